@@ -12,7 +12,7 @@ import (
 	"github.com/magefile/mage/sh"
 )
 
-//go:embed *.proto testproto
+//go:embed *.proto testproto reflection nrpcpb
 var files embed.FS
 
 // Gen (re-)generates auto-generated code.
@@ -52,5 +52,14 @@ func Gen() error {
 		return err
 	}
 
-	return nil
+	// the nrpc stubs are only generated for the test services
+	if err := sh.Run("go", "install", "./cmd/protoc-gen-nrpc"); err != nil {
+		return err
+	}
+	return sh.Run("protoc", "--nrpc_out=.", "--nrpc_opt=paths=source_relative", "testproto/test.proto")
+}
+
+// Bench runs the benchmarks of the hot path reporting the allocations per call.
+func Bench() error {
+	return sh.RunV("go", "test", "-run", "^$", "-bench", ".", "-benchmem", ".")
 }
@@ -0,0 +1,111 @@
+package nrpc
+
+import (
+	"context"
+
+	"github.com/tehsphinx/nrpc/pubsub"
+	"google.golang.org/grpc"
+)
+
+// Future is the pending result of an asynchronous call (see Client.InvokeAsync).
+type Future struct {
+	done chan struct{}
+	err  error
+}
+
+func newFuture() *Future {
+	return &Future{done: make(chan struct{})}
+}
+
+func (f *Future) complete(err error) {
+	f.err = err
+	close(f.done)
+}
+
+// Done returns a channel that is closed once the call is done.
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Result waits for the call to be done and returns its error. The reply passed to InvokeAsync holds the
+// response once Result returned nil.
+func (f *Future) Result() error {
+	<-f.done
+	return f.err
+}
+
+// InvokeAsync performs a unary RPC like Invoke without waiting for the response, so many calls can be
+// sent before waiting for their results. The returned future is done once the response was received
+// into reply or the call failed. The reply must not be accessed before.
+//
+// On publishers implementing pubsub.AsyncRequester, like the NATS publisher, pending calls wait for their
+// responses without a goroutine each and the responses are decoded on the goroutine delivering them,
+// except for paged responses (see WithResponsePaging), whose pages are requested by a goroutine.
+// Calls passing the unary interceptor of the client, retried, hedged or sent one-way calls are run by a
+// goroutine calling Invoke, as are all calls on other publishers.
+func (s *Client) InvokeAsync(ctx context.Context, method string, args, reply interface{}, opts ...grpc.CallOption) *Future {
+	future := newFuture()
+	requester, ok := s.pub.(pubsub.AsyncRequester)
+	if !ok || s.unaryInt != nil {
+		go func() {
+			future.complete(s.Invoke(ctx, method, args, reply, opts...))
+		}()
+		return future
+	}
+
+	ctx = s.propagate.apply(ctx)
+	callOpts, err := getCallOptions(s.callDefaults(method), method, opts)
+	if err != nil {
+		future.complete(err)
+		return future
+	}
+	if callOpts.retry.MaxAttempts > 1 || callOpts.hedging.enabled() || callOpts.oneWay {
+		go func() {
+			future.complete(s.invoke(ctx, method, args, reply, nil, opts...))
+		}()
+		return future
+	}
+
+	s.invokeAsync(ctx, requester, method, args, reply, callOpts, opts, future)
+	return future
+}
+
+// invokeAsync sends the unary call with the requester and completes the future with its result.
+func (s *Client) invokeAsync(ctx context.Context, requester pubsub.AsyncRequester, method string, args, reply interface{},
+	callOpts callOptions, opts []grpc.CallOption, future *Future) {
+	ctx, cancel := withCallTimeout(ctx, callOpts.timeout)
+	ctx, callOpts.stats = beginClientStats(ctx, s.statsHandler, method, nil)
+	complete := func(err error) {
+		cancel()
+		callOpts.stats.end(err)
+		future.complete(err)
+	}
+
+	ctx, err := s.prepareCall(ctx, method, &callOpts)
+	if err != nil {
+		complete(err)
+		return
+	}
+	subj := callOpts.subjects.MapSubject(callSubj(method, s.pickInstance(ctx, method, callOpts)))
+	req, err := s.newRequest(ctx, method, subj, args, callOpts)
+	if err != nil {
+		complete(toRPCErr(err))
+		return
+	}
+
+	handle := func(res pubsub.Message, err error) {
+		resp, err := s.readFirstPage(ctx, req, res, err)
+		if err == nil && resp.PageSubject != "" {
+			// requesting the pages blocks, which must not hold up the responses to the other calls
+			go func() {
+				resp, err := s.readPages(ctx, req, resp)
+				complete(finishCall(callOpts, opts, subj, resp, toRPCErr(err), reply))
+			}()
+			return
+		}
+		complete(finishCall(callOpts, opts, subj, resp, toRPCErr(err), reply))
+	}
+	if r := requester.RequestAsync(ctx, req.msg, handle); r != nil {
+		handle(pubsub.Message{}, r)
+	}
+}
@@ -0,0 +1,93 @@
+package nrpc
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Authenticator authenticates the calls and streams of a server before its handlers and interceptors
+// are invoked (see WithAuthenticator). The auth package implements authenticators for the credentials
+// of its providers.
+type Authenticator interface {
+	// Authenticate validates the credentials of the call to the full method (/service/method). They are
+	// found in the incoming metadata of the context. The returned context is passed to the handler, so it
+	// can carry the authenticated identity. Errors without a status are returned as codes.Unauthenticated.
+	Authenticate(ctx context.Context, method string) (context.Context, error)
+}
+
+// AuthenticatorFunc adapts a function to the Authenticator interface.
+type AuthenticatorFunc func(ctx context.Context, method string) (context.Context, error)
+
+// Authenticate implements the Authenticator interface.
+func (f AuthenticatorFunc) Authenticate(ctx context.Context, method string) (context.Context, error) {
+	return f(ctx, method)
+}
+
+// Authorizer decides whether an authenticated call may invoke the method before the interceptors and
+// handlers of the server are invoked (see WithAuthorizer). The auth package implements a policy engine.
+type Authorizer interface {
+	// Authorize returns an error if the call to the full method (/service/method) is not allowed.
+	// The context is the one returned by the authenticator, the metadata is the incoming metadata.
+	// Errors without a status are returned as codes.PermissionDenied.
+	Authorize(ctx context.Context, method string, md metadata.MD) error
+}
+
+// AuthorizerFunc adapts a function to the Authorizer interface.
+type AuthorizerFunc func(ctx context.Context, method string, md metadata.MD) error
+
+// Authorize implements the Authorizer interface.
+func (f AuthorizerFunc) Authorize(ctx context.Context, method string, md metadata.MD) error {
+	return f(ctx, method, md)
+}
+
+// authorize authenticates and authorizes the call. It returns the context passed on to the handler.
+func authorize(ctx context.Context, auth Authenticator, authz Authorizer, method string) (context.Context, error) {
+	if auth != nil {
+		authCtx, err := auth.Authenticate(ctx, method)
+		if err != nil {
+			return nil, withCode(err, codes.Unauthenticated)
+		}
+		ctx = authCtx
+	}
+	if authz != nil {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if r := authz.Authorize(ctx, method, md); r != nil {
+			return nil, withCode(r, codes.PermissionDenied)
+		}
+	}
+	return ctx, nil
+}
+
+// withCode returns errors without a status as status errors with the code.
+func withCode(err error, code codes.Code) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	return status.Error(code, err.Error())
+}
+
+// streamAuthInterceptor authenticates and authorizes streams before they are passed on.
+func streamAuthInterceptor(auth Authenticator, authz Authorizer) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authorize(stream.Context(), auth, authz, info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, authenticatedStream{ServerStream: stream, ctx: ctx})
+	}
+}
+
+// authenticatedStream passes the context returned by the authenticator to the handler.
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context returns the context of the authenticated stream.
+func (s authenticatedStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,72 @@
+// Package auth implements credential providers attaching credentials to every call of an nrpc
+// client and the matching authenticators validating them on the server:
+//
+//	client := nrpc.NewClient(pub, sub, nrpc.WithPerRPCCredentials(auth.Token(jwt)))
+//	server := nrpc.NewServer(pub, sub, nrpc.WithAuthenticator(auth.BearerAuthenticator(validate)))
+//
+// The credentials are sent as metadata of the call. Other grpc credentials, e.g. the OAuth2
+// credentials of google.golang.org/grpc/credentials/oauth, can be used with nrpc as well.
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/tehsphinx/nrpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	authorizationKey = "authorization"
+	bearerPrefix     = "Bearer "
+)
+
+// ErrMissingCredentials is returned by the authenticators if the call carries no credentials.
+var ErrMissingCredentials = errors.New("auth: missing credentials")
+
+// Token returns credentials attaching the token, e.g. a JWT, as bearer token to every call.
+func Token(token string) credentials.PerRPCCredentials {
+	return TokenSource(func(context.Context) (string, error) {
+		return token, nil
+	})
+}
+
+// TokenSource returns credentials attaching the token returned by the source as bearer token to
+// every call. The source is called per call, so it can refresh tokens, e.g. OAuth2 access tokens.
+func TokenSource(source func(ctx context.Context) (string, error)) credentials.PerRPCCredentials {
+	return tokenSource(source)
+}
+
+type tokenSource func(ctx context.Context) (string, error)
+
+// GetRequestMetadata implements the credentials.PerRPCCredentials interface.
+func (s tokenSource) GetRequestMetadata(ctx context.Context, _ ...string) (map[string]string, error) {
+	token, err := s(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{authorizationKey: bearerPrefix + token}, nil
+}
+
+// RequireTransportSecurity implements the credentials.PerRPCCredentials interface. Securing
+// the transport is up to the connection to the broker.
+func (s tokenSource) RequireTransportSecurity() bool {
+	return false
+}
+
+// BearerAuthenticator returns an authenticator passing the bearer token of a call to validate, e.g. to
+// verify a JWT and add its claims to the context (see NewClaimsContext). The context returned by validate
+// is passed to the handler. Calls without bearer token fail with ErrMissingCredentials.
+func BearerAuthenticator(validate func(ctx context.Context, token string) (context.Context, error)) nrpc.Authenticator {
+	return nrpc.AuthenticatorFunc(func(ctx context.Context, _ string) (context.Context, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		for _, value := range md.Get(authorizationKey) {
+			if strings.HasPrefix(value, bearerPrefix) {
+				return validate(ctx, strings.TrimPrefix(value, bearerPrefix))
+			}
+		}
+		return nil, ErrMissingCredentials
+	})
+}
@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nkeys"
+	"github.com/tehsphinx/nrpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	nkeyKey      = "nrpc-nkey"
+	nonceKey     = "nrpc-nonce"
+	signatureKey = "nrpc-nkey-sig"
+
+	nonceLen = 16
+	// nkeyClaim is the claim holding the public NKey of callers authenticated by the NKeyAuthenticator.
+	nkeyClaim = "nkey"
+)
+
+// NKey returns credentials proving the possession of the NKey of the seed, e.g. the user NKey the client
+// connects to NATS with, without sending a secret. A fresh nonce bound to the service is signed for every
+// call (see NKeyAuthenticator).
+func NKey(seed []byte) (credentials.PerRPCCredentials, error) {
+	kp, err := nkeys.FromSeed(seed)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := kp.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	return &nkeyCreds{kp: kp, pub: pub}, nil
+}
+
+type nkeyCreds struct {
+	kp  nkeys.KeyPair
+	pub string
+}
+
+// GetRequestMetadata implements the credentials.PerRPCCredentials interface.
+func (c *nkeyCreds) GetRequestMetadata(_ context.Context, uri ...string) (map[string]string, error) {
+	random := make([]byte, nonceLen)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+	nonce := strconv.FormatInt(time.Now().UnixNano(), 10) + "." + base64.RawURLEncoding.EncodeToString(random)
+
+	var target string
+	if len(uri) != 0 {
+		target = uri[0]
+	}
+	sig, err := c.kp.Sign(signedInput(nonce, target))
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		nkeyKey:      c.pub,
+		nonceKey:     nonce,
+		signatureKey: base64.RawURLEncoding.EncodeToString(sig),
+	}, nil
+}
+
+// RequireTransportSecurity implements the credentials.PerRPCCredentials interface.
+func (c *nkeyCreds) RequireTransportSecurity() bool {
+	return false
+}
+
+// signedInput binds the nonce to the service called, so a signature cannot be used for other services.
+func signedInput(nonce, uri string) []byte {
+	return []byte(nonce + " " + uri)
+}
+
+type nkeyKeyCtx struct{}
+
+// NKeyFromContext returns the public NKey of the caller authenticated by the NKeyAuthenticator.
+func NKeyFromContext(ctx context.Context) (string, bool) {
+	pub, ok := ctx.Value(nkeyKeyCtx{}).(string)
+	return pub, ok
+}
+
+// NKeyAuthenticator returns an authenticator verifying the signatures of NKey credentials. Only the given
+// public keys are accepted. Nonces deviating more than maxAge from the time of the server or used before
+// are rejected to prevent replays. The public key of the caller is passed to the handler with the context
+// (see NKeyFromContext) and as claim "nkey" (see ClaimsFromContext).
+func NKeyAuthenticator(maxAge time.Duration, trusted ...string) nrpc.Authenticator {
+	a := &nkeyAuthenticator{
+		maxAge:  maxAge,
+		trusted: make(map[string]nkeys.KeyPair, len(trusted)),
+		seen:    map[string]time.Time{},
+	}
+	for _, pub := range trusted {
+		if kp, err := nkeys.FromPublicKey(pub); err == nil {
+			a.trusted[pub] = kp
+		}
+	}
+	return a
+}
+
+type nkeyAuthenticator struct {
+	maxAge  time.Duration
+	trusted map[string]nkeys.KeyPair
+
+	m         sync.Mutex
+	seen      map[string]time.Time
+	lastPurge time.Time
+}
+
+// Authenticate implements the nrpc.Authenticator interface.
+func (a *nkeyAuthenticator) Authenticate(ctx context.Context, method string) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	pub, nonce, encodedSig := first(md, nkeyKey), first(md, nonceKey), first(md, signatureKey)
+	if pub == "" || nonce == "" || encodedSig == "" {
+		return nil, ErrMissingCredentials
+	}
+
+	kp, ok := a.trusted[pub]
+	if !ok {
+		return nil, fmt.Errorf("auth: untrusted nkey %s", pub)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(encodedSig)
+	if err != nil {
+		return nil, fmt.Errorf("auth: invalid signature: %w", err)
+	}
+	if r := kp.Verify(signedInput(nonce, "nrpc://"+serviceName(method)), sig); r != nil {
+		return nil, fmt.Errorf("auth: invalid signature: %w", r)
+	}
+	if r := a.checkNonce(pub, nonce, time.Now()); r != nil {
+		return nil, r
+	}
+	ctx = NewClaimsContext(ctx, Claims{nkeyClaim: {pub}})
+	return context.WithValue(ctx, nkeyKeyCtx{}, pub), nil
+}
+
+// checkNonce rejects nonces outside the time window and the ones used before.
+func (a *nkeyAuthenticator) checkNonce(pub, nonce string, now time.Time) error {
+	i := strings.Index(nonce, ".")
+	if i < 0 {
+		return errors.New("auth: invalid nonce")
+	}
+	nanos, err := strconv.ParseInt(nonce[:i], 10, 64)
+	if err != nil {
+		return errors.New("auth: invalid nonce")
+	}
+	issued := time.Unix(0, nanos)
+	if issued.Before(now.Add(-a.maxAge)) || issued.After(now.Add(a.maxAge)) {
+		return errors.New("auth: expired nonce")
+	}
+
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	if now.Sub(a.lastPurge) > a.maxAge {
+		// nonces outside the time window are rejected anyway
+		for key, t := range a.seen {
+			if t.Before(now.Add(-a.maxAge)) {
+				delete(a.seen, key)
+			}
+		}
+		a.lastPurge = now
+	}
+
+	key := pub + " " + nonce
+	if _, ok := a.seen[key]; ok {
+		return errors.New("auth: nonce used before")
+	}
+	a.seen[key] = issued
+	return nil
+}
+
+func first(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) != 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// serviceName extracts the service name from a full method name (/service/method).
+func serviceName(method string) string {
+	method = strings.TrimPrefix(method, "/")
+	if i := strings.Index(method, "/"); i >= 0 {
+		return method[:i]
+	}
+	return method
+}
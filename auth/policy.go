@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	"github.com/tehsphinx/nrpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Claims are the claims of an authenticated caller, e.g. the claims of a JWT, mapping the claim
+// names to their values.
+type Claims map[string][]string
+
+// Has reports whether the claim has the value.
+func (c Claims) Has(name, value string) bool {
+	for _, v := range c[name] {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+type claimsKey struct{}
+
+// NewClaimsContext returns a context carrying the claims of the caller. Authenticators add the claims
+// of the authenticated caller to the context, so policies can check them. The "sub" claim, or the
+// "nkey" claim if there is none, is set as user of the peer of the call (see nrpc.PeerFromContext).
+func NewClaimsContext(ctx context.Context, claims Claims) context.Context {
+	if p, ok := nrpc.PeerFromContext(ctx); ok {
+		if user := claimedUser(claims); user != "" {
+			peer := *p
+			peer.User = user
+			ctx = nrpc.NewContextWithPeer(ctx, &peer)
+		}
+	}
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// claimedUser returns the identity of the caller the claims are about.
+func claimedUser(claims Claims) string {
+	for _, claim := range []string{"sub", nkeyClaim} {
+		if values := claims[claim]; len(values) != 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+// ClaimsFromContext returns the claims of the caller carried by the context.
+func ClaimsFromContext(ctx context.Context) (Claims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(Claims)
+	return claims, ok
+}
+
+// Rule allows or denies the calls of the methods matching its pattern.
+type Rule struct {
+	// Method is the pattern of the full methods (/service/method) the rule applies to, following the
+	// syntax of path.Match, e.g. "/pkg.Service/Method", "/pkg.Service/*" or "*" for all methods.
+	Method string
+	// Claims are the claims the caller must have for the rule to apply, e.g. {"role": "admin"}.
+	Claims map[string]string
+	// Deny makes the rule deny the calls instead of allowing them.
+	Deny bool
+}
+
+// applies reports whether the rule applies to the call of the method by the caller with the claims.
+func (r Rule) applies(method string, claims Claims) bool {
+	if ok, _ := path.Match(r.Method, method); !ok && r.Method != "*" {
+		return false
+	}
+	for name, value := range r.Claims {
+		if !claims.Has(name, value) {
+			return false
+		}
+	}
+	return true
+}
+
+// Policy is an authorizer enforcing its rules centrally (see nrpc.WithAuthorizer). The first rule
+// applying to a call decides whether it is allowed; calls no rule applies to are denied.
+//
+//	policy := auth.Policy{Rules: []auth.Rule{
+//		{Method: "/grpc.health.v1.Health/*"},
+//		{Method: "/pkg.Admin/*", Claims: map[string]string{"role": "admin"}},
+//		{Method: "/pkg.Admin/*", Deny: true},
+//		{Method: "*", Claims: map[string]string{"role": "user"}},
+//	}}
+type Policy struct {
+	Rules []Rule
+}
+
+var _ nrpc.Authorizer = Policy{}
+
+// Authorize implements the nrpc.Authorizer interface.
+func (p Policy) Authorize(ctx context.Context, method string, _ metadata.MD) error {
+	claims, _ := ClaimsFromContext(ctx)
+	for _, rule := range p.Rules {
+		if !rule.applies(method, claims) {
+			continue
+		}
+		if rule.Deny {
+			break
+		}
+		return nil
+	}
+	return fmt.Errorf("auth: access to %s denied", method)
+}
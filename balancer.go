@@ -0,0 +1,128 @@
+package nrpc
+
+import (
+	"context"
+	"hash/fnv"
+	"sync/atomic"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// BalancingPolicy picks the server instance a call is sent to among the live instances discovered by
+// the registry of the client (see WithRegistry and WithBalancingPolicy). Without a balancing policy,
+// calls are sent to the queue group of the service and the pub-sub broker picks the instance.
+type BalancingPolicy interface {
+	// Pick returns the instance the call of the full method (/service/method) is sent to.
+	// The instances serve the service of the method, there is at least one.
+	Pick(ctx context.Context, method string, instances []Instance) Instance
+}
+
+// RoundRobin returns a balancing policy sending the calls to the instances in turn.
+func RoundRobin() BalancingPolicy {
+	return &roundRobin{}
+}
+
+type roundRobin struct {
+	next uint64
+}
+
+func (p *roundRobin) Pick(_ context.Context, _ string, instances []Instance) Instance {
+	n := atomic.AddUint64(&p.next, 1) - 1
+	return instances[n%uint64(len(instances))]
+}
+
+// LeastLoaded returns a balancing policy sending the calls to the instance with the fewest calls and
+// streams in progress at the time of its last announcement. Instances with the same load take turns.
+func LeastLoaded() BalancingPolicy {
+	return &leastLoaded{}
+}
+
+type leastLoaded struct {
+	next uint64
+}
+
+func (p *leastLoaded) Pick(_ context.Context, _ string, instances []Instance) Instance {
+	var least []Instance
+	for _, inst := range instances {
+		switch {
+		case len(least) == 0 || inst.Load < least[0].Load:
+			least = append(least[:0], inst)
+		case inst.Load == least[0].Load:
+			least = append(least, inst)
+		}
+	}
+	n := atomic.AddUint64(&p.next, 1) - 1
+	return least[n%uint64(len(least))]
+}
+
+// ConsistentHash returns a balancing policy sending all calls with the same request key to the same
+// instance as long as it is alive. The key is the value of the metadata with the given name sent with
+// the call. If instances come or go, only the keys of those instances move. Calls without the key are
+// sent to the instances in turn.
+func ConsistentHash(key string) BalancingPolicy {
+	return &consistentHash{key: key}
+}
+
+type consistentHash struct {
+	key      string
+	fallback roundRobin
+}
+
+func (p *consistentHash) Pick(ctx context.Context, method string, instances []Instance) Instance {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	values := md.Get(p.key)
+	if len(values) == 0 {
+		return p.fallback.Pick(ctx, method, instances)
+	}
+	return pickByHash(values[0], instances)
+}
+
+// pickByHash picks the instance for the key by rendezvous hashing: the instance with the highest
+// hash of the key combined with its ID wins.
+func pickByHash(key string, instances []Instance) Instance {
+	var picked Instance
+	var best uint64
+	for i, inst := range instances {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(key))
+		_, _ = h.Write([]byte{0})
+		_, _ = h.Write([]byte(inst.ID))
+		if sum := h.Sum64(); i == 0 || sum > best {
+			picked, best = inst, sum
+		}
+	}
+	return picked
+}
+
+// balancingPolicies maps full methods, service names or the empty default key to balancing policies.
+type balancingPolicies map[string]BalancingPolicy
+
+// get returns the balancing policy of the full method (/service/method).
+func (p balancingPolicies) get(method string) BalancingPolicy {
+	for _, key := range policyKeys(method) {
+		if policy, ok := p[key]; ok {
+			return policy
+		}
+	}
+	return nil
+}
+
+// pickInstance returns the ID of the instance the call is sent to: the instance given with the
+// ToInstance call option, the one the affinity key hashes to or the one picked by the balancing
+// policy. It returns an empty ID if the call is sent to the queue group of the service.
+func (s *Client) pickInstance(ctx context.Context, method string, callOpts callOptions) string {
+	if callOpts.instance != "" {
+		return callOpts.instance
+	}
+	if (callOpts.affinity == "" && callOpts.balancing == nil) || s.registry == nil {
+		return ""
+	}
+	instances := s.registry.Instances(serviceName(method))
+	if len(instances) == 0 {
+		return ""
+	}
+	if callOpts.affinity != "" {
+		return pickByHash(callOpts.affinity, instances).ID
+	}
+	return callOpts.balancing.Pick(ctx, method, instances).ID
+}
@@ -0,0 +1,82 @@
+package nrpc
+
+import (
+	"sync"
+	"time"
+)
+
+// batchVersion is the version of the envelope introducing batched stream frames.
+const batchVersion uint32 = 2
+
+// batcher collects the data frames of a stream and publishes them together once the batch is full
+// or the first frame of the batch waited for the maximum delay (see WithStreamBatching).
+type batcher struct {
+	size  int
+	delay time.Duration
+	// publish publishes the frames of a batch in a single frame.
+	publish func(frames [][]byte) error
+	// failed is called with the error of a batch published after the delay passed.
+	failed func(err error)
+
+	m      sync.Mutex
+	frames [][]byte
+	timer  *time.Timer
+}
+
+func newBatcher(size int, delay time.Duration, publish func(frames [][]byte) error, failed func(err error)) *batcher {
+	return &batcher{
+		size:    size,
+		delay:   delay,
+		publish: publish,
+		failed:  failed,
+	}
+}
+
+// enabled reports whether frames are batched on a stream negotiated to the version of the envelope.
+func (b *batcher) enabled(version uint32) bool {
+	return b.size > 1 && b.delay > 0 && version >= batchVersion
+}
+
+// add adds the frame to the batch. The batch is published once it is full.
+func (b *batcher) add(frame []byte) error {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	b.frames = append(b.frames, frame)
+	if len(b.frames) >= b.size {
+		return b.flushLocked()
+	}
+	if len(b.frames) == 1 {
+		b.timer = time.AfterFunc(b.delay, b.expire)
+	}
+	return nil
+}
+
+// expire publishes the batch once the delay passed.
+func (b *batcher) expire() {
+	if err := b.flush(); err != nil {
+		b.failed(err)
+	}
+}
+
+// flush publishes the frames collected so far, e.g. before a frame that must not overtake them.
+func (b *batcher) flush() error {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	return b.flushLocked()
+}
+
+func (b *batcher) flushLocked() error {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if len(b.frames) == 0 {
+		return nil
+	}
+
+	frames := b.frames
+	b.frames = nil
+	return b.publish(frames)
+}
@@ -0,0 +1,186 @@
+// Package blob transfers blobs of bytes, e.g. files, over the streams of nrpc clients and servers. The
+// blob is split into chunks of the message type nrpcpb.BlobChunk carrying a checksum each:
+//
+//	// client side of rpc Upload (stream nrpc.BlobChunk) returns (UploadResp)
+//	stream, err := client.Upload(ctx)
+//	if err != nil {
+//		return err
+//	}
+//	if _, err := blob.SendReader(ctx, stream, file); err != nil {
+//		return err
+//	}
+//	resp, err := stream.CloseAndRecv()
+//
+//	// server side
+//	func (s *server) Upload(stream pb.Files_UploadServer) error {
+//		size, err := blob.ReceiveWriter(stream.Context(), stream, file)
+//		...
+//	}
+//
+// Both functions return the offset the transfer reached. An interrupted transfer is resumed on a new
+// stream by passing the offset the receiver reached to both sides with WithOffset. Clients resuming a
+// download send it with the metadata or the request of the stream, clients resuming an upload query it
+// from the server first, e.g. with a unary call.
+package blob
+
+import (
+	"context"
+	"errors"
+	"hash/crc32"
+	"io"
+
+	"github.com/tehsphinx/nrpc"
+	"github.com/tehsphinx/nrpc/nrpcpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultChunkSize is the number of bytes of a blob sent per message if WithChunkSize is not set.
+const DefaultChunkSize = 32 * 1024
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Sender is a stream sending messages, e.g. a grpc.ClientStream or a grpc.ServerStream.
+type Sender interface {
+	SendMsg(m interface{}) error
+}
+
+// Receiver is a stream receiving messages, e.g. a grpc.ClientStream or a grpc.ServerStream.
+type Receiver interface {
+	RecvMsg(m interface{}) error
+}
+
+// Option configures the sending or receiving of a blob.
+type Option func(*options)
+
+type options struct {
+	chunkSize int
+	offset    int64
+	progress  func(offset int64)
+}
+
+func newOptions(opts []Option) options {
+	opt := options{chunkSize: DefaultChunkSize}
+	for _, o := range opts {
+		o(&opt)
+	}
+	if opt.chunkSize <= 0 {
+		opt.chunkSize = DefaultChunkSize
+	}
+	return opt
+}
+
+// WithChunkSize sets the number of bytes of the blob sent per message. The default is DefaultChunkSize.
+// It should stay below the maximum payload size of the broker and is ignored by ReceiveWriter.
+func WithChunkSize(size int) Option {
+	return func(opt *options) {
+		opt.chunkSize = size
+	}
+}
+
+// WithOffset resumes a transfer at the offset, e.g. the offset returned by ReceiveWriter for an
+// interrupted transfer. SendReader skips the bytes of the reader preceding the offset, seeking if the
+// reader implements io.Seeker. ReceiveWriter expects the first chunk at the offset, so the writer should
+// append to the bytes already received.
+func WithOffset(offset int64) Option {
+	return func(opt *options) {
+		opt.offset = offset
+	}
+}
+
+// WithProgress sets a function called with the offset reached after every chunk sent or received.
+func WithProgress(fn func(offset int64)) Option {
+	return func(opt *options) {
+		opt.progress = fn
+	}
+}
+
+// SendReader sends the bytes of the reader on the stream until the reader returns io.EOF, followed by a
+// chunk marking the end of the blob. The sends are bounded by the context (see nrpc.SendMsgContext).
+// It returns the offset reached: the size of the blob once it was sent completely.
+func SendReader(ctx context.Context, stream Sender, r io.Reader, opts ...Option) (int64, error) {
+	opt := newOptions(opts)
+
+	offset, err := skip(r, opt.offset)
+	if err != nil {
+		return offset, err
+	}
+
+	buf := make([]byte, opt.chunkSize)
+	for {
+		n, rErr := io.ReadFull(r, buf)
+		if n > 0 {
+			data := buf[:n]
+			chunk := &nrpcpb.BlobChunk{Offset: offset, Data: data, Checksum: crc32.Checksum(data, castagnoli)}
+			if r := nrpc.SendMsgContext(ctx, stream, chunk); r != nil {
+				return offset, r
+			}
+			offset += int64(n)
+			if opt.progress != nil {
+				opt.progress(offset)
+			}
+		}
+		if errors.Is(rErr, io.EOF) || errors.Is(rErr, io.ErrUnexpectedEOF) {
+			break
+		}
+		if rErr != nil {
+			return offset, rErr
+		}
+	}
+	return offset, nrpc.SendMsgContext(ctx, stream, &nrpcpb.BlobChunk{Offset: offset, Eof: true})
+}
+
+// skip skips the bytes of the reader preceding the offset.
+func skip(r io.Reader, offset int64) (int64, error) {
+	if offset <= 0 {
+		return 0, nil
+	}
+	if seeker, ok := r.(io.Seeker); ok {
+		return seeker.Seek(offset, io.SeekStart)
+	}
+	n, err := io.CopyN(io.Discard, r, offset)
+	if errors.Is(err, io.EOF) {
+		return n, status.Errorf(codes.OutOfRange, "blob: offset %d exceeds the size %d of the blob", offset, n)
+	}
+	return n, err
+}
+
+// ReceiveWriter receives the chunks of a blob sent with SendReader on the stream and writes them to the
+// writer until the end of the blob. Chunks with a wrong checksum or offset fail the transfer with
+// codes.DataLoss, as does the end of the stream before the end of the blob. It returns the offset reached:
+// the size of the blob once it was received completely, or the offset to resume an interrupted transfer at.
+func ReceiveWriter(ctx context.Context, stream Receiver, w io.Writer, opts ...Option) (int64, error) {
+	opt := newOptions(opts)
+
+	offset := opt.offset
+	for {
+		if r := ctx.Err(); r != nil {
+			return offset, status.FromContextError(r).Err()
+		}
+
+		chunk := &nrpcpb.BlobChunk{}
+		if err := stream.RecvMsg(chunk); err != nil {
+			if errors.Is(err, io.EOF) {
+				return offset, status.Errorf(codes.DataLoss, "blob: stream ended at offset %d before the end of the blob", offset)
+			}
+			return offset, err
+		}
+		if chunk.Offset != offset {
+			return offset, status.Errorf(codes.DataLoss, "blob: received chunk at offset %d, expected offset %d", chunk.Offset, offset)
+		}
+		if chunk.Eof {
+			return offset, nil
+		}
+		if crc32.Checksum(chunk.Data, castagnoli) != chunk.Checksum {
+			return offset, status.Errorf(codes.DataLoss, "blob: checksum mismatch of the chunk at offset %d", offset)
+		}
+
+		if _, err := w.Write(chunk.Data); err != nil {
+			return offset, err
+		}
+		offset += int64(len(chunk.Data))
+		if opt.progress != nil {
+			opt.progress(offset)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package nrpc
+
+import (
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// CircuitState is the state of a circuit breaker.
+type CircuitState int
+
+// The states of a circuit breaker.
+const (
+	// CircuitClosed lets all calls through.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen fails all calls fast.
+	CircuitOpen
+	// CircuitHalfOpen lets a limited number of probing calls through.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// CircuitBreakerPolicy configures the circuit breakers of the client. Every subject calls are sent to,
+// i.e. every method and instance, has its own breaker. After FailureThreshold consecutive failures the
+// breaker opens and calls fail fast with codes.Unavailable. Once the cool-down passed, the breaker is
+// half-open and lets probing calls through: it closes if they succeed and opens again if one fails.
+// Streams are subject to the breaker while being established.
+type CircuitBreakerPolicy struct {
+	// FailureThreshold is the number of consecutive failures opening the breaker.
+	// A value of 0 disables the breaker.
+	FailureThreshold int
+	// CoolDown is the time the breaker stays open before it lets probing calls through.
+	CoolDown time.Duration
+	// HalfOpenProbes is the number of probing calls that must succeed to close the breaker.
+	// It defaults to 1.
+	HalfOpenProbes int
+	// FailureCodes are the status codes counting as failure.
+	// They default to codes.Unavailable and codes.DeadlineExceeded if left empty.
+	FailureCodes []codes.Code
+	// OnStateChange is called when the breaker of a subject changes its state.
+	OnStateChange func(subject string, from, to CircuitState)
+}
+
+func (p CircuitBreakerPolicy) enabled() bool {
+	return p.FailureThreshold > 0
+}
+
+func (p CircuitBreakerPolicy) failure(err error) bool {
+	if err == nil {
+		return false
+	}
+	code := status.Code(err)
+	if len(p.FailureCodes) == 0 {
+		return code == codes.Unavailable || code == codes.DeadlineExceeded
+	}
+	for _, c := range p.FailureCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+func (p CircuitBreakerPolicy) probes() int {
+	if p.HalfOpenProbes < 1 {
+		return 1
+	}
+	return p.HalfOpenProbes
+}
+
+// circuitBreakerPolicies holds the circuit breaker policies configured for methods, services and the default.
+type circuitBreakerPolicies map[string]CircuitBreakerPolicy
+
+// get returns the circuit breaker policy of the full method (/service/method).
+func (p circuitBreakerPolicies) get(method string) CircuitBreakerPolicy {
+	for _, key := range policyKeys(method) {
+		if policy, ok := p[key]; ok {
+			return policy
+		}
+	}
+	return CircuitBreakerPolicy{}
+}
+
+// circuitBreakers holds the circuit breakers of the subjects.
+type circuitBreakers struct {
+	m        sync.Mutex
+	breakers map[string]*circuitBreaker
+}
+
+func newCircuitBreakers() *circuitBreakers {
+	return &circuitBreakers{breakers: map[string]*circuitBreaker{}}
+}
+
+func (b *circuitBreakers) get(subject string, policy CircuitBreakerPolicy) *circuitBreaker {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	breaker, ok := b.breakers[subject]
+	if !ok {
+		breaker = &circuitBreaker{subject: subject, policy: policy}
+		b.breakers[subject] = breaker
+	}
+	return breaker
+}
+
+// circuit is the circuit breaker configuration of a call.
+type circuit struct {
+	policy   CircuitBreakerPolicy
+	breakers *circuitBreakers
+}
+
+// acquire asks the breaker of the subject to let a call through. The returned function must be called
+// with the result of the call.
+func (c circuit) acquire(subject string) (func(err error), error) {
+	if !c.policy.enabled() || c.breakers == nil {
+		return func(error) {}, nil
+	}
+	breaker := c.breakers.get(subject, c.policy)
+	if r := breaker.acquire(time.Now()); r != nil {
+		return nil, r
+	}
+	return breaker.record, nil
+}
+
+// circuitBreaker is the circuit breaker of a subject.
+type circuitBreaker struct {
+	subject string
+	policy  CircuitBreakerPolicy
+
+	m        sync.Mutex
+	state    CircuitState
+	failures int
+	openedAt time.Time
+	// probes and successes count the probing calls let through and succeeded while half-open.
+	probes    int
+	successes int
+}
+
+func (b *circuitBreaker) acquire(now time.Time) error {
+	b.m.Lock()
+	from := b.state
+	if b.state == CircuitOpen && now.Sub(b.openedAt) >= b.policy.CoolDown {
+		b.state, b.probes, b.successes = CircuitHalfOpen, 0, 0
+	}
+	var err error
+	switch {
+	case b.state == CircuitOpen:
+		err = status.Errorf(codes.Unavailable, "nrpc: circuit breaker of %s is open", b.subject)
+	case b.state == CircuitHalfOpen && b.probes >= b.policy.probes():
+		err = status.Errorf(codes.Unavailable, "nrpc: circuit breaker of %s is half-open and probing", b.subject)
+	case b.state == CircuitHalfOpen:
+		b.probes++
+	}
+	to := b.state
+	b.m.Unlock()
+
+	b.notify(from, to)
+	return err
+}
+
+func (b *circuitBreaker) record(err error) {
+	failure := b.policy.failure(err)
+
+	b.m.Lock()
+	from := b.state
+	switch {
+	case b.state == CircuitHalfOpen && failure:
+		b.state, b.openedAt = CircuitOpen, time.Now()
+	case b.state == CircuitHalfOpen:
+		if b.successes++; b.successes >= b.policy.probes() {
+			b.state, b.failures = CircuitClosed, 0
+		}
+	case b.state == CircuitClosed && failure:
+		if b.failures++; b.failures >= b.policy.FailureThreshold {
+			b.state, b.openedAt = CircuitOpen, time.Now()
+		}
+	case b.state == CircuitClosed:
+		b.failures = 0
+	}
+	to := b.state
+	b.m.Unlock()
+
+	b.notify(from, to)
+}
+
+func (b *circuitBreaker) notify(from, to CircuitState) {
+	if from != to && b.policy.OnStateChange != nil {
+		b.policy.OnStateChange(b.subject, from, to)
+	}
+}
@@ -0,0 +1,53 @@
+// Package bridge connects nrpc with plain gRPC in both directions, so services can move behind a pub-sub
+// broker one at a time.
+//
+// NewServer creates a grpc server forwarding the calls of all methods to nrpc, so clients of a grpc
+// service keep working unchanged when the service moves behind the broker:
+//
+//	client := nrpc.NewClient(nats.Publisher(conn), nats.Subscriber(conn))
+//	server := bridge.NewServer(dynamic.NewClient(client, dynamic.NewReflectionSource(client)))
+//
+//	lis, err := net.Listen("tcp", ":9090")
+//	if err != nil {
+//		return err
+//	}
+//	err = server.Serve(lis)
+//
+// RegisterUpstream serves services of a grpc endpoint on the broker, so services already using nrpc
+// can call services that remain on grpc:
+//
+//	upstream, err := grpc.Dial("legacy:9090", grpc.WithTransportCredentials(creds))
+//	if err != nil {
+//		return err
+//	}
+//	server := nrpc.NewServer(nats.Publisher(conn), nats.Subscriber(conn))
+//	err = bridge.RegisterUpstream(ctx, server, upstream, dynamic.NewFilesSource(protoregistry.GlobalFiles), "pkg.Legacy")
+//
+// Unary calls and streams of all kinds are forwarded with their metadata, deadline and cancellation
+// (see package relay).
+package bridge
+
+import (
+	"context"
+
+	"github.com/tehsphinx/nrpc/dynamic"
+	"github.com/tehsphinx/nrpc/relay"
+	"google.golang.org/grpc"
+)
+
+// NewServer creates a grpc server forwarding all calls through the client. The methods are looked up in
+// the source of the client when they are called first. Calls of methods that are not found fail with
+// codes.Unimplemented. The options configure the grpc server, e.g. its credentials. Services registered
+// with the server are served by the server itself.
+func NewServer(client *dynamic.Client, opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts, grpc.UnknownServiceHandler(relay.New(client).Handler()))
+	return grpc.NewServer(opts...)
+}
+
+// RegisterUpstream registers the services given by their full names (package.Service) with the server,
+// usually an *nrpc.Server, forwarding their calls to the upstream grpc connection. The descriptors of the
+// services are looked up in the source. It must be called before the server is started.
+func RegisterUpstream(ctx context.Context, server grpc.ServiceRegistrar, upstream grpc.ClientConnInterface,
+	src dynamic.Source, services ...string) error {
+	return relay.New(dynamic.NewClient(upstream, src)).Register(ctx, server, services...)
+}
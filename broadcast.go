@@ -0,0 +1,171 @@
+package nrpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/tehsphinx/nrpc/pubsub"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// broadcastInstance is the instance segment of the subject all instances serving a method receive
+	// broadcasts on. It cannot collide with the IDs of instances, as they are longer.
+	broadcastInstance = "all"
+	// broadcastBuffer is the number of responses to a broadcast buffered until they are received.
+	broadcastBuffer = 16
+)
+
+// registerBroadcast subscribes the handler of the unary method to its broadcast subject without queue
+// group, so all instances of the server receive the broadcasts of clients (see Client.Broadcast).
+func (s *Server) registerBroadcast(fullMethod string, handler pubsub.Handler) {
+	subj := s.servedSubjects().MapSubject(callSubj(fullMethod, broadcastInstance))
+	s.subs.RegisterSubscription(subscription{
+		endpoint: subj,
+		handler:  s.tenantHandler(subj, handler),
+	})
+}
+
+// MaxResponses returns a CallOption ending a broadcast (see Client.Broadcast) once n responses were received.
+func MaxResponses(n int) grpc.CallOption {
+	return callOption{apply: func(opt *callOptions) {
+		opt.maxResponses = n
+	}}
+}
+
+// Broadcast sends the request of the unary method to all server instances serving it and returns their
+// responses as they arrive, e.g. to invalidate caches or to gather the state of all instances. The method
+// is given as full method (/service/method). The broadcast ends when the deadline of the context passes
+// or the number of responses set with the MaxResponses call option was received. Unlike calls, broadcasts
+// are neither retried, hedged nor balanced and the interceptors of the client are not invoked.
+func (s *Client) Broadcast(ctx context.Context, method string, args interface{}, opts ...grpc.CallOption) (*BroadcastResponses, error) {
+	ctx = s.propagate.apply(ctx)
+	callOpts, err := getCallOptions(s.callDefaults(method), method, opts)
+	if err != nil {
+		return nil, err
+	}
+	if r := s.awaitReady(ctx, callOpts.readiness); r != nil {
+		return nil, r
+	}
+	ctx, err = withCredentials(ctx, method, callOpts.creds)
+	if err != nil {
+		return nil, err
+	}
+	ctx = callOpts.call.withMetadata(ctx)
+	if callOpts.subjects, err = s.callSubjects(ctx); err != nil {
+		return nil, err
+	}
+
+	timeout := timeoutFromCtx(ctx)
+	if timeout < 0 {
+		return nil, toRPCErr(ctx.Err())
+	}
+	_, payload, err := marshalReqMsg(outgoingMD(ctx), callOpts.codec, args, callOpts.announce(&Request{
+		Timeout:    timeout,
+		Compressor: callOpts.compressor,
+	}), callOpts.stream.maxSendMsgSize)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	resps := &BroadcastResponses{
+		ctx:     ctx,
+		cancel:  cancel,
+		opts:    callOpts,
+		chResps: make(chan []byte, broadcastBuffer),
+	}
+
+	subj := callOpts.subjects.MapSubject(callSubj(method, broadcastInstance))
+	inbox := callOpts.subjects.MapSubject("nrpc.resp" + strings.ReplaceAll(method, "/", ".") + "." + randString(randSubjectLen))
+	resps.sub, err = subscribeReplies(s.sub, inbox, subj, callOpts.stream.streamQueue, func(_ context.Context, msg pubsub.Replier) {
+		s.cfg.tap.message(ctx, Frame{Direction: FrameReceived, Method: method, Subject: inbox, Data: msg.Data()})
+		select {
+		case <-ctx.Done():
+		case resps.chResps <- msg.Data():
+		}
+	})
+	if err != nil {
+		cancel()
+		return nil, toRPCErr(err)
+	}
+
+	s.log.Debug("broadcast", "subject", subj)
+	s.cfg.tap.request(ctx, Frame{Direction: FrameSent, Method: method, Subject: subj, Data: payload})
+	if r := s.pub.Publish(pubsub.Message{
+		Subject: subj,
+		Reply:   inbox,
+		Data:    payload,
+	}); r != nil {
+		resps.Close()
+		return nil, toRPCErr(r)
+	}
+	return resps, nil
+}
+
+// BroadcastResponses receives the responses of the server instances to a broadcast (see Client.Broadcast).
+type BroadcastResponses struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	opts     callOptions
+	sub      pubsub.Subscription
+	chResps  chan []byte
+	received int
+
+	closeOnce sync.Once
+}
+
+// Recv receives the next response into reply. Responses of instances failing the call are returned as
+// their status error, the broadcast continues nevertheless. Recv returns io.EOF at the end of the
+// broadcast and the status error of the context if it is canceled.
+func (r *BroadcastResponses) Recv(reply interface{}) error {
+	if r.opts.maxResponses > 0 && r.received >= r.opts.maxResponses {
+		r.Close()
+		return io.EOF
+	}
+
+	// the responses received before the end of the broadcast are returned first
+	select {
+	case data := <-r.chResps:
+		return r.decode(data, reply)
+	default:
+	}
+
+	select {
+	case <-r.ctx.Done():
+		r.Close()
+		if errors.Is(r.ctx.Err(), context.DeadlineExceeded) {
+			return io.EOF
+		}
+		return status.FromContextError(r.ctx.Err()).Err()
+	case data := <-r.chResps:
+		return r.decode(data, reply)
+	}
+}
+
+func (r *BroadcastResponses) decode(data []byte, reply interface{}) error {
+	r.received++
+
+	resp, err := unmarshalUnaryResp(data)
+	if err != nil {
+		return toRPCErr(err)
+	}
+	codec, err := responseCodec(r.opts.codec, resp.Codec)
+	if err != nil {
+		return err
+	}
+	_, err = decode(codec, resp.Compressor, resp.Data, reply, r.opts.stream.maxRecvMsgSize)
+	return err
+}
+
+// Close ends the broadcast. Responses arriving later are discarded.
+func (r *BroadcastResponses) Close() {
+	r.closeOnce.Do(func() {
+		r.cancel()
+		_ = r.sub.Unsubscribe()
+	})
+}
@@ -0,0 +1,270 @@
+package nrpc
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// callOption implements nrpc specific options for a single call. It satisfies the
+// grpc.CallOption interface so it can be passed to generated client code.
+type callOption struct {
+	grpc.EmptyCallOption
+	apply func(opt *callOptions)
+}
+
+// CallOption is implemented by call options of other packages extending the calls of the client, e.g.
+// with options specific to a transport. Besides the standard call options of grpc and its own, the client
+// applies the call options implementing it in the order they were passed. Implementations embed
+// grpc.EmptyCallOption to satisfy the grpc.CallOption interface.
+type CallOption interface {
+	grpc.CallOption
+	// BeforeCall is called before the call is sent and may change the settings of the call. An error
+	// fails the call.
+	BeforeCall(info *CallInfo) error
+	// AfterCall is called once the call is done with the metadata received from the server.
+	AfterCall(info *CallInfo)
+}
+
+// CallInfo describes a call to the CallOption extensions.
+type CallInfo struct {
+	// Method is the full method (/service/method) of the call.
+	Method string
+	// Metadata is sent with the call in addition to the outgoing metadata of the context.
+	Metadata metadata.MD
+	// Compressor is the name of the compressor of the requests.
+	Compressor string
+	// MaxSendMsgSize and MaxRecvMsgSize limit the size of the messages sent and received.
+	MaxSendMsgSize int
+	MaxRecvMsgSize int
+	// Header and Trailer are the metadata received from the server and Subject the subject the call
+	// was sent on. They are set before AfterCall is called.
+	Header  metadata.MD
+	Trailer metadata.MD
+	Subject string
+}
+
+// before applies the call option extension to the options of the call.
+func (c *CallInfo) before(o CallOption, opt *callOptions) error {
+	c.Compressor = opt.compressor
+	c.MaxSendMsgSize, c.MaxRecvMsgSize = opt.stream.maxSendMsgSize, opt.stream.maxRecvMsgSize
+	if r := o.BeforeCall(c); r != nil {
+		return r
+	}
+	opt.compressor = c.Compressor
+	opt.stream.maxSendMsgSize, opt.stream.maxRecvMsgSize = c.MaxSendMsgSize, c.MaxRecvMsgSize
+	return nil
+}
+
+// withMetadata adds the metadata set by the call option extensions to the outgoing metadata of the context.
+func (c *CallInfo) withMetadata(ctx context.Context) context.Context {
+	if c == nil {
+		return ctx
+	}
+	for k, values := range c.Metadata {
+		for _, v := range values {
+			ctx = metadata.AppendToOutgoingContext(ctx, k, v)
+		}
+	}
+	return ctx
+}
+
+// callOptions holds the configuration of a single call.
+type callOptions struct {
+	stream     streamConfig
+	compressor string
+	codec      Codec
+	retry      RetryPolicy
+	hedging    HedgingPolicy
+	creds      []credentials.PerRPCCredentials
+	balancing  BalancingPolicy
+	instance   string
+	affinity   string
+	subjects   SubjectMapper
+	circuit    circuit
+	readiness  readiness
+	// maxResponses ends broadcasts once the number of responses was received.
+	maxResponses int
+	// oneWay publishes unary calls without waiting for the response.
+	oneWay bool
+	// idempotencyKey is sent with unary calls to be executed once by the server.
+	idempotencyKey string
+	// outboxID is the ID the attempts of a unary call are published and persisted with (see WithOutbox).
+	outboxID string
+	// stats reports the events of the call to the stats handler of the client.
+	stats *clientStats
+	// mux shares the response subjects of streams. It is nil if multiplexing is disabled.
+	mux *muxer
+	// clientID identifies the connection of the client to the broker (see pubsub.ClientIdentifier).
+	clientID string
+	// identity is announced with the calls (see WithClientIdentity).
+	identity ClientIdentity
+	// timeout bounds the call in addition to its context (see ServiceConfig).
+	timeout time.Duration
+	// call describes the call to the call option extensions. It is nil if none was passed.
+	call *CallInfo
+}
+
+// announce sets the ID of the connection and the identity of the client on a request.
+func (o *callOptions) announce(req *Request) *Request {
+	req.ClientId = o.clientID
+	o.identity.announce(req)
+	return req
+}
+
+// getCallOptions applies the call options to the defaults configured on the client for the method.
+func getCallOptions(defaults callOptions, method string, opts []grpc.CallOption) (callOptions, error) {
+	callOpt := defaults
+	callOpt.creds = append([]credentials.PerRPCCredentials{}, defaults.creds...)
+
+	for _, o := range opts {
+		switch opt := o.(type) {
+		case callOption:
+			opt.apply(&callOpt)
+		case grpc.CompressorCallOption:
+			callOpt.compressor = opt.CompressorType
+		case grpc.ContentSubtypeCallOption:
+			c, err := getCodec(strings.ToLower(opt.ContentSubtype))
+			if err != nil {
+				return callOptions{}, err
+			}
+			callOpt.codec = c
+		case grpc.ForceCodecCallOption:
+			callOpt.codec = opt.Codec
+		case grpc.CustomCodecCallOption:
+			callOpt.codec = legacyCodec{Codec: opt.Codec}
+		case grpc.MaxRecvMsgSizeCallOption:
+			callOpt.stream.maxRecvMsgSize = opt.MaxRecvMsgSize
+		case grpc.MaxSendMsgSizeCallOption:
+			callOpt.stream.maxSendMsgSize = opt.MaxSendMsgSize
+		case grpc.PerRPCCredsCallOption:
+			callOpt.creds = append(callOpt.creds, opt.Creds)
+		case grpc.FailFastCallOption:
+			callOpt.readiness = readinessOf(!opt.FailFast)
+		case CallOption:
+			if callOpt.call == nil {
+				callOpt.call = &CallInfo{Method: method, Metadata: metadata.MD{}}
+			}
+			if r := callOpt.call.before(opt, &callOpt); r != nil {
+				return callOptions{}, r
+			}
+		}
+	}
+	return callOpt, nil
+}
+
+// applyAfterCall fills the header, trailer and peer call options once the call is done and passes the
+// metadata to the call option extensions.
+func applyAfterCall(opts []grpc.CallOption, call *CallInfo, subj string, header, trailer metadata.MD) {
+	if call != nil {
+		call.Header, call.Trailer, call.Subject = header, trailer, subj
+	}
+	for _, o := range opts {
+		switch opt := o.(type) {
+		case grpc.HeaderCallOption:
+			*opt.HeaderAddr = header
+		case grpc.TrailerCallOption:
+			*opt.TrailerAddr = trailer
+		case grpc.PeerCallOption:
+			*opt.PeerAddr = peer.Peer{Addr: subjectAddr(subj)}
+		case CallOption:
+			opt.AfterCall(call)
+		}
+	}
+}
+
+// withCredentials adds the metadata of the per call credentials to the outgoing metadata of the context.
+func withCredentials(ctx context.Context, method string, creds []credentials.PerRPCCredentials) (context.Context, error) {
+	uri := "nrpc://" + serviceName(method)
+	for _, c := range creds {
+		md, err := c.GetRequestMetadata(ctx, uri)
+		if err != nil {
+			if _, ok := status.FromError(err); ok {
+				return nil, err
+			}
+			return nil, status.Errorf(codes.Unauthenticated, "transport: per-RPC creds failed due to error: %v", err)
+		}
+		for k, v := range md {
+			ctx = metadata.AppendToOutgoingContext(ctx, k, v)
+		}
+	}
+	return ctx, nil
+}
+
+// subjectAddr is the address of the peer of a call: the subject the server listens on.
+type subjectAddr string
+
+// Network implements the net.Addr interface.
+func (a subjectAddr) Network() string { return "nrpc" }
+
+// String implements the net.Addr interface.
+func (a subjectAddr) String() string { return string(a) }
+
+// StreamConnectTimeout returns a CallOption that sets the time the client waits for
+// the server to accept the stream. It overwrites the WithStreamConnectTimeout option of the client.
+func StreamConnectTimeout(timeout time.Duration) grpc.CallOption {
+	return callOption{apply: func(opt *callOptions) {
+		opt.stream.connectTimeout = timeout
+	}}
+}
+
+// Keepalive returns a CallOption that sets the keepalive interval and timeout of the stream.
+// It overwrites the WithKeepalive option of the client. An interval of 0 disables keepalive.
+func Keepalive(interval, timeout time.Duration) grpc.CallOption {
+	return callOption{apply: func(opt *callOptions) {
+		opt.stream.keepaliveTime = interval
+		opt.stream.keepaliveWait = timeout
+	}}
+}
+
+// StreamResumption returns a CallOption that sets the number of frames kept to resume the stream.
+// It overwrites the WithStreamResumption option of the client. A size of 0 disables resumption.
+func StreamResumption(bufferSize int) grpc.CallOption {
+	return callOption{apply: func(opt *callOptions) {
+		opt.stream.resumeBuffer = bufferSize
+	}}
+}
+
+// StreamOrdering returns a CallOption that sets the verification of the order of the frames of the stream.
+// It overwrites the WithStreamOrdering option of the client.
+func StreamOrdering(policy OrderingPolicy) grpc.CallOption {
+	return callOption{apply: func(opt *callOptions) {
+		opt.stream.ordering = policy
+	}}
+}
+
+// ConsumerStuckTimeout returns a CallOption that sets the time the stream waits for a received
+// message to be consumed before the stream is closed. It overwrites the WithConsumerStuckTimeout
+// option of the client.
+func ConsumerStuckTimeout(timeout time.Duration) grpc.CallOption {
+	return callOption{apply: func(opt *callOptions) {
+		opt.stream.stuckTimeout = timeout
+	}}
+}
+
+// ToInstance returns a CallOption that sends the call to the server instance with the given ID
+// instead of the queue group of the service. The server has to announce itself (see WithAnnouncements),
+// its ID is listed by the Registry. The call fails with codes.Unavailable if the instance is gone.
+func ToInstance(id string) grpc.CallOption {
+	return callOption{apply: func(opt *callOptions) {
+		opt.instance = id
+	}}
+}
+
+// AffinityKey returns a CallOption routing all calls and streams with the same key to the same server
+// instance as long as it is alive, e.g. to keep the streams of a stateful session on one instance. The
+// instance is picked by consistent hashing over the instances discovered by the registry of the client
+// (see WithRegistry), so only the keys of instances that come or go move. It takes precedence over the
+// balancing policy. Without discovered instances the call is sent to the queue group of the service.
+func AffinityKey(key string) grpc.CallOption {
+	return callOption{apply: func(opt *callOptions) {
+		opt.affinity = key
+	}}
+}
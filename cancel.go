@@ -0,0 +1,127 @@
+package nrpc
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/tehsphinx/nrpc/pubsub"
+)
+
+// cancelSubj returns the subject cancellations of calls and streams to the service are sent on.
+// All server instances of the service listen on it.
+func cancelSubj(service string) string {
+	return "nrpc.cancel." + service
+}
+
+// serviceName extracts the service name from a full method name (/service/method).
+func serviceName(method string) string {
+	method = strings.TrimPrefix(method, "/")
+	if i := strings.Index(method, "/"); i >= 0 {
+		return method[:i]
+	}
+	return method
+}
+
+// inflightCalls keeps track of the calls and streams in progress so they can be
+// canceled by the client.
+type inflightCalls struct {
+	m      sync.Mutex
+	calls  map[string]context.CancelFunc
+	seq    uint64
+	chIdle chan struct{}
+}
+
+func newInflightCalls() *inflightCalls {
+	return &inflightCalls{
+		calls: make(map[string]context.CancelFunc),
+	}
+}
+
+// add registers the call and returns a function to remove it again. Calls without ID cannot be
+// canceled by the client, but are still tracked for draining the server.
+func (c *inflightCalls) add(id string, cancel context.CancelFunc) func() {
+	c.m.Lock()
+	if id == "" {
+		// IDs sent by clients never start with a '#'
+		c.seq++
+		id = "#" + strconv.FormatUint(c.seq, 10)
+	}
+	c.calls[id] = cancel
+	c.m.Unlock()
+
+	return func() {
+		c.m.Lock()
+		defer c.m.Unlock()
+
+		delete(c.calls, id)
+		if len(c.calls) == 0 && c.chIdle != nil {
+			close(c.chIdle)
+			c.chIdle = nil
+		}
+	}
+}
+
+// wait blocks until there are no calls in progress or the context is done.
+func (c *inflightCalls) wait(ctx context.Context) error {
+	for {
+		c.m.Lock()
+		if len(c.calls) == 0 {
+			c.m.Unlock()
+			return nil
+		}
+		if c.chIdle == nil {
+			c.chIdle = make(chan struct{})
+		}
+		chIdle := c.chIdle
+		c.m.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-chIdle:
+		}
+	}
+}
+
+// len returns the number of calls and streams in progress.
+func (c *inflightCalls) len() int {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return len(c.calls)
+}
+
+// cancelAll cancels all calls in progress.
+func (c *inflightCalls) cancelAll() {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	for _, cancel := range c.calls {
+		cancel()
+	}
+}
+
+// cancel cancels the call with the given ID if it is handled by this server.
+func (c *inflightCalls) cancel(id string) {
+	c.m.Lock()
+	cancel, ok := c.calls[id]
+	c.m.Unlock()
+
+	if ok {
+		cancel()
+	}
+}
+
+// handleCancel handles the cancellations of calls and streams sent by clients.
+func (s *Server) handleCancel(_ context.Context, msg pubsub.Replier) {
+	req, err := unmarshalReq(msg.Data())
+	if err != nil {
+		s.log.Error("failed to unmarshal cancel request", "subject", msg.Subject(), "error", err)
+		return
+	}
+	if req.Cancel {
+		s.calls.cancel(req.Id)
+	}
+}
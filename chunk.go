@@ -0,0 +1,108 @@
+package nrpc
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// chunker splits marshaled messages that exceed the chunk size into chunks.
+type chunker struct {
+	size   int
+	nextID uint64
+}
+
+// needsSplit reports whether the payload exceeds the chunk size.
+func (c *chunker) needsSplit(payload []byte) bool {
+	return c.size > 0 && len(payload) > c.size
+}
+
+// split splits the payload into chunks. The wrap function marshals a chunk into the
+// envelope of the direction it is sent in. If the payload does not exceed the chunk
+// size it is returned as is.
+func (c *chunker) split(payload []byte, wrap func(chunk *Chunk) ([]byte, error)) ([][]byte, error) {
+	if !c.needsSplit(payload) {
+		return [][]byte{payload}, nil
+	}
+
+	c.nextID++
+	total := (len(payload) + c.size - 1) / c.size
+	chunks := make([][]byte, 0, total)
+	for i := 0; i < total; i++ {
+		end := (i + 1) * c.size
+		if end > len(payload) {
+			end = len(payload)
+		}
+
+		chunk, err := wrap(&Chunk{
+			Id:    c.nextID,
+			Index: uint32(i),
+			Total: uint32(total),
+			Data:  payload[i*c.size : end],
+		})
+		if err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks, nil
+}
+
+// reassembler collects chunks until the original message is complete.
+// It must only be used by the goroutine handling the subscription.
+type reassembler struct {
+	partials map[uint64]*partialMsg
+}
+
+type partialMsg struct {
+	chunks   [][]byte
+	received int
+	size     int
+}
+
+func newReassembler() *reassembler {
+	return &reassembler{
+		partials: make(map[uint64]*partialMsg),
+	}
+}
+
+// add adds a chunk and returns the reassembled message once all chunks were received.
+func (r *reassembler) add(chunk *Chunk) ([]byte, bool, error) {
+	if chunk.Total == 0 || chunk.Index >= chunk.Total {
+		return nil, false, fmt.Errorf("invalid chunk %d of %d", chunk.Index, chunk.Total)
+	}
+
+	msg, ok := r.partials[chunk.Id]
+	if !ok {
+		msg = &partialMsg{chunks: make([][]byte, chunk.Total)}
+		r.partials[chunk.Id] = msg
+	}
+	if int(chunk.Total) != len(msg.chunks) {
+		delete(r.partials, chunk.Id)
+		return nil, false, fmt.Errorf("chunk %d: inconsistent number of chunks", chunk.Id)
+	}
+	if msg.chunks[chunk.Index] == nil {
+		msg.received++
+		msg.size += len(chunk.Data)
+	}
+	msg.chunks[chunk.Index] = chunk.Data
+
+	if msg.received < len(msg.chunks) {
+		return nil, false, nil
+	}
+	delete(r.partials, chunk.Id)
+
+	data := make([]byte, 0, msg.size)
+	for _, part := range msg.chunks {
+		data = append(data, part...)
+	}
+	return data, true, nil
+}
+
+func wrapReqChunk(chunk *Chunk) ([]byte, error) {
+	return proto.Marshal(&Request{Chunk: chunk})
+}
+
+func wrapRespChunk(chunk *Chunk) ([]byte, error) {
+	return proto.Marshal(&Response{Chunk: chunk})
+}
@@ -2,52 +2,322 @@ package nrpc
 
 import (
 	"context"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/tehsphinx/nrpc/pubsub"
 	"google.golang.org/grpc"
-	"google.golang.org/protobuf/proto"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/stats"
 )
 
-// Client implements a pub-sub based grpc client.
+// Client implements a pub-sub based grpc client. It implements the grpc.ClientConnInterface,
+// so clients generated by protoc-gen-go-grpc work unchanged on top of it:
+//
+//	client := pb.NewFooClient(nrpc.NewClient(pub, sub))
+//
+// Besides the nrpc specific call options, the grpc call options Header, Trailer, Peer,
+// PerRPCCredentials, UseCompressor, CallContentSubtype, ForceCodec, MaxCallRecvMsgSize,
+// MaxCallSendMsgSize and WaitForReady are supported. Other grpc call options are ignored.
 type Client struct {
-	pub pubsub.Publisher
-	sub pubsub.Subscriber
-	log Logger
+	pub    pubsub.Publisher
+	sub    pubsub.Subscriber
+	states pubsub.StateReporter
+	// clientIDs returns the ID of the connection to the broker. It is nil if the publisher does not know it.
+	clientIDs pubsub.ClientIdentifier
+	identity  ClientIdentity
+	log       Logger
+	cfg       streamConfig
+	codec     Codec
+	retry     retryPolicies
+	hedging   hedgingPolicies
+	creds     []credentials.PerRPCCredentials
+	ready     readiness
+
+	breakerPolicies circuitBreakerPolicies
+	breakers        *circuitBreakers
+
+	registry    *Registry
+	balancing   balancingPolicies
+	subjects    SubjectMapper
+	multiTenant bool
+	propagate   propagation
+	outbox      *outbox
+	idempotent  idempotentMethods
+
+	serviceConfig *serviceConfigHolder
+
+	statsHandler stats.Handler
+	streams      *activeStreams
+	mux          *muxer
+
+	unaryInt  grpc.UnaryClientInterceptor
+	streamInt grpc.StreamClientInterceptor
 }
 
+var _ grpc.ClientConnInterface = (*Client)(nil)
+
 // Invoke performs a unary RPC and returns after the response is received
 // into reply.
 func (s *Client) Invoke(ctx context.Context, method string, args interface{}, reply interface{}, opts ...grpc.CallOption) error {
-	timeout := timeoutFromCtx(ctx)
-	if timeout < 0 {
-		return ctx.Err()
+	ctx = s.propagate.apply(ctx)
+	if s.unaryInt != nil {
+		return s.unaryInt(ctx, method, args, reply, nil, s.invoke, opts...)
 	}
+	return s.invoke(ctx, method, args, reply, nil, opts...)
+}
 
-	payload, err := marshalReqMsg(ctx, args.(proto.Message), "", "", timeout)
+func (s *Client) invoke(ctx context.Context, method string, args, reply interface{}, _ *grpc.ClientConn,
+	opts ...grpc.CallOption) (err error) {
+	callOpts, err := getCallOptions(s.callDefaults(method), method, opts)
 	if err != nil {
 		return err
 	}
+	ctx, cancel := withCallTimeout(ctx, callOpts.timeout)
+	defer cancel()
+	ctx, callOpts.stats = beginClientStats(ctx, s.statsHandler, method, nil)
+	defer func() {
+		callOpts.stats.end(err)
+	}()
+	if ctx, err = s.prepareCall(ctx, method, &callOpts); err != nil {
+		return err
+	}
+	if s.outbox != nil {
+		// the entry of the call is not redelivered while it is attempted
+		defer s.outbox.acquire(callOpts.outboxID)()
+	}
+	if callOpts.oneWay {
+		return s.publish(ctx, method, args, callOpts)
+	}
 
-	req := pubsub.Message{
-		Subject: methodSubj(method),
-		Data:    payload,
+	var resp *Response
+	// subj is the subject of the successful attempt, guarded by m as hedged attempts run concurrently
+	var subj string
+	var m sync.Mutex
+	if callOpts.hedging.enabled() {
+		resp, err = hedge(ctx, callOpts.hedging, func(ctx context.Context) (*Response, error) {
+			attemptSubj := callOpts.subjects.MapSubject(callSubj(method, s.pickInstance(ctx, method, callOpts)))
+			r, e := s.call(ctx, method, attemptSubj, args, callOpts)
+			if e == nil {
+				m.Lock()
+				subj = attemptSubj
+				m.Unlock()
+			}
+			return r, toRPCErr(e)
+		})
+	} else {
+		err = retry(ctx, callOpts.retry, func() error {
+			subj = callOpts.subjects.MapSubject(callSubj(method, s.pickInstance(ctx, method, callOpts)))
+			var r error
+			resp, r = s.call(ctx, method, subj, args, callOpts)
+			return toRPCErr(r)
+		})
 	}
+	m.Lock()
+	defer m.Unlock()
+	return finishCall(callOpts, opts, subj, resp, err, reply)
+}
 
-	s.log.Infof("Request: subject => %v", req.Subject)
-	res, err := s.pub.Request(ctx, req)
+// prepareCall waits for the client to be ready for the unary call, adds the metadata of the call to the
+// context and completes the options of the call.
+func (s *Client) prepareCall(ctx context.Context, method string, callOpts *callOptions) (context.Context, error) {
+	if r := s.awaitReady(ctx, callOpts.readiness); r != nil {
+		return nil, r
+	}
+	ctx, err := withCredentials(ctx, method, callOpts.creds)
 	if err != nil {
+		return nil, err
+	}
+	ctx = callOpts.call.withMetadata(ctx)
+	if callOpts.subjects, err = s.callSubjects(ctx); err != nil {
+		return nil, err
+	}
+	if callOpts.idempotencyKey == "" && s.idempotent.get(method) {
+		// the key is shared by all attempts of the call
+		callOpts.idempotencyKey = randString(callIDLen)
+	}
+	if s.outbox != nil {
+		// the attempts of the call share their entry in the outbox
+		callOpts.outboxID = randString(callIDLen)
+	}
+	return ctx, nil
+}
+
+// finishCall decodes the response of a unary call sent on the subject into reply and fills the metadata
+// call options. The response is nil if the call failed without response of the server.
+func finishCall(callOpts callOptions, opts []grpc.CallOption, subj string, resp *Response, err error, reply interface{}) error {
+	if err != nil {
+		if resp != nil {
+			// like grpc, the metadata of failed calls is available as well
+			callOpts.stats.inHeader(toMD(resp.Header), 0)
+			callOpts.stats.inTrailer(toMD(resp.Trailer))
+			applyAfterCall(opts, callOpts.call, subj, toMD(resp.Header), toMD(resp.Trailer))
+		}
 		return err
 	}
-	resp, err := unmarshalUnaryRespMsg(res.Data, reply.(proto.Message))
+
+	callOpts.stats.inHeader(toMD(resp.Header), 0)
+	codec, err := responseCodec(callOpts.codec, resp.Codec)
 	if err != nil {
 		return err
 	}
-	applyRespToOptions(opts, resp)
+	data, err := decode(codec, resp.Compressor, resp.Data, reply, callOpts.stream.maxRecvMsgSize)
+	if err != nil {
+		return err
+	}
+	callOpts.stats.inPayload(reply, data, resp.Data)
+	callOpts.stats.inTrailer(toMD(resp.Trailer))
+	applyAfterCall(opts, callOpts.call, subj, toMD(resp.Header), toMD(resp.Trailer))
 	return nil
 }
 
+// callDefaults returns the call options configured on the client for the method.
+func (s *Client) callDefaults(method string) callOptions {
+	opt := callOptions{
+		stream:  s.cfg.forMethod(method),
+		codec:   s.codec,
+		retry:   s.retry.get(method),
+		hedging: s.hedging.get(method),
+		creds:   s.creds,
+		circuit: circuit{policy: s.breakerPolicies.get(method), breakers: s.breakers},
+
+		balancing: s.balancing.get(method),
+		subjects:  s.subjects,
+		readiness: s.ready,
+		oneWay:    isOneWay(method),
+		mux:       s.mux,
+		clientID:  s.clientID(),
+		identity:  s.identity,
+	}
+	s.serviceConfig.get(method).apply(&opt)
+	return opt
+}
+
+// clientID returns the ID of the connection to the broker if the publisher knows it.
+func (s *Client) clientID() string {
+	if s.clientIDs == nil {
+		return ""
+	}
+	return s.clientIDs.ClientID()
+}
+
+// call sends a single attempt of a unary call to the subject. The data of the returned response is not decoded yet.
+// Unlike streams, unary calls do not subscribe response subjects: the reply is received with the
+// request-reply mechanism of the publisher.
+func (s *Client) call(ctx context.Context, method, subj string, args interface{}, callOpts callOptions) (*Response, error) {
+	req, err := s.newRequest(ctx, method, subj, args, callOpts)
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.pub.Request(ctx, req.msg)
+	return s.readResponse(ctx, req, res, err)
+}
+
+// unaryRequest is a request of a unary call sent to the server.
+type unaryRequest struct {
+	method   string
+	subj     string
+	id       string
+	msg      pubsub.Message
+	callOpts callOptions
+	// done reports the outcome of the request to the circuit breaker.
+	done func(err error)
+}
+
+// newRequest marshals the request of a unary call sent on the subject. The request must be passed to
+// readResponse once it is sent, as it holds the circuit breaker of the subject.
+func (s *Client) newRequest(ctx context.Context, method, subj string, args interface{}, callOpts callOptions) (*unaryRequest, error) {
+	timeout := timeoutFromCtx(ctx)
+	if timeout < 0 {
+		return nil, ctx.Err()
+	}
+
+	var id string
+	if ctx.Done() != nil {
+		id = randString(callIDLen)
+	}
+	data, payload, err := marshalReqMsg(outgoingMD(ctx), callOpts.codec, args, callOpts.announce(&Request{
+		Timeout:        timeout,
+		Compressor:     callOpts.compressor,
+		Id:             id,
+		IdempotencyKey: callOpts.idempotencyKey,
+		Version:        callOpts.stream.wireVersion,
+	}), callOpts.stream.maxSendMsgSize)
+	if err != nil {
+		return nil, err
+	}
+
+	done, err := callOpts.circuit.acquire(subj)
+	if err != nil {
+		return nil, err
+	}
+	if debugEnabled(s.log) {
+		s.log.Debug("request", "subject", subj)
+	}
+	callOpts.stats.outHeader(ctx, subj)
+	callOpts.stats.outPayload(args, data, payload)
+	s.cfg.tap.request(ctx, Frame{Direction: FrameSent, Method: method, Subject: subj, Data: payload})
+	return &unaryRequest{
+		method:   method,
+		subj:     subj,
+		id:       id,
+		msg:      pubsub.Message{Subject: subj, Data: payload, ID: callOpts.outboxID},
+		callOpts: callOpts,
+		done:     done,
+	}, nil
+}
+
+// readResponse unmarshals the reply to the request or handles the error of sending it.
+func (s *Client) readResponse(ctx context.Context, req *unaryRequest, res pubsub.Message, err error) (*Response, error) {
+	resp, err := s.readFirstPage(ctx, req, res, err)
+	if err == nil && resp.PageSubject != "" {
+		resp, err = s.readPages(ctx, req, resp)
+	}
+	return resp, err
+}
+
+// readFirstPage decodes the response to the request or, if the response is paged, its first page.
+// Paged responses are completed by readPages.
+func (s *Client) readFirstPage(ctx context.Context, req *unaryRequest, res pubsub.Message, err error) (*Response, error) {
+	if err != nil {
+		req.done(toRPCErr(err))
+		if ctx.Err() != nil && req.id != "" {
+			s.cancelCall(ctx, req.callOpts.subjects, req.method, req.id)
+		}
+		return nil, err
+	}
+	s.cfg.tap.message(ctx, Frame{Direction: FrameReceived, Method: req.method, Subject: req.subj, Data: res.Data})
+	resp, err := unmarshalUnaryResp(res.Data)
+	if err != nil || resp.PageSubject == "" {
+		req.done(err)
+	}
+	return resp, err
+}
+
+// readPages requests the pages of the paged response to the request following the first page.
+func (s *Client) readPages(ctx context.Context, req *unaryRequest, first *Response) (*Response, error) {
+	resp, err := s.fetchPages(ctx, req.method, req.callOpts, first)
+	req.done(err)
+	return resp, err
+}
+
+// cancelCall notifies the servers of the service that the call was canceled.
+func (s *Client) cancelCall(ctx context.Context, subjects SubjectMapper, method, id string) {
+	payload, err := marshalCancel(id)
+	if err != nil {
+		s.log.Error("failed to marshal cancel request", "method", method, "error", err)
+		return
+	}
+	subj := subjects.MapSubject(cancelSubj(serviceName(method)))
+	s.cfg.tap.request(ctx, Frame{Direction: FrameSent, Method: method, Subject: subj, Data: payload})
+	if r := s.pub.Publish(pubsub.Message{
+		Subject: subj,
+		Data:    payload,
+	}); r != nil {
+		s.log.Error("failed to cancel call", "method", method, "error", r)
+	}
+}
+
 func timeoutFromCtx(ctx context.Context) int64 {
 	if deadline, ok := ctx.Deadline(); ok {
 		return time.Until(deadline).Nanoseconds()
@@ -56,27 +326,52 @@ func timeoutFromCtx(ctx context.Context) int64 {
 }
 
 // NewStream begins a streaming RPC.
-func (s *Client) NewStream(ctx context.Context, _ *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
-	stream := newClientStream(s.pub, s.sub, s.log, method, opts)
-	if r := stream.Subscribe(ctx); r != nil {
-		return nil, r
+func (s *Client) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	ctx = s.propagate.apply(ctx)
+	if s.streamInt != nil {
+		return s.streamInt(ctx, desc, nil, method, s.newStream, opts...)
 	}
-	return stream, nil
+	return s.newStream(ctx, desc, nil, method, opts...)
 }
 
-func applyRespToOptions(opts []grpc.CallOption, resp *Response) {
-	for _, opt := range opts {
-		switch o := opt.(type) {
-		case grpc.HeaderCallOption:
-			md := toMD(resp.Header)
-			*o.HeaderAddr = md
-		case grpc.TrailerCallOption:
-			md := toMD(resp.Trailer)
-			*o.TrailerAddr = md
+func (s *Client) newStream(ctx context.Context, desc *grpc.StreamDesc, _ *grpc.ClientConn, method string,
+	opts ...grpc.CallOption) (_ grpc.ClientStream, err error) {
+	callOpts, err := getCallOptions(s.callDefaults(method), method, opts)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := withCallTimeout(ctx, callOpts.timeout)
+	defer func() {
+		if err != nil {
+			cancel()
 		}
+	}()
+	if r := s.awaitReady(ctx, callOpts.readiness); r != nil {
+		return nil, r
+	}
+	ctx, err = withCredentials(ctx, method, callOpts.creds)
+	if err != nil {
+		return nil, err
+	}
+	ctx = callOpts.call.withMetadata(ctx)
+	if callOpts.subjects, err = s.callSubjects(ctx); err != nil {
+		return nil, err
 	}
-}
 
-func methodSubj(method string) string {
-	return "nrpc" + strings.ReplaceAll(method, "/", ".")
+	callOpts.instance = s.pickInstance(ctx, method, callOpts)
+	ctx, callOpts.stats = beginClientStats(ctx, s.statsHandler, method, desc)
+	stream := newClientStream(s.pub, s.sub, s.log, callOpts, method, opts)
+	stream.serverStreams = desc == nil || desc.ServerStreams
+	if r := stream.Subscribe(ctx); r != nil {
+		err = toRPCErr(r)
+		callOpts.stats.end(err)
+		return nil, err
+	}
+	remove := s.streams.add(stream)
+	go func() {
+		<-stream.ctx.Done()
+		remove()
+		cancel()
+	}()
+	return stream, nil
 }
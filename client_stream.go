@@ -5,61 +5,192 @@ import (
 	"errors"
 	"io"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/tehsphinx/nrpc/pubsub"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
 )
 
-func newClientStream(pub pubsub.Publisher, sub pubsub.Subscriber, log Logger, method string, opts []grpc.CallOption) *clientStream {
+func newClientStream(pub pubsub.Publisher, sub pubsub.Subscriber, log Logger, callOpts callOptions, method string,
+	opts []grpc.CallOption) *clientStream {
 	randSuffix := randString(randSubjectLen)
+	recvWin := &recvWindow{size: callOpts.stream.window}
 	s := &clientStream{
 		pub:        pub,
 		sub:        sub,
 		log:        log,
+		cfg:        callOpts.stream,
+		compressor: callOpts.compressor,
+		codec:      callOpts.codec,
+		retry:      callOpts.retry,
+		circuit:    callOpts.circuit,
+		stats:      callOpts.stats,
+		clientID:   callOpts.clientID,
+		identity:   callOpts.identity,
 		method:     method,
-		methodSubj: methodSubj(method),
-		reqSubj:    "nrpc.req" + strings.ReplaceAll(method, "/", ".") + "." + randSuffix,
-		respSubj:   "nrpc.resp" + strings.ReplaceAll(method, "/", ".") + "." + randSuffix,
+		subjects:   callOpts.subjects,
+		methodSubj: callOpts.subjects.MapSubject(callSubj(method, callOpts.instance)),
+		reqSubj:    callOpts.subjects.MapSubject("nrpc.req" + strings.ReplaceAll(method, "/", ".") + "." + randSuffix),
+		respSubj:   callOpts.subjects.MapSubject("nrpc.resp" + strings.ReplaceAll(method, "/", ".") + "." + randSuffix),
 		opts:       opts,
-		chRecv:     make(chan *respMsg, 1),
+		call:       callOpts.call,
+		chRecv:     make(chan *respMsg, callOpts.stream.recvBuffer.bufferSize(recvWin)),
+		chHeader:   make(chan struct{}),
+		chFinished: make(chan struct{}),
+		sendWin:    newSendWindow(),
+		recvWin:    recvWin,
+		chunker:    &chunker{size: callOpts.stream.chunkSize},
+		chunks:     newReassembler(),
+		dedup:      newDedup(),
+		keepalive:  newKeepalive(callOpts.stream.keepaliveTime, callOpts.stream.keepaliveWait),
+		activity:   newStreamActivity(),
+		start:      time.Now(),
+	}
+	s.resume, s.order = newSequencing(callOpts.stream.resumeBuffer, callOpts.stream.ordering)
+	s.batch = newBatcher(callOpts.stream.batchSize, callOpts.stream.batchDelay, s.publishBatch, s.abort)
+	if callOpts.mux != nil {
+		// the stream shares a response subject and is told apart by its ID
+		s.mux = callOpts.mux
+		s.streamID = randSuffix
+		s.respSubj = callOpts.mux.subject(callOpts.subjects)
 	}
 	return s
 }
 
 type clientStream struct {
+	// sentSeq numbers the published messages. It is accessed atomically and kept
+	// first in the struct to guarantee 64-bit alignment.
+	sentSeq uint64
+	// opened and finished are set atomically once the server accepted the stream
+	// and the end of the stream was received respectively.
+	opened   uint32
+	finished uint32
+	// ended is set atomically once RecvMsg received the end of the stream sent by the server.
+	ended uint32
+	// version is the version of the envelope negotiated with the server. It is set atomically once
+	// the server accepted the stream.
+	version uint32
+
 	pub pubsub.Publisher
 	sub pubsub.Subscriber
 	log Logger
+	cfg streamConfig
 
 	ctx        context.Context
 	cancel     context.CancelFunc
 	method     string
+	subjects   SubjectMapper
 	methodSubj string
 	reqSubj    string
 	respSubj   string
-	opts       []grpc.CallOption
+	compressor string
+	codec      Codec
+	retry      RetryPolicy
+	// mux shares the response subject of the stream with other streams of the client, which are told
+	// apart by the stream ID. It is nil if the stream subscribes its own response subject.
+	mux      *muxer
+	streamID string
+	clientID string
+	identity ClientIdentity
+	circuit  circuit
+	stats    *clientStats
+	opts     []grpc.CallOption
+	call     *CallInfo
 
-	firstSent   bool
-	sendClosed  bool
-	chRecv      chan *respMsg
-	recvHeader  metadata.MD
-	recvTrailer metadata.MD
+	// serverStreams is false for client streams, which receive a single response.
+	serverStreams bool
+	firstSent     bool
+	sendClosed    bool
+	chRecv        chan *respMsg
+	sendWin       *sendWindow
+	recvWin       *recvWindow
+	chunker       *chunker
+	chunks        *reassembler
+	dedup         *dedup
+	keepalive     *keepalive
+	resume        *resumer
+	order         *reorderer
+	batch         *batcher
+	aborted       abortErr
+	chHeader      chan struct{}
+	headerOnce    sync.Once
+	recvHeader    metadata.MD
+	chFinished    chan struct{}
+	finishOnce    sync.Once
+	recvTrailer   metadata.MD
+	afterOnce     sync.Once
+	activity      *streamActivity
+	start         time.Time
 }
 
 // Header returns the header metadata received from the server if there
 // is any. It blocks if the metadata is not ready to read.
 func (s *clientStream) Header() (metadata.MD, error) {
-	return s.recvHeader, nil
+	select {
+	case <-s.chHeader:
+		return s.recvHeader, nil
+	case <-s.ctx.Done():
+	}
+
+	// the header might have arrived together with the end of the stream
+	select {
+	case <-s.chHeader:
+		return s.recvHeader, nil
+	default:
+		return nil, toRPCErr(s.aborted.err(s.ctx))
+	}
+}
+
+// setHeader stores the header of the first message received from the server
+// and unblocks calls to Header.
+func (s *clientStream) setHeader(resp *Response) {
+	s.headerOnce.Do(func() {
+		s.recvHeader = toMD(resp.Header)
+		s.stats.inHeader(s.recvHeader, 0)
+		close(s.chHeader)
+	})
+}
+
+// applyAfterCall fills the header, trailer and peer call options of the stream once it ended.
+func (s *clientStream) applyAfterCall() {
+	s.afterOnce.Do(func() {
+		var header metadata.MD
+		select {
+		case <-s.chHeader:
+			header = s.recvHeader
+		default:
+		}
+		applyAfterCall(s.opts, s.call, s.methodSubj, header, s.Trailer())
+	})
 }
 
 // Trailer returns the trailer metadata from the server, if there is any.
 // It must only be called after stream.CloseAndRecv has returned, or
 // stream.Recv has returned a non-nil error (including io.EOF).
 func (s *clientStream) Trailer() metadata.MD {
-	return s.recvTrailer
+	select {
+	case <-s.chFinished:
+		return s.recvTrailer
+	default:
+		return nil
+	}
+}
+
+// finish records the end of the stream received from the server along with its trailer.
+// The end of the stream is recorded even if it arrives after the stream was canceled.
+func (s *clientStream) finish(resp *Response) {
+	s.finishOnce.Do(func() {
+		s.recvTrailer = toMD(resp.Trailer)
+		s.stats.inTrailer(s.recvTrailer)
+		atomic.StoreUint32(&s.finished, 1)
+		close(s.chFinished)
+	})
 }
 
 // CloseSend closes the send direction of the stream. It closes the stream
@@ -68,14 +199,11 @@ func (s *clientStream) Trailer() metadata.MD {
 func (s *clientStream) CloseSend() error {
 	payload, err := marshalEOS()
 	if err != nil {
-		return err
+		return toRPCErr(err)
 	}
 	s.sendClosed = true
 
-	return s.pub.Publish(pubsub.Message{
-		Subject: s.reqSubj,
-		Data:    payload,
-	})
+	return toRPCErr(s.publish(FrameEOS, payload))
 }
 
 // Context returns the context for this stream.
@@ -105,24 +233,186 @@ func (s *clientStream) Context() context.Context {
 // to call SendMsg on the same stream in different goroutines. It is also
 // not safe to call CloseSend concurrently with SendMsg.
 func (s *clientStream) SendMsg(m interface{}) error {
+	return toRPCErr(s.send(s.ctx, m))
+}
+
+// SendMsgContext sends a message like SendMsg. If the context is done before the message could be sent,
+// e.g. while waiting for credit of the server, the message is dropped without aborting the stream.
+func (s *clientStream) SendMsgContext(ctx context.Context, m interface{}) error {
+	return toRPCErr(s.send(ctx, m))
+}
+
+// sendErr returns the error of a send on the done stream. Like in grpc, it is io.EOF if the server
+// ended the stream, so the status is only returned by RecvMsg.
+func (s *clientStream) sendErr() error {
+	if atomic.LoadUint32(&s.ended) == 1 {
+		return io.EOF
+	}
+	return s.aborted.err(s.ctx)
+}
+
+func (s *clientStream) send(sendCtx context.Context, m interface{}) error {
 	if s.sendClosed {
 		return io.EOF
 	}
 	select {
 	case <-s.ctx.Done():
-		return s.ctx.Err()
+		return s.sendErr()
 	default:
 	}
-	// nolint: forcetypeassert
-	args := m.(proto.Message)
+	if r := sendContextErr(s.ctx, sendCtx); r != nil {
+		return r
+	}
+	if !s.sendWin.ready() {
+		// the server grants credit for the messages it received, so the batch must not wait for the delay
+		if r := s.batch.flush(); r != nil {
+			return r
+		}
+	}
+	ctx, cancel := withSendContext(s.ctx, sendCtx)
+	defer cancel()
+	if r := s.sendWin.acquire(ctx); r != nil {
+		if r := sendContextErr(s.ctx, sendCtx); r != nil {
+			return r
+		}
+		return s.sendErr()
+	}
 
 	subj, reqSubj, respSubj := s.getSubjects()
-	payload, err := marshalReqMsg(s.ctx, args, reqSubj, respSubj, 0)
+	req := &Request{
+		ReqSubject:  reqSubj,
+		RespSubject: respSubj,
+		Window:      uint32(s.recvWin.size),
+		Compressor:  s.compressor,
+	}
+	if !s.firstSent {
+		// the server derives the deadline of the stream from the remaining timeout
+		if req.Timeout = timeoutFromCtx(s.ctx); req.Timeout < 0 {
+			return s.ctx.Err()
+		}
+		req.KeepaliveInterval = int64(s.keepalive.interval)
+		req.KeepaliveTimeout = int64(s.keepalive.timeout)
+		req.ResumeBuffer = uint32(s.resume.size)
+		req.Ordering = uint32(s.order.policy.Mode)
+		req.ReorderWindow = uint32(s.order.policy.window())
+		req.Version = s.cfg.wireVersion
+		req.StreamId = s.streamID
+		req.ClientId = s.clientID
+		s.identity.announce(req)
+	}
+	// the metadata is sent with the first message only, the server ignores it on the following ones
+	var header metadata.MD
+	if !s.firstSent {
+		header = outgoingMD(s.ctx)
+	}
+	data, payload, err := marshalReqMsg(header, s.codec, m, req, s.cfg.maxSendMsgSize)
 	if err != nil {
 		return err
 	}
 
-	return s.sendMsg(subj, payload)
+	if !s.firstSent {
+		s.stats.outHeader(s.ctx, subj)
+	}
+	if !s.firstSent && s.chunker.needsSplit(payload) {
+		err = s.sendFirstChunked(req)
+	} else {
+		err = s.sendMsg(subj, payload)
+	}
+	if err != nil {
+		return err
+	}
+	s.stats.outPayload(m, data, payload)
+	return nil
+}
+
+// sendFirstChunked opens the stream without data and sends the data of
+// the first message in chunks on the request subject.
+func (s *clientStream) sendFirstChunked(req *Request) error {
+	data := req.Data
+	req.Data = nil
+	req.DataFollows = true
+
+	payload, err := proto.Marshal(req)
+	if err != nil {
+		return err
+	}
+	if r := s.sendMsg(s.methodSubj, payload); r != nil {
+		return r
+	}
+
+	payload, err = proto.Marshal(&Request{
+		Data:       data,
+		Compressor: req.Compressor,
+		Codec:      req.Codec,
+	})
+	if err != nil {
+		return err
+	}
+	return s.publish(FrameData, payload)
+}
+
+// publish publishes the payload on the request subject. Frames other than pings and requests
+// to resume the stream are numbered if stream resumption is enabled. Data frames are batched
+// if enabled and negotiated with the server.
+func (s *clientStream) publish(frame FrameType, payload []byte) error {
+	if frame == FramePing || frame == FrameResume {
+		return s.publishFrame(frame, payload)
+	}
+	if frame == FrameData && s.batch.enabled(atomic.LoadUint32(&s.version)) {
+		return s.batch.add(payload)
+	}
+	// the frame must not overtake the data frames sent before
+	if r := s.batch.flush(); r != nil {
+		return r
+	}
+	return s.publishNumbered(frame, payload)
+}
+
+// publishNumbered publishes the payload numbered if stream resumption is enabled.
+func (s *clientStream) publishNumbered(frame FrameType, payload []byte) error {
+	return s.resume.send(payload, reqSeqField, func(payload []byte) error {
+		return s.publishFrame(frame, payload)
+	})
+}
+
+// publishBatch publishes the data frames of a batch in a single frame.
+func (s *clientStream) publishBatch(frames [][]byte) error {
+	if len(frames) == 1 {
+		return s.publishNumbered(FrameData, frames[0])
+	}
+	payload, err := marshalReqBatch(frames)
+	if err != nil {
+		return err
+	}
+	return s.publishNumbered(FrameBatch, payload)
+}
+
+// publishFrame publishes the payload on the request subject. It is split into chunks if needed.
+func (s *clientStream) publishFrame(frame FrameType, payload []byte) error {
+	chunks := [][]byte{payload}
+	if s.chunker.needsSplit(payload) {
+		var err error
+		if chunks, err = s.chunker.split(payload, wrapReqChunk); err != nil {
+			return err
+		}
+	}
+
+	if debugEnabled(s.log) {
+		s.log.Debug("sending frame", "subject", s.reqSubj, "frame", frame, "chunks", len(chunks))
+	}
+	s.cfg.tap.request(s.ctx, Frame{Direction: FrameSent, Method: s.method, Subject: s.reqSubj, Type: frame, Data: payload})
+
+	for _, chunk := range chunks {
+		if r := s.pub.Publish(pubsub.Message{
+			Subject: s.reqSubj,
+			Data:    chunk,
+			ID:      frameID(s.reqSubj, atomic.AddUint64(&s.sentSeq, 1)),
+		}); r != nil {
+			return r
+		}
+		s.activity.sentFrame(len(chunk))
+	}
+	return nil
 }
 
 func (s *clientStream) getSubjects() (string, string, string) {
@@ -134,30 +424,149 @@ func (s *clientStream) getSubjects() (string, string, string) {
 
 func (s *clientStream) sendMsg(subj string, payload []byte) error {
 	if s.firstSent {
-		return s.pub.Publish(pubsub.Message{
-			Subject: subj,
-			Data:    payload,
-		})
+		return s.publish(FrameData, payload)
 	}
 
-	ctx, cancel := context.WithTimeout(s.ctx, streamConnectTimeout)
-	defer cancel()
+	if debugEnabled(s.log) {
+		s.log.Debug("opening stream", "subject", subj, "reqSubject", s.reqSubj, "respSubject", s.respSubj)
+	}
 
-	resp, err := s.pub.Request(ctx, pubsub.Message{
-		Subject: subj,
-		Data:    payload,
+	// the stream is only retried while being established, before the server produced any data
+	var resp pubsub.Message
+	err := retry(s.ctx, s.retry, func() error {
+		done, r := s.circuit.acquire(subj)
+		if r != nil {
+			return r
+		}
+		ctx, cancel := context.WithTimeout(s.ctx, s.cfg.connectTimeout)
+		defer cancel()
+
+		s.cfg.tap.request(s.ctx, Frame{Direction: FrameSent, Method: s.method, Subject: subj, Data: payload})
+		s.activity.sentFrame(len(payload))
+		resp, r = s.pub.Request(ctx, pubsub.Message{
+			Subject: subj,
+			Data:    payload,
+		})
+		if r == nil {
+			s.activity.receivedFrame(len(resp.Data))
+			s.cfg.tap.message(s.ctx, Frame{Direction: FrameReceived, Method: s.method, Subject: subj, Type: FrameHandshake, Data: resp.Data})
+		}
+		r = toRPCErr(r)
+		done(r)
+		return r
 	})
+	if err != nil && s.ctx.Err() != nil {
+		// the stream might have been aborted by frames received while waiting for the handshake
+		return s.aborted.err(s.ctx)
+	}
 	if err != nil {
 		return err
 	}
-	if len(resp.Data) != 0 {
-		return errors.New("unexpected response")
+	handshake, err := unmarshalHandshake(resp.Data)
+	if err != nil {
+		return err
 	}
+	if handshake.Window != 0 {
+		// the first message already took up one slot of the server's window
+		s.sendWin.enable(int(handshake.Window) - 1)
+	}
+	version := negotiateVersion(s.cfg.wireVersion, handshake.Version)
+	atomic.StoreUint32(&s.version, version)
 	s.firstSent = true
+	atomic.StoreUint32(&s.opened, 1)
+	if s.mux != nil && version < muxVersion {
+		// the server does not tell the frames of the stream apart on the shared subject
+		err = status.Errorf(codes.Unimplemented, "nrpc: the server does not support multiplexed streams (wire version %d)", version)
+		s.abort(err)
+		return err
+	}
 
+	go s.keepalive.run(s.ctx, "server", s.ping, s.abort)
 	return nil
 }
 
+// abort cancels the stream with the given error.
+func (s *clientStream) abort(err error) {
+	first := s.aborted.set(err)
+	s.cancel()
+	if first {
+		s.cfg.events.aborted(s, err)
+	}
+}
+
+// ping proves the liveness of the client to the server.
+func (s *clientStream) ping() error {
+	payload, err := marshalReqPing(s.resume.lastSent())
+	if err != nil {
+		return err
+	}
+	return s.publish(FramePing, payload)
+}
+
+// requestResume asks the server to send the frames following the last frame received in order again.
+func (s *clientStream) requestResume() {
+	payload, err := marshalReqResume(s.resume.acked())
+	if err != nil {
+		return
+	}
+	if r := s.publish(FrameResume, payload); r != nil {
+		s.log.Warn("failed to request resumption of stream", "subject", s.reqSubj, "error", r)
+	}
+}
+
+// replay sends the frames following the acknowledged sequence number again.
+func (s *clientStream) replay(ack uint64) {
+	err := s.resume.replay(ack, func(payload []byte) error {
+		return s.publishFrame(FrameReplay, payload)
+	})
+	if err != nil {
+		s.abort(err)
+	}
+}
+
+// closeStream is called once the stream is done. If the stream was canceled before it ended,
+// the server is notified. It answers with the end of the stream, which carries the trailer, so
+// the response subject is kept until it arrives or the teardown timeout passes.
+func (s *clientStream) closeStream() {
+	s.stats.end(toRPCErr(s.aborted.err(s.ctx)))
+	if !s.sendCancel() {
+		return
+	}
+	timer := time.NewTimer(teardownTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-s.chFinished:
+	case <-timer.C:
+	}
+}
+
+// sendCancel notifies the server that the stream was canceled before it ended.
+// It reports whether the server was notified.
+func (s *clientStream) sendCancel() bool {
+	if atomic.LoadUint32(&s.opened) == 0 || atomic.LoadUint32(&s.finished) == 1 {
+		return false
+	}
+
+	// the cancellation is sent on the cancel subject of the service, so it does not queue up
+	// behind messages of the stream the server has not consumed yet.
+	payload, err := marshalCancel(s.reqSubj)
+	if err != nil {
+		s.log.Error("failed to marshal cancel request", "method", s.method, "error", err)
+		return false
+	}
+	subj := s.subjects.MapSubject(cancelSubj(serviceName(s.method)))
+	s.cfg.tap.request(s.ctx, Frame{Direction: FrameSent, Method: s.method, Subject: subj, Data: payload})
+	if r := s.pub.Publish(pubsub.Message{
+		Subject: subj,
+		Data:    payload,
+	}); r != nil {
+		s.log.Error("failed to cancel stream", "method", s.method, "error", r)
+		return false
+	}
+	return true
+}
+
 // RecvMsg blocks until it receives a message into m or the stream is
 // done. It returns io.EOF when the stream completes successfully. On
 // any other error, the stream is aborted and the error contains the RPC
@@ -167,81 +576,309 @@ func (s *clientStream) sendMsg(subj string, payload []byte) error {
 // calling RecvMsg on the same stream at the same time, but it is not
 // safe to call RecvMsg on the same stream in different goroutines.
 func (s *clientStream) RecvMsg(target interface{}) error {
-	for {
-		resp, err := s.recvMsg(target)
-		if err != nil {
-			return err
-		}
-		if resp.HeaderOnly {
-			continue
-		}
+	if r := s.recv(target); r != nil {
+		return r
+	}
+	if s.serverStreams {
 		return nil
 	}
+
+	// like grpc, the response of a call without server stream is received along with the end
+	// of the stream, so the trailer is available once the response was received
+	err := s.recv(target)
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	if err == nil {
+		return status.Error(codes.Internal, "cardinality violation: expected <EOF> for non server-streaming RPCs, but received another message")
+	}
+	return err
+}
+
+func (s *clientStream) recv(target interface{}) error {
+	if r := s.recvMsg(target); r != nil {
+		return toRPCErr(r)
+	}
+	return toRPCErr(s.grantCredit())
 }
 
-func (s *clientStream) recvMsg(target interface{}) (*Response, error) {
+// grantCredit grants the server further credit once enough messages of the window were consumed.
+func (s *clientStream) grantCredit() error {
+	credit := s.recvWin.consume()
+	if credit == 0 {
+		return nil
+	}
+
+	payload, err := marshalReqCredit(credit)
+	if err != nil {
+		return err
+	}
+	return s.publish(FrameCredit, payload)
+}
+
+func (s *clientStream) recvMsg(target interface{}) error {
 	var recv *respMsg
 	select {
 	case <-s.ctx.Done():
-		return nil, s.ctx.Err()
+		return s.fail(s.aborted.err(s.ctx))
 	case recv = <-s.chRecv:
 	}
 
-	resp, err := unmarshalRespMsg(recv.data, target)
-	if err != nil {
-		return nil, err
+	if recv.err != nil {
+		return recv.err
 	}
+	resp := recv.resp
 	if resp.Eos {
+		s.finish(resp)
+		atomic.StoreUint32(&s.ended, 1)
+		var err error
+		if len(resp.Data) != 0 {
+			err = unmarshalErr(resp.Data)
+		}
+		s.stats.end(toRPCErr(err))
 		s.cancel()
-		if resp.Data != nil {
-			return nil, unmarshalErr(resp.Data)
+		s.applyAfterCall()
+		if err != nil {
+			return err
 		}
-		return nil, io.EOF
+		return io.EOF
 	}
-	if resp.Header != nil {
-		s.recvHeader = toMD(resp.Header)
+
+	codec, err := responseCodec(s.codec, resp.Codec)
+	if err != nil {
+		return s.fail(err)
 	}
-	if resp.Trailer != nil {
-		s.recvTrailer = toMD(resp.Trailer)
+	data, err := decode(codec, resp.Compressor, resp.Data, target, s.cfg.maxRecvMsgSize)
+	if err != nil {
+		return s.fail(err)
 	}
-	return resp, nil
+	s.stats.inPayload(target, data, recv.data)
+	return nil
 }
 
-// Subscribe subscribes to the server stream.
-func (s *clientStream) Subscribe(ctx context.Context) error {
-	queue := "receive"
+// fail cancels the stream with the error. Like at the end of the stream, the trailer is available
+// afterwards if the server ended the stream.
+func (s *clientStream) fail(err error) error {
+	s.stats.end(toRPCErr(err))
+	s.cancel()
+	s.awaitTeardown()
+	s.applyAfterCall()
+	return err
+}
 
-	s.ctx, s.cancel = context.WithCancel(ctx)
+// awaitTeardown waits for the end of the canceled stream, so the trailer is available if the server
+// ended the stream. The end of the stream might already be buffered. Otherwise, the server answers the
+// cancellation with it, so the client waits for the teardown timeout at most.
+func (s *clientStream) awaitTeardown() {
+	if atomic.LoadUint32(&s.opened) == 0 {
+		return
+	}
+	timer := time.NewTimer(teardownTimeout)
+	defer timer.Stop()
 
-	s.log.Infof("Subscribed Stream (client): Subject => %s, Queue => %s", s.respSubj, queue)
-	sub, err := s.sub.Subscribe(s.respSubj, queue, func(ctx context.Context, msg pubsub.Replier) {
-		// dbg.Cyan("server -> client (received)", msg.Subject(), msg.Data())
+	for {
 		select {
-		case <-s.ctx.Done():
+		case <-s.chFinished:
+			return
+		case recv := <-s.chRecv:
+			s.teardown(recv)
+		case <-timer.C:
 			return
-		case s.chRecv <- &respMsg{ctx: ctx, data: msg.Data()}:
-		default:
-			select {
-			case <-s.ctx.Done():
-				return
-			case <-ctx.Done():
-				s.cancel()
-				return
-			case s.chRecv <- &respMsg{ctx: ctx, data: msg.Data()}:
-			case <-time.After(stuckTimeout):
-				s.log.Errorf("Stream: Subject => %s, Queue => %s: closing stream: "+
-					"client stream consumer stuck for 30sec", s.respSubj, queue)
-				s.cancel()
-			}
 		}
-	})
+	}
+}
+
+// teardown handles a message received after the stream was canceled. Only the end of the stream is of interest.
+func (s *clientStream) teardown(recv *respMsg) {
+	if recv.err == nil && recv.resp.Eos {
+		s.finish(recv.resp)
+	}
+}
+
+// readResp unmarshals a received response. Chunks are collected until the response is complete.
+// It returns the data of the complete response or nil if more chunks are expected.
+func (s *clientStream) readResp(data []byte) ([]byte, *Response, error) {
+	resp, err := unmarshalResp(data)
+	if err != nil {
+		return data, resp, err
+	}
+	if debugEnabled(s.log) {
+		s.log.Debug("received frame", "subject", s.respSubj, "frame", respFrame(resp))
+	}
+	if resp.Chunk == nil {
+		s.cfg.tap.response(s.ctx, Frame{Direction: FrameReceived, Method: s.method, Subject: s.respSubj, Data: data})
+		return data, resp, nil
+	}
+
+	data, complete, err := s.chunks.add(resp.Chunk)
+	if err != nil {
+		return []byte{}, nil, err
+	}
+	if !complete {
+		return nil, nil, nil
+	}
+	s.cfg.tap.response(s.ctx, Frame{Direction: FrameReceived, Method: s.method, Subject: s.respSubj, Data: data})
+	resp, err = unmarshalResp(data)
+	return data, resp, err
+}
+
+// Subscribe subscribes to the server stream.
+func (s *clientStream) Subscribe(ctx context.Context) error {
+	s.ctx, s.cancel = context.WithCancel(context.WithValue(ctx, streamKey{}, introspectable(s)))
+
+	s.log.Debug("subscribed client stream", "subject", s.respSubj, "queue", s.cfg.streamQueue)
+	sub, err := s.subscribe()
 	if err != nil {
 		return err
 	}
-	go func() {
-		<-s.ctx.Done()
-		_ = sub.Unsubscribe()
-	}()
+	go s.resume.watch(s.ctx, sub, s.closeStream, s.subscribe, s.requestResume, s.abort)
 
 	return err
 }
+
+func (s *clientStream) subscribe() (pubsub.Subscription, error) {
+	if s.mux != nil {
+		return s.mux.subscribe(s.respSubj, s.streamID, s.receive)
+	}
+	return s.sub.Subscribe(s.respSubj, s.cfg.streamQueue, s.receive)
+}
+
+// receive handles a message received on the response subject.
+func (s *clientStream) receive(ctx context.Context, msg pubsub.Replier) {
+	// dbg.Cyan("server -> client (received)", msg.Subject(), msg.Data())
+	if s.dedup.duplicate(msg) {
+		observeDuplicate(s.cfg.observer, s.method)
+		return
+	}
+	s.activity.receivedFrame(len(msg.Data()))
+	s.keepalive.received()
+	data, resp, err := s.readResp(msg.Data())
+	if data == nil {
+		// incomplete chunked message
+		return
+	}
+	if err != nil {
+		s.deliver(ctx, data, resp, err)
+		return
+	}
+	if !s.accept(resp) {
+		return
+	}
+	if r := s.order.receive(resp.Seq, func() { s.process(ctx, data, resp) }); r != nil {
+		s.log.Error("aborting stream: client stream received frames out of order", "subject", s.respSubj, "error", r)
+		s.abort(r)
+	}
+}
+
+// process delivers a received response or the responses of a received batch.
+func (s *clientStream) process(ctx context.Context, data []byte, resp *Response) {
+	if len(resp.Batch) != 0 {
+		for _, frame := range resp.Batch {
+			resp, err := unmarshalResp(frame)
+			s.deliver(ctx, frame, resp, err)
+		}
+		return
+	}
+	s.deliver(ctx, data, resp, nil)
+}
+
+// deliver handles a received response, which is either a credit grant, a header frame or buffered to be
+// received.
+func (s *clientStream) deliver(ctx context.Context, data []byte, resp *Response, err error) {
+	if err == nil && resp.Credit != 0 {
+		s.sendWin.add(int(resp.Credit))
+		return
+	}
+	if err == nil {
+		s.setHeader(resp)
+		if resp.HeaderOnly {
+			return
+		}
+	}
+
+	recv := &respMsg{ctx: ctx, data: data, resp: resp, err: err}
+	select {
+	case <-s.ctx.Done():
+		s.teardown(recv)
+		return
+	case s.chRecv <- recv:
+	default:
+		if !s.overflow(ctx, recv) {
+			return
+		}
+	}
+	s.cfg.observer.ObserveQueueDepth(s.method, len(s.chRecv))
+}
+
+// overflow buffers a message received while the receive buffer is full according to the overflow policy.
+// It reports whether the message was buffered.
+func (s *clientStream) overflow(ctx context.Context, recv *respMsg) bool {
+	switch s.cfg.recvBuffer.Overflow {
+	case OverflowAbort:
+		s.log.Error("aborting stream: client stream receive buffer overflowed", "subject", s.respSubj, "size", cap(s.chRecv))
+		s.abort(errRecvOverflow(cap(s.chRecv)))
+		return false
+	case OverflowDropOldest:
+		for {
+			select {
+			case s.chRecv <- recv:
+				return true
+			default:
+			}
+			select {
+			case old := <-s.chRecv:
+				if old.final() {
+					s.abort(errRecvOverflow(cap(s.chRecv)))
+					return false
+				}
+				s.cfg.recvBuffer.dropped(s.ctx, s.method)
+			default:
+			}
+		}
+	}
+
+	s.cfg.events.slowConsumer(s)
+	select {
+	case <-s.ctx.Done():
+		s.teardown(recv)
+		return false
+	case <-ctx.Done():
+		s.cancel()
+		return false
+	case s.chRecv <- recv:
+		return true
+	case <-time.After(s.cfg.stuckTimeout):
+		s.log.Error("closing stream: client stream consumer stuck",
+			"subject", s.respSubj, "queue", s.cfg.streamQueue, "timeout", s.cfg.stuckTimeout)
+		s.cfg.observer.ConsumerStuck(s.method)
+		s.cfg.events.stuck(s)
+		s.cancel()
+		return false
+	}
+}
+
+// accept handles pings and requests to resume the stream and checks the order of the other frames.
+// It reports whether the response should be processed further.
+func (s *clientStream) accept(resp *Response) bool {
+	switch {
+	case resp.Ping:
+		if s.resume.advertised(resp.LastSeq) {
+			s.requestResume()
+		}
+		return false
+	case resp.Resume:
+		s.replay(resp.Ack)
+		return false
+	}
+
+	if duplicateFrame(s.dedup, s.resume, resp.Seq) {
+		observeDuplicate(s.cfg.observer, s.method)
+		return false
+	}
+
+	next, missing := s.resume.receive(resp.Seq)
+	if missing {
+		s.requestResume()
+	}
+	return next
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/tehsphinx/nrpc/dynamic"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// caller calls a method with the requests given as JSON and prints the responses.
+type caller struct {
+	client       *dynamic.Client
+	out          io.Writer
+	emitDefaults bool
+	verbose      bool
+}
+
+func (c *caller) call(ctx context.Context, method, data string) error {
+	md, err := c.client.FindMethod(ctx, method)
+	if err != nil {
+		return err
+	}
+	reqs, err := dynamic.ParseRequests(md, []byte(data))
+	if err != nil {
+		return err
+	}
+
+	if !md.IsStreamingClient() && !md.IsStreamingServer() {
+		var header, trailer metadata.MD
+		resp, err := c.client.Invoke(ctx, md, reqs[0], grpc.Header(&header), grpc.Trailer(&trailer))
+		c.printMD("Response headers", header)
+		if err == nil {
+			err = c.print(resp)
+		}
+		c.printMD("Response trailers", trailer)
+		return err
+	}
+
+	stream, err := c.client.NewStream(ctx, md)
+	if err != nil {
+		return err
+	}
+	for _, req := range reqs {
+		if r := stream.Send(req); r != nil {
+			break
+		}
+	}
+	if r := stream.CloseSend(); r != nil {
+		return r
+	}
+
+	header, _ := stream.Header()
+	c.printMD("Response headers", header)
+	for {
+		resp, r := stream.Recv()
+		if r != nil {
+			err = r
+			break
+		}
+		if r := c.print(resp); r != nil {
+			return r
+		}
+	}
+	c.printMD("Response trailers", stream.Trailer())
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
+
+func (c *caller) print(msg proto.Message) error {
+	data, err := protojson.MarshalOptions{
+		Multiline:       true,
+		Indent:          "  ",
+		EmitUnpopulated: c.emitDefaults,
+	}.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal the response: %w", err)
+	}
+	_, err = fmt.Fprintln(c.out, string(data))
+	return err
+}
+
+func (c *caller) printMD(title string, md metadata.MD) {
+	if !c.verbose {
+		return
+	}
+	keys := make([]string, 0, len(md))
+	for key := range md {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fmt.Fprintf(c.out, "\n%s:\n", title)
+	for _, key := range keys {
+		for _, v := range md[key] {
+			fmt.Fprintf(c.out, "%s: %s\n", key, v)
+		}
+	}
+	fmt.Fprintln(c.out)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/tehsphinx/nrpc/dynamic"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// list prints the services or the methods of the service given in args.
+func list(ctx context.Context, src dynamic.Source, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		names, err := src.Services(ctx)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			fmt.Fprintln(out, name)
+		}
+		return nil
+	}
+
+	d, err := src.FindSymbol(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	sd, ok := d.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return fmt.Errorf("%s is not a service", args[0])
+	}
+	for i := 0; i < sd.Methods().Len(); i++ {
+		fmt.Fprintln(out, sd.Methods().Get(i).FullName())
+	}
+	return nil
+}
+
+// describe prints the service, method, message or enum in proto syntax.
+func describe(ctx context.Context, src dynamic.Source, symbol string, out io.Writer) error {
+	d, err := src.FindSymbol(ctx, strings.Replace(symbol, "/", ".", 1))
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "%s is a %s:\n", d.FullName(), kind(d))
+	switch d := d.(type) {
+	case protoreflect.ServiceDescriptor:
+		fmt.Fprintf(out, "service %s {\n", d.Name())
+		for i := 0; i < d.Methods().Len(); i++ {
+			fmt.Fprintf(out, "  %s\n", rpcSignature(d.Methods().Get(i)))
+		}
+		fmt.Fprintln(out, "}")
+	case protoreflect.MethodDescriptor:
+		fmt.Fprintln(out, rpcSignature(d))
+	case protoreflect.MessageDescriptor:
+		fmt.Fprintf(out, "message %s {\n", d.Name())
+		for i := 0; i < d.Fields().Len(); i++ {
+			fd := d.Fields().Get(i)
+			fmt.Fprintf(out, "  %s%s %s = %d;\n", label(fd), fieldType(fd), fd.Name(), fd.Number())
+		}
+		fmt.Fprintln(out, "}")
+	case protoreflect.EnumDescriptor:
+		fmt.Fprintf(out, "enum %s {\n", d.Name())
+		for i := 0; i < d.Values().Len(); i++ {
+			v := d.Values().Get(i)
+			fmt.Fprintf(out, "  %s = %d;\n", v.Name(), v.Number())
+		}
+		fmt.Fprintln(out, "}")
+	}
+	return nil
+}
+
+func kind(d protoreflect.Descriptor) string {
+	switch d.(type) {
+	case protoreflect.ServiceDescriptor:
+		return "service"
+	case protoreflect.MethodDescriptor:
+		return "method"
+	case protoreflect.MessageDescriptor:
+		return "message"
+	case protoreflect.EnumDescriptor:
+		return "enum"
+	case protoreflect.FieldDescriptor:
+		return "field"
+	}
+	return "symbol"
+}
+
+func rpcSignature(md protoreflect.MethodDescriptor) string {
+	var in, out string
+	if md.IsStreamingClient() {
+		in = "stream "
+	}
+	if md.IsStreamingServer() {
+		out = "stream "
+	}
+	return fmt.Sprintf("rpc %s ( %s.%s ) returns ( %s.%s );", md.Name(), in, md.Input().FullName(), out, md.Output().FullName())
+}
+
+func label(fd protoreflect.FieldDescriptor) string {
+	switch {
+	case fd.IsMap():
+		return ""
+	case fd.IsList():
+		return "repeated "
+	case fd.HasOptionalKeyword():
+		return "optional "
+	}
+	return ""
+}
+
+func fieldType(fd protoreflect.FieldDescriptor) string {
+	switch {
+	case fd.IsMap():
+		return fmt.Sprintf("map<%s, %s>", fieldType(fd.MapKey()), fieldType(fd.MapValue()))
+	case fd.Message() != nil:
+		return "." + string(fd.Message().FullName())
+	case fd.Enum() != nil:
+		return "." + string(fd.Enum().FullName())
+	}
+	return fd.Kind().String()
+}
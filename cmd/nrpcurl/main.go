@@ -0,0 +1,206 @@
+// Command nrpcurl calls the methods of nrpc services from the command line, like grpcurl does for gRPC
+// servers. The requests and responses are given and printed as JSON:
+//
+//	nrpcurl -server nats://localhost:4222 list
+//	nrpcurl describe pkg.Greeter
+//	nrpcurl -d '{"name": "world"}' -H 'authorization: Bearer token' pkg.Greeter/SayHello
+//	echo '{"name": "a"} {"name": "b"}' | nrpcurl -d @ pkg.Greeter/SayHelloToAll
+//
+// The descriptors of the services are fetched from the server reflection service (see package reflection)
+// or read from files with a FileDescriptorSet, as created by protoc --descriptor_set_out --include_imports.
+// The subjects set with the options of nrpcpb/options.proto are honored.
+//
+// For client and bidirectional streams the request data is a sequence of JSON messages, for unary and
+// server streaming methods a single message. If the call fails, the status is printed and nrpcurl exits
+// with code 64 plus the status code.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	natsgo "github.com/nats-io/nats.go"
+	"github.com/tehsphinx/nrpc"
+	"github.com/tehsphinx/nrpc/dynamic"
+	"github.com/tehsphinx/nrpc/pubsub/nats"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+const usage = `Usage:
+	nrpcurl [flags] list [service]
+	nrpcurl [flags] describe <symbol>
+	nrpcurl [flags] <package.Service/Method>
+
+Flags:
+`
+
+// stringList collects the values of a flag given multiple times.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+type config struct {
+	server         string
+	protosets      stringList
+	headers        stringList
+	data           string
+	maxTime        time.Duration
+	connectTimeout time.Duration
+	emitDefaults   bool
+	verbose        bool
+}
+
+func main() {
+	var cfg config
+	flag.StringVar(&cfg.server, "server", natsgo.DefaultURL, "URL of the NATS server")
+	flag.Var(&cfg.protosets, "protoset", "file with a FileDescriptorSet of the services, can be given multiple times; "+
+		"without it the descriptors are fetched from the server reflection service")
+	flag.Var(&cfg.headers, "H", "metadata sent with the call as 'name: value', can be given multiple times")
+	flag.StringVar(&cfg.data, "d", "", "request data as JSON; @ reads it from stdin")
+	flag.DurationVar(&cfg.maxTime, "max-time", 0, "deadline of the call or of the lookups of list and describe")
+	flag.DurationVar(&cfg.connectTimeout, "connect-timeout", 10*time.Second, "timeout to connect to the NATS server")
+	flag.BoolVar(&cfg.emitDefaults, "emit-defaults", false, "print fields with default values")
+	flag.BoolVar(&cfg.verbose, "v", false, "print the header and trailer metadata of the response")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(cfg, flag.Args(), os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR:")
+		if st, ok := status.FromError(err); ok {
+			fmt.Fprintf(os.Stderr, "  Code: %v\n  Message: %s\n", st.Code(), st.Message())
+			if st.Code() != codes.OK {
+				os.Exit(64 + int(st.Code()))
+			}
+		} else {
+			fmt.Fprintf(os.Stderr, "  %v\n", err)
+		}
+		os.Exit(1)
+	}
+}
+
+// run runs the command of the args, reading the request data from stdin for -d @ and printing to out.
+func run(cfg config, args []string, stdin io.Reader, out io.Writer) error {
+	conn, err := natsgo.Connect(cfg.server, natsgo.Timeout(cfg.connectTimeout))
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", cfg.server, err)
+	}
+	defer conn.Close()
+
+	client := nrpc.NewClient(nats.Publisher(conn), nats.Subscriber(conn))
+
+	ctx := context.Background()
+	md, err := parseHeaders(cfg.headers)
+	if err != nil {
+		return err
+	}
+	ctx = metadata.NewOutgoingContext(ctx, md)
+	if cfg.maxTime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.maxTime)
+		defer cancel()
+	}
+
+	var src dynamic.Source
+	if len(cfg.protosets) != 0 {
+		src, err = readProtosets(cfg.protosets)
+		if err != nil {
+			return err
+		}
+	} else {
+		src = dynamic.NewReflectionSource(client)
+	}
+
+	switch args[0] {
+	case "list":
+		if len(args) > 2 {
+			return fmt.Errorf("too many arguments for list")
+		}
+		return list(ctx, src, args[1:], out)
+	case "describe":
+		if len(args) != 2 {
+			return fmt.Errorf("describe expects a symbol")
+		}
+		return describe(ctx, src, args[1], out)
+	}
+
+	if len(args) != 1 {
+		return fmt.Errorf("too many arguments")
+	}
+	data, err := requestData(cfg.data, stdin)
+	if err != nil {
+		return err
+	}
+	c := &caller{
+		client:       dynamic.NewClient(client, src),
+		out:          out,
+		emitDefaults: cfg.emitDefaults,
+		verbose:      cfg.verbose,
+	}
+	return c.call(ctx, args[0], data)
+}
+
+// parseHeaders parses the -H flags of the form "name: value" into metadata.
+func parseHeaders(headers []string) (metadata.MD, error) {
+	md := metadata.MD{}
+	for _, h := range headers {
+		i := strings.IndexByte(h, ':')
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid header %q: expected 'name: value'", h)
+		}
+		md.Append(strings.TrimSpace(h[:i]), strings.TrimSpace(h[i+1:]))
+	}
+	return md, nil
+}
+
+// requestData returns the data given with the -d flag, reading it from stdin for @.
+func requestData(data string, stdin io.Reader) (string, error) {
+	if data != "@" {
+		return data, nil
+	}
+	b, err := io.ReadAll(stdin)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the request data from stdin: %w", err)
+	}
+	return string(b), nil
+}
+
+// readProtosets reads the FileDescriptorSets of the files.
+func readProtosets(paths []string) (dynamic.Source, error) {
+	sets := make([]*descriptorpb.FileDescriptorSet, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read protoset: %w", err)
+		}
+		var set descriptorpb.FileDescriptorSet
+		if r := proto.Unmarshal(data, &set); r != nil {
+			return nil, fmt.Errorf("failed to parse protoset %s: %w", path, r)
+		}
+		sets = append(sets, &set)
+	}
+	return dynamic.NewDescriptorSetSource(sets...)
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	natsgo "github.com/nats-io/nats.go"
+	"github.com/tehsphinx/nrpc"
+	"github.com/tehsphinx/nrpc/dynamic"
+	"github.com/tehsphinx/nrpc/pubsub/nats"
+	"github.com/tehsphinx/nrpc/testproto"
+	"github.com/tehsphinx/nrpc/testproto/testserver"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func TestParseHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers []string
+		want    metadata.MD
+		wantErr bool
+	}{
+		{name: "none", want: metadata.MD{}},
+		{
+			name:    "trimmed",
+			headers: []string{"Authorization:  Bearer token ", "x-id:1"},
+			want:    metadata.MD{"authorization": {"Bearer token"}, "x-id": {"1"}},
+		},
+		{
+			name:    "repeated",
+			headers: []string{"key: a", "key: b"},
+			want:    metadata.MD{"key": {"a", "b"}},
+		},
+		{name: "value with colon", headers: []string{"url: http://host"}, want: metadata.MD{"url": {"http://host"}}},
+		{name: "empty value", headers: []string{"key:"}, want: metadata.MD{"key": {""}}},
+		{name: "missing colon", headers: []string{"key value"}, wantErr: true},
+		{name: "missing name", headers: []string{": value"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			asrt := is.New(t)
+
+			md, err := parseHeaders(tt.headers)
+			if tt.wantErr {
+				asrt.True(err != nil)
+				return
+			}
+			asrt.NoErr(err)
+			asrt.Equal(md, tt.want)
+		})
+	}
+}
+
+func TestRequestData(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		stdin string
+		want  string
+	}{
+		{name: "flag", data: `{"msg": "a"}`, stdin: `{"msg": "b"}`, want: `{"msg": "a"}`},
+		{name: "stdin", data: "@", stdin: `{"msg": "b"} {"msg": "c"}`, want: `{"msg": "b"} {"msg": "c"}`},
+		{name: "empty", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			asrt := is.New(t)
+
+			data, err := requestData(tt.data, strings.NewReader(tt.stdin))
+			asrt.NoErr(err)
+			asrt.Equal(data, tt.want)
+		})
+	}
+}
+
+// writeProtoset writes the FileDescriptorSet of the test services and their dependencies to a file.
+func writeProtoset(t *testing.T) string {
+	t.Helper()
+
+	set := &descriptorpb.FileDescriptorSet{}
+	seen := map[string]bool{}
+	var add func(fd protoreflect.FileDescriptor)
+	add = func(fd protoreflect.FileDescriptor) {
+		if seen[fd.Path()] {
+			return
+		}
+		seen[fd.Path()] = true
+		for i := 0; i < fd.Imports().Len(); i++ {
+			add(fd.Imports().Get(i).FileDescriptor)
+		}
+		set.File = append(set.File, protodesc.ToFileDescriptorProto(fd))
+	}
+	add(testproto.File_testproto_test_proto)
+
+	data, err := proto.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "test.protoset")
+	if r := os.WriteFile(path, data, 0o600); r != nil {
+		t.Fatal(r)
+	}
+	return path
+}
+
+func TestReadProtosets(t *testing.T) {
+	asrt := is.New(t)
+	ctx := context.Background()
+
+	src, err := readProtosets([]string{writeProtoset(t)})
+	asrt.NoErr(err)
+	d, err := src.FindSymbol(ctx, "testproto.Test")
+	asrt.NoErr(err)
+	_, ok := d.(protoreflect.ServiceDescriptor)
+	asrt.True(ok)
+
+	_, err = readProtosets([]string{filepath.Join(t.TempDir(), "missing.protoset")})
+	asrt.True(err != nil)
+
+	invalid := filepath.Join(t.TempDir(), "invalid.protoset")
+	asrt.NoErr(os.WriteFile(invalid, []byte("not a protoset"), 0o600))
+	_, err = readProtosets([]string{invalid})
+	asrt.True(err != nil)
+	asrt.True(strings.Contains(err.Error(), "failed to parse protoset"))
+}
+
+// compact collapses the white space of the printed output, which protojson varies on purpose.
+func compact(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func TestCall(t *testing.T) {
+	asrt := is.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub, sub := nats.Publisher(conn), nats.Subscriber(conn)
+	server, _, err := testserver.New(pub, sub)
+	asrt.NoErr(err)
+	defer server.Stop()
+
+	src, err := readProtosets([]string{writeProtoset(t)})
+	asrt.NoErr(err)
+	client := dynamic.NewClient(nrpc.NewClient(pub, sub), src)
+
+	t.Run("unary", func(t *testing.T) {
+		asrt := asrt.New(t)
+
+		var out bytes.Buffer
+		c := &caller{client: client, out: &out, verbose: true}
+		callCtx := metadata.NewOutgoingContext(ctx, metadata.Pairs("key", "value"))
+		asrt.NoErr(c.call(callCtx, "testproto.Test/Unary", `{"msg": "Hello via NRPC"}`))
+
+		printed := out.String()
+		asrt.True(strings.Contains(printed, "Response headers:\n"))
+		asrt.True(strings.Contains(printed, "srv-key: srv-value\n"))
+		asrt.True(strings.Contains(compact(printed), `"msg": "Hello back!"`))
+		asrt.True(strings.Contains(printed, "Response trailers:\ntraily: t-value\n"))
+	})
+	t.Run("unary error", func(t *testing.T) {
+		asrt := asrt.New(t)
+
+		var out bytes.Buffer
+		c := &caller{client: client, out: &out}
+		err := c.call(ctx, "testproto.Test/Unary", `{"msg": "unexpected"}`)
+		asrt.Equal(status.Code(err), codes.InvalidArgument)
+		asrt.Equal(out.String(), "")
+	})
+	t.Run("bidi stream", func(t *testing.T) {
+		asrt := asrt.New(t)
+
+		var out bytes.Buffer
+		c := &caller{client: client, out: &out}
+		data := `{"msg": "Hello via NRPC 1"} {"msg": "Hello via NRPC 2"} {"msg": "Hello via NRPC 3"}`
+		asrt.NoErr(c.call(ctx, "testproto.Test/BiDiStream", data))
+
+		printed := compact(out.String())
+		for _, want := range []string{`"msg": "Hello back! 1"`, `"msg": "Hello back! 2"`, `"msg": "Hello back! 3"`} {
+			asrt.True(strings.Contains(printed, want))
+		}
+		asrt.Equal(strings.Count(printed, `"msg"`), 3)
+	})
+	t.Run("unknown method", func(t *testing.T) {
+		asrt := asrt.New(t)
+
+		c := &caller{client: client, out: &bytes.Buffer{}}
+		asrt.True(c.call(ctx, "testproto.Test/Missing", `{}`) != nil)
+	})
+}
+
+func TestMaxTime(t *testing.T) {
+	asrt := is.New(t)
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	// the reflection service receives the requests but never replies
+	s, err := conn.Subscribe(">", func(*natsgo.Msg) {})
+	asrt.NoErr(err)
+	defer s.Unsubscribe()
+	asrt.NoErr(conn.Flush())
+
+	for _, args := range [][]string{{"list"}, {"describe", "testproto.Test"}} {
+		t.Run(args[0], func(t *testing.T) {
+			asrt := asrt.New(t)
+
+			cfg := config{server: conn.ConnectedUrl(), maxTime: 100 * time.Millisecond, connectTimeout: time.Second}
+			start := time.Now()
+			err := run(cfg, args, strings.NewReader(""), &bytes.Buffer{})
+			asrt.Equal(status.Code(err), codes.DeadlineExceeded)
+			asrt.True(time.Since(start) < 2*time.Second)
+		})
+	}
+}
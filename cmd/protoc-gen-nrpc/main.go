@@ -0,0 +1,54 @@
+// Command protoc-gen-nrpc is a protoc plugin generating nrpc clients and servers for the services
+// of a proto file. It is installed with
+//
+//	go install github.com/tehsphinx/nrpc/cmd/protoc-gen-nrpc
+//
+// and invoked along with protoc-gen-go:
+//
+//	protoc --go_out=. --go_opt=paths=source_relative \
+//		--nrpc_out=. --nrpc_opt=paths=source_relative \
+//		-I . -I $(go list -m -f '{{.Dir}}' github.com/tehsphinx/nrpc) foo.proto
+//
+// For every service Foo it generates a typed client on top of *nrpc.Client (NewFooNRPCClient),
+// the server interface FooNRPCServer and its registration on *nrpc.Server (RegisterFooNRPCServer).
+// The subjects the methods are served on can be customized and unary methods can be marked as one-way
+// with the options of nrpcpb/options.proto.
+//
+// With --nrpc_opt=iterators=true the receiving streams get a Messages method returning a range-over-func
+// iterator over the received messages (see the typed package). The generated files then require Go 1.23.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+const version = "1.0.0"
+
+func main() {
+	showVersion := flag.Bool("version", false, "print the version and exit")
+	flag.Parse()
+	if *showVersion {
+		fmt.Printf("protoc-gen-nrpc %v\n", version)
+		os.Exit(0)
+	}
+
+	var flags flag.FlagSet
+	iterators := flags.Bool("iterators", false, "generate range-over-func iterators receiving the messages of streams (requires Go 1.23)")
+	protogen.Options{ParamFunc: flags.Set}.Run(func(gen *protogen.Plugin) error {
+		gen.SupportedFeatures = uint64(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL)
+		for _, f := range gen.Files {
+			if !f.Generate {
+				continue
+			}
+			if r := generateFile(gen, f, *iterators); r != nil {
+				return r
+			}
+		}
+		return nil
+	})
+}
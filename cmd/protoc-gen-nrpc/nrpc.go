@@ -0,0 +1,473 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/tehsphinx/nrpc/nrpcpb"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	contextPackage = protogen.GoImportPath("context")
+	grpcPackage    = protogen.GoImportPath("google.golang.org/grpc")
+	codesPackage   = protogen.GoImportPath("google.golang.org/grpc/codes")
+	statusPackage  = protogen.GoImportPath("google.golang.org/grpc/status")
+	nrpcPackage    = protogen.GoImportPath("github.com/tehsphinx/nrpc")
+	typedPackage   = protogen.GoImportPath("github.com/tehsphinx/nrpc/typed")
+	iterPackage    = protogen.GoImportPath("iter")
+)
+
+// generateFile generates the _nrpc.pb.go file of a proto file containing services. With iterators the
+// receiving streams get iterators over their messages, which requires Go 1.23.
+func generateFile(gen *protogen.Plugin, file *protogen.File, iterators bool) error {
+	if len(file.Services) == 0 {
+		return nil
+	}
+
+	g := gen.NewGeneratedFile(file.GeneratedFilenamePrefix+"_nrpc.pb.go", file.GoImportPath)
+	if iterators {
+		g.P("//go:build go1.23")
+		g.P()
+	}
+	g.P("// Code generated by protoc-gen-nrpc. DO NOT EDIT.")
+	g.P("// versions:")
+	g.P("// - protoc-gen-nrpc v", version)
+	g.P("// source: ", file.Desc.Path())
+	g.P()
+	g.P("package ", file.GoPackageName)
+	g.P()
+
+	if r := generateRegistrations(g, file); r != nil {
+		return r
+	}
+	for _, service := range file.Services {
+		generateService(g, file, service, iterators)
+	}
+	return nil
+}
+
+// generateRegistrations registers the custom subjects and the one-way methods.
+func generateRegistrations(g *protogen.GeneratedFile, file *protogen.File) error {
+	var lines []string
+	for _, service := range file.Services {
+		for _, method := range service.Methods {
+			subj, err := methodSubject(service, method)
+			if err != nil {
+				return err
+			}
+			if subj != "" {
+				lines = append(lines, fmt.Sprintf("%s(%q, %q)", g.QualifiedGoIdent(nrpcPackage.Ident("RegisterSubject")),
+					fullMethod(service, method), subj))
+			}
+
+			oneWay, err := methodOneWay(method)
+			if err != nil {
+				return err
+			}
+			if oneWay {
+				lines = append(lines, fmt.Sprintf("%s(%q)", g.QualifiedGoIdent(nrpcPackage.Ident("RegisterOneWay")),
+					fullMethod(service, method)))
+			}
+		}
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+
+	g.P("func init() {")
+	for _, line := range lines {
+		g.P(line)
+	}
+	g.P("}")
+	g.P()
+	return nil
+}
+
+// methodSubject returns the custom subject of the method or an empty string if the default subject is used.
+func methodSubject(service *protogen.Service, method *protogen.Method) (string, error) {
+	subj := proto.GetExtension(method.Desc.Options(), nrpcpb.E_Subject).(string)
+	if subj == "" {
+		prefix := proto.GetExtension(service.Desc.Options(), nrpcpb.E_SubjectPrefix).(string)
+		if prefix == "" {
+			return "", nil
+		}
+		subj = prefix + "." + string(method.Desc.Name())
+	}
+
+	for _, token := range strings.Split(subj, ".") {
+		if token == "" || strings.ContainsAny(token, "*> \t\r\n") {
+			return "", fmt.Errorf("%s: invalid subject %q", method.Desc.FullName(), subj)
+		}
+	}
+	return subj, nil
+}
+
+// methodOneWay reports whether the method is marked as one-way. Only unary methods can be one-way.
+func methodOneWay(method *protogen.Method) (bool, error) {
+	if !proto.GetExtension(method.Desc.Options(), nrpcpb.E_OneWay).(bool) {
+		return false, nil
+	}
+	if method.Desc.IsStreamingClient() || method.Desc.IsStreamingServer() {
+		return false, fmt.Errorf("%s: streaming methods cannot be one-way", method.Desc.FullName())
+	}
+	return true, nil
+}
+
+func fullMethod(service *protogen.Service, method *protogen.Method) string {
+	return fmt.Sprintf("/%s/%s", service.Desc.FullName(), method.Desc.Name())
+}
+
+func generateService(g *protogen.GeneratedFile, file *protogen.File, service *protogen.Service, iterators bool) {
+	clientName := service.GoName + "NRPCClient"
+	serverName := service.GoName + "NRPCServer"
+	descName := service.GoName + "_NRPCServiceDesc"
+
+	// client
+	g.P("// ", clientName, " is the nrpc client API for the ", service.GoName, " service.")
+	g.P("type ", clientName, " interface {")
+	for _, method := range service.Methods {
+		g.P(method.Comments.Leading, clientSignature(g, service, method))
+	}
+	g.P("}")
+	g.P()
+	g.P("type ", unexport(clientName), " struct {")
+	g.P("c *", nrpcPackage.Ident("Client"))
+	g.P("}")
+	g.P()
+	g.P("// New", clientName, " creates a client of the ", service.GoName, " service sending its calls through the nrpc client.")
+	g.P("func New", clientName, "(c *", nrpcPackage.Ident("Client"), ") ", clientName, " {")
+	g.P("return &", unexport(clientName), "{c}")
+	g.P("}")
+	g.P()
+
+	streamIndex := 0
+	for _, method := range service.Methods {
+		if !method.Desc.IsStreamingClient() && !method.Desc.IsStreamingServer() {
+			generateUnaryClientMethod(g, service, method)
+			continue
+		}
+		generateStreamClientMethod(g, service, method, streamIndex, iterators)
+		streamIndex++
+	}
+
+	// server
+	g.P("// ", serverName, " is the nrpc server API for the ", service.GoName, " service.")
+	g.P("type ", serverName, " interface {")
+	for _, method := range service.Methods {
+		g.P(method.Comments.Leading, serverSignature(g, service, method))
+	}
+	g.P("}")
+	g.P()
+	g.P("// Unimplemented", serverName, " can be embedded to have forward compatible implementations.")
+	g.P("type Unimplemented", serverName, " struct{}")
+	g.P()
+	for _, method := range service.Methods {
+		nilArg := ""
+		if !method.Desc.IsStreamingClient() && !method.Desc.IsStreamingServer() {
+			nilArg = "nil, "
+		}
+		g.P("func (Unimplemented", serverName, ") ", serverSignature(g, service, method), " {")
+		g.P("return ", nilArg, statusPackage.Ident("Errorf"), "(", codesPackage.Ident("Unimplemented"),
+			`, "method `, method.GoName, ` not implemented")`)
+		g.P("}")
+		g.P()
+	}
+	g.P("// Register", serverName, " registers the implementation of the ", service.GoName, " service on the nrpc server.")
+	g.P("func Register", serverName, "(s *", nrpcPackage.Ident("Server"), ", srv ", serverName, ") {")
+	g.P("s.RegisterService(&", descName, ", srv)")
+	g.P("}")
+	g.P()
+
+	for _, method := range service.Methods {
+		if !method.Desc.IsStreamingClient() && !method.Desc.IsStreamingServer() {
+			generateUnaryHandler(g, service, method)
+			continue
+		}
+		generateStreamHandler(g, service, method, iterators)
+	}
+
+	// service descriptor
+	g.P("// ", descName, " is the grpc.ServiceDesc of the ", service.GoName, " service used by Register", serverName, ".")
+	g.P("var ", descName, " = ", grpcPackage.Ident("ServiceDesc"), "{")
+	g.P("ServiceName: ", fmt.Sprintf("%q", service.Desc.FullName()), ",")
+	g.P("HandlerType: (*", serverName, ")(nil),")
+	g.P("Methods: []", grpcPackage.Ident("MethodDesc"), "{")
+	for _, method := range service.Methods {
+		if method.Desc.IsStreamingClient() || method.Desc.IsStreamingServer() {
+			continue
+		}
+		g.P("{")
+		g.P("MethodName: ", fmt.Sprintf("%q", method.Desc.Name()), ",")
+		g.P("Handler: ", handlerName(service, method), ",")
+		g.P("},")
+	}
+	g.P("},")
+	g.P("Streams: []", grpcPackage.Ident("StreamDesc"), "{")
+	for _, method := range service.Methods {
+		if !method.Desc.IsStreamingClient() && !method.Desc.IsStreamingServer() {
+			continue
+		}
+		g.P("{")
+		g.P("StreamName: ", fmt.Sprintf("%q", method.Desc.Name()), ",")
+		g.P("Handler: ", handlerName(service, method), ",")
+		if method.Desc.IsStreamingServer() {
+			g.P("ServerStreams: true,")
+		}
+		if method.Desc.IsStreamingClient() {
+			g.P("ClientStreams: true,")
+		}
+		g.P("},")
+	}
+	g.P("},")
+	g.P("Metadata: ", fmt.Sprintf("%q", file.Desc.Path()), ",")
+	g.P("}")
+	g.P()
+}
+
+func clientSignature(g *protogen.GeneratedFile, service *protogen.Service, method *protogen.Method) string {
+	s := method.GoName + "(ctx " + g.QualifiedGoIdent(contextPackage.Ident("Context"))
+	if !method.Desc.IsStreamingClient() {
+		s += ", in *" + g.QualifiedGoIdent(method.Input.GoIdent)
+	}
+	s += ", opts ..." + g.QualifiedGoIdent(grpcPackage.Ident("CallOption")) + ") "
+	if !method.Desc.IsStreamingClient() && !method.Desc.IsStreamingServer() {
+		return s + "(*" + g.QualifiedGoIdent(method.Output.GoIdent) + ", error)"
+	}
+	return s + "(" + streamName(service, method) + "NRPCClient, error)"
+}
+
+func serverSignature(g *protogen.GeneratedFile, service *protogen.Service, method *protogen.Method) string {
+	var args []string
+	var ret string
+	if !method.Desc.IsStreamingClient() && !method.Desc.IsStreamingServer() {
+		args = append(args, g.QualifiedGoIdent(contextPackage.Ident("Context")))
+		ret = "(*" + g.QualifiedGoIdent(method.Output.GoIdent) + ", error)"
+	} else {
+		ret = "error"
+	}
+	if !method.Desc.IsStreamingClient() {
+		args = append(args, "*"+g.QualifiedGoIdent(method.Input.GoIdent))
+	}
+	if method.Desc.IsStreamingClient() || method.Desc.IsStreamingServer() {
+		args = append(args, streamName(service, method)+"NRPCServer")
+	}
+	return method.GoName + "(" + strings.Join(args, ", ") + ") " + ret
+}
+
+func generateUnaryClientMethod(g *protogen.GeneratedFile, service *protogen.Service, method *protogen.Method) {
+	g.P("func (c *", unexport(service.GoName), "NRPCClient) ", clientSignature(g, service, method), " {")
+	g.P("out := new(", method.Output.GoIdent, ")")
+	g.P("if err := c.c.Invoke(ctx, ", fmt.Sprintf("%q", fullMethod(service, method)), ", in, out, opts...); err != nil {")
+	g.P("return nil, err")
+	g.P("}")
+	g.P("return out, nil")
+	g.P("}")
+	g.P()
+}
+
+func generateStreamClientMethod(g *protogen.GeneratedFile, service *protogen.Service, method *protogen.Method, index int,
+	iterators bool) {
+	ifaceName := streamName(service, method) + "NRPCClient"
+	typeName := unexport(service.GoName) + method.GoName + "NRPCClient"
+
+	g.P("func (c *", unexport(service.GoName), "NRPCClient) ", clientSignature(g, service, method), " {")
+	g.P("stream, err := c.c.NewStream(ctx, &", service.GoName, "_NRPCServiceDesc.Streams[", index, "], ",
+		fmt.Sprintf("%q", fullMethod(service, method)), ", opts...)")
+	g.P("if err != nil {")
+	g.P("return nil, err")
+	g.P("}")
+	g.P("x := &", typeName, "{stream}")
+	if !method.Desc.IsStreamingClient() {
+		g.P("if err := x.ClientStream.SendMsg(in); err != nil {")
+		g.P("return nil, err")
+		g.P("}")
+		g.P("if err := x.ClientStream.CloseSend(); err != nil {")
+		g.P("return nil, err")
+		g.P("}")
+	}
+	g.P("return x, nil")
+	g.P("}")
+	g.P()
+
+	g.P("// ", ifaceName, " is the client side of the ", method.GoName, " stream.")
+	g.P("type ", ifaceName, " interface {")
+	if method.Desc.IsStreamingClient() {
+		g.P("Send(*", method.Input.GoIdent, ") error")
+		g.P("SendContext(", contextPackage.Ident("Context"), ", *", method.Input.GoIdent, ") error")
+	}
+	if method.Desc.IsStreamingServer() {
+		g.P("Recv() (*", method.Output.GoIdent, ", error)")
+		if iterators {
+			g.P(messagesSignature(method.Output)...)
+		}
+	} else {
+		g.P("CloseAndRecv() (*", method.Output.GoIdent, ", error)")
+	}
+	g.P(grpcPackage.Ident("ClientStream"))
+	g.P("}")
+	g.P()
+	g.P("type ", typeName, " struct {")
+	g.P(grpcPackage.Ident("ClientStream"))
+	g.P("}")
+	g.P()
+	if method.Desc.IsStreamingClient() {
+		g.P("func (x *", typeName, ") Send(m *", method.Input.GoIdent, ") error {")
+		g.P("return x.ClientStream.SendMsg(m)")
+		g.P("}")
+		g.P()
+		generateSendContext(g, typeName, "ClientStream", method.Input)
+	}
+	if method.Desc.IsStreamingServer() {
+		g.P("func (x *", typeName, ") Recv() (*", method.Output.GoIdent, ", error) {")
+		g.P("m := new(", method.Output.GoIdent, ")")
+		g.P("if err := x.ClientStream.RecvMsg(m); err != nil {")
+		g.P("return nil, err")
+		g.P("}")
+		g.P("return m, nil")
+		g.P("}")
+		g.P()
+		if iterators {
+			generateMessages(g, typeName, "ClientStream", method.Output)
+		}
+		return
+	}
+	g.P("func (x *", typeName, ") CloseAndRecv() (*", method.Output.GoIdent, ", error) {")
+	g.P("if err := x.ClientStream.CloseSend(); err != nil {")
+	g.P("return nil, err")
+	g.P("}")
+	g.P("m := new(", method.Output.GoIdent, ")")
+	g.P("if err := x.ClientStream.RecvMsg(m); err != nil {")
+	g.P("return nil, err")
+	g.P("}")
+	g.P("return m, nil")
+	g.P("}")
+	g.P()
+}
+
+func generateUnaryHandler(g *protogen.GeneratedFile, service *protogen.Service, method *protogen.Method) {
+	serverName := service.GoName + "NRPCServer"
+
+	g.P("func ", handlerName(service, method), "(srv interface{}, ctx ", contextPackage.Ident("Context"),
+		", dec func(interface{}) error, interceptor ", grpcPackage.Ident("UnaryServerInterceptor"), ") (interface{}, error) {")
+	g.P("in := new(", method.Input.GoIdent, ")")
+	g.P("if err := dec(in); err != nil {")
+	g.P("return nil, err")
+	g.P("}")
+	g.P("if interceptor == nil {")
+	g.P("return srv.(", serverName, ").", method.GoName, "(ctx, in)")
+	g.P("}")
+	g.P("info := &", grpcPackage.Ident("UnaryServerInfo"), "{")
+	g.P("Server: srv,")
+	g.P("FullMethod: ", fmt.Sprintf("%q", fullMethod(service, method)), ",")
+	g.P("}")
+	g.P("handler := func(ctx ", contextPackage.Ident("Context"), ", req interface{}) (interface{}, error) {")
+	g.P("return srv.(", serverName, ").", method.GoName, "(ctx, req.(*", method.Input.GoIdent, "))")
+	g.P("}")
+	g.P("return interceptor(ctx, in, info, handler)")
+	g.P("}")
+	g.P()
+}
+
+func generateStreamHandler(g *protogen.GeneratedFile, service *protogen.Service, method *protogen.Method, iterators bool) {
+	serverName := service.GoName + "NRPCServer"
+	ifaceName := streamName(service, method) + "NRPCServer"
+	typeName := unexport(service.GoName) + method.GoName + "NRPCServer"
+
+	g.P("func ", handlerName(service, method), "(srv interface{}, stream ", grpcPackage.Ident("ServerStream"), ") error {")
+	if !method.Desc.IsStreamingClient() {
+		g.P("m := new(", method.Input.GoIdent, ")")
+		g.P("if err := stream.RecvMsg(m); err != nil {")
+		g.P("return err")
+		g.P("}")
+		g.P("return srv.(", serverName, ").", method.GoName, "(m, &", typeName, "{stream})")
+	} else {
+		g.P("return srv.(", serverName, ").", method.GoName, "(&", typeName, "{stream})")
+	}
+	g.P("}")
+	g.P()
+
+	g.P("// ", ifaceName, " is the server side of the ", method.GoName, " stream.")
+	g.P("type ", ifaceName, " interface {")
+	if method.Desc.IsStreamingServer() {
+		g.P("Send(*", method.Output.GoIdent, ") error")
+		g.P("SendContext(", contextPackage.Ident("Context"), ", *", method.Output.GoIdent, ") error")
+	} else {
+		g.P("SendAndClose(*", method.Output.GoIdent, ") error")
+	}
+	if method.Desc.IsStreamingClient() {
+		g.P("Recv() (*", method.Input.GoIdent, ", error)")
+		if iterators {
+			g.P(messagesSignature(method.Input)...)
+		}
+	}
+	g.P(grpcPackage.Ident("ServerStream"))
+	g.P("}")
+	g.P()
+	g.P("type ", typeName, " struct {")
+	g.P(grpcPackage.Ident("ServerStream"))
+	g.P("}")
+	g.P()
+	send := "Send"
+	if !method.Desc.IsStreamingServer() {
+		send = "SendAndClose"
+	}
+	g.P("func (x *", typeName, ") ", send, "(m *", method.Output.GoIdent, ") error {")
+	g.P("return x.ServerStream.SendMsg(m)")
+	g.P("}")
+	g.P()
+	if method.Desc.IsStreamingServer() {
+		generateSendContext(g, typeName, "ServerStream", method.Output)
+	}
+	if method.Desc.IsStreamingClient() {
+		g.P("func (x *", typeName, ") Recv() (*", method.Input.GoIdent, ", error) {")
+		g.P("m := new(", method.Input.GoIdent, ")")
+		g.P("if err := x.ServerStream.RecvMsg(m); err != nil {")
+		g.P("return nil, err")
+		g.P("}")
+		g.P("return m, nil")
+		g.P("}")
+		g.P()
+		if iterators {
+			generateMessages(g, typeName, "ServerStream", method.Input)
+		}
+	}
+}
+
+// generateSendContext generates the send of a message bounded by a context on the embedded stream.
+func generateSendContext(g *protogen.GeneratedFile, typeName, stream string, msg *protogen.Message) {
+	g.P("func (x *", typeName, ") SendContext(ctx ", contextPackage.Ident("Context"), ", m *", msg.GoIdent, ") error {")
+	g.P("return ", nrpcPackage.Ident("SendMsgContext"), "(ctx, x.", stream, ", m)")
+	g.P("}")
+	g.P()
+}
+
+// messagesSignature returns the signature of the iterator over the messages received on a stream.
+func messagesSignature(msg *protogen.Message) []interface{} {
+	return []interface{}{"Messages(ctx ", contextPackage.Ident("Context"), ") ", iterPackage.Ident("Seq2"),
+		"[*", msg.GoIdent, ", error]"}
+}
+
+// generateMessages generates the iterator over the messages received on the embedded stream.
+func generateMessages(g *protogen.GeneratedFile, typeName, stream string, msg *protogen.Message) {
+	g.P(append(append([]interface{}{"func (x *", typeName, ") "}, messagesSignature(msg)...), " {")...)
+	g.P("return ", typedPackage.Ident("Messages"), "[", msg.GoIdent, "](ctx, x.", stream, ")")
+	g.P("}")
+	g.P()
+}
+
+func streamName(service *protogen.Service, method *protogen.Method) string {
+	return service.GoName + "_" + method.GoName
+}
+
+func handlerName(service *protogen.Service, method *protogen.Method) string {
+	return "_" + service.GoName + "_" + method.GoName + "_NRPCHandler"
+}
+
+func unexport(s string) string {
+	r, size := utf8.DecodeRuneInString(s)
+	return string(unicode.ToLower(r)) + s[size:]
+}
@@ -0,0 +1,94 @@
+package nrpc
+
+import (
+	"bytes"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	encproto "google.golang.org/grpc/encoding/proto"
+	"google.golang.org/grpc/status"
+)
+
+// Codec defines the interface used to marshal and unmarshal messages. It is the codec
+// interface of google.golang.org/grpc/encoding, so codecs written for grpc can be used.
+type Codec = encoding.Codec
+
+// legacyCodec adapts the deprecated codec of the grpc.CallCustomCodec call option to a Codec.
+type legacyCodec struct {
+	grpc.Codec
+}
+
+// Name implements the encoding.Codec interface.
+func (c legacyCodec) Name() string {
+	return c.String()
+}
+
+// RegisterCodec registers the codec so it can be selected by name with the
+// grpc.CallContentSubtype call option and found by the server. Codecs are registered
+// in the google.golang.org/grpc/encoding registry. It must only be called at init time.
+func RegisterCodec(codec Codec) {
+	encoding.RegisterCodec(codec)
+}
+
+// getCodec returns the codec registered under the given name. An empty name
+// refers to the default proto codec.
+func getCodec(name string) (Codec, error) {
+	if name == "" {
+		name = encproto.Name
+	}
+
+	codec := encoding.GetCodec(name)
+	if codec == nil {
+		return nil, status.Errorf(codes.Internal, "grpc: no codec registered for content-subtype %s", name)
+	}
+	return codec, nil
+}
+
+// encode marshals args with the codec and compresses the result with the compressor into buf.
+// It returns the marshaled as well as the compressed data, which is only valid until buf is modified.
+// Messages exceeding the maximum size after compression are rejected with codes.ResourceExhausted.
+func encode(codec Codec, compressor string, args interface{}, maxSize int, buf *bytes.Buffer) ([]byte, []byte, error) {
+	innerPayload, err := codec.Marshal(args)
+	if err != nil {
+		return nil, nil, status.Errorf(codes.Internal, "grpc: error while marshaling: %v", err)
+	}
+	data, err := compress(compressor, innerPayload, buf)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(data) > maxSize {
+		return nil, nil, status.Errorf(codes.ResourceExhausted, "grpc: trying to send message larger than max (%d vs. %d)", len(data), maxSize)
+	}
+	return innerPayload, data, nil
+}
+
+// responseCodec returns the codec to decode a response encoded with the named codec. The codec of
+// the call is used if it has the same name, so a codec forced with grpc.ForceCodec decodes the response as well.
+func responseCodec(callCodec Codec, name string) (Codec, error) {
+	if callCodec != nil && callCodec.Name() == name {
+		return callCodec, nil
+	}
+	return getCodec(name)
+}
+
+// decode decompresses the data and unmarshals it into target with the codec. It returns
+// the decompressed data. Messages exceeding the maximum size before or after decompression
+// are rejected with codes.ResourceExhausted.
+func decode(codec Codec, compressor string, data []byte, target interface{}, maxSize int) ([]byte, error) {
+	if len(data) > maxSize {
+		return nil, status.Errorf(codes.ResourceExhausted, "grpc: received message larger than max (%d vs. %d)", len(data), maxSize)
+	}
+	data, err := decompress(compressor, data)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxSize {
+		return nil, status.Errorf(codes.ResourceExhausted,
+			"grpc: received message after decompression larger than max (%d vs. %d)", len(data), maxSize)
+	}
+	if r := codec.Unmarshal(data, target); r != nil {
+		return nil, status.Errorf(codes.Internal, "grpc: failed to unmarshal the received message: %v", r)
+	}
+	return data, nil
+}
@@ -0,0 +1,87 @@
+package nrpc
+
+import (
+	"bytes"
+	"io"
+	"sync"
+
+	// register the built-in compressors
+	_ "github.com/tehsphinx/nrpc/encoding/zstd"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	_ "google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/status"
+)
+
+// maxPooledBuffer is the capacity up to which buffers are returned to bufPool, so single large messages
+// do not pin their buffers.
+const maxPooledBuffer = 64 << 10
+
+// bufPool holds the buffers messages are compressed into. The compressed data is copied into the payload
+// of the message, so the buffers are reused right away.
+var bufPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+func getBuffer() *bytes.Buffer {
+	return bufPool.Get().(*bytes.Buffer)
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBuffer {
+		return
+	}
+	buf.Reset()
+	bufPool.Put(buf)
+}
+
+// compress compresses the data into buf with the compressor registered under the given name
+// in the google.golang.org/grpc/encoding registry. An empty name or empty data is left as is.
+// The compressed data is only valid until buf is modified.
+func compress(name string, data []byte, buf *bytes.Buffer) ([]byte, error) {
+	if name == "" || name == encoding.Identity || len(data) == 0 {
+		return data, nil
+	}
+
+	comp := encoding.GetCompressor(name)
+	if comp == nil {
+		return nil, status.Errorf(codes.Internal, "grpc: Compressor is not installed for requested grpc-encoding %q", name)
+	}
+
+	w, err := comp.Compress(buf)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "grpc: error while compressing: %v", err)
+	}
+	if _, r := w.Write(data); r != nil {
+		return nil, status.Errorf(codes.Internal, "grpc: error while compressing: %v", r)
+	}
+	if r := w.Close(); r != nil {
+		return nil, status.Errorf(codes.Internal, "grpc: error while compressing: %v", r)
+	}
+	return buf.Bytes(), nil
+}
+
+// decompress decompresses the data with the compressor registered under the given name
+// in the google.golang.org/grpc/encoding registry. An empty name or empty data is left as is.
+func decompress(name string, data []byte) ([]byte, error) {
+	if name == "" || name == encoding.Identity || len(data) == 0 {
+		return data, nil
+	}
+
+	comp := encoding.GetCompressor(name)
+	if comp == nil {
+		return nil, status.Errorf(codes.Unimplemented, "grpc: Decompressor is not installed for grpc-encoding %q", name)
+	}
+
+	r, err := comp.Decompress(bytes.NewReader(data))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "grpc: failed to decompress the received message: %v", err)
+	}
+	out, err := io.ReadAll(r)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "grpc: failed to decompress the received message: %v", err)
+	}
+	return out, nil
+}
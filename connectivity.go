@@ -0,0 +1,75 @@
+package nrpc
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/status"
+)
+
+// readiness defines whether a call waits for the connection to the broker to be ready.
+type readiness int
+
+const (
+	// readinessUnset passes calls to the publisher regardless of the state of its connection.
+	readinessUnset readiness = iota
+	// readinessFailFast fails calls while the connection is down.
+	readinessFailFast
+	// readinessWait holds back calls until the connection is ready.
+	readinessWait
+)
+
+func readinessOf(waitForReady bool) readiness {
+	if waitForReady {
+		return readinessWait
+	}
+	return readinessFailFast
+}
+
+// GetState returns the connectivity state of the client: the state of the connection of its publisher
+// to the broker. Clients on publishers not implementing pubsub.StateReporter are always ready.
+func (s *Client) GetState() connectivity.State {
+	if s.states == nil {
+		return connectivity.Ready
+	}
+	return s.states.State()
+}
+
+// WaitForStateChange waits until the connectivity state of the client differs from sourceState or
+// ctx expires. It returns true in the former case and false in the latter.
+func (s *Client) WaitForStateChange(ctx context.Context, sourceState connectivity.State) bool {
+	if s.states == nil {
+		if sourceState != connectivity.Ready {
+			return true
+		}
+		<-ctx.Done()
+		return false
+	}
+	return s.states.WaitForStateChange(ctx, sourceState)
+}
+
+// awaitReady checks the connectivity state before a call. Like grpc, calls failing fast only wait
+// while the connection is being established and fail with codes.Unavailable once it failed, while
+// calls waiting for ready wait until the connection is ready or their context is done.
+func (s *Client) awaitReady(ctx context.Context, ready readiness) error {
+	if s.states == nil || ready == readinessUnset {
+		return nil
+	}
+	for {
+		state := s.states.State()
+		switch state {
+		case connectivity.Ready:
+			return nil
+		case connectivity.Shutdown:
+			return status.Error(codes.Unavailable, "nrpc: the connection to the broker is shut down")
+		case connectivity.TransientFailure:
+			if ready == readinessFailFast {
+				return status.Error(codes.Unavailable, "nrpc: the connection to the broker is down")
+			}
+		}
+		if !s.states.WaitForStateChange(ctx, state) {
+			return status.FromContextError(ctx.Err()).Err()
+		}
+	}
+}
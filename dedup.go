@@ -0,0 +1,111 @@
+package nrpc
+
+import (
+	"strconv"
+
+	"github.com/tehsphinx/nrpc/pubsub"
+)
+
+// dedupSize is the number of message IDs and of sequence numbers received ahead of a missing
+// frame remembered to detect redeliveries.
+const dedupSize = 1024
+
+// dedup detects redelivered messages of transports with at-least-once delivery
+// by remembering the IDs of the last received messages and the sequence numbers
+// of the received frames of a stream.
+// It must only be used by the goroutine handling the subscription.
+type dedup struct {
+	seen map[string]struct{}
+	ids  []string
+	next int
+
+	// all frames up to seq were received, of the following ones the frames in ahead.
+	seq   uint64
+	ahead map[uint64]struct{}
+}
+
+// frameID returns the ID of the frame with the sequence number published on the subject of a stream.
+// It is built in a buffer on the stack, so only the resulting string is allocated.
+func frameID(subj string, seq uint64) string {
+	var buf [128]byte
+	id := append(buf[:0], subj...)
+	id = append(id, '.')
+	return string(strconv.AppendUint(id, seq, 10))
+}
+
+func newDedup() *dedup {
+	return &dedup{
+		seen: make(map[string]struct{}, dedupSize),
+		ids:  make([]string, dedupSize),
+	}
+}
+
+// duplicate reports whether the message was received before.
+// Messages without an ID are never considered duplicates.
+func (d *dedup) duplicate(msg pubsub.Replier) bool {
+	identifier, ok := msg.(pubsub.Identifier)
+	if !ok {
+		return false
+	}
+	id := identifier.ID()
+	if id == "" {
+		return false
+	}
+
+	if _, ok := d.seen[id]; ok {
+		return true
+	}
+
+	delete(d.seen, d.ids[d.next])
+	d.ids[d.next] = id
+	d.seen[id] = struct{}{}
+	d.next = (d.next + 1) % len(d.ids)
+	return false
+}
+
+// duplicateSeq reports whether the frame with the sequence number was received before.
+// Frames without a sequence number are never considered duplicates. If too many frames
+// were received ahead of a missing frame, the missing frames are considered lost.
+func (d *dedup) duplicateSeq(seq uint64) bool {
+	if seq == 0 {
+		return false
+	}
+	if seq <= d.seq {
+		return true
+	}
+	if _, ok := d.ahead[seq]; ok {
+		return true
+	}
+
+	if d.ahead == nil {
+		d.ahead = map[uint64]struct{}{}
+	}
+	d.ahead[seq] = struct{}{}
+	if len(d.ahead) > dedupSize {
+		d.seq = seq
+		for s := range d.ahead {
+			if s < d.seq {
+				d.seq = s
+			}
+		}
+		d.seq--
+	}
+	// advance over the frames received in order
+	for {
+		if _, ok := d.ahead[d.seq+1]; !ok {
+			return false
+		}
+		delete(d.ahead, d.seq+1)
+		d.seq++
+	}
+}
+
+// duplicateFrame reports whether the numbered frame of a stream was received before. Streams with
+// resumption enabled only process the frames received in order: the frames they drop because frames
+// are missing are sent again on request and must not be considered duplicates.
+func duplicateFrame(d *dedup, r *resumer, seq uint64) bool {
+	if r.enabled() {
+		return seq != 0 && seq <= r.acked()
+	}
+	return d.duplicateSeq(seq)
+}
@@ -0,0 +1,324 @@
+package nrpc
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tehsphinx/nrpc/pubsub"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// discoverySubj is the subject the server instances announce themselves on.
+	discoverySubj = "nrpc.discovery"
+	// probeSubj is the subject registries ask the server instances to announce themselves on.
+	probeSubj = "nrpc.discovery.probe"
+
+	instanceIDLen = 16
+	// announceTTLFactor is the number of announcements an instance may miss before it is considered gone.
+	announceTTLFactor = 3
+	// expiryInterval is the interval the registry removes the instances that stopped announcing themselves.
+	expiryInterval = time.Second
+)
+
+// InstanceID returns the ID identifying this server instance in its announcements.
+func (s *Server) InstanceID() string {
+	return s.id
+}
+
+// registerProbe makes the server answer the probes of registries with an announcement.
+func (s *Server) registerProbe() {
+	if s.announceInterval <= 0 {
+		return
+	}
+	s.subs.RegisterSubscription(subscription{
+		endpoint: s.subjects.MapSubject(probeSubj),
+		handler: func(context.Context, pubsub.Replier) {
+			s.announce(false)
+		},
+	})
+}
+
+// announceLoop announces the instance every announce interval until the context is done.
+// The instance announces that it leaves when the context is done.
+func (s *Server) announceLoop(ctx context.Context) {
+	if s.announceInterval <= 0 {
+		return
+	}
+
+	s.announce(false)
+	tick := time.NewTicker(s.announceInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			s.announce(false)
+		case <-ctx.Done():
+			s.announce(true)
+			return
+		}
+	}
+}
+
+func (s *Server) announce(leaving bool) {
+	if s.announceInterval <= 0 {
+		return
+	}
+	s.m.Lock()
+	// a draining server keeps announcing that it leaves
+	leaving = leaving || s.draining
+	s.m.Unlock()
+
+	msg := &Announcement{
+		InstanceId: s.id,
+		Load:       uint32(s.calls.len()),
+		Ttl:        int64(announceTTLFactor * s.announceInterval),
+		Leaving:    leaving,
+	}
+	for name, info := range s.serviceInfo {
+		svc := &ServiceAnnouncement{Name: name}
+		for _, method := range info.Methods {
+			svc.Methods = append(svc.Methods, method.Name)
+		}
+		msg.Services = append(msg.Services, svc)
+	}
+
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		s.log.Error("failed to marshal the announcement", "error", err)
+		return
+	}
+	if r := s.pub.Publish(pubsub.Message{Subject: s.subjects.MapSubject(discoverySubj), Data: data}); r != nil {
+		s.log.Warn("failed to announce the instance", "error", r)
+	}
+}
+
+// Instance is a server instance announcing itself (see WithAnnouncements).
+type Instance struct {
+	// ID identifies the instance.
+	ID string
+	// Services maps the full names of the services the instance serves to the names of their methods.
+	Services map[string][]string
+	// Load is the number of calls and streams the instance handled at the time of the last announcement.
+	Load int
+	// LastSeen is the time of the last announcement.
+	LastSeen time.Time
+
+	expires time.Time
+}
+
+// Serves reports whether the instance serves the service.
+func (i Instance) Serves(service string) bool {
+	_, ok := i.Services[service]
+	return ok
+}
+
+// Registry keeps track of the live server instances announcing themselves on the discovery subject.
+// Servers announce themselves if they are created with the WithAnnouncements option. Instances are
+// removed when they announce that they leave or miss three announcements.
+type Registry struct {
+	log      Logger
+	subjects SubjectMapper
+
+	subscription pubsub.Subscription
+	done         chan struct{}
+	closeOnce    sync.Once
+
+	m         sync.Mutex
+	instances map[string]Instance
+	watchers  map[*watcher]struct{}
+}
+
+type watcher struct {
+	service string
+	ch      chan []Instance
+}
+
+// NewRegistry creates a registry listening for the announcements of the server instances.
+// Options mapping the subjects (e.g. WithSubjectPrefix) must match the ones of the servers. The instances are asked to announce themselves right away, so the registry knows the live
+// instances shortly after it was created. Close the registry to stop listening.
+func NewRegistry(pub pubsub.Publisher, sub pubsub.Subscriber, opts ...Option) (*Registry, error) {
+	opt := getOptions(opts)
+	pub, sub = opt.pubSub(pub, sub)
+
+	r := &Registry{
+		log:       opt.logger,
+		subjects:  opt.subjectMapper,
+		done:      make(chan struct{}),
+		instances: map[string]Instance{},
+		watchers:  map[*watcher]struct{}{},
+	}
+
+	subscription, err := sub.Subscribe(r.subjects.MapSubject(discoverySubj), "", r.receive)
+	if err != nil {
+		return nil, err
+	}
+	r.subscription = subscription
+	if err = sub.Flush(); err != nil {
+		_ = subscription.Unsubscribe()
+		return nil, err
+	}
+	if err = pub.Publish(pubsub.Message{Subject: r.subjects.MapSubject(probeSubj)}); err != nil {
+		_ = subscription.Unsubscribe()
+		return nil, err
+	}
+
+	go r.expireLoop()
+	return r, nil
+}
+
+// Close stops listening for announcements and closes the channels of the watchers.
+func (r *Registry) Close() error {
+	var err error
+	r.closeOnce.Do(func() {
+		close(r.done)
+		err = r.subscription.Unsubscribe()
+
+		r.m.Lock()
+		defer r.m.Unlock()
+		for w := range r.watchers {
+			delete(r.watchers, w)
+			close(w.ch)
+		}
+	})
+	return err
+}
+
+// Instances returns the live instances serving the service, ordered by their ID.
+// With an empty service name all live instances are returned.
+func (r *Registry) Instances(service string) []Instance {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	return r.list(service)
+}
+
+// Watch returns a channel receiving the live instances serving the service whenever they change,
+// starting with the current instances. The channel holds only the latest list, so slow readers
+// skip intermediate changes. It is closed when the context is done or the registry is closed.
+// With an empty service name all instances are watched.
+func (r *Registry) Watch(ctx context.Context, service string) <-chan []Instance {
+	w := &watcher{
+		service: service,
+		ch:      make(chan []Instance, 1),
+	}
+
+	r.m.Lock()
+	select {
+	case <-r.done:
+		close(w.ch)
+		r.m.Unlock()
+		return w.ch
+	default:
+	}
+	r.watchers[w] = struct{}{}
+	w.ch <- r.list(service)
+	r.m.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-r.done:
+			return
+		}
+
+		r.m.Lock()
+		defer r.m.Unlock()
+		if _, ok := r.watchers[w]; ok {
+			delete(r.watchers, w)
+			close(w.ch)
+		}
+	}()
+	return w.ch
+}
+
+func (r *Registry) receive(_ context.Context, msg pubsub.Replier) {
+	var ann Announcement
+	if err := proto.Unmarshal(msg.Data(), &ann); err != nil {
+		r.log.Warn("failed to unmarshal the announcement", "error", err)
+		return
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if ann.Leaving {
+		if _, ok := r.instances[ann.InstanceId]; ok {
+			delete(r.instances, ann.InstanceId)
+			r.notify()
+		}
+		return
+	}
+
+	now := time.Now()
+	inst := Instance{
+		ID:       ann.InstanceId,
+		Services: make(map[string][]string, len(ann.Services)),
+		Load:     int(ann.Load),
+		LastSeen: now,
+		expires:  now.Add(time.Duration(ann.Ttl)),
+	}
+	for _, svc := range ann.Services {
+		inst.Services[svc.Name] = svc.Methods
+	}
+	r.instances[inst.ID] = inst
+	r.notify()
+}
+
+func (r *Registry) expireLoop() {
+	tick := time.NewTicker(expiryInterval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+			r.expire(time.Now())
+		case <-r.done:
+			return
+		}
+	}
+}
+
+func (r *Registry) expire(now time.Time) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	changed := false
+	for id, inst := range r.instances {
+		if now.After(inst.expires) {
+			delete(r.instances, id)
+			changed = true
+		}
+	}
+	if changed {
+		r.notify()
+	}
+}
+
+// notify sends the changed instances to the watchers. The lock must be held.
+func (r *Registry) notify() {
+	for w := range r.watchers {
+		// replace a list the watcher did not read yet
+		select {
+		case <-w.ch:
+		default:
+		}
+		w.ch <- r.list(w.service)
+	}
+}
+
+// list returns the instances serving the service. The lock must be held.
+func (r *Registry) list(service string) []Instance {
+	instances := make([]Instance, 0, len(r.instances))
+	for _, inst := range r.instances {
+		if service == "" || inst.Serves(service) {
+			instances = append(instances, inst)
+		}
+	}
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].ID < instances[j].ID
+	})
+	return instances
+}
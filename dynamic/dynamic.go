@@ -0,0 +1,193 @@
+// Package dynamic calls the methods of nrpc services without generated code. The descriptors of the
+// methods are looked up in a Source: the server reflection service, FileDescriptorSets or the files
+// registered by generated code. Requests and responses are dynamicpb messages, which are built from
+// and marshaled to JSON with protojson:
+//
+//	client := dynamic.NewClient(nrpc.NewClient(pub, sub), dynamic.NewReflectionSource(conn))
+//	md, err := client.FindMethod(ctx, "pkg.Greeter/SayHello")
+//	reqs, err := dynamic.ParseRequests(md, []byte(`{"name": "world"}`))
+//	resp, err := client.Invoke(ctx, md, reqs[0])
+//
+// Streams of all kinds are opened with NewStream. The options of nrpcpb/options.proto are honored like
+// by the code generated by protoc-gen-nrpc.
+package dynamic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/tehsphinx/nrpc"
+	"github.com/tehsphinx/nrpc/nrpcpb"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Client calls methods by their descriptors.
+type Client struct {
+	conn grpc.ClientConnInterface
+	src  Source
+}
+
+// NewClient creates a client calling the methods through the connection, usually an nrpc client.
+// The descriptors of the methods are looked up in the source.
+func NewClient(conn grpc.ClientConnInterface, src Source) *Client {
+	return &Client{conn: conn, src: src}
+}
+
+// Source returns the source the descriptors are looked up in.
+func (c *Client) Source() Source {
+	return c.src
+}
+
+// FindMethod returns the descriptor of the method given as package.Service/Method, /package.Service/Method
+// or package.Service.Method. The subject and one-way calls set with the options of nrpcpb/options.proto
+// are registered (see nrpc.RegisterSubject and nrpc.RegisterOneWay).
+func (c *Client) FindMethod(ctx context.Context, method string) (protoreflect.MethodDescriptor, error) {
+	name := strings.TrimPrefix(method, "/")
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[:i] + "." + name[i+1:]
+	}
+	d, err := c.src.FindSymbol(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	md, ok := d.(protoreflect.MethodDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a method", method)
+	}
+	register(md)
+	return md, nil
+}
+
+// FindService returns the descriptor of the service given as package.Service. The subjects and one-way
+// calls of its methods are registered like by FindMethod.
+func (c *Client) FindService(ctx context.Context, service string) (protoreflect.ServiceDescriptor, error) {
+	d, err := c.src.FindSymbol(ctx, service)
+	if err != nil {
+		return nil, err
+	}
+	sd, ok := d.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a service", service)
+	}
+	methods := sd.Methods()
+	for i := 0; i < methods.Len(); i++ {
+		register(methods.Get(i))
+	}
+	return sd, nil
+}
+
+// Invoke calls the unary method with the request and returns the response.
+func (c *Client) Invoke(ctx context.Context, md protoreflect.MethodDescriptor, req proto.Message,
+	opts ...grpc.CallOption) (*dynamicpb.Message, error) {
+	if md.IsStreamingClient() || md.IsStreamingServer() {
+		return nil, fmt.Errorf("%s is a streaming method", md.FullName())
+	}
+	resp := dynamicpb.NewMessage(md.Output())
+	if err := c.conn.Invoke(ctx, FullMethod(md), req, resp, opts...); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// NewStream opens a stream of the streaming method. Unary methods are called with Invoke.
+func (c *Client) NewStream(ctx context.Context, md protoreflect.MethodDescriptor, opts ...grpc.CallOption) (*Stream, error) {
+	if !md.IsStreamingClient() && !md.IsStreamingServer() {
+		return nil, fmt.Errorf("%s is a unary method", md.FullName())
+	}
+	stream, err := c.conn.NewStream(ctx, &grpc.StreamDesc{
+		StreamName:    string(md.Name()),
+		ServerStreams: md.IsStreamingServer(),
+		ClientStreams: md.IsStreamingClient(),
+	}, FullMethod(md), opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Stream{ClientStream: stream, method: md}, nil
+}
+
+// Stream is a stream of a method called by its descriptor.
+type Stream struct {
+	grpc.ClientStream
+	method protoreflect.MethodDescriptor
+}
+
+// Send sends a request.
+func (s *Stream) Send(req proto.Message) error {
+	return s.SendMsg(req)
+}
+
+// Recv receives a response. It returns io.EOF at the end of the stream.
+func (s *Stream) Recv() (*dynamicpb.Message, error) {
+	resp := dynamicpb.NewMessage(s.method.Output())
+	if err := s.RecvMsg(resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// FullMethod returns the full method (/package.Service/Method) of the method.
+func FullMethod(md protoreflect.MethodDescriptor) string {
+	return fmt.Sprintf("/%s/%s", md.Parent().FullName(), md.Name())
+}
+
+// ParseRequests parses the requests of the method from JSON: a sequence of messages for client streams,
+// a single message otherwise. Without data, an empty request is returned for unary and server streaming methods.
+func ParseRequests(md protoreflect.MethodDescriptor, data []byte) ([]proto.Message, error) {
+	var reqs []proto.Message
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for {
+		var raw json.RawMessage
+		err := dec.Decode(&raw)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid request data: %w", err)
+		}
+
+		req := dynamicpb.NewMessage(md.Input())
+		if r := protojson.Unmarshal(raw, req); r != nil {
+			return nil, fmt.Errorf("invalid request: %w", r)
+		}
+		reqs = append(reqs, req)
+	}
+
+	if md.IsStreamingClient() {
+		return reqs, nil
+	}
+	switch len(reqs) {
+	case 0:
+		return []proto.Message{dynamicpb.NewMessage(md.Input())}, nil
+	case 1:
+		return reqs, nil
+	}
+	return nil, fmt.Errorf("%s expects a single request, got %d", md.FullName(), len(reqs))
+}
+
+// register registers the subject and the one-way calls set with the options of nrpcpb/options.proto,
+// as the code generated by protoc-gen-nrpc does.
+func register(md protoreflect.MethodDescriptor) {
+	if oneWay, _ := proto.GetExtension(md.Options(), nrpcpb.E_OneWay).(bool); oneWay {
+		nrpc.RegisterOneWay(FullMethod(md))
+	}
+
+	subj, _ := proto.GetExtension(md.Options(), nrpcpb.E_Subject).(string)
+	if subj == "" {
+		prefix, _ := proto.GetExtension(md.Parent().Options(), nrpcpb.E_SubjectPrefix).(string)
+		if prefix == "" {
+			return
+		}
+		subj = prefix + "." + string(md.Name())
+	}
+	nrpc.RegisterSubject(FullMethod(md), subj)
+}
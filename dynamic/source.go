@@ -0,0 +1,235 @@
+package dynamic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	rpb "github.com/tehsphinx/nrpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// Source provides the descriptors of services.
+type Source interface {
+	// Services returns the full names of the services.
+	Services(ctx context.Context) ([]string, error)
+	// FindSymbol returns the descriptor of a service, method, message or enum by its full name.
+	FindSymbol(ctx context.Context, name string) (protoreflect.Descriptor, error)
+}
+
+// FilesSource provides the descriptors of a registry of files, e.g. protoregistry.GlobalFiles holding
+// the descriptors of the generated code linked into the binary.
+type FilesSource struct {
+	files *protoregistry.Files
+}
+
+// NewFilesSource creates a source of the descriptors of the files.
+func NewFilesSource(files *protoregistry.Files) *FilesSource {
+	return &FilesSource{files: files}
+}
+
+// NewDescriptorSetSource creates a source of the descriptors of FileDescriptorSets, as created by
+// protoc --descriptor_set_out --include_imports.
+func NewDescriptorSetSource(sets ...*descriptorpb.FileDescriptorSet) (*FilesSource, error) {
+	fds := map[string]*descriptorpb.FileDescriptorProto{}
+	for _, set := range sets {
+		for _, fd := range set.File {
+			fds[fd.GetName()] = fd
+		}
+	}
+
+	files, err := buildFiles(fds)
+	if err != nil {
+		return nil, err
+	}
+	return NewFilesSource(files), nil
+}
+
+// Services implements the Source interface.
+func (s *FilesSource) Services(context.Context) ([]string, error) {
+	var names []string
+	s.files.RangeFiles(func(f protoreflect.FileDescriptor) bool {
+		for i := 0; i < f.Services().Len(); i++ {
+			names = append(names, string(f.Services().Get(i).FullName()))
+		}
+		return true
+	})
+	sort.Strings(names)
+	return names, nil
+}
+
+// FindSymbol implements the Source interface.
+func (s *FilesSource) FindSymbol(_ context.Context, name string) (protoreflect.Descriptor, error) {
+	d, err := s.files.FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil, fmt.Errorf("symbol %s not found: %w", name, err)
+	}
+	return d, nil
+}
+
+// ReflectionSource provides the descriptors fetched from the server reflection service (see package
+// reflection). Fetched files are cached.
+type ReflectionSource struct {
+	client rpb.ServerReflectionClient
+
+	m   sync.Mutex
+	fds map[string]*descriptorpb.FileDescriptorProto
+}
+
+// NewReflectionSource creates a source fetching the descriptors from the server reflection service
+// reached through the connection, usually an nrpc client.
+func NewReflectionSource(conn grpc.ClientConnInterface) *ReflectionSource {
+	return &ReflectionSource{
+		client: rpb.NewServerReflectionClient(conn),
+		fds:    map[string]*descriptorpb.FileDescriptorProto{},
+	}
+}
+
+// Services implements the Source interface.
+func (s *ReflectionSource) Services(ctx context.Context) ([]string, error) {
+	resp, err := s.ask(ctx, &rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_ListServices{},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, svc := range resp.GetListServicesResponse().GetService() {
+		names = append(names, svc.GetName())
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// FindSymbol implements the Source interface.
+func (s *ReflectionSource) FindSymbol(ctx context.Context, name string) (protoreflect.Descriptor, error) {
+	resp, err := s.ask(ctx, &rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: name},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if r := s.addFiles(ctx, resp); r != nil {
+		return nil, r
+	}
+	files, err := buildFiles(s.fds)
+	if err != nil {
+		return nil, err
+	}
+	return files.FindDescriptorByName(protoreflect.FullName(name))
+}
+
+// addFiles adds the files of the response and fetches their missing dependencies.
+func (s *ReflectionSource) addFiles(ctx context.Context, resp *rpb.ServerReflectionResponse) error {
+	var added []*descriptorpb.FileDescriptorProto
+	for _, data := range resp.GetFileDescriptorResponse().GetFileDescriptorProto() {
+		fd := &descriptorpb.FileDescriptorProto{}
+		if err := proto.Unmarshal(data, fd); err != nil {
+			return fmt.Errorf("failed to parse the file descriptor: %w", err)
+		}
+		if _, ok := s.fds[fd.GetName()]; ok {
+			continue
+		}
+		s.fds[fd.GetName()] = fd
+		added = append(added, fd)
+	}
+
+	for _, fd := range added {
+		for _, dep := range fd.GetDependency() {
+			if _, ok := s.fds[dep]; ok {
+				continue
+			}
+			depResp, err := s.ask(ctx, &rpb.ServerReflectionRequest{
+				MessageRequest: &rpb.ServerReflectionRequest_FileByFilename{FileByFilename: dep},
+			})
+			if err != nil {
+				return err
+			}
+			if r := s.addFiles(ctx, depResp); r != nil {
+				return r
+			}
+		}
+	}
+	return nil
+}
+
+// ask sends a single request to the reflection service and returns its response.
+func (s *ReflectionSource) ask(ctx context.Context, req *rpb.ServerReflectionRequest) (*rpb.ServerReflectionResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := s.client.ServerReflectionInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if r := stream.Send(req); r != nil {
+		return nil, r
+	}
+	if r := stream.CloseSend(); r != nil {
+		return nil, r
+	}
+	resp, err := stream.Recv()
+	if errors.Is(err, io.EOF) {
+		return nil, status.Error(codes.Unavailable, "server reflection: no response")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if e := resp.GetErrorResponse(); e != nil {
+		return nil, status.Error(codes.Code(e.GetErrorCode()), e.GetErrorMessage())
+	}
+	return resp, nil
+}
+
+// buildFiles creates the registry of the file descriptors.
+func buildFiles(fds map[string]*descriptorpb.FileDescriptorProto) (*protoregistry.Files, error) {
+	files := &protoregistry.Files{}
+
+	var register func(name string) error
+	register = func(name string) error {
+		if _, err := files.FindFileByPath(name); err == nil {
+			return nil
+		}
+		fd, ok := fds[name]
+		if !ok {
+			return fmt.Errorf("missing descriptor of %s", name)
+		}
+		for _, dep := range fd.GetDependency() {
+			if err := register(dep); err != nil {
+				return err
+			}
+		}
+		f, err := protodesc.NewFile(fd, files)
+		if err != nil {
+			return fmt.Errorf("invalid descriptor of %s: %w", name, err)
+		}
+		return files.RegisterFile(f)
+	}
+
+	names := make([]string, 0, len(fds))
+	for name := range fds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := register(name); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
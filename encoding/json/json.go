@@ -0,0 +1,45 @@
+// Package json implements and registers a JSON codec with the
+// google.golang.org/grpc/encoding registry. Proto messages are encoded with protojson,
+// all other values with encoding/json. Import the package on the client and server
+// and select it per call with grpc.CallContentSubtype(json.Name) or per client
+// with nrpc.WithCodec.
+package json
+
+import (
+	stdjson "encoding/json"
+
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// Name is the name registered for the JSON codec.
+const Name = "json"
+
+func init() {
+	encoding.RegisterCodec(Codec{})
+}
+
+// Codec encodes messages as JSON.
+type Codec struct{}
+
+// Marshal implements the encoding.Codec interface.
+func (Codec) Marshal(v interface{}) ([]byte, error) {
+	if msg, ok := v.(proto.Message); ok {
+		return protojson.Marshal(msg)
+	}
+	return stdjson.Marshal(v)
+}
+
+// Unmarshal implements the encoding.Codec interface.
+func (Codec) Unmarshal(data []byte, v interface{}) error {
+	if msg, ok := v.(proto.Message); ok {
+		return protojson.Unmarshal(data, msg)
+	}
+	return stdjson.Unmarshal(data, v)
+}
+
+// Name implements the encoding.Codec interface.
+func (Codec) Name() string {
+	return Name
+}
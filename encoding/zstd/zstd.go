@@ -0,0 +1,80 @@
+// Package zstd implements and registers the zstd compressor with the
+// google.golang.org/grpc/encoding registry. It is registered by importing
+// the package, which the nrpc package does. Select it per call with
+// grpc.UseCompressor(zstd.Name).
+package zstd
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/grpc/encoding"
+)
+
+// Name is the name registered for the zstd compressor.
+const Name = "zstd"
+
+func init() {
+	encoding.RegisterCompressor(newCompressor())
+}
+
+func newCompressor() *compressor {
+	// nolint: errcheck // creating an encoder and decoder without options does not fail
+	enc, _ := zstd.NewWriter(nil)
+	// nolint: errcheck
+	dec, _ := zstd.NewReader(nil)
+
+	return &compressor{
+		enc: enc,
+		dec: dec,
+	}
+}
+
+// compressor compresses whole messages with a shared encoder and decoder
+// which are safe for concurrent use with EncodeAll and DecodeAll.
+type compressor struct {
+	enc *zstd.Encoder
+	dec *zstd.Decoder
+}
+
+// Compress implements the encoding.Compressor interface.
+func (c *compressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	return &writer{enc: c.enc, w: w}, nil
+}
+
+// Decompress implements the encoding.Compressor interface.
+func (c *compressor) Decompress(r io.Reader) (io.Reader, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	decoded, err := c.dec.DecodeAll(data, nil)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(decoded), nil
+}
+
+// Name implements the encoding.Compressor interface.
+func (c *compressor) Name() string {
+	return Name
+}
+
+// writer buffers the written data and compresses it on Close.
+type writer struct {
+	enc *zstd.Encoder
+	w   io.Writer
+	buf []byte
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	return len(p), nil
+}
+
+func (w *writer) Close() error {
+	_, err := w.w.Write(w.enc.EncodeAll(w.buf, nil))
+	return err
+}
@@ -0,0 +1,287 @@
+package nrpc
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/tehsphinx/nrpc/pubsub"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// envelopeVersion is the first byte of encrypted payloads. It is followed by the length of the
+// key ID, the key ID, the nonce and the sealed payload.
+const envelopeVersion = 2
+
+// The kinds of sealed payloads: messages published on a subject and replies to requests.
+const (
+	sealedMessage byte = iota
+	sealedReply
+)
+
+// errInvalidEnvelope is returned for payloads that are not encrypted.
+var errInvalidEnvelope = errors.New("nrpc: invalid encrypted payload")
+
+// KeyProvider provides the keys of the payload encryption (see WithEncryption). Encrypted payloads
+// carry the ID of their key, so keys can be rotated by providing the new key for decryption on all
+// clients and servers before encrypting with it.
+type KeyProvider interface {
+	// EncryptionKey returns the ID and the key new payloads are encrypted with.
+	EncryptionKey() (id string, key []byte, err error)
+	// DecryptionKey returns the key with the ID.
+	DecryptionKey(id string) ([]byte, error)
+}
+
+// StaticKeys returns a key provider of fixed AES keys of 16, 24 or 32 bytes mapped by their ID.
+// Payloads are encrypted with the key of the current ID.
+func StaticKeys(current string, keys map[string][]byte) KeyProvider {
+	return staticKeys{current: current, keys: keys}
+}
+
+type staticKeys struct {
+	current string
+	keys    map[string][]byte
+}
+
+func (k staticKeys) EncryptionKey() (string, []byte, error) {
+	key, err := k.DecryptionKey(k.current)
+	return k.current, key, err
+}
+
+func (k staticKeys) DecryptionKey(id string) ([]byte, error) {
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("nrpc: unknown key %q", id)
+	}
+	return key, nil
+}
+
+// envelope encrypts and authenticates payloads with AES-GCM. The payloads are bound to the subject they
+// are published on, replies to the subject of the request, so the broker cannot redirect them to other
+// methods, tenants or streams. The subjects are authenticated as the subjects of nrpc they were mapped
+// from (see SubjectMapper), so the mapping remains transparent.
+type envelope struct {
+	keys     KeyProvider
+	subjects SubjectMapper
+	// aeads caches the ciphers by key.
+	aeads sync.Map
+}
+
+func (e *envelope) aead(key []byte) (cipher.AEAD, error) {
+	if aead, ok := e.aeads.Load(string(key)); ok {
+		return aead.(cipher.AEAD), nil
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	e.aeads.Store(string(key), aead)
+	return aead, nil
+}
+
+// aad returns the additional data authenticated along with the payload: the header of the envelope, the
+// kind of the payload and the subject it is bound to.
+func (e *envelope) aad(header []byte, kind byte, subject string) []byte {
+	if unmapped, ok := unmapSubject(e.subjects, subject); ok {
+		subject = unmapped
+	}
+	aad := make([]byte, 0, len(header)+1+len(subject))
+	aad = append(aad, header...)
+	aad = append(aad, kind)
+	return append(aad, subject...)
+}
+
+func (e *envelope) seal(kind byte, subject string, data []byte) ([]byte, error) {
+	id, key, err := e.keys.EncryptionKey()
+	if err != nil {
+		return nil, err
+	}
+	if len(id) > 255 {
+		return nil, fmt.Errorf("nrpc: key ID %q too long", id)
+	}
+	aead, err := e.aead(key)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, 2+len(id)+aead.NonceSize())
+	header = append(header, envelopeVersion, byte(len(id)))
+	header = append(header, id...)
+	nonce := make([]byte, aead.NonceSize())
+	if _, r := rand.Read(nonce); r != nil {
+		return nil, r
+	}
+	header = append(header, nonce...)
+	return aead.Seal(header, nonce, data, e.aad(header, kind, subject)), nil
+}
+
+func (e *envelope) open(kind byte, subject string, data []byte) ([]byte, error) {
+	if len(data) < 2 || data[0] != envelopeVersion || len(data) < 2+int(data[1]) {
+		return nil, errInvalidEnvelope
+	}
+	idEnd := 2 + int(data[1])
+	key, err := e.keys.DecryptionKey(string(data[2:idEnd]))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := e.aead(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonceEnd := idEnd + aead.NonceSize()
+	if len(data) < nonceEnd {
+		return nil, errInvalidEnvelope
+	}
+	return aead.Open(nil, data[idEnd:nonceEnd], data[nonceEnd:], e.aad(data[:nonceEnd], kind, subject))
+}
+
+// pubSub wraps the publisher and subscriber with the payload encryption if it is enabled.
+func (o options) pubSub(pub pubsub.Publisher, sub pubsub.Subscriber) (pubsub.Publisher, pubsub.Subscriber) {
+	if o.encryptionKeys == nil {
+		return pub, sub
+	}
+	env := &envelope{keys: o.encryptionKeys, subjects: o.subjectMapper}
+	return &encryptedPublisher{pub: pub, env: env}, &encryptedSubscriber{sub: sub, env: env, log: o.logger}
+}
+
+type encryptedPublisher struct {
+	pub pubsub.Publisher
+	env *envelope
+}
+
+func (p *encryptedPublisher) Publish(msg pubsub.Message) error {
+	data, err := p.env.seal(sealedMessage, msg.Subject, msg.Data)
+	if err != nil {
+		return status.Errorf(codes.Internal, "nrpc: failed to encrypt the message: %v", err)
+	}
+	msg.Data = data
+	return p.pub.Publish(msg)
+}
+
+func (p *encryptedPublisher) Request(ctx context.Context, msg pubsub.Message) (pubsub.Message, error) {
+	data, err := p.env.seal(sealedMessage, msg.Subject, msg.Data)
+	if err != nil {
+		return pubsub.Message{}, status.Errorf(codes.Internal, "nrpc: failed to encrypt the message: %v", err)
+	}
+	msg.Data = data
+
+	reply, err := p.pub.Request(ctx, msg)
+	if err != nil {
+		return reply, err
+	}
+	if reply.Data, err = p.env.open(sealedReply, msg.Subject, reply.Data); err != nil {
+		return pubsub.Message{}, status.Errorf(codes.Internal, "nrpc: failed to decrypt the reply: %v", err)
+	}
+	return reply, nil
+}
+
+type encryptedSubscriber struct {
+	sub pubsub.Subscriber
+	env *envelope
+	log Logger
+}
+
+func (s *encryptedSubscriber) Subscribe(subject, queue string, handler pubsub.Handler) (pubsub.Subscription, error) {
+	return s.sub.Subscribe(subject, queue, s.decrypt(handler))
+}
+
+func (s *encryptedSubscriber) SubscribeAsync(subject, queue string, handler pubsub.Handler) (pubsub.Subscription, error) {
+	return s.sub.SubscribeAsync(subject, queue, s.decrypt(handler))
+}
+
+// SubscribeReplies subscribes to the replies to the requests published on the request subject with the
+// subject as reply subject (see subscribeReplies).
+func (s *encryptedSubscriber) SubscribeReplies(subject, request, queue string, handler pubsub.Handler) (pubsub.Subscription, error) {
+	return s.sub.Subscribe(subject, queue, s.decryptReplies(request, handler))
+}
+
+func (s *encryptedSubscriber) Flush() error {
+	return s.sub.Flush()
+}
+
+// decrypt passes the decrypted messages to the handler. Messages failing decryption are dropped.
+func (s *encryptedSubscriber) decrypt(handler pubsub.Handler) pubsub.Handler {
+	return func(ctx context.Context, msg pubsub.Replier) {
+		data, err := s.env.open(sealedMessage, msg.Subject(), msg.Data())
+		if err != nil {
+			s.log.Warn("dropping message failing decryption", "subject", msg.Subject(), "error", err)
+			return
+		}
+		handler(ctx, &encryptedMsg{Replier: msg, data: data, env: s.env})
+	}
+}
+
+// decryptReplies passes the decrypted replies to the requests published on the request subject to the
+// handler. Replies failing decryption are dropped.
+func (s *encryptedSubscriber) decryptReplies(request string, handler pubsub.Handler) pubsub.Handler {
+	return func(ctx context.Context, msg pubsub.Replier) {
+		data, err := s.env.open(sealedReply, request, msg.Data())
+		if err != nil {
+			s.log.Warn("dropping reply failing decryption", "subject", msg.Subject(), "error", err)
+			return
+		}
+		handler(ctx, &encryptedMsg{Replier: msg, data: data, env: s.env})
+	}
+}
+
+// replySubscriber is implemented by subscribers receiving the replies to requests differently than the
+// messages published on a subject, e.g. the subscriber of the payload encryption.
+type replySubscriber interface {
+	SubscribeReplies(subject, request, queue string, handler pubsub.Handler) (pubsub.Subscription, error)
+}
+
+// subscribeReplies subscribes to the subject receiving the replies to the requests published on the
+// request subject with the subject as reply subject (see pubsub.Message).
+func subscribeReplies(sub pubsub.Subscriber, subject, request, queue string, handler pubsub.Handler) (pubsub.Subscription, error) {
+	if replies, ok := sub.(replySubscriber); ok {
+		return replies.SubscribeReplies(subject, request, queue, handler)
+	}
+	return sub.Subscribe(subject, queue, handler)
+}
+
+// encryptedMsg is a decrypted message. Replies to it are encrypted.
+type encryptedMsg struct {
+	pubsub.Replier
+	data []byte
+	env  *envelope
+}
+
+func (m *encryptedMsg) Data() []byte {
+	return m.data
+}
+
+func (m *encryptedMsg) Reply(reply pubsub.Reply) error {
+	data, err := m.env.seal(sealedReply, m.Replier.Subject(), reply.Data)
+	if err != nil {
+		return err
+	}
+	return m.Replier.Reply(pubsub.Reply{Data: data})
+}
+
+// Unwrap returns the received message, e.g. to acknowledge it (see pubsub.Acknowledger).
+func (m *encryptedMsg) Unwrap() pubsub.Replier {
+	return m.Replier
+}
+
+// ReplySubject implements the pubsub.ReplyAddresser interface if the received message does.
+func (m *encryptedMsg) ReplySubject() string {
+	return replySubject(m.Replier)
+}
+
+// ID implements the pubsub.Identifier interface if the received message does.
+func (m *encryptedMsg) ID() string {
+	if identifier, ok := m.Replier.(pubsub.Identifier); ok {
+		return identifier.ID()
+	}
+	return ""
+}
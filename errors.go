@@ -0,0 +1,68 @@
+package nrpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+
+	"github.com/tehsphinx/nrpc/pubsub"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// abortErr holds the error a stream was aborted with, e.g. because the other side is considered dead.
+type abortErr struct {
+	v atomic.Value
+}
+
+type abortReason struct {
+	err error
+}
+
+// set stores the error. It reports whether the stream was not aborted before.
+func (a *abortErr) set(err error) bool {
+	reason := abortReason{err: err}
+	if a.v.CompareAndSwap(nil, reason) {
+		return true
+	}
+	a.v.Store(reason)
+	return false
+}
+
+// err returns the error the stream was aborted with or the error of the context of the stream otherwise.
+func (a *abortErr) err(ctx context.Context) error {
+	if reason, ok := a.v.Load().(abortReason); ok {
+		return reason.err
+	}
+	return ctx.Err()
+}
+
+// toStatus converts the error returned by a handler into a status. Context errors
+// are converted into the corresponding codes, other errors into codes.Unknown.
+func toStatus(err error) *status.Status {
+	if st, ok := status.FromError(err); ok {
+		return st
+	}
+	return status.FromContextError(err)
+}
+
+// toRPCErr converts an error into a status error the same way grpc-go surfaces
+// errors to the client, so status.Code(err) can be relied upon. io.EOF as well
+// as errors already carrying a status are returned as is.
+func toRPCErr(err error) error {
+	if err == nil || errors.Is(err, io.EOF) {
+		return err
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return status.FromContextError(err).Err()
+	case errors.Is(err, pubsub.ErrNoResponders):
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	return status.Error(codes.Unknown, err.Error())
+}
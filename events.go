@@ -0,0 +1,39 @@
+package nrpc
+
+// StreamEvents are callbacks notified about the problems of the streams of a client or server (see
+// WithStreamEvents), so applications can alert, shed load or record diagnostics. The callbacks receive
+// the description of the stream at the time of the event. They are called synchronously by the stream,
+// so they should return quickly. Callbacks left nil are skipped.
+type StreamEvents struct {
+	// OnSlowConsumer is called every time a received message finds the receive buffer of the stream full
+	// and waits for the consumer to receive a message (see WithRecvBuffer and WithConsumerStuckTimeout).
+	OnSlowConsumer func(info StreamInfo)
+	// OnStreamStuck is called when the stream is closed because its consumer did not receive a message
+	// within the consumer stuck timeout.
+	OnStreamStuck func(info StreamInfo)
+	// OnStreamAborted is called with the error when nrpc aborts the stream, e.g. because the other side is
+	// considered dead (see WithKeepalive), the receive buffer overflowed or frames of the stream were lost.
+	// It is called once per stream. Streams ended by the application or the other side are not reported.
+	OnStreamAborted func(info StreamInfo, err error)
+}
+
+// slowConsumer notifies OnSlowConsumer about the stream.
+func (e StreamEvents) slowConsumer(s introspectable) {
+	if e.OnSlowConsumer != nil {
+		e.OnSlowConsumer(s.Stats())
+	}
+}
+
+// stuck notifies OnStreamStuck about the stream.
+func (e StreamEvents) stuck(s introspectable) {
+	if e.OnStreamStuck != nil {
+		e.OnStreamStuck(s.Stats())
+	}
+}
+
+// aborted notifies OnStreamAborted about the stream.
+func (e StreamEvents) aborted(s introspectable, err error) {
+	if e.OnStreamAborted != nil {
+		e.OnStreamAborted(s.Stats(), err)
+	}
+}
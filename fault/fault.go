@@ -0,0 +1,319 @@
+// Package fault injects faults into the calls of nrpc clients and servers for chaos testing. Rules
+// select the calls by method and metadata and inject latency, errors, dropped messages and stream
+// resets into a percentage of them:
+//
+//	injector := fault.New(fault.Rule{Methods: []string{"foo.Foo"}, Percentage: 10, Code: codes.Unavailable})
+//	server := nrpc.NewServer(pub, sub, injector.ServerOptions()...)
+//
+// The rules can be changed at runtime with SetRules, e.g. to enable faults for an experiment only.
+package fault
+
+import (
+	"context"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tehsphinx/nrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Rule describes the faults injected into the calls it applies to. For unary calls, the percentage
+// applies per call: it is delayed and then failed or dropped. For streams, the percentage of the
+// error code applies once when the stream is opened, the percentage of the other faults applies
+// to every message sent and received.
+type Rule struct {
+	// Methods are the full methods (/service/method) or service names the rule applies to.
+	// Without methods it applies to all methods.
+	Methods []string
+	// Metadata restricts the rule to calls carrying the metadata values, e.g. a header
+	// enabling chaos testing for single requests.
+	Metadata map[string]string
+	// Percentage is the share of the calls or messages in percent the faults are injected into.
+	// Faults are injected into all of them if it is 0.
+	Percentage float64
+
+	// Delay delays unary calls and the messages of streams.
+	Delay time.Duration
+	// Code fails the calls with the status code if it is not codes.OK.
+	Code codes.Code
+	// Drop drops the messages: unary calls wait for their deadline to pass, messages of streams are
+	// silently discarded.
+	Drop bool
+	// Reset aborts streams with codes.Unavailable.
+	Reset bool
+}
+
+// applies reports whether the rule applies to the call of the full method with the metadata.
+func (r Rule) applies(method string, md metadata.MD) bool {
+	if len(r.Methods) != 0 && !matchMethod(r.Methods, method) {
+		return false
+	}
+	for key, value := range r.Metadata {
+		if !contains(md.Get(key), value) {
+			return false
+		}
+	}
+	return true
+}
+
+// roll decides whether the faults are injected.
+func (r Rule) roll() bool {
+	// nolint: gosec // chaos testing needs no secure randomness
+	return r.Percentage <= 0 || rand.Float64()*100 < r.Percentage
+}
+
+func matchMethod(methods []string, method string) bool {
+	service := strings.TrimPrefix(method, "/")
+	if i := strings.Index(service, "/"); i >= 0 {
+		service = service[:i]
+	}
+	for _, m := range methods {
+		if m == method || m == service {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Injector injects the faults of its rules into calls.
+type Injector struct {
+	m     sync.RWMutex
+	rules []Rule
+}
+
+// New creates an injector of the rules.
+func New(rules ...Rule) *Injector {
+	return &Injector{rules: rules}
+}
+
+// SetRules replaces the rules of the injector. Streams keep the rule they were opened with.
+func (i *Injector) SetRules(rules ...Rule) {
+	i.m.Lock()
+	defer i.m.Unlock()
+
+	i.rules = rules
+}
+
+// rule returns the first rule applying to the call.
+func (i *Injector) rule(method string, md metadata.MD) (Rule, bool) {
+	i.m.RLock()
+	defer i.m.RUnlock()
+
+	for _, rule := range i.rules {
+		if rule.applies(method, md) {
+			return rule, true
+		}
+	}
+	return Rule{}, false
+}
+
+// ClientOptions returns the options adding the client interceptors of the injector to a client.
+func (i *Injector) ClientOptions() []nrpc.Option {
+	return []nrpc.Option{
+		nrpc.WithChainUnaryInterceptor(i.UnaryClientInterceptor()),
+		nrpc.WithChainStreamInterceptor(i.StreamClientInterceptor()),
+	}
+}
+
+// ServerOptions returns the options adding the server interceptors of the injector to a server.
+func (i *Injector) ServerOptions() []nrpc.Option {
+	return []nrpc.Option{
+		nrpc.ChainUnaryInterceptor(i.UnaryServerInterceptor()),
+		nrpc.ChainStreamInterceptor(i.StreamServerInterceptor()),
+	}
+}
+
+// UnaryClientInterceptor returns a client interceptor injecting faults into unary calls before
+// they are sent. The rules are matched with the outgoing metadata.
+func (i *Injector) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		if r := i.injectUnary(ctx, method, md); r != nil {
+			return r
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor returns a client interceptor injecting faults into streams.
+// The rules are matched with the outgoing metadata.
+func (i *Injector) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string,
+		streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		rule, ok := i.rule(method, md)
+		if !ok {
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+		if rule.Code != codes.OK && rule.roll() {
+			return nil, injected(rule.Code, method)
+		}
+
+		ctx, cancel := context.WithCancel(ctx)
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		return &clientStream{ClientStream: stream, fault: streamFault{rule: rule, method: method, reset: cancel}}, nil
+	}
+}
+
+// UnaryServerInterceptor returns a server interceptor injecting faults into unary calls before
+// they are handled. The rules are matched with the incoming metadata.
+func (i *Injector) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if r := i.injectUnary(ctx, info.FullMethod, md); r != nil {
+			return nil, r
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor returns a server interceptor injecting faults into streams.
+// The rules are matched with the incoming metadata.
+func (i *Injector) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		md, _ := metadata.FromIncomingContext(ss.Context())
+		rule, ok := i.rule(info.FullMethod, md)
+		if !ok {
+			return handler(srv, ss)
+		}
+		if rule.Code != codes.OK && rule.roll() {
+			return injected(rule.Code, info.FullMethod)
+		}
+		return handler(srv, &serverStream{ServerStream: ss, fault: streamFault{rule: rule, method: info.FullMethod}})
+	}
+}
+
+// injectUnary injects the faults of the rule applying to the unary call. It returns the error
+// the call fails with.
+func (i *Injector) injectUnary(ctx context.Context, method string, md metadata.MD) error {
+	rule, ok := i.rule(method, md)
+	if !ok || !rule.roll() {
+		return nil
+	}
+	if r := sleep(ctx, rule.Delay); r != nil {
+		return r
+	}
+	if rule.Drop {
+		<-ctx.Done()
+		return status.FromContextError(ctx.Err()).Err()
+	}
+	if rule.Code != codes.OK {
+		return injected(rule.Code, method)
+	}
+	return nil
+}
+
+// streamFault injects the faults of a rule into the messages of a stream.
+type streamFault struct {
+	rule   Rule
+	method string
+	// reset cancels the stream on the client side.
+	reset context.CancelFunc
+}
+
+// inject injects the faults into a message. It reports whether the message is dropped and
+// returns an error if the stream was reset.
+func (f streamFault) inject(ctx context.Context) (bool, error) {
+	if (f.rule.Delay == 0 && !f.rule.Drop && !f.rule.Reset) || !f.rule.roll() {
+		return false, nil
+	}
+	if r := sleep(ctx, f.rule.Delay); r != nil {
+		return false, r
+	}
+	if f.rule.Reset {
+		if f.reset != nil {
+			f.reset()
+		}
+		return false, status.Errorf(codes.Unavailable, "fault: stream of %s reset", f.method)
+	}
+	return f.rule.Drop, nil
+}
+
+type clientStream struct {
+	grpc.ClientStream
+	fault streamFault
+}
+
+func (s *clientStream) SendMsg(m interface{}) error {
+	drop, err := s.fault.inject(s.Context())
+	if err != nil || drop {
+		return err
+	}
+	return s.ClientStream.SendMsg(m)
+}
+
+func (s *clientStream) RecvMsg(m interface{}) error {
+	for {
+		if err := s.ClientStream.RecvMsg(m); err != nil {
+			return err
+		}
+		drop, err := s.fault.inject(s.Context())
+		if err != nil || !drop {
+			return err
+		}
+	}
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	fault streamFault
+}
+
+func (s *serverStream) SendMsg(m interface{}) error {
+	drop, err := s.fault.inject(s.Context())
+	if err != nil || drop {
+		return err
+	}
+	return s.ServerStream.SendMsg(m)
+}
+
+func (s *serverStream) RecvMsg(m interface{}) error {
+	for {
+		if err := s.ServerStream.RecvMsg(m); err != nil {
+			return err
+		}
+		drop, err := s.fault.inject(s.Context())
+		if err != nil || !drop {
+			return err
+		}
+	}
+}
+
+func injected(code codes.Code, method string) error {
+	return status.Errorf(code, "fault: injected into %s", method)
+}
+
+// sleep waits for the duration or until the context is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return status.FromContextError(ctx.Err()).Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,111 @@
+package nrpc
+
+import (
+	"context"
+	"sync"
+)
+
+// sendWindow limits the number of messages a stream may send before the
+// receiving side grants further credit.
+type sendWindow struct {
+	m       sync.Mutex
+	enabled bool
+	credit  int
+	chAdded chan struct{}
+}
+
+func newSendWindow() *sendWindow {
+	return &sendWindow{
+		chAdded: make(chan struct{}, 1),
+	}
+}
+
+// enable activates flow control and adds the given initial credit.
+// Credit granted before enable was called is kept.
+func (w *sendWindow) enable(credit int) {
+	w.m.Lock()
+	w.enabled = true
+	w.credit += credit
+	w.m.Unlock()
+
+	w.signal()
+}
+
+// add adds credit granted by the receiving side.
+func (w *sendWindow) add(credit int) {
+	w.m.Lock()
+	w.credit += credit
+	w.m.Unlock()
+
+	w.signal()
+}
+
+func (w *sendWindow) signal() {
+	select {
+	case w.chAdded <- struct{}{}:
+	default:
+	}
+}
+
+// ready reports whether a message can be sent without waiting for credit.
+func (w *sendWindow) ready() bool {
+	w.m.Lock()
+	defer w.m.Unlock()
+	return !w.enabled || w.credit > 0
+}
+
+// acquire blocks until there is credit to send one message or the context is done.
+func (w *sendWindow) acquire(ctx context.Context) error {
+	for {
+		w.m.Lock()
+		if !w.enabled {
+			w.m.Unlock()
+			return nil
+		}
+		if w.credit > 0 {
+			w.credit--
+			w.m.Unlock()
+			return nil
+		}
+		w.m.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-w.chAdded:
+		}
+	}
+}
+
+// recvWindow keeps track of consumed messages and decides when to grant
+// the sending side further credit. It must only be used by the consuming goroutine.
+type recvWindow struct {
+	size     int
+	consumed int
+}
+
+// consume records a consumed message and returns the credit that should be
+// granted to the sending side. It returns 0 if no credit should be sent yet.
+func (w *recvWindow) consume() int {
+	if w.size == 0 {
+		return 0
+	}
+
+	w.consumed++
+	if w.consumed < (w.size+1)/2 {
+		return 0
+	}
+
+	credit := w.consumed
+	w.consumed = 0
+	return credit
+}
+
+// bufferSize returns the size of the receive buffer needed to hold a full window
+// as well as the final message of the stream.
+func (w *recvWindow) bufferSize() int {
+	if w.size == 0 {
+		return 1
+	}
+	return w.size + 1
+}
@@ -0,0 +1,361 @@
+// Package gateway exposes nrpc services over HTTP with JSON messages, so browsers and other
+// HTTP clients can reach services behind a pub-sub broker:
+//
+//	client := nrpc.NewClient(pub, sub)
+//	gw := gateway.New(client)
+//	if err := gw.Register("pkg.Greeter"); err != nil {
+//		return err
+//	}
+//	http.ListenAndServe(":8080", gw)
+//
+// Every method of a registered service is served at POST /<package.Service>/<Method> with the
+// request as JSON body. Methods annotated with google.api.http rules are additionally served at
+// the paths of the rules, binding path variables, query parameters and the body to the fields of
+// the request. Further routes can be added with Handle.
+//
+// Responses of server streams are written as newline delimited JSON, each line holding either
+// {"result": <message>} or {"error": <status>}. If the client accepts text/event-stream, they are
+// sent as server-sent events instead: the messages as data of unnamed events, the error as data
+// of an event of type error. The body of client and bidirectional streams is a sequence of JSON
+// messages, which is read completely before the messages are sent to the service.
+//
+// The Authorization header and headers prefixed with Grpc-Metadata- are forwarded to the service
+// as metadata. The header metadata of the response is returned in headers prefixed with Grpc-Metadata-,
+// the trailer metadata of unary calls in headers prefixed with Grpc-Trailer-. Errors are answered
+// with the HTTP status matching the status code and the google.rpc.Status as JSON body.
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// errMethodNotAllowed is returned if a route matches the path of the request but not its HTTP method.
+var errMethodNotAllowed = status.Error(codes.Unimplemented, "HTTP method not allowed")
+
+// Option configures the gateway.
+type Option func(opts *options)
+
+type options struct {
+	marshal   protojson.MarshalOptions
+	unmarshal protojson.UnmarshalOptions
+}
+
+// WithMarshalOptions sets the options the responses are marshaled to JSON with.
+func WithMarshalOptions(opts protojson.MarshalOptions) Option {
+	return func(o *options) {
+		o.marshal = opts
+	}
+}
+
+// WithUnmarshalOptions sets the options the requests are unmarshaled from JSON with.
+// By default unknown fields are discarded.
+func WithUnmarshalOptions(opts protojson.UnmarshalOptions) Option {
+	return func(o *options) {
+		o.unmarshal = opts
+	}
+}
+
+// Gateway is an http.Handler translating HTTP requests to calls of nrpc services.
+type Gateway struct {
+	conn grpc.ClientConnInterface
+	opts options
+
+	m      sync.RWMutex
+	routes []*route
+}
+
+type route struct {
+	httpMethod string
+	pattern    *pattern
+	body       string
+	fullMethod string
+	method     protoreflect.MethodDescriptor
+}
+
+// New creates a gateway sending the calls through the client, usually an *nrpc.Client.
+func New(conn grpc.ClientConnInterface, opts ...Option) *Gateway {
+	o := options{
+		unmarshal: protojson.UnmarshalOptions{DiscardUnknown: true},
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return &Gateway{
+		conn: conn,
+		opts: o,
+	}
+}
+
+// Register exposes the methods of the service given by its full name (package.Service). The service
+// is looked up in protoregistry.GlobalFiles, where it is registered by importing its generated Go package.
+func (g *Gateway) Register(service string) error {
+	sd, err := findService(service)
+	if err != nil {
+		return err
+	}
+
+	methods := sd.Methods()
+	for i := 0; i < methods.Len(); i++ {
+		md := methods.Get(i)
+		if r := g.add(http.MethodPost, "/"+string(sd.FullName())+"/"+string(md.Name()), "*", md); r != nil {
+			return r
+		}
+
+		rule, ok := proto.GetExtension(md.Options(), annotations.E_Http).(*annotations.HttpRule)
+		if !ok || rule == nil {
+			continue
+		}
+		if r := g.addRule(md, rule); r != nil {
+			return r
+		}
+	}
+	return nil
+}
+
+// Handle exposes the full method (/package.Service/Method) at the HTTP method and path template. The
+// template and body follow the syntax of the google.api.http annotation, e.g. Handle("GET",
+// "/v1/{name=messages/*}", "/pkg.Messages/GetMessage", ""). The body names the field of the request
+// the body is unmarshaled into, "*" for the whole request or "" for requests without body.
+func (g *Gateway) Handle(httpMethod, tmpl, fullMethod, body string) error {
+	name := strings.TrimPrefix(fullMethod, "/")
+	i := strings.LastIndex(name, "/")
+	if i < 0 {
+		return fmt.Errorf("gateway: invalid method %q", fullMethod)
+	}
+	sd, err := findService(name[:i])
+	if err != nil {
+		return err
+	}
+	md := sd.Methods().ByName(protoreflect.Name(name[i+1:]))
+	if md == nil {
+		return fmt.Errorf("gateway: method %q not found", fullMethod)
+	}
+	return g.add(httpMethod, tmpl, body, md)
+}
+
+func findService(name string) (protoreflect.ServiceDescriptor, error) {
+	d, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil, fmt.Errorf("gateway: service %q not found: %w", name, err)
+	}
+	sd, ok := d.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("gateway: %q is not a service", name)
+	}
+	return sd, nil
+}
+
+func (g *Gateway) addRule(md protoreflect.MethodDescriptor, rule *annotations.HttpRule) error {
+	var method, tmpl string
+	switch p := rule.Pattern.(type) {
+	case *annotations.HttpRule_Get:
+		method, tmpl = http.MethodGet, p.Get
+	case *annotations.HttpRule_Put:
+		method, tmpl = http.MethodPut, p.Put
+	case *annotations.HttpRule_Post:
+		method, tmpl = http.MethodPost, p.Post
+	case *annotations.HttpRule_Delete:
+		method, tmpl = http.MethodDelete, p.Delete
+	case *annotations.HttpRule_Patch:
+		method, tmpl = http.MethodPatch, p.Patch
+	case *annotations.HttpRule_Custom:
+		method, tmpl = p.Custom.Kind, p.Custom.Path
+	}
+	if tmpl != "" {
+		if r := g.add(method, tmpl, rule.Body, md); r != nil {
+			return r
+		}
+	}
+
+	for _, binding := range rule.AdditionalBindings {
+		if r := g.addRule(md, binding); r != nil {
+			return r
+		}
+	}
+	return nil
+}
+
+func (g *Gateway) add(httpMethod, tmpl, body string, md protoreflect.MethodDescriptor) error {
+	p, err := parsePattern(tmpl)
+	if err != nil {
+		return err
+	}
+
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	g.routes = append(g.routes, &route{
+		httpMethod: strings.ToUpper(httpMethod),
+		pattern:    p,
+		body:       body,
+		fullMethod: fmt.Sprintf("/%s/%s", md.Parent().FullName(), md.Name()),
+		method:     md,
+	})
+	return nil
+}
+
+// match returns the route of the request and the values of its path variables.
+func (g *Gateway) match(r *http.Request) (*route, map[string]string, error) {
+	g.m.RLock()
+	defer g.m.RUnlock()
+
+	path := r.URL.EscapedPath()
+	pathMatched := false
+	for _, rt := range g.routes {
+		params, ok := rt.pattern.match(path)
+		if !ok {
+			continue
+		}
+		if rt.httpMethod != r.Method {
+			pathMatched = true
+			continue
+		}
+		return rt, params, nil
+	}
+
+	if pathMatched {
+		return nil, nil, errMethodNotAllowed
+	}
+	return nil, nil, status.Errorf(codes.NotFound, "no route for %s", path)
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rt, params, err := g.match(r)
+	if errors.Is(err, errMethodNotAllowed) {
+		g.writeStatus(w, http.StatusMethodNotAllowed, err)
+		return
+	}
+	if err != nil {
+		g.writeError(w, err)
+		return
+	}
+
+	ctx := metadata.NewOutgoingContext(r.Context(), outgoingMD(r))
+	if !rt.method.IsStreamingClient() && !rt.method.IsStreamingServer() {
+		g.serveUnary(ctx, w, r, rt, params)
+		return
+	}
+	g.serveStream(ctx, w, r, rt, params)
+}
+
+func (g *Gateway) serveUnary(ctx context.Context, w http.ResponseWriter, r *http.Request, rt *route, params map[string]string) {
+	req, err := g.newRequest(r, rt, params)
+	if err != nil {
+		g.writeError(w, err)
+		return
+	}
+
+	resp := newMessage(rt.method.Output())
+	var header, trailer metadata.MD
+	err = g.conn.Invoke(ctx, rt.fullMethod, req, resp, grpc.Header(&header), grpc.Trailer(&trailer))
+	writeMD(w, metadataHeaderPrefix, header)
+	writeMD(w, trailerHeaderPrefix, trailer)
+	if err != nil {
+		g.writeError(w, err)
+		return
+	}
+	g.writeMessage(w, resp)
+}
+
+func (g *Gateway) serveStream(ctx context.Context, w http.ResponseWriter, r *http.Request, rt *route, params map[string]string) {
+	var reqs []proto.Message
+	if rt.method.IsStreamingClient() {
+		msgs, err := g.readMessages(r, rt.method.Input())
+		if err != nil {
+			g.writeError(w, err)
+			return
+		}
+		reqs = msgs
+	} else {
+		req, err := g.newRequest(r, rt, params)
+		if err != nil {
+			g.writeError(w, err)
+			return
+		}
+		reqs = []proto.Message{req}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := g.conn.NewStream(ctx, &grpc.StreamDesc{
+		StreamName:    string(rt.method.Name()),
+		ServerStreams: rt.method.IsStreamingServer(),
+		ClientStreams: rt.method.IsStreamingClient(),
+	}, rt.fullMethod)
+	if err != nil {
+		g.writeError(w, err)
+		return
+	}
+
+	// send concurrently, so the service can answer while the requests are sent
+	go func() {
+		for _, req := range reqs {
+			if sendErr := stream.SendMsg(req); sendErr != nil {
+				// the error is returned by RecvMsg
+				return
+			}
+		}
+		_ = stream.CloseSend()
+	}()
+
+	if !rt.method.IsStreamingServer() {
+		resp := newMessage(rt.method.Output())
+		err := stream.RecvMsg(resp)
+		header, _ := stream.Header()
+		writeMD(w, metadataHeaderPrefix, header)
+		if err != nil {
+			g.writeError(w, err)
+			return
+		}
+		g.writeMessage(w, resp)
+		return
+	}
+
+	g.writeStream(w, r, rt, stream)
+}
+
+func (g *Gateway) writeStream(w http.ResponseWriter, r *http.Request, rt *route, stream grpc.ClientStream) {
+	// wait for the first message to answer with an error status if the stream fails right away
+	resp := newMessage(rt.method.Output())
+	err := stream.RecvMsg(resp)
+	header, _ := stream.Header()
+	writeMD(w, metadataHeaderPrefix, header)
+	if err != nil && !errors.Is(err, io.EOF) {
+		g.writeError(w, err)
+		return
+	}
+
+	writer := newStreamWriter(g, w, r)
+	writer.start()
+	for err == nil {
+		if writeErr := writer.message(resp); writeErr != nil {
+			writer.error(writeErr)
+			return
+		}
+
+		resp = newMessage(rt.method.Output())
+		err = stream.RecvMsg(resp)
+	}
+	if !errors.Is(err, io.EOF) {
+		writer.error(err)
+	}
+}
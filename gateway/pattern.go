@@ -0,0 +1,150 @@
+package gateway
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+type segmentKind int
+
+const (
+	segLiteral segmentKind = iota
+	// segWildcard (*) matches a single path segment.
+	segWildcard
+	// segDeepWildcard (**) matches the remaining path segments.
+	segDeepWildcard
+)
+
+type segment struct {
+	kind    segmentKind
+	literal string
+}
+
+// variable binds the path segments [start, end) to a field of the request.
+type variable struct {
+	field      string
+	start, end int
+}
+
+// pattern is a parsed path template of the google.api.http annotation, e.g. /v1/{name=messages/*}:cancel.
+type pattern struct {
+	segments []segment
+	vars     []variable
+	verb     string
+}
+
+func parsePattern(tmpl string) (*pattern, error) {
+	if !strings.HasPrefix(tmpl, "/") {
+		return nil, fmt.Errorf("gateway: invalid path template %q: must start with /", tmpl)
+	}
+
+	p := &pattern{}
+	rest := tmpl[1:]
+	if i := strings.LastIndex(rest, ":"); i > strings.LastIndex(rest, "/") && i > strings.LastIndex(rest, "}") {
+		p.verb, rest = rest[i+1:], rest[:i]
+	}
+
+	for rest != "" {
+		if rest[0] == '{' {
+			end := strings.IndexByte(rest, '}')
+			if end < 0 {
+				return nil, fmt.Errorf("gateway: invalid path template %q: unclosed variable", tmpl)
+			}
+			field, sub := rest[1:end], "*"
+			if i := strings.IndexByte(field, '='); i >= 0 {
+				field, sub = field[:i], field[i+1:]
+			}
+			rest = rest[end+1:]
+
+			v := variable{field: field, start: len(p.segments)}
+			for _, seg := range strings.Split(sub, "/") {
+				p.segments = append(p.segments, parseSegment(seg))
+			}
+			v.end = len(p.segments)
+			p.vars = append(p.vars, v)
+		} else {
+			seg := rest
+			if i := strings.IndexByte(rest, '/'); i >= 0 {
+				seg = rest[:i]
+			}
+			rest = rest[len(seg):]
+			p.segments = append(p.segments, parseSegment(seg))
+		}
+
+		if rest == "" {
+			break
+		}
+		if rest[0] != '/' || len(rest) == 1 {
+			return nil, fmt.Errorf("gateway: invalid path template %q", tmpl)
+		}
+		rest = rest[1:]
+	}
+
+	for i, seg := range p.segments {
+		if seg.kind == segLiteral && (seg.literal == "" || strings.ContainsAny(seg.literal, "{}=")) {
+			return nil, fmt.Errorf("gateway: invalid path template %q: invalid segment %q", tmpl, seg.literal)
+		}
+		if seg.kind == segDeepWildcard && i != len(p.segments)-1 {
+			return nil, fmt.Errorf("gateway: invalid path template %q: ** must be the last segment", tmpl)
+		}
+	}
+	return p, nil
+}
+
+func parseSegment(seg string) segment {
+	switch seg {
+	case "*":
+		return segment{kind: segWildcard}
+	case "**":
+		return segment{kind: segDeepWildcard}
+	}
+	return segment{literal: seg}
+}
+
+// match matches the escaped path of a request against the pattern and returns the values of the variables.
+func (p *pattern) match(path string) (map[string]string, bool) {
+	if p.verb != "" {
+		if !strings.HasSuffix(path, ":"+p.verb) {
+			return nil, false
+		}
+		path = strings.TrimSuffix(path, ":"+p.verb)
+	}
+	if !strings.HasPrefix(path, "/") {
+		return nil, false
+	}
+
+	raw := strings.Split(path[1:], "/")
+	segs := make([]string, len(raw))
+	for i, s := range raw {
+		seg, err := url.PathUnescape(s)
+		if err != nil {
+			return nil, false
+		}
+		segs[i] = seg
+	}
+
+	n := len(p.segments)
+	deep := n > 0 && p.segments[n-1].kind == segDeepWildcard
+	if (deep && len(segs) < n-1) || (!deep && len(segs) != n) {
+		return nil, false
+	}
+	for i, seg := range p.segments {
+		switch {
+		case seg.kind == segLiteral && segs[i] != seg.literal:
+			return nil, false
+		case seg.kind == segWildcard && segs[i] == "":
+			return nil, false
+		}
+	}
+
+	params := make(map[string]string, len(p.vars))
+	for _, v := range p.vars {
+		end := v.end
+		if deep && end == n {
+			end = len(segs)
+		}
+		params[v.field] = strings.Join(segs[v.start:end], "/")
+	}
+	return params, true
+}
@@ -0,0 +1,204 @@
+package gateway
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// newMessage creates a message of the type. The generated type is used if it is registered.
+func newMessage(desc protoreflect.MessageDescriptor) proto.Message {
+	if typ, err := protoregistry.GlobalTypes.FindMessageByName(desc.FullName()); err == nil {
+		return typ.New().Interface()
+	}
+	return dynamicpb.NewMessage(desc)
+}
+
+// newRequest builds the request message of a unary or server streaming call from the body,
+// the path variables and the query parameters of the HTTP request.
+func (g *Gateway) newRequest(r *http.Request, rt *route, params map[string]string) (proto.Message, error) {
+	req := newMessage(rt.method.Input())
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "failed to read the request body: %v", err)
+	}
+	if err = g.setBody(req, rt.body, body); err != nil {
+		return nil, err
+	}
+
+	for field, value := range params {
+		if err = setField(req.ProtoReflect(), field, []string{value}); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid path parameter %s: %v", field, err)
+		}
+	}
+
+	if rt.body == "*" {
+		return req, nil
+	}
+	for key, values := range r.URL.Query() {
+		if _, ok := params[key]; ok || (rt.body != "" && (key == rt.body || strings.HasPrefix(key, rt.body+"."))) {
+			continue
+		}
+		if err = setField(req.ProtoReflect(), key, values); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid query parameter %s: %v", key, err)
+		}
+	}
+	return req, nil
+}
+
+// setBody unmarshals the body into the request. With the field "*", the body is the request,
+// otherwise it is the value of the top-level field of the request.
+func (g *Gateway) setBody(req proto.Message, field string, body []byte) error {
+	if field == "" || len(body) == 0 {
+		return nil
+	}
+	if field != "*" {
+		wrapped, err := json.Marshal(map[string]json.RawMessage{field: body})
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, "invalid request body: %v", err)
+		}
+		body = wrapped
+	}
+
+	// unmarshal into a new message and merge, as unmarshaling resets the message
+	msg := req.ProtoReflect().New().Interface()
+	if r := g.opts.unmarshal.Unmarshal(body, msg); r != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid request body: %v", r)
+	}
+	proto.Merge(req, msg)
+	return nil
+}
+
+// readMessages reads the stream of JSON messages in the body of a client streaming call.
+func (g *Gateway) readMessages(r *http.Request, desc protoreflect.MessageDescriptor) ([]proto.Message, error) {
+	var msgs []proto.Message
+
+	dec := json.NewDecoder(r.Body)
+	for {
+		var raw json.RawMessage
+		err := dec.Decode(&raw)
+		if errors.Is(err, io.EOF) {
+			return msgs, nil
+		}
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid request body: %v", err)
+		}
+
+		msg := newMessage(desc)
+		if r := g.opts.unmarshal.Unmarshal(raw, msg); r != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid request message: %v", r)
+		}
+		msgs = append(msgs, msg)
+	}
+}
+
+// setField sets the field at the path (e.g. "author.name") of the message to the values parsed from strings.
+func setField(msg protoreflect.Message, path string, values []string) error {
+	names := strings.Split(path, ".")
+	for _, name := range names[:len(names)-1] {
+		fd := fieldByName(msg.Descriptor(), name)
+		if fd == nil || fd.Message() == nil || fd.IsList() || fd.IsMap() {
+			return fmt.Errorf("%s is not a message field of %s", name, msg.Descriptor().FullName())
+		}
+		msg = msg.Mutable(fd).Message()
+	}
+
+	name := names[len(names)-1]
+	fd := fieldByName(msg.Descriptor(), name)
+	switch {
+	case fd == nil:
+		return fmt.Errorf("unknown field %s of %s", name, msg.Descriptor().FullName())
+	case fd.IsMap():
+		return fmt.Errorf("map field %s cannot be set from a string", name)
+	case fd.IsList():
+		list := msg.Mutable(fd).List()
+		for _, s := range values {
+			v, err := parseValue(fd, s, list.NewElement)
+			if err != nil {
+				return err
+			}
+			list.Append(v)
+		}
+		return nil
+	}
+
+	v, err := parseValue(fd, values[len(values)-1], func() protoreflect.Value { return msg.NewField(fd) })
+	if err != nil {
+		return err
+	}
+	msg.Set(fd, v)
+	return nil
+}
+
+func fieldByName(desc protoreflect.MessageDescriptor, name string) protoreflect.FieldDescriptor {
+	if fd := desc.Fields().ByName(protoreflect.Name(name)); fd != nil {
+		return fd
+	}
+	return desc.Fields().ByJSONName(name)
+}
+
+// parseValue parses the string into a value of the field. Messages are created with newValue and
+// parsed from their JSON string representation, which works for well-known types like google.protobuf.Timestamp.
+func parseValue(fd protoreflect.FieldDescriptor, s string, newValue func() protoreflect.Value) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		v, err := strconv.ParseBool(s)
+		return protoreflect.ValueOfBool(v), err
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		v, err := strconv.ParseInt(s, 10, 32)
+		return protoreflect.ValueOfInt32(int32(v)), err
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		v, err := strconv.ParseInt(s, 10, 64)
+		return protoreflect.ValueOfInt64(v), err
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		v, err := strconv.ParseUint(s, 10, 32)
+		return protoreflect.ValueOfUint32(uint32(v)), err
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		v, err := strconv.ParseUint(s, 10, 64)
+		return protoreflect.ValueOfUint64(v), err
+	case protoreflect.FloatKind:
+		v, err := strconv.ParseFloat(s, 32)
+		return protoreflect.ValueOfFloat32(float32(v)), err
+	case protoreflect.DoubleKind:
+		v, err := strconv.ParseFloat(s, 64)
+		return protoreflect.ValueOfFloat64(v), err
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(s), nil
+	case protoreflect.BytesKind:
+		v, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			v, err = base64.URLEncoding.DecodeString(s)
+		}
+		return protoreflect.ValueOfBytes(v), err
+	case protoreflect.EnumKind:
+		if v := fd.Enum().Values().ByName(protoreflect.Name(s)); v != nil {
+			return protoreflect.ValueOfEnum(v.Number()), nil
+		}
+		v, err := strconv.ParseInt(s, 10, 32)
+		if err != nil {
+			return protoreflect.Value{}, fmt.Errorf("invalid value %s of enum %s", s, fd.Enum().FullName())
+		}
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(v)), nil
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		v := newValue()
+		if r := protojson.Unmarshal([]byte(strconv.Quote(s)), v.Message().Interface()); r != nil {
+			return protoreflect.Value{}, r
+		}
+		return v, nil
+	}
+	return protoreflect.Value{}, fmt.Errorf("unsupported kind %v of field %s", fd.Kind(), fd.Name())
+}
@@ -0,0 +1,188 @@
+package gateway
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// metadataHeaderPrefix prefixes HTTP headers forwarded as metadata to the service
+	// and the header metadata of the response.
+	metadataHeaderPrefix = "Grpc-Metadata-"
+	// trailerHeaderPrefix prefixes the trailer metadata of unary responses.
+	trailerHeaderPrefix = "Grpc-Trailer-"
+
+	contentTypeJSON   = "application/json"
+	contentTypeNDJSON = "application/x-ndjson"
+	contentTypeSSE    = "text/event-stream"
+)
+
+// outgoingMD returns the metadata sent to the service: the Authorization header and the
+// headers prefixed with Grpc-Metadata-.
+func outgoingMD(r *http.Request) metadata.MD {
+	md := metadata.MD{}
+	for key, values := range r.Header {
+		switch {
+		case key == "Authorization":
+			md.Append("authorization", values...)
+		case strings.HasPrefix(key, metadataHeaderPrefix):
+			md.Append(strings.TrimPrefix(key, metadataHeaderPrefix), values...)
+		}
+	}
+	return md
+}
+
+// writeMD adds the metadata to the HTTP headers. Binary values are base64 encoded.
+func writeMD(w http.ResponseWriter, prefix string, md metadata.MD) {
+	for key, values := range md {
+		for _, v := range values {
+			if strings.HasSuffix(key, "-bin") {
+				v = base64.StdEncoding.EncodeToString([]byte(v))
+			}
+			w.Header().Add(prefix+key, v)
+		}
+	}
+}
+
+// httpStatus maps the status code to the HTTP status code.
+func httpStatus(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		// the de facto standard of nginx for a request closed by the client
+		return 499
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// marshalStatus marshals the status of the error as google.rpc.Status.
+func (g *Gateway) marshalStatus(err error) []byte {
+	st := status.Convert(err).Proto()
+	data, r := g.opts.marshal.Marshal(st)
+	if r != nil {
+		// the details cannot be marshaled without their types
+		st.Details = nil
+		data, _ = g.opts.marshal.Marshal(st)
+	}
+	return data
+}
+
+// writeError answers the request with the status of the error.
+func (g *Gateway) writeError(w http.ResponseWriter, err error) {
+	g.writeStatus(w, httpStatus(status.Code(err)), err)
+}
+
+func (g *Gateway) writeStatus(w http.ResponseWriter, code int, err error) {
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.WriteHeader(code)
+	_, _ = w.Write(g.marshalStatus(err))
+}
+
+// writeMessage answers the request with the message.
+func (g *Gateway) writeMessage(w http.ResponseWriter, msg proto.Message) {
+	data, err := g.opts.marshal.Marshal(msg)
+	if err != nil {
+		g.writeError(w, status.Errorf(codes.Internal, "failed to marshal the response: %v", err))
+		return
+	}
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
+
+// streamWriter writes the messages of a server stream. Messages are sent as server-sent events
+// if the client accepts text/event-stream, otherwise as newline delimited JSON.
+type streamWriter struct {
+	g       *Gateway
+	w       http.ResponseWriter
+	flusher http.Flusher
+	sse     bool
+}
+
+func newStreamWriter(g *Gateway, w http.ResponseWriter, r *http.Request) *streamWriter {
+	flusher, _ := w.(http.Flusher)
+	return &streamWriter{
+		g:       g,
+		w:       w,
+		flusher: flusher,
+		sse:     strings.Contains(r.Header.Get("Accept"), contentTypeSSE),
+	}
+}
+
+func (s *streamWriter) start() {
+	if s.sse {
+		s.w.Header().Set("Content-Type", contentTypeSSE)
+		s.w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		s.w.Header().Set("Content-Type", contentTypeNDJSON)
+	}
+	s.w.WriteHeader(http.StatusOK)
+	s.flush()
+}
+
+// message writes a message of the stream as {"result": <message>} or as data of a server-sent event.
+func (s *streamWriter) message(msg proto.Message) error {
+	data, err := s.g.opts.marshal.Marshal(msg)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to marshal the response: %v", err)
+	}
+	return s.write("", "result", data)
+}
+
+// error writes the error ending the stream as {"error": <status>} or as server-sent event of type error.
+func (s *streamWriter) error(err error) {
+	_ = s.write("error", "error", s.g.marshalStatus(err))
+}
+
+func (s *streamWriter) write(event, key string, data []byte) error {
+	var b strings.Builder
+	if s.sse {
+		if event != "" {
+			b.WriteString("event: " + event + "\n")
+		}
+		b.WriteString("data: ")
+		b.Write(data)
+		b.WriteString("\n\n")
+	} else {
+		b.WriteString(`{"` + key + `":`)
+		b.Write(data)
+		b.WriteString("}\n")
+	}
+
+	if _, r := s.w.Write([]byte(b.String())); r != nil {
+		return status.Errorf(codes.Canceled, "failed to write the response: %v", r)
+	}
+	s.flush()
+	return nil
+}
+
+func (s *streamWriter) flush() {
+	if s.flusher != nil {
+		s.flusher.Flush()
+	}
+}
@@ -0,0 +1,108 @@
+package grpcweb
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// statusDetailsKey is the trailer carrying the status along with its details, e.g. errdetails.RetryInfo.
+const statusDetailsKey = "grpc-status-details-bin"
+
+const (
+	frameHeaderLen = 5
+
+	flagCompressed byte = 0x01
+	flagTrailer    byte = 0x80
+)
+
+// readFrame reads the message of the first data frame of the request body.
+func readFrame(r io.Reader, maxSize int) ([]byte, error) {
+	var header [frameHeaderLen]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "grpc-web: failed to read the frame header: %v", err)
+	}
+	if header[0]&flagTrailer != 0 {
+		return nil, status.Error(codes.InvalidArgument, "grpc-web: expected a data frame")
+	}
+	if header[0]&flagCompressed != 0 {
+		return nil, status.Error(codes.Unimplemented, "grpc-web: compressed messages are not supported")
+	}
+
+	length := binary.BigEndian.Uint32(header[1:])
+	if int64(length) > int64(maxSize) {
+		return nil, status.Errorf(codes.ResourceExhausted, "grpc-web: received message larger than max (%d vs. %d)", length, maxSize)
+	}
+	msg := make([]byte, length)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "grpc-web: failed to read the message: %v", err)
+	}
+	return msg, nil
+}
+
+// frame prefixes the payload with the frame header.
+func frame(flag byte, payload []byte) []byte {
+	data := make([]byte, frameHeaderLen+len(payload))
+	data[0] = flag
+	binary.BigEndian.PutUint32(data[1:], uint32(len(payload)))
+	copy(data[frameHeaderLen:], payload)
+	return data
+}
+
+// trailerFrame encodes the status and the trailer metadata as trailer frame.
+func trailerFrame(st *status.Status, trailer metadata.MD) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "grpc-status: %d\r\n", st.Code())
+	fmt.Fprintf(&b, "grpc-message: %s\r\n", encodeMessage(st.Message()))
+
+	keys := make([]string, 0, len(trailer))
+	for key := range trailer {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, v := range trailer[key] {
+			if strings.HasSuffix(key, "-bin") {
+				v = base64.StdEncoding.EncodeToString([]byte(v))
+			}
+			fmt.Fprintf(&b, "%s: %s\r\n", key, v)
+		}
+	}
+	return frame(flagTrailer, []byte(b.String()))
+}
+
+// withStatusDetails adds the status to the trailer if it carries details, so clients can read them.
+func withStatusDetails(st *status.Status, trailer metadata.MD) metadata.MD {
+	if len(st.Proto().GetDetails()) == 0 {
+		return trailer
+	}
+	data, err := proto.Marshal(st.Proto())
+	if err != nil {
+		return trailer
+	}
+	trailer = trailer.Copy()
+	trailer.Set(statusDetailsKey, string(data))
+	return trailer
+}
+
+// encodeMessage percent-encodes the status message as required by the grpc protocol.
+func encodeMessage(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+		if c >= ' ' && c <= '~' && c != '%' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
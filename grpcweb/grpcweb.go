@@ -0,0 +1,319 @@
+// Package grpcweb implements an http.Handler speaking the grpc-web protocol, so web frontends
+// using the grpc-web client can call nrpc services without a separate proxy like Envoy:
+//
+//	client := nrpc.NewClient(pub, sub)
+//	http.ListenAndServe(":8080", grpcweb.New(client, grpcweb.WithAllowedOrigins("https://example.com")))
+//
+// Unary and server streaming calls are supported in the binary (application/grpc-web) and the
+// text (application/grpc-web-text) format. The grpc-web protocol does not support client streams.
+// The messages are passed through without decoding them, so the handler does not need the generated
+// code of the services. Server streaming methods are recognized by looking up the method in
+// protoregistry.GlobalFiles, so the generated Go package of services with server streams must be
+// imported; unknown methods are called as unary methods.
+//
+// The headers of the request are forwarded as metadata to the service, the header metadata of the
+// response is sent as HTTP headers and the status and trailer metadata in the trailer frame.
+package grpcweb
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+const (
+	contentTypeBinary = "application/grpc-web"
+	contentTypeText   = "application/grpc-web-text"
+
+	// defaultMaxRecvMsgSize is the default of grpc-go.
+	defaultMaxRecvMsgSize = 1024 * 1024 * 4
+)
+
+// headers of the HTTP request that are not forwarded as metadata.
+var skipHeaders = map[string]bool{
+	"accept":          true,
+	"accept-encoding": true,
+	"accept-language": true,
+	"connection":      true,
+	"content-length":  true,
+	"content-type":    true,
+	"cookie":          true,
+	"grpc-timeout":    true,
+	"host":            true,
+	"origin":          true,
+	"referer":         true,
+	"te":              true,
+	"user-agent":      true,
+	"x-grpc-web":      true,
+	"x-user-agent":    true,
+}
+
+// Option configures the handler.
+type Option func(opts *options)
+
+type options struct {
+	allowOrigin    func(origin string) bool
+	maxRecvMsgSize int
+}
+
+// WithAllowedOrigins allows browsers to call the services from the given origins (see CORS).
+// "*" allows all origins. Without it, only same-origin requests are possible.
+func WithAllowedOrigins(origins ...string) Option {
+	allowed := make(map[string]bool, len(origins))
+	for _, o := range origins {
+		allowed[o] = true
+	}
+	return WithOriginFunc(func(origin string) bool {
+		return allowed["*"] || allowed[origin]
+	})
+}
+
+// WithOriginFunc sets the function deciding if browsers are allowed to call the services from an origin (see CORS).
+func WithOriginFunc(allow func(origin string) bool) Option {
+	return func(opts *options) {
+		opts.allowOrigin = allow
+	}
+}
+
+// WithMaxRecvMsgSize sets the maximum size of a request message. It defaults to 4MB.
+func WithMaxRecvMsgSize(size int) Option {
+	return func(opts *options) {
+		opts.maxRecvMsgSize = size
+	}
+}
+
+// Handler translates grpc-web requests to calls of nrpc services.
+type Handler struct {
+	conn grpc.ClientConnInterface
+	opts options
+}
+
+// New creates a grpc-web handler sending the calls through the client, usually an *nrpc.Client.
+func New(conn grpc.ClientConnInterface, opts ...Option) *Handler {
+	o := options{
+		allowOrigin:    func(string) bool { return false },
+		maxRecvMsgSize: defaultMaxRecvMsgSize,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return &Handler{
+		conn: conn,
+		opts: o,
+	}
+}
+
+// IsGRPCWebRequest reports whether the request is a grpc-web request or a CORS preflight request for one.
+// Use it to serve grpc-web requests next to other HTTP handlers.
+func IsGRPCWebRequest(r *http.Request) bool {
+	if r.Method == http.MethodOptions {
+		return strings.Contains(strings.ToLower(r.Header.Get("Access-Control-Request-Headers")), "x-grpc-web")
+	}
+	return r.Method == http.MethodPost && strings.HasPrefix(r.Header.Get("Content-Type"), contentTypeBinary)
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if origin := r.Header.Get("Origin"); origin != "" && h.opts.allowOrigin(origin) {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Add("Vary", "Origin")
+		if r.Method == http.MethodOptions {
+			w.Header().Set("Access-Control-Allow-Methods", http.MethodPost)
+			w.Header().Set("Access-Control-Allow-Headers", r.Header.Get("Access-Control-Request-Headers"))
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	if r.Method != http.MethodPost || !strings.HasPrefix(contentType, contentTypeBinary) {
+		http.Error(w, "grpc-web: unsupported request", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	ctx, cancel, err := h.context(r)
+	if err != nil {
+		newResponse(w, contentType).finish(err, nil)
+		return
+	}
+	defer cancel()
+
+	body := io.Reader(r.Body)
+	if strings.HasPrefix(contentType, contentTypeText) {
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+	req, err := readFrame(body, h.opts.maxRecvMsgSize)
+	if err != nil {
+		newResponse(w, contentType).finish(err, nil)
+		return
+	}
+
+	codec := grpc.ForceCodec(rawCodec{name: contentSubtype(contentType)})
+	if serverStreams(r.URL.Path) {
+		h.serveStream(ctx, w, r, req, codec)
+		return
+	}
+
+	var resp []byte
+	var header, trailer metadata.MD
+	err = h.conn.Invoke(ctx, r.URL.Path, &req, &resp, codec, grpc.Header(&header), grpc.Trailer(&trailer))
+
+	res := newResponse(w, contentType)
+	res.header(header)
+	if err == nil {
+		err = res.message(resp)
+	}
+	res.finish(err, trailer)
+}
+
+func (h *Handler) serveStream(ctx context.Context, w http.ResponseWriter, r *http.Request, req []byte, codec grpc.CallOption) {
+	res := newResponse(w, r.Header.Get("Content-Type"))
+
+	stream, err := h.conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, r.URL.Path, codec)
+	if err != nil {
+		res.finish(err, nil)
+		return
+	}
+	if err = stream.SendMsg(&req); err != nil {
+		res.finish(err, nil)
+		return
+	}
+	if err = stream.CloseSend(); err != nil {
+		res.finish(err, nil)
+		return
+	}
+
+	for {
+		var resp []byte
+		err = stream.RecvMsg(&resp)
+		if !res.started() {
+			header, _ := stream.Header()
+			res.header(header)
+		}
+		if err != nil {
+			break
+		}
+		if writeErr := res.message(resp); writeErr != nil {
+			// the client is gone
+			return
+		}
+	}
+	if errors.Is(err, io.EOF) {
+		err = nil
+	}
+	res.finish(err, stream.Trailer())
+}
+
+// context returns the context of the call carrying the metadata and the deadline of the request.
+func (h *Handler) context(r *http.Request) (context.Context, context.CancelFunc, error) {
+	md := metadata.MD{}
+	for key, values := range r.Header {
+		key = strings.ToLower(key)
+		if skipHeaders[key] || strings.HasPrefix(key, "access-control-") || strings.HasPrefix(key, "sec-") {
+			continue
+		}
+		md.Append(key, values...)
+	}
+	ctx := metadata.NewOutgoingContext(r.Context(), md)
+
+	timeout := r.Header.Get("Grpc-Timeout")
+	if timeout == "" {
+		ctx, cancel := context.WithCancel(ctx)
+		return ctx, cancel, nil
+	}
+	d, err := parseTimeout(timeout)
+	if err != nil {
+		return nil, nil, status.Errorf(codes.InvalidArgument, "grpc-web: invalid grpc-timeout %q: %v", timeout, err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, d)
+	return ctx, cancel, nil
+}
+
+// parseTimeout parses the value of the grpc-timeout header, e.g. 100m for 100 milliseconds.
+func parseTimeout(s string) (time.Duration, error) {
+	if len(s) < 2 {
+		return 0, errors.New("too short")
+	}
+	units := map[byte]time.Duration{
+		'H': time.Hour,
+		'M': time.Minute,
+		'S': time.Second,
+		'm': time.Millisecond,
+		'u': time.Microsecond,
+		'n': time.Nanosecond,
+	}
+	unit, ok := units[s[len(s)-1]]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit %q", s[len(s)-1])
+	}
+	v, err := strconv.ParseInt(s[:len(s)-1], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(v) * unit, nil
+}
+
+// contentSubtype returns the codec of the messages given by the content type, e.g. json for application/grpc-web+json.
+func contentSubtype(contentType string) string {
+	contentType = strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0])
+	if i := strings.IndexByte(contentType, '+'); i >= 0 {
+		return strings.ToLower(contentType[i+1:])
+	}
+	return "proto"
+}
+
+// serverStreams reports whether the method (/package.Service/Method) is a server streaming method.
+func serverStreams(method string) bool {
+	name := strings.ReplaceAll(strings.TrimPrefix(method, "/"), "/", ".")
+	d, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil {
+		return false
+	}
+	md, ok := d.(protoreflect.MethodDescriptor)
+	return ok && md.IsStreamingServer()
+}
+
+// rawCodec passes the encoded messages of grpc-web requests and responses through. It carries the
+// name of the codec the messages are encoded with, so the service decodes them with that codec.
+type rawCodec struct {
+	name string
+}
+
+// Name implements the encoding.Codec interface.
+func (c rawCodec) Name() string {
+	return c.name
+}
+
+// Marshal implements the encoding.Codec interface.
+func (c rawCodec) Marshal(v interface{}) ([]byte, error) {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return nil, fmt.Errorf("grpc-web: cannot marshal %T", v)
+	}
+	return *b, nil
+}
+
+// Unmarshal implements the encoding.Codec interface.
+func (c rawCodec) Unmarshal(data []byte, v interface{}) error {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("grpc-web: cannot unmarshal into %T", v)
+	}
+	*b = append((*b)[:0], data...)
+	return nil
+}
@@ -0,0 +1,91 @@
+package grpcweb
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// response writes the response of a call in the grpc-web format.
+type response struct {
+	w           http.ResponseWriter
+	contentType string
+	text        bool
+	expose      []string
+	wroteHeader bool
+}
+
+func newResponse(w http.ResponseWriter, contentType string) *response {
+	return &response{
+		w:           w,
+		contentType: contentType,
+		text:        strings.HasPrefix(contentType, contentTypeText),
+		expose:      []string{"grpc-status", "grpc-message"},
+	}
+}
+
+func (r *response) started() bool {
+	return r.wroteHeader
+}
+
+// header sets the header metadata as HTTP headers. It must be called before the first message.
+func (r *response) header(md metadata.MD) {
+	for key, values := range md {
+		for _, v := range values {
+			if strings.HasSuffix(key, "-bin") {
+				v = base64.StdEncoding.EncodeToString([]byte(v))
+			}
+			r.w.Header().Add(key, v)
+		}
+		r.expose = append(r.expose, key)
+	}
+}
+
+func (r *response) writeHeader() {
+	if r.wroteHeader {
+		return
+	}
+	r.wroteHeader = true
+
+	r.w.Header().Set("Content-Type", r.contentType)
+	r.w.Header().Set("Access-Control-Expose-Headers", strings.Join(r.expose, ", "))
+	r.w.WriteHeader(http.StatusOK)
+}
+
+// message writes a data frame with the message.
+func (r *response) message(msg []byte) error {
+	r.writeHeader()
+	return r.write(frame(0, msg))
+}
+
+// finish ends the response with the status of the error and the trailer metadata. Without messages
+// written, a trailers-only response is sent carrying the status in the HTTP headers.
+func (r *response) finish(err error, trailer metadata.MD) {
+	st := status.Convert(err)
+	trailer = withStatusDetails(st, trailer)
+	if !r.wroteHeader {
+		r.w.Header().Set("grpc-status", strconv.Itoa(int(st.Code())))
+		r.w.Header().Set("grpc-message", encodeMessage(st.Message()))
+		r.header(trailer)
+		r.writeHeader()
+		return
+	}
+	_ = r.write(trailerFrame(st, trailer))
+}
+
+func (r *response) write(data []byte) error {
+	if r.text {
+		data = []byte(base64.StdEncoding.EncodeToString(data))
+	}
+	if _, err := r.w.Write(data); err != nil {
+		return err
+	}
+	if f, ok := r.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package nrpc
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// HedgingPolicy configures the hedging of unary calls to idempotent methods. It mirrors the
+// hedging policy of the gRPC service config. Hedged requests are distributed to the servers of
+// a queue group by the broker, so a slow server does not hold up the call.
+type HedgingPolicy struct {
+	// MaxAttempts is the maximum number of requests sent including the original one.
+	// A value of 1 or less disables hedging.
+	MaxAttempts int
+	// HedgingDelay is the delay after which the next request is sent if no response was received yet.
+	HedgingDelay time.Duration
+	// NonFatalStatusCodes are the status codes that cause the next request to be sent immediately
+	// instead of failing the call. By default, every error fails the call.
+	NonFatalStatusCodes []codes.Code
+}
+
+func (p HedgingPolicy) enabled() bool {
+	return p.MaxAttempts > 1
+}
+
+func (p HedgingPolicy) nonFatal(err error) bool {
+	code := status.Code(err)
+	for _, c := range p.NonFatalStatusCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+type hedgeResult struct {
+	resp *Response
+	err  error
+}
+
+// hedge sends up to MaxAttempts requests, each one HedgingDelay after the previous one or immediately
+// if the previous one failed with a non-fatal status code. It returns the first successful response
+// and cancels the outstanding requests.
+func hedge(ctx context.Context, policy HedgingPolicy, fn func(ctx context.Context) (*Response, error)) (*Response, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan hedgeResult, policy.MaxAttempts)
+	var started, pending int
+	send := func() {
+		started++
+		pending++
+		go func() {
+			resp, err := fn(ctx)
+			results <- hedgeResult{resp: resp, err: err}
+		}()
+	}
+
+	send()
+	timer := time.NewTimer(policy.HedgingDelay)
+	defer timer.Stop()
+
+	var err error
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if started < policy.MaxAttempts {
+				send()
+				timer.Reset(policy.HedgingDelay)
+			}
+		case res := <-results:
+			pending--
+			if res.err == nil {
+				return res.resp, nil
+			}
+			err = res.err
+			if !policy.nonFatal(err) {
+				return nil, err
+			}
+			if started < policy.MaxAttempts {
+				send()
+				resetTimer(timer, policy.HedgingDelay)
+			}
+		}
+	}
+	return nil, err
+}
+
+func resetTimer(timer *time.Timer, d time.Duration) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+	timer.Reset(d)
+}
+
+// hedgingPolicies holds the hedging policies configured for methods, services and the default.
+type hedgingPolicies map[string]HedgingPolicy
+
+// get returns the hedging policy of the full method (/service/method).
+func (p hedgingPolicies) get(method string) HedgingPolicy {
+	for _, key := range policyKeys(method) {
+		if policy, ok := p[key]; ok {
+			return policy
+		}
+	}
+	return HedgingPolicy{}
+}
+
+// Hedge returns a CallOption that sets the hedging policy of the call. It overwrites
+// the policies configured with the WithHedgingPolicy option of the client.
+// Pass an empty HedgingPolicy to disable hedging for the call.
+func Hedge(policy HedgingPolicy) grpc.CallOption {
+	return callOption{apply: func(opt *callOptions) {
+		opt.hedging = policy
+	}}
+}
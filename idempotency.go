@@ -0,0 +1,191 @@
+package nrpc
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// idempotencyTTL is the default time the responses of calls with an idempotency key are stored.
+const idempotencyTTL = 10 * time.Minute
+
+// IdempotencyStore stores the responses of unary calls with an idempotency key (see WithIdempotency).
+// Servers sharing a store execute a call once even if its retries reach different instances, as long as
+// they do not arrive concurrently. It must be safe for concurrent use.
+type IdempotencyStore interface {
+	// Load returns the response stored for the key. It reports false if there is none or it expired.
+	Load(key string) ([]byte, bool, error)
+	// Store stores the response for the key until the TTL passed.
+	Store(key string, resp []byte, ttl time.Duration) error
+}
+
+// IdempotencyKey returns a CallOption that sends the unary call with the idempotency key. Servers with
+// idempotency enabled execute the call once per key and reply to later calls with the stored response,
+// e.g. to calls redelivered from the outbox (see WithOutbox). It overwrites the keys generated for
+// the methods configured with the WithIdempotencyKeys option of the client.
+func IdempotencyKey(key string) grpc.CallOption {
+	return callOption{apply: func(opt *callOptions) {
+		opt.idempotencyKey = key
+	}}
+}
+
+// idempotentMethods holds the methods and services the client generates idempotency keys for.
+// The empty key holds the default.
+type idempotentMethods map[string]bool
+
+// get reports whether the client generates idempotency keys for the full method (/service/method).
+func (m idempotentMethods) get(method string) bool {
+	for _, key := range policyKeys(method) {
+		if enabled, ok := m[key]; ok {
+			return enabled
+		}
+	}
+	return false
+}
+
+// idempotency executes unary calls with the same idempotency key once and replays their response.
+type idempotency struct {
+	store IdempotencyStore
+	ttl   time.Duration
+	log   Logger
+
+	m        sync.Mutex
+	inFlight map[string]chan struct{}
+}
+
+func newIdempotency(store IdempotencyStore, ttl time.Duration, log Logger) *idempotency {
+	if store == nil {
+		return nil
+	}
+	if ttl <= 0 {
+		ttl = idempotencyTTL
+	}
+	return &idempotency{store: store, ttl: ttl, log: log, inFlight: map[string]chan struct{}{}}
+}
+
+// begin starts the call of the full method with the idempotency key. It returns the stored response of
+// a previous call with the key or the call to execute. Calls with a key being executed by this server
+// wait for the execution to end. The key is scoped to the tenant and the authenticated user of the call,
+// so it must only be called once the call was authorized.
+func (i *idempotency) begin(ctx context.Context, method, key string) ([]byte, *idempotentCall, error) {
+	key = idempotencyScope(ctx, method) + key
+	for {
+		i.m.Lock()
+		done, ok := i.inFlight[key]
+		if !ok {
+			done = make(chan struct{})
+			i.inFlight[key] = done
+		}
+		i.m.Unlock()
+
+		if !ok {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return nil, nil, status.FromContextError(ctx.Err()).Err()
+		case <-done:
+		}
+	}
+
+	call := &idempotentCall{idempotency: i, key: key}
+	resp, ok, err := i.store.Load(key)
+	if err != nil {
+		call.end()
+		return nil, nil, status.Errorf(codes.Unavailable, "nrpc: failed to load the response of the idempotency key: %v", err)
+	}
+	if ok {
+		call.end()
+		return resp, nil, nil
+	}
+	return nil, call, nil
+}
+
+// idempotencyScope returns the prefix of the stored keys of the calls of the method with the context:
+// the method, the tenant and the authenticated user, separated by NUL bytes as users may contain spaces.
+func idempotencyScope(ctx context.Context, method string) string {
+	tenant, _ := TenantFromContext(ctx)
+	var user string
+	if p, ok := PeerFromContext(ctx); ok {
+		user = p.User
+	}
+	return method + "\x00" + tenant + "\x00" + user + "\x00"
+}
+
+// idempotentCall is the execution of a call with an idempotency key.
+type idempotentCall struct {
+	*idempotency
+	key  string
+	resp []byte
+}
+
+// succeed records the response of the call to be stored. Only successful responses are stored, so calls
+// failing or rejected before the handler ran, e.g. by the concurrency limit, are executed again when retried.
+func (c *idempotentCall) succeed(resp []byte) {
+	c.resp = resp
+}
+
+// end stores the response of the successful call and lets waiting calls with the key proceed.
+func (c *idempotentCall) end() {
+	if c.resp != nil {
+		if r := c.store.Store(c.key, c.resp, c.ttl); r != nil {
+			c.log.Error("failed to store the response of the idempotency key", "key", c.key, "error", r)
+		}
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	close(c.inFlight[c.key])
+	delete(c.inFlight, c.key)
+}
+
+// memoryIdempotencyStore is the default IdempotencyStore keeping the responses in memory.
+type memoryIdempotencyStore struct {
+	m         sync.Mutex
+	responses map[string]storedResponse
+	lastSweep time.Time
+}
+
+type storedResponse struct {
+	resp    []byte
+	expires time.Time
+}
+
+func newMemoryIdempotencyStore() *memoryIdempotencyStore {
+	return &memoryIdempotencyStore{responses: map[string]storedResponse{}, lastSweep: time.Now()}
+}
+
+// Load implements the IdempotencyStore interface.
+func (s *memoryIdempotencyStore) Load(key string) ([]byte, bool, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	stored, ok := s.responses[key]
+	if !ok || time.Now().After(stored.expires) {
+		return nil, false, nil
+	}
+	return stored.resp, true, nil
+}
+
+// Store implements the IdempotencyStore interface. Expired responses are removed at most once per TTL.
+func (s *memoryIdempotencyStore) Store(key string, resp []byte, ttl time.Duration) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.lastSweep) > ttl {
+		for k, stored := range s.responses {
+			if now.After(stored.expires) {
+				delete(s.responses, k)
+			}
+		}
+		s.lastSweep = now
+	}
+	s.responses[key] = storedResponse{resp: resp, expires: now.Add(ttl)}
+	return nil
+}
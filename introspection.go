@@ -0,0 +1,244 @@
+package nrpc
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// StreamInfo describes an active stream of a client or server, e.g. to diagnose stuck consumers.
+type StreamInfo struct {
+	// Method is the full method (/service/method) of the stream.
+	Method string
+	// Client reports whether the stream is the client side of the stream.
+	Client bool
+	// ReqSubject and RespSubject are the subjects the messages of the client and the server are sent on.
+	ReqSubject  string
+	RespSubject string
+	// Started is the time the stream was opened.
+	Started time.Time
+	// LastActivity is the time the last frame was sent or received.
+	LastActivity time.Time
+	// FramesSent and FramesReceived count the frames of the stream including control frames like pings.
+	FramesSent     uint64
+	FramesReceived uint64
+	// BytesSent and BytesReceived count the bytes of the frames as published, including their envelope.
+	BytesSent     uint64
+	BytesReceived uint64
+	// Buffered is the number of received messages waiting to be consumed, BufferSize the size of the receive buffer.
+	Buffered   int
+	BufferSize int
+	// WireVersion is the version of the envelope negotiated for the stream (see WireVersion).
+	WireVersion uint32
+}
+
+// StreamLister lists the active streams. It is implemented by Client and Server.
+type StreamLister interface {
+	Streams() []StreamInfo
+}
+
+// MethodStats aggregates the streams of a method.
+type MethodStats struct {
+	// Active is the number of active streams, Ended the number of streams ended since the start.
+	Active int
+	Ended  uint64
+	// FramesSent, FramesReceived, BytesSent and BytesReceived sum up the counters of the active and ended streams.
+	FramesSent     uint64
+	FramesReceived uint64
+	BytesSent      uint64
+	BytesReceived  uint64
+	// Buffered is the number of received messages of the active streams waiting to be consumed.
+	Buffered int
+	// LastActivity is the time the last frame of a stream of the method was sent or received.
+	LastActivity time.Time
+}
+
+// add adds the counters of the stream.
+func (m *MethodStats) add(info StreamInfo) {
+	m.FramesSent += info.FramesSent
+	m.FramesReceived += info.FramesReceived
+	m.BytesSent += info.BytesSent
+	m.BytesReceived += info.BytesReceived
+	if info.LastActivity.After(m.LastActivity) {
+		m.LastActivity = info.LastActivity
+	}
+}
+
+// Stats is a snapshot of the statistics of the streams of a client or server.
+type Stats struct {
+	// Methods holds the statistics per full method (/service/method).
+	Methods map[string]MethodStats
+}
+
+type streamKey struct{}
+
+// StreamStatsFromContext returns the statistics of the stream the context belongs to, i.e. the context
+// of a client stream or the context passed to a stream handler.
+func StreamStatsFromContext(ctx context.Context) (StreamInfo, bool) {
+	stream, ok := ctx.Value(streamKey{}).(introspectable)
+	if !ok {
+		return StreamInfo{}, false
+	}
+	return stream.Stats(), true
+}
+
+// Stats returns the statistics of the streams of the client per method.
+func (s *Client) Stats() Stats {
+	return s.streams.stats()
+}
+
+// Stats returns the statistics of the streams of the server per method.
+func (s *Server) Stats() Stats {
+	return s.streams.stats()
+}
+
+// Streams returns the active streams of the client ordered by the time they were opened.
+func (s *Client) Streams() []StreamInfo {
+	return s.streams.list()
+}
+
+// Streams returns the active streams of the server ordered by the time they were opened.
+func (s *Server) Streams() []StreamInfo {
+	return s.streams.list()
+}
+
+// streamActivity counts the frames of a stream and their bytes. It is accessed atomically.
+type streamActivity struct {
+	sent          uint64
+	received      uint64
+	bytesSent     uint64
+	bytesReceived uint64
+	// last is the time of the last frame in unix nanoseconds.
+	last int64
+}
+
+func newStreamActivity() *streamActivity {
+	return &streamActivity{last: time.Now().UnixNano()}
+}
+
+func (a *streamActivity) sentFrame(size int) {
+	atomic.AddUint64(&a.sent, 1)
+	atomic.AddUint64(&a.bytesSent, uint64(size))
+	atomic.StoreInt64(&a.last, time.Now().UnixNano())
+}
+
+func (a *streamActivity) receivedFrame(size int) {
+	atomic.AddUint64(&a.received, 1)
+	atomic.AddUint64(&a.bytesReceived, uint64(size))
+	atomic.StoreInt64(&a.last, time.Now().UnixNano())
+}
+
+// fill sets the frame counts and the last activity of the info.
+func (a *streamActivity) fill(info *StreamInfo) {
+	info.FramesSent = atomic.LoadUint64(&a.sent)
+	info.FramesReceived = atomic.LoadUint64(&a.received)
+	info.BytesSent = atomic.LoadUint64(&a.bytesSent)
+	info.BytesReceived = atomic.LoadUint64(&a.bytesReceived)
+	info.LastActivity = time.Unix(0, atomic.LoadInt64(&a.last))
+}
+
+// introspectable is implemented by the streams of clients and servers.
+type introspectable interface {
+	Stats() StreamInfo
+}
+
+// activeStreams keeps track of the active streams of a client or server and the totals of the ended ones.
+type activeStreams struct {
+	m       sync.Mutex
+	streams map[introspectable]struct{}
+	ended   map[string]MethodStats
+}
+
+func newActiveStreams() *activeStreams {
+	return &activeStreams{
+		streams: map[introspectable]struct{}{},
+		ended:   map[string]MethodStats{},
+	}
+}
+
+// add registers the stream and returns a function to remove it again.
+func (a *activeStreams) add(stream introspectable) func() {
+	a.m.Lock()
+	a.streams[stream] = struct{}{}
+	a.m.Unlock()
+
+	return func() {
+		a.m.Lock()
+		defer a.m.Unlock()
+
+		if _, ok := a.streams[stream]; !ok {
+			return
+		}
+		delete(a.streams, stream)
+
+		info := stream.Stats()
+		ended := a.ended[info.Method]
+		ended.Ended++
+		ended.add(info)
+		a.ended[info.Method] = ended
+	}
+}
+
+func (a *activeStreams) stats() Stats {
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	methods := make(map[string]MethodStats, len(a.ended))
+	for method, ended := range a.ended {
+		methods[method] = ended
+	}
+	for stream := range a.streams {
+		info := stream.Stats()
+		method := methods[info.Method]
+		method.Active++
+		method.Buffered += info.Buffered
+		method.add(info)
+		methods[info.Method] = method
+	}
+	return Stats{Methods: methods}
+}
+
+func (a *activeStreams) list() []StreamInfo {
+	a.m.Lock()
+	infos := make([]StreamInfo, 0, len(a.streams))
+	for stream := range a.streams {
+		infos = append(infos, stream.Stats())
+	}
+	a.m.Unlock()
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Started.Before(infos[j].Started)
+	})
+	return infos
+}
+
+func (s *clientStream) Stats() StreamInfo {
+	info := StreamInfo{
+		Method:      s.method,
+		Client:      true,
+		ReqSubject:  s.reqSubj,
+		RespSubject: s.respSubj,
+		Started:     s.start,
+		Buffered:    len(s.chRecv),
+		BufferSize:  cap(s.chRecv),
+		WireVersion: atomic.LoadUint32(&s.version),
+	}
+	s.activity.fill(&info)
+	return info
+}
+
+func (s *serverStream) Stats() StreamInfo {
+	info := StreamInfo{
+		Method:      s.fullMethod,
+		ReqSubject:  s.reqSubj,
+		RespSubject: s.respSubj,
+		Started:     s.start,
+		Buffered:    len(s.chRecv),
+		BufferSize:  cap(s.chRecv),
+		WireVersion: s.version,
+	}
+	s.activity.fill(&info)
+	return info
+}
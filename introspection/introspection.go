@@ -0,0 +1,76 @@
+// Package introspection serves the active streams of nrpc clients and servers over HTTP, e.g. to
+// diagnose the stuck consumers reported in the logs:
+//
+//	mux.Handle("/debug/nrpc/streams", introspection.Handler(server, client))
+//
+// The streams are served as JSON. Text clients like curl get a table with ?format=text.
+package introspection
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/tehsphinx/nrpc"
+)
+
+// Stream is the JSON representation of an active stream.
+type Stream struct {
+	nrpc.StreamInfo
+	// Age is the time since the stream was opened.
+	Age string
+	// Idle is the time since the last frame was sent or received.
+	Idle string
+}
+
+// Handler returns an HTTP handler serving the active streams of the clients and servers ordered
+// by the time they were opened.
+func Handler(listers ...nrpc.StreamLister) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		streams := list(listers, time.Now())
+
+		if r.URL.Query().Get("format") == "text" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			writeText(w, streams)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(struct {
+			Streams []Stream
+		}{Streams: streams})
+	})
+}
+
+func list(listers []nrpc.StreamLister, now time.Time) []Stream {
+	streams := []Stream{}
+	for _, lister := range listers {
+		for _, info := range lister.Streams() {
+			streams = append(streams, Stream{
+				StreamInfo: info,
+				Age:        now.Sub(info.Started).Round(time.Millisecond).String(),
+				Idle:       now.Sub(info.LastActivity).Round(time.Millisecond).String(),
+			})
+		}
+	}
+	sort.SliceStable(streams, func(i, j int) bool {
+		return streams[i].Started.Before(streams[j].Started)
+	})
+	return streams
+}
+
+func writeText(w http.ResponseWriter, streams []Stream) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "SIDE\tMETHOD\tREQ SUBJECT\tRESP SUBJECT\tAGE\tIDLE\tSENT\tRECEIVED\tBUFFERED")
+	for _, s := range streams {
+		side := "server"
+		if s.Client {
+			side = "client"
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%d\t%d\t%d/%d\n", side, s.Method, s.ReqSubject, s.RespSubject,
+			s.Age, s.Idle, s.FramesSent, s.FramesReceived, s.Buffered, s.BufferSize)
+	}
+	_ = tw.Flush()
+}
@@ -0,0 +1,37 @@
+package nrpc
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+// UnaryCall is one of the unary calls sent at once by Client.InvokeAll.
+type UnaryCall struct {
+	// Method is the full method (/service/method) of the call.
+	Method string
+	// Args is the request of the call, Reply receives its response.
+	Args  interface{}
+	Reply interface{}
+	// Opts are applied to the call after the options passed to InvokeAll.
+	Opts []grpc.CallOption
+}
+
+// InvokeAll sends the unary calls, possibly of different methods, at once and waits for all of their
+// responses. The calls share the deadline of the context. It returns the errors of the calls in the
+// order of the calls, nil for the calls whose response was received into their reply. Like the calls of
+// InvokeAsync, the calls wait for their responses without a goroutine each on publishers implementing
+// pubsub.AsyncRequester, which receive the responses of all calls on a single response inbox.
+func (s *Client) InvokeAll(ctx context.Context, calls []UnaryCall, opts ...grpc.CallOption) []error {
+	futures := make([]*Future, len(calls))
+	for i, call := range calls {
+		callOpts := append(append(make([]grpc.CallOption, 0, len(opts)+len(call.Opts)), opts...), call.Opts...)
+		futures[i] = s.InvokeAsync(ctx, call.Method, call.Args, call.Reply, callOpts...)
+	}
+
+	errs := make([]error, len(calls))
+	for i, future := range futures {
+		errs[i] = future.Result()
+	}
+	return errs
+}
@@ -0,0 +1,198 @@
+package nrpc
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/tehsphinx/nrpc/pubsub"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// jobBackoff is the backoff before failed jobs are delivered again. The backoff grows with the deliveries
+// of the job if the subscriber counts them (see pubsub.DeliveryCounter).
+var jobBackoff = RetryPolicy{
+	InitialBackoff:    100 * time.Millisecond,
+	MaxBackoff:        time.Minute,
+	BackoffMultiplier: 2,
+}
+
+// JobMethod returns the full method (/service/method) jobs published on the subject are handled as
+// (see EventMethod).
+func JobMethod(subject string) string {
+	return "/" + jobService + "/" + subject
+}
+
+// isJob reports whether the full method is the method of jobs.
+func isJob(method string) bool {
+	return serviceName(method) == jobService
+}
+
+// PublishJob publishes the job on the subject to the servers handling it (see Server.HandleJob). Jobs are
+// sent like events (see Client.PublishEvent), but they are persisted by the broker until a server handled
+// them, e.g. with the jetstream publisher and a stream covering jetstream.JobSubjects. Unlike one-way
+// calls, jobs outlive the deadline of the context: they are bound by the handler timeouts of the server.
+func (s *Client) PublishJob(ctx context.Context, subject string, job interface{}, opts ...grpc.CallOption) error {
+	return s.Invoke(ctx, JobMethod(subject), job, nil, append(opts, OneWay())...)
+}
+
+// HandleJob registers the handler for the jobs published on the subject (see Client.PublishJob). Each job
+// is handled by one server handling the subject. Jobs run through the same stack as events (see
+// HandleEvent) and are leased to their handler: a job neither acknowledged nor extended in time, e.g.
+// because its server crashed, is delivered again. The handler controls the acknowledgement with the Job
+// returned by JobFromContext. Jobs the handler did not settle are acknowledged if it succeeded and
+// dropped if they cannot be decoded or the handler failed with codes.PermissionDenied,
+// codes.Unauthenticated, codes.InvalidArgument or codes.Unimplemented. Other failed jobs are requeued
+// with a backoff growing with their deliveries. Jobs are acknowledged only if the subscriber of the server
+// supports it (see pubsub.Acknowledger), e.g. the jetstream subscriber; other subscribers deliver jobs at
+// most once. Like RegisterService, it panics if it is called twice for the same subject or after the
+// server started.
+func (s *Server) HandleJob(subject string, newJob func() interface{}, handler EventHandler) {
+	s.registerEvent("HandleJob", subject, JobMethod(subject), jobService, newJob, handler,
+		func(h pubsub.Handler) pubsub.Handler {
+			return func(ctx context.Context, msg pubsub.Replier) {
+				job := &Job{}
+				job.acker = acknowledger(msg)
+
+				h(context.WithValue(ctx, jobKey{}, job), msg)
+				if err := job.finish(); err != nil {
+					s.log.Warn("failed to acknowledge the job", "subject", msg.Subject(), "error", err)
+				}
+			}
+		})
+}
+
+// acknowledger returns the acknowledger of the received message, following the messages wrapped by
+// nrpc, e.g. decrypted messages (see WithEncryption). It returns nil if the subscriber does not support
+// acknowledgements.
+func acknowledger(msg pubsub.Replier) pubsub.Acknowledger {
+	for {
+		if acker, ok := msg.(pubsub.Acknowledger); ok {
+			return acker
+		}
+		wrapper, ok := msg.(interface{ Unwrap() pubsub.Replier })
+		if !ok {
+			return nil
+		}
+		msg = wrapper.Unwrap()
+	}
+}
+
+type jobKey struct{}
+
+// JobFromContext returns the job handled with the context (see Server.HandleJob). It returns nil if the
+// context is not the context of a job handler.
+func JobFromContext(ctx context.Context) *Job {
+	job, _ := ctx.Value(jobKey{}).(*Job)
+	return job
+}
+
+// Job controls the acknowledgement of a job handled by a server (see Server.HandleJob). A job is settled
+// once it was acknowledged or requeued. Its methods are safe for concurrent use.
+type Job struct {
+	acker pubsub.Acknowledger
+
+	m       sync.Mutex
+	settled bool
+	// done reports whether the handler returned, err is its error.
+	done bool
+	err  error
+	// invalid reports whether the job could not be decoded.
+	invalid bool
+}
+
+// Ack acknowledges the job: it is not delivered again.
+func (s *Job) Ack() error {
+	return s.settle(pubsub.Acknowledger.Ack)
+}
+
+// Nack requeues the job: it is delivered again, to any server handling it, once the delay passed.
+func (s *Job) Nack(delay time.Duration) error {
+	return s.settle(func(acker pubsub.Acknowledger) error {
+		return acker.Nack(delay)
+	})
+}
+
+// Extend extends the lease of the job, so it is not delivered again while the handler still works on it.
+// Handlers of long-running jobs call it periodically within the lease time of the subscriber (see
+// jetstream.JobAckWait).
+func (s *Job) Extend() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.settled {
+		return errJobSettled()
+	}
+	if s.acker == nil {
+		return nil
+	}
+	return s.acker.InProgress()
+}
+
+// settle settles the job with the function unless it was settled already.
+func (s *Job) settle(fn func(pubsub.Acknowledger) error) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.settled {
+		return errJobSettled()
+	}
+	s.settled = true
+	if s.acker == nil {
+		return nil
+	}
+	return fn(s.acker)
+}
+
+// handled records the result of the handler. invalid reports whether the job could not be decoded.
+// It does nothing on a nil job, so events share the code handling jobs.
+func (s *Job) handled(err error, invalid bool) {
+	if s == nil {
+		return
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.done, s.err, s.invalid = true, err, invalid
+}
+
+// finish settles the job the handler did not settle: successfully handled jobs are acknowledged, invalid
+// ones and the ones failing for good are dropped and all others are requeued after a backoff.
+func (s *Job) finish() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.settled || s.acker == nil {
+		return nil
+	}
+	s.settled = true
+	switch {
+	case s.invalid, !retryableJob(s.err):
+		return s.acker.Term()
+	case s.done && s.err == nil:
+		return s.acker.Ack()
+	default:
+		deliveries := 1
+		if counter, ok := s.acker.(pubsub.DeliveryCounter); ok {
+			deliveries = counter.Deliveries()
+		}
+		return s.acker.Nack(jobBackoff.backoff(deliveries))
+	}
+}
+
+// retryableJob reports whether a job failing with the error may succeed when delivered again. Jobs that
+// are rejected or not handled at all fail on every delivery.
+func retryableJob(err error) bool {
+	switch status.Code(err) {
+	case codes.PermissionDenied, codes.Unauthenticated, codes.InvalidArgument, codes.Unimplemented:
+		return false
+	default:
+		return true
+	}
+}
+
+func errJobSettled() error {
+	return status.Error(codes.FailedPrecondition, "nrpc: the job was already settled")
+}
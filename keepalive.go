@@ -0,0 +1,66 @@
+package nrpc
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// keepalive sends pings on a stream and detects a dead peer. Both sides of the stream ping
+// each other in the interval. If no message is received from the other side within the
+// interval plus the timeout, the stream is aborted.
+type keepalive struct {
+	// lastRecv is the time in unix nanoseconds a message was last received. It is accessed
+	// atomically and kept first in the struct to guarantee 64-bit alignment.
+	lastRecv int64
+
+	interval time.Duration
+	timeout  time.Duration
+}
+
+func newKeepalive(interval, timeout time.Duration) *keepalive {
+	return &keepalive{
+		lastRecv: time.Now().UnixNano(),
+		interval: interval,
+		timeout:  timeout,
+	}
+}
+
+func (k *keepalive) enabled() bool {
+	return k.interval > 0
+}
+
+// received records that a message of the other side was received.
+func (k *keepalive) received() {
+	atomic.StoreInt64(&k.lastRecv, time.Now().UnixNano())
+}
+
+// run pings the other side in the interval until the context is done. It calls abort if the
+// other side did not send anything within the interval plus the timeout.
+func (k *keepalive) run(ctx context.Context, peer string, ping func() error, abort func(err error)) {
+	if !k.enabled() {
+		return
+	}
+	k.received()
+
+	tick := time.NewTicker(k.interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+
+		silence := time.Since(time.Unix(0, atomic.LoadInt64(&k.lastRecv)))
+		if silence > k.interval+k.timeout {
+			abort(status.Errorf(codes.Unavailable, "keepalive timeout: no message received from the %s for %v", peer, silence))
+			return
+		}
+		_ = ping()
+	}
+}
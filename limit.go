@@ -0,0 +1,98 @@
+package nrpc
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ConcurrencyLimit limits the number of calls and streams a server handles concurrently, so a burst
+// of calls cannot exhaust the resources of the server. Calls exceeding the limit wait in a bounded queue
+// for a free slot. Calls that do not fit into the queue or wait too long are rejected with codes.ResourceExhausted.
+type ConcurrencyLimit struct {
+	// MaxConcurrent is the maximum number of calls handled at the same time. 0 disables the limit.
+	MaxConcurrent int
+	// MaxQueued is the maximum number of calls waiting for a free slot. With 0, calls
+	// are rejected right away if there is no free slot.
+	MaxQueued int
+	// MaxWait is the maximum time a call waits for a free slot. 0 waits until the deadline of the call.
+	// Streams wait at most the stream connect timeout, as the client does not wait for the stream
+	// to be accepted any longer.
+	MaxWait time.Duration
+}
+
+// concurrencyLimits holds the concurrency limits configured for methods, services and the default.
+type concurrencyLimits map[string]ConcurrencyLimit
+
+// get returns the concurrency limit of the full method (/service/method).
+func (p concurrencyLimits) get(method string) ConcurrencyLimit {
+	for _, key := range policyKeys(method) {
+		if limit, ok := p[key]; ok {
+			return limit
+		}
+	}
+	return ConcurrencyLimit{}
+}
+
+// limiter enforces a concurrency limit. A nil limiter does not limit.
+type limiter struct {
+	// queued is the number of waiting calls. It is accessed atomically.
+	queued int32
+
+	limit ConcurrencyLimit
+	slots chan struct{}
+}
+
+func newLimiter(limit ConcurrencyLimit) *limiter {
+	if limit.MaxConcurrent <= 0 {
+		return nil
+	}
+	return &limiter{
+		limit: limit,
+		slots: make(chan struct{}, limit.MaxConcurrent),
+	}
+}
+
+// acquire waits for a free slot and returns a function to release it again.
+func (l *limiter) acquire(ctx context.Context) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return l.release, nil
+	default:
+	}
+
+	if int(atomic.AddInt32(&l.queued, 1)) > l.limit.MaxQueued {
+		atomic.AddInt32(&l.queued, -1)
+		return nil, status.Errorf(codes.ResourceExhausted, "nrpc: concurrency limit of %d calls reached", l.limit.MaxConcurrent)
+	}
+	defer atomic.AddInt32(&l.queued, -1)
+
+	waitCtx := ctx
+	if l.limit.MaxWait > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx, l.limit.MaxWait)
+		defer cancel()
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return l.release, nil
+	case <-waitCtx.Done():
+	}
+	if r := ctx.Err(); r != nil {
+		return nil, r
+	}
+	return nil, status.Errorf(codes.ResourceExhausted, "nrpc: concurrency limit of %d calls reached: no free slot within %v",
+		l.limit.MaxConcurrent, l.limit.MaxWait)
+}
+
+func (l *limiter) release() {
+	<-l.slots
+}
@@ -0,0 +1,244 @@
+// Package liveconfig applies changes of the configuration of running nrpc clients and servers without a
+// restart. The configuration is a JSON document stored under a key of a NATS key-value bucket:
+//
+//	{
+//	  "logLevel": "debug",
+//	  "handlerTimeouts": {"": "30s", "foo.Foo": "2s", "/foo.Foo/Bar": "500ms"},
+//	  "rateLimits": {"foo.Foo": {"rate": 100, "burst": 20}},
+//	  "serviceConfig": {"methodConfig": [{"name": [{"service": "foo.Foo"}], "timeout": "1s"}]}
+//	}
+//
+// The watcher applies every version of the document to its targets and notifies the application:
+//
+//	log := nrpc.NewLevelLogger(logger, nrpc.LevelInfo)
+//	limiter := ratelimit.New()
+//	server := nrpc.NewServer(pub, sub, append(limiter.ServerOptions(), nrpc.WithLogger(log))...)
+//
+//	watcher := liveconfig.New(liveconfig.WithServer(server), liveconfig.WithLimiter(limiter),
+//		liveconfig.WithLogger(log), liveconfig.OnChange(func(cfg *liveconfig.Config) { ... }))
+//	err := watcher.Watch(ctx, kv, "orders")
+//
+// Sections missing from the document leave the configuration of their targets unchanged. Methods are
+// keyed like the methods of the options: by full method (/service/method), by service name or by "" for
+// the default. The application reads its own fields of the document from Config.Document.
+package liveconfig
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/tehsphinx/nrpc"
+	"github.com/tehsphinx/nrpc/ratelimit"
+)
+
+// Config is a version of the configuration.
+type Config struct {
+	// LogLevel is the level set on the loggers of the watcher. It is nil if the document does not set it.
+	LogLevel *nrpc.Level
+	// HandlerTimeouts are the timeouts set on the handlers of the servers of the watcher.
+	HandlerTimeouts map[string]time.Duration
+	// RateLimits are the limits set on the limiters of the watcher.
+	RateLimits map[string]ratelimit.Limit
+	// ServiceConfig is the service config set on the clients of the watcher.
+	ServiceConfig *nrpc.ServiceConfig
+	// Document is the JSON document the configuration was parsed from.
+	Document []byte
+}
+
+type jsonConfig struct {
+	LogLevel        *nrpc.Level          `json:"logLevel"`
+	HandlerTimeouts map[string]string    `json:"handlerTimeouts"`
+	RateLimits      map[string]jsonLimit `json:"rateLimits"`
+	ServiceConfig   json.RawMessage      `json:"serviceConfig"`
+}
+
+type jsonLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// Parse parses a configuration document. Durations are given like "1.5s" or "500ms".
+func Parse(data []byte) (*Config, error) {
+	var doc jsonConfig
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("liveconfig: invalid document: %w", err)
+	}
+
+	cfg := &Config{LogLevel: doc.LogLevel, Document: data}
+	if doc.HandlerTimeouts != nil {
+		cfg.HandlerTimeouts = make(map[string]time.Duration, len(doc.HandlerTimeouts))
+		for key, s := range doc.HandlerTimeouts {
+			timeout, err := time.ParseDuration(s)
+			if err != nil || timeout < 0 {
+				return nil, fmt.Errorf("liveconfig: invalid handler timeout %q of %q", s, key)
+			}
+			cfg.HandlerTimeouts[key] = timeout
+		}
+	}
+	if doc.RateLimits != nil {
+		cfg.RateLimits = make(map[string]ratelimit.Limit, len(doc.RateLimits))
+		for key, limit := range doc.RateLimits {
+			cfg.RateLimits[key] = ratelimit.Limit{Rate: limit.Rate, Burst: limit.Burst}
+		}
+	}
+	if len(doc.ServiceConfig) != 0 {
+		serviceConfig, err := nrpc.ParseServiceConfig(doc.ServiceConfig)
+		if err != nil {
+			return nil, fmt.Errorf("liveconfig: %w", err)
+		}
+		cfg.ServiceConfig = serviceConfig
+	}
+	return cfg, nil
+}
+
+// Option configures the watcher.
+type Option func(w *Watcher)
+
+// WithServer adds a server the handler timeouts are set on.
+func WithServer(server *nrpc.Server) Option {
+	return func(w *Watcher) {
+		w.servers = append(w.servers, server)
+	}
+}
+
+// WithClient adds a client the service config is set on.
+func WithClient(client *nrpc.Client) Option {
+	return func(w *Watcher) {
+		w.clients = append(w.clients, client)
+	}
+}
+
+// WithLimiter adds a limiter the rate limits are set on.
+func WithLimiter(limiter *ratelimit.Limiter) Option {
+	return func(w *Watcher) {
+		w.limiters = append(w.limiters, limiter)
+	}
+}
+
+// WithLogger adds a logger the log level is set on.
+func WithLogger(log *nrpc.LevelLogger) Option {
+	return func(w *Watcher) {
+		w.loggers = append(w.loggers, log)
+	}
+}
+
+// OnChange adds a function called with every version of the configuration once it was applied.
+func OnChange(fn func(cfg *Config)) Option {
+	return func(w *Watcher) {
+		w.onChange = append(w.onChange, fn)
+	}
+}
+
+// OnError sets a function called with the errors of invalid versions of the document received while
+// watching. Invalid versions are not applied.
+func OnError(fn func(err error)) Option {
+	return func(w *Watcher) {
+		w.onError = fn
+	}
+}
+
+// Watcher applies versions of the configuration to clients, servers, limiters and loggers.
+type Watcher struct {
+	servers  []*nrpc.Server
+	clients  []*nrpc.Client
+	limiters []*ratelimit.Limiter
+	loggers  []*nrpc.LevelLogger
+	onChange []func(cfg *Config)
+	onError  func(err error)
+
+	// m serializes the versions applied, so targets and callbacks see them in order.
+	m sync.Mutex
+}
+
+// New creates a watcher.
+func New(opts ...Option) *Watcher {
+	w := &Watcher{}
+	for _, o := range opts {
+		o(w)
+	}
+	return w
+}
+
+// Apply applies the configuration to the targets of the watcher and notifies the OnChange functions.
+func (w *Watcher) Apply(cfg *Config) {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	if cfg.LogLevel != nil {
+		for _, log := range w.loggers {
+			log.SetLevel(*cfg.LogLevel)
+		}
+	}
+	if cfg.HandlerTimeouts != nil {
+		for _, server := range w.servers {
+			server.SetHandlerTimeouts(cfg.HandlerTimeouts)
+		}
+	}
+	if cfg.RateLimits != nil {
+		for _, limiter := range w.limiters {
+			limiter.SetLimits(cfg.RateLimits)
+		}
+	}
+	if cfg.ServiceConfig != nil {
+		for _, client := range w.clients {
+			client.SetServiceConfig(cfg.ServiceConfig)
+		}
+	}
+	for _, fn := range w.onChange {
+		fn(cfg)
+	}
+}
+
+// Watch applies the document stored under the key of the key-value bucket and its updates until the
+// context is done. It returns once the current document was applied and fails if it is invalid. Deleting
+// the key leaves the configuration unchanged.
+func (w *Watcher) Watch(ctx context.Context, kv nats.KeyValue, key string) error {
+	watcher, err := kv.Watch(key, nats.IgnoreDeletes())
+	if err != nil {
+		return err
+	}
+
+	// the current document is followed by a nil entry, which is the only entry if the key does not exist
+	for entry := range watcher.Updates() {
+		if entry == nil {
+			break
+		}
+		if r := w.apply(entry); r != nil {
+			_ = watcher.Stop()
+			return r
+		}
+	}
+
+	go func() {
+		defer func() { _ = watcher.Stop() }()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case entry, ok := <-watcher.Updates():
+				if !ok {
+					return
+				}
+				if r := w.apply(entry); r != nil && w.onError != nil {
+					w.onError(r)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// apply applies the document of the entry.
+func (w *Watcher) apply(entry nats.KeyValueEntry) error {
+	cfg, err := Parse(entry.Value())
+	if err != nil {
+		return err
+	}
+	w.Apply(cfg)
+	return nil
+}
@@ -1,57 +1,219 @@
 package nrpc
 
-import "log"
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+)
 
-// Logger defines the interface for logging.
+// Logger defines the interface for structured, leveled logging. The fields are given as
+// alternating keys and values, e.g. log.Info("subscribed", "subject", subj, "queue", queue).
+// Adapters for log/slog and zap are available in the logging sub packages.
 type Logger interface {
-	Info(args ...interface{})
-	Infof(format string, args ...interface{})
-	Error(args ...interface{})
-	Errorf(format string, args ...interface{})
-	// Debug(args ...interface{})
-	// Debugf(format string, args ...interface{})
-	// Warn(args ...interface{})
-	// Warnf(format string, args ...interface{})
-	// Fatal(args ...interface{})
-	// Fatalf(format string, args ...interface{})
+	Debug(msg string, fields ...interface{})
+	Info(msg string, fields ...interface{})
+	Warn(msg string, fields ...interface{})
+	Error(msg string, fields ...interface{})
+}
+
+// Level defines the level of a log message. The values match the ones of log/slog.
+type Level int
+
+// Log levels from the most to the least verbose.
+const (
+	LevelDebug Level = -4
+	LevelInfo  Level = 0
+	LevelWarn  Level = 4
+	LevelError Level = 8
+)
+
+// String implements the fmt.Stringer interface.
+func (l Level) String() string {
+	switch {
+	case l <= LevelDebug:
+		return "DEBUG"
+	case l <= LevelInfo:
+		return "INFO"
+	case l <= LevelWarn:
+		return "WARN"
+	}
+	return "ERROR"
+}
+
+// MarshalText implements the encoding.TextMarshaler interface.
+func (l Level) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface. It accepts the names of the levels
+// regardless of their case, e.g. "debug".
+func (l *Level) UnmarshalText(text []byte) error {
+	switch strings.ToUpper(string(text)) {
+	case "DEBUG":
+		*l = LevelDebug
+	case "INFO":
+		*l = LevelInfo
+	case "WARN":
+		*l = LevelWarn
+	case "ERROR":
+		*l = LevelError
+	default:
+		return fmt.Errorf("nrpc: unknown log level %q", text)
+	}
+	return nil
+}
+
+// LevelEnabler is implemented by loggers that can report whether they log messages of a level. The debug
+// messages logged for every frame are skipped if the logger does not log LevelDebug, which saves the
+// allocation of their fields. Loggers not implementing it receive all messages.
+type LevelEnabler interface {
+	Enabled(level Level) bool
+}
+
+// debugEnabled reports whether the logger logs debug messages.
+func debugEnabled(log Logger) bool {
+	if enabler, ok := log.(LevelEnabler); ok {
+		return enabler.Enabled(LevelDebug)
+	}
+	return true
 }
 
 var _ Logger = (*noopLogger)(nil)
 var _ Logger = (*StandardLogger)(nil)
+var _ LevelEnabler = (*noopLogger)(nil)
+var _ LevelEnabler = (*StandardLogger)(nil)
 
 type noopLogger struct{}
 
-// Info implements the Logger interface
-func (n noopLogger) Info(...interface{}) {}
+// Debug implements the Logger interface.
+func (n noopLogger) Debug(string, ...interface{}) {}
+
+// Info implements the Logger interface.
+func (n noopLogger) Info(string, ...interface{}) {}
+
+// Warn implements the Logger interface.
+func (n noopLogger) Warn(string, ...interface{}) {}
+
+// Error implements the Logger interface.
+func (n noopLogger) Error(string, ...interface{}) {}
+
+// Enabled implements the LevelEnabler interface.
+func (n noopLogger) Enabled(Level) bool {
+	return false
+}
+
+// LevelLogger wraps a logger and drops the messages below its level, which can be changed at runtime,
+// e.g. to enable debug logging of a running server temporarily.
+type LevelLogger struct {
+	log   Logger
+	level int64
+}
+
+var _ Logger = (*LevelLogger)(nil)
+var _ LevelEnabler = (*LevelLogger)(nil)
+
+// NewLevelLogger creates a logger passing the messages of the level and above to the logger.
+func NewLevelLogger(log Logger, level Level) *LevelLogger {
+	return &LevelLogger{log: log, level: int64(level)}
+}
+
+// Level returns the level of the logger.
+func (l *LevelLogger) Level() Level {
+	return Level(atomic.LoadInt64(&l.level))
+}
+
+// SetLevel changes the level of the logger.
+func (l *LevelLogger) SetLevel(level Level) {
+	atomic.StoreInt64(&l.level, int64(level))
+}
+
+// Debug implements the Logger interface.
+func (l *LevelLogger) Debug(msg string, fields ...interface{}) {
+	if l.Enabled(LevelDebug) {
+		l.log.Debug(msg, fields...)
+	}
+}
+
+// Info implements the Logger interface.
+func (l *LevelLogger) Info(msg string, fields ...interface{}) {
+	if l.Enabled(LevelInfo) {
+		l.log.Info(msg, fields...)
+	}
+}
 
-// Infof implements the Logger interface.
-func (n noopLogger) Infof(string, ...interface{}) {}
+// Warn implements the Logger interface.
+func (l *LevelLogger) Warn(msg string, fields ...interface{}) {
+	if l.Enabled(LevelWarn) {
+		l.log.Warn(msg, fields...)
+	}
+}
 
 // Error implements the Logger interface.
-func (n noopLogger) Error(...interface{}) {}
+func (l *LevelLogger) Error(msg string, fields ...interface{}) {
+	if l.Enabled(LevelError) {
+		l.log.Error(msg, fields...)
+	}
+}
 
-// Errorf implements the Logger interface.
-func (n noopLogger) Errorf(string, ...interface{}) {}
+// Enabled implements the LevelEnabler interface. Messages of the level are logged if they are at the
+// level of the logger or above and the wrapped logger logs them.
+func (l *LevelLogger) Enabled(level Level) bool {
+	if level < l.Level() {
+		return false
+	}
+	if enabler, ok := l.log.(LevelEnabler); ok {
+		return enabler.Enabled(level)
+	}
+	return true
+}
 
 // StandardLogger implements the Logger interface using the standard library logger.
-type StandardLogger struct{}
+// Messages below Level are discarded. The zero value logs from LevelInfo on.
+type StandardLogger struct {
+	Level Level
+}
 
-// Info implements the Logger interface
-func (d StandardLogger) Info(args ...interface{}) {
-	log.Println(args...)
+// Debug implements the Logger interface.
+func (d StandardLogger) Debug(msg string, fields ...interface{}) {
+	d.log(LevelDebug, msg, fields)
 }
 
-// Infof implements the Logger interface.
-func (d StandardLogger) Infof(format string, args ...interface{}) {
-	log.Printf(format, args...)
+// Info implements the Logger interface.
+func (d StandardLogger) Info(msg string, fields ...interface{}) {
+	d.log(LevelInfo, msg, fields)
+}
+
+// Warn implements the Logger interface.
+func (d StandardLogger) Warn(msg string, fields ...interface{}) {
+	d.log(LevelWarn, msg, fields)
 }
 
 // Error implements the Logger interface.
-func (d StandardLogger) Error(args ...interface{}) {
-	d.Info(args...)
+func (d StandardLogger) Error(msg string, fields ...interface{}) {
+	d.log(LevelError, msg, fields)
 }
 
-// Errorf implements the Logger interface.
-func (d StandardLogger) Errorf(format string, args ...interface{}) {
-	d.Infof(format, args...)
+// Enabled implements the LevelEnabler interface.
+func (d StandardLogger) Enabled(level Level) bool {
+	return level >= d.Level
+}
+
+func (d StandardLogger) log(level Level, msg string, fields []interface{}) {
+	if !d.Enabled(level) {
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString(level.String())
+	b.WriteString(" ")
+	b.WriteString(msg)
+	for i := 0; i < len(fields); i += 2 {
+		if i+1 == len(fields) {
+			fmt.Fprintf(&b, " !BADKEY=%v", fields[i])
+			break
+		}
+		fmt.Fprintf(&b, " %v=%v", fields[i], fields[i+1])
+	}
+	log.Println(b.String())
 }
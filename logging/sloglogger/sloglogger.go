@@ -0,0 +1,49 @@
+//go:build go1.21
+
+// Package sloglogger adapts a log/slog logger to the nrpc.Logger interface.
+package sloglogger
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/tehsphinx/nrpc"
+)
+
+var _ nrpc.Logger = (*Logger)(nil)
+var _ nrpc.LevelEnabler = (*Logger)(nil)
+
+// Logger implements the nrpc.Logger interface using a log/slog logger.
+type Logger struct {
+	log *slog.Logger
+}
+
+// New creates a new Logger logging to the given slog logger.
+func New(log *slog.Logger) *Logger {
+	return &Logger{log: log}
+}
+
+// Debug implements the nrpc.Logger interface.
+func (l *Logger) Debug(msg string, fields ...interface{}) {
+	l.log.Debug(msg, fields...)
+}
+
+// Info implements the nrpc.Logger interface.
+func (l *Logger) Info(msg string, fields ...interface{}) {
+	l.log.Info(msg, fields...)
+}
+
+// Warn implements the nrpc.Logger interface.
+func (l *Logger) Warn(msg string, fields ...interface{}) {
+	l.log.Warn(msg, fields...)
+}
+
+// Error implements the nrpc.Logger interface.
+func (l *Logger) Error(msg string, fields ...interface{}) {
+	l.log.Error(msg, fields...)
+}
+
+// Enabled implements the nrpc.LevelEnabler interface.
+func (l *Logger) Enabled(level nrpc.Level) bool {
+	return l.log.Enabled(context.Background(), slog.Level(level))
+}
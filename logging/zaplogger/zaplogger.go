@@ -0,0 +1,59 @@
+// Package zaplogger adapts a zap logger to the nrpc.Logger interface.
+package zaplogger
+
+import (
+	"github.com/tehsphinx/nrpc"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+var _ nrpc.Logger = (*Logger)(nil)
+var _ nrpc.LevelEnabler = (*Logger)(nil)
+
+// Logger implements the nrpc.Logger interface using a zap logger.
+type Logger struct {
+	log  *zap.SugaredLogger
+	core zapcore.Core
+}
+
+// New creates a new Logger logging to the given zap logger.
+func New(log *zap.Logger) *Logger {
+	return &Logger{log: log.Sugar(), core: log.Core()}
+}
+
+// Debug implements the nrpc.Logger interface.
+func (l *Logger) Debug(msg string, fields ...interface{}) {
+	l.log.Debugw(msg, fields...)
+}
+
+// Info implements the nrpc.Logger interface.
+func (l *Logger) Info(msg string, fields ...interface{}) {
+	l.log.Infow(msg, fields...)
+}
+
+// Warn implements the nrpc.Logger interface.
+func (l *Logger) Warn(msg string, fields ...interface{}) {
+	l.log.Warnw(msg, fields...)
+}
+
+// Error implements the nrpc.Logger interface.
+func (l *Logger) Error(msg string, fields ...interface{}) {
+	l.log.Errorw(msg, fields...)
+}
+
+// Enabled implements the nrpc.LevelEnabler interface.
+func (l *Logger) Enabled(level nrpc.Level) bool {
+	return l.core.Enabled(zapLevel(level))
+}
+
+func zapLevel(level nrpc.Level) zapcore.Level {
+	switch {
+	case level <= nrpc.LevelDebug:
+		return zapcore.DebugLevel
+	case level <= nrpc.LevelInfo:
+		return zapcore.InfoLevel
+	case level <= nrpc.LevelWarn:
+		return zapcore.WarnLevel
+	}
+	return zapcore.ErrorLevel
+}
@@ -2,11 +2,17 @@ package nrpc
 
 import (
 	"context"
+	"encoding/base64"
 	"fmt"
+	"strings"
 
 	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	encproto "google.golang.org/grpc/encoding/proto"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -14,9 +20,13 @@ type recvMsg struct {
 	ctx  context.Context
 	data []byte
 	req  *Request
+	err  error
 }
 
 func (m *recvMsg) request() (*Request, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
 	if m.req != nil {
 		return m.req, nil
 	}
@@ -29,9 +39,22 @@ func (m *recvMsg) request() (*Request, error) {
 	return m.req, nil
 }
 
+// final reports whether the stream cannot continue after the message: it ends the stream or failed to be read.
+func (m *recvMsg) final() bool {
+	req, err := m.request()
+	return err != nil || req.Eos
+}
+
 type respMsg struct {
 	ctx  context.Context
 	data []byte
+	resp *Response
+	err  error
+}
+
+// final reports whether the stream cannot continue after the message: it ends the stream or failed to be read.
+func (m *respMsg) final() bool {
+	return m.err != nil || m.resp.Eos
 }
 
 func marshalProto(subj string, args proto.Message, msgType MessageType) ([]byte, error) {
@@ -52,52 +75,124 @@ func marshalProto(subj string, args proto.Message, msgType MessageType) ([]byte,
 	return payload, nil
 }
 
-func marshalReqMsg(ctx context.Context, args proto.Message, reqSubj, respSubj string, timeout int64) ([]byte, error) {
-	innerPayload, err := proto.Marshal(args)
+// marshalReqMsg marshals args with the codec and the metadata into req.
+// The data is compressed with req.Compressor if set and must not exceed maxSize.
+// It returns the marshaled args as well as the marshaled request.
+func marshalReqMsg(header metadata.MD, codec Codec, args interface{}, req *Request, maxSize int) ([]byte, []byte, error) {
+	req.Header = fromMD(header)
+	req.Codec = codec.Name()
+	innerPayload, data, payload, err := marshalEnvelope(codec, req.Compressor, args, maxSize, req)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+	// the data is kept on the request, e.g. to send it in chunks
+	req.Data = data
+	return innerPayload, payload, nil
+}
 
+// outgoingMD returns the outgoing metadata of the context sent with requests.
+func outgoingMD(ctx context.Context) metadata.MD {
 	md, _ := metadata.FromOutgoingContext(ctx)
-	return proto.Marshal(&Request{
-		Header:      fromMD(md),
-		Data:        innerPayload,
-		ReqSubject:  reqSubj,
-		RespSubject: respSubj,
-		Timeout:     timeout,
-	})
+	return md
 }
 
-func marshalRespMsg(resp proto.Message, header metadata.MD, trailer metadata.MD, eos bool, headerOnly bool) ([]byte, []byte, error) {
-	innerPayload, err := proto.Marshal(resp)
+// marshalUnaryRespMsg marshals args with the codec into resp and wraps it into a Message.
+// The data is compressed with resp.Compressor if set and must not exceed maxSize.
+// It returns the marshaled args as well as the marshaled message.
+func marshalUnaryRespMsg(subj string, codec Codec, args interface{}, resp *Response, maxSize int) ([]byte, []byte, error) {
+	resp.Codec = codec.Name()
+	innerPayload, data, payload, err := marshalEnvelope(codec, resp.Compressor, args, maxSize,
+		&Message{Subject: subj, Type: MessageType_Data}, resp)
 	if err != nil {
 		return nil, nil, err
 	}
+	resp.Data = data
+	return innerPayload, payload, nil
+}
 
-	payload, err := proto.Marshal(&Response{
-		Header:     fromMD(header),
-		Trailer:    fromMD(trailer),
-		HeaderOnly: headerOnly,
-		Data:       innerPayload,
-		Eos:        eos,
-	})
-	return innerPayload, payload, err
+// dataField is the number of the data field of Request, Response and Message.
+const dataField protowire.Number = 2
+
+// marshalEnvelope encodes args like encode and marshals the data into the innermost of the nested envelopes,
+// e.g. a Response wrapped into a Message. The data field of the envelopes must not be set. Uncompressed proto
+// messages are marshaled directly into the payload instead of being marshaled on their own and copied.
+// It returns the marshaled args, the data of the innermost envelope and the payload.
+func marshalEnvelope(codec Codec, compressor string, args interface{}, maxSize int,
+	envelopes ...proto.Message) ([]byte, []byte, []byte, error) {
+	msg, direct := args.(proto.Message)
+	direct = direct && codec.Name() == encproto.Name && (compressor == "" || compressor == encoding.Identity)
+
+	var innerPayload, data []byte
+	size := 0
+	if direct {
+		size = proto.Size(msg)
+		if size > maxSize {
+			return nil, nil, nil, status.Errorf(codes.ResourceExhausted, "grpc: trying to send message larger than max (%d vs. %d)", size, maxSize)
+		}
+	} else {
+		buf := getBuffer()
+		defer putBuffer(buf)
+
+		var err error
+		innerPayload, data, err = encode(codec, compressor, args, maxSize, buf)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		size = len(data)
+	}
+
+	// the sizes of the envelopes including the data field holding the next one
+	var sizesBuf [2]int
+	sizes := append(sizesBuf[:0], make([]int, len(envelopes))...)
+	next := size
+	for i := len(envelopes) - 1; i >= 0; i-- {
+		sizes[i] = proto.Size(envelopes[i]) + protowire.SizeTag(dataField) + protowire.SizeBytes(next)
+		next = sizes[i]
+	}
+
+	opts := proto.MarshalOptions{UseCachedSize: true}
+	payload := make([]byte, 0, next)
+	for i, envelope := range envelopes {
+		var err error
+		if payload, err = opts.MarshalAppend(payload, envelope); err != nil {
+			return nil, nil, nil, err
+		}
+		inner := size
+		if i+1 < len(envelopes) {
+			inner = sizes[i+1]
+		}
+		payload = protowire.AppendTag(payload, dataField, protowire.BytesType)
+		payload = protowire.AppendVarint(payload, uint64(inner))
+	}
+
+	start := len(payload)
+	if !direct {
+		// the data is copied out of the pooled buffer
+		payload = append(payload, data...)
+		return innerPayload, payload[start:len(payload):len(payload)], payload, nil
+	}
+	payload, err := opts.MarshalAppend(payload, msg)
+	if err != nil {
+		return nil, nil, nil, status.Errorf(codes.Internal, "grpc: error while marshaling: %v", err)
+	}
+	data = payload[start:len(payload):len(payload)]
+	return data, data, payload, nil
 }
 
-func marshalUnaryRespMsg(subj string, resp proto.Message, header metadata.MD, trailer metadata.MD, eos bool, headerOnly bool) ([]byte, []byte, error) {
-	innerPayload, err := proto.Marshal(resp)
+// marshalErrMsg marshals the status of a failed unary call along with its header and trailer into a Message.
+func marshalErrMsg(subj string, st *spb.Status, header, trailer metadata.MD) ([]byte, error) {
+	innerPayload, err := proto.Marshal(st)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
-	payload, err := marshalProto(subj, &Response{
-		Header:     fromMD(header),
-		Trailer:    fromMD(trailer),
-		HeaderOnly: headerOnly,
-		Data:       innerPayload,
-		Eos:        eos,
-	}, MessageType_Data)
-	return innerPayload, payload, err
+	return proto.Marshal(&Message{
+		Subject: subj,
+		Data:    innerPayload,
+		Type:    MessageType_Error,
+		Header:  fromMD(header),
+		Trailer: fromMD(trailer),
+	})
 }
 
 func marshalEOS() ([]byte, error) {
@@ -106,6 +201,98 @@ func marshalEOS() ([]byte, error) {
 	})
 }
 
+func marshalCancel(id string) ([]byte, error) {
+	return proto.Marshal(&Request{
+		Id:     id,
+		Cancel: true,
+	})
+}
+
+func marshalReqPing(lastSeq uint64) ([]byte, error) {
+	return proto.Marshal(&Request{
+		Ping:    true,
+		LastSeq: lastSeq,
+	})
+}
+
+func marshalRespPing(lastSeq uint64) ([]byte, error) {
+	return proto.Marshal(&Response{
+		Ping:    true,
+		LastSeq: lastSeq,
+	})
+}
+
+func marshalReqResume(ack uint64) ([]byte, error) {
+	return proto.Marshal(&Request{
+		Resume: true,
+		Ack:    ack,
+	})
+}
+
+func marshalRespResume(ack uint64) ([]byte, error) {
+	return proto.Marshal(&Response{
+		Resume: true,
+		Ack:    ack,
+	})
+}
+
+func marshalReqCredit(credit int) ([]byte, error) {
+	return proto.Marshal(&Request{
+		Credit: uint32(credit),
+	})
+}
+
+func marshalRespCredit(credit int) ([]byte, error) {
+	return proto.Marshal(&Response{
+		Credit: uint32(credit),
+	})
+}
+
+func marshalReqBatch(frames [][]byte) ([]byte, error) {
+	return proto.Marshal(&Request{
+		Batch: frames,
+	})
+}
+
+func marshalRespBatch(frames [][]byte) ([]byte, error) {
+	return proto.Marshal(&Response{
+		Batch: frames,
+	})
+}
+
+// marshalHandshake marshals the reply to the first message of a stream.
+// An empty reply is sent if there is nothing to negotiate.
+func marshalHandshake(subj string, window int, version uint32) ([]byte, error) {
+	if window == 0 && version == 0 {
+		return nil, nil
+	}
+	return marshalProto(subj, &Response{
+		Window:  uint32(window),
+		Version: version,
+	}, MessageType_Data)
+}
+
+func unmarshalHandshake(data []byte) (*Response, error) {
+	if len(data) == 0 {
+		return &Response{}, nil
+	}
+
+	var msg Message
+	if r := proto.Unmarshal(data, &msg); r != nil {
+		return nil, r
+	}
+	if msg.GetType() == MessageType_Error {
+		// the response carries the metadata of the failed call
+		return &Response{Header: msg.Header, Trailer: msg.Trailer}, unmarshalErr(msg.GetData())
+	}
+
+	var resp Response
+	if r := proto.Unmarshal(msg.GetData(), &resp); r != nil {
+		return nil, r
+	}
+	return &resp, nil
+}
+
 func unmarshalReq(data []byte) (*Request, error) {
 	var req Request
 	if r := proto.Unmarshal(data, &req); r != nil {
@@ -115,32 +302,32 @@ func unmarshalReq(data []byte) (*Request, error) {
 	return &req, nil
 }
 
-func unmarshalRespMsg(data []byte, target interface{}) (*Response, error) {
+func unmarshalResp(data []byte) (*Response, error) {
 	var resp Response
 	if r := proto.Unmarshal(data, &resp); r != nil {
 		return nil, r
 	}
 
-	// nolint: forcetypeassert
-	return &resp, proto.Unmarshal(resp.GetData(), target.(proto.Message))
+	return &resp, nil
 }
 
-func unmarshalUnaryRespMsg(data []byte, target interface{}) (*Response, error) {
+// unmarshalUnaryResp unmarshals the response of a unary call without decoding its data.
+// The error status is returned if the call failed.
+func unmarshalUnaryResp(data []byte) (*Response, error) {
 	var msg Message
 	if r := proto.Unmarshal(data, &msg); r != nil {
 		return nil, r
 	}
 	if msg.GetType() == MessageType_Error {
-		return nil, unmarshalErr(msg.GetData())
+		// the response carries the metadata of the failed call
+		return &Response{Header: msg.Header, Trailer: msg.Trailer}, unmarshalErr(msg.GetData())
 	}
 
 	var resp Response
 	if r := proto.Unmarshal(msg.GetData(), &resp); r != nil {
 		return nil, r
 	}
-
-	// nolint: forcetypeassert
-	return &resp, proto.Unmarshal(resp.GetData(), target.(proto.Message))
+	return &resp, nil
 }
 
 func unmarshalErr(data []byte) error {
@@ -151,20 +338,136 @@ func unmarshalErr(data []byte) error {
 	return status.ErrorProto(&stats)
 }
 
+// binHeaderSuffix marks metadata keys with binary values. Like gRPC does on the wire, their values are
+// transmitted base64 encoded, as the header values of the messages must be valid UTF-8.
+const binHeaderSuffix = "-bin"
+
 func toMD(header map[string]*Header) metadata.MD {
 	h := metadata.MD{}
 	for k, v := range header {
-		h[k] = v.Values
+		if !strings.HasSuffix(k, binHeaderSuffix) {
+			h[k] = v.Values
+			continue
+		}
+		values := make([]string, 0, len(v.Values))
+		for _, val := range v.Values {
+			values = append(values, decodeBinHeader(val))
+		}
+		h[k] = values
 	}
 	return h
 }
 
+// fromMD converts the metadata to the headers of a message. Keys are lowercased like grpc does.
 func fromMD(header metadata.MD) map[string]*Header {
-	h := map[string]*Header{}
+	if len(header) == 0 {
+		return nil
+	}
+	h := make(map[string]*Header, len(header))
 	for k, v := range header {
+		k = strings.ToLower(k)
+		values := v
+		if strings.HasSuffix(k, binHeaderSuffix) {
+			values = make([]string, 0, len(v))
+			for _, val := range v {
+				values = append(values, base64.RawStdEncoding.EncodeToString([]byte(val)))
+			}
+		}
+		if existing, ok := h[k]; ok {
+			existing.Values = append(existing.Values, values...)
+			continue
+		}
 		h[k] = &Header{
-			Values: v,
+			Values: values,
 		}
 	}
 	return h
 }
+
+// decodeBinHeader decodes a binary header value. Like grpc, it accepts padded and unpadded base64.
+// Values that are not base64 encoded are kept as they are.
+func decodeBinHeader(v string) string {
+	if len(v)%4 == 0 {
+		if b, err := base64.StdEncoding.DecodeString(v); err == nil {
+			return string(b)
+		}
+	}
+	if b, err := base64.RawStdEncoding.DecodeString(v); err == nil {
+		return string(b)
+	}
+	return v
+}
+
+// FrameType is the type of a frame sent between client and server (see WireTap).
+// It is logged on debug level as well.
+type FrameType string
+
+// The types of the frames.
+const (
+	// FrameData carries a request or response message.
+	FrameData FrameType = "data"
+	// FrameHeader carries the header of a stream sent before its first message.
+	FrameHeader FrameType = "header"
+	// FrameEOS ends a stream. It carries the trailer and the status of failed streams.
+	FrameEOS FrameType = "eos"
+	// FrameError carries the status of a failed unary call.
+	FrameError FrameType = "error"
+	// FrameHandshake is the reply of a server accepting a stream.
+	FrameHandshake FrameType = "handshake"
+	// FrameCredit grants flow control credit to the other side of a stream.
+	FrameCredit FrameType = "credit"
+	// FrameChunk carries a part of a frame that was split up.
+	FrameChunk FrameType = "chunk"
+	// FrameCancel cancels a call or stream.
+	FrameCancel FrameType = "cancel"
+	// FramePing proves the liveness of the other side of a stream.
+	FramePing FrameType = "ping"
+	// FrameResume requests the frames following the last frame received in order again.
+	FrameResume FrameType = "resume"
+	// FrameReplay marks frames sent again on request of the other side.
+	FrameReplay FrameType = "replay"
+	// FrameBatch carries several data frames of a stream sent together.
+	FrameBatch FrameType = "batch"
+)
+
+// reqFrame returns the frame type of a request.
+func reqFrame(req *Request) FrameType {
+	switch {
+	case req.Cancel:
+		return FrameCancel
+	case req.Ping:
+		return FramePing
+	case req.Resume:
+		return FrameResume
+	case req.Credit != 0:
+		return FrameCredit
+	case req.Chunk != nil:
+		return FrameChunk
+	case len(req.Batch) != 0:
+		return FrameBatch
+	case req.Eos:
+		return FrameEOS
+	}
+	return FrameData
+}
+
+// respFrame returns the frame type of a response.
+func respFrame(resp *Response) FrameType {
+	switch {
+	case resp.Ping:
+		return FramePing
+	case resp.Resume:
+		return FrameResume
+	case resp.Credit != 0:
+		return FrameCredit
+	case resp.Chunk != nil:
+		return FrameChunk
+	case len(resp.Batch) != 0:
+		return FrameBatch
+	case resp.Eos:
+		return FrameEOS
+	case resp.HeaderOnly:
+		return FrameHeader
+	}
+	return FrameData
+}
@@ -0,0 +1,155 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/tehsphinx/nrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryClientInterceptor returns a client interceptor collecting the metrics of unary calls.
+func (m *Metrics) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		start := time.Now()
+		m.started.WithLabelValues(method, typeUnary).Inc()
+		m.observeSize(method, directionSent, req)
+
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		if err == nil {
+			m.observeSize(method, directionReceived, reply)
+		}
+
+		code := status.Code(err).String()
+		m.handled.WithLabelValues(method, typeUnary, code).Inc()
+		m.latency.WithLabelValues(method, code).Observe(time.Since(start).Seconds())
+		return err
+	}
+}
+
+// StreamClientInterceptor returns a client interceptor collecting the metrics of streams.
+// A stream is counted as completed once RecvMsg returns an error.
+func (m *Metrics) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string,
+		streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		typ := streamType(desc.ClientStreams, desc.ServerStreams)
+		m.started.WithLabelValues(method, typ).Inc()
+
+		s := &monitoredStream{m: m, method: method, typ: typ, start: time.Now()}
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			s.finish(err)
+			return nil, err
+		}
+		return &clientStream{ClientStream: stream, monitoredStream: s}, nil
+	}
+}
+
+// UnaryServerInterceptor returns a server interceptor collecting the metrics of unary calls.
+func (m *Metrics) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		m.started.WithLabelValues(info.FullMethod, typeUnary).Inc()
+		m.observeSize(info.FullMethod, directionReceived, req)
+
+		resp, err := handler(ctx, req)
+		if err == nil {
+			m.observeSize(info.FullMethod, directionSent, resp)
+		}
+
+		code := status.Code(err).String()
+		m.handled.WithLabelValues(info.FullMethod, typeUnary, code).Inc()
+		m.latency.WithLabelValues(info.FullMethod, code).Observe(time.Since(start).Seconds())
+		m.observeCaller(info.FullMethod, caller(ctx), code)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor returns a server interceptor collecting the metrics of streams.
+func (m *Metrics) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		typ := streamType(info.IsClientStream, info.IsServerStream)
+		m.started.WithLabelValues(info.FullMethod, typ).Inc()
+
+		s := &monitoredStream{m: m, method: info.FullMethod, typ: typ, start: time.Now(), caller: caller(ss.Context()), server: true}
+		err := handler(srv, &serverStream{ServerStream: ss, monitoredStream: s})
+		s.finish(err)
+		return err
+	}
+}
+
+// monitoredStream records the metrics of a single stream.
+type monitoredStream struct {
+	m      *Metrics
+	method string
+	typ    string
+	start  time.Time
+	once   sync.Once
+	// caller is the identity announced by the caller of a stream handled by a server.
+	caller nrpc.ClientIdentity
+	server bool
+}
+
+// finish records the completion of the stream. Only the first call has an effect.
+func (s *monitoredStream) finish(err error) {
+	s.once.Do(func() {
+		if errors.Is(err, io.EOF) {
+			err = nil
+		}
+		code := status.Code(err).String()
+		s.m.handled.WithLabelValues(s.method, s.typ, code).Inc()
+		s.m.streamDur.WithLabelValues(s.method, code).Observe(time.Since(s.start).Seconds())
+		if s.server {
+			s.m.observeCaller(s.method, s.caller, code)
+		}
+	})
+}
+
+type clientStream struct {
+	grpc.ClientStream
+	*monitoredStream
+}
+
+func (s *clientStream) SendMsg(msg interface{}) error {
+	err := s.ClientStream.SendMsg(msg)
+	if err == nil {
+		s.m.observeSize(s.method, directionSent, msg)
+	}
+	return err
+}
+
+func (s *clientStream) RecvMsg(msg interface{}) error {
+	err := s.ClientStream.RecvMsg(msg)
+	if err != nil {
+		s.finish(err)
+		return err
+	}
+	s.m.observeSize(s.method, directionReceived, msg)
+	return nil
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	*monitoredStream
+}
+
+func (s *serverStream) SendMsg(msg interface{}) error {
+	err := s.ServerStream.SendMsg(msg)
+	if err == nil {
+		s.m.observeSize(s.method, directionSent, msg)
+	}
+	return err
+}
+
+func (s *serverStream) RecvMsg(msg interface{}) error {
+	err := s.ServerStream.RecvMsg(msg)
+	if err == nil {
+		s.m.observeSize(s.method, directionReceived, msg)
+	}
+	return err
+}
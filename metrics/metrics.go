@@ -0,0 +1,214 @@
+// Package metrics exposes prometheus metrics of nrpc clients and servers. The metrics are
+// collected by interceptors and a stream observer that are plugged into the client or server:
+//
+//	m := metrics.NewServerMetrics()
+//	prometheus.MustRegister(m)
+//	server := nrpc.NewServer(pub, sub, m.Options()...)
+package metrics
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tehsphinx/nrpc"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	sideClient = "client"
+	sideServer = "server"
+
+	typeUnary        = "unary"
+	typeClientStream = "client_stream"
+	typeServerStream = "server_stream"
+	typeBidiStream   = "bidi_stream"
+
+	directionSent     = "sent"
+	directionReceived = "received"
+)
+
+var _ prometheus.Collector = (*Metrics)(nil)
+
+var (
+	_ nrpc.StreamObserver    = (*Metrics)(nil)
+	_ nrpc.DuplicateObserver = (*Metrics)(nil)
+)
+
+// Metrics collects the metrics of an nrpc client or server. It implements prometheus.Collector
+// and must be registered with a prometheus registry to be exported.
+type Metrics struct {
+	side string
+
+	started    *prometheus.CounterVec
+	handled    *prometheus.CounterVec
+	latency    *prometheus.HistogramVec
+	msgSize    *prometheus.HistogramVec
+	streamDur  *prometheus.HistogramVec
+	queueDepth *prometheus.HistogramVec
+	stuck      *prometheus.CounterVec
+	duplicates *prometheus.CounterVec
+	byCaller   *prometheus.CounterVec
+}
+
+// NewClientMetrics creates the metrics of an nrpc client. The metric names are prefixed with nrpc_client.
+func NewClientMetrics() *Metrics {
+	return newMetrics(sideClient)
+}
+
+// NewServerMetrics creates the metrics of an nrpc server. The metric names are prefixed with nrpc_server.
+func NewServerMetrics() *Metrics {
+	return newMetrics(sideServer)
+}
+
+func newMetrics(side string) *Metrics {
+	return &Metrics{
+		side: side,
+		started: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "nrpc",
+			Subsystem: side,
+			Name:      "started_total",
+			Help:      "Total number of RPCs started.",
+		}, []string{"method", "type"}),
+		handled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "nrpc",
+			Subsystem: side,
+			Name:      "handled_total",
+			Help:      "Total number of RPCs completed, regardless of success or failure.",
+		}, []string{"method", "type", "code"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "nrpc",
+			Subsystem: side,
+			Name:      "handling_seconds",
+			Help:      "Latency of unary RPCs in seconds.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "code"}),
+		msgSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "nrpc",
+			Subsystem: side,
+			Name:      "msg_size_bytes",
+			Help:      "Size of sent and received messages in bytes.",
+			Buckets:   prometheus.ExponentialBuckets(64, 4, 8),
+		}, []string{"method", "direction"}),
+		streamDur: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "nrpc",
+			Subsystem: side,
+			Name:      "stream_duration_seconds",
+			Help:      "Duration streams were open in seconds.",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 4, 10),
+		}, []string{"method", "code"}),
+		queueDepth: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "nrpc",
+			Subsystem: side,
+			Name:      "recv_queue_depth",
+			Help:      "Number of buffered messages of a stream observed on each received message.",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 8),
+		}, []string{"method"}),
+		stuck: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "nrpc",
+			Subsystem: side,
+			Name:      "consumer_stuck_total",
+			Help:      "Total number of streams closed because received messages were not consumed in time.",
+		}, []string{"method"}),
+		duplicates: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "nrpc",
+			Subsystem: side,
+			Name:      "duplicate_frames_total",
+			Help:      "Total number of stream frames dropped because they were received before.",
+		}, []string{"method"}),
+		byCaller: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "nrpc",
+			Subsystem: side,
+			Name:      "handled_by_caller_total",
+			Help:      "Total number of RPCs completed by the service announced by the caller.",
+		}, []string{"method", "caller", "code"}),
+	}
+}
+
+func (m *Metrics) collectors() []prometheus.Collector {
+	collectors := []prometheus.Collector{m.started, m.handled, m.latency, m.msgSize, m.streamDur, m.queueDepth, m.stuck, m.duplicates}
+	if m.side == sideServer {
+		// only servers know the identity of their callers
+		collectors = append(collectors, m.byCaller)
+	}
+	return collectors
+}
+
+// Describe implements prometheus.Collector.
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range m.collectors() {
+		c.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range m.collectors() {
+		c.Collect(ch)
+	}
+}
+
+// Options returns the options plugging the interceptors and the stream observer into the client
+// or server, depending on whether the metrics were created for a client or a server.
+func (m *Metrics) Options() []nrpc.Option {
+	if m.side == sideClient {
+		return []nrpc.Option{
+			nrpc.WithChainUnaryInterceptor(m.UnaryClientInterceptor()),
+			nrpc.WithChainStreamInterceptor(m.StreamClientInterceptor()),
+			nrpc.WithStreamObserver(m),
+		}
+	}
+	return []nrpc.Option{
+		nrpc.ChainUnaryInterceptor(m.UnaryServerInterceptor()),
+		nrpc.ChainStreamInterceptor(m.StreamServerInterceptor()),
+		nrpc.WithStreamObserver(m),
+	}
+}
+
+// ObserveQueueDepth implements nrpc.StreamObserver.
+func (m *Metrics) ObserveQueueDepth(method string, depth int) {
+	m.queueDepth.WithLabelValues(method).Observe(float64(depth))
+}
+
+// ConsumerStuck implements nrpc.StreamObserver.
+func (m *Metrics) ConsumerStuck(method string) {
+	m.stuck.WithLabelValues(method).Inc()
+}
+
+// DuplicateReceived implements nrpc.DuplicateObserver.
+func (m *Metrics) DuplicateReceived(method string) {
+	m.duplicates.WithLabelValues(method).Inc()
+}
+
+func (m *Metrics) observeSize(method, direction string, msg interface{}) {
+	protoMsg, ok := msg.(proto.Message)
+	if !ok {
+		return
+	}
+	m.msgSize.WithLabelValues(method, direction).Observe(float64(proto.Size(protoMsg)))
+}
+
+// observeCaller counts a completed call by the service announced by the caller (see nrpc.WithClientIdentity).
+// The version and instance are left out to keep the number of series bounded.
+func (m *Metrics) observeCaller(method string, caller nrpc.ClientIdentity, code string) {
+	m.byCaller.WithLabelValues(method, caller.Service, code).Inc()
+}
+
+// caller returns the identity announced by the caller of a call handled with the context.
+func caller(ctx context.Context) nrpc.ClientIdentity {
+	if p, ok := nrpc.PeerFromContext(ctx); ok {
+		return p.Identity
+	}
+	return nrpc.ClientIdentity{}
+}
+
+func streamType(clientStreams, serverStreams bool) string {
+	switch {
+	case clientStreams && serverStreams:
+		return typeBidiStream
+	case clientStreams:
+		return typeClientStream
+	case serverStreams:
+		return typeServerStream
+	}
+	return typeUnary
+}
@@ -0,0 +1,156 @@
+package nrpc
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/tehsphinx/nrpc/pubsub"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	// muxVersion is the version of the envelope introducing streams sharing their response subject.
+	muxVersion uint32 = 3
+
+	// respStreamIDField is the field number of stream_id in Response (see message.proto).
+	respStreamIDField protowire.Number = 18
+)
+
+// muxer multiplexes the streams of a client on a bounded set of response subjects (see WithStreamMultiplexing).
+// The subjects are subscribed once they are used by the first stream and kept for the lifetime of the client.
+// The frames received on them are passed to the stream with the ID carried by the frame.
+type muxer struct {
+	sub   pubsub.Subscriber
+	log   Logger
+	id    string
+	size  int
+	queue string
+	next  uint32
+
+	m       sync.RWMutex
+	subs    map[string]pubsub.Subscription
+	streams map[string]pubsub.Handler
+}
+
+// newMuxer creates a muxer sharing the given number of response subjects. It returns nil if the
+// number is 0, which disables multiplexing.
+func newMuxer(sub pubsub.Subscriber, log Logger, size int, queue string) *muxer {
+	if size <= 0 {
+		return nil
+	}
+	return &muxer{
+		sub:     sub,
+		log:     log,
+		id:      randString(randSubjectLen),
+		size:    size,
+		queue:   queue,
+		subs:    map[string]pubsub.Subscription{},
+		streams: map[string]pubsub.Handler{},
+	}
+}
+
+// subject returns the next of the shared response subjects. The subjects are mapped like the
+// subjects of the stream, e.g. to the tenant of the call.
+func (m *muxer) subject(subjects SubjectMapper) string {
+	i := atomic.AddUint32(&m.next, 1) % uint32(m.size)
+	return subjects.MapSubject("nrpc.mux." + m.id + "." + strconv.FormatUint(uint64(i), 10))
+}
+
+// subscribe passes the frames of the stream received on the shared subject to the handler. The subject
+// is subscribed if it is not yet or its subscription became invalid. Unsubscribing the returned
+// subscription removes the handler of the stream only.
+func (m *muxer) subscribe(subj, streamID string, handler pubsub.Handler) (pubsub.Subscription, error) {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	sub, ok := m.subs[subj]
+	if !ok || !sub.IsValid() {
+		var err error
+		if sub, err = m.sub.Subscribe(subj, m.queue, m.receive); err != nil {
+			return nil, err
+		}
+		m.log.Debug("subscribed shared response subject", "subject", subj, "queue", m.queue)
+		m.subs[subj] = sub
+	}
+	m.streams[streamID] = handler
+	return &muxSubscription{mux: m, sub: sub, streamID: streamID}, nil
+}
+
+// receive passes a frame received on a shared subject to its stream. Frames of ended streams are dropped.
+func (m *muxer) receive(ctx context.Context, msg pubsub.Replier) {
+	m.m.RLock()
+	handler, ok := m.streams[streamIDOf(msg.Data())]
+	m.m.RUnlock()
+	if !ok {
+		return
+	}
+	handler(ctx, msg)
+}
+
+func (m *muxer) remove(streamID string) {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	delete(m.streams, streamID)
+}
+
+// muxSubscription is the subscription of a stream to a shared subject.
+type muxSubscription struct {
+	mux      *muxer
+	sub      pubsub.Subscription
+	streamID string
+}
+
+// Unsubscribe stops passing frames to the stream. The shared subject stays subscribed.
+func (s *muxSubscription) Unsubscribe() error {
+	s.mux.remove(s.streamID)
+	return nil
+}
+
+// IsValid reports whether the shared subject is still subscribed.
+func (s *muxSubscription) IsValid() bool {
+	return s.sub.IsValid()
+}
+
+// Lost implements the pubsub.LossNotifier interface if the subscription of the shared subject does.
+func (s *muxSubscription) Lost() <-chan struct{} {
+	return lostChan(s.sub)
+}
+
+// appendStreamID sets the stream ID of a marshaled response by appending the field. The payload
+// is copied, as it might be kept for replay or still be referenced by the publisher.
+func appendStreamID(payload []byte, streamID string) []byte {
+	buf := make([]byte, 0, len(payload)+protowire.SizeTag(respStreamIDField)+protowire.SizeBytes(len(streamID)))
+	buf = append(buf, payload...)
+	buf = protowire.AppendTag(buf, respStreamIDField, protowire.BytesType)
+	return protowire.AppendString(buf, streamID)
+}
+
+// streamIDOf returns the stream ID of a marshaled response without unmarshaling the response.
+// Like on unmarshaling, the last occurrence of the field wins.
+func streamIDOf(data []byte) string {
+	var id []byte
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return ""
+		}
+		data = data[n:]
+		if num == respStreamIDField && typ == protowire.BytesType {
+			v, m := protowire.ConsumeBytes(data)
+			if m < 0 {
+				return ""
+			}
+			id, data = v, data[m:]
+			continue
+		}
+		m := protowire.ConsumeFieldValue(num, typ, data)
+		if m < 0 {
+			return ""
+		}
+		data = data[m:]
+	}
+	return string(id)
+}
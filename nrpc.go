@@ -7,42 +7,131 @@
 package nrpc
 
 import (
+	"math"
 	"time"
 
 	"github.com/tehsphinx/nrpc/pubsub"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 const (
 	streamConnectTimeout = 5 * time.Second
 	stuckTimeout         = 30 * time.Second
 	randSubjectLen       = 10
+	callIDLen            = 16
+	drainTimeout         = 30 * time.Second
+	// teardownTimeout is the time a client waits for the end of a stream it canceled,
+	// which carries the trailer of the server.
+	teardownTimeout = 200 * time.Millisecond
+	// defaultMaxRecvMsgSize and defaultMaxSendMsgSize are the defaults of grpc-go.
+	defaultMaxRecvMsgSize = 1024 * 1024 * 4
+	defaultMaxSendMsgSize = math.MaxInt32
+	// streamQueue is the default queue the stream subjects are subscribed with (see WithStreamQueueGroup).
+	streamQueue = "receive"
 )
 
 // NewClient creates a new pub-sub based grpc client.
 func NewClient(pub pubsub.Publisher, sub pubsub.Subscriber, opts ...Option) *Client {
 	opt := getOptions(opts)
+	// the state and the client ID are reported by the publisher of the broker, not by the wrappers of the options
+	states, _ := pub.(pubsub.StateReporter)
+	clientIDs, _ := pub.(pubsub.ClientIdentifier)
+	pub, sub = opt.pubSub(pub, sub)
+	var box *outbox
+	if opt.outbox != nil {
+		box = newOutbox(pub, opt.outbox, opt.logger)
+		pub = box
+	}
 
 	return &Client{
-		pub: pub,
-		sub: sub,
-		log: opt.logger,
+		pub:       pub,
+		sub:       sub,
+		states:    states,
+		clientIDs: clientIDs,
+		identity:  opt.identity,
+		log:       opt.logger,
+		cfg:       opt.streamConfig(),
+		codec:     opt.codec,
+		retry:     opt.retryPolicies,
+		hedging:   opt.hedgingPolicies,
+		creds:     opt.perRPCCreds,
+		ready:     opt.readiness,
+
+		breakerPolicies: opt.breakerPolicies,
+		breakers:        newCircuitBreakers(),
+
+		registry:    opt.registry,
+		balancing:   opt.balancingPolicies,
+		subjects:    opt.subjectMapper,
+		multiTenant: opt.multiTenant,
+		propagate:   newPropagation(opt.propagatedKeys),
+		outbox:      box,
+		idempotent:  opt.idempotentMethods,
+
+		serviceConfig: &serviceConfigHolder{cfg: opt.serviceConfig},
+
+		statsHandler: opt.clientStatsHandler,
+		streams:      newActiveStreams(),
+		mux:          newMuxer(sub, opt.logger, opt.muxSubjects, opt.streamQueue),
+
+		unaryInt:  chainUnaryClientInterceptors(opt.unaryClientInterceptors()),
+		streamInt: chainStreamClientInterceptors(opt.streamClientInterceptors()),
 	}
 }
 
 // NewServer creates a new pub-sub based grpc server.
 func NewServer(pub pubsub.Publisher, sub pubsub.Subscriber, opts ...Option) *Server {
 	opt := getOptions(opts)
+	// the transport is named by the subscriber of the broker, not by the wrappers of the options
+	transport := transportName(sub)
+	pub, sub = opt.pubSub(pub, sub)
 
-	return &Server{
-		pub:  pub,
-		sub:  sub,
-		log:  opt.logger,
-		subs: newSubscriptions(opt.logger),
+	s := &Server{
+		pub:       pub,
+		sub:       sub,
+		transport: transport,
+		log:       opt.logger,
+		cfg:       opt.streamConfig(),
+		subs:      newSubscriptions(opt.logger),
+		calls:     newInflightCalls(),
+		streams:   newActiveStreams(),
 
-		unaryInt:     opt.unaryInt,
-		streamInt:    opt.streamInt,
+		drainTimeout: opt.drainTimeout,
+		limits:       opt.concurrencyLimits,
+		limiter:      newLimiter(opt.globalLimit),
+
+		unaryInt:     chainUnaryServerInterceptors(opt.unaryServerInterceptors()),
+		streamInt:    chainStreamServerInterceptors(opt.streamServerInterceptors()),
 		statsHandler: opt.statsHandler,
 		serviceInfo:  map[string]grpc.ServiceInfo{},
+		health:       health.NewServer(),
+
+		subjects:         opt.subjectMapper,
+		multiTenant:      opt.multiTenant,
+		queueGroups:      opt.queueGroups,
+		id:               randString(instanceIDLen),
+		announceInterval: opt.announceInterval,
+		idempotency:      newIdempotency(opt.idempotencyStore, opt.idempotencyTTL, opt.logger),
+		auth:             opt.authenticator,
+		authz:            opt.authorizer,
+
+		methods:        map[string]struct{}{},
+		events:         map[string]struct{}{},
+		unknownHandler: opt.unknownHandler,
+		unknownLimiter: newLimiter(opt.concurrencyLimits.get("")),
+
+		pageSize:   opt.pageSize,
+		pagedBytes: opt.pagedBytes,
+		pushes:     newPushRegistry(),
+	}
+	if s.unknownHandler != nil {
+		s.registerUnknown()
+	}
+	if s.pageSize > 0 {
+		s.registerPages()
 	}
+	healthpb.RegisterHealthServer(s, s.health)
+	return s
 }
@@ -1,24 +1,90 @@
 package nrpc_test
 
 import (
+	"bytes"
 	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	stdjson "encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"testing/iotest"
 	"time"
 
+	"github.com/eclipse/paho.golang/paho"
 	"github.com/matryer/is"
+	_ "github.com/mattn/go-sqlite3"
+	natsgo "github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/tehsphinx/nrpc"
+	"github.com/tehsphinx/nrpc/auth"
+	"github.com/tehsphinx/nrpc/blob"
+	"github.com/tehsphinx/nrpc/bridge"
+	"github.com/tehsphinx/nrpc/dynamic"
+	"github.com/tehsphinx/nrpc/encoding/json"
+	"github.com/tehsphinx/nrpc/encoding/zstd"
+	"github.com/tehsphinx/nrpc/fault"
+	"github.com/tehsphinx/nrpc/gateway"
+	"github.com/tehsphinx/nrpc/grpcweb"
+	"github.com/tehsphinx/nrpc/introspection"
+	"github.com/tehsphinx/nrpc/liveconfig"
+	"github.com/tehsphinx/nrpc/metrics"
+	"github.com/tehsphinx/nrpc/nrpcpb"
+	"github.com/tehsphinx/nrpc/nrpctest"
+	"github.com/tehsphinx/nrpc/outbox"
+	"github.com/tehsphinx/nrpc/pubsub"
+	"github.com/tehsphinx/nrpc/pubsub/jetstream"
+	"github.com/tehsphinx/nrpc/pubsub/kafka"
+	"github.com/tehsphinx/nrpc/pubsub/memory"
+	"github.com/tehsphinx/nrpc/pubsub/mqtt"
 	"github.com/tehsphinx/nrpc/pubsub/nats"
+	"github.com/tehsphinx/nrpc/pubsub/redis"
+	"github.com/tehsphinx/nrpc/ratelimit"
+	"github.com/tehsphinx/nrpc/reflection"
+	rpb "github.com/tehsphinx/nrpc/reflection/grpc_reflection_v1"
+	"github.com/tehsphinx/nrpc/relay"
+	"github.com/tehsphinx/nrpc/replay"
+	"github.com/tehsphinx/nrpc/requestlog"
 	"github.com/tehsphinx/nrpc/testproto"
 	"github.com/tehsphinx/nrpc/testproto/testclient"
 	"github.com/tehsphinx/nrpc/testproto/testserver"
+	"github.com/tehsphinx/nrpc/tracing"
+	"github.com/tehsphinx/nrpc/validate"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	rpbalpha "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/stats"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/emptypb"
 )
 
 func TestUnary(t *testing.T) {
@@ -134,6 +200,29 @@ func TestServerStream(t *testing.T) {
 		md = stream.Trailer()
 		asrt.Equal(md.Get("traily"), []string{"t-value"})
 	})
+	t.Run("header before recv", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		// outbound header
+		md := metadata.New(map[string]string{"heady": "head1"})
+		ctx = metadata.NewOutgoingContext(ctx, md)
+
+		stream, err := client.ServerStream(ctx, &testproto.ServerStreamReq{
+			Msg: "Hello via NRPC",
+		})
+		asrt.NoErr(err)
+
+		md, err = stream.Header()
+		asrt.NoErr(err)
+		asrt.Equal(md.Get("heady"), []string{"head1"})
+		asrt.Equal(md.Get("srv-key"), []string{"srv-value"})
+
+		msg, err := stream.Recv()
+		asrt.NoErr(err)
+		asrt.Equal(msg.Msg, "Hello back! 1")
+	})
 	t.Run("stream error", func(t *testing.T) {
 		asrt := asrt.New(t)
 		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
@@ -156,6 +245,28 @@ func TestServerStream(t *testing.T) {
 		asrt.Equal(errStatus.Code(), codes.InvalidArgument)
 		asrt.Equal(errStatus.Message(), "invalid message")
 	})
+	t.Run("consumer stuck", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		// outbound header
+		md := metadata.New(map[string]string{"heady": "head1"})
+		ctx = metadata.NewOutgoingContext(ctx, md)
+
+		stream, err := client.ServerStream(ctx, &testproto.ServerStreamReq{
+			Msg: "Hello via NRPC",
+		}, nrpc.ConsumerStuckTimeout(50*time.Millisecond))
+		asrt.NoErr(err)
+
+		time.Sleep(200 * time.Millisecond)
+
+		var r error
+		for r == nil {
+			_, r = stream.Recv()
+		}
+		asrt.True(!errors.Is(r, io.EOF))
+	})
 }
 
 func TestClientStream(t *testing.T) {
@@ -274,3 +385,7212 @@ func TestBiDiStream(t *testing.T) {
 		asrt.Equal(md.Get("traily"), []string{"t-value"})
 	})
 }
+
+func TestFlowControl(t *testing.T) {
+	asrt := is.New(t)
+	ctxMain := context.Background()
+	logger := nrpc.StandardLogger{}
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	const msgCount = 20
+	_, impl, err := testserver.New(pub, sub, nrpc.WithLogger(logger), nrpc.WithStreamWindow(2))
+	asrt.NoErr(err)
+	impl.SetMsgCount(msgCount)
+	client := testclient.New(pub, sub, nrpc.WithLogger(logger), nrpc.WithStreamWindow(2))
+
+	t.Run("server stream", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+		ctx = metadata.NewOutgoingContext(ctx, metadata.New(map[string]string{"heady": "head1"}))
+
+		stream, err := client.ServerStream(ctx, &testproto.ServerStreamReq{
+			Msg: "Hello via NRPC",
+		})
+		asrt.NoErr(err)
+
+		var i int
+		for {
+			msg, r := stream.Recv()
+			if errors.Is(r, io.EOF) {
+				break
+			}
+			asrt.NoErr(r)
+
+			i++
+			asrt.Equal(msg.Msg, fmt.Sprintf("Hello back! %d", i))
+		}
+		asrt.Equal(i, msgCount)
+	})
+	t.Run("client stream", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+		ctx = metadata.NewOutgoingContext(ctx, metadata.New(map[string]string{"heady": "head1"}))
+
+		stream, err := client.ClientStream(ctx)
+		asrt.NoErr(err)
+
+		for i := 0; i < msgCount; i++ {
+			r := stream.Send(&testproto.ClientStreamReq{
+				Msg: fmt.Sprintf("Hello via NRPC %d", i+1),
+			})
+			asrt.NoErr(r)
+		}
+
+		resp, err := stream.CloseAndRecv()
+		asrt.NoErr(err)
+		asrt.Equal(resp.Msg, "Hello back!")
+	})
+}
+
+func TestStreamBatching(t *testing.T) {
+	asrt := is.New(t)
+	ctxMain := context.Background()
+	logger := nrpc.StandardLogger{}
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	const msgCount = 20
+	serverTap, clientTap := &frameRecorder{}, &frameRecorder{}
+	_, impl, err := testserver.New(pub, sub, nrpc.WithLogger(logger), nrpc.WithWireTap(serverTap),
+		nrpc.WithStreamBatching(8, 50*time.Millisecond), nrpc.WithStreamWindow(6))
+	asrt.NoErr(err)
+	impl.SetMsgCount(msgCount)
+
+	for _, tc := range []struct {
+		name    string
+		version uint32
+		batched bool
+	}{
+		{name: "batched", version: nrpc.WireVersion, batched: true},
+		{name: "old client", version: 1, batched: false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			asrt := asrt.New(t)
+			ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+			defer cancel()
+
+			serverTap.reset()
+			clientTap.reset()
+			// the window is smaller than the batch, so batches are published when the credit runs out
+			client := testclient.New(pub, sub, nrpc.WithLogger(logger), nrpc.WithWireTap(clientTap),
+				nrpc.WithStreamBatching(8, time.Minute), nrpc.WithStreamWindow(6), nrpc.WithWireVersion(tc.version))
+
+			stream, err := client.ServerStream(ctx, &testproto.ServerStreamReq{Msg: "Hello via NRPC"})
+			asrt.NoErr(err)
+			var i int
+			for {
+				msg, r := stream.Recv()
+				if errors.Is(r, io.EOF) {
+					break
+				}
+				asrt.NoErr(r)
+				i++
+				asrt.Equal(msg.Msg, fmt.Sprintf("Hello back! %d", i))
+			}
+			asrt.Equal(i, msgCount)
+			asrt.Equal(contains(clientTap.frames(), "received batch"), tc.batched)
+
+			cStream, err := client.ClientStream(ctx)
+			asrt.NoErr(err)
+			for i := 0; i < msgCount; i++ {
+				asrt.NoErr(cStream.Send(&testproto.ClientStreamReq{Msg: fmt.Sprintf("Hello via NRPC %d", i+1)}))
+			}
+			resp, err := cStream.CloseAndRecv()
+			asrt.NoErr(err)
+			asrt.Equal(resp.Msg, "Hello back!")
+			asrt.Equal(contains(serverTap.frames(), "received batch"), tc.batched)
+		})
+	}
+}
+
+func TestStreamMultiplexing(t *testing.T) {
+	asrt := is.New(t)
+	ctxMain := context.Background()
+	logger := nrpc.StandardLogger{}
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	const msgCount = 10
+	server, impl, err := testserver.New(pub, sub, nrpc.WithLogger(logger))
+	asrt.NoErr(err)
+	impl.SetMsgCount(msgCount)
+	nrpcClient := nrpc.NewClient(pub, sub, nrpc.WithLogger(logger), nrpc.WithStreamMultiplexing(2))
+	client := testproto.NewTestClient(nrpcClient)
+
+	t.Run("shared subjects", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		const streamCount = 5
+		var streams []testproto.Test_ServerStreamClient
+		for i := 0; i < streamCount; i++ {
+			stream, err := client.ServerStream(ctx, &testproto.ServerStreamReq{Msg: "Hello via NRPC"})
+			asrt.NoErr(err)
+			streams = append(streams, stream)
+		}
+		subjects := map[string]bool{}
+		for _, info := range nrpcClient.Streams() {
+			subjects[info.RespSubject] = true
+		}
+		asrt.Equal(len(subjects), 2)
+
+		var wg sync.WaitGroup
+		for _, stream := range streams {
+			wg.Add(1)
+			go func(stream testproto.Test_ServerStreamClient) {
+				defer wg.Done()
+				var i int
+				for {
+					msg, r := stream.Recv()
+					if errors.Is(r, io.EOF) {
+						break
+					}
+					asrt.NoErr(r)
+					i++
+					asrt.Equal(msg.Msg, fmt.Sprintf("Hello back! %d", i))
+				}
+				asrt.Equal(i, msgCount)
+			}(stream)
+		}
+		wg.Wait()
+	})
+	t.Run("old server", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		server.Stop()
+		oldServer, _, err := testserver.New(pub, sub, nrpc.WithLogger(logger), nrpc.WithWireVersion(2))
+		asrt.NoErr(err)
+		defer oldServer.Stop()
+
+		_, err = client.ServerStream(ctx, &testproto.ServerStreamReq{Msg: "Hello via NRPC"})
+		asrt.Equal(status.Code(err), codes.Unimplemented)
+	})
+}
+
+func TestSendPacing(t *testing.T) {
+	asrt := is.New(t)
+	ctxMain := context.Background()
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	const (
+		msgCount = 10
+		interval = 10 * time.Millisecond
+	)
+	_, impl, err := testserver.New(pub, sub, nrpc.WithStreamWindow(2),
+		nrpc.WithSendPacing(nrpc.SendPacingPolicy{Interval: interval, WriteDeadline: 100 * time.Millisecond}))
+	asrt.NoErr(err)
+	impl.SetMsgCount(msgCount)
+	client := testclient.New(pub, sub, nrpc.WithStreamWindow(2))
+
+	t.Run("interval", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		start := time.Now()
+		stream, err := client.ServerStream(ctx, &testproto.ServerStreamReq{Msg: "Hello via NRPC"})
+		asrt.NoErr(err)
+
+		var i int
+		for {
+			_, r := stream.Recv()
+			if errors.Is(r, io.EOF) {
+				break
+			}
+			asrt.NoErr(r)
+			i++
+		}
+		asrt.Equal(i, msgCount)
+		asrt.True(time.Since(start) >= (msgCount-1)*interval)
+	})
+	t.Run("write deadline", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		stream, err := client.ServerStream(ctx, &testproto.ServerStreamReq{Msg: "Hello via NRPC"})
+		asrt.NoErr(err)
+		_, err = stream.Recv()
+		asrt.NoErr(err)
+
+		// the stalled client grants no credit, so the server fails to send within the write deadline
+		time.Sleep(300 * time.Millisecond)
+
+		for {
+			_, err = stream.Recv()
+			if err != nil {
+				break
+			}
+		}
+		asrt.Equal(status.Code(err), codes.DeadlineExceeded)
+	})
+}
+
+// stalledEchoServer implements the testproto.EchoNRPCServer interface. Its stream handler waits for
+// release before receiving the messages of the client.
+type stalledEchoServer struct {
+	echoServer
+	release <-chan struct{}
+}
+
+func (s stalledEchoServer) Stream(stream testproto.Echo_StreamNRPCServer) error {
+	<-s.release
+	return s.echoServer.Stream(stream)
+}
+
+func TestSendContext(t *testing.T) {
+	asrt := is.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	release := make(chan struct{})
+	server := nrpc.NewServer(pub, sub, nrpc.WithStreamWindow(2))
+	testproto.RegisterEchoNRPCServer(server, stalledEchoServer{release: release})
+	asrt.NoErr(server.Run(ctx))
+	defer server.Stop()
+
+	client := testproto.NewEchoNRPCClient(nrpc.NewClient(pub, sub))
+	stream, err := client.Stream(ctx)
+	asrt.NoErr(err)
+	asrt.NoErr(stream.Send(&testproto.BiDiStreamReq{Msg: "first"}))
+	asrt.NoErr(stream.Send(&testproto.BiDiStreamReq{Msg: "second"}))
+
+	// the stalled server grants no credit, so the send gives up when its context is done
+	sendCtx, sendCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer sendCancel()
+	err = stream.SendContext(sendCtx, &testproto.BiDiStreamReq{Msg: "dropped"})
+	asrt.Equal(status.Code(err), codes.DeadlineExceeded)
+
+	// the stream is not aborted and sends the following messages once the server consumes the first ones
+	close(release)
+	asrt.NoErr(stream.SendContext(ctx, &testproto.BiDiStreamReq{Msg: "third"}))
+	asrt.NoErr(stream.CloseSend())
+
+	var received []string
+	for {
+		res, r := stream.Recv()
+		if errors.Is(r, io.EOF) {
+			break
+		}
+		asrt.NoErr(r)
+		received = append(received, res.Msg)
+	}
+	asrt.Equal(received, []string{"first", "second", "third"})
+}
+
+func TestRecvBuffer(t *testing.T) {
+	asrt := is.New(t)
+	ctxMain := context.Background()
+	logger := nrpc.StandardLogger{}
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	const msgCount = 20
+	_, impl, err := testserver.New(pub, sub, nrpc.WithLogger(logger))
+	asrt.NoErr(err)
+	impl.SetMsgCount(msgCount)
+
+	var dropped int32
+	client := testclient.New(pub, sub, nrpc.WithLogger(logger), nrpc.WithRecvBuffer(nrpc.RecvBufferPolicy{
+		Size:     3,
+		Overflow: nrpc.OverflowDropOldest,
+		OnDrop: func(_ context.Context, method string) {
+			if method == "/testproto.Test/ServerStream" {
+				atomic.AddInt32(&dropped, 1)
+			}
+		},
+	}))
+
+	recvAll := func(ctx context.Context, opts ...grpc.CallOption) ([]string, error) {
+		stream, err := client.ServerStream(ctx, &testproto.ServerStreamReq{Msg: "Hello via NRPC"}, opts...)
+		if err != nil {
+			return nil, err
+		}
+		// let the messages pile up in the receive buffer
+		time.Sleep(200 * time.Millisecond)
+
+		var msgs []string
+		for {
+			msg, r := stream.Recv()
+			if errors.Is(r, io.EOF) {
+				return msgs, nil
+			}
+			if r != nil {
+				return msgs, r
+			}
+			msgs = append(msgs, msg.Msg)
+		}
+	}
+
+	t.Run("drop oldest", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		msgs, err := recvAll(ctx)
+		asrt.NoErr(err)
+		// the newest messages and the end of the stream are kept
+		asrt.True(len(msgs) < msgCount)
+		asrt.Equal(msgs[len(msgs)-1], fmt.Sprintf("Hello back! %d", msgCount))
+		asrt.Equal(len(msgs)+int(atomic.LoadInt32(&dropped)), msgCount)
+	})
+	t.Run("abort", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		_, err := recvAll(ctx, nrpc.RecvBuffer(nrpc.RecvBufferPolicy{Size: 3, Overflow: nrpc.OverflowAbort}))
+		asrt.Equal(status.Code(err), codes.ResourceExhausted)
+	})
+}
+
+func TestStreamEvents(t *testing.T) {
+	asrt := is.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	_, impl, err := testserver.New(pub, sub)
+	asrt.NoErr(err)
+	impl.SetMsgCount(20)
+
+	var (
+		m       sync.Mutex
+		slow    []nrpc.StreamInfo
+		stuck   []nrpc.StreamInfo
+		aborted []error
+	)
+	client := testclient.New(pub, sub, nrpc.WithRecvBuffer(nrpc.RecvBufferPolicy{Size: 3}), nrpc.WithStreamEvents(nrpc.StreamEvents{
+		OnSlowConsumer: func(info nrpc.StreamInfo) {
+			m.Lock()
+			defer m.Unlock()
+			slow = append(slow, info)
+		},
+		OnStreamStuck: func(info nrpc.StreamInfo) {
+			m.Lock()
+			defer m.Unlock()
+			stuck = append(stuck, info)
+		},
+		OnStreamAborted: func(_ nrpc.StreamInfo, err error) {
+			m.Lock()
+			defer m.Unlock()
+			aborted = append(aborted, err)
+		},
+	}))
+	recvAll := func(opts ...grpc.CallOption) error {
+		stream, err := client.ServerStream(ctx, &testproto.ServerStreamReq{Msg: "Hello via NRPC"}, opts...)
+		if err != nil {
+			return err
+		}
+		// let the messages pile up in the receive buffer
+		time.Sleep(200 * time.Millisecond)
+		for {
+			if _, r := stream.Recv(); r != nil {
+				return r
+			}
+		}
+	}
+
+	err = recvAll(nrpc.ConsumerStuckTimeout(50 * time.Millisecond))
+	asrt.True(!errors.Is(err, io.EOF))
+	m.Lock()
+	asrt.True(len(slow) > 0)
+	asrt.Equal(slow[0].Method, "/testproto.Test/ServerStream")
+	asrt.True(slow[0].Client)
+	asrt.Equal(slow[0].Buffered, 3)
+	asrt.Equal(len(stuck), 1)
+	asrt.Equal(len(aborted), 0)
+	m.Unlock()
+
+	err = recvAll(nrpc.RecvBuffer(nrpc.RecvBufferPolicy{Size: 3, Overflow: nrpc.OverflowAbort}))
+	asrt.Equal(status.Code(err), codes.ResourceExhausted)
+	m.Lock()
+	asrt.Equal(len(aborted), 1)
+	asrt.Equal(status.Code(aborted[0]), codes.ResourceExhausted)
+	m.Unlock()
+}
+
+func TestClientInterceptors(t *testing.T) {
+	asrt := is.New(t)
+	ctxMain := context.Background()
+	logger := nrpc.StandardLogger{}
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	var calls []string
+	unaryInt := func(name string) grpc.UnaryClientInterceptor {
+		return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn,
+			invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			calls = append(calls, name)
+			ctx = metadata.AppendToOutgoingContext(ctx, name, method)
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+	}
+	streamInt := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string,
+		streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		ctx = metadata.AppendToOutgoingContext(ctx, "stream-int", method)
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+
+	_, _, err = testserver.New(pub, sub, nrpc.WithLogger(logger))
+	asrt.NoErr(err)
+	client := testclient.New(pub, sub, nrpc.WithLogger(logger),
+		nrpc.WithChainUnaryInterceptor(unaryInt("second"), unaryInt("third")),
+		nrpc.WithUnaryInterceptor(unaryInt("first")),
+		nrpc.WithStreamInterceptor(streamInt),
+	)
+
+	t.Run("unary", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		var header metadata.MD
+		_, err := client.Unary(ctx, &testproto.UnaryReq{
+			Msg: "Hello via NRPC",
+		}, grpc.Header(&header))
+		asrt.NoErr(err)
+
+		asrt.Equal(calls, []string{"first", "second", "third"})
+		asrt.Equal(header.Get("third"), []string{"/testproto.Test/Unary"})
+	})
+	t.Run("stream", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		stream, err := client.ServerStream(ctx, &testproto.ServerStreamReq{
+			Msg: "Hello via NRPC",
+		})
+		asrt.NoErr(err)
+
+		md, err := stream.Header()
+		asrt.NoErr(err)
+		asrt.Equal(md.Get("stream-int"), []string{"/testproto.Test/ServerStream"})
+	})
+}
+
+type interceptedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s interceptedStream) Context() context.Context {
+	return s.ctx
+}
+
+func TestServerInterceptors(t *testing.T) {
+	asrt := is.New(t)
+	ctxMain := context.Background()
+	logger := nrpc.StandardLogger{}
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	var (
+		m     sync.Mutex
+		calls []string
+	)
+	unaryInt := func(name string) grpc.UnaryServerInterceptor {
+		return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			m.Lock()
+			calls = append(calls, name)
+			m.Unlock()
+
+			md, _ := metadata.FromIncomingContext(ctx)
+			md = metadata.Join(md, metadata.Pairs(name, info.FullMethod))
+			return handler(metadata.NewIncomingContext(ctx, md), req)
+		}
+	}
+	streamInt := func(name string) grpc.StreamServerInterceptor {
+		return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			md, _ := metadata.FromIncomingContext(ss.Context())
+			md = metadata.Join(md, metadata.Pairs(name, info.FullMethod))
+			return handler(srv, interceptedStream{ServerStream: ss, ctx: metadata.NewIncomingContext(ss.Context(), md)})
+		}
+	}
+
+	_, _, err = testserver.New(pub, sub, nrpc.WithLogger(logger),
+		nrpc.ChainUnaryInterceptor(unaryInt("second"), unaryInt("third")),
+		nrpc.UnaryInterceptor(unaryInt("first")),
+		nrpc.ChainStreamInterceptor(streamInt("stream-first"), streamInt("stream-second")),
+	)
+	asrt.NoErr(err)
+	client := testclient.New(pub, sub, nrpc.WithLogger(logger))
+
+	t.Run("unary", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		var header metadata.MD
+		_, err := client.Unary(ctx, &testproto.UnaryReq{
+			Msg: "Hello via NRPC",
+		}, grpc.Header(&header))
+		asrt.NoErr(err)
+
+		asrt.Equal(calls, []string{"first", "second", "third"})
+		asrt.Equal(header.Get("third"), []string{"/testproto.Test/Unary"})
+	})
+	t.Run("stream", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		stream, err := client.ServerStream(ctx, &testproto.ServerStreamReq{
+			Msg: "Hello via NRPC",
+		})
+		asrt.NoErr(err)
+
+		md, err := stream.Header()
+		asrt.NoErr(err)
+		asrt.Equal(md.Get("stream-first"), []string{"/testproto.Test/ServerStream"})
+		asrt.Equal(md.Get("stream-second"), []string{"/testproto.Test/ServerStream"})
+	})
+}
+
+type statsTagKey struct{}
+
+// recordingStatsHandler implements the stats.Handler interface. It records the names of the events of
+// the calls tagged with a trace ID, which the client sends as metadata and the server extracts from it.
+type recordingStatsHandler struct {
+	m      sync.Mutex
+	events map[string][]string
+}
+
+func (h *recordingStatsHandler) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get("x-trace")) != 0 {
+		return context.WithValue(ctx, statsTagKey{}, "server "+md.Get("x-trace")[0])
+	}
+	ctx = metadata.AppendToOutgoingContext(ctx, "x-trace", info.FullMethodName)
+	return context.WithValue(ctx, statsTagKey{}, "client "+info.FullMethodName)
+}
+
+func (h *recordingStatsHandler) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	tag, _ := ctx.Value(statsTagKey{}).(string)
+	name := strings.TrimPrefix(fmt.Sprintf("%T", s), "*stats.")
+	if end, ok := s.(*stats.End); ok && end.Error != nil {
+		name += " " + status.Code(end.Error).String()
+	}
+
+	h.m.Lock()
+	defer h.m.Unlock()
+	h.events[tag] = append(h.events[tag], name)
+}
+
+func (h *recordingStatsHandler) get(tag string) []string {
+	h.m.Lock()
+	defer h.m.Unlock()
+	return h.events[tag]
+}
+
+func (h *recordingStatsHandler) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (h *recordingStatsHandler) HandleConn(context.Context, stats.ConnStats) {}
+
+func TestStatsHandler(t *testing.T) {
+	asrt := is.New(t)
+	ctxMain := context.Background()
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	handler := &recordingStatsHandler{events: map[string][]string{}}
+	_, _, err = testserver.New(pub, sub, nrpc.StatsHandler(handler))
+	asrt.NoErr(err)
+	client := testclient.New(pub, sub, nrpc.WithStatsHandler(handler))
+
+	// awaitEvents waits for the server to report the end of the call.
+	awaitEvents := func(tag string, count int) []string {
+		for i := 0; i < 100 && len(handler.get(tag)) < count; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		return handler.get(tag)
+	}
+
+	t.Run("unary", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		_, err := client.Unary(ctx, &testproto.UnaryReq{Msg: "Hello via NRPC"})
+		asrt.NoErr(err)
+		_, err = client.Unary(ctx, &testproto.UnaryReq{Msg: "invalid"})
+		asrt.Equal(status.Code(err), codes.InvalidArgument)
+
+		asrt.Equal(handler.get("client /testproto.Test/Unary"), []string{
+			"Begin", "OutHeader", "OutPayload", "InHeader", "InPayload", "InTrailer", "End",
+			"Begin", "OutHeader", "OutPayload", "InHeader", "InTrailer", "End InvalidArgument",
+		})
+		asrt.Equal(awaitEvents("server /testproto.Test/Unary", 11), []string{
+			"Begin", "InHeader", "InPayload", "OutHeader", "OutPayload", "OutTrailer", "End",
+			"Begin", "InHeader", "InPayload", "End InvalidArgument",
+		})
+	})
+	t.Run("server stream", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		stream, err := client.ServerStream(ctx, &testproto.ServerStreamReq{Msg: "Hello via NRPC"})
+		asrt.NoErr(err)
+		for {
+			_, r := stream.Recv()
+			if errors.Is(r, io.EOF) {
+				break
+			}
+			asrt.NoErr(r)
+		}
+
+		// the header might be received before the request is reported as sent
+		events := handler.get("client /testproto.Test/ServerStream")
+		asrt.Equal(len(events), 11)
+		asrt.Equal(events[:2], []string{"Begin", "OutHeader"})
+		asrt.Equal(events[4:], []string{"InPayload", "InPayload", "InPayload", "InPayload", "InPayload", "InTrailer", "End"})
+
+		events = awaitEvents("server /testproto.Test/ServerStream", 1)
+		asrt.Equal(events[:3], []string{"Begin", "InHeader", "InPayload"})
+	})
+}
+
+func TestIntrospection(t *testing.T) {
+	asrt := is.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	server, _, err := testserver.New(pub, sub)
+	asrt.NoErr(err)
+	nrpcClient := nrpc.NewClient(pub, sub)
+	client := testproto.NewTestClient(nrpcClient)
+
+	stream, err := client.BiDiStream(ctx)
+	asrt.NoErr(err)
+	asrt.NoErr(stream.Send(&testproto.BiDiStreamReq{Msg: "Hello via NRPC 1"}))
+	_, err = stream.Recv()
+	asrt.NoErr(err)
+
+	clientStreams := nrpcClient.Streams()
+	asrt.Equal(len(clientStreams), 1)
+	asrt.Equal(clientStreams[0].Method, "/testproto.Test/BiDiStream")
+	asrt.True(clientStreams[0].Client)
+	asrt.True(clientStreams[0].FramesSent >= 1)
+	asrt.True(clientStreams[0].FramesReceived >= 2) // handshake and response
+	serverStreams := server.Streams()
+	asrt.Equal(len(serverStreams), 1)
+	asrt.True(!serverStreams[0].Client)
+	asrt.Equal(serverStreams[0].ReqSubject, clientStreams[0].ReqSubject)
+
+	srv := httptest.NewServer(introspection.Handler(server, nrpcClient))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	asrt.NoErr(err)
+	defer resp.Body.Close()
+	var listed struct {
+		Streams []introspection.Stream
+	}
+	asrt.NoErr(stdjson.NewDecoder(resp.Body).Decode(&listed))
+	asrt.Equal(len(listed.Streams), 2)
+	asrt.Equal(listed.Streams[0].RespSubject, clientStreams[0].RespSubject)
+
+	// ended streams are removed
+	asrt.NoErr(stream.CloseSend())
+	for {
+		if _, r := stream.Recv(); r != nil {
+			asrt.True(errors.Is(r, io.EOF))
+			break
+		}
+	}
+	for i := 0; i < 100 && len(nrpcClient.Streams())+len(server.Streams()) != 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	asrt.Equal(len(nrpcClient.Streams()), 0)
+	asrt.Equal(len(server.Streams()), 0)
+}
+
+func TestStreamStats(t *testing.T) {
+	asrt := is.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	server, _, err := testserver.New(pub, sub)
+	asrt.NoErr(err)
+	nrpcClient := nrpc.NewClient(pub, sub)
+	client := testproto.NewTestClient(nrpcClient)
+
+	const method = "/testproto.Test/ServerStream"
+
+	stream, err := client.ServerStream(ctx, &testproto.ServerStreamReq{Msg: "Hello via NRPC"})
+	asrt.NoErr(err)
+	_, err = stream.Recv()
+	asrt.NoErr(err)
+
+	info, ok := nrpc.StreamStatsFromContext(stream.Context())
+	asrt.True(ok)
+	asrt.Equal(info.Method, method)
+	asrt.True(info.FramesSent >= 1)
+	asrt.True(info.BytesSent > 0)
+	asrt.True(info.BytesReceived > 0)
+
+	active := nrpcClient.Stats().Methods[method]
+	asrt.Equal(active.Active, 1)
+	asrt.Equal(active.Ended, uint64(0))
+
+	for {
+		if _, r := stream.Recv(); r != nil {
+			asrt.True(errors.Is(r, io.EOF))
+			break
+		}
+	}
+	for i := 0; i < 100 && len(nrpcClient.Streams())+len(server.Streams()) != 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// ended streams keep counting towards the statistics of their method
+	clientStats := nrpcClient.Stats().Methods[method]
+	asrt.Equal(clientStats.Active, 0)
+	asrt.Equal(clientStats.Ended, uint64(1))
+	asrt.True(clientStats.FramesReceived >= info.FramesReceived)
+	asrt.True(clientStats.BytesReceived >= info.BytesReceived)
+	asrt.True(!clientStats.LastActivity.IsZero())
+
+	serverStats := server.Stats().Methods[method]
+	asrt.Equal(serverStats.Ended, uint64(1))
+	asrt.True(serverStats.FramesReceived >= 1)
+	asrt.True(serverStats.BytesSent > 0)
+}
+
+// pushServer implements the testproto.TestServer interface. Its server streams accept the messages pushed
+// to their push key, which must match the message of the request, and send them to the client.
+type pushServer struct {
+	testproto.UnimplementedTestServer
+}
+
+func (pushServer) ServerStream(req *testproto.ServerStreamReq, stream testproto.Test_ServerStreamServer) error {
+	md, _ := metadata.FromIncomingContext(stream.Context())
+	if keys := md.Get(nrpc.PushKeyMetadataKey); len(keys) == 0 || keys[0] != req.Msg {
+		return status.Error(codes.PermissionDenied, "push key of another user")
+	}
+	pushed, err := nrpc.AcceptPush(stream)
+	if err != nil {
+		return err
+	}
+	// the header tells the client that pushed messages reach the stream from now on
+	if r := stream.SendHeader(metadata.Pairs("accepted", req.Msg)); r != nil {
+		return r
+	}
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case msg := <-pushed:
+			if r := stream.SendMsg(msg); r != nil {
+				return r
+			}
+		}
+	}
+}
+
+func TestPush(t *testing.T) {
+	asrt := is.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	// two instances serve the streams, a third one only pushes messages
+	var servers []*nrpc.Server
+	for i := 0; i < 3; i++ {
+		server := nrpc.NewServer(pub, sub)
+		if i < 2 {
+			testproto.RegisterTestServer(server, pushServer{})
+		}
+		asrt.NoErr(server.Run(ctx))
+		defer server.Stop()
+		servers = append(servers, server)
+	}
+	client := testproto.NewTestClient(nrpc.NewClient(pub, sub))
+
+	open := func(ctx context.Context, key string) testproto.Test_ServerStreamClient {
+		stream, err := client.ServerStream(nrpc.NewPushKeyContext(ctx, key), &testproto.ServerStreamReq{Msg: key})
+		asrt.NoErr(err)
+		header, err := stream.Header()
+		asrt.NoErr(err)
+		asrt.Equal(header.Get("accepted"), []string{key})
+		return stream
+	}
+	recv := func(stream testproto.Test_ServerStreamClient, want string) {
+		resp, err := stream.Recv()
+		asrt.NoErr(err)
+		asrt.Equal(resp.Msg, want)
+	}
+
+	first := open(ctx, "user-1")
+	other := open(ctx, "user-2")
+	secondCtx, cancelSecond := context.WithCancel(ctx)
+	second := open(secondCtx, "user-1")
+
+	// all streams of the key receive the pushed messages, whichever instance serves them
+	asrt.NoErr(servers[2].Push(ctx, "user-1", &testproto.ServerStreamResp{Msg: "for user-1"}))
+	asrt.NoErr(servers[0].Push(ctx, "user-2", &testproto.ServerStreamResp{Msg: "for user-2"}))
+	recv(first, "for user-1")
+	recv(second, "for user-1")
+	recv(other, "for user-2")
+
+	// ended streams no longer accept pushed messages
+	cancelSecond()
+	asrt.NoErr(servers[1].Push(ctx, "user-1", &testproto.ServerStreamResp{Msg: "again"}))
+	recv(first, "again")
+
+	// servers validate the key before accepting it
+	stream, err := client.ServerStream(nrpc.NewPushKeyContext(ctx, "user-1"), &testproto.ServerStreamReq{Msg: "user-2"})
+	asrt.NoErr(err)
+	_, err = stream.Recv()
+	asrt.Equal(status.Code(err), codes.PermissionDenied)
+
+	t.Run("listen", func(t *testing.T) {
+		asrt := asrt.New(t)
+
+		// the streams of an instance started with Listen accept the messages pushed by other instances
+		listening := nrpc.NewServer(pub, sub, nrpc.WithSubjectPrefix("listen"))
+		testproto.RegisterTestServer(listening, pushServer{})
+		listenCtx, stopListening := context.WithCancel(ctx)
+		defer stopListening()
+		go func() {
+			_ = listening.Listen(listenCtx)
+		}()
+		pushing := nrpc.NewServer(pub, sub, nrpc.WithSubjectPrefix("listen"))
+		asrt.NoErr(pushing.Run(ctx))
+		defer pushing.Stop()
+
+		// the stream is opened once the instance subscribed
+		client := testproto.NewTestClient(nrpc.NewClient(pub, sub, nrpc.WithSubjectPrefix("listen")))
+		var stream testproto.Test_ServerStreamClient
+		for stream == nil {
+			s, err := client.ServerStream(nrpc.NewPushKeyContext(ctx, "user-1"), &testproto.ServerStreamReq{Msg: "user-1"})
+			if err == nil {
+				_, err = s.Header()
+			}
+			if status.Code(err) != codes.Unavailable {
+				asrt.NoErr(err)
+				stream = s
+			}
+		}
+
+		asrt.NoErr(pushing.Push(ctx, "user-1", &testproto.ServerStreamResp{Msg: "listening"}))
+		resp, err := stream.Recv()
+		asrt.NoErr(err)
+		asrt.Equal(resp.Msg, "listening")
+	})
+
+	t.Run("tenants", func(t *testing.T) {
+		asrt := asrt.New(t)
+
+		tenantServer := nrpc.NewServer(pub, sub, nrpc.WithMultiTenancy(), nrpc.WithSubjectPrefix("tenants"))
+		testproto.RegisterTestServer(tenantServer, pushServer{})
+		asrt.NoErr(tenantServer.Run(ctx))
+		defer tenantServer.Stop()
+		client := testproto.NewTestClient(nrpc.NewClient(pub, sub, nrpc.WithMultiTenancy(), nrpc.WithSubjectPrefix("tenants")))
+
+		// both tenants open a stream with the same push key
+		open := func(tenant string) testproto.Test_ServerStreamClient {
+			stream, err := client.ServerStream(nrpc.NewPushKeyContext(nrpc.NewTenantContext(ctx, tenant), "user-1"),
+				&testproto.ServerStreamReq{Msg: "user-1"})
+			asrt.NoErr(err)
+			_, err = stream.Header()
+			asrt.NoErr(err)
+			return stream
+		}
+		acme, other := open("acme"), open("other")
+
+		// the streams only receive the messages pushed to their tenant
+		asrt.NoErr(tenantServer.Push(nrpc.NewTenantContext(ctx, "acme"), "user-1", &testproto.ServerStreamResp{Msg: "for acme"}))
+		asrt.NoErr(tenantServer.Push(nrpc.NewTenantContext(ctx, "other"), "user-1", &testproto.ServerStreamResp{Msg: "for other"}))
+		for stream, want := range map[testproto.Test_ServerStreamClient]string{acme: "for acme", other: "for other"} {
+			resp, err := stream.Recv()
+			asrt.NoErr(err)
+			asrt.Equal(resp.Msg, want)
+		}
+
+		// pushing needs a tenant
+		err = tenantServer.Push(ctx, "user-1", &testproto.ServerStreamResp{Msg: "for anyone"})
+		asrt.Equal(status.Code(err), codes.InvalidArgument)
+	})
+}
+
+func TestWireVersion(t *testing.T) {
+	asrt := is.New(t)
+	ctxMain, cancelMain := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelMain()
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	// version 0 speaks the envelope of peers from before the versioning
+	for _, tc := range []struct {
+		client, server, negotiated uint32
+	}{
+		{client: 0, server: 0, negotiated: 0},
+		{client: 0, server: nrpc.WireVersion, negotiated: 0},
+		{client: nrpc.WireVersion, server: 0, negotiated: 0},
+		{client: nrpc.WireVersion, server: nrpc.WireVersion, negotiated: nrpc.WireVersion},
+	} {
+		t.Run(fmt.Sprintf("client v%d server v%d", tc.client, tc.server), func(t *testing.T) {
+			asrt := asrt.New(t)
+			ctx := metadata.NewOutgoingContext(ctxMain, metadata.Pairs("heady", "head1"))
+
+			server, _, err := testserver.New(pub, sub, nrpc.WithWireVersion(tc.server))
+			asrt.NoErr(err)
+			defer server.Stop()
+			nrpcClient := nrpc.NewClient(pub, sub, nrpc.WithWireVersion(tc.client))
+			client := testproto.NewTestClient(nrpcClient)
+
+			resp, err := client.Unary(ctx, &testproto.UnaryReq{Msg: "Hello via NRPC"})
+			asrt.NoErr(err)
+			asrt.Equal(resp.Msg, "Hello back!")
+
+			stream, err := client.BiDiStream(ctx)
+			asrt.NoErr(err)
+			asrt.NoErr(stream.Send(&testproto.BiDiStreamReq{Msg: "Hello via NRPC 1"}))
+			_, err = stream.Recv()
+			asrt.NoErr(err)
+			asrt.Equal(nrpcClient.Streams()[0].WireVersion, tc.negotiated)
+			asrt.Equal(server.Streams()[0].WireVersion, tc.negotiated)
+			asrt.NoErr(stream.CloseSend())
+			_, err = stream.Recv()
+			asrt.Equal(err, io.EOF)
+		})
+	}
+	t.Run("future client", func(t *testing.T) {
+		asrt := asrt.New(t)
+
+		server, _, err := testserver.New(pub, sub)
+		asrt.NoErr(err)
+		defer server.Stop()
+
+		// a client of a later release speaking a version unknown to the server
+		data, err := proto.Marshal(&testproto.UnaryReq{Msg: "Hello via NRPC"})
+		asrt.NoErr(err)
+		payload, err := proto.Marshal(&nrpc.Request{Data: data, Version: nrpc.WireVersion + 7})
+		asrt.NoErr(err)
+		reply, err := conn.RequestWithContext(ctxMain, "nrpc.testproto.Test.Unary", payload)
+		asrt.NoErr(err)
+
+		var msg nrpc.Message
+		asrt.NoErr(proto.Unmarshal(reply.Data, &msg))
+		asrt.Equal(msg.Type, nrpc.MessageType_Data)
+		var resp nrpc.Response
+		asrt.NoErr(proto.Unmarshal(msg.Data, &resp))
+		asrt.Equal(resp.Version, nrpc.WireVersion)
+		var unary testproto.UnaryResp
+		asrt.NoErr(proto.Unmarshal(resp.Data, &unary))
+		asrt.Equal(unary.Msg, "Hello back!")
+	})
+}
+
+func TestChunking(t *testing.T) {
+	asrt := is.New(t)
+	ctxMain := context.Background()
+	logger := nrpc.StandardLogger{}
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	// small chunk size to force every message into chunks
+	_, _, err = testserver.New(pub, sub, nrpc.WithLogger(logger), nrpc.WithChunkSize(8))
+	asrt.NoErr(err)
+	client := testclient.New(pub, sub, nrpc.WithLogger(logger), nrpc.WithChunkSize(8))
+
+	t.Run("server stream", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+		ctx = metadata.NewOutgoingContext(ctx, metadata.New(map[string]string{"heady": "head1"}))
+
+		stream, err := client.ServerStream(ctx, &testproto.ServerStreamReq{
+			Msg: "Hello via NRPC",
+		})
+		asrt.NoErr(err)
+
+		var i int
+		for {
+			msg, r := stream.Recv()
+			if errors.Is(r, io.EOF) {
+				break
+			}
+			asrt.NoErr(r)
+
+			i++
+			asrt.Equal(msg.Msg, fmt.Sprintf("Hello back! %d", i))
+		}
+		asrt.Equal(i, 5)
+
+		md, err := stream.Header()
+		asrt.NoErr(err)
+		asrt.Equal(md.Get("srv-key"), []string{"srv-value"})
+		asrt.Equal(stream.Trailer().Get("traily"), []string{"t-value"})
+	})
+	t.Run("bidi stream", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+		ctx = metadata.NewOutgoingContext(ctx, metadata.New(map[string]string{"heady": "head1"}))
+
+		stream, err := client.BiDiStream(ctx)
+		asrt.NoErr(err)
+
+		for i := 0; i < 5; i++ {
+			r := stream.Send(&testproto.BiDiStreamReq{
+				Msg: fmt.Sprintf("Hello via NRPC %d", i+1),
+			})
+			asrt.NoErr(r)
+
+			resp, r := stream.Recv()
+			asrt.NoErr(r)
+			asrt.Equal(resp.Msg, fmt.Sprintf("Hello back! %d", i+1))
+		}
+		asrt.NoErr(stream.CloseSend())
+
+		_, err = stream.Recv()
+		asrt.True(errors.Is(err, io.EOF))
+	})
+}
+
+func TestCompression(t *testing.T) {
+	asrt := is.New(t)
+	ctxMain := context.Background()
+	logger := nrpc.StandardLogger{}
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	_, _, err = testserver.New(pub, sub, nrpc.WithLogger(logger))
+	asrt.NoErr(err)
+	client := testclient.New(pub, sub, nrpc.WithLogger(logger))
+
+	for _, compressor := range []string{"gzip", zstd.Name} {
+		compressor := compressor
+
+		t.Run(compressor+" unary", func(t *testing.T) {
+			asrt := asrt.New(t)
+			ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+			defer cancel()
+
+			resp, err := client.Unary(ctx, &testproto.UnaryReq{
+				Msg: "Hello via NRPC",
+			}, grpc.UseCompressor(compressor))
+			asrt.NoErr(err)
+			asrt.Equal(resp.Msg, "Hello back!")
+		})
+		t.Run(compressor+" bidi stream", func(t *testing.T) {
+			asrt := asrt.New(t)
+			ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+			defer cancel()
+
+			stream, err := client.BiDiStream(ctx, grpc.UseCompressor(compressor))
+			asrt.NoErr(err)
+
+			for i := 0; i < 5; i++ {
+				r := stream.Send(&testproto.BiDiStreamReq{
+					Msg: fmt.Sprintf("Hello via NRPC %d", i+1),
+				})
+				asrt.NoErr(r)
+
+				resp, r := stream.Recv()
+				asrt.NoErr(r)
+				asrt.Equal(resp.Msg, fmt.Sprintf("Hello back! %d", i+1))
+			}
+			asrt.NoErr(stream.CloseSend())
+
+			_, err = stream.Recv()
+			asrt.True(errors.Is(err, io.EOF))
+		})
+	}
+
+	t.Run("unknown compressor", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		_, err := client.Unary(ctx, &testproto.UnaryReq{
+			Msg: "Hello via NRPC",
+		}, grpc.UseCompressor("unknown"))
+		asrt.Equal(status.Code(err), codes.Internal)
+	})
+}
+
+func TestJetStream(t *testing.T) {
+	asrt := is.New(t)
+	ctxMain := context.Background()
+	logger := nrpc.StandardLogger{}
+
+	conn, js, shutdown, err := testproto.NewTestJetStreamConn()
+	asrt.NoErr(err)
+	defer shutdown()
+	asrt.NoErr(jetstream.AddStream(js, "nrpc"))
+
+	pub := jetstream.Publisher(conn, js)
+	sub := jetstream.Subscriber(conn, js)
+
+	_, _, err = testserver.New(pub, sub, nrpc.WithLogger(logger))
+	asrt.NoErr(err)
+	client := testclient.New(pub, sub, nrpc.WithLogger(logger))
+
+	t.Run("unary", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		resp, err := client.Unary(ctx, &testproto.UnaryReq{
+			Msg: "Hello via NRPC",
+		})
+		asrt.NoErr(err)
+		asrt.Equal(resp.Msg, "Hello back!")
+	})
+	t.Run("client stream", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		stream, err := client.ClientStream(ctx)
+		asrt.NoErr(err)
+
+		for i := 0; i < 5; i++ {
+			r := stream.Send(&testproto.ClientStreamReq{
+				Msg: fmt.Sprintf("Hello via NRPC %d", i+1),
+			})
+			asrt.NoErr(r)
+		}
+
+		resp, err := stream.CloseAndRecv()
+		asrt.NoErr(err)
+		asrt.Equal(resp.Msg, "Hello back!")
+		asrt.Equal(stream.Trailer().Get("traily"), []string{"t-value"})
+	})
+	t.Run("bidi stream", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		stream, err := client.BiDiStream(ctx)
+		asrt.NoErr(err)
+
+		for i := 0; i < 5; i++ {
+			r := stream.Send(&testproto.BiDiStreamReq{
+				Msg: fmt.Sprintf("Hello via NRPC %d", i+1),
+			})
+			asrt.NoErr(r)
+
+			resp, r := stream.Recv()
+			asrt.NoErr(r)
+			asrt.Equal(resp.Msg, fmt.Sprintf("Hello back! %d", i+1))
+		}
+		asrt.NoErr(stream.CloseSend())
+
+		_, err = stream.Recv()
+		asrt.True(errors.Is(err, io.EOF))
+	})
+}
+
+func TestJetStreamJobs(t *testing.T) {
+	keys := map[string][]byte{"1": bytes.Repeat([]byte{1}, 32)}
+	for _, tc := range []struct {
+		name string
+		opts []nrpc.Option
+	}{
+		{name: "plain"},
+		// decrypted jobs are acknowledged on the messages they were received with
+		{name: "encrypted", opts: []nrpc.Option{nrpc.WithEncryption(nrpc.StaticKeys("1", keys))}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			testJetStreamJobs(t, tc.opts...)
+		})
+	}
+}
+
+func testJetStreamJobs(t *testing.T, opts ...nrpc.Option) {
+	asrt := is.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	conn, js, shutdown, err := testproto.NewTestJetStreamConn()
+	asrt.NoErr(err)
+	defer shutdown()
+	asrt.NoErr(jetstream.AddJobStream(js, "jobs"))
+
+	const ackWait = 300 * time.Millisecond
+	client := nrpc.NewClient(jetstream.Publisher(conn, js), jetstream.Subscriber(conn, js), opts...)
+
+	// the first worker crashes while handling the job
+	crashConn, err := natsgo.Connect(conn.ConnectedUrl())
+	asrt.NoErr(err)
+	crashJS, err := crashConn.JetStream()
+	asrt.NoErr(err)
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	defer close(release)
+	crashing := nrpc.NewServer(jetstream.Publisher(crashConn, crashJS),
+		jetstream.Subscriber(crashConn, crashJS, jetstream.JobAckWait(ackWait)), opts...)
+	crashing.HandleJob("reports", func() interface{} { return &testproto.UnaryReq{} },
+		func(ctx context.Context, _ interface{}) error {
+			started <- struct{}{}
+			<-release
+			return nil
+		})
+	asrt.NoErr(crashing.Run(ctx))
+
+	asrt.NoErr(client.PublishJob(ctx, "reports", &testproto.UnaryReq{Msg: "report"}))
+	select {
+	case <-started:
+	case <-ctx.Done():
+		t.Fatal("job not received")
+	}
+	crashConn.Close()
+
+	// the second worker receives the job once its lease expired: it fails the first attempt, so the
+	// job is requeued, and extends the lease while handling the second attempt for longer than it lasts
+	attempts := make(chan string, 10)
+	var attempt int32
+	worker := nrpc.NewServer(jetstream.Publisher(conn, js), jetstream.Subscriber(conn, js, jetstream.JobAckWait(ackWait)),
+		opts...)
+	worker.HandleJob("reports", func() interface{} { return &testproto.UnaryReq{} },
+		func(ctx context.Context, req interface{}) error {
+			attempts <- req.(*testproto.UnaryReq).Msg
+			if atomic.AddInt32(&attempt, 1) == 1 {
+				return status.Error(codes.Unavailable, "try again")
+			}
+
+			job := nrpc.JobFromContext(ctx)
+			for i := 0; i < 6; i++ {
+				time.Sleep(ackWait / 3)
+				if r := job.Extend(); r != nil {
+					return r
+				}
+			}
+			if r := job.Ack(); r != nil {
+				return r
+			}
+			if r := job.Ack(); status.Code(r) != codes.FailedPrecondition {
+				return fmt.Errorf("unexpected error of a second ack: %v", r)
+			}
+			return nil
+		})
+	asrt.NoErr(worker.Run(ctx))
+	defer worker.Stop()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-attempts:
+			asrt.Equal(msg, "report")
+		case <-ctx.Done():
+			t.Fatal("job not redelivered")
+		}
+	}
+	select {
+	case <-attempts:
+		t.Fatal("acknowledged job redelivered")
+	case <-time.After(3 * ackWait):
+	}
+	asrt.Equal(atomic.LoadInt32(&attempt), int32(2))
+
+	// jobs the handler rejects are dropped instead of being requeued
+	rejected := make(chan struct{}, 10)
+	rejecting := nrpc.NewServer(jetstream.Publisher(conn, js), jetstream.Subscriber(conn, js, jetstream.JobAckWait(ackWait)),
+		opts...)
+	rejecting.HandleJob("invalid", func() interface{} { return &testproto.UnaryReq{} },
+		func(context.Context, interface{}) error {
+			rejected <- struct{}{}
+			return status.Error(codes.InvalidArgument, "never valid")
+		})
+	asrt.NoErr(rejecting.Run(ctx))
+	defer rejecting.Stop()
+
+	asrt.NoErr(client.PublishJob(ctx, "invalid", &testproto.UnaryReq{Msg: "invalid"}))
+	select {
+	case <-rejected:
+	case <-ctx.Done():
+		t.Fatal("job not received")
+	}
+	select {
+	case <-rejected:
+		t.Fatal("rejected job redelivered")
+	case <-time.After(3 * ackWait):
+	}
+}
+
+func TestRedis(t *testing.T) {
+	asrt := is.New(t)
+	ctxMain := context.Background()
+	logger := nrpc.StandardLogger{}
+
+	redisClient, shutdown, err := testproto.NewTestRedisClient()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := redis.Publisher(redisClient)
+	sub := redis.Subscriber(redisClient)
+
+	_, _, err = testserver.New(pub, sub, nrpc.WithLogger(logger))
+	asrt.NoErr(err)
+	client := testclient.New(pub, sub, nrpc.WithLogger(logger))
+
+	t.Run("unary", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		resp, err := client.Unary(ctx, &testproto.UnaryReq{
+			Msg: "Hello via NRPC",
+		})
+		asrt.NoErr(err)
+		asrt.Equal(resp.Msg, "Hello back!")
+	})
+	t.Run("client stream", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		stream, err := client.ClientStream(ctx)
+		asrt.NoErr(err)
+
+		for i := 0; i < 5; i++ {
+			r := stream.Send(&testproto.ClientStreamReq{
+				Msg: fmt.Sprintf("Hello via NRPC %d", i+1),
+			})
+			asrt.NoErr(r)
+		}
+
+		resp, err := stream.CloseAndRecv()
+		asrt.NoErr(err)
+		asrt.Equal(resp.Msg, "Hello back!")
+		asrt.Equal(stream.Trailer().Get("traily"), []string{"t-value"})
+	})
+	t.Run("bidi stream", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		stream, err := client.BiDiStream(ctx)
+		asrt.NoErr(err)
+
+		for i := 0; i < 5; i++ {
+			r := stream.Send(&testproto.BiDiStreamReq{
+				Msg: fmt.Sprintf("Hello via NRPC %d", i+1),
+			})
+			asrt.NoErr(r)
+
+			resp, r := stream.Recv()
+			asrt.NoErr(r)
+			asrt.Equal(resp.Msg, fmt.Sprintf("Hello back! %d", i+1))
+		}
+		asrt.NoErr(stream.CloseSend())
+
+		_, err = stream.Recv()
+		asrt.True(errors.Is(err, io.EOF))
+	})
+	t.Run("wildcards", func(t *testing.T) {
+		asrt := asrt.New(t)
+
+		// wildcard subjects are subscribed with PSUBSCRIBE and matched by token
+		received := make(chan string, 10)
+		for _, subject := range []string{"test.*.event", "test.>"} {
+			subject := subject
+			s, err := sub.Subscribe(subject, "", func(_ context.Context, msg pubsub.Replier) {
+				received <- subject + " " + msg.Subject()
+			})
+			asrt.NoErr(err)
+			defer s.Unsubscribe()
+		}
+
+		for _, subject := range []string{"test.a.event", "test.a.b.event", "test", "other.a.event"} {
+			asrt.NoErr(pub.Publish(pubsub.Message{Subject: subject}))
+		}
+		var got []string
+		for len(got) < 3 {
+			select {
+			case msg := <-received:
+				got = append(got, msg)
+			case <-time.After(2 * time.Second):
+				t.Fatalf("received %v", got)
+			}
+		}
+		select {
+		case msg := <-received:
+			t.Fatalf("unexpected message %s", msg)
+		case <-time.After(50 * time.Millisecond):
+		}
+		sort.Strings(got)
+		asrt.Equal(got, []string{"test.*.event test.a.event", "test.> test.a.b.event", "test.> test.a.event"})
+	})
+	t.Run("multi-tenancy", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		// the server subscribes the subjects of all tenants with a wildcard
+		server := nrpc.NewServer(pub, sub, nrpc.WithMultiTenancy())
+		testproto.RegisterEchoServer(server, tenantServer{})
+		asrt.NoErr(server.Run(ctx))
+		defer server.Stop()
+
+		client := testproto.NewEchoClient(nrpc.NewClient(pub, sub, nrpc.WithMultiTenancy()))
+		resp, err := client.Echo(nrpc.NewTenantContext(ctx, "a"), &testproto.UnaryReq{})
+		asrt.NoErr(err)
+		asrt.Equal(resp.Msg, "a")
+	})
+}
+
+// TestKafka runs against the Kafka brokers listed in NRPC_TEST_KAFKA_BROKERS (comma separated), e.g.
+// localhost:9092. It is skipped without brokers.
+func TestKafka(t *testing.T) {
+	brokers := os.Getenv("NRPC_TEST_KAFKA_BROKERS")
+	if brokers == "" {
+		t.Skip("NRPC_TEST_KAFKA_BROKERS not set")
+	}
+	asrt := is.New(t)
+	ctxMain := context.Background()
+	logger := nrpc.StandardLogger{}
+
+	conn, err := kafka.Dial(strings.Split(brokers, ",")...)
+	asrt.NoErr(err)
+	defer conn.Close()
+
+	pub := kafka.Publisher(conn)
+	sub := kafka.Subscriber(conn)
+
+	server, _, err := testserver.New(pub, sub, nrpc.WithLogger(logger))
+	asrt.NoErr(err)
+	defer server.Stop()
+	client := testclient.New(pub, sub, nrpc.WithLogger(logger))
+
+	// requests sent before the consumer group of the server joined are not received
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		_, err := client.Unary(ctx, &testproto.UnaryReq{Msg: "Hello via NRPC"})
+		cancel()
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server not reachable: %v", err)
+		}
+	}
+
+	t.Run("unary", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 5*time.Second)
+		defer cancel()
+
+		resp, err := client.Unary(ctx, &testproto.UnaryReq{
+			Msg: "Hello via NRPC",
+		})
+		asrt.NoErr(err)
+		asrt.Equal(resp.Msg, "Hello back!")
+	})
+	t.Run("concurrent unary", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 10*time.Second)
+		defer cancel()
+
+		// the replies are matched to their requests by correlation ID
+		var wg sync.WaitGroup
+		errs := make(chan error, 10)
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				msg := fmt.Sprintf("Hello via NRPC %d", i)
+				resp, err := client.Unary(ctx, &testproto.UnaryReq{Msg: msg})
+				if err == nil && resp.Msg != "Hello back!" {
+					err = fmt.Errorf("unexpected response %q", resp.Msg)
+				}
+				errs <- err
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			asrt.NoErr(err)
+		}
+	})
+	t.Run("bidi stream", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 10*time.Second)
+		defer cancel()
+
+		stream, err := client.BiDiStream(ctx)
+		asrt.NoErr(err)
+
+		for i := 0; i < 5; i++ {
+			r := stream.Send(&testproto.BiDiStreamReq{
+				Msg: fmt.Sprintf("Hello via NRPC %d", i+1),
+			})
+			asrt.NoErr(r)
+
+			resp, r := stream.Recv()
+			asrt.NoErr(r)
+			asrt.Equal(resp.Msg, fmt.Sprintf("Hello back! %d", i+1))
+		}
+		asrt.NoErr(stream.CloseSend())
+
+		_, err = stream.Recv()
+		asrt.True(errors.Is(err, io.EOF))
+	})
+}
+
+// connectMQTT opens an MQTT 5 session with the broker at the address.
+func connectMQTT(t *testing.T, addr string) *mqtt.Conn {
+	t.Helper()
+	netConn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := mqtt.Connect(context.Background(), netConn, &paho.Connect{CleanStart: true, KeepAlive: 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+func TestMQTT(t *testing.T) {
+	asrt := is.New(t)
+	ctxMain := context.Background()
+	logger := nrpc.StandardLogger{}
+
+	addr, shutdown, err := testproto.NewTestMQTTBroker()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	conn := connectMQTT(t, addr)
+	pub := mqtt.Publisher(conn)
+	sub := mqtt.Subscriber(conn)
+
+	server, _, err := testserver.New(pub, sub, nrpc.WithLogger(logger))
+	asrt.NoErr(err)
+	defer server.Stop()
+	client := testclient.New(pub, sub, nrpc.WithLogger(logger))
+
+	t.Run("unary", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		resp, err := client.Unary(ctx, &testproto.UnaryReq{
+			Msg: "Hello via NRPC",
+		})
+		asrt.NoErr(err)
+		asrt.Equal(resp.Msg, "Hello back!")
+	})
+	t.Run("response topic", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		// the replies go to the inbox of the requesting session and are matched by their correlation data
+		responder := connectMQTT(t, addr)
+		s, err := mqtt.Subscriber(responder).SubscribeAsync("test.echo", "", func(_ context.Context, msg pubsub.Replier) {
+			_ = msg.Reply(pubsub.Reply{Data: append([]byte("echo "), msg.Data()...)})
+		})
+		asrt.NoErr(err)
+		defer s.Unsubscribe()
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 10)
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				msg := fmt.Sprintf("msg %d", i)
+				resp, err := pub.Request(ctx, pubsub.Message{Subject: "test.echo", Data: []byte(msg)})
+				if err == nil && string(resp.Data) != "echo "+msg {
+					err = fmt.Errorf("unexpected reply %q to %q", resp.Data, msg)
+				}
+				errs <- err
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			asrt.NoErr(err)
+		}
+	})
+	t.Run("bidi stream", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		stream, err := client.BiDiStream(ctx)
+		asrt.NoErr(err)
+
+		for i := 0; i < 5; i++ {
+			r := stream.Send(&testproto.BiDiStreamReq{
+				Msg: fmt.Sprintf("Hello via NRPC %d", i+1),
+			})
+			asrt.NoErr(r)
+
+			resp, r := stream.Recv()
+			asrt.NoErr(r)
+			asrt.Equal(resp.Msg, fmt.Sprintf("Hello back! %d", i+1))
+		}
+		asrt.NoErr(stream.CloseSend())
+
+		_, err = stream.Recv()
+		asrt.True(errors.Is(err, io.EOF))
+	})
+	t.Run("queue", func(t *testing.T) {
+		asrt := asrt.New(t)
+
+		// the members of a queue share a subscription: each message is handled by one of them
+		const count = 20
+		received := make(chan string, 2*count)
+		perMember := make([]int32, 2)
+		for i := range perMember {
+			i := i
+			s, err := mqtt.Subscriber(connectMQTT(t, addr)).Subscribe("test.queue", "workers",
+				func(_ context.Context, msg pubsub.Replier) {
+					atomic.AddInt32(&perMember[i], 1)
+					received <- string(msg.Data())
+				})
+			asrt.NoErr(err)
+			defer s.Unsubscribe()
+		}
+
+		for i := 0; i < count; i++ {
+			asrt.NoErr(pub.Publish(pubsub.Message{Subject: "test.queue", Data: []byte(strconv.Itoa(i))}))
+		}
+		seen := map[string]bool{}
+		for len(seen) < count {
+			select {
+			case msg := <-received:
+				asrt.True(!seen[msg]) // handled once
+				seen[msg] = true
+			case <-time.After(2 * time.Second):
+				t.Fatalf("received %d of %d messages", len(seen), count)
+			}
+		}
+		select {
+		case msg := <-received:
+			t.Fatalf("message %s handled twice", msg)
+		case <-time.After(50 * time.Millisecond):
+		}
+		asrt.True(atomic.LoadInt32(&perMember[0]) > 0 && atomic.LoadInt32(&perMember[1]) > 0)
+	})
+	t.Run("no responders", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		// the broker reports the missing subscribers in the PUBACK
+		_, err := pub.Request(ctx, pubsub.Message{Subject: "test.nobody", Data: []byte("hello")})
+		asrt.True(errors.Is(err, pubsub.ErrNoResponders))
+		asrt.NoErr(ctx.Err()) // failed without waiting for the deadline
+
+		_, err = testproto.NewEchoNRPCClient(nrpc.NewClient(pub, sub)).Echo(ctx, &testproto.UnaryReq{Msg: "hello"})
+		asrt.Equal(status.Code(err), codes.Unavailable)
+	})
+	t.Run("wildcards", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		// wildcard subjects fail instead of silently receiving nothing
+		_, err := sub.Subscribe("nrpc.>", "", func(context.Context, pubsub.Replier) {})
+		asrt.True(errors.Is(err, pubsub.ErrWildcards))
+
+		server := nrpc.NewServer(pub, sub, nrpc.WithMultiTenancy())
+		testproto.RegisterEchoServer(server, tenantServer{})
+		err = server.Run(ctx)
+		asrt.True(errors.Is(err, pubsub.ErrWildcards))
+	})
+}
+
+func TestManagedConn(t *testing.T) {
+	asrt := is.New(t)
+	ctxMain := context.Background()
+	logger := nrpc.StandardLogger{}
+
+	srv, err := testproto.NewTestNATSServer()
+	asrt.NoErr(err)
+	defer srv.Shutdown()
+
+	states := make(chan nats.State, 10)
+	managed, err := nats.Dial(srv.URL(),
+		nats.WithPoolSize(2),
+		nats.WithRedialWait(50*time.Millisecond),
+		nats.WithStateHandler(func(_ int, state nats.State) {
+			states <- state
+		}),
+		// let the NATS client give up right away, so the connections are replaced
+		nats.WithNATSOptions(natsgo.MaxReconnects(0)),
+	)
+	asrt.NoErr(err)
+	defer managed.Close()
+	asrt.Equal(<-states, nats.StateConnected)
+	asrt.Equal(<-states, nats.StateConnected)
+
+	pub := managed.Publisher()
+	sub := managed.Subscriber()
+
+	_, _, err = testserver.New(pub, sub, nrpc.WithLogger(logger))
+	asrt.NoErr(err)
+	client := testclient.New(pub, sub, nrpc.WithLogger(logger))
+
+	unary := func(asrt *is.I) {
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		resp, err := client.Unary(ctx, &testproto.UnaryReq{
+			Msg: "Hello via NRPC",
+		})
+		asrt.NoErr(err)
+		asrt.Equal(resp.Msg, "Hello back!")
+	}
+
+	t.Run("unary", func(t *testing.T) {
+		unary(asrt.New(t))
+	})
+	t.Run("reconnect", func(t *testing.T) {
+		asrt := asrt.New(t)
+
+		srv.Shutdown()
+		asrt.Equal(<-states, nats.StateDisconnected)
+		asrt.Equal(<-states, nats.StateDisconnected)
+
+		asrt.NoErr(srv.Restart())
+		asrt.Equal(<-states, nats.StateReconnected)
+		asrt.Equal(<-states, nats.StateReconnected)
+
+		// the subscriptions of the server were renewed on the new connections
+		unary(asrt)
+	})
+}
+
+func TestWaitForReady(t *testing.T) {
+	asrt := is.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	srv, err := testproto.NewTestNATSServer()
+	asrt.NoErr(err)
+	defer srv.Shutdown()
+
+	// client and server share the connection, so the subscriptions of the server are renewed
+	// before the client is ready again
+	conn, err := natsgo.Connect(srv.URL(), natsgo.ReconnectWait(50*time.Millisecond), natsgo.MaxReconnects(-1))
+	asrt.NoErr(err)
+	defer conn.Close()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	server := nrpc.NewServer(pub, sub)
+	testproto.RegisterEchoNRPCServer(server, echoServer{})
+	asrt.NoErr(server.Run(ctx))
+	defer server.Stop()
+
+	cc := nrpc.NewClient(pub, sub, nrpc.WithWaitForReady(true))
+	client := testproto.NewEchoNRPCClient(cc)
+	asrt.Equal(cc.GetState(), connectivity.Ready)
+
+	srv.Shutdown()
+	asrt.True(cc.WaitForStateChange(ctx, connectivity.Ready))
+	asrt.Equal(cc.GetState(), connectivity.TransientFailure)
+
+	// calls failing fast do not wait for the broker
+	_, err = client.Echo(ctx, &testproto.UnaryReq{Msg: "Hello via NRPC"}, grpc.WaitForReady(false))
+	asrt.Equal(status.Code(err), codes.Unavailable)
+	_, err = client.Stream(ctx, grpc.WaitForReady(false))
+	asrt.Equal(status.Code(err), codes.Unavailable)
+
+	// calls waiting for ready are sent once the broker is back
+	chResp := make(chan error, 1)
+	go func() {
+		resp, r := client.Echo(ctx, &testproto.UnaryReq{Msg: "Hello via NRPC"})
+		if r == nil && resp.Msg != "Hello via NRPC" {
+			r = fmt.Errorf("unexpected response %q", resp.Msg)
+		}
+		chResp <- r
+	}()
+	time.Sleep(100 * time.Millisecond)
+	asrt.NoErr(srv.Restart())
+	asrt.NoErr(<-chResp)
+	asrt.Equal(cc.GetState(), connectivity.Ready)
+
+	// calls time out while waiting
+	srv.Shutdown()
+	asrt.True(cc.WaitForStateChange(ctx, connectivity.Ready))
+	ctxTimeout, cancelTimeout := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancelTimeout()
+	_, err = client.Echo(ctxTimeout, &testproto.UnaryReq{Msg: "Hello via NRPC"})
+	asrt.Equal(status.Code(err), codes.DeadlineExceeded)
+}
+
+func TestKeepalive(t *testing.T) {
+	asrt := is.New(t)
+	ctxMain := context.Background()
+	logger := nrpc.StandardLogger{}
+
+	srv, err := testproto.NewTestNATSServer()
+	asrt.NoErr(err)
+	defer srv.Shutdown()
+
+	serverConn, err := natsgo.Connect(srv.URL())
+	asrt.NoErr(err)
+	defer serverConn.Close()
+	clientConn, err := natsgo.Connect(srv.URL())
+	asrt.NoErr(err)
+	defer clientConn.Close()
+
+	_, _, err = testserver.New(nats.Publisher(serverConn), nats.Subscriber(serverConn), nrpc.WithLogger(logger))
+	asrt.NoErr(err)
+	client := testclient.New(nats.Publisher(clientConn), nats.Subscriber(clientConn), nrpc.WithLogger(logger),
+		nrpc.WithKeepalive(50*time.Millisecond, 100*time.Millisecond))
+
+	t.Run("idle stream", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		stream, err := client.BiDiStream(ctx)
+		asrt.NoErr(err)
+
+		for i := 0; i < 2; i++ {
+			asrt.NoErr(stream.Send(&testproto.BiDiStreamReq{
+				Msg: fmt.Sprintf("Hello via NRPC %d", i+1),
+			}))
+			resp, r := stream.Recv()
+			asrt.NoErr(r)
+			asrt.Equal(resp.Msg, fmt.Sprintf("Hello back! %d", i+1))
+
+			// the pings keep the stream alive while no messages are sent
+			time.Sleep(300 * time.Millisecond)
+		}
+		asrt.NoErr(stream.CloseSend())
+
+		_, err = stream.Recv()
+		asrt.True(errors.Is(err, io.EOF))
+	})
+	t.Run("dead server", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		stream, err := client.BiDiStream(ctx)
+		asrt.NoErr(err)
+		asrt.NoErr(stream.Send(&testproto.BiDiStreamReq{
+			Msg: "Hello via NRPC 1",
+		}))
+		_, err = stream.Recv()
+		asrt.NoErr(err)
+
+		// the server stops sending pings
+		serverConn.Close()
+
+		_, err = stream.Recv()
+		asrt.Equal(status.Code(err), codes.Unavailable)
+		asrt.True(ctx.Err() == nil)
+	})
+}
+
+func TestStreamResumption(t *testing.T) {
+	asrt := is.New(t)
+	ctxMain := context.Background()
+	logger := nrpc.StandardLogger{}
+
+	t.Run("server stream", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		conn, shutdown, err := testproto.NewTestConn()
+		asrt.NoErr(err)
+		defer shutdown()
+
+		pub := nats.Publisher(conn)
+		sub := nats.Subscriber(conn)
+
+		// the server loses the third frame
+		_, _, err = testserver.New(&lossyPublisher{Publisher: pub, prefix: "nrpc.resp.", drop: 3}, sub, nrpc.WithLogger(logger))
+		asrt.NoErr(err)
+		client := testclient.New(pub, sub, nrpc.WithLogger(logger), nrpc.WithStreamResumption(16))
+
+		stream, err := client.ServerStream(ctx, &testproto.ServerStreamReq{
+			Msg: "Hello via NRPC",
+		})
+		asrt.NoErr(err)
+
+		var i int
+		for {
+			msg, r := stream.Recv()
+			if errors.Is(r, io.EOF) {
+				break
+			}
+			asrt.NoErr(r)
+
+			i++
+			asrt.Equal(msg.Msg, fmt.Sprintf("Hello back! %d", i))
+		}
+		asrt.Equal(i, 5)
+	})
+	t.Run("client stream", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		conn, shutdown, err := testproto.NewTestConn()
+		asrt.NoErr(err)
+		defer shutdown()
+
+		pub := nats.Publisher(conn)
+		sub := nats.Subscriber(conn)
+
+		_, _, err = testserver.New(pub, sub, nrpc.WithLogger(logger))
+		asrt.NoErr(err)
+		// the client loses the second frame
+		client := testclient.New(&lossyPublisher{Publisher: pub, prefix: "nrpc.req.", drop: 2}, sub,
+			nrpc.WithLogger(logger), nrpc.WithStreamResumption(16))
+
+		stream, err := client.ClientStream(ctx)
+		asrt.NoErr(err)
+
+		for i := 0; i < 5; i++ {
+			r := stream.Send(&testproto.ClientStreamReq{
+				Msg: fmt.Sprintf("Hello via NRPC %d", i+1),
+			})
+			asrt.NoErr(r)
+		}
+
+		resp, err := stream.CloseAndRecv()
+		asrt.NoErr(err)
+		asrt.Equal(resp.Msg, "Hello back!")
+	})
+}
+
+// lossyPublisher drops the n-th message published on a subject with the given prefix.
+type lossyPublisher struct {
+	pubsub.Publisher
+	prefix string
+	drop   int
+
+	m     sync.Mutex
+	count int
+}
+
+func (p *lossyPublisher) Publish(msg pubsub.Message) error {
+	if !strings.HasPrefix(msg.Subject, p.prefix) {
+		return p.Publisher.Publish(msg)
+	}
+
+	p.m.Lock()
+	p.count++
+	drop := p.count == p.drop
+	p.m.Unlock()
+
+	if drop {
+		return nil
+	}
+	return p.Publisher.Publish(msg)
+}
+
+func TestStreamOrdering(t *testing.T) {
+	asrt := is.New(t)
+	ctxMain := context.Background()
+	logger := nrpc.StandardLogger{}
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	recv := func(stream testproto.Test_ServerStreamClient) (int, error) {
+		var i int
+		for {
+			msg, r := stream.Recv()
+			if errors.Is(r, io.EOF) {
+				return i, nil
+			}
+			if r != nil {
+				return i, r
+			}
+			i++
+			if msg.Msg != fmt.Sprintf("Hello back! %d", i) {
+				return i, fmt.Errorf("received %q out of order", msg.Msg)
+			}
+		}
+	}
+
+	t.Run("reorder", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		// the server publishes the second frame after the third
+		server, _, err := testserver.New(&reorderingPublisher{Publisher: pub, prefix: "nrpc.resp.", delay: 2}, sub, nrpc.WithLogger(logger))
+		asrt.NoErr(err)
+		defer server.Stop()
+		client := testclient.New(pub, sub, nrpc.WithLogger(logger),
+			nrpc.WithStreamOrdering(nrpc.OrderingPolicy{Mode: nrpc.OrderingReorder, Window: 2}))
+
+		stream, err := client.ServerStream(ctx, &testproto.ServerStreamReq{Msg: "Hello via NRPC"})
+		asrt.NoErr(err)
+		i, err := recv(stream)
+		asrt.NoErr(err)
+		asrt.Equal(i, 5)
+	})
+	t.Run("abort", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		server, _, err := testserver.New(&reorderingPublisher{Publisher: pub, prefix: "nrpc.resp.", delay: 2}, sub, nrpc.WithLogger(logger))
+		asrt.NoErr(err)
+		defer server.Stop()
+		client := testclient.New(pub, sub, nrpc.WithLogger(logger))
+
+		stream, err := client.ServerStream(ctx, &testproto.ServerStreamReq{Msg: "Hello via NRPC"},
+			nrpc.StreamOrdering(nrpc.OrderingPolicy{Mode: nrpc.OrderingAbort}))
+		if err == nil {
+			_, err = recv(stream)
+		}
+		asrt.Equal(status.Code(err), codes.DataLoss)
+	})
+	t.Run("gap", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		// the server loses the third frame, the following frames exceed the reorder window
+		server, _, err := testserver.New(&lossyPublisher{Publisher: pub, prefix: "nrpc.resp.", drop: 3}, sub, nrpc.WithLogger(logger))
+		asrt.NoErr(err)
+		defer server.Stop()
+		client := testclient.New(pub, sub, nrpc.WithLogger(logger),
+			nrpc.WithStreamOrdering(nrpc.OrderingPolicy{Mode: nrpc.OrderingReorder, Window: 1}))
+
+		stream, err := client.ServerStream(ctx, &testproto.ServerStreamReq{Msg: "Hello via NRPC"})
+		asrt.NoErr(err)
+		_, err = recv(stream)
+		asrt.Equal(status.Code(err), codes.DataLoss)
+	})
+	t.Run("client stream", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		server, _, err := testserver.New(pub, sub, nrpc.WithLogger(logger))
+		asrt.NoErr(err)
+		defer server.Stop()
+		// the client publishes the second frame after the third, the server reorders them
+		client := testclient.New(&reorderingPublisher{Publisher: pub, prefix: "nrpc.req.", delay: 2}, sub,
+			nrpc.WithLogger(logger), nrpc.WithStreamOrdering(nrpc.OrderingPolicy{Mode: nrpc.OrderingReorder}))
+
+		stream, err := client.ClientStream(ctx)
+		asrt.NoErr(err)
+		for i := 0; i < 5; i++ {
+			asrt.NoErr(stream.Send(&testproto.ClientStreamReq{Msg: fmt.Sprintf("Hello via NRPC %d", i+1)}))
+		}
+		resp, err := stream.CloseAndRecv()
+		asrt.NoErr(err)
+		asrt.Equal(resp.Msg, "Hello back!")
+	})
+}
+
+// reorderingPublisher publishes the n-th message published on a subject with the given prefix after the following one.
+type reorderingPublisher struct {
+	pubsub.Publisher
+	prefix string
+	delay  int
+
+	m       sync.Mutex
+	count   int
+	delayed *pubsub.Message
+}
+
+func (p *reorderingPublisher) Publish(msg pubsub.Message) error {
+	if !strings.HasPrefix(msg.Subject, p.prefix) {
+		return p.Publisher.Publish(msg)
+	}
+
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	p.count++
+	if p.count == p.delay {
+		p.delayed = &msg
+		return nil
+	}
+	if r := p.Publisher.Publish(msg); r != nil {
+		return r
+	}
+	if p.delayed == nil {
+		return nil
+	}
+	delayed := *p.delayed
+	p.delayed = nil
+	return p.Publisher.Publish(delayed)
+}
+
+func TestDuplicateFrames(t *testing.T) {
+	asrt := is.New(t)
+	ctxMain := context.Background()
+	logger := nrpc.StandardLogger{}
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	t.Run("server stream", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		// the server publishes every frame twice
+		server, _, err := testserver.New(&duplicatingPublisher{Publisher: pub, prefix: "nrpc.resp."}, sub, nrpc.WithLogger(logger))
+		asrt.NoErr(err)
+		defer server.Stop()
+		clientMetrics := metrics.NewClientMetrics()
+		client := testclient.New(pub, sub, append(clientMetrics.Options(), nrpc.WithLogger(logger))...)
+
+		stream, err := client.ServerStream(ctx, &testproto.ServerStreamReq{Msg: "Hello via NRPC"})
+		asrt.NoErr(err)
+		var i int
+		for {
+			msg, r := stream.Recv()
+			if errors.Is(r, io.EOF) {
+				break
+			}
+			asrt.NoErr(r)
+			i++
+			asrt.Equal(msg.Msg, fmt.Sprintf("Hello back! %d", i))
+		}
+		asrt.Equal(i, 5)
+
+		// the duplicates of the messages are received before the end of the stream, the duplicate of the
+		// frame ending it may arrive after the stream was torn down and be dropped uncounted
+		duplicates := func(n int) error {
+			expected := fmt.Sprintf(`
+# HELP nrpc_client_duplicate_frames_total Total number of stream frames dropped because they were received before.
+# TYPE nrpc_client_duplicate_frames_total counter
+nrpc_client_duplicate_frames_total{method="/testproto.Test/ServerStream"} %d
+`, n)
+			return testutil.CollectAndCompare(clientMetrics, strings.NewReader(expected), "nrpc_client_duplicate_frames_total")
+		}
+		if r := duplicates(i); r != nil {
+			asrt.NoErr(duplicates(i + 1))
+		}
+	})
+	t.Run("client stream", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		server, _, err := testserver.New(pub, sub, nrpc.WithLogger(logger))
+		asrt.NoErr(err)
+		defer server.Stop()
+		// the client publishes every frame twice, the server receives every message once
+		client := testclient.New(&duplicatingPublisher{Publisher: pub, prefix: "nrpc.req."}, sub, nrpc.WithLogger(logger))
+
+		stream, err := client.ClientStream(ctx)
+		asrt.NoErr(err)
+		for i := 0; i < 5; i++ {
+			asrt.NoErr(stream.Send(&testproto.ClientStreamReq{Msg: fmt.Sprintf("Hello via NRPC %d", i+1)}))
+		}
+		resp, err := stream.CloseAndRecv()
+		asrt.NoErr(err)
+		asrt.Equal(resp.Msg, "Hello back!")
+	})
+}
+
+// duplicatingPublisher publishes every message published on a subject with the given prefix twice. The
+// duplicate carries no message ID, so it can only be detected by its sequence number.
+type duplicatingPublisher struct {
+	pubsub.Publisher
+	prefix string
+}
+
+func (p *duplicatingPublisher) Publish(msg pubsub.Message) error {
+	if !strings.HasPrefix(msg.Subject, p.prefix) {
+		return p.Publisher.Publish(msg)
+	}
+
+	if r := p.Publisher.Publish(msg); r != nil {
+		return r
+	}
+	msg.ID = ""
+	return p.Publisher.Publish(msg)
+}
+
+func TestSubscriptionLoss(t *testing.T) {
+	asrt := is.New(t)
+	ctxMain := context.Background()
+
+	broker := memory.NewBroker()
+	pub := memory.Publisher(broker)
+	sub := memory.Subscriber(broker)
+
+	server := nrpc.NewServer(pub, sub)
+	testproto.RegisterEchoNRPCServer(server, echoServer{})
+	asrt.NoErr(server.Run(ctxMain))
+	defer server.Stop()
+
+	echo := func(asrt *is.I, stream testproto.Echo_StreamNRPCClient, msg string) {
+		asrt.NoErr(stream.Send(&testproto.BiDiStreamReq{Msg: msg}))
+		res, err := stream.Recv()
+		asrt.NoErr(err)
+		asrt.Equal(res.Msg, msg)
+	}
+
+	t.Run("abort", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		client := testproto.NewEchoNRPCClient(nrpc.NewClient(pub, sub))
+		stream, err := client.Stream(ctx)
+		asrt.NoErr(err)
+		echo(asrt, stream, "before")
+
+		// the stream cannot recover the frames sent while it was not subscribed
+		asrt.Equal(broker.Drop("nrpc.resp.>"), 1)
+		_, err = stream.Recv()
+		asrt.Equal(status.Code(err), codes.Unavailable)
+	})
+	t.Run("resume", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		client := testproto.NewEchoNRPCClient(nrpc.NewClient(pub, sub, nrpc.WithStreamResumption(16)))
+		stream, err := client.Stream(ctx)
+		asrt.NoErr(err)
+		echo(asrt, stream, "before")
+
+		// the stream subscribes again and asks the server for the frames sent in the meantime
+		asrt.Equal(broker.Drop("nrpc.resp.>"), 1)
+		echo(asrt, stream, "after")
+		asrt.NoErr(stream.CloseSend())
+		_, err = stream.Recv()
+		asrt.True(errors.Is(err, io.EOF))
+	})
+}
+
+func TestMsgSize(t *testing.T) {
+	asrt := is.New(t)
+	ctxMain := context.Background()
+	logger := nrpc.StandardLogger{}
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	_, _, err = testserver.New(pub, sub, nrpc.WithLogger(logger), nrpc.MaxRecvMsgSize(64))
+	asrt.NoErr(err)
+	client := testclient.New(pub, sub, nrpc.WithLogger(logger))
+
+	t.Run("server receive", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		_, err := client.Unary(ctx, &testproto.UnaryReq{
+			Msg: strings.Repeat("x", 128),
+		})
+		asrt.Equal(status.Code(err), codes.ResourceExhausted)
+	})
+	t.Run("client send", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		_, err := client.Unary(ctx, &testproto.UnaryReq{
+			Msg: "Hello via NRPC",
+		}, grpc.MaxCallSendMsgSize(8))
+		asrt.Equal(status.Code(err), codes.ResourceExhausted)
+	})
+	t.Run("client receive", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		_, err := client.Unary(ctx, &testproto.UnaryReq{
+			Msg: "Hello via NRPC",
+		}, grpc.MaxCallRecvMsgSize(8))
+		asrt.Equal(status.Code(err), codes.ResourceExhausted)
+
+		stream, err := client.ServerStream(ctx, &testproto.ServerStreamReq{
+			Msg: "Hello via NRPC",
+		}, grpc.MaxCallRecvMsgSize(8))
+		asrt.NoErr(err)
+
+		_, err = stream.Recv()
+		asrt.Equal(status.Code(err), codes.ResourceExhausted)
+	})
+}
+
+func TestResponsePaging(t *testing.T) {
+	asrt := is.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	server := nrpc.NewServer(pub, sub, nrpc.WithResponsePaging(64))
+	testproto.RegisterEchoNRPCServer(server, echoServer{})
+	asrt.NoErr(server.Run(ctx))
+	defer server.Stop()
+
+	msg := strings.Repeat("Hello via NRPC ", 50)
+	for _, tc := range []struct {
+		name    string
+		version uint32
+		pages   bool
+	}{
+		{name: "paged", version: nrpc.WireVersion, pages: true},
+		{name: "old client", version: 3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			asrt := asrt.New(t)
+
+			var m sync.Mutex
+			var requested int
+			tap := nrpc.WireTapFunc(func(_ context.Context, frame nrpc.Frame) {
+				if frame.Direction == nrpc.FrameSent && strings.HasPrefix(frame.Subject, "nrpc.page.") {
+					m.Lock()
+					requested++
+					m.Unlock()
+				}
+			})
+			client := testproto.NewEchoNRPCClient(nrpc.NewClient(pub, sub, nrpc.WithWireTap(tap), nrpc.WithWireVersion(tc.version)))
+
+			resp, err := client.Echo(ctx, &testproto.UnaryReq{Msg: msg})
+			asrt.NoErr(err)
+			asrt.Equal(resp.Msg, msg)
+
+			m.Lock()
+			defer m.Unlock()
+			asrt.Equal(requested > 0, tc.pages)
+		})
+	}
+
+	t.Run("page requests", func(t *testing.T) {
+		asrt := asrt.New(t)
+
+		otherConn, err := natsgo.Connect(conn.ConnectedUrl())
+		asrt.NoErr(err)
+		defer otherConn.Close()
+		other := nats.Publisher(otherConn)
+
+		// fetch requests the last page of the response on the connection of another client
+		fetch := func(subj string, req *nrpc.Request) nrpc.MessageType {
+			data, err := proto.Marshal(req)
+			asrt.NoErr(err)
+			res, err := other.Request(ctx, pubsub.Message{Subject: subj, Data: data})
+			asrt.NoErr(err)
+			var msg nrpc.Message
+			asrt.NoErr(proto.Unmarshal(res.Data, &msg))
+			return msg.Type
+		}
+
+		// another client learning the first page fetches the following ones before the caller does:
+		// only the key grants access, and pages can be fetched repeatedly until they expire
+		var attempted bool
+		tap := nrpc.WireTapFunc(func(_ context.Context, frame nrpc.Frame) {
+			if frame.Direction != nrpc.FrameReceived || strings.HasPrefix(frame.Subject, "nrpc.page.") || attempted {
+				return
+			}
+			attempted = true
+			var msg nrpc.Message
+			asrt.NoErr(proto.Unmarshal(frame.Data, &msg))
+			var first nrpc.Response
+			asrt.NoErr(proto.Unmarshal(msg.Data, &first))
+			asrt.True(first.PageKey != "")
+
+			last := &nrpc.Chunk{Id: first.Chunk.Id, Index: first.Chunk.Total - 1}
+			asrt.Equal(fetch(first.PageSubject, &nrpc.Request{Chunk: last}), nrpc.MessageType_Error)
+			asrt.Equal(fetch(first.PageSubject, &nrpc.Request{Chunk: last, PageKey: "wrong"}), nrpc.MessageType_Error)
+			asrt.Equal(fetch(first.PageSubject, &nrpc.Request{Chunk: last, PageKey: first.PageKey}), nrpc.MessageType_Data)
+			asrt.Equal(fetch(first.PageSubject, &nrpc.Request{Chunk: last, PageKey: first.PageKey}), nrpc.MessageType_Data)
+		})
+		client := testproto.NewEchoNRPCClient(nrpc.NewClient(pub, sub, nrpc.WithWireTap(tap)))
+
+		// the pages are still served to the caller
+		resp, err := client.Echo(ctx, &testproto.UnaryReq{Msg: msg})
+		asrt.NoErr(err)
+		asrt.Equal(resp.Msg, msg)
+		asrt.True(attempted)
+	})
+
+	t.Run("limit", func(t *testing.T) {
+		asrt := asrt.New(t)
+
+		// the server keeps the pages of a single response at most
+		limited := nrpc.NewServer(pub, sub, nrpc.WithResponsePaging(64), nrpc.WithResponsePagingLimit(2*len(msg)),
+			nrpc.WithSubjectPrefix("limited"))
+		testproto.RegisterEchoNRPCServer(limited, echoServer{})
+		asrt.NoErr(limited.Run(ctx))
+		defer limited.Stop()
+		client := testproto.NewEchoNRPCClient(nrpc.NewClient(pub, sub, nrpc.WithSubjectPrefix("limited")))
+
+		resp, err := client.Echo(ctx, &testproto.UnaryReq{Msg: msg})
+		asrt.NoErr(err)
+		asrt.Equal(resp.Msg, msg)
+
+		_, err = client.Echo(ctx, &testproto.UnaryReq{Msg: msg})
+		asrt.Equal(status.Code(err), codes.ResourceExhausted)
+
+		// small responses are not paged
+		resp, err = client.Echo(ctx, &testproto.UnaryReq{Msg: "Hello"})
+		asrt.NoErr(err)
+		asrt.Equal(resp.Msg, "Hello")
+	})
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	asrt := is.New(t)
+	ctxMain := context.Background()
+	logger := nrpc.StandardLogger{}
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	// the interceptor blocks the calls until they are released
+	started := make(chan struct{}, 10)
+	chRelease := make(chan struct{})
+	blocking := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		started <- struct{}{}
+		<-chRelease
+		return handler(ctx, req)
+	}
+
+	_, _, err = testserver.New(pub, sub, nrpc.WithLogger(logger), nrpc.ChainUnaryInterceptor(blocking),
+		nrpc.WithConcurrencyLimit(nrpc.ConcurrencyLimit{MaxConcurrent: 1, MaxQueued: 1}, "/testproto.Test/Unary"))
+	asrt.NoErr(err)
+	client := testclient.New(pub, sub, nrpc.WithLogger(logger))
+
+	ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+	defer cancel()
+
+	unary := func() error {
+		_, err := client.Unary(ctx, &testproto.UnaryReq{
+			Msg: "Hello via NRPC",
+		})
+		return err
+	}
+
+	// the first call is handled, the second one queued
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- unary()
+		}()
+		if i == 0 {
+			<-started
+		}
+	}
+	// wait for the second call to be queued
+	time.Sleep(100 * time.Millisecond)
+
+	// the third call exceeds the queue
+	asrt.Equal(status.Code(unary()), codes.ResourceExhausted)
+
+	close(chRelease)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		asrt.NoErr(err)
+	}
+}
+
+// panickingStream panics when sending the second message.
+type panickingStream struct {
+	grpc.ServerStream
+	sent int
+}
+
+func (s *panickingStream) SendMsg(m interface{}) error {
+	s.sent++
+	if s.sent == 2 {
+		panic("boom")
+	}
+	return s.ServerStream.SendMsg(m)
+}
+
+func TestPanicRecovery(t *testing.T) {
+	asrt := is.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	unaryPanic := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		panic("boom")
+	}
+	streamPanic := func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &panickingStream{ServerStream: ss})
+	}
+
+	serverLog := &recordingLogger{}
+	_, _, err = testserver.New(pub, sub, nrpc.WithLogger(serverLog),
+		nrpc.ChainUnaryInterceptor(unaryPanic), nrpc.ChainStreamInterceptor(streamPanic))
+	asrt.NoErr(err)
+	client := testclient.New(pub, sub, nrpc.WithLogger(nrpc.StandardLogger{}))
+
+	_, err = client.Unary(ctx, &testproto.UnaryReq{Msg: "Hello via NRPC"})
+	asrt.Equal(status.Code(err), codes.Internal)
+	asrt.True(serverLog.contains("panic recovered in handler method /testproto.Test/Unary panic boom stack goroutine"))
+
+	// the panic mid-stream closes the stream after the first message
+	stream, err := client.ServerStream(ctx, &testproto.ServerStreamReq{Msg: "Hello via NRPC"})
+	asrt.NoErr(err)
+	_, err = stream.Recv()
+	asrt.NoErr(err)
+	_, err = stream.Recv()
+	asrt.Equal(status.Code(err), codes.Internal)
+	asrt.NoErr(ctx.Err())
+}
+
+// tokenCreds implements credentials.PerRPCCredentials attaching a static token.
+type tokenCreds string
+
+func (c tokenCreds) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(c)}, nil
+}
+
+func (c tokenCreds) RequireTransportSecurity() bool { return true }
+
+func TestClientConnInterface(t *testing.T) {
+	asrt := is.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	_, _, err = testserver.New(pub, sub, nrpc.WithLogger(nrpc.StandardLogger{}))
+	asrt.NoErr(err)
+
+	// the stock generated client works on top of the nrpc client
+	client := testproto.NewTestClient(nrpc.NewClient(pub, sub, nrpc.WithLogger(nrpc.StandardLogger{})))
+
+	var (
+		header, trailer metadata.MD
+		p               peer.Peer
+	)
+	_, err = client.Unary(ctx, &testproto.UnaryReq{Msg: "Hello via NRPC"},
+		grpc.PerRPCCredentials(tokenCreds("secret")), grpc.Header(&header), grpc.Trailer(&trailer), grpc.Peer(&p))
+	asrt.NoErr(err)
+	// the server echoes the received metadata in the header
+	asrt.Equal(header.Get("authorization"), []string{"Bearer secret"})
+	asrt.Equal(trailer.Get("traily"), []string{"t-value"})
+	asrt.Equal(p.Addr.Network(), "nrpc")
+	asrt.Equal(p.Addr.String(), "nrpc.testproto.Test.Unary")
+
+	header, trailer = nil, nil
+	stream, err := client.ServerStream(ctx, &testproto.ServerStreamReq{Msg: "Hello via NRPC"},
+		grpc.PerRPCCredentials(tokenCreds("secret")), grpc.Header(&header), grpc.Trailer(&trailer))
+	asrt.NoErr(err)
+	for {
+		if _, r := stream.Recv(); r != nil {
+			asrt.True(errors.Is(r, io.EOF))
+			break
+		}
+	}
+	asrt.Equal(header.Get("authorization"), []string{"Bearer secret"})
+	asrt.Equal(trailer.Get("traily"), []string{"t-value"})
+}
+
+func TestRegisterService(t *testing.T) {
+	asrt := is.New(t)
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	panics := func(f func()) (panicked bool) {
+		defer func() {
+			panicked = recover() != nil
+		}()
+		f()
+		return false
+	}
+
+	server := nrpc.NewServer(pub, sub, nrpc.WithLogger(nrpc.StandardLogger{}))
+	// the implementation must satisfy the handler type of the service
+	asrt.True(panics(func() { server.RegisterService(&testproto.Test_ServiceDesc, struct{}{}) }))
+
+	testproto.RegisterTestServer(server, &testserver.Server{})
+	asrt.True(panics(func() { testproto.RegisterTestServer(server, &testserver.Server{}) }))
+
+	asrt.NoErr(server.Run(context.Background()))
+	defer server.Stop()
+	asrt.True(panics(func() {
+		rpbalpha.RegisterServerReflectionServer(server, rpbalpha.UnimplementedServerReflectionServer{})
+	}))
+}
+
+// echoServer implements the testproto.EchoNRPCServer interface generated by protoc-gen-nrpc.
+type echoServer struct {
+	testproto.UnimplementedEchoNRPCServer
+}
+
+func (echoServer) Echo(_ context.Context, req *testproto.UnaryReq) (*testproto.UnaryResp, error) {
+	return &testproto.UnaryResp{Msg: req.Msg}, nil
+}
+
+func (echoServer) Stream(stream testproto.Echo_StreamNRPCServer) error {
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if r := stream.Send(&testproto.BiDiStreamResp{Msg: req.Msg}); r != nil {
+			return r
+		}
+	}
+}
+
+func TestGeneratedNRPC(t *testing.T) {
+	asrt := is.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	serverLog := &recordingLogger{}
+	server := nrpc.NewServer(pub, sub, nrpc.WithLogger(serverLog))
+	testproto.RegisterEchoNRPCServer(server, echoServer{})
+	asrt.NoErr(server.Run(ctx))
+	defer server.Stop()
+
+	// the subjects are set with the options of the proto file
+	asrt.True(serverLog.contains("INFO subscribed subject test.echo.unary queue testproto.Echo"))
+	asrt.True(serverLog.contains("INFO subscribed subject test.echo.Stream queue testproto.Echo"))
+
+	client := testproto.NewEchoNRPCClient(nrpc.NewClient(pub, sub, nrpc.WithLogger(nrpc.StandardLogger{})))
+
+	resp, err := client.Echo(ctx, &testproto.UnaryReq{Msg: "Hello via NRPC"})
+	asrt.NoErr(err)
+	asrt.Equal(resp.Msg, "Hello via NRPC")
+
+	stream, err := client.Stream(ctx)
+	asrt.NoErr(err)
+	for i := 0; i < 3; i++ {
+		msg := fmt.Sprintf("Hello %d", i)
+		asrt.NoErr(stream.Send(&testproto.BiDiStreamReq{Msg: msg}))
+		res, r := stream.Recv()
+		asrt.NoErr(r)
+		asrt.Equal(res.Msg, msg)
+	}
+	asrt.NoErr(stream.CloseSend())
+	_, err = stream.Recv()
+	asrt.True(errors.Is(err, io.EOF))
+}
+
+// notifyServer implements the testproto.EchoNRPCServer interface. Its handlers wait for release
+// and pass the received messages on to received.
+type notifyServer struct {
+	echoServer
+	release  <-chan struct{}
+	received chan<- string
+}
+
+func (s notifyServer) Echo(_ context.Context, req *testproto.UnaryReq) (*testproto.UnaryResp, error) {
+	<-s.release
+	s.received <- req.Msg
+	return &testproto.UnaryResp{Msg: req.Msg}, nil
+}
+
+func (s notifyServer) Notify(_ context.Context, req *testproto.UnaryReq) (*testproto.UnaryResp, error) {
+	<-s.release
+	s.received <- req.Msg
+	return nil, status.Error(codes.Internal, "never reaches the client")
+}
+
+func TestOneWay(t *testing.T) {
+	asrt := is.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	release := make(chan struct{})
+	received := make(chan string, 2)
+	server := nrpc.NewServer(pub, sub)
+	testproto.RegisterEchoNRPCServer(server, notifyServer{release: release, received: received})
+	asrt.NoErr(server.Run(ctx))
+	defer server.Stop()
+
+	client := testproto.NewEchoNRPCClient(nrpc.NewClient(pub, sub))
+
+	// the calls return before the handlers finished
+	resp, err := client.Notify(ctx, &testproto.UnaryReq{Msg: "notification"})
+	asrt.NoErr(err)
+	asrt.Equal(resp.Msg, "")
+	resp, err = client.Echo(ctx, &testproto.UnaryReq{Msg: "one-way echo"}, nrpc.OneWay())
+	asrt.NoErr(err)
+	asrt.Equal(resp.Msg, "")
+
+	close(release)
+	var msgs []string
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-received:
+			msgs = append(msgs, msg)
+		case <-ctx.Done():
+			t.Fatal("one-way calls were not handled")
+		}
+	}
+	sort.Strings(msgs)
+	asrt.Equal(msgs, []string{"notification", "one-way echo"})
+}
+
+func TestInvokeAsync(t *testing.T) {
+	asrt := is.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	const calls = 20
+	release := make(chan struct{})
+	received := make(chan string, calls+2)
+	server := nrpc.NewServer(pub, sub)
+	testproto.RegisterEchoNRPCServer(server, notifyServer{release: release, received: received})
+	asrt.NoErr(server.Run(ctx))
+	defer server.Stop()
+
+	client := nrpc.NewClient(pub, sub)
+
+	// all calls are sent before the first one is answered
+	futures := make([]*nrpc.Future, calls)
+	resps := make([]*testproto.UnaryResp, calls)
+	for i := range futures {
+		resps[i] = &testproto.UnaryResp{}
+		futures[i] = client.InvokeAsync(ctx, "/testproto.Echo/Echo", &testproto.UnaryReq{Msg: strconv.Itoa(i)}, resps[i])
+	}
+
+	// calls end at the deadline of their context
+	callCtx, callCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer callCancel()
+	timedOut := client.InvokeAsync(callCtx, "/testproto.Echo/Echo", &testproto.UnaryReq{Msg: "timeout"}, &testproto.UnaryResp{})
+	select {
+	case <-timedOut.Done():
+	case <-ctx.Done():
+		t.Fatal("call did not end at its deadline")
+	}
+	asrt.Equal(status.Code(timedOut.Result()), codes.DeadlineExceeded)
+	for _, future := range futures {
+		select {
+		case <-future.Done():
+			t.Fatal("call done before the handler returned")
+		default:
+		}
+	}
+
+	close(release)
+	for i, future := range futures {
+		asrt.NoErr(future.Result())
+		asrt.Equal(resps[i].Msg, strconv.Itoa(i))
+	}
+
+	// failing calls end with their status
+	err = client.InvokeAsync(ctx, "/testproto.Missing/Echo", &testproto.UnaryReq{}, &testproto.UnaryResp{}).Result()
+	asrt.Equal(status.Code(err), codes.Unavailable)
+
+	// calls passing the interceptor of the client are run by a goroutine
+	var intercepted int32
+	interceptedClient := nrpc.NewClient(pub, sub, nrpc.WithUnaryInterceptor(func(ctx context.Context, method string, req, reply interface{},
+		cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		atomic.AddInt32(&intercepted, 1)
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}))
+	resp := &testproto.UnaryResp{}
+	asrt.NoErr(interceptedClient.InvokeAsync(ctx, "/testproto.Echo/Echo", &testproto.UnaryReq{Msg: "intercepted"}, resp).Result())
+	asrt.Equal(resp.Msg, "intercepted")
+	asrt.Equal(atomic.LoadInt32(&intercepted), int32(1))
+}
+
+func TestInvokeAsyncPaged(t *testing.T) {
+	asrt := is.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	server := nrpc.NewServer(pub, sub, nrpc.WithResponsePaging(64))
+	testproto.RegisterEchoNRPCServer(server, echoServer{})
+	asrt.NoErr(server.Run(ctx))
+	defer server.Stop()
+
+	// the paged call requests its pages only once a plain call sent after its first page is done
+	var (
+		client  *nrpc.Client
+		plain   *nrpc.Future
+		started bool
+	)
+	plainResp := &testproto.UnaryResp{}
+	tap := nrpc.WireTapFunc(func(_ context.Context, frame nrpc.Frame) {
+		if frame.Direction != nrpc.FrameSent || !strings.HasPrefix(frame.Subject, "nrpc.page.") || started {
+			return
+		}
+		started = true
+		plain = client.InvokeAsync(ctx, "/testproto.Echo/Echo", &testproto.UnaryReq{Msg: "plain"}, plainResp)
+		select {
+		case <-plain.Done():
+		case <-ctx.Done():
+		}
+	})
+	client = nrpc.NewClient(pub, sub, nrpc.WithWireTap(tap))
+
+	msg := strings.Repeat("Hello via NRPC ", 50)
+	pagedResp := &testproto.UnaryResp{}
+	asrt.NoErr(client.InvokeAsync(ctx, "/testproto.Echo/Echo", &testproto.UnaryReq{Msg: msg}, pagedResp).Result())
+	asrt.Equal(pagedResp.Msg, msg)
+	asrt.True(started)
+	asrt.NoErr(plain.Result())
+	asrt.Equal(plainResp.Msg, "plain")
+}
+
+func TestInvokeAll(t *testing.T) {
+	asrt := is.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	_, _, err = testserver.New(pub, sub)
+	asrt.NoErr(err)
+	server := nrpc.NewServer(pub, sub)
+	testproto.RegisterEchoNRPCServer(server, echoServer{})
+	asrt.NoErr(server.Run(ctx))
+	defer server.Stop()
+
+	client := nrpc.NewClient(pub, sub)
+
+	var header metadata.MD
+	echo, unary, invalid := &testproto.UnaryResp{}, &testproto.UnaryResp{}, &testproto.UnaryResp{}
+	errs := client.InvokeAll(ctx, []nrpc.UnaryCall{
+		{Method: "/testproto.Echo/Echo", Args: &testproto.UnaryReq{Msg: "echo"}, Reply: echo},
+		{Method: "/testproto.Test/Unary", Args: &testproto.UnaryReq{Msg: "Hello via NRPC"}, Reply: unary, Opts: []grpc.CallOption{grpc.Header(&header)}},
+		{Method: "/testproto.Test/Unary", Args: &testproto.UnaryReq{Msg: "invalid"}, Reply: invalid},
+		{Method: "/testproto.Missing/Echo", Args: &testproto.UnaryReq{}, Reply: &testproto.UnaryResp{}},
+	})
+
+	// every call has its own status
+	asrt.Equal(len(errs), 4)
+	asrt.NoErr(errs[0])
+	asrt.Equal(echo.Msg, "echo")
+	asrt.NoErr(errs[1])
+	asrt.Equal(unary.Msg, "Hello back!")
+	asrt.Equal(header.Get("srv-key"), []string{"srv-value"})
+	asrt.Equal(status.Code(errs[2]), codes.InvalidArgument)
+	asrt.Equal(status.Code(errs[3]), codes.Unavailable)
+
+	// the calls share the deadline of the context
+	expired, cancelExpired := context.WithTimeout(ctx, time.Nanosecond)
+	defer cancelExpired()
+	<-expired.Done()
+	errs = client.InvokeAll(expired, []nrpc.UnaryCall{
+		{Method: "/testproto.Echo/Echo", Args: &testproto.UnaryReq{Msg: "late"}, Reply: &testproto.UnaryResp{}},
+		{Method: "/testproto.Test/Unary", Args: &testproto.UnaryReq{Msg: "Hello via NRPC"}, Reply: &testproto.UnaryResp{}},
+	})
+	asrt.Equal(status.Code(errs[0]), codes.DeadlineExceeded)
+	asrt.Equal(status.Code(errs[1]), codes.DeadlineExceeded)
+}
+
+// crashingStore simulates a process crashing before the outcome of its calls is known:
+// it never deletes entries.
+type crashingStore struct {
+	nrpc.OutboxStore
+}
+
+func (s crashingStore) Delete(string) error {
+	return nil
+}
+
+func TestOutbox(t *testing.T) {
+	tests := []struct {
+		name  string
+		store func(t *testing.T) nrpc.OutboxStore
+	}{
+		{
+			name: "bolt",
+			store: func(t *testing.T) nrpc.OutboxStore {
+				store, err := outbox.NewBolt(filepath.Join(t.TempDir(), "outbox.db"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(func() { _ = store.Close() })
+				return store
+			},
+		},
+		{
+			name: "sqlite",
+			store: func(t *testing.T) nrpc.OutboxStore {
+				db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "outbox.db"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(func() { _ = db.Close() })
+				store, err := outbox.NewSQLite(db)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return store
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testOutbox(t, tt.store(t))
+		})
+	}
+}
+
+func testOutbox(t *testing.T, store nrpc.OutboxStore) {
+	asrt := is.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	// the call of the crashed process is left in the outbox
+	crashed := testproto.NewEchoNRPCClient(nrpc.NewClient(pub, sub, nrpc.WithOutbox(crashingStore{store})))
+	callCtx, callCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	_, err = crashed.Echo(callCtx, &testproto.UnaryReq{Msg: "lost"})
+	callCancel()
+	asrt.True(err != nil)
+	pending, err := store.Pending()
+	asrt.NoErr(err)
+	asrt.Equal(len(pending), 1)
+	asrt.True(pending[0].Call)
+
+	// failed calls are left in the outbox too
+	failing := testproto.NewEchoNRPCClient(nrpc.NewClient(pub, sub, nrpc.WithOutbox(store)))
+	callCtx, callCancel = context.WithTimeout(ctx, 100*time.Millisecond)
+	_, err = failing.Echo(callCtx, &testproto.UnaryReq{Msg: "failed"})
+	callCancel()
+	asrt.True(err != nil)
+	pending, err = store.Pending()
+	asrt.NoErr(err)
+	asrt.Equal(len(pending), 2)
+
+	release := make(chan struct{})
+	close(release)
+	received := make(chan string, 2)
+	server := nrpc.NewServer(pub, sub, nrpc.UnaryInterceptor(func(ctx context.Context, req interface{},
+		info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		// the deadline of the callers passed, redelivered calls are bound by the handler timeouts
+		if _, ok := ctx.Deadline(); ok && req.(*testproto.UnaryReq).Msg != "delivered" {
+			t.Errorf("redelivered call %q has the deadline of its caller", req.(*testproto.UnaryReq).Msg)
+		}
+		return handler(ctx, req)
+	}))
+	testproto.RegisterEchoNRPCServer(server, notifyServer{release: release, received: received})
+	asrt.NoErr(server.Run(ctx))
+	defer server.Stop()
+
+	// the restarted process redelivers them
+	nrpcClient := nrpc.NewClient(pub, sub, nrpc.WithOutbox(store))
+	n, err := nrpcClient.Redeliver()
+	asrt.NoErr(err)
+	asrt.Equal(n, 2)
+	var redelivered []string
+	for len(redelivered) < 2 {
+		select {
+		case msg := <-received:
+			redelivered = append(redelivered, msg)
+		case <-ctx.Done():
+			t.Fatal("call was not redelivered")
+		}
+	}
+	sort.Strings(redelivered)
+	asrt.Equal(redelivered, []string{"failed", "lost"})
+
+	// delivered calls are deleted from the outbox
+	client := testproto.NewEchoNRPCClient(nrpcClient)
+	resp, err := client.Echo(ctx, &testproto.UnaryReq{Msg: "delivered"})
+	asrt.NoErr(err)
+	asrt.Equal(resp.Msg, "delivered")
+	pending, err = store.Pending()
+	asrt.NoErr(err)
+	asrt.Equal(len(pending), 0)
+
+	// the attempts of a retried call share their entry, which is not redelivered while the call is attempted
+	var attempts int32
+	attempted := make(chan struct{}, 3)
+	tap := nrpc.WireTapFunc(func(_ context.Context, frame nrpc.Frame) {
+		if frame.Direction == nrpc.FrameSent && frame.Method == "/testproto.Test/Unary" {
+			atomic.AddInt32(&attempts, 1)
+			attempted <- struct{}{}
+		}
+	})
+	retrying := nrpc.NewClient(pub, sub, nrpc.WithOutbox(store), nrpc.WithWireTap(tap), nrpc.WithRetryPolicy(nrpc.RetryPolicy{
+		MaxAttempts:       3,
+		InitialBackoff:    50 * time.Millisecond,
+		MaxBackoff:        50 * time.Millisecond,
+		BackoffMultiplier: 1,
+	}))
+	done := make(chan error, 1)
+	go func() {
+		done <- retrying.Invoke(ctx, "/testproto.Test/Unary", &testproto.UnaryReq{Msg: "unserved"}, &testproto.UnaryResp{})
+	}()
+	<-attempted
+	n, err = retrying.Redeliver()
+	asrt.NoErr(err)
+	asrt.Equal(n, 0)
+	asrt.Equal(status.Code(<-done), codes.Unavailable)
+	asrt.Equal(atomic.LoadInt32(&attempts), int32(3))
+	pending, err = store.Pending()
+	asrt.NoErr(err)
+	asrt.Equal(len(pending), 1)
+
+	// failed calls are redelivered at runtime
+	n, err = retrying.Redeliver()
+	asrt.NoErr(err)
+	asrt.Equal(n, 1)
+	pending, err = store.Pending()
+	asrt.NoErr(err)
+	asrt.Equal(len(pending), 0)
+}
+
+func TestGateway(t *testing.T) {
+	asrt := is.New(t)
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	_, _, err = testserver.New(pub, sub, nrpc.WithLogger(nrpc.StandardLogger{}))
+	asrt.NoErr(err)
+
+	gw := gateway.New(nrpc.NewClient(pub, sub, nrpc.WithLogger(nrpc.StandardLogger{})))
+	asrt.NoErr(gw.Register("testproto.Test"))
+	asrt.NoErr(gw.Handle(http.MethodGet, "/v1/unary/{msg}", "/testproto.Test/Unary", ""))
+	srv := httptest.NewServer(gw)
+	defer srv.Close()
+
+	do := func(method, path, accept string, body string) (*http.Response, string) {
+		req, r := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		asrt.NoErr(r)
+		req.Header.Set("Accept", accept)
+		req.Header.Set("Grpc-Metadata-Client-Key", "client-value")
+		resp, r := http.DefaultClient.Do(req)
+		asrt.NoErr(r)
+		defer resp.Body.Close()
+		data, r := io.ReadAll(resp.Body)
+		asrt.NoErr(r)
+		return resp, string(data)
+	}
+
+	// unary call by path convention
+	resp, body := do(http.MethodPost, "/testproto.Test/Unary", "", `{"msg": "Hello via NRPC"}`)
+	asrt.Equal(resp.StatusCode, http.StatusOK)
+	asrt.Equal(resp.Header.Get("Grpc-Metadata-Client-Key"), "client-value")
+	asrt.Equal(resp.Header.Get("Grpc-Trailer-Traily"), "t-value")
+	asrt.True(strings.Contains(body, `"msg":"Hello back!"`))
+
+	// unary call with the message bound to the path
+	resp, body = do(http.MethodGet, "/v1/unary/Hello%20via%20NRPC", "", "")
+	asrt.Equal(resp.StatusCode, http.StatusOK)
+	asrt.True(strings.Contains(body, `"msg":"Hello back!"`))
+
+	// errors are mapped to HTTP status codes
+	resp, body = do(http.MethodGet, "/v1/unary/invalid", "", "")
+	asrt.Equal(resp.StatusCode, http.StatusBadRequest)
+	asrt.True(strings.Contains(body, `"code":3`))
+	resp, _ = do(http.MethodGet, "/testproto.Test/Unary", "", "")
+	asrt.Equal(resp.StatusCode, http.StatusMethodNotAllowed)
+	resp, _ = do(http.MethodGet, "/unknown", "", "")
+	asrt.Equal(resp.StatusCode, http.StatusNotFound)
+
+	// server stream as newline delimited JSON
+	resp, body = do(http.MethodPost, "/testproto.Test/ServerStream", "", `{"msg": "Hello via NRPC"}`)
+	asrt.Equal(resp.StatusCode, http.StatusOK)
+	asrt.Equal(resp.Header.Get("Content-Type"), "application/x-ndjson")
+	lines := strings.Split(strings.TrimSpace(body), "\n")
+	asrt.Equal(len(lines), 5)
+	asrt.True(strings.HasPrefix(lines[0], `{"result":{"msg":"Hello back! 1"}}`))
+
+	// server stream as server-sent events
+	resp, body = do(http.MethodPost, "/testproto.Test/ServerStream", "text/event-stream", `{"msg": "Hello via NRPC"}`)
+	asrt.Equal(resp.StatusCode, http.StatusOK)
+	asrt.Equal(strings.Count(body, "data: "), 5)
+	asrt.True(strings.HasPrefix(body, "data: {\"msg\":\"Hello back! 1\"}\n\n"))
+
+	// bidirectional stream with a sequence of messages as body
+	resp, body = do(http.MethodPost, "/testproto.Test/BiDiStream", "", `{"msg": "Hello via NRPC 1"} {"msg": "Hello via NRPC 2"}`)
+	asrt.Equal(resp.StatusCode, http.StatusOK)
+	asrt.Equal(len(strings.Split(strings.TrimSpace(body), "\n")), 2)
+}
+
+func TestGRPCWeb(t *testing.T) {
+	asrt := is.New(t)
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	_, _, err = testserver.New(pub, sub, nrpc.WithLogger(nrpc.StandardLogger{}))
+	asrt.NoErr(err)
+
+	srv := httptest.NewServer(grpcweb.New(nrpc.NewClient(pub, sub, nrpc.WithLogger(nrpc.StandardLogger{}))))
+	defer srv.Close()
+
+	// do sends the message in a grpc-web frame and returns the data frames and the trailer frame of the response
+	do := func(method, contentType, msg string) (*http.Response, [][]byte, string) {
+		payload, r := proto.Marshal(&testproto.UnaryReq{Msg: msg})
+		asrt.NoErr(r)
+		body := append([]byte{0, 0, 0, 0, 0}, payload...)
+		binary.BigEndian.PutUint32(body[1:5], uint32(len(payload)))
+		if contentType == "application/grpc-web-text" {
+			body = []byte(base64.StdEncoding.EncodeToString(body))
+		}
+
+		req, r := http.NewRequest(http.MethodPost, srv.URL+method, bytes.NewReader(body))
+		asrt.NoErr(r)
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("X-Grpc-Web", "1")
+		req.Header.Set("Client-Key", "client-value")
+		resp, r := http.DefaultClient.Do(req)
+		asrt.NoErr(r)
+		defer resp.Body.Close()
+		data, r := io.ReadAll(resp.Body)
+		asrt.NoErr(r)
+		if contentType == "application/grpc-web-text" {
+			// the frames are encoded separately, so the data is decoded in blocks of 4 characters
+			var decoded []byte
+			for i := 0; i+4 <= len(data); i += 4 {
+				block, decodeErr := base64.StdEncoding.DecodeString(string(data[i : i+4]))
+				asrt.NoErr(decodeErr)
+				decoded = append(decoded, block...)
+			}
+			data = decoded
+		}
+
+		var frames [][]byte
+		var trailer string
+		for len(data) >= 5 {
+			n := binary.BigEndian.Uint32(data[1:5])
+			if data[0]&0x80 != 0 {
+				trailer = string(data[5 : 5+n])
+			} else {
+				frames = append(frames, data[5:5+n])
+			}
+			data = data[5+n:]
+		}
+		return resp, frames, trailer
+	}
+
+	// unary call
+	resp, frames, trailer := do("/testproto.Test/Unary", "application/grpc-web+proto", "Hello via NRPC")
+	asrt.Equal(resp.StatusCode, http.StatusOK)
+	asrt.Equal(resp.Header.Get("Content-Type"), "application/grpc-web+proto")
+	asrt.Equal(resp.Header.Get("Client-Key"), "client-value")
+	asrt.Equal(len(frames), 1)
+	var unaryResp testproto.UnaryResp
+	asrt.NoErr(proto.Unmarshal(frames[0], &unaryResp))
+	asrt.Equal(unaryResp.Msg, "Hello back!")
+	asrt.True(strings.Contains(trailer, "grpc-status: 0\r\n"))
+	asrt.True(strings.Contains(trailer, "traily: t-value\r\n"))
+
+	// errors without messages are sent as trailers-only response
+	resp, frames, _ = do("/testproto.Test/Unary", "application/grpc-web+proto", "invalid")
+	asrt.Equal(resp.StatusCode, http.StatusOK)
+	asrt.Equal(len(frames), 0)
+	asrt.Equal(resp.Header.Get("Grpc-Status"), "3")
+	asrt.Equal(resp.Header.Get("Grpc-Message"), "invalid message")
+
+	// server stream in the text format
+	resp, frames, trailer = do("/testproto.Test/ServerStream", "application/grpc-web-text", "Hello via NRPC")
+	asrt.Equal(resp.StatusCode, http.StatusOK)
+	asrt.Equal(len(frames), 5)
+	var streamResp testproto.ServerStreamResp
+	asrt.NoErr(proto.Unmarshal(frames[4], &streamResp))
+	asrt.Equal(streamResp.Msg, "Hello back! 5")
+	asrt.True(strings.Contains(trailer, "grpc-status: 0\r\n"))
+}
+
+func TestDiscovery(t *testing.T) {
+	asrt := is.New(t)
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	server1, _, err := testserver.New(pub, sub, nrpc.WithAnnouncements(50*time.Millisecond))
+	asrt.NoErr(err)
+	defer server1.Stop()
+	server2, _, err := testserver.New(pub, sub, nrpc.WithAnnouncements(50*time.Millisecond))
+	asrt.NoErr(err)
+
+	registry, err := nrpc.NewRegistry(pub, sub)
+	asrt.NoErr(err)
+	defer registry.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// waitFor waits for the watched instances to match the IDs
+	watch := registry.Watch(ctx, "testproto.Test")
+	waitFor := func(ids ...string) []nrpc.Instance {
+		for instances := range watch {
+			if len(instances) != len(ids) {
+				continue
+			}
+			match := true
+			for i, inst := range instances {
+				match = match && inst.ID == ids[i]
+			}
+			if match {
+				return instances
+			}
+		}
+		t.Fatalf("instances %v not discovered", ids)
+		return nil
+	}
+
+	ids := []string{server1.InstanceID(), server2.InstanceID()}
+	if ids[0] > ids[1] {
+		ids[0], ids[1] = ids[1], ids[0]
+	}
+	instances := waitFor(ids...)
+	asrt.True(instances[0].Serves("testproto.Test"))
+	asrt.Equal(len(instances[0].Services["testproto.Test"]), 4)
+	asrt.Equal(len(registry.Instances("testproto.Test")), 2)
+	asrt.Equal(len(registry.Instances("unknown.Service")), 0)
+
+	// the stopped instance announces that it leaves
+	server2.Stop()
+	waitFor(server1.InstanceID())
+	asrt.Equal(len(registry.Instances("")), 1)
+}
+
+func TestBalancing(t *testing.T) {
+	asrt := is.New(t)
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	var ids []string
+	for i := 0; i < 3; i++ {
+		server, _, r := testserver.New(pub, sub, nrpc.WithAnnouncements(time.Second))
+		asrt.NoErr(r)
+		defer server.Stop()
+		ids = append(ids, server.InstanceID())
+	}
+
+	registry, err := nrpc.NewRegistry(pub, sub)
+	asrt.NoErr(err)
+	defer registry.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for instances := range registry.Watch(ctx, "testproto.Test") {
+		if len(instances) == 3 {
+			break
+		}
+	}
+	asrt.NoErr(ctx.Err())
+
+	// call returns the ID of the instance serving the call
+	call := func(callCtx context.Context, client testproto.TestClient, opts ...grpc.CallOption) string {
+		var p peer.Peer
+		_, r := client.Unary(callCtx, &testproto.UnaryReq{Msg: "Hello via NRPC"}, append(opts, grpc.Peer(&p))...)
+		asrt.NoErr(r)
+		subj := p.Addr.String()
+		return subj[strings.LastIndex(subj, ".")+1:]
+	}
+
+	// round robin takes turns
+	client := testproto.NewTestClient(nrpc.NewClient(pub, sub, nrpc.WithRegistry(registry), nrpc.WithBalancingPolicy(nrpc.RoundRobin())))
+	served := map[string]int{}
+	for i := 0; i < 6; i++ {
+		served[call(ctx, client)]++
+	}
+	for _, id := range ids {
+		asrt.Equal(served[id], 2)
+	}
+
+	// consistent hashing sends the calls with the same key to the same instance
+	client = testproto.NewTestClient(nrpc.NewClient(pub, sub, nrpc.WithRegistry(registry),
+		nrpc.WithBalancingPolicy(nrpc.ConsistentHash("tenant"), "testproto.Test")))
+	keyCtx := metadata.AppendToOutgoingContext(ctx, "tenant", "tenant-1")
+	first := call(keyCtx, client)
+	for i := 0; i < 5; i++ {
+		asrt.Equal(call(keyCtx, client), first)
+	}
+
+	// streams with the same affinity key are served by the same instance
+	client = testproto.NewTestClient(nrpc.NewClient(pub, sub, nrpc.WithRegistry(registry), nrpc.WithBalancingPolicy(nrpc.RoundRobin())))
+	stream := func(key string) string {
+		var p peer.Peer
+		s, r := client.ServerStream(ctx, &testproto.ServerStreamReq{Msg: "Hello via NRPC"}, nrpc.AffinityKey(key), grpc.Peer(&p))
+		asrt.NoErr(r)
+		for r == nil {
+			_, r = s.Recv()
+		}
+		asrt.True(errors.Is(r, io.EOF))
+		subj := p.Addr.String()
+		return subj[strings.LastIndex(subj, ".")+1:]
+	}
+	for _, key := range []string{"session-1", "session-2", "session-3"} {
+		instance := stream(key)
+		for i := 0; i < 3; i++ {
+			asrt.Equal(stream(key), instance)
+		}
+	}
+
+	// a specific instance can be addressed
+	client = testproto.NewTestClient(nrpc.NewClient(pub, sub))
+	asrt.Equal(call(ctx, client, nrpc.ToInstance(ids[1])), ids[1])
+	_, err = client.Unary(ctx, &testproto.UnaryReq{Msg: "Hello via NRPC"}, nrpc.ToInstance("unknown"))
+	asrt.Equal(status.Code(err), codes.Unavailable)
+}
+
+func TestQueueGroups(t *testing.T) {
+	asrt := is.New(t)
+	ctxMain := context.Background()
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	// serve starts two servers with the options and returns the number of calls they handled in total.
+	serve := func(ctx context.Context, t *testing.T, opts ...[]nrpc.Option) func() int32 {
+		asrt := is.New(t)
+		var failing, calls int32
+		for _, o := range opts {
+			server := nrpc.NewServer(pub, sub, o...)
+			testproto.RegisterEchoServer(server, flakyServer{failing: &failing, calls: &calls})
+			asrt.NoErr(server.Run(ctx))
+			t.Cleanup(server.Stop)
+		}
+		return func() int32 {
+			// give the other servers the chance to handle the call as well
+			time.Sleep(100 * time.Millisecond)
+			return atomic.LoadInt32(&calls)
+		}
+	}
+	call := func(ctx context.Context, asrt *is.I, opts ...nrpc.Option) {
+		client := testproto.NewEchoClient(nrpc.NewClient(pub, sub, opts...))
+		_, err := client.Echo(ctx, &testproto.UnaryReq{Msg: "Hello"})
+		asrt.NoErr(err)
+	}
+
+	t.Run("service group", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		calls := serve(ctx, t, nil, nil)
+		call(ctx, asrt)
+		asrt.Equal(calls(), int32(1))
+	})
+	t.Run("separate groups", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		calls := serve(ctx, t,
+			[]nrpc.Option{nrpc.WithQueueGroups([]string{"blue"}, "testproto.Echo")},
+			[]nrpc.Option{nrpc.WithQueueGroups([]string{"green"})},
+		)
+		call(ctx, asrt)
+		asrt.Equal(calls(), int32(2))
+	})
+	t.Run("multiple groups", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		calls := serve(ctx, t, []nrpc.Option{nrpc.WithQueueGroups([]string{"blue", "green"})})
+		call(ctx, asrt)
+		asrt.Equal(calls(), int32(2))
+	})
+	t.Run("broadcast", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		calls := serve(ctx, t,
+			[]nrpc.Option{nrpc.WithBroadcast("/testproto.Echo/Echo")},
+			[]nrpc.Option{nrpc.WithBroadcast("/testproto.Echo/Echo")},
+		)
+		call(ctx, asrt)
+		asrt.Equal(calls(), int32(2))
+	})
+	t.Run("stream queue group", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		server := nrpc.NewServer(pub, sub, nrpc.WithStreamQueueGroup("streams"))
+		testproto.RegisterEchoServer(server, metadataServer{})
+		asrt.NoErr(server.Run(ctx))
+		defer server.Stop()
+
+		client := testproto.NewEchoClient(nrpc.NewClient(pub, sub, nrpc.WithStreamQueueGroup("streams")))
+		stream, err := client.Stream(ctx)
+		asrt.NoErr(err)
+		asrt.NoErr(stream.Send(&testproto.BiDiStreamReq{Msg: "Hello"}))
+		resp, err := stream.Recv()
+		asrt.NoErr(err)
+		asrt.Equal(resp.Msg, "Hello")
+		asrt.NoErr(stream.CloseSend())
+	})
+}
+
+func TestBroadcast(t *testing.T) {
+	asrt := is.New(t)
+	ctxMain := context.Background()
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	const instances = 3
+	var failing, calls int32
+	for i := 0; i < instances; i++ {
+		server := nrpc.NewServer(pub, sub)
+		testproto.RegisterEchoServer(server, flakyServer{failing: &failing, calls: &calls})
+		asrt.NoErr(server.Run(ctxMain))
+		defer server.Stop()
+	}
+	client := nrpc.NewClient(pub, sub)
+
+	// gather receives the responses until the end of the broadcast.
+	gather := func(asrt *is.I, resps *nrpc.BroadcastResponses) []string {
+		var msgs []string
+		for {
+			var resp testproto.UnaryResp
+			r := resps.Recv(&resp)
+			if errors.Is(r, io.EOF) {
+				return msgs
+			}
+			asrt.NoErr(r)
+			msgs = append(msgs, resp.Msg)
+		}
+	}
+
+	t.Run("deadline", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 300*time.Millisecond)
+		defer cancel()
+
+		resps, err := client.Broadcast(ctx, "/testproto.Echo/Echo", &testproto.UnaryReq{Msg: "scatter"})
+		asrt.NoErr(err)
+		asrt.Equal(gather(asrt, resps), []string{"scatter", "scatter", "scatter"})
+	})
+	t.Run("max responses", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		start := time.Now()
+		resps, err := client.Broadcast(ctx, "/testproto.Echo/Echo", &testproto.UnaryReq{Msg: "scatter"}, nrpc.MaxResponses(2))
+		asrt.NoErr(err)
+		asrt.Equal(len(gather(asrt, resps)), 2)
+		asrt.True(time.Since(start) < time.Second)
+	})
+	t.Run("failing instances", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 300*time.Millisecond)
+		defer cancel()
+
+		atomic.StoreInt32(&failing, 1)
+		defer atomic.StoreInt32(&failing, 0)
+
+		resps, err := client.Broadcast(ctx, "/testproto.Echo/Echo", &testproto.UnaryReq{Msg: "scatter"})
+		asrt.NoErr(err)
+		var resp testproto.UnaryResp
+		for i := 0; i < instances; i++ {
+			asrt.Equal(status.Code(resps.Recv(&resp)), codes.Unavailable)
+		}
+		asrt.Equal(resps.Recv(&resp), io.EOF)
+	})
+	t.Run("canceled", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithCancel(ctxMain)
+
+		resps, err := client.Broadcast(ctx, "/testproto.Echo/Echo", &testproto.UnaryReq{Msg: "scatter"}, nrpc.MaxResponses(instances+1))
+		asrt.NoErr(err)
+		var resp testproto.UnaryResp
+		for i := 0; i < instances; i++ {
+			asrt.NoErr(resps.Recv(&resp))
+		}
+		cancel()
+		asrt.Equal(status.Code(resps.Recv(&resp)), codes.Canceled)
+	})
+
+	// calls are still handled by a single instance
+	atomic.StoreInt32(&calls, 0)
+	_, err = testproto.NewEchoClient(client).Echo(ctxMain, &testproto.UnaryReq{Msg: "single"})
+	asrt.NoErr(err)
+	time.Sleep(100 * time.Millisecond)
+	asrt.Equal(atomic.LoadInt32(&calls), int32(1))
+}
+
+func TestSubjectMapping(t *testing.T) {
+	asrt := is.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	server := nrpc.NewServer(pub, sub, nrpc.WithSubjectPrefix("acme"), nrpc.WithEnvironment("staging"))
+	testproto.RegisterEchoServer(server, headerServer{})
+	asrt.NoErr(server.Run(ctx))
+	defer server.Stop()
+
+	// the subjects, including the ones registered with RegisterSubject, are prefixed with the prefix and the environment
+	var p peer.Peer
+	client := testproto.NewEchoClient(nrpc.NewClient(pub, sub, nrpc.WithEnvironment("staging"), nrpc.WithSubjectPrefix("acme")))
+	resp, err := client.Echo(ctx, &testproto.UnaryReq{Msg: "Hello via NRPC"}, grpc.Peer(&p))
+	asrt.NoErr(err)
+	asrt.Equal(resp.Msg, "Hello via NRPC")
+	asrt.Equal(p.Addr.String(), "acme.staging.test.echo.unary")
+
+	stream, err := client.Stream(ctx)
+	asrt.NoErr(err)
+	asrt.NoErr(stream.Send(&testproto.BiDiStreamReq{Msg: "Hello via NRPC"}))
+	streamResp, err := stream.Recv()
+	asrt.NoErr(err)
+	asrt.Equal(streamResp.Msg, "Hello via NRPC")
+	asrt.NoErr(stream.CloseSend())
+	_, err = stream.Recv()
+	asrt.True(errors.Is(err, io.EOF))
+
+	// a custom mapper can produce the same subjects
+	client = testproto.NewEchoClient(nrpc.NewClient(pub, sub, nrpc.WithSubjectMapper(nrpc.SubjectMapperFunc(func(subject string) string {
+		return "acme.staging." + subject
+	}))))
+	_, err = client.Echo(ctx, &testproto.UnaryReq{Msg: "Hello via NRPC"})
+	asrt.NoErr(err)
+
+	// other environments share the broker without reaching the server
+	for _, opts := range [][]nrpc.Option{
+		nil,
+		{nrpc.WithSubjectPrefix("acme"), nrpc.WithEnvironment("production")},
+		{nrpc.WithSubjectPrefix("acme"), nrpc.WithEnvironment("staging"), nrpc.WithTenant("tenant")},
+	} {
+		client = testproto.NewEchoClient(nrpc.NewClient(pub, sub, opts...))
+		_, err = client.Echo(ctx, &testproto.UnaryReq{Msg: "Hello via NRPC"})
+		asrt.Equal(status.Code(err), codes.Unavailable)
+	}
+}
+
+// tenantServer implements the testproto.EchoServer interface. It answers with the tenant of the call.
+type tenantServer struct {
+	testproto.UnimplementedEchoServer
+}
+
+func (tenantServer) Echo(ctx context.Context, _ *testproto.UnaryReq) (*testproto.UnaryResp, error) {
+	tenant, _ := nrpc.TenantFromContext(ctx)
+	return &testproto.UnaryResp{Msg: tenant}, nil
+}
+
+func (tenantServer) Stream(stream testproto.Echo_StreamServer) error {
+	tenant, _ := nrpc.TenantFromContext(stream.Context())
+	return stream.Send(&testproto.BiDiStreamResp{Msg: tenant})
+}
+
+func TestMultiTenancy(t *testing.T) {
+	asrt := is.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tenancy := nats.Tenancy{
+		Prefix:  "acme",
+		Servers: nats.User{Name: "servers", Password: "servers"},
+		Tenants: map[string]nats.User{
+			"a":    {Name: "a", Password: "a"},
+			"b":    {Name: "b", Password: "b"},
+			"nrpc": {Name: "nrpc", Password: "nrpc"},
+		},
+	}
+	config := tenancy.Config()
+	asrt.True(strings.Contains(config, `{user: "a", password: "a", permissions: {publish: {allow: ["acme.a.>", `))
+	asrt.True(strings.Contains(config, `deny: ["acme.nrpc.push"]}`))
+
+	srv, err := testproto.NewTestAuthNATSServer(tenancy.Users())
+	asrt.NoErr(err)
+	defer srv.Shutdown()
+
+	serverConn, err := natsgo.Connect(srv.URL(), natsgo.UserInfo("servers", "servers"))
+	asrt.NoErr(err)
+	defer serverConn.Close()
+
+	server := nrpc.NewServer(nats.Publisher(serverConn), nats.Subscriber(serverConn), nrpc.WithSubjectPrefix("acme"), nrpc.WithMultiTenancy())
+	testproto.RegisterEchoServer(server, tenantServer{})
+	asrt.NoErr(server.Run(ctx))
+	defer server.Stop()
+
+	clientConn, err := natsgo.Connect(srv.URL(), natsgo.UserInfo("a", "a"), natsgo.CustomInboxPrefix(nats.TenantInboxPrefix("a")))
+	asrt.NoErr(err)
+	defer clientConn.Close()
+
+	pub, sub := nats.Publisher(clientConn), nats.Subscriber(clientConn)
+	client := testproto.NewEchoClient(nrpc.NewClient(pub, sub, nrpc.WithSubjectPrefix("acme"), nrpc.WithMultiTenancy()))
+
+	// the tenant is taken from the context or the metadata and passed to the handler
+	resp, err := client.Echo(nrpc.NewTenantContext(ctx, "a"), &testproto.UnaryReq{})
+	asrt.NoErr(err)
+	asrt.Equal(resp.Msg, "a")
+	resp, err = client.Echo(metadata.AppendToOutgoingContext(ctx, nrpc.TenantMetadataKey, "a"), &testproto.UnaryReq{})
+	asrt.NoErr(err)
+	asrt.Equal(resp.Msg, "a")
+
+	stream, err := client.Stream(nrpc.NewTenantContext(ctx, "a"))
+	asrt.NoErr(err)
+	asrt.NoErr(stream.Send(&testproto.BiDiStreamReq{}))
+	streamResp, err := stream.Recv()
+	asrt.NoErr(err)
+	asrt.Equal(streamResp.Msg, "a")
+
+	// calls need a tenant
+	_, err = client.Echo(ctx, &testproto.UnaryReq{})
+	asrt.Equal(status.Code(err), codes.InvalidArgument)
+
+	// the permissions keep the tenant from calling as another tenant
+	callCtx, callCancel := context.WithTimeout(nrpc.NewTenantContext(ctx, "b"), 200*time.Millisecond)
+	defer callCancel()
+	_, err = client.Echo(callCtx, &testproto.UnaryReq{})
+	asrt.Equal(status.Code(err), codes.DeadlineExceeded)
+
+	// the server only subscribes and publishes on the stream subjects of the tenant opening the stream
+	handshakes := []struct {
+		name     string
+		req      string
+		resp     string
+		accepted bool
+	}{
+		{name: "valid", req: "acme.a.nrpc.req.testproto.Echo.Stream.x1", resp: "acme.a.nrpc.resp.testproto.Echo.Stream.x1", accepted: true},
+		{name: "shared response subject", req: "acme.a.nrpc.req.testproto.Echo.Stream.x2", resp: "acme.a.nrpc.mux.id.0", accepted: true},
+		{name: "wildcard request", req: "acme.>", resp: "acme.a.nrpc.resp.testproto.Echo.Stream.x3"},
+		{name: "wildcard suffix", req: "acme.a.nrpc.req.testproto.Echo.Stream.*", resp: "acme.a.nrpc.resp.testproto.Echo.Stream.x4"},
+		{name: "wildcard response", req: "acme.a.nrpc.req.testproto.Echo.Stream.x5", resp: "acme.a.nrpc.resp.testproto.Echo.Stream.>"},
+		{name: "other tenant request", req: "acme.b.nrpc.req.testproto.Echo.Stream.x6", resp: "acme.a.nrpc.resp.testproto.Echo.Stream.x6"},
+		{name: "other tenant response", req: "acme.a.nrpc.req.testproto.Echo.Stream.x7", resp: "acme.b.nrpc.resp.testproto.Echo.Stream.x7"},
+		{name: "other method", req: "acme.a.nrpc.req.testproto.Echo.Echo.x8", resp: "acme.a.nrpc.resp.testproto.Echo.Echo.x8"},
+		{name: "other subject", req: "acme.a.nrpc.req.testproto.Echo.Stream.x9", resp: "acme.a.test.echo.unary"},
+	}
+	for _, hs := range handshakes {
+		payload, err := proto.Marshal(&nrpc.Request{ReqSubject: hs.req, RespSubject: hs.resp})
+		asrt.NoErr(err)
+		reply, err := clientConn.RequestWithContext(ctx, "acme.a.test.echo.Stream", payload)
+		asrt.NoErr(err)
+		var msg nrpc.Message
+		asrt.NoErr(proto.Unmarshal(reply.Data, &msg))
+		if (msg.Type != nrpc.MessageType_Error) != hs.accepted {
+			t.Errorf("%s: accepted = %v, want %v", hs.name, !hs.accepted, hs.accepted)
+		}
+	}
+
+	// only the servers can push, even if the subjects of the tenant cover the push subject
+	violations := make(chan error, 2)
+	pushConn, err := natsgo.Connect(srv.URL(), natsgo.UserInfo("nrpc", "nrpc"),
+		natsgo.ErrorHandler(func(_ *natsgo.Conn, _ *natsgo.Subscription, err error) { violations <- err }))
+	asrt.NoErr(err)
+	defer pushConn.Close()
+
+	asrt.NoErr(pushConn.Publish("acme.nrpc.push", nil))
+	_, err = pushConn.SubscribeSync("acme.nrpc.push")
+	asrt.NoErr(err)
+	asrt.NoErr(pushConn.Flush())
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-violations:
+			asrt.True(strings.Contains(strings.ToLower(err.Error()), natsgo.PERMISSIONS_ERR))
+		case <-ctx.Done():
+			t.Fatal("pushing was permitted")
+		}
+	}
+}
+
+func TestPropagation(t *testing.T) {
+	asrt := is.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	server := nrpc.NewServer(pub, sub)
+	testproto.RegisterEchoServer(server, metadataServer{})
+	asrt.NoErr(server.Run(ctx))
+	defer server.Stop()
+
+	client := testproto.NewEchoClient(nrpc.NewClient(pub, sub, nrpc.WithPropagation("X-Auth", "x-b3-*")))
+
+	// the context of a handler carries the metadata of the call it serves as incoming metadata
+	handlerCtx := metadata.NewIncomingContext(ctx, metadata.Pairs(
+		"x-auth", "token", "x-b3-traceid", "trace", "x-b3-spanid", "span", "x-private", "secret", "x-override", "in",
+	))
+	handlerCtx = metadata.AppendToOutgoingContext(handlerCtx, "x-override", "out")
+
+	var header metadata.MD
+	_, err = client.Echo(handlerCtx, &testproto.UnaryReq{Msg: "fan-out"}, grpc.Header(&header))
+	asrt.NoErr(err)
+	asrt.Equal(header.Get("x-auth"), []string{"token"})
+	asrt.Equal(header.Get("x-b3-traceid"), []string{"trace"})
+	asrt.Equal(header.Get("x-b3-spanid"), []string{"span"})
+	asrt.Equal(len(header.Get("x-private")), 0)
+	asrt.Equal(header.Get("x-override"), []string{"out"})
+
+	stream, err := client.Stream(handlerCtx)
+	asrt.NoErr(err)
+	asrt.NoErr(stream.Send(&testproto.BiDiStreamReq{Msg: "fan-out"}))
+	_, err = stream.Recv()
+	asrt.NoErr(err)
+	header, err = stream.Header()
+	asrt.NoErr(err)
+	asrt.Equal(header.Get("x-auth"), []string{"token"})
+	asrt.Equal(len(header.Get("x-private")), 0)
+	asrt.NoErr(stream.CloseSend())
+}
+
+func TestAuthentication(t *testing.T) {
+	asrt := is.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	run := func(authenticator nrpc.Authenticator) func() {
+		server := nrpc.NewServer(pub, sub, nrpc.WithAuthenticator(authenticator))
+		testproto.RegisterEchoServer(server, headerServer{})
+		asrt.NoErr(server.Run(ctx))
+		return server.Stop
+	}
+	echo := func(creds ...credentials.PerRPCCredentials) error {
+		var opts []nrpc.Option
+		for _, c := range creds {
+			opts = append(opts, nrpc.WithPerRPCCredentials(c))
+		}
+		client := testproto.NewEchoClient(nrpc.NewClient(pub, sub, opts...))
+		if _, err := client.Echo(ctx, &testproto.UnaryReq{Msg: "Hello via NRPC"}); err != nil {
+			return err
+		}
+		stream, err := client.Stream(ctx)
+		if err != nil {
+			return err
+		}
+		if r := stream.Send(&testproto.BiDiStreamReq{Msg: "Hello via NRPC"}); r != nil {
+			return r
+		}
+		_, err = stream.Recv()
+		return err
+	}
+
+	// bearer tokens
+	stop := run(auth.BearerAuthenticator(func(ctx context.Context, token string) (context.Context, error) {
+		if token != "secret" {
+			return nil, errors.New("invalid token")
+		}
+		return ctx, nil
+	}))
+	asrt.NoErr(echo(auth.Token("secret")))
+	asrt.Equal(status.Code(echo()), codes.Unauthenticated)
+	asrt.Equal(status.Code(echo(auth.Token("guess"))), codes.Unauthenticated)
+	stop()
+
+	// nkey signed nonces
+	user, err := nkeys.CreateUser()
+	asrt.NoErr(err)
+	pubKey, err := user.PublicKey()
+	asrt.NoErr(err)
+	seed, err := user.Seed()
+	asrt.NoErr(err)
+	stranger, err := nkeys.CreateUser()
+	asrt.NoErr(err)
+	strangerSeed, err := stranger.Seed()
+	asrt.NoErr(err)
+
+	stop = run(auth.NKeyAuthenticator(time.Minute, pubKey))
+	creds, err := auth.NKey(seed)
+	asrt.NoErr(err)
+	asrt.NoErr(echo(creds))
+	creds, err = auth.NKey(strangerSeed)
+	asrt.NoErr(err)
+	asrt.Equal(status.Code(echo(creds)), codes.Unauthenticated)
+	stop()
+
+	// authenticators can answer with other codes
+	stop = run(nrpc.AuthenticatorFunc(func(context.Context, string) (context.Context, error) {
+		return nil, status.Error(codes.PermissionDenied, "denied")
+	}))
+	asrt.Equal(status.Code(echo(auth.Token("secret"))), codes.PermissionDenied)
+	stop()
+}
+
+func TestEncryption(t *testing.T) {
+	asrt := is.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	// the broker only sees encrypted payloads
+	var m sync.Mutex
+	var plain bool
+	var sealed []byte
+	snoop, err := conn.Subscribe(">", func(msg *natsgo.Msg) {
+		m.Lock()
+		defer m.Unlock()
+		plain = plain || bytes.Contains(msg.Data, []byte("Hello via NRPC"))
+		if msg.Subject == "test.echo.unary" && sealed == nil {
+			sealed = msg.Data
+		}
+	})
+	asrt.NoErr(err)
+	defer func() { _ = snoop.Unsubscribe() }()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	keys := map[string][]byte{
+		"1": bytes.Repeat([]byte{1}, 32),
+		"2": bytes.Repeat([]byte{2}, 32),
+	}
+	server := nrpc.NewServer(pub, sub, nrpc.WithEncryption(nrpc.StaticKeys("1", keys)))
+	testproto.RegisterEchoServer(server, headerServer{})
+	asrt.NoErr(server.Run(ctx))
+	defer server.Stop()
+
+	// the client encrypts with a rotated key known to the server
+	client := testproto.NewEchoClient(nrpc.NewClient(pub, sub, nrpc.WithEncryption(nrpc.StaticKeys("2", keys))))
+	resp, err := client.Echo(ctx, &testproto.UnaryReq{Msg: "Hello via NRPC"})
+	asrt.NoErr(err)
+	asrt.Equal(resp.Msg, "Hello via NRPC")
+
+	stream, err := client.Stream(ctx)
+	asrt.NoErr(err)
+	asrt.NoErr(stream.Send(&testproto.BiDiStreamReq{Msg: "Hello via NRPC"}))
+	streamResp, err := stream.Recv()
+	asrt.NoErr(err)
+	asrt.Equal(streamResp.Msg, "Hello via NRPC")
+	asrt.NoErr(stream.CloseSend())
+	_, err = stream.Recv()
+	asrt.True(errors.Is(err, io.EOF))
+
+	// replies to broadcasts are received on a subscription
+	resps, err := nrpc.NewClient(pub, sub, nrpc.WithEncryption(nrpc.StaticKeys("2", keys))).
+		Broadcast(ctx, "/testproto.Echo/Echo", &testproto.UnaryReq{Msg: "Hello via NRPC"}, nrpc.MaxResponses(1))
+	asrt.NoErr(err)
+	var broadcastResp testproto.UnaryResp
+	asrt.NoErr(resps.Recv(&broadcastResp))
+	asrt.Equal(broadcastResp.Msg, "Hello via NRPC")
+	resps.Close()
+
+	asrt.NoErr(conn.Flush())
+	m.Lock()
+	asrt.True(!plain)
+	m.Unlock()
+
+	// the payloads are bound to the subject of nrpc they are sent on: the mapping of the subjects is
+	// transparent, but payloads moved to another subject are dropped
+	prefixed := nrpc.NewServer(pub, sub, nrpc.WithEncryption(nrpc.StaticKeys("1", keys)), nrpc.WithSubjectPrefix("acme"))
+	testproto.RegisterEchoServer(prefixed, headerServer{})
+	asrt.NoErr(prefixed.Run(ctx))
+	defer prefixed.Stop()
+
+	m.Lock()
+	request := sealed
+	m.Unlock()
+	asrt.True(request != nil)
+	for _, subj := range []string{"test.echo.unary", "acme.test.echo.unary"} {
+		_, err = conn.RequestWithContext(ctx, subj, request)
+		asrt.NoErr(err)
+	}
+	for _, subj := range []string{"test.echo.Stream", "acme.test.echo.Stream", "test.echo.unary.all"} {
+		callCtx, callCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		_, err = conn.RequestWithContext(callCtx, subj, request)
+		callCancel()
+		asrt.True(errors.Is(err, context.DeadlineExceeded))
+	}
+
+	// messages failing the decryption are dropped
+	for _, opts := range [][]nrpc.Option{
+		nil,
+		{nrpc.WithEncryption(nrpc.StaticKeys("1", map[string][]byte{"1": bytes.Repeat([]byte{3}, 32)}))},
+	} {
+		callCtx, callCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		client = testproto.NewEchoClient(nrpc.NewClient(pub, sub, opts...))
+		_, err = client.Echo(callCtx, &testproto.UnaryReq{Msg: "Hello via NRPC"})
+		callCancel()
+		asrt.Equal(status.Code(err), codes.DeadlineExceeded)
+	}
+}
+
+func TestAuthorization(t *testing.T) {
+	asrt := is.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	roles := map[string][]string{"admin": {"admin", "user"}, "user": {"user"}, "guest": {"guest"}}
+	authenticator := auth.BearerAuthenticator(func(ctx context.Context, token string) (context.Context, error) {
+		return auth.NewClaimsContext(ctx, auth.Claims{"role": roles[token]}), nil
+	})
+	policy := auth.Policy{Rules: []auth.Rule{
+		{Method: "/testproto.Echo/Stream", Claims: map[string]string{"role": "admin"}},
+		{Method: "/testproto.Echo/Stream", Deny: true},
+		{Method: "/testproto.Echo/*", Claims: map[string]string{"role": "user"}},
+	}}
+
+	server := nrpc.NewServer(pub, sub, nrpc.WithAuthenticator(authenticator), nrpc.WithAuthorizer(policy))
+	testproto.RegisterEchoServer(server, headerServer{})
+	asrt.NoErr(server.Run(ctx))
+	defer server.Stop()
+
+	call := func(token string) (error, error) {
+		client := testproto.NewEchoClient(nrpc.NewClient(pub, sub, nrpc.WithPerRPCCredentials(auth.Token(token))))
+		_, unaryErr := client.Echo(ctx, &testproto.UnaryReq{Msg: "Hello via NRPC"})
+
+		stream, err := client.Stream(ctx)
+		if err != nil {
+			return unaryErr, err
+		}
+		if r := stream.Send(&testproto.BiDiStreamReq{Msg: "Hello via NRPC"}); r != nil {
+			return unaryErr, r
+		}
+		_, err = stream.Recv()
+		return unaryErr, err
+	}
+
+	unaryErr, streamErr := call("admin")
+	asrt.NoErr(unaryErr)
+	asrt.NoErr(streamErr)
+
+	unaryErr, streamErr = call("user")
+	asrt.NoErr(unaryErr)
+	asrt.Equal(status.Code(streamErr), codes.PermissionDenied)
+
+	unaryErr, streamErr = call("guest")
+	asrt.Equal(status.Code(unaryErr), codes.PermissionDenied)
+	asrt.Equal(status.Code(streamErr), codes.PermissionDenied)
+}
+
+func TestPeer(t *testing.T) {
+	asrt := is.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	var (
+		m     sync.Mutex
+		peers []nrpc.Peer
+		addrs []string
+	)
+	record := func(ctx context.Context) {
+		m.Lock()
+		defer m.Unlock()
+
+		p, ok := nrpc.PeerFromContext(ctx)
+		asrt.True(ok)
+		peers = append(peers, *p)
+		grpcPeer, ok := peer.FromContext(ctx)
+		asrt.True(ok)
+		addrs = append(addrs, grpcPeer.Addr.String())
+	}
+	server := nrpc.NewServer(pub, sub,
+		nrpc.WithAuthenticator(nrpc.AuthenticatorFunc(func(ctx context.Context, _ string) (context.Context, error) {
+			return auth.NewClaimsContext(ctx, auth.Claims{"sub": {"alice"}}), nil
+		})),
+		nrpc.ChainUnaryInterceptor(func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			record(ctx)
+			return handler(ctx, req)
+		}),
+		nrpc.ChainStreamInterceptor(func(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			record(ss.Context())
+			return handler(srv, ss)
+		}),
+	)
+	testproto.RegisterEchoServer(server, headerServer{})
+	asrt.NoErr(server.Run(ctx))
+	defer server.Stop()
+
+	clientID, err := conn.GetClientID()
+	asrt.NoErr(err)
+	client := testproto.NewEchoClient(nrpc.NewClient(pub, sub))
+
+	_, err = client.Echo(ctx, &testproto.UnaryReq{Msg: "Hello via NRPC"})
+	asrt.NoErr(err)
+	stream, err := client.Stream(ctx)
+	asrt.NoErr(err)
+	asrt.NoErr(stream.Send(&testproto.BiDiStreamReq{Msg: "Hello via NRPC"}))
+	_, err = stream.Recv()
+	asrt.NoErr(err)
+	asrt.NoErr(stream.CloseSend())
+
+	m.Lock()
+	defer m.Unlock()
+	asrt.Equal(len(peers), 2)
+	for i, p := range peers {
+		asrt.Equal(p.ClientID, strconv.FormatUint(clientID, 10))
+		asrt.Equal(p.User, "alice")
+		asrt.Equal(p.Transport, "nats")
+		asrt.Equal(addrs[i], p.ReplySubject)
+	}
+	// unary calls are answered on the inbox of the request, streams on their response subject
+	asrt.True(strings.HasPrefix(peers[0].ReplySubject, "_INBOX."))
+	asrt.True(strings.HasPrefix(peers[1].ReplySubject, "nrpc.resp.testproto.Echo.Stream."))
+}
+
+// traceOption implements the nrpc.CallOption interface. It sends a trace ID with the call and keeps the
+// description of the call once it is done.
+type traceOption struct {
+	grpc.EmptyCallOption
+	id   string
+	err  error
+	done *nrpc.CallInfo
+}
+
+func (o *traceOption) BeforeCall(info *nrpc.CallInfo) error {
+	info.Metadata.Set("x-trace-id", o.id)
+	return o.err
+}
+
+func (o *traceOption) AfterCall(info *nrpc.CallInfo) {
+	o.done = info
+}
+
+// legacyJSONCodec implements the deprecated grpc.Codec interface.
+type legacyJSONCodec struct {
+	json.Codec
+}
+
+func (legacyJSONCodec) String() string {
+	return json.Name
+}
+
+func TestCallOptionExtension(t *testing.T) {
+	asrt := is.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	server := nrpc.NewServer(pub, sub)
+	testproto.RegisterEchoServer(server, metadataServer{})
+	asrt.NoErr(server.Run(ctx))
+	defer server.Stop()
+
+	client := testproto.NewEchoClient(nrpc.NewClient(pub, sub))
+
+	// the metadata of the option is sent with the call, the server echoes it with the header
+	unary := &traceOption{id: "unary"}
+	_, err = client.Echo(ctx, &testproto.UnaryReq{Msg: "Hello via NRPC"}, unary)
+	asrt.NoErr(err)
+	asrt.Equal(unary.done.Method, "/testproto.Echo/Echo")
+	asrt.Equal(unary.done.Header.Get("x-trace-id"), []string{"unary"})
+	asrt.Equal(unary.done.Trailer.Get("x-trailer"), []string{"t-value"})
+	asrt.Equal(unary.done.Subject, "test.echo.unary")
+
+	stream := &traceOption{id: "stream"}
+	s, err := client.Stream(ctx, stream)
+	asrt.NoErr(err)
+	asrt.NoErr(s.Send(&testproto.BiDiStreamReq{Msg: "Hello via NRPC"}))
+	asrt.NoErr(s.CloseSend())
+	for err == nil {
+		_, err = s.Recv()
+	}
+	asrt.True(errors.Is(err, io.EOF))
+	asrt.Equal(stream.done.Header.Get("x-trace-id"), []string{"stream"})
+	asrt.Equal(stream.done.Trailer.Get("x-trailer"), []string{"t-value"})
+
+	// an error of the option fails the call before it is sent
+	failing := &traceOption{id: "failing", err: status.Error(codes.PermissionDenied, "denied")}
+	_, err = client.Echo(ctx, &testproto.UnaryReq{Msg: "Hello via NRPC"}, failing)
+	asrt.Equal(status.Code(err), codes.PermissionDenied)
+	asrt.True(failing.done == nil)
+
+	// codecs of the deprecated grpc.Codec interface are adapted
+	resp, err := client.Echo(ctx, &testproto.UnaryReq{Msg: "Hello via JSON"}, grpc.CallCustomCodec(legacyJSONCodec{}))
+	asrt.NoErr(err)
+	asrt.Equal(resp.Msg, "Hello via JSON")
+}
+
+func TestClientIdentity(t *testing.T) {
+	asrt := is.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	identities := make(chan nrpc.ClientIdentity, 10)
+	log := &recordingLogger{}
+	serverMetrics := metrics.NewServerMetrics()
+	limiter := ratelimit.New(ratelimit.WithLimit(ratelimit.Limit{Rate: 0.1, Burst: 1}), ratelimit.PerCallerService())
+	opts := append(serverMetrics.Options(), requestlog.New(log).ServerOptions()...)
+	opts = append(opts, limiter.ServerOptions()...)
+	opts = append(opts, nrpc.ChainStreamInterceptor(func(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		p, _ := nrpc.PeerFromContext(ss.Context())
+		identities <- p.Identity
+		return handler(srv, ss)
+	}))
+	_, _, err = testserver.New(pub, sub, opts...)
+	asrt.NoErr(err)
+
+	billing := nrpc.ClientIdentity{Service: "billing", Version: "v1.4.2", Instance: "billing-7d9f"}
+	client := testclient.New(pub, sub, nrpc.WithClientIdentity(billing))
+	stream, err := client.ServerStream(ctx, &testproto.ServerStreamReq{Msg: "Hello via NRPC"})
+	asrt.NoErr(err)
+	for {
+		if _, r := stream.Recv(); r != nil {
+			asrt.True(errors.Is(r, io.EOF))
+			break
+		}
+	}
+	asrt.Equal(<-identities, billing)
+
+	_, err = client.Unary(ctx, &testproto.UnaryReq{Msg: "Hello via NRPC"})
+	asrt.NoErr(err)
+	asrt.True(log.contains("caller billing/v1.4.2/billing-7d9f"))
+
+	// the services are limited separately, all instances of a service share the limit
+	_, err = client.Unary(ctx, &testproto.UnaryReq{Msg: "Hello via NRPC"})
+	asrt.Equal(status.Code(err), codes.ResourceExhausted)
+	other := testclient.New(pub, sub, nrpc.WithClientIdentity(nrpc.ClientIdentity{Service: "billing", Instance: "billing-5c2a"}))
+	_, err = other.Unary(ctx, &testproto.UnaryReq{Msg: "Hello via NRPC"})
+	asrt.Equal(status.Code(err), codes.ResourceExhausted)
+	anonymous := testclient.New(pub, sub)
+	_, err = anonymous.Unary(ctx, &testproto.UnaryReq{Msg: "Hello via NRPC"})
+	asrt.NoErr(err)
+
+	// the server might record a finished stream only after the client received the end of it
+	for i := 0; i < 100 && testutil.CollectAndCount(serverMetrics, "nrpc_server_handled_by_caller_total") < 3; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	expected := `
+# HELP nrpc_server_handled_by_caller_total Total number of RPCs completed by the service announced by the caller.
+# TYPE nrpc_server_handled_by_caller_total counter
+nrpc_server_handled_by_caller_total{caller="",code="OK",method="/testproto.Test/Unary"} 1
+nrpc_server_handled_by_caller_total{caller="billing",code="OK",method="/testproto.Test/ServerStream"} 1
+nrpc_server_handled_by_caller_total{caller="billing",code="OK",method="/testproto.Test/Unary"} 1
+nrpc_server_handled_by_caller_total{caller="billing",code="ResourceExhausted",method="/testproto.Test/Unary"} 2
+`
+	asrt.NoErr(testutil.CollectAndCompare(serverMetrics, strings.NewReader(expected), "nrpc_server_handled_by_caller_total"))
+}
+
+func TestRateLimit(t *testing.T) {
+	asrt := is.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	serverLimiter := ratelimit.New(ratelimit.WithLimit(ratelimit.Limit{Rate: 0.1, Burst: 2}), ratelimit.PerCaller("x-api-key"))
+	server := nrpc.NewServer(pub, sub, serverLimiter.ServerOptions()...)
+	testproto.RegisterEchoServer(server, headerServer{})
+	asrt.NoErr(server.Run(ctx))
+	defer server.Stop()
+
+	// the server limits every caller separately
+	client := testproto.NewEchoClient(nrpc.NewClient(pub, sub))
+	echo := func(apiKey string) (metadata.MD, error) {
+		var header metadata.MD
+		_, err := client.Echo(metadata.AppendToOutgoingContext(ctx, "x-api-key", apiKey), &testproto.UnaryReq{}, grpc.Header(&header))
+		return header, err
+	}
+	for i := 0; i < 2; i++ {
+		_, err = echo("a")
+		asrt.NoErr(err)
+	}
+	header, err := echo("a")
+	asrt.Equal(status.Code(err), codes.ResourceExhausted)
+	retryAfter, ok := ratelimit.RetryAfter(header)
+	asrt.True(ok)
+	asrt.True(retryAfter > 9*time.Second && retryAfter <= 10*time.Second)
+	_, err = echo("b")
+	asrt.NoErr(err)
+
+	// the client rejects calls before sending them
+	clientLimiter := ratelimit.New(ratelimit.WithLimit(ratelimit.Limit{Rate: 0.1, Burst: 1}, "/testproto.Echo/Stream"))
+	client = testproto.NewEchoClient(nrpc.NewClient(pub, sub, clientLimiter.ClientOptions()...))
+	stream, err := client.Stream(ctx)
+	asrt.NoErr(err)
+	asrt.NoErr(stream.CloseSend())
+	_, err = client.Stream(ctx, grpc.Header(&header))
+	asrt.Equal(status.Code(err), codes.ResourceExhausted)
+	_, ok = ratelimit.RetryAfter(header)
+	asrt.True(ok)
+}
+
+func TestReplay(t *testing.T) {
+	asrt := is.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	server := nrpc.NewServer(pub, sub)
+	testproto.RegisterEchoServer(server, metadataServer{})
+	asrt.NoErr(server.Run(ctx))
+	defer server.Stop()
+
+	calls := func(client testproto.EchoClient) []string {
+		ctx := metadata.AppendToOutgoingContext(ctx, "x-key", "value")
+		var results []string
+		for _, msg := range []string{"hello", "fail"} {
+			var header metadata.MD
+			resp, err := client.Echo(ctx, &testproto.UnaryReq{Msg: msg}, grpc.Header(&header))
+			results = append(results, resp.GetMsg()+" "+status.Code(err).String()+" "+strings.Join(header.Get("x-key"), ","))
+		}
+
+		stream, err := client.Stream(ctx)
+		asrt.NoErr(err)
+		for _, msg := range []string{"a", "b"} {
+			asrt.NoErr(stream.Send(&testproto.BiDiStreamReq{Msg: msg}))
+		}
+		asrt.NoErr(stream.CloseSend())
+		for {
+			resp, err := stream.Recv()
+			if err != nil {
+				results = append(results, err.Error()+" "+strings.Join(stream.Trailer().Get("x-trailer"), ","))
+				break
+			}
+			results = append(results, resp.Msg)
+		}
+		return results
+	}
+
+	var buf bytes.Buffer
+	recorder := replay.NewRecorder(&buf)
+	want := calls(testproto.NewEchoClient(nrpc.NewClient(pub, sub, recorder.ClientOptions()...)))
+	asrt.NoErr(recorder.Err())
+	asrt.Equal(want, []string{"hello OK value", " FailedPrecondition value", "a", "b", "EOF t-value"})
+
+	exchanges, err := replay.Load(&buf)
+	asrt.NoErr(err)
+	asrt.Equal(len(exchanges), 3)
+	asrt.Equal(exchanges[0].Method, "/testproto.Echo/Echo")
+	asrt.Equal(exchanges[1].Code, codes.FailedPrecondition)
+	asrt.Equal(len(exchanges[2].Messages), 4)
+
+	// the recorded calls are issued against the server again
+	replayed, err := replay.Replay(ctx, nrpc.NewClient(pub, sub), exchanges)
+	asrt.NoErr(err)
+	for i, ex := range replayed {
+		asrt.True(ex.Equal(exchanges[i]))
+	}
+
+	// the player serves the recorded responses without the server
+	server.Stop()
+	player := replay.NewPlayer(exchanges)
+	client := testproto.NewEchoClient(nrpc.NewClient(pub, sub, player.ClientOptions()...))
+	asrt.Equal(calls(client), want)
+	_, err = client.Echo(ctx, &testproto.UnaryReq{Msg: "unknown"})
+	asrt.Equal(status.Code(err), codes.NotFound)
+}
+
+func TestMockServer(t *testing.T) {
+	asrt := is.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	mock := nrpctest.NewServer()
+	mock.Register(&testproto.Echo_ServiceDesc)
+	mock.Unary("/testproto.Echo/Echo").
+		Return(&testproto.UnaryResp{Msg: "canned"}).
+		Respond(nrpctest.Response{Err: status.Error(codes.Unavailable, "down"), Header: metadata.Pairs("x-key", "value")})
+	mock.Stream("/testproto.Echo/Stream").Script(
+		nrpctest.Sleep(50*time.Millisecond),
+		nrpctest.Header(metadata.Pairs("x-delayed", "1")),
+		nrpctest.Recv(),
+		nrpctest.Send(&testproto.BiDiStreamResp{Msg: "scripted"}),
+		nrpctest.Fail(status.Error(codes.DataLoss, "mid-stream")),
+	)
+	asrt.NoErr(mock.Run(ctx))
+	defer mock.Stop()
+
+	client := testproto.NewEchoClient(mock.Client())
+
+	resp, err := client.Echo(ctx, &testproto.UnaryReq{Msg: "first"})
+	asrt.NoErr(err)
+	asrt.Equal(resp.Msg, "canned")
+	// the last response is repeated
+	for i := 0; i < 2; i++ {
+		var header metadata.MD
+		_, err = client.Echo(ctx, &testproto.UnaryReq{Msg: "again"}, grpc.Header(&header))
+		asrt.Equal(status.Code(err), codes.Unavailable)
+		asrt.Equal(header.Get("x-key"), []string{"value"})
+	}
+	requests := mock.Unary("/testproto.Echo/Echo").Requests()
+	asrt.Equal(len(requests), 3)
+	asrt.Equal(requests[0].(*testproto.UnaryReq).Msg, "first")
+
+	start := time.Now()
+	stream, err := client.Stream(ctx)
+	asrt.NoErr(err)
+	asrt.NoErr(stream.Send(&testproto.BiDiStreamReq{Msg: "hello"}))
+	header, err := stream.Header()
+	asrt.NoErr(err)
+	asrt.Equal(header.Get("x-delayed"), []string{"1"})
+	asrt.True(time.Since(start) >= 50*time.Millisecond)
+
+	streamResp, err := stream.Recv()
+	asrt.NoErr(err)
+	asrt.Equal(streamResp.Msg, "scripted")
+	_, err = stream.Recv()
+	asrt.Equal(status.Code(err), codes.DataLoss)
+	asrt.Equal(mock.Stream("/testproto.Echo/Stream").Requests()[0].(*testproto.BiDiStreamReq).Msg, "hello")
+}
+
+func TestFaultInjection(t *testing.T) {
+	asrt := is.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	serverFaults := fault.New(
+		fault.Rule{Metadata: map[string]string{"x-chaos": "error"}, Code: codes.Unavailable},
+		fault.Rule{Metadata: map[string]string{"x-chaos": "reset"}, Reset: true},
+	)
+	server := nrpc.NewServer(pub, sub, serverFaults.ServerOptions()...)
+	testproto.RegisterEchoServer(server, metadataServer{})
+	asrt.NoErr(server.Run(ctx))
+	defer server.Stop()
+
+	clientFaults := fault.New(
+		fault.Rule{Methods: []string{"testproto.Echo"}, Metadata: map[string]string{"x-chaos": "delay"}, Delay: 50 * time.Millisecond},
+		fault.Rule{Metadata: map[string]string{"x-chaos": "drop"}, Drop: true},
+	)
+	client := testproto.NewEchoClient(nrpc.NewClient(pub, sub, clientFaults.ClientOptions()...))
+
+	chaos := func(ctx context.Context, kind string) context.Context {
+		return metadata.AppendToOutgoingContext(ctx, "x-chaos", kind)
+	}
+
+	// calls without matching metadata are not affected
+	_, err = client.Echo(ctx, &testproto.UnaryReq{Msg: "Hello via NRPC"})
+	asrt.NoErr(err)
+
+	_, err = client.Echo(chaos(ctx, "error"), &testproto.UnaryReq{Msg: "Hello via NRPC"})
+	asrt.Equal(status.Code(err), codes.Unavailable)
+
+	start := time.Now()
+	_, err = client.Echo(chaos(ctx, "delay"), &testproto.UnaryReq{Msg: "Hello via NRPC"})
+	asrt.NoErr(err)
+	asrt.True(time.Since(start) >= 50*time.Millisecond)
+
+	dropCtx, dropCancel := context.WithTimeout(chaos(ctx, "drop"), 50*time.Millisecond)
+	defer dropCancel()
+	_, err = client.Echo(dropCtx, &testproto.UnaryReq{Msg: "Hello via NRPC"})
+	asrt.Equal(status.Code(err), codes.DeadlineExceeded)
+
+	stream, err := client.Stream(chaos(ctx, "reset"))
+	asrt.NoErr(err)
+	asrt.NoErr(stream.Send(&testproto.BiDiStreamReq{Msg: "Hello via NRPC"}))
+	_, err = stream.Recv()
+	asrt.Equal(status.Code(err), codes.Unavailable)
+
+	// rules can be changed at runtime
+	serverFaults.SetRules()
+	_, err = client.Echo(chaos(ctx, "error"), &testproto.UnaryReq{Msg: "Hello via NRPC"})
+	asrt.NoErr(err)
+}
+
+// fieldError mimics the validation errors generated by protoc-gen-validate.
+type fieldError struct {
+	field  string
+	reason string
+}
+
+func (e fieldError) Field() string  { return e.field }
+func (e fieldError) Reason() string { return e.reason }
+func (e fieldError) Error() string  { return e.field + ": " + e.reason }
+
+func TestValidation(t *testing.T) {
+	asrt := is.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	validator := validate.New(validate.WithValidator(validate.ValidatorFunc(func(msg interface{}) error {
+		m, ok := msg.(interface{ GetMsg() string })
+		if ok && m.GetMsg() == "" {
+			return fieldError{field: "msg", reason: "value is required"}
+		}
+		return nil
+	})))
+	server := nrpc.NewServer(pub, sub, validator.ServerOptions()...)
+	testproto.RegisterEchoNRPCServer(server, echoServer{})
+	asrt.NoErr(server.Run(ctx))
+	defer server.Stop()
+
+	client := testproto.NewEchoNRPCClient(nrpc.NewClient(pub, sub))
+
+	violation := func(err error) *errdetails.BadRequest_FieldViolation {
+		st := status.Convert(err)
+		asrt.Equal(st.Code(), codes.InvalidArgument)
+		asrt.Equal(len(st.Details()), 1)
+		badRequest, ok := st.Details()[0].(*errdetails.BadRequest)
+		asrt.True(ok)
+		asrt.Equal(len(badRequest.FieldViolations), 1)
+		return badRequest.FieldViolations[0]
+	}
+
+	t.Run("unary", func(t *testing.T) {
+		asrt := asrt.New(t)
+
+		resp, r := client.Echo(ctx, &testproto.UnaryReq{Msg: "valid"})
+		asrt.NoErr(r)
+		asrt.Equal(resp.Msg, "valid")
+
+		_, r = client.Echo(ctx, &testproto.UnaryReq{})
+		v := violation(r)
+		asrt.Equal(v.Field, "msg")
+		asrt.Equal(v.Description, "value is required")
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		asrt := asrt.New(t)
+
+		stream, r := client.Stream(ctx)
+		asrt.NoErr(r)
+
+		asrt.NoErr(stream.Send(&testproto.BiDiStreamReq{Msg: "valid"}))
+		resp, r := stream.Recv()
+		asrt.NoErr(r)
+		asrt.Equal(resp.Msg, "valid")
+
+		asrt.NoErr(stream.Send(&testproto.BiDiStreamReq{}))
+		_, r = stream.Recv()
+		asrt.Equal(violation(r).Field, "msg")
+	})
+}
+
+// flakyServer implements the testproto.EchoServer interface. It fails with codes.Unavailable while failing is set.
+type flakyServer struct {
+	testproto.UnimplementedEchoServer
+	failing *int32
+	calls   *int32
+}
+
+func (s flakyServer) Echo(_ context.Context, req *testproto.UnaryReq) (*testproto.UnaryResp, error) {
+	atomic.AddInt32(s.calls, 1)
+	if atomic.LoadInt32(s.failing) == 1 {
+		return nil, status.Error(codes.Unavailable, "try again")
+	}
+	return &testproto.UnaryResp{Msg: req.Msg}, nil
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	asrt := is.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	failing, calls := int32(1), int32(0)
+	server := nrpc.NewServer(pub, sub)
+	testproto.RegisterEchoServer(server, flakyServer{failing: &failing, calls: &calls})
+	asrt.NoErr(server.Run(ctx))
+	defer server.Stop()
+
+	var m sync.Mutex
+	var transitions []string
+	client := testproto.NewEchoClient(nrpc.NewClient(pub, sub, nrpc.WithCircuitBreaker(nrpc.CircuitBreakerPolicy{
+		FailureThreshold: 2,
+		CoolDown:         100 * time.Millisecond,
+		OnStateChange: func(subject string, from, to nrpc.CircuitState) {
+			m.Lock()
+			defer m.Unlock()
+			transitions = append(transitions, from.String()+" -> "+to.String())
+		},
+	}, "testproto.Echo")))
+
+	// consecutive failures open the breaker, so calls fail fast
+	for i := 0; i < 3; i++ {
+		_, err = client.Echo(ctx, &testproto.UnaryReq{Msg: "Hello via NRPC"})
+		asrt.Equal(status.Code(err), codes.Unavailable)
+	}
+	asrt.Equal(atomic.LoadInt32(&calls), int32(2))
+
+	// after the cool-down a successful probe closes the breaker
+	time.Sleep(150 * time.Millisecond)
+	atomic.StoreInt32(&failing, 0)
+	resp, err := client.Echo(ctx, &testproto.UnaryReq{Msg: "Hello via NRPC"})
+	asrt.NoErr(err)
+	asrt.Equal(resp.Msg, "Hello via NRPC")
+	asrt.Equal(atomic.LoadInt32(&calls), int32(3))
+
+	m.Lock()
+	defer m.Unlock()
+	asrt.Equal(transitions, []string{"closed -> open", "open -> half-open", "half-open -> closed"})
+}
+
+func TestWireTap(t *testing.T) {
+	asrt := is.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	serverTap, clientTap := &frameRecorder{}, &frameRecorder{}
+	server := nrpc.NewServer(pub, sub, nrpc.WithWireTap(serverTap))
+	testproto.RegisterEchoServer(server, headerServer{})
+	asrt.NoErr(server.Run(ctx))
+	defer server.Stop()
+
+	client := testproto.NewEchoClient(nrpc.NewClient(pub, sub, nrpc.WithWireTap(clientTap)))
+
+	ctx = metadata.AppendToOutgoingContext(ctx, "key", "value")
+	_, err = client.Echo(ctx, &testproto.UnaryReq{Msg: "Hello via NRPC"})
+	asrt.NoErr(err)
+
+	want := []string{"sent data", "received data"}
+	asrt.Equal(clientTap.frames(), want)
+	asrt.Equal(serverTap.frames(), []string{"received data", "sent data"})
+	for _, tap := range []*frameRecorder{clientTap, serverTap} {
+		req, resp := tap.get(0), tap.get(1)
+		asrt.Equal(req.Method, "/testproto.Echo/Echo")
+		asrt.Equal(req.Subject, "test.echo.unary")
+		asrt.Equal(req.Size, len(req.Data))
+		asrt.Equal(req.Header.Get("key"), []string{"value"})
+		asrt.Equal(resp.Header.Get("sent"), []string{"1"})
+		asrt.Equal(resp.Trailer.Get("trailer"), []string{"1", "2"})
+	}
+
+	// streams: the first message is answered with a handshake, the trailer is sent with the end of the stream
+	clientTap.reset()
+	stream, err := client.Stream(ctx)
+	asrt.NoErr(err)
+	asrt.NoErr(stream.Send(&testproto.BiDiStreamReq{Msg: "Hello via NRPC"}))
+	_, err = stream.Recv()
+	asrt.NoErr(err)
+	asrt.NoErr(stream.CloseSend())
+	_, err = stream.Recv()
+	asrt.True(errors.Is(err, io.EOF))
+
+	frames := clientTap.frames()
+	asrt.Equal(frames[0], "sent data")
+	asrt.True(contains(frames, "received handshake"))
+	asrt.True(contains(frames, "received header"))
+	asrt.True(contains(frames, "sent eos"))
+	eos := clientTap.get(len(frames) - 1)
+	asrt.Equal(eos.Type, nrpc.FrameEOS)
+	asrt.Equal(eos.Direction, nrpc.FrameReceived)
+	asrt.Equal(eos.Trailer.Get("trailer"), []string{"1", "2"})
+}
+
+// frameRecorder records the frames passed to the wire tap.
+type frameRecorder struct {
+	m      sync.Mutex
+	tapped []nrpc.Frame
+}
+
+func (r *frameRecorder) TapFrame(_ context.Context, frame nrpc.Frame) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.tapped = append(r.tapped, frame)
+}
+
+func (r *frameRecorder) frames() []string {
+	r.m.Lock()
+	defer r.m.Unlock()
+	frames := make([]string, 0, len(r.tapped))
+	for _, frame := range r.tapped {
+		frames = append(frames, frame.Direction.String()+" "+string(frame.Type))
+	}
+	return frames
+}
+
+func (r *frameRecorder) get(i int) nrpc.Frame {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return r.tapped[i]
+}
+
+func (r *frameRecorder) reset() {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.tapped = nil
+}
+
+// metadataServer implements the testproto.EchoServer interface. It answers with the received
+// metadata prefixed with x- in the header and the trailer.
+type metadataServer struct {
+	testproto.UnimplementedEchoServer
+}
+
+func echoedMD(ctx context.Context) (metadata.MD, metadata.MD) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	header := metadata.MD{}
+	for k, v := range md {
+		if strings.HasPrefix(k, "x-") {
+			header[k] = v
+		}
+	}
+	return header, metadata.Pairs("x-trailer", "t-value", "x-trailer-bin", string([]byte{0, 1, 0xff}))
+}
+
+func (metadataServer) Echo(ctx context.Context, req *testproto.UnaryReq) (*testproto.UnaryResp, error) {
+	header, trailer := echoedMD(ctx)
+	if r := grpc.SetHeader(ctx, header); r != nil {
+		return nil, r
+	}
+	if r := grpc.SetTrailer(ctx, trailer); r != nil {
+		return nil, r
+	}
+	if req.Msg == "fail" {
+		return nil, status.Error(codes.FailedPrecondition, "failed")
+	}
+	return &testproto.UnaryResp{Msg: req.Msg}, nil
+}
+
+func (metadataServer) Stream(stream testproto.Echo_StreamServer) error {
+	header, trailer := echoedMD(stream.Context())
+	if r := stream.SendHeader(header); r != nil {
+		return r
+	}
+	stream.SetTrailer(trailer)
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if r := stream.Send(&testproto.BiDiStreamResp{Msg: req.Msg}); r != nil {
+			return r
+		}
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	asrt := is.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	server := nrpc.NewServer(pub, sub)
+	testproto.RegisterEchoServer(server, metadataServer{})
+	asrt.NoErr(server.Run(ctx))
+	defer server.Stop()
+
+	// the same service served by grpc for comparison
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	asrt.NoErr(err)
+	grpcServer := grpc.NewServer()
+	testproto.RegisterEchoServer(grpcServer, metadataServer{})
+	go func() { _ = grpcServer.Serve(lis) }()
+	defer grpcServer.Stop()
+	grpcConn, err := grpc.DialContext(ctx, lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	asrt.NoErr(err)
+	defer grpcConn.Close()
+
+	binValue := string([]byte{0, 1, 0xfe, 0xff})
+	callCtx := metadata.NewOutgoingContext(ctx, metadata.Pairs("x-key", "value-1", "x-key", "value-2", "x-data-bin", binValue))
+	callCtx = metadata.AppendToOutgoingContext(callCtx, "x-appended", "appended", "X-Upper", "upper")
+
+	// only the metadata set by the service is compared, grpc adds further headers like content-type
+	filter := func(md metadata.MD) metadata.MD {
+		out := metadata.MD{}
+		for k, v := range md {
+			if strings.HasPrefix(k, "x-") {
+				out[k] = v
+			}
+		}
+		return out
+	}
+	type result struct {
+		header, trailer metadata.MD
+		code            codes.Code
+	}
+	unary := func(cc grpc.ClientConnInterface, msg string) result {
+		var header, trailer metadata.MD
+		_, r := testproto.NewEchoClient(cc).Echo(callCtx, &testproto.UnaryReq{Msg: msg}, grpc.Header(&header), grpc.Trailer(&trailer))
+		return result{header: filter(header), trailer: filter(trailer), code: status.Code(r)}
+	}
+	stream := func(cc grpc.ClientConnInterface) result {
+		var header, trailer metadata.MD
+		s, r := testproto.NewEchoClient(cc).Stream(callCtx, grpc.Header(&header), grpc.Trailer(&trailer))
+		asrt.NoErr(r)
+		asrt.NoErr(s.Send(&testproto.BiDiStreamReq{Msg: "Hello via NRPC"}))
+		asrt.NoErr(s.CloseSend())
+		streamHeader, r := s.Header()
+		asrt.NoErr(r)
+		for r == nil {
+			_, r = s.Recv()
+		}
+		asrt.True(errors.Is(r, io.EOF))
+		// the call options are filled once the stream ended
+		asrt.Equal(filter(streamHeader), filter(header))
+		asrt.Equal(filter(s.Trailer()), filter(trailer))
+		return result{header: filter(header), trailer: filter(trailer)}
+	}
+
+	client := nrpc.NewClient(pub, sub)
+	want := unary(grpcConn, "Hello via NRPC")
+	asrt.Equal(want.header, metadata.MD{
+		"x-key":      {"value-1", "value-2"},
+		"x-data-bin": {binValue},
+		"x-appended": {"appended"},
+		"x-upper":    {"upper"},
+	})
+	asrt.Equal(want.trailer["x-trailer-bin"], []string{string([]byte{0, 1, 0xff})})
+	asrt.Equal(unary(client, "Hello via NRPC"), want)
+
+	// the metadata of failed calls is available as well
+	want = unary(grpcConn, "fail")
+	asrt.Equal(want.code, codes.FailedPrecondition)
+	asrt.Equal(unary(client, "fail"), want)
+
+	asrt.Equal(stream(client), stream(grpcConn))
+}
+
+// headerServer implements the testproto.EchoServer interface. It sets the metadata with the functions
+// of the grpc package and reports the codes of calls expected to fail in the trailer.
+type headerServer struct {
+	testproto.UnimplementedEchoServer
+}
+
+func (headerServer) Echo(ctx context.Context, req *testproto.UnaryReq) (*testproto.UnaryResp, error) {
+	_ = grpc.SetHeader(ctx, metadata.Pairs("set", "1"))
+	_ = grpc.SendHeader(ctx, metadata.Pairs("sent", "1"))
+	_ = grpc.SetTrailer(ctx, metadata.Pairs("trailer", "1"))
+	_ = grpc.SetTrailer(ctx, metadata.Pairs("trailer", "2", "set-after-send", status.Code(grpc.SetHeader(ctx, metadata.Pairs("late", "1"))).String()))
+	return &testproto.UnaryResp{Msg: req.Msg}, nil
+}
+
+func (headerServer) Stream(stream testproto.Echo_StreamServer) error {
+	ctx := stream.Context()
+	_ = grpc.SetHeader(ctx, metadata.Pairs("set", "1"))
+	if r := grpc.SendHeader(ctx, metadata.Pairs("sent", "1")); r != nil {
+		return r
+	}
+	stream.SetTrailer(metadata.Pairs("trailer", "1"))
+	_ = grpc.SetTrailer(ctx, metadata.Pairs("trailer", "2", "send-twice", status.Code(stream.SendHeader(nil)).String()))
+
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if r := stream.Send(&testproto.BiDiStreamResp{Msg: req.Msg}); r != nil {
+			return r
+		}
+	}
+}
+
+func TestHeaderAndTrailer(t *testing.T) {
+	asrt := is.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	var (
+		m            sync.Mutex
+		headerFrames int
+		dataHeaders  int
+	)
+	tap := nrpc.WireTapFunc(func(_ context.Context, frame nrpc.Frame) {
+		if frame.Direction != nrpc.FrameSent || frame.Method != "/testproto.Echo/Stream" {
+			return
+		}
+		m.Lock()
+		defer m.Unlock()
+		switch {
+		case frame.Type == nrpc.FrameHeader:
+			headerFrames++
+		case frame.Type == nrpc.FrameData && len(frame.Header) != 0:
+			dataHeaders++
+		}
+	})
+
+	server := nrpc.NewServer(pub, sub, nrpc.WithWireTap(tap))
+	testproto.RegisterEchoServer(server, headerServer{})
+	asrt.NoErr(server.Run(ctx))
+	defer server.Stop()
+
+	client := testproto.NewEchoClient(nrpc.NewClient(pub, sub))
+
+	// unary: the header is merged and cannot be changed once sent, the trailer is merged
+	var header, trailer metadata.MD
+	_, err = client.Echo(ctx, &testproto.UnaryReq{Msg: "Hello via NRPC"}, grpc.Header(&header), grpc.Trailer(&trailer))
+	asrt.NoErr(err)
+	asrt.Equal(header.Get("set"), []string{"1"})
+	asrt.Equal(header.Get("sent"), []string{"1"})
+	asrt.Equal(len(header.Get("late")), 0)
+	asrt.Equal(trailer.Get("trailer"), []string{"1", "2"})
+	asrt.Equal(trailer.Get("set-after-send"), []string{codes.Internal.String()})
+
+	// stream: the header is sent before the first response
+	stream, err := client.Stream(ctx)
+	asrt.NoErr(err)
+	asrt.NoErr(stream.Send(&testproto.BiDiStreamReq{Msg: "Hello via NRPC"}))
+	header, err = stream.Header()
+	asrt.NoErr(err)
+	asrt.Equal(header.Get("set"), []string{"1"})
+	asrt.Equal(header.Get("sent"), []string{"1"})
+
+	resp, err := stream.Recv()
+	asrt.NoErr(err)
+	asrt.Equal(resp.Msg, "Hello via NRPC")
+	// the trailer is only sent at the end of the stream
+	asrt.Equal(len(stream.Trailer()), 0)
+
+	asrt.NoErr(stream.CloseSend())
+	_, err = stream.Recv()
+	asrt.True(errors.Is(err, io.EOF))
+	asrt.Equal(stream.Trailer().Get("trailer"), []string{"1", "2"})
+	asrt.Equal(stream.Trailer().Get("send-twice"), []string{codes.Internal.String()})
+
+	// the header is sent once with its own frame and not repeated with the responses
+	m.Lock()
+	defer m.Unlock()
+	asrt.Equal(headerFrames, 1)
+	asrt.Equal(dataHeaders, 0)
+}
+
+// trailersOnlyServer implements the testproto.EchoServer interface. Its stream fails before sending a
+// response, the header is only set if the client asks for it with the x-set-header metadata.
+type trailersOnlyServer struct {
+	testproto.UnimplementedEchoServer
+}
+
+func (trailersOnlyServer) Stream(stream testproto.Echo_StreamServer) error {
+	if md, _ := metadata.FromIncomingContext(stream.Context()); len(md.Get("x-set-header")) != 0 {
+		_ = stream.SetHeader(metadata.Pairs("x-header", "h-value"))
+	}
+	stream.SetTrailer(metadata.Pairs("x-trailer", "t-value"))
+	return status.Error(codes.PermissionDenied, "denied")
+}
+
+func TestTrailersOnly(t *testing.T) {
+	asrt := is.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	var (
+		m      sync.Mutex
+		frames []nrpc.FrameType
+	)
+	tap := nrpc.WireTapFunc(func(_ context.Context, frame nrpc.Frame) {
+		// the handshake answers the request opening the stream
+		if frame.Direction != nrpc.FrameSent || frame.Method != "/testproto.Echo/Stream" || frame.Type == nrpc.FrameHandshake {
+			return
+		}
+		m.Lock()
+		defer m.Unlock()
+		frames = append(frames, frame.Type)
+	})
+
+	server := nrpc.NewServer(pub, sub, nrpc.WithWireTap(tap))
+	testproto.RegisterEchoServer(server, trailersOnlyServer{})
+	asrt.NoErr(server.Run(ctx))
+	defer server.Stop()
+
+	// the same service served by grpc for comparison
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	asrt.NoErr(err)
+	grpcServer := grpc.NewServer()
+	testproto.RegisterEchoServer(grpcServer, trailersOnlyServer{})
+	go func() { _ = grpcServer.Serve(lis) }()
+	defer grpcServer.Stop()
+	grpcConn, err := grpc.DialContext(ctx, lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	asrt.NoErr(err)
+	defer grpcConn.Close()
+
+	type result struct {
+		header, trailer metadata.MD
+		headerErr       error
+		code            codes.Code
+		message         string
+		eof, sendEOF    bool
+	}
+	call := func(cc grpc.ClientConnInterface, md ...string) result {
+		s, r := testproto.NewEchoClient(cc).Stream(metadata.AppendToOutgoingContext(ctx, md...))
+		asrt.NoErr(r)
+		asrt.NoErr(s.Send(&testproto.BiDiStreamReq{Msg: "Hello via NRPC"}))
+
+		// the header is available once the status was received
+		var res result
+		header, headerErr := s.Header()
+		res.header, res.headerErr = metadata.MD{}, headerErr
+		_, r = s.Recv()
+		res.eof = errors.Is(r, io.EOF)
+		res.code, res.message = status.Code(r), status.Convert(r).Message()
+		res.sendEOF = errors.Is(s.Send(&testproto.BiDiStreamReq{Msg: "Hello via NRPC"}), io.EOF)
+		if v := header.Get("x-header"); len(v) != 0 {
+			res.header.Set("x-header", v...)
+		}
+		res.trailer = metadata.Pairs("x-trailer", s.Trailer().Get("x-trailer")[0])
+		return res
+	}
+
+	client := nrpc.NewClient(pub, sub)
+	want := call(grpcConn)
+	asrt.True(!want.eof)
+	asrt.Equal(want.code, codes.PermissionDenied)
+	asrt.Equal(call(client), want)
+
+	// the error is sent with a single frame carrying the status and the trailer
+	m.Lock()
+	asrt.Equal(frames, []nrpc.FrameType{nrpc.FrameEOS})
+	frames = nil
+	m.Unlock()
+
+	// a header set before the error is sent along with the status
+	want = call(grpcConn, "x-set-header", "1")
+	asrt.Equal(want.header.Get("x-header"), []string{"h-value"})
+	asrt.Equal(call(client, "x-set-header", "1"), want)
+
+	m.Lock()
+	defer m.Unlock()
+	asrt.Equal(frames, []nrpc.FrameType{nrpc.FrameEOS})
+}
+
+func TestTrailerOnCancel(t *testing.T) {
+	asrt := is.New(t)
+	ctxMain, cancelMain := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelMain()
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	server := nrpc.NewServer(pub, sub)
+	testproto.RegisterEchoServer(server, metadataServer{})
+	asrt.NoErr(server.Run(ctxMain))
+	defer server.Stop()
+
+	client := testproto.NewEchoClient(nrpc.NewClient(pub, sub))
+
+	ctx, cancel := context.WithCancel(ctxMain)
+	defer cancel()
+
+	var trailer metadata.MD
+	stream, err := client.Stream(ctx, grpc.Trailer(&trailer))
+	asrt.NoErr(err)
+	asrt.NoErr(stream.Send(&testproto.BiDiStreamReq{Msg: "Hello via NRPC"}))
+	_, err = stream.Recv()
+	asrt.NoErr(err)
+
+	// the server ends the canceled stream with its trailer, which is received before Recv returns
+	cancel()
+	_, err = stream.Recv()
+	asrt.Equal(status.Code(err), codes.Canceled)
+	asrt.Equal(stream.Trailer().Get("x-trailer"), []string{"t-value"})
+	asrt.Equal(trailer.Get("x-trailer"), []string{"t-value"})
+}
+
+func TestCodec(t *testing.T) {
+	asrt := is.New(t)
+	ctxMain := context.Background()
+	logger := nrpc.StandardLogger{}
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	_, _, err = testserver.New(pub, sub, nrpc.WithLogger(logger))
+	asrt.NoErr(err)
+	client := testclient.New(pub, sub, nrpc.WithLogger(logger))
+
+	t.Run("unary", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		resp, err := client.Unary(ctx, &testproto.UnaryReq{
+			Msg: "Hello via NRPC",
+		}, grpc.CallContentSubtype(json.Name))
+		asrt.NoErr(err)
+		asrt.Equal(resp.Msg, "Hello back!")
+	})
+	t.Run("bidi stream", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		stream, err := client.BiDiStream(ctx, grpc.ForceCodec(json.Codec{}))
+		asrt.NoErr(err)
+
+		for i := 0; i < 5; i++ {
+			r := stream.Send(&testproto.BiDiStreamReq{
+				Msg: fmt.Sprintf("Hello via NRPC %d", i+1),
+			})
+			asrt.NoErr(r)
+
+			resp, r := stream.Recv()
+			asrt.NoErr(r)
+			asrt.Equal(resp.Msg, fmt.Sprintf("Hello back! %d", i+1))
+		}
+		asrt.NoErr(stream.CloseSend())
+
+		_, err = stream.Recv()
+		asrt.True(errors.Is(err, io.EOF))
+	})
+	t.Run("stream error", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		stream, err := client.ServerStream(ctx, &testproto.ServerStreamReq{
+			Msg: "error",
+		}, grpc.CallContentSubtype(json.Name))
+		asrt.NoErr(err)
+
+		_, err = stream.Recv()
+		asrt.Equal(status.Code(err), codes.InvalidArgument)
+	})
+	t.Run("unknown codec", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		_, err := client.Unary(ctx, &testproto.UnaryReq{
+			Msg: "Hello via NRPC",
+		}, grpc.CallContentSubtype("unknown"))
+		asrt.Equal(status.Code(err), codes.Internal)
+	})
+}
+
+func TestDeadline(t *testing.T) {
+	asrt := is.New(t)
+	ctxMain := context.Background()
+	logger := nrpc.StandardLogger{}
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	deadlines := make(chan time.Time, 1)
+	unaryInt := func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		deadline, _ := ctx.Deadline()
+		deadlines <- deadline
+		return handler(ctx, req)
+	}
+	streamInt := func(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		deadline, _ := ss.Context().Deadline()
+		deadlines <- deadline
+		return handler(srv, ss)
+	}
+
+	_, _, err = testserver.New(pub, sub, nrpc.WithLogger(logger),
+		nrpc.UnaryInterceptor(unaryInt),
+		nrpc.StreamInterceptor(streamInt),
+	)
+	asrt.NoErr(err)
+	client := testclient.New(pub, sub, nrpc.WithLogger(logger))
+
+	t.Run("unary", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+		ctx = metadata.NewOutgoingContext(ctx, metadata.New(map[string]string{"heady": "head1"}))
+
+		_, err := client.Unary(ctx, &testproto.UnaryReq{
+			Msg: "Hello via NRPC",
+		})
+		asrt.NoErr(err)
+
+		clientDeadline, _ := ctx.Deadline()
+		serverDeadline := <-deadlines
+		asrt.True(!serverDeadline.IsZero())
+		// the timeout is transmitted relative to the time of sending
+		asrt.True(serverDeadline.Sub(clientDeadline) < 100*time.Millisecond)
+	})
+	t.Run("stream", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+		ctx = metadata.NewOutgoingContext(ctx, metadata.New(map[string]string{"heady": "head1"}))
+
+		stream, err := client.ServerStream(ctx, &testproto.ServerStreamReq{
+			Msg: "Hello via NRPC",
+		})
+		asrt.NoErr(err)
+		for {
+			if _, r := stream.Recv(); r != nil {
+				asrt.True(errors.Is(r, io.EOF))
+				break
+			}
+		}
+
+		clientDeadline, _ := ctx.Deadline()
+		serverDeadline := <-deadlines
+		asrt.True(!serverDeadline.IsZero())
+		// the timeout is transmitted relative to the time of sending
+		asrt.True(serverDeadline.Sub(clientDeadline) < 100*time.Millisecond)
+	})
+}
+
+func TestHandlerTimeout(t *testing.T) {
+	asrt := is.New(t)
+	ctxMain := context.Background()
+	logger := nrpc.StandardLogger{}
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	// the interceptors ignore the context like runaway handlers
+	unaryInt := func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		time.Sleep(100 * time.Millisecond)
+		return handler(ctx, req)
+	}
+	streamInt := func(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		time.Sleep(100 * time.Millisecond)
+		return handler(srv, ss)
+	}
+
+	_, _, err = testserver.New(pub, sub, nrpc.WithLogger(logger),
+		nrpc.UnaryInterceptor(unaryInt),
+		nrpc.StreamInterceptor(streamInt),
+		nrpc.WithHandlerTimeout(50*time.Millisecond),
+		nrpc.WithHandlerTimeout(time.Minute, "/testproto.Test/ServerStream"),
+	)
+	asrt.NoErr(err)
+	client := testclient.New(pub, sub, nrpc.WithLogger(logger))
+
+	t.Run("unary without deadline", func(t *testing.T) {
+		asrt := asrt.New(t)
+
+		_, err := client.Unary(ctxMain, &testproto.UnaryReq{Msg: "Hello via NRPC"})
+		asrt.Equal(status.Code(err), codes.DeadlineExceeded)
+	})
+	t.Run("stream without deadline", func(t *testing.T) {
+		asrt := asrt.New(t)
+
+		stream, err := client.BiDiStream(ctxMain)
+		asrt.NoErr(err)
+		asrt.NoErr(stream.Send(&testproto.BiDiStreamReq{Msg: "Hello via NRPC 1"}))
+		// the handler might still answer the message before it notices the deadline
+		for err == nil {
+			_, err = stream.Recv()
+		}
+		asrt.Equal(status.Code(err), codes.DeadlineExceeded)
+	})
+	t.Run("method override", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		stream, err := client.ServerStream(ctx, &testproto.ServerStreamReq{Msg: "Hello via NRPC"})
+		asrt.NoErr(err)
+		for {
+			if _, r := stream.Recv(); r != nil {
+				asrt.True(errors.Is(r, io.EOF))
+				break
+			}
+		}
+	})
+}
+
+func TestCancel(t *testing.T) {
+	asrt := is.New(t)
+	ctxMain := context.Background()
+	logger := nrpc.StandardLogger{}
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	// the interceptors block until the call is canceled and report the reason
+	started := make(chan struct{}, 1)
+	canceled := make(chan error, 1)
+	unaryInt := func(ctx context.Context, _ interface{}, _ *grpc.UnaryServerInfo, _ grpc.UnaryHandler) (interface{}, error) {
+		started <- struct{}{}
+		<-ctx.Done()
+		canceled <- ctx.Err()
+		return nil, ctx.Err()
+	}
+	streamInt := func(_ interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, _ grpc.StreamHandler) error {
+		started <- struct{}{}
+		<-ss.Context().Done()
+		canceled <- ss.Context().Err()
+		return ss.Context().Err()
+	}
+
+	_, _, err = testserver.New(pub, sub, nrpc.WithLogger(logger),
+		nrpc.UnaryInterceptor(unaryInt),
+		nrpc.StreamInterceptor(streamInt),
+	)
+	asrt.NoErr(err)
+	client := testclient.New(pub, sub, nrpc.WithLogger(logger))
+
+	t.Run("unary", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 5*time.Second)
+		defer cancel()
+
+		go func() {
+			<-started
+			cancel()
+		}()
+		_, err := client.Unary(ctx, &testproto.UnaryReq{
+			Msg: "Hello via NRPC",
+		})
+		asrt.Equal(status.Code(err), codes.Canceled)
+
+		select {
+		case r := <-canceled:
+			asrt.True(errors.Is(r, context.Canceled))
+		case <-time.After(time.Second):
+			t.Fatal("server call was not canceled")
+		}
+	})
+	t.Run("stream", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 5*time.Second)
+		defer cancel()
+
+		_, err := client.ServerStream(ctx, &testproto.ServerStreamReq{
+			Msg: "Hello via NRPC",
+		})
+		asrt.NoErr(err)
+		<-started
+		cancel()
+
+		select {
+		case r := <-canceled:
+			asrt.True(errors.Is(r, context.Canceled))
+		case <-time.After(time.Second):
+			t.Fatal("server stream was not canceled")
+		}
+	})
+}
+
+func TestStatus(t *testing.T) {
+	asrt := is.New(t)
+	ctxMain := context.Background()
+	logger := nrpc.StandardLogger{}
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	errStatus, err := status.New(codes.NotFound, "not found").WithDetails(
+		&errdetails.ErrorInfo{
+			Reason: "MISSING",
+			Domain: "nrpc",
+		},
+		&errdetails.BadRequest{FieldViolations: []*errdetails.BadRequest_FieldViolation{
+			{Field: "msg", Description: "unknown message"},
+		}},
+		&errdetails.RetryInfo{RetryDelay: durationpb.New(time.Second)},
+	)
+	asrt.NoErr(err)
+
+	unaryInt := func(_ context.Context, _ interface{}, _ *grpc.UnaryServerInfo, _ grpc.UnaryHandler) (interface{}, error) {
+		return nil, errStatus.Err()
+	}
+	streamInt := func(_ interface{}, _ grpc.ServerStream, _ *grpc.StreamServerInfo, _ grpc.StreamHandler) error {
+		return errStatus.Err()
+	}
+
+	_, _, err = testserver.New(pub, sub, nrpc.WithLogger(logger),
+		nrpc.UnaryInterceptor(unaryInt),
+		nrpc.StreamInterceptor(streamInt),
+	)
+	asrt.NoErr(err)
+	client := testclient.New(pub, sub, nrpc.WithLogger(logger))
+
+	assertStatus := func(asrt *is.I, err error) {
+		st, ok := status.FromError(err)
+		asrt.True(ok)
+		asrt.Equal(st.Code(), codes.NotFound)
+		asrt.Equal(st.Message(), "not found")
+		// the typed details are available to the client
+		asrt.Equal(len(st.Details()), 3)
+		info, ok := st.Details()[0].(*errdetails.ErrorInfo)
+		asrt.True(ok)
+		asrt.Equal(info.Reason, "MISSING")
+		badReq, ok := st.Details()[1].(*errdetails.BadRequest)
+		asrt.True(ok)
+		asrt.Equal(badReq.FieldViolations[0].Field, "msg")
+		retryInfo, ok := st.Details()[2].(*errdetails.RetryInfo)
+		asrt.True(ok)
+		asrt.Equal(retryInfo.RetryDelay.AsDuration(), time.Second)
+	}
+
+	t.Run("unary details", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		_, err := client.Unary(ctx, &testproto.UnaryReq{Msg: "Hello via NRPC"})
+		assertStatus(asrt, err)
+	})
+	t.Run("stream details", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		stream, err := client.ServerStream(ctx, &testproto.ServerStreamReq{Msg: "Hello via NRPC"})
+		asrt.NoErr(err)
+
+		_, err = stream.Recv()
+		assertStatus(asrt, err)
+	})
+	t.Run("deadline exceeded", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, time.Nanosecond)
+		defer cancel()
+		<-ctx.Done()
+
+		_, err := client.Unary(ctx, &testproto.UnaryReq{Msg: "Hello via NRPC"})
+		asrt.Equal(status.Code(err), codes.DeadlineExceeded)
+	})
+	t.Run("unavailable", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		// no server is subscribed to the subject
+		err := nrpc.NewClient(pub, sub).Invoke(ctx, "/unknown.Service/Method", &testproto.UnaryReq{}, &testproto.UnaryResp{})
+		asrt.Equal(status.Code(err), codes.Unavailable)
+	})
+}
+
+func TestRetry(t *testing.T) {
+	asrt := is.New(t)
+	ctxMain := context.Background()
+	logger := nrpc.StandardLogger{}
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	// the interceptor fails the given number of attempts of each call with Unavailable, asking
+	// the client to retry after the pushback if it is set
+	var (
+		m        sync.Mutex
+		attempts int
+		failures int
+		pushback time.Duration
+	)
+	reset := func(fail int) {
+		m.Lock()
+		attempts, failures, pushback = 0, fail, 0
+		m.Unlock()
+	}
+	unaryInt := func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		m.Lock()
+		attempts++
+		fail := attempts <= failures
+		delay := pushback
+		m.Unlock()
+
+		if !fail {
+			return handler(ctx, req)
+		}
+		st := status.New(codes.Unavailable, "try again")
+		if delay != 0 {
+			st, _ = st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(delay)})
+		}
+		return nil, st.Err()
+	}
+
+	_, _, err = testserver.New(pub, sub, nrpc.WithLogger(logger), nrpc.UnaryInterceptor(unaryInt))
+	asrt.NoErr(err)
+	client := testclient.New(pub, sub, nrpc.WithLogger(logger), nrpc.WithRetryPolicy(nrpc.RetryPolicy{
+		MaxAttempts:       4,
+		InitialBackoff:    10 * time.Millisecond,
+		MaxBackoff:        50 * time.Millisecond,
+		BackoffMultiplier: 2,
+	}))
+	getAttempts := func() int {
+		m.Lock()
+		defer m.Unlock()
+		return attempts
+	}
+
+	t.Run("retried", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+		ctx = metadata.NewOutgoingContext(ctx, metadata.New(map[string]string{"heady": "head1"}))
+		reset(2)
+
+		resp, err := client.Unary(ctx, &testproto.UnaryReq{Msg: "Hello via NRPC"})
+		asrt.NoErr(err)
+		asrt.Equal(resp.Msg, "Hello back!")
+		asrt.Equal(getAttempts(), 3)
+	})
+	t.Run("attempts exhausted", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+		reset(10)
+
+		_, err := client.Unary(ctx, &testproto.UnaryReq{Msg: "Hello via NRPC"})
+		asrt.Equal(status.Code(err), codes.Unavailable)
+		asrt.Equal(getAttempts(), 4)
+	})
+	t.Run("not retryable", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+		reset(0)
+
+		_, err := client.Unary(ctx, &testproto.UnaryReq{Msg: "error"})
+		asrt.Equal(status.Code(err), codes.InvalidArgument)
+		asrt.Equal(getAttempts(), 1)
+	})
+	t.Run("disabled per call", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+		reset(1)
+
+		_, err := client.Unary(ctx, &testproto.UnaryReq{Msg: "Hello via NRPC"}, nrpc.Retry(nrpc.RetryPolicy{}))
+		asrt.Equal(status.Code(err), codes.Unavailable)
+		asrt.Equal(getAttempts(), 1)
+	})
+	t.Run("server pushback", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+		reset(1)
+		m.Lock()
+		pushback = 200 * time.Millisecond
+		m.Unlock()
+
+		// the retry info replaces the backoff of at most 10ms
+		start := time.Now()
+		_, err := client.Unary(ctx, &testproto.UnaryReq{Msg: "Hello via NRPC"})
+		asrt.NoErr(err)
+		asrt.True(time.Since(start) >= 200*time.Millisecond)
+		asrt.Equal(getAttempts(), 2)
+
+		// the retry info is available to the caller
+		reset(1)
+		m.Lock()
+		pushback = 200 * time.Millisecond
+		m.Unlock()
+		_, err = client.Unary(ctx, &testproto.UnaryReq{Msg: "Hello via NRPC"}, nrpc.Retry(nrpc.RetryPolicy{}))
+		details := status.Convert(err).Details()
+		asrt.Equal(len(details), 1)
+		info, ok := details[0].(*errdetails.RetryInfo)
+		asrt.True(ok)
+		asrt.Equal(info.RetryDelay.AsDuration(), 200*time.Millisecond)
+	})
+}
+
+func TestServiceConfig(t *testing.T) {
+	asrt := is.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, js, shutdown, err := testproto.NewTestJetStreamConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	// the interceptor fails the given number of attempts of each call with Unavailable and holds
+	// back slow calls until they are canceled
+	var attempts, failures int32
+	unaryInt := func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if req.(*testproto.UnaryReq).Msg == "slow" {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		if atomic.AddInt32(&attempts, 1) <= atomic.LoadInt32(&failures) {
+			return nil, status.Error(codes.Unavailable, "try again")
+		}
+		return handler(ctx, req)
+	}
+	reset := func(fail int32) {
+		atomic.StoreInt32(&attempts, 0)
+		atomic.StoreInt32(&failures, fail)
+	}
+	_, _, err = testserver.New(pub, sub, nrpc.UnaryInterceptor(unaryInt))
+	asrt.NoErr(err)
+
+	doc := []byte(`{
+		"methodConfig": [{
+			"name": [{"service": "testproto.Test", "method": "Unary"}],
+			"timeout": "0.1s",
+			"retryPolicy": {
+				"maxAttempts": 3,
+				"initialBackoff": "0.01s",
+				"maxBackoff": "0.01s",
+				"backoffMultiplier": 1,
+				"retryableStatusCodes": ["UNAVAILABLE"]
+			}
+		}, {
+			"name": [{"service": "testproto.Test"}],
+			"maxRequestMessageBytes": 64
+		}]
+	}`)
+	path := filepath.Join(t.TempDir(), "service_config.json")
+	asrt.NoErr(os.WriteFile(path, doc, 0o600))
+	cfg, err := nrpc.LoadServiceConfig(path)
+	asrt.NoErr(err)
+
+	for _, invalid := range []string{
+		`{"methodConfig": [{"name": [{"service": "foo.Foo"}], "timeout": "soon"}]}`,
+		`{"methodConfig": [{"name": [{"service": "foo.Foo"}]}, {"name": [{"service": "foo.Foo"}]}]}`,
+		`{"methodConfig": [{"name": [{"method": "Bar"}]}]}`,
+		`{"methodConfig": [{"name": [{}], "retryPolicy": {}, "hedgingPolicy": {}}]}`,
+	} {
+		_, err := nrpc.ParseServiceConfig([]byte(invalid))
+		asrt.True(err != nil)
+	}
+
+	client := testclient.New(pub, sub, nrpc.WithServiceConfig(cfg))
+
+	reset(2)
+	resp, err := client.Unary(ctx, &testproto.UnaryReq{Msg: "Hello via NRPC"})
+	asrt.NoErr(err)
+	asrt.Equal(resp.Msg, "Hello back!")
+	asrt.Equal(atomic.LoadInt32(&attempts), int32(3))
+
+	start := time.Now()
+	_, err = client.Unary(ctx, &testproto.UnaryReq{Msg: "slow"})
+	asrt.Equal(status.Code(err), codes.DeadlineExceeded)
+	asrt.True(time.Since(start) < time.Second)
+
+	// the config of the method takes precedence over the one of the service: the request is sent and
+	// rejected by the server for its content
+	_, err = client.Unary(ctx, &testproto.UnaryReq{Msg: strings.Repeat("x", 128)})
+	asrt.Equal(status.Code(err), codes.InvalidArgument)
+	stream, err := client.ServerStream(ctx, &testproto.ServerStreamReq{Msg: strings.Repeat("x", 128)})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	asrt.Equal(status.Code(err), codes.ResourceExhausted)
+
+	t.Run("key-value bucket", func(t *testing.T) {
+		asrt := asrt.New(t)
+
+		kv, err := js.CreateKeyValue(&natsgo.KeyValueConfig{Bucket: "nrpc-config"})
+		asrt.NoErr(err)
+		_, err = kv.Put("client", doc)
+		asrt.NoErr(err)
+		fetched, err := jetstream.FetchServiceConfig(kv, "client")
+		asrt.NoErr(err)
+		asrt.True(fetched != nil)
+
+		nrpcClient := nrpc.NewClient(pub, sub)
+		errs := make(chan error, 1)
+		asrt.NoErr(jetstream.WatchServiceConfig(ctx, kv, "client", nrpcClient, func(err error) { errs <- err }))
+		client := testproto.NewTestClient(nrpcClient)
+
+		reset(2)
+		_, err = client.Unary(ctx, &testproto.UnaryReq{Msg: "Hello via NRPC"})
+		asrt.NoErr(err)
+		asrt.Equal(atomic.LoadInt32(&attempts), int32(3))
+
+		// updates are applied in order, so the valid update is applied once the invalid one was reported
+		_, err = kv.Put("client", []byte(`{}`))
+		asrt.NoErr(err)
+		_, err = kv.Put("client", []byte(`{"methodConfig": 1}`))
+		asrt.NoErr(err)
+		asrt.True(<-errs != nil)
+
+		reset(2)
+		_, err = client.Unary(ctx, &testproto.UnaryReq{Msg: "Hello via NRPC"})
+		asrt.Equal(status.Code(err), codes.Unavailable)
+		asrt.Equal(atomic.LoadInt32(&attempts), int32(1))
+	})
+}
+
+func TestLiveConfig(t *testing.T) {
+	asrt := is.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, js, shutdown, err := testproto.NewTestJetStreamConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	// slow calls are held back until they are canceled
+	slowInt := func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if req.(*testproto.UnaryReq).Msg == "slow" {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return handler(ctx, req)
+	}
+	serverLog := &recordingLogger{}
+	log := nrpc.NewLevelLogger(serverLog, nrpc.LevelInfo)
+	limiter := ratelimit.New()
+	server, _, err := testserver.New(pub, sub, append(limiter.ServerOptions(), nrpc.WithLogger(log),
+		nrpc.UnaryInterceptor(slowInt))...)
+	asrt.NoErr(err)
+	defer server.Stop()
+	nrpcClient := nrpc.NewClient(pub, sub)
+	client := testproto.NewTestClient(nrpcClient)
+
+	kv, err := js.CreateKeyValue(&natsgo.KeyValueConfig{Bucket: "nrpc-live"})
+	asrt.NoErr(err)
+	_, err = kv.Put("server", []byte(`{
+		"logLevel": "debug",
+		"handlerTimeouts": {"testproto.Test": "50ms"},
+		"rateLimits": {"/testproto.Test/Unary": {"rate": 0.001, "burst": 1}},
+		"serviceConfig": {"methodConfig": [{"name": [{}], "waitForReady": true}]},
+		"feature": "enabled"
+	}`))
+	asrt.NoErr(err)
+
+	changes := make(chan *liveconfig.Config, 1)
+	errs := make(chan error, 1)
+	watcher := liveconfig.New(liveconfig.WithServer(server), liveconfig.WithClient(nrpcClient),
+		liveconfig.WithLimiter(limiter), liveconfig.WithLogger(log),
+		liveconfig.OnChange(func(cfg *liveconfig.Config) { changes <- cfg }),
+		liveconfig.OnError(func(err error) { errs <- err }))
+	asrt.NoErr(watcher.Watch(ctx, kv, "server"))
+
+	cfg := <-changes
+	asrt.Equal(*cfg.LogLevel, nrpc.LevelDebug)
+	asrt.True(cfg.ServiceConfig != nil)
+	var app struct {
+		Feature string `json:"feature"`
+	}
+	asrt.NoErr(stdjson.Unmarshal(cfg.Document, &app))
+	asrt.Equal(app.Feature, "enabled")
+
+	_, err = client.Unary(ctx, &testproto.UnaryReq{Msg: "Hello via NRPC"})
+	asrt.NoErr(err)
+	asrt.True(serverLog.contains("DEBUG"))
+	_, err = client.Unary(ctx, &testproto.UnaryReq{Msg: "Hello via NRPC"})
+	asrt.Equal(status.Code(err), codes.ResourceExhausted)
+	_, err = client.Unary(ctx, &testproto.UnaryReq{Msg: "slow"})
+	asrt.Equal(status.Code(err), codes.DeadlineExceeded)
+
+	// the update lifts the rate limit and raises the log level, the handler timeouts stay unchanged
+	_, err = kv.Put("server", []byte(`{"logLevel": "error", "rateLimits": {}}`))
+	asrt.NoErr(err)
+	<-changes
+	asrt.Equal(log.Level(), nrpc.LevelError)
+
+	serverLog.m.Lock()
+	logged := len(serverLog.entries)
+	serverLog.m.Unlock()
+	_, err = client.Unary(ctx, &testproto.UnaryReq{Msg: "Hello via NRPC"})
+	asrt.NoErr(err)
+	_, err = client.Unary(ctx, &testproto.UnaryReq{Msg: "slow"})
+	asrt.Equal(status.Code(err), codes.DeadlineExceeded)
+	serverLog.m.Lock()
+	asrt.Equal(len(serverLog.entries), logged)
+	serverLog.m.Unlock()
+
+	// invalid documents are reported and not applied
+	_, err = kv.Put("server", []byte(`{"logLevel": "loud"}`))
+	asrt.NoErr(err)
+	asrt.True(<-errs != nil)
+	asrt.Equal(log.Level(), nrpc.LevelError)
+}
+
+func TestIdempotency(t *testing.T) {
+	asrt := is.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	var failing, calls int32
+	server := nrpc.NewServer(pub, sub, nrpc.WithIdempotency(nil, time.Minute))
+	testproto.RegisterEchoServer(server, flakyServer{failing: &failing, calls: &calls})
+	asrt.NoErr(server.Run(ctx))
+	defer server.Stop()
+
+	t.Run("failed calls are executed again", func(t *testing.T) {
+		asrt := is.New(t)
+		atomic.StoreInt32(&failing, 1)
+		defer atomic.StoreInt32(&failing, 0)
+		atomic.StoreInt32(&calls, 0)
+
+		client := testproto.NewEchoClient(nrpc.NewClient(pub, sub, nrpc.WithIdempotencyKeys(),
+			nrpc.WithRetryPolicy(nrpc.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond})))
+		_, err := client.Echo(ctx, &testproto.UnaryReq{Msg: "failing"})
+		asrt.Equal(status.Code(err), codes.Unavailable)
+		asrt.Equal(atomic.LoadInt32(&calls), int32(3))
+	})
+
+	t.Run("hedged calls are executed once", func(t *testing.T) {
+		asrt := is.New(t)
+		atomic.StoreInt32(&calls, 0)
+
+		client := testproto.NewEchoClient(nrpc.NewClient(pub, sub, nrpc.WithIdempotencyKeys("testproto.Echo"),
+			nrpc.WithHedgingPolicy(nrpc.HedgingPolicy{MaxAttempts: 3})))
+		resp, err := client.Echo(ctx, &testproto.UnaryReq{Msg: "hedged"})
+		asrt.NoErr(err)
+		asrt.Equal(resp.Msg, "hedged")
+
+		// wait for the outstanding hedged requests to be answered
+		time.Sleep(50 * time.Millisecond)
+		asrt.Equal(atomic.LoadInt32(&calls), int32(1))
+	})
+
+	t.Run("calls with the same key replay the response", func(t *testing.T) {
+		asrt := is.New(t)
+		atomic.StoreInt32(&calls, 0)
+
+		client := testproto.NewEchoClient(nrpc.NewClient(pub, sub))
+		resp, err := client.Echo(ctx, &testproto.UnaryReq{Msg: "first"}, nrpc.IdempotencyKey("key"))
+		asrt.NoErr(err)
+		asrt.Equal(resp.Msg, "first")
+		resp, err = client.Echo(ctx, &testproto.UnaryReq{Msg: "second"}, nrpc.IdempotencyKey("key"))
+		asrt.NoErr(err)
+		asrt.Equal(resp.Msg, "first")
+		asrt.Equal(atomic.LoadInt32(&calls), int32(1))
+
+		// calls without or with another key are executed
+		resp, err = client.Echo(ctx, &testproto.UnaryReq{Msg: "third"})
+		asrt.NoErr(err)
+		asrt.Equal(resp.Msg, "third")
+		resp, err = client.Echo(ctx, &testproto.UnaryReq{Msg: "fourth"}, nrpc.IdempotencyKey("other"))
+		asrt.NoErr(err)
+		asrt.Equal(resp.Msg, "fourth")
+		asrt.Equal(atomic.LoadInt32(&calls), int32(3))
+	})
+}
+
+func TestIdempotencyAuth(t *testing.T) {
+	asrt := is.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	// the authorization header names the user
+	authenticator := nrpc.AuthenticatorFunc(func(ctx context.Context, _ string) (context.Context, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		users := md.Get("authorization")
+		if len(users) == 0 {
+			return nil, errors.New("missing credentials")
+		}
+		p, _ := nrpc.PeerFromContext(ctx)
+		peer := *p
+		peer.User = users[0]
+		return nrpc.NewContextWithPeer(ctx, &peer), nil
+	})
+
+	var failing, calls int32
+	server := nrpc.NewServer(pub, sub, nrpc.WithIdempotency(nil, time.Minute), nrpc.WithAuthenticator(authenticator))
+	testproto.RegisterEchoServer(server, flakyServer{failing: &failing, calls: &calls})
+	asrt.NoErr(server.Run(ctx))
+	defer server.Stop()
+
+	client := testproto.NewEchoClient(nrpc.NewClient(pub, sub))
+	ctxA := metadata.AppendToOutgoingContext(ctx, "authorization", "user-a")
+	resp, err := client.Echo(ctxA, &testproto.UnaryReq{Msg: "for user-a"}, nrpc.IdempotencyKey("key"))
+	asrt.NoErr(err)
+	asrt.Equal(resp.Msg, "for user-a")
+
+	// unauthenticated callers reusing the key do not get the stored response
+	_, err = client.Echo(ctx, &testproto.UnaryReq{Msg: "anonymous"}, nrpc.IdempotencyKey("key"))
+	asrt.Equal(status.Code(err), codes.Unauthenticated)
+
+	// other users reusing the key execute the call
+	ctxB := metadata.AppendToOutgoingContext(ctx, "authorization", "user-b")
+	resp, err = client.Echo(ctxB, &testproto.UnaryReq{Msg: "for user-b"}, nrpc.IdempotencyKey("key"))
+	asrt.NoErr(err)
+	asrt.Equal(resp.Msg, "for user-b")
+
+	// the user of the key gets the stored response
+	resp, err = client.Echo(ctxA, &testproto.UnaryReq{Msg: "again"}, nrpc.IdempotencyKey("key"))
+	asrt.NoErr(err)
+	asrt.Equal(resp.Msg, "for user-a")
+	asrt.Equal(atomic.LoadInt32(&calls), int32(2))
+}
+
+func TestHedging(t *testing.T) {
+	asrt := is.New(t)
+	ctxMain := context.Background()
+	logger := nrpc.StandardLogger{}
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	// the first attempt of a call hangs until it is canceled
+	var (
+		m        sync.Mutex
+		attempts int
+	)
+	canceled := make(chan error, 1)
+	unaryInt := func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		m.Lock()
+		attempts++
+		first := attempts == 1
+		m.Unlock()
+
+		if first {
+			<-ctx.Done()
+			canceled <- ctx.Err()
+			return nil, ctx.Err()
+		}
+		return handler(ctx, req)
+	}
+
+	_, _, err = testserver.New(pub, sub, nrpc.WithLogger(logger), nrpc.UnaryInterceptor(unaryInt))
+	asrt.NoErr(err)
+	client := testclient.New(pub, sub, nrpc.WithLogger(logger), nrpc.WithHedgingPolicy(nrpc.HedgingPolicy{
+		MaxAttempts:  3,
+		HedgingDelay: 50 * time.Millisecond,
+	}, "/testproto.Test/Unary"))
+
+	ctx, cancel := context.WithTimeout(ctxMain, 5*time.Second)
+	defer cancel()
+	ctx = metadata.NewOutgoingContext(ctx, metadata.New(map[string]string{"heady": "head1"}))
+
+	start := time.Now()
+	resp, err := client.Unary(ctx, &testproto.UnaryReq{Msg: "Hello via NRPC"})
+	asrt.NoErr(err)
+	asrt.Equal(resp.Msg, "Hello back!")
+	asrt.True(time.Since(start) < time.Second)
+
+	select {
+	case r := <-canceled:
+		asrt.True(errors.Is(r, context.Canceled))
+	case <-time.After(time.Second):
+		t.Fatal("hedged request was not canceled")
+	}
+}
+
+func TestGracefulStop(t *testing.T) {
+	asrt := is.New(t)
+	ctxMain := context.Background()
+	logger := nrpc.StandardLogger{}
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	// the interceptors signal the start of a call and hold it until released or canceled
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	unaryInt := func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		started <- struct{}{}
+		select {
+		case <-release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		return handler(ctx, req)
+	}
+	streamInt := func(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		started <- struct{}{}
+		<-ss.Context().Done()
+		return ss.Context().Err()
+	}
+
+	t.Run("drain", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+		ctx = metadata.NewOutgoingContext(ctx, metadata.New(map[string]string{"heady": "head1"}))
+
+		server, _, err := testserver.New(pub, sub, nrpc.WithLogger(logger), nrpc.UnaryInterceptor(unaryInt))
+		asrt.NoErr(err)
+		client := testclient.New(pub, sub, nrpc.WithLogger(logger))
+
+		chErr := make(chan error, 1)
+		go func() {
+			_, err := client.Unary(ctx, &testproto.UnaryReq{Msg: "Hello via NRPC"})
+			chErr <- err
+		}()
+		<-started
+
+		stopped := make(chan struct{})
+		go func() {
+			server.GracefulStop()
+			close(stopped)
+		}()
+
+		// the in-flight call finishes before the server stops
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+		asrt.NoErr(<-chErr)
+		<-stopped
+
+		// new calls are not accepted anymore
+		_, err = client.Unary(ctx, &testproto.UnaryReq{Msg: "Hello via NRPC"})
+		asrt.Equal(status.Code(err), codes.Unavailable)
+	})
+	t.Run("drain timeout", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+		ctx = metadata.NewOutgoingContext(ctx, metadata.New(map[string]string{"heady": "head1"}))
+
+		server, _, err := testserver.New(pub, sub, nrpc.WithLogger(logger),
+			nrpc.StreamInterceptor(streamInt), nrpc.WithDrainTimeout(100*time.Millisecond))
+		asrt.NoErr(err)
+		client := testclient.New(pub, sub, nrpc.WithLogger(logger))
+
+		stream, err := client.ServerStream(ctx, &testproto.ServerStreamReq{Msg: "Hello via NRPC"})
+		asrt.NoErr(err)
+		<-started
+
+		start := time.Now()
+		server.GracefulStop()
+		asrt.True(time.Since(start) < time.Second)
+
+		// the stream was canceled by the server
+		_, err = stream.Recv()
+		asrt.Equal(status.Code(err), codes.Canceled)
+	})
+}
+
+func TestUnknownServiceHandler(t *testing.T) {
+	asrt := is.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	methods := make(chan string, 10)
+	// the handler echoes the messages it does not know as unknown fields of empty messages
+	unknown := func(_ interface{}, stream grpc.ServerStream) error {
+		method, _ := grpc.MethodFromServerStream(stream)
+		_ = stream.SetHeader(metadata.Pairs("method", method))
+		for {
+			msg := &emptypb.Empty{}
+			if r := stream.RecvMsg(msg); errors.Is(r, io.EOF) {
+				return nil
+			} else if r != nil {
+				return r
+			}
+			if r := stream.SendMsg(msg); r != nil {
+				return r
+			}
+		}
+	}
+	streamInt := func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		methods <- info.FullMethod
+		return handler(srv, ss)
+	}
+	server := nrpc.NewServer(pub, sub, nrpc.UnknownServiceHandler(unknown), nrpc.StreamInterceptor(streamInt))
+	testproto.RegisterEchoServer(server, headerServer{})
+	asrt.NoErr(server.Run(ctx))
+	defer server.Stop()
+
+	client := testclient.New(pub, sub)
+
+	var header metadata.MD
+	resp, err := client.Unary(ctx, &testproto.UnaryReq{Msg: "Hello via NRPC"}, grpc.Header(&header))
+	asrt.NoErr(err)
+	asrt.Equal(resp.Msg, "Hello via NRPC")
+	asrt.Equal(header.Get("method"), []string{"/testproto.Test/Unary"})
+	asrt.Equal(<-methods, "/testproto.Test/Unary")
+
+	stream, err := client.BiDiStream(ctx)
+	asrt.NoErr(err)
+	for i := 1; i <= 3; i++ {
+		msg := fmt.Sprintf("Hello via NRPC %d", i)
+		asrt.NoErr(stream.Send(&testproto.BiDiStreamReq{Msg: msg}))
+		resp, err := stream.Recv()
+		asrt.NoErr(err)
+		asrt.Equal(resp.Msg, msg)
+	}
+	asrt.NoErr(stream.CloseSend())
+	_, err = stream.Recv()
+	asrt.True(errors.Is(err, io.EOF))
+	asrt.Equal(<-methods, "/testproto.Test/BiDiStream")
+
+	// registered methods are not passed to the unknown service handler
+	echo := testproto.NewEchoClient(nrpc.NewClient(pub, sub))
+	header = nil
+	_, err = echo.Echo(ctx, &testproto.UnaryReq{Msg: "Hello via NRPC"}, grpc.Header(&header))
+	asrt.NoErr(err)
+	asrt.Equal(header.Get("set"), []string{"1"})
+	asrt.Equal(len(methods), 0)
+}
+
+func TestBlobTransfer(t *testing.T) {
+	asrt := is.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	data := make([]byte, 100000)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+
+	// the first download is interrupted by the reader of the server failing, the client resumes the download
+	// by passing the offset it reached with the metadata
+	var downloads int32
+	download := func(_ interface{}, stream grpc.ServerStream) error {
+		if r := stream.RecvMsg(&emptypb.Empty{}); r != nil {
+			return r
+		}
+		md, _ := metadata.FromIncomingContext(stream.Context())
+		offset, err := strconv.ParseInt(md.Get("offset")[0], 10, 64)
+		if err != nil {
+			return err
+		}
+
+		var r io.Reader = bytes.NewReader(data)
+		if atomic.AddInt32(&downloads, 1) == 1 {
+			r = io.MultiReader(bytes.NewReader(data[:40000]), iotest.ErrReader(status.Error(codes.Unavailable, "disk failure")))
+		}
+		_, err = blob.SendReader(stream.Context(), stream, r, blob.WithChunkSize(8192), blob.WithOffset(offset))
+		return err
+	}
+	server := nrpc.NewServer(pub, sub, nrpc.UnknownServiceHandler(download))
+	asrt.NoErr(server.Run(ctx))
+	defer server.Stop()
+
+	client := nrpc.NewClient(pub, sub)
+	open := func(offset int64) grpc.ClientStream {
+		ctx := metadata.AppendToOutgoingContext(ctx, "offset", strconv.FormatInt(offset, 10))
+		stream, err := client.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, "/files.Files/Download")
+		asrt.NoErr(err)
+		asrt.NoErr(stream.SendMsg(&emptypb.Empty{}))
+		asrt.NoErr(stream.CloseSend())
+		return stream
+	}
+
+	var received bytes.Buffer
+	offset, err := blob.ReceiveWriter(ctx, open(0), &received)
+	asrt.Equal(status.Code(err), codes.Unavailable)
+	asrt.Equal(offset, int64(40000))
+
+	var progress []int64
+	offset, err = blob.ReceiveWriter(ctx, open(offset), &received, blob.WithOffset(offset),
+		blob.WithProgress(func(offset int64) { progress = append(progress, offset) }))
+	asrt.NoErr(err)
+	asrt.Equal(offset, int64(len(data)))
+	asrt.Equal(progress[0], int64(40000+8192))
+	asrt.Equal(progress[len(progress)-1], int64(len(data)))
+	asrt.True(bytes.Equal(received.Bytes(), data))
+
+	// chunks failing their checksum abort the transfer
+	corrupted := recvFunc(func(m interface{}) error {
+		chunk := m.(*nrpcpb.BlobChunk)
+		chunk.Data, chunk.Checksum = []byte("Hello via NRPC"), 1
+		return nil
+	})
+	offset, err = blob.ReceiveWriter(ctx, corrupted, io.Discard)
+	asrt.Equal(status.Code(err), codes.DataLoss)
+	asrt.Equal(offset, int64(0))
+}
+
+// recvFunc adapts a function to the RecvMsg method of streams.
+type recvFunc func(m interface{}) error
+
+func (f recvFunc) RecvMsg(m interface{}) error {
+	return f(m)
+}
+
+func TestHealth(t *testing.T) {
+	asrt := is.New(t)
+	ctxMain := context.Background()
+	logger := nrpc.StandardLogger{}
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	server, _, err := testserver.New(pub, sub, nrpc.WithLogger(logger))
+	asrt.NoErr(err)
+	client := healthpb.NewHealthClient(nrpc.NewClient(pub, sub, nrpc.WithLogger(logger)))
+
+	t.Run("check", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		for _, service := range []string{"", "testproto.Test"} {
+			resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+			asrt.NoErr(err)
+			asrt.Equal(resp.Status, healthpb.HealthCheckResponse_SERVING)
+		}
+
+		_, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: "unknown.Service"})
+		asrt.Equal(status.Code(err), codes.NotFound)
+	})
+	t.Run("watch", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		stream, err := client.Watch(ctx, &healthpb.HealthCheckRequest{Service: "testproto.Test"})
+		asrt.NoErr(err)
+
+		resp, err := stream.Recv()
+		asrt.NoErr(err)
+		asrt.Equal(resp.Status, healthpb.HealthCheckResponse_SERVING)
+
+		server.Health().SetServingStatus("testproto.Test", healthpb.HealthCheckResponse_NOT_SERVING)
+
+		resp, err = stream.Recv()
+		asrt.NoErr(err)
+		asrt.Equal(resp.Status, healthpb.HealthCheckResponse_NOT_SERVING)
+	})
+}
+
+func TestReflection(t *testing.T) {
+	asrt := is.New(t)
+	ctxMain := context.Background()
+	logger := nrpc.StandardLogger{}
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	server := nrpc.NewServer(pub, sub, nrpc.WithLogger(logger))
+	testproto.RegisterTestServer(server, &testserver.Server{})
+	reflection.Register(server)
+	asrt.NoErr(server.Run(ctxMain))
+	defer server.Stop()
+	client := nrpc.NewClient(pub, sub, nrpc.WithLogger(logger))
+
+	t.Run("v1alpha", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		stream, err := rpbalpha.NewServerReflectionClient(client).ServerReflectionInfo(ctx)
+		asrt.NoErr(err)
+
+		err = stream.Send(&rpbalpha.ServerReflectionRequest{
+			MessageRequest: &rpbalpha.ServerReflectionRequest_ListServices{},
+		})
+		asrt.NoErr(err)
+		resp, err := stream.Recv()
+		asrt.NoErr(err)
+
+		var services []string
+		for _, svc := range resp.GetListServicesResponse().GetService() {
+			services = append(services, svc.Name)
+		}
+		asrt.True(contains(services, "testproto.Test"))
+		asrt.True(contains(services, "grpc.health.v1.Health"))
+
+		err = stream.Send(&rpbalpha.ServerReflectionRequest{
+			MessageRequest: &rpbalpha.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: "testproto.Test"},
+		})
+		asrt.NoErr(err)
+		resp, err = stream.Recv()
+		asrt.NoErr(err)
+		asrt.True(len(resp.GetFileDescriptorResponse().GetFileDescriptorProto()) > 0)
+		asrt.NoErr(stream.CloseSend())
+	})
+	t.Run("v1", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		stream, err := rpb.NewServerReflectionClient(client).ServerReflectionInfo(ctx)
+		asrt.NoErr(err)
+
+		err = stream.Send(&rpb.ServerReflectionRequest{
+			MessageRequest: &rpb.ServerReflectionRequest_ListServices{},
+		})
+		asrt.NoErr(err)
+		resp, err := stream.Recv()
+		asrt.NoErr(err)
+
+		var services []string
+		for _, svc := range resp.GetListServicesResponse().GetService() {
+			services = append(services, svc.Name)
+		}
+		asrt.True(contains(services, "testproto.Test"))
+		asrt.True(contains(services, "grpc.reflection.v1.ServerReflection"))
+
+		err = stream.Send(&rpb.ServerReflectionRequest{
+			MessageRequest: &rpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: "unknown.Symbol"},
+		})
+		asrt.NoErr(err)
+		resp, err = stream.Recv()
+		asrt.NoErr(err)
+		asrt.Equal(codes.Code(resp.GetErrorResponse().GetErrorCode()), codes.NotFound)
+		asrt.NoErr(stream.CloseSend())
+	})
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func TestDynamicClient(t *testing.T) {
+	asrt := is.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	logger := nrpc.StandardLogger{}
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	server := nrpc.NewServer(pub, sub, nrpc.WithLogger(logger))
+	impl := &testserver.Server{}
+	impl.SetMsgCount(5)
+	testproto.RegisterTestServer(server, impl)
+	reflection.Register(server)
+	asrt.NoErr(server.Run(ctx))
+	defer server.Stop()
+	conn2 := nrpc.NewClient(pub, sub, nrpc.WithLogger(logger))
+	client := dynamic.NewClient(conn2, dynamic.NewReflectionSource(conn2))
+	ctx = metadata.AppendToOutgoingContext(ctx, "key", "value")
+
+	services, err := client.Source().Services(ctx)
+	asrt.NoErr(err)
+	asrt.True(contains(services, "testproto.Test"))
+
+	t.Run("unary", func(t *testing.T) {
+		asrt := asrt.New(t)
+
+		md, err := client.FindMethod(ctx, "/testproto.Test/Unary")
+		asrt.NoErr(err)
+		reqs, err := dynamic.ParseRequests(md, []byte(`{"msg": "Hello via NRPC"}`))
+		asrt.NoErr(err)
+		asrt.Equal(len(reqs), 1)
+
+		resp, err := client.Invoke(ctx, md, reqs[0])
+		asrt.NoErr(err)
+		asrt.Equal(resp.Get(md.Output().Fields().ByName("msg")).String(), "Hello back!")
+	})
+	t.Run("server stream", func(t *testing.T) {
+		asrt := asrt.New(t)
+
+		md, err := client.FindMethod(ctx, "testproto.Test.ServerStream")
+		asrt.NoErr(err)
+		reqs, err := dynamic.ParseRequests(md, []byte(`{"msg": "Hello via NRPC"}`))
+		asrt.NoErr(err)
+
+		stream, err := client.NewStream(ctx, md)
+		asrt.NoErr(err)
+		asrt.NoErr(stream.Send(reqs[0]))
+		asrt.NoErr(stream.CloseSend())
+
+		var msgs []string
+		for {
+			resp, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			asrt.NoErr(err)
+			msgs = append(msgs, resp.Get(md.Output().Fields().ByName("msg")).String())
+		}
+		asrt.Equal(msgs, []string{"Hello back! 1", "Hello back! 2", "Hello back! 3", "Hello back! 4", "Hello back! 5"})
+	})
+	t.Run("not a method", func(t *testing.T) {
+		asrt := asrt.New(t)
+
+		_, err := client.FindMethod(ctx, "testproto.UnaryReq")
+		asrt.True(err != nil)
+	})
+}
+
+func TestRelay(t *testing.T) {
+	asrt := is.New(t)
+	ctxMain := context.Background()
+	logger := nrpc.StandardLogger{}
+
+	// the service is served on cluster B and called on cluster A
+	connA, shutdownA, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdownA()
+	connB, shutdownB, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdownB()
+
+	pubA, subA := nats.Publisher(connA), nats.Subscriber(connA)
+	pubB, subB := nats.Publisher(connB), nats.Subscriber(connB)
+
+	target, _, err := testserver.New(pubB, subB, nrpc.WithLogger(logger))
+	asrt.NoErr(err)
+	defer target.Stop()
+
+	targetClient := nrpc.NewClient(pubB, subB, nrpc.WithLogger(logger))
+	r := relay.New(dynamic.NewClient(targetClient, dynamic.NewFilesSource(protoregistry.GlobalFiles)))
+	server := nrpc.NewServer(pubA, subA, nrpc.WithLogger(logger))
+	asrt.NoErr(r.Register(ctxMain, server, "testproto.Test"))
+	asrt.NoErr(server.Run(ctxMain))
+	defer server.Stop()
+
+	client := testclient.New(pubA, subA, nrpc.WithLogger(logger))
+
+	t.Run("unary", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+		ctx = metadata.NewOutgoingContext(ctx, metadata.Pairs("heady", "head1"))
+
+		var header, trailer metadata.MD
+		resp, err := client.Unary(ctx, &testproto.UnaryReq{Msg: "Hello via NRPC"}, grpc.Header(&header), grpc.Trailer(&trailer))
+		asrt.NoErr(err)
+		asrt.Equal(resp.Msg, "Hello back!")
+		asrt.Equal(header.Get("heady"), []string{"head1"})
+		asrt.Equal(header.Get("srv-key"), []string{"srv-value"})
+		asrt.Equal(trailer.Get("traily"), []string{"t-value"})
+
+		_, err = client.Unary(ctx, &testproto.UnaryReq{Msg: "invalid"})
+		asrt.Equal(status.Code(err), codes.InvalidArgument)
+	})
+	t.Run("server stream", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+		ctx = metadata.NewOutgoingContext(ctx, metadata.Pairs("heady", "head1"))
+
+		stream, err := client.ServerStream(ctx, &testproto.ServerStreamReq{Msg: "Hello via NRPC"})
+		asrt.NoErr(err)
+
+		var count int
+		for {
+			resp, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			asrt.NoErr(err)
+			count++
+			asrt.Equal(resp.Msg, fmt.Sprintf("Hello back! %d", count))
+		}
+		asrt.Equal(count, 5)
+
+		header, err := stream.Header()
+		asrt.NoErr(err)
+		asrt.Equal(header.Get("srv-key"), []string{"srv-value"})
+		asrt.Equal(stream.Trailer().Get("traily"), []string{"t-value"})
+	})
+	t.Run("client stream", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+		ctx = metadata.NewOutgoingContext(ctx, metadata.Pairs("heady", "head1"))
+
+		stream, err := client.ClientStream(ctx)
+		asrt.NoErr(err)
+		for i := 0; i < 5; i++ {
+			asrt.NoErr(stream.Send(&testproto.ClientStreamReq{Msg: fmt.Sprintf("Hello via NRPC %d", i+1)}))
+		}
+		resp, err := stream.CloseAndRecv()
+		asrt.NoErr(err)
+		asrt.Equal(resp.Msg, "Hello back!")
+		asrt.Equal(stream.Trailer().Get("traily"), []string{"t-value"})
+	})
+}
+
+func TestBridge(t *testing.T) {
+	asrt := is.New(t)
+	ctxMain, cancelMain := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelMain()
+	logger := nrpc.StandardLogger{}
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	server, impl, err := testserver.New(pub, sub, nrpc.WithLogger(logger))
+	asrt.NoErr(err)
+	defer server.Stop()
+	impl.SetMsgCount(3)
+
+	nrpcClient := nrpc.NewClient(pub, sub, nrpc.WithLogger(logger))
+	grpcServer := bridge.NewServer(dynamic.NewClient(nrpcClient, dynamic.NewFilesSource(protoregistry.GlobalFiles)))
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	asrt.NoErr(err)
+	go func() { _ = grpcServer.Serve(lis) }()
+	defer grpcServer.Stop()
+
+	grpcConn, err := grpc.DialContext(ctxMain, lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	asrt.NoErr(err)
+	defer grpcConn.Close()
+	client := testproto.NewTestClient(grpcConn)
+	ctxMain = metadata.NewOutgoingContext(ctxMain, metadata.Pairs("heady", "head1"))
+
+	t.Run("unary", func(t *testing.T) {
+		asrt := asrt.New(t)
+
+		var header, trailer metadata.MD
+		resp, err := client.Unary(ctxMain, &testproto.UnaryReq{Msg: "Hello via NRPC"}, grpc.Header(&header), grpc.Trailer(&trailer))
+		asrt.NoErr(err)
+		asrt.Equal(resp.Msg, "Hello back!")
+		asrt.Equal(header.Get("heady"), []string{"head1"})
+		asrt.Equal(header.Get("srv-key"), []string{"srv-value"})
+		asrt.Equal(trailer.Get("traily"), []string{"t-value"})
+
+		_, err = client.Unary(ctxMain, &testproto.UnaryReq{Msg: "invalid"})
+		asrt.Equal(status.Code(err), codes.InvalidArgument)
+	})
+	t.Run("server stream", func(t *testing.T) {
+		asrt := asrt.New(t)
+
+		stream, err := client.ServerStream(ctxMain, &testproto.ServerStreamReq{Msg: "Hello via NRPC"})
+		asrt.NoErr(err)
+		var msgs []string
+		for {
+			resp, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			asrt.NoErr(err)
+			msgs = append(msgs, resp.Msg)
+		}
+		asrt.Equal(msgs, []string{"Hello back! 1", "Hello back! 2", "Hello back! 3"})
+		asrt.Equal(stream.Trailer().Get("traily"), []string{"t-value"})
+	})
+	t.Run("client stream", func(t *testing.T) {
+		asrt := asrt.New(t)
+
+		stream, err := client.ClientStream(ctxMain)
+		asrt.NoErr(err)
+		for i := 0; i < 3; i++ {
+			asrt.NoErr(stream.Send(&testproto.ClientStreamReq{Msg: fmt.Sprintf("Hello via NRPC %d", i+1)}))
+		}
+		resp, err := stream.CloseAndRecv()
+		asrt.NoErr(err)
+		asrt.Equal(resp.Msg, "Hello back!")
+	})
+	t.Run("bidi stream", func(t *testing.T) {
+		asrt := asrt.New(t)
+
+		stream, err := client.BiDiStream(ctxMain)
+		asrt.NoErr(err)
+		for i := 0; i < 3; i++ {
+			asrt.NoErr(stream.Send(&testproto.BiDiStreamReq{Msg: fmt.Sprintf("Hello via NRPC %d", i+1)}))
+			resp, err := stream.Recv()
+			asrt.NoErr(err)
+			asrt.Equal(resp.Msg, fmt.Sprintf("Hello back! %d", i+1))
+		}
+		asrt.NoErr(stream.CloseSend())
+		_, err = stream.Recv()
+		asrt.Equal(err, io.EOF)
+
+		header, err := stream.Header()
+		asrt.NoErr(err)
+		asrt.Equal(header.Get("srv-key"), []string{"srv-value"})
+	})
+	t.Run("unknown method", func(t *testing.T) {
+		asrt := asrt.New(t)
+
+		err := grpcConn.Invoke(ctxMain, "/unknown.Service/Method", &testproto.UnaryReq{}, &testproto.UnaryResp{})
+		asrt.Equal(status.Code(err), codes.Unimplemented)
+	})
+}
+
+func TestBridgeUpstream(t *testing.T) {
+	asrt := is.New(t)
+	ctxMain, cancelMain := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelMain()
+	logger := nrpc.StandardLogger{}
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	// the service remains on grpc
+	impl := &testserver.Server{}
+	impl.SetMsgCount(3)
+	grpcServer := grpc.NewServer()
+	testproto.RegisterTestServer(grpcServer, impl)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	asrt.NoErr(err)
+	go func() { _ = grpcServer.Serve(lis) }()
+	defer grpcServer.Stop()
+
+	upstream, err := grpc.DialContext(ctxMain, lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	asrt.NoErr(err)
+	defer upstream.Close()
+
+	server := nrpc.NewServer(pub, sub, nrpc.WithLogger(logger))
+	asrt.NoErr(bridge.RegisterUpstream(ctxMain, server, upstream, dynamic.NewFilesSource(protoregistry.GlobalFiles), "testproto.Test"))
+	asrt.NoErr(server.Run(ctxMain))
+	defer server.Stop()
+
+	client := testclient.New(pub, sub, nrpc.WithLogger(logger))
+	ctxMain = metadata.NewOutgoingContext(ctxMain, metadata.Pairs("heady", "head1"))
+
+	t.Run("unary", func(t *testing.T) {
+		asrt := asrt.New(t)
+
+		var header, trailer metadata.MD
+		resp, err := client.Unary(ctxMain, &testproto.UnaryReq{Msg: "Hello via NRPC"}, grpc.Header(&header), grpc.Trailer(&trailer))
+		asrt.NoErr(err)
+		asrt.Equal(resp.Msg, "Hello back!")
+		asrt.Equal(header.Get("heady"), []string{"head1"})
+		asrt.Equal(trailer.Get("traily"), []string{"t-value"})
+
+		_, err = client.Unary(ctxMain, &testproto.UnaryReq{Msg: "invalid"})
+		asrt.Equal(status.Code(err), codes.InvalidArgument)
+	})
+	t.Run("bidi stream", func(t *testing.T) {
+		asrt := asrt.New(t)
+
+		stream, err := client.BiDiStream(ctxMain)
+		asrt.NoErr(err)
+		for i := 0; i < 3; i++ {
+			asrt.NoErr(stream.Send(&testproto.BiDiStreamReq{Msg: fmt.Sprintf("Hello via NRPC %d", i+1)}))
+			resp, err := stream.Recv()
+			asrt.NoErr(err)
+			asrt.Equal(resp.Msg, fmt.Sprintf("Hello back! %d", i+1))
+		}
+		asrt.NoErr(stream.CloseSend())
+		_, err = stream.Recv()
+		asrt.Equal(err, io.EOF)
+		asrt.Equal(stream.Trailer().Get("traily"), []string{"t-value"})
+	})
+}
+
+func TestMetrics(t *testing.T) {
+	asrt := is.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	logger := nrpc.StandardLogger{}
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	serverMetrics := metrics.NewServerMetrics()
+	clientMetrics := metrics.NewClientMetrics()
+	_, _, err = testserver.New(pub, sub, append(serverMetrics.Options(), nrpc.WithLogger(logger))...)
+	asrt.NoErr(err)
+	client := testclient.New(pub, sub, append(clientMetrics.Options(), nrpc.WithLogger(logger))...)
+
+	_, err = client.Unary(ctx, &testproto.UnaryReq{Msg: "Hello via NRPC"})
+	asrt.NoErr(err)
+	_, err = client.Unary(ctx, &testproto.UnaryReq{Msg: "invalid"})
+	asrt.Equal(status.Code(err), codes.InvalidArgument)
+
+	// the server might record a finished stream only after the client received the end of it
+	expected := `
+# HELP nrpc_server_handled_total Total number of RPCs completed, regardless of success or failure.
+# TYPE nrpc_server_handled_total counter
+nrpc_server_handled_total{code="InvalidArgument",method="/testproto.Test/Unary",type="unary"} 1
+nrpc_server_handled_total{code="OK",method="/testproto.Test/Unary",type="unary"} 1
+`
+	asrt.NoErr(testutil.CollectAndCompare(serverMetrics, strings.NewReader(expected), "nrpc_server_handled_total"))
+
+	stream, err := client.ServerStream(ctx, &testproto.ServerStreamReq{Msg: "Hello via NRPC"})
+	asrt.NoErr(err)
+	for {
+		if _, r := stream.Recv(); r != nil {
+			asrt.True(errors.Is(r, io.EOF))
+			break
+		}
+	}
+
+	expected = `
+# HELP nrpc_client_handled_total Total number of RPCs completed, regardless of success or failure.
+# TYPE nrpc_client_handled_total counter
+nrpc_client_handled_total{code="InvalidArgument",method="/testproto.Test/Unary",type="unary"} 1
+nrpc_client_handled_total{code="OK",method="/testproto.Test/ServerStream",type="server_stream"} 1
+nrpc_client_handled_total{code="OK",method="/testproto.Test/Unary",type="unary"} 1
+`
+	asrt.NoErr(testutil.CollectAndCompare(clientMetrics, strings.NewReader(expected), "nrpc_client_handled_total"))
+	asrt.True(testutil.CollectAndCount(clientMetrics, "nrpc_client_recv_queue_depth") > 0)
+
+	expected = `
+# HELP nrpc_server_started_total Total number of RPCs started.
+# TYPE nrpc_server_started_total counter
+nrpc_server_started_total{method="/testproto.Test/ServerStream",type="server_stream"} 1
+nrpc_server_started_total{method="/testproto.Test/Unary",type="unary"} 2
+`
+	asrt.NoErr(testutil.CollectAndCompare(serverMetrics, strings.NewReader(expected), "nrpc_server_started_total"))
+}
+
+func TestTracing(t *testing.T) {
+	asrt := is.New(t)
+	ctxMain := context.Background()
+	logger := nrpc.StandardLogger{}
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	recorder := tracetest.NewSpanRecorder()
+	opts := []tracing.Option{
+		tracing.WithTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))),
+		tracing.WithPropagator(propagation.TraceContext{}),
+	}
+
+	_, _, err = testserver.New(pub, sub, append(tracing.ServerOptions(opts...), nrpc.WithLogger(logger))...)
+	asrt.NoErr(err)
+	client := testclient.New(pub, sub, append(tracing.ClientOptions(opts...), nrpc.WithLogger(logger))...)
+
+	spansOf := func(name string) []sdktrace.ReadOnlySpan {
+		var spans []sdktrace.ReadOnlySpan
+		for _, span := range recorder.Ended() {
+			if span.Name() == name {
+				spans = append(spans, span)
+			}
+		}
+		return spans
+	}
+
+	t.Run("unary", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		_, err := client.Unary(ctx, &testproto.UnaryReq{Msg: "Hello via NRPC"})
+		asrt.NoErr(err)
+
+		spans := spansOf("testproto.Test/Unary")
+		asrt.Equal(len(spans), 2)
+		server, client := spans[0], spans[1]
+		asrt.Equal(server.SpanKind(), trace.SpanKindServer)
+		asrt.Equal(client.SpanKind(), trace.SpanKindClient)
+		asrt.Equal(server.Parent().TraceID(), client.SpanContext().TraceID())
+		asrt.Equal(server.Parent().SpanID(), client.SpanContext().SpanID())
+	})
+	t.Run("stream", func(t *testing.T) {
+		asrt := asrt.New(t)
+		ctx, cancel := context.WithTimeout(ctxMain, 2*time.Second)
+		defer cancel()
+
+		stream, err := client.ServerStream(ctx, &testproto.ServerStreamReq{Msg: "Hello via NRPC"})
+		asrt.NoErr(err)
+		for {
+			if _, r := stream.Recv(); r != nil {
+				asrt.True(errors.Is(r, io.EOF))
+				break
+			}
+		}
+
+		var client sdktrace.ReadOnlySpan
+		for _, span := range spansOf("testproto.Test/ServerStream") {
+			if span.SpanKind() == trace.SpanKindClient {
+				client = span
+			}
+		}
+		asrt.True(client != nil)
+
+		// 5 messages and the end of the stream
+		var received int
+		for _, span := range spansOf("testproto.Test/ServerStream RECEIVED") {
+			if span.Parent().SpanID() == client.SpanContext().SpanID() {
+				received++
+			}
+		}
+		asrt.Equal(received, 6)
+	})
+}
+
+type recordingLogger struct {
+	m       sync.Mutex
+	entries []string
+}
+
+func (l *recordingLogger) Debug(msg string, fields ...interface{}) { l.record("DEBUG", msg, fields) }
+func (l *recordingLogger) Info(msg string, fields ...interface{})  { l.record("INFO", msg, fields) }
+func (l *recordingLogger) Warn(msg string, fields ...interface{})  { l.record("WARN", msg, fields) }
+func (l *recordingLogger) Error(msg string, fields ...interface{}) { l.record("ERROR", msg, fields) }
+
+func (l *recordingLogger) record(level, msg string, fields []interface{}) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	l.entries = append(l.entries, fmt.Sprintln(append([]interface{}{level, msg}, fields...)...))
+}
+
+func (l *recordingLogger) contains(s string) bool {
+	l.m.Lock()
+	defer l.m.Unlock()
+	for _, entry := range l.entries {
+		if strings.Contains(entry, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRequestLog(t *testing.T) {
+	asrt := is.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	log := &recordingLogger{}
+	reqLog := requestlog.New(log, requestlog.WithPayloads(), requestlog.WithRedactedPaths("msg"))
+	authenticator := nrpc.AuthenticatorFunc(func(ctx context.Context, _ string) (context.Context, error) {
+		return auth.NewClaimsContext(ctx, auth.Claims{"sub": {"alice"}}), nil
+	})
+	_, _, err = testserver.New(pub, sub, append(reqLog.ServerOptions(), nrpc.WithAuthenticator(authenticator))...)
+	asrt.NoErr(err)
+	client := testclient.New(pub, sub)
+
+	_, err = client.Unary(ctx, &testproto.UnaryReq{Msg: "Hello via NRPC", Secret: "s3cret"})
+	asrt.NoErr(err)
+	asrt.True(log.contains("INFO handled call method /testproto.Test/Unary identity alice"))
+	// the secret is marked with the redact option, the message is redacted by path
+	asrt.True(log.contains("[REDACTED]"))
+	asrt.True(!log.contains("s3cret"))
+	asrt.True(!log.contains("Hello"))
+
+	_, err = client.Unary(ctx, &testproto.UnaryReq{Msg: "invalid"})
+	asrt.Equal(status.Code(err), codes.InvalidArgument)
+	asrt.True(log.contains("WARN handled call method /testproto.Test/Unary identity alice"))
+	asrt.True(log.contains("code InvalidArgument error invalid message"))
+
+	stream, err := client.ServerStream(ctx, &testproto.ServerStreamReq{Msg: "Hello via NRPC"})
+	asrt.NoErr(err)
+	for {
+		if _, r := stream.Recv(); r != nil {
+			asrt.True(errors.Is(r, io.EOF))
+			break
+		}
+	}
+	// the stream is logged once the handler returned, which might be after the client received the end of the stream
+	for i := 0; i < 100 && !log.contains("handled stream"); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	asrt.True(log.contains("INFO handled stream method /testproto.Test/ServerStream identity alice"))
+	asrt.True(log.contains("received 1"))
+	asrt.True(log.contains("sent 5"))
+}
+
+func TestLogger(t *testing.T) {
+	asrt := is.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	serverLog := &recordingLogger{}
+	clientLog := &recordingLogger{}
+	_, _, err = testserver.New(pub, sub, nrpc.WithLogger(serverLog))
+	asrt.NoErr(err)
+	client := testclient.New(pub, sub, nrpc.WithLogger(clientLog))
+
+	stream, err := client.ServerStream(ctx, &testproto.ServerStreamReq{Msg: "Hello via NRPC"})
+	asrt.NoErr(err)
+	for {
+		if _, r := stream.Recv(); r != nil {
+			asrt.True(errors.Is(r, io.EOF))
+			break
+		}
+	}
+
+	asrt.True(serverLog.contains("INFO subscribed subject nrpc.testproto.Test.ServerStream queue testproto.Test"))
+	asrt.True(serverLog.contains("DEBUG sending frame subject nrpc.resp.testproto.Test.ServerStream."))
+	asrt.True(clientLog.contains("DEBUG opening stream subject nrpc.testproto.Test.ServerStream"))
+	asrt.True(clientLog.contains("frame data"))
+	asrt.True(clientLog.contains("frame eos"))
+}
+
+// benchCompressors are the compressors the benchmarks run with.
+var benchCompressors = []string{encoding.Identity, "gzip"}
+
+func BenchmarkUnary(b *testing.B) {
+	for _, compressor := range benchCompressors {
+		b.Run(compressor, func(b *testing.B) {
+			benchmarkUnary(b, grpc.UseCompressor(compressor))
+		})
+	}
+}
+
+func benchmarkUnary(b *testing.B, opts ...grpc.CallOption) {
+	asrt := is.New(b)
+	ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("heady", "head1"))
+
+	broker := memory.NewBroker()
+	pub := memory.Publisher(broker)
+	sub := memory.Subscriber(broker)
+
+	server, _, err := testserver.New(pub, sub)
+	asrt.NoErr(err)
+	defer server.Stop()
+	client := testclient.New(pub, sub)
+
+	req := &testproto.UnaryReq{Msg: "Hello via NRPC"}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, r := client.Unary(ctx, req, opts...); r != nil {
+			b.Fatal(r)
+		}
+	}
+}
+
+func BenchmarkStream(b *testing.B) {
+	for _, compressor := range benchCompressors {
+		b.Run(compressor, func(b *testing.B) {
+			benchmarkStream(b, grpc.UseCompressor(compressor))
+		})
+	}
+}
+
+func benchmarkStream(b *testing.B, opts ...grpc.CallOption) {
+	asrt := is.New(b)
+	ctx, cancel := context.WithCancel(metadata.NewOutgoingContext(context.Background(), metadata.Pairs("heady", "head1")))
+	defer cancel()
+
+	broker := memory.NewBroker()
+	pub := memory.Publisher(broker)
+	sub := memory.Subscriber(broker)
+
+	server, _, err := testserver.New(pub, sub)
+	asrt.NoErr(err)
+	defer server.Stop()
+	client := testclient.New(pub, sub)
+
+	stream, err := client.BiDiStream(ctx, opts...)
+	asrt.NoErr(err)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if r := stream.Send(&testproto.BiDiStreamReq{Msg: fmt.Sprintf("Hello via NRPC %d", i+1)}); r != nil {
+			b.Fatal(r)
+		}
+		if _, r := stream.Recv(); r != nil {
+			b.Fatal(r)
+		}
+	}
+	b.StopTimer()
+	asrt.NoErr(stream.CloseSend())
+}
@@ -0,0 +1,167 @@
+// Package nrpctest provides a deterministic mock server for testing nrpc clients. The mock server
+// serves the methods of registered services with canned responses, scripted streams and injected
+// errors over the in-memory pubsub, so edge cases like mid-stream errors or delayed headers can be
+// tested without implementing the service:
+//
+//	mock := nrpctest.NewServer()
+//	mock.Register(&pb.Foo_ServiceDesc)
+//	mock.Unary("/foo.Foo/Get").Return(&pb.GetResp{Name: "foo"})
+//	mock.Stream("/foo.Foo/Watch").Script(nrpctest.Sleep(time.Second), nrpctest.Header(md), nrpctest.Fail(err))
+//	if err := mock.Run(ctx); err != nil { ... }
+//	defer mock.Stop()
+//
+//	client := pb.NewFooClient(mock.Client())
+//
+// Methods without canned responses or scripts fail with codes.Unimplemented. The message types of the
+// services are looked up in the global registry of the protobuf package.
+package nrpctest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/tehsphinx/nrpc"
+	"github.com/tehsphinx/nrpc/pubsub/memory"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// Server is a mock server serving the methods of the registered services with canned responses.
+type Server struct {
+	broker *memory.Broker
+	server *nrpc.Server
+
+	m       sync.Mutex
+	unaries map[string]*Unary
+	streams map[string]*Stream
+}
+
+// NewServer creates a mock server on a new in-memory broker. The options configure the nrpc server.
+func NewServer(opts ...nrpc.Option) *Server {
+	broker := memory.NewBroker()
+	return &Server{
+		broker:  broker,
+		server:  nrpc.NewServer(memory.Publisher(broker), memory.Subscriber(broker), opts...),
+		unaries: map[string]*Unary{},
+		streams: map[string]*Stream{},
+	}
+}
+
+// Register registers the service of the service description, e.g. pb.Foo_ServiceDesc generated by
+// protoc-gen-go-grpc. Like the registration of services of the nrpc server, it must be called before
+// the server runs. It panics if the service is unknown to the global registry of the protobuf package.
+func (s *Server) Register(desc *grpc.ServiceDesc) {
+	mock := grpc.ServiceDesc{
+		ServiceName: desc.ServiceName,
+		HandlerType: desc.HandlerType,
+		Metadata:    desc.Metadata,
+	}
+	for _, m := range desc.Methods {
+		method := "/" + desc.ServiceName + "/" + m.MethodName
+		types := lookupTypes(desc.ServiceName, m.MethodName)
+		mock.Methods = append(mock.Methods, grpc.MethodDesc{
+			MethodName: m.MethodName,
+			Handler:    s.unaryHandler(method, types),
+		})
+	}
+	for _, st := range desc.Streams {
+		method := "/" + desc.ServiceName + "/" + st.StreamName
+		types := lookupTypes(desc.ServiceName, st.StreamName)
+		mock.Streams = append(mock.Streams, grpc.StreamDesc{
+			StreamName:    st.StreamName,
+			ClientStreams: st.ClientStreams,
+			ServerStreams: st.ServerStreams,
+			Handler:       s.streamHandler(method, types),
+		})
+	}
+	// the mock does not implement the handler type, so the implementation is not checked
+	s.server.RegisterService(&mock, nil)
+}
+
+// Run starts the mock server.
+func (s *Server) Run(ctx context.Context) error {
+	return s.server.Run(ctx)
+}
+
+// Stop stops the mock server.
+func (s *Server) Stop() {
+	s.server.Stop()
+}
+
+// Client creates a client of the mock server. The options configure the nrpc client.
+func (s *Server) Client(opts ...nrpc.Option) *nrpc.Client {
+	return nrpc.NewClient(memory.Publisher(s.broker), memory.Subscriber(s.broker), opts...)
+}
+
+// Broker returns the in-memory broker the mock server is subscribed to.
+func (s *Server) Broker() *memory.Broker {
+	return s.broker
+}
+
+// Unary returns the mock of the unary full method (/service/method) to configure its responses.
+func (s *Server) Unary(method string) *Unary {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	u, ok := s.unaries[method]
+	if !ok {
+		u = &Unary{method: method}
+		s.unaries[method] = u
+	}
+	return u
+}
+
+// Stream returns the mock of the streaming full method (/service/method) to configure its scripts.
+func (s *Server) Stream(method string) *Stream {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	st, ok := s.streams[method]
+	if !ok {
+		st = &Stream{method: method}
+		s.streams[method] = st
+	}
+	return st
+}
+
+// messageTypes are the types of the request and response messages of a method.
+type messageTypes struct {
+	req  protoreflect.MessageType
+	resp protoreflect.MessageType
+}
+
+func (t messageTypes) newReq() proto.Message {
+	return t.req.New().Interface()
+}
+
+func (t messageTypes) newResp() proto.Message {
+	return t.resp.New().Interface()
+}
+
+// lookupTypes looks up the message types of the method in the global registry.
+func lookupTypes(service, method string) messageTypes {
+	desc, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(service))
+	if err != nil {
+		panic(fmt.Sprintf("nrpctest: service %q not found: %v", service, err))
+	}
+	serviceDesc, ok := desc.(protoreflect.ServiceDescriptor)
+	if !ok {
+		panic(fmt.Sprintf("nrpctest: %q is not a service", service))
+	}
+	methodDesc := serviceDesc.Methods().ByName(protoreflect.Name(method))
+	if methodDesc == nil {
+		panic(fmt.Sprintf("nrpctest: method %q of service %q not found", method, service))
+	}
+
+	var types messageTypes
+	if types.req, err = protoregistry.GlobalTypes.FindMessageByName(methodDesc.Input().FullName()); err != nil {
+		panic(fmt.Sprintf("nrpctest: request type of %s/%s not found: %v", service, method, err))
+	}
+	if types.resp, err = protoregistry.GlobalTypes.FindMessageByName(methodDesc.Output().FullName()); err != nil {
+		panic(fmt.Sprintf("nrpctest: response type of %s/%s not found: %v", service, method, err))
+	}
+	return types
+}
@@ -0,0 +1,141 @@
+package nrpctest
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// Step is a step of a stream script.
+type Step struct {
+	run func(stream grpc.ServerStream, recv func() error) error
+}
+
+// Send sends the message to the client.
+func Send(msg proto.Message) Step {
+	return Step{run: func(stream grpc.ServerStream, _ func() error) error {
+		return stream.SendMsg(msg)
+	}}
+}
+
+// Recv waits for the next message of the client. The end of the client stream does not fail the script.
+func Recv() Step {
+	return Step{run: func(_ grpc.ServerStream, recv func() error) error {
+		return recv()
+	}}
+}
+
+// Header sends the header. Without this step, the header is sent with the first message.
+func Header(md metadata.MD) Step {
+	return Step{run: func(stream grpc.ServerStream, _ func() error) error {
+		return stream.SendHeader(md)
+	}}
+}
+
+// Trailer sets the trailer sent at the end of the stream.
+func Trailer(md metadata.MD) Step {
+	return Step{run: func(stream grpc.ServerStream, _ func() error) error {
+		stream.SetTrailer(md)
+		return nil
+	}}
+}
+
+// Sleep delays the next step, e.g. to delay the header or a message.
+func Sleep(d time.Duration) Step {
+	return Step{run: func(stream grpc.ServerStream, _ func() error) error {
+		return sleep(stream.Context(), d)
+	}}
+}
+
+// Fail ends the stream with the error. Errors without a status are returned as codes.Unknown.
+func Fail(err error) Step {
+	return Step{run: func(grpc.ServerStream, func() error) error {
+		return err
+	}}
+}
+
+// Stream is the mock of a streaming method. Every stream runs the next script in the order they were
+// added. The last script is repeated once all others ran. Streams end successfully after their script
+// unless it failed.
+type Stream struct {
+	method string
+
+	m        sync.Mutex
+	scripts  [][]Step
+	next     int
+	requests []proto.Message
+}
+
+// Script adds a script of a stream.
+func (s *Stream) Script(steps ...Step) *Stream {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.scripts = append(s.scripts, steps)
+	return s
+}
+
+// Requests returns the messages received by the Recv steps of all streams so far.
+func (s *Stream) Requests() []proto.Message {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return append([]proto.Message(nil), s.requests...)
+}
+
+func (s *Stream) nextScript() ([]Step, bool) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if len(s.scripts) == 0 {
+		return nil, false
+	}
+	script := s.scripts[s.next]
+	if s.next < len(s.scripts)-1 {
+		s.next++
+	}
+	return script, true
+}
+
+func (s *Stream) addRequest(req proto.Message) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.requests = append(s.requests, req)
+}
+
+func (s *Server) streamHandler(method string, types messageTypes) grpc.StreamHandler {
+	return func(_ interface{}, stream grpc.ServerStream) error {
+		mock := s.Stream(method)
+		script, ok := mock.nextScript()
+		if !ok {
+			return status.Errorf(codes.Unimplemented, "nrpctest: no script for %s", method)
+		}
+
+		recv := func() error {
+			req := types.newReq()
+			err := stream.RecvMsg(req)
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			mock.addRequest(req)
+			return nil
+		}
+		for _, step := range script {
+			if r := step.run(stream, recv); r != nil {
+				return r
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,139 @@
+package nrpctest
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// Response is a canned response of a unary call.
+type Response struct {
+	// Msg is the response message. An empty message is returned if it is nil and the call does not fail.
+	Msg proto.Message
+	// Err fails the call. Errors without a status are returned as codes.Unknown.
+	Err error
+	// Header and Trailer are sent with the response.
+	Header  metadata.MD
+	Trailer metadata.MD
+	// Delay delays the response. Calls whose deadline passes in the meantime fail with codes.DeadlineExceeded.
+	Delay time.Duration
+}
+
+// Unary is the mock of a unary method. Its responses are returned in the order they were added.
+// The last response is repeated once all others were returned.
+type Unary struct {
+	method string
+
+	m         sync.Mutex
+	responses []Response
+	next      int
+	requests  []proto.Message
+}
+
+// Respond adds the response.
+func (u *Unary) Respond(resp Response) *Unary {
+	u.m.Lock()
+	defer u.m.Unlock()
+
+	u.responses = append(u.responses, resp)
+	return u
+}
+
+// Return adds a response returning the message.
+func (u *Unary) Return(msg proto.Message) *Unary {
+	return u.Respond(Response{Msg: msg})
+}
+
+// Fail adds a response failing with the error.
+func (u *Unary) Fail(err error) *Unary {
+	return u.Respond(Response{Err: err})
+}
+
+// Requests returns the requests received so far.
+func (u *Unary) Requests() []proto.Message {
+	u.m.Lock()
+	defer u.m.Unlock()
+
+	return append([]proto.Message(nil), u.requests...)
+}
+
+// receive records the request and returns the next response.
+func (u *Unary) receive(req proto.Message) (Response, bool) {
+	u.m.Lock()
+	defer u.m.Unlock()
+
+	u.requests = append(u.requests, req)
+	if len(u.responses) == 0 {
+		return Response{}, false
+	}
+	resp := u.responses[u.next]
+	if u.next < len(u.responses)-1 {
+		u.next++
+	}
+	return resp, true
+}
+
+// unaryHandler returns the handler of the unary method. It has the signature of grpc.MethodDesc.Handler.
+func (s *Server) unaryHandler(method string, types messageTypes) func(interface{}, context.Context, func(interface{}) error,
+	grpc.UnaryServerInterceptor) (interface{}, error) {
+	// nolint: revive // the context is not the first argument of grpc method handlers
+	return func(_ interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+		req := types.newReq()
+		if err := dec(req); err != nil {
+			return nil, err
+		}
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			msg, _ := req.(proto.Message)
+			return s.respond(ctx, method, types, msg)
+		}
+		if interceptor == nil {
+			return handler(ctx, req)
+		}
+		return interceptor(ctx, req, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+	}
+}
+
+func (s *Server) respond(ctx context.Context, method string, types messageTypes, req proto.Message) (interface{}, error) {
+	resp, ok := s.Unary(method).receive(req)
+	if !ok {
+		return nil, status.Errorf(codes.Unimplemented, "nrpctest: no response for %s", method)
+	}
+	if r := sleep(ctx, resp.Delay); r != nil {
+		return nil, r
+	}
+	if resp.Header != nil {
+		_ = grpc.SetHeader(ctx, resp.Header)
+	}
+	if resp.Trailer != nil {
+		_ = grpc.SetTrailer(ctx, resp.Trailer)
+	}
+	if resp.Err != nil {
+		return nil, resp.Err
+	}
+	if resp.Msg == nil {
+		return types.newResp(), nil
+	}
+	return resp.Msg, nil
+}
+
+// sleep waits for the duration or until the context is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return status.FromContextError(ctx.Err()).Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,30 @@
+package nrpc
+
+// StreamObserver is notified about internals of streams that are neither visible to interceptors
+// nor to stats handlers. It can be used to export metrics (see package metrics).
+type StreamObserver interface {
+	// ObserveQueueDepth is called with the number of buffered messages each time a stream received a message.
+	ObserveQueueDepth(method string, depth int)
+	// ConsumerStuck is called when a stream is closed because received messages were not consumed in time.
+	ConsumerStuck(method string)
+}
+
+// DuplicateObserver is implemented by stream observers counting the frames streams received twice, e.g.
+// from transports with at-least-once delivery. The duplicates are dropped before they are received.
+type DuplicateObserver interface {
+	// DuplicateReceived is called for every frame a stream dropped because it was received before.
+	DuplicateReceived(method string)
+}
+
+// observeDuplicate notifies the observer about a dropped duplicate frame if it implements DuplicateObserver.
+func observeDuplicate(observer StreamObserver, method string) {
+	if o, ok := observer.(DuplicateObserver); ok {
+		o.DuplicateReceived(method)
+	}
+}
+
+type noopStreamObserver struct{}
+
+func (noopStreamObserver) ObserveQueueDepth(string, int) {}
+
+func (noopStreamObserver) ConsumerStuck(string) {}
@@ -0,0 +1,86 @@
+package nrpc
+
+import (
+	"context"
+	"sync"
+
+	"github.com/tehsphinx/nrpc/pubsub"
+	"google.golang.org/grpc"
+)
+
+var oneWayMethods = struct {
+	m      sync.RWMutex
+	byName map[string]bool
+}{byName: map[string]bool{}}
+
+// RegisterOneWay marks the unary full method (/service/method) as one-way: clients publish its requests
+// without waiting for a response, servers handle them without replying. The code generated by
+// protoc-gen-nrpc registers the methods marked with the one_way option of nrpcpb/options.proto.
+// It must only be called at init time.
+func RegisterOneWay(fullMethod string) {
+	oneWayMethods.m.Lock()
+	defer oneWayMethods.m.Unlock()
+
+	oneWayMethods.byName[fullMethod] = true
+}
+
+// isOneWay reports whether the full method was registered as one-way.
+func isOneWay(method string) bool {
+	oneWayMethods.m.RLock()
+	defer oneWayMethods.m.RUnlock()
+
+	return oneWayMethods.byName[method]
+}
+
+// OneWay returns a CallOption sending a unary call one-way: the call returns as soon as the request
+// is published, leaving the reply untouched. Errors of the server never reach the client. Methods
+// registered with RegisterOneWay are always called one-way.
+func OneWay() grpc.CallOption {
+	return callOption{apply: func(opt *callOptions) {
+		opt.oneWay = true
+	}}
+}
+
+// publish publishes the request of a one-way call. Like a call, it is bound by the deadline of the
+// context on the server, but it is neither retried nor hedged. Jobs are not bound by the deadline.
+func (s *Client) publish(ctx context.Context, method string, args interface{}, callOpts callOptions) error {
+	timeout := timeoutFromCtx(ctx)
+	if timeout < 0 {
+		return toRPCErr(ctx.Err())
+	}
+	if isJob(method) {
+		timeout = 0
+	}
+	data, payload, err := marshalReqMsg(outgoingMD(ctx), callOpts.codec, args, callOpts.announce(&Request{
+		Timeout:        timeout,
+		Compressor:     callOpts.compressor,
+		OneWay:         true,
+		IdempotencyKey: callOpts.idempotencyKey,
+		Version:        callOpts.stream.wireVersion,
+	}), callOpts.stream.maxSendMsgSize)
+	if err != nil {
+		return err
+	}
+
+	subj := callOpts.subjects.MapSubject(callSubj(method, s.pickInstance(ctx, method, callOpts)))
+	if debugEnabled(s.log) {
+		s.log.Debug("publish", "subject", subj)
+	}
+	callOpts.stats.outHeader(ctx, subj)
+	callOpts.stats.outPayload(args, data, payload)
+	s.cfg.tap.request(ctx, Frame{Direction: FrameSent, Method: method, Subject: subj, Data: payload})
+	return toRPCErr(s.pub.Publish(pubsub.Message{
+		Subject: subj,
+		Data:    payload,
+	}))
+}
+
+// discardReplies drops the replies to a one-way call.
+type discardReplies struct {
+	pubsub.Replier
+}
+
+// Reply implements the pubsub.Replier interface.
+func (discardReplies) Reply(pubsub.Reply) error {
+	return nil
+}
@@ -1,7 +1,12 @@
 package nrpc
 
 import (
+	"time"
+
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/encoding"
+	encproto "google.golang.org/grpc/encoding/proto"
 	"google.golang.org/grpc/stats"
 )
 
@@ -10,22 +15,209 @@ type Option func(opt *options)
 
 func getOptions(opts []Option) options {
 	opt := options{
-		logger:       noopLogger{},
-		statsHandler: noopStatsHandler{},
+		logger:             noopLogger{},
+		statsHandler:       noopStatsHandler{},
+		clientStatsHandler: noopStatsHandler{},
+		observer:           noopStreamObserver{},
+		connectTimeout:     streamConnectTimeout,
+		stuckTimeout:       stuckTimeout,
+		drainTimeout:       drainTimeout,
+		maxRecvMsgSize:     defaultMaxRecvMsgSize,
+		maxSendMsgSize:     defaultMaxSendMsgSize,
+		codec:              encoding.GetCodec(encproto.Name),
+		retryPolicies:      retryPolicies{},
+		hedgingPolicies:    hedgingPolicies{},
+		concurrencyLimits:  concurrencyLimits{},
+		balancingPolicies:  balancingPolicies{},
+		breakerPolicies:    circuitBreakerPolicies{},
+		recvBuffers:        recvBufferPolicies{},
+		sendPacings:        sendPacingPolicies{},
+		queueGroups:        queueGroupPolicies{},
+		streamQueue:        streamQueue,
+		idempotentMethods:  idempotentMethods{},
+		handlerTimeouts:    handlerTimeouts{},
+		wireVersion:        WireVersion,
+		pagedBytes:         defaultPagedBytes,
 	}
 
 	for _, o := range opts {
 		o(&opt)
 	}
+	if opt.subjectMapper == nil {
+		opt.subjectMapper = opt.subjectScheme
+	}
 	return opt
 }
 
+func (o options) streamConfig() streamConfig {
+	return streamConfig{
+		window:          o.window,
+		connectTimeout:  o.connectTimeout,
+		stuckTimeout:    o.stuckTimeout,
+		chunkSize:       o.chunkSize,
+		observer:        o.observer,
+		events:          o.events,
+		keepaliveTime:   o.keepaliveTime,
+		keepaliveWait:   o.keepaliveWait,
+		resumeBuffer:    o.resumeBuffer,
+		ordering:        o.ordering,
+		batchSize:       o.batchSize,
+		batchDelay:      o.batchDelay,
+		maxRecvMsgSize:  o.maxRecvMsgSize,
+		maxSendMsgSize:  o.maxSendMsgSize,
+		tap:             wireTap{tap: o.wireTap},
+		recvBuffers:     o.recvBuffers,
+		sendPacings:     o.sendPacings,
+		streamQueue:     o.streamQueue,
+		handlerTimeouts: &handlerTimeoutTable{timeouts: o.handlerTimeouts},
+		wireVersion:     o.wireVersion,
+	}
+}
+
+// streamConfig holds the configuration of client and server streams.
+type streamConfig struct {
+	window         int
+	connectTimeout time.Duration
+	stuckTimeout   time.Duration
+	chunkSize      int
+	observer       StreamObserver
+	events         StreamEvents
+	keepaliveTime  time.Duration
+	keepaliveWait  time.Duration
+	resumeBuffer   int
+	ordering       OrderingPolicy
+	batchSize      int
+	batchDelay     time.Duration
+	// the maximum message sizes apply to unary calls as well.
+	maxRecvMsgSize int
+	maxSendMsgSize int
+	// the wire tap applies to unary calls as well.
+	tap wireTap
+	// recvBuffer is the policy of the stream looked up in the configured recvBuffers by forMethod.
+	recvBuffer  RecvBufferPolicy
+	recvBuffers recvBufferPolicies
+	// sendPacing is the policy of server streams looked up in the configured sendPacings by forMethod.
+	sendPacing  SendPacingPolicy
+	sendPacings sendPacingPolicies
+	// streamQueue is the queue group the subjects of streams are subscribed with.
+	streamQueue string
+	// handlerTimeout is the timeout of server handlers looked up in the configured handlerTimeouts by forMethod.
+	// The handler timeouts apply to unary calls as well.
+	handlerTimeout  time.Duration
+	handlerTimeouts *handlerTimeoutTable
+	// the wire version applies to unary calls as well.
+	wireVersion uint32
+}
+
+// forMethod returns the configuration of a stream of the full method.
+func (c streamConfig) forMethod(method string) streamConfig {
+	c.recvBuffer = c.recvBuffers.get(method)
+	c.sendPacing = c.sendPacings.get(method)
+	c.handlerTimeout = c.handlerTimeouts.get(method)
+	return c
+}
+
 type options struct {
-	logger Logger
+	logger            Logger
+	window            int
+	connectTimeout    time.Duration
+	stuckTimeout      time.Duration
+	chunkSize         int
+	codec             Codec
+	retryPolicies     retryPolicies
+	hedgingPolicies   hedgingPolicies
+	drainTimeout      time.Duration
+	concurrencyLimits concurrencyLimits
+	globalLimit       ConcurrencyLimit
+	observer          StreamObserver
+	events            StreamEvents
+	wireTap           WireTap
+	recvBuffers       recvBufferPolicies
+	sendPacings       sendPacingPolicies
+	keepaliveTime     time.Duration
+	keepaliveWait     time.Duration
+	resumeBuffer      int
+	ordering          OrderingPolicy
+	batchSize         int
+	batchDelay        time.Duration
+	muxSubjects       int
+	maxRecvMsgSize    int
+	maxSendMsgSize    int
+	perRPCCreds       []credentials.PerRPCCredentials
+	identity          ClientIdentity
+	announceInterval  time.Duration
+	registry          *Registry
+	balancingPolicies balancingPolicies
+	breakerPolicies   circuitBreakerPolicies
+	subjectScheme     subjectScheme
+	subjectMapper     SubjectMapper
+	multiTenant       bool
+	queueGroups       queueGroupPolicies
+	streamQueue       string
+	authenticator     Authenticator
+	authorizer        Authorizer
+	encryptionKeys    KeyProvider
+	readiness         readiness
+	propagatedKeys    []string
+	outbox            OutboxStore
+	idempotentMethods idempotentMethods
+	idempotencyStore  IdempotencyStore
+	idempotencyTTL    time.Duration
+	handlerTimeouts   handlerTimeouts
+	wireVersion       uint32
+	pageSize          int
+	pagedBytes        int
+	serviceConfig     *ServiceConfig
+
+	unaryInt           grpc.UnaryServerInterceptor
+	unaryInts          []grpc.UnaryServerInterceptor
+	streamInt          grpc.StreamServerInterceptor
+	streamInts         []grpc.StreamServerInterceptor
+	unknownHandler     grpc.StreamHandler
+	statsHandler       stats.Handler
+	clientStatsHandler stats.Handler
+
+	unaryClientInt   grpc.UnaryClientInterceptor
+	unaryClientInts  []grpc.UnaryClientInterceptor
+	streamClientInt  grpc.StreamClientInterceptor
+	streamClientInts []grpc.StreamClientInterceptor
+}
+
+// unaryServerInterceptors returns the unary server interceptors. Calls are authenticated and
+// authorized by the server before they reach the interceptors (see Server.handleMethod).
+func (o options) unaryServerInterceptors() []grpc.UnaryServerInterceptor {
+	var interceptors []grpc.UnaryServerInterceptor
+	if o.unaryInt != nil {
+		interceptors = append(interceptors, o.unaryInt)
+	}
+	return append(interceptors, o.unaryInts...)
+}
+
+// streamServerInterceptors returns the stream server interceptors. Streams are authenticated and
+// authorized before they reach the interceptors.
+func (o options) streamServerInterceptors() []grpc.StreamServerInterceptor {
+	var interceptors []grpc.StreamServerInterceptor
+	if o.authenticator != nil || o.authorizer != nil {
+		interceptors = append(interceptors, streamAuthInterceptor(o.authenticator, o.authorizer))
+	}
+	if o.streamInt != nil {
+		interceptors = append(interceptors, o.streamInt)
+	}
+	return append(interceptors, o.streamInts...)
+}
 
-	unaryInt     grpc.UnaryServerInterceptor
-	streamInt    grpc.StreamServerInterceptor
-	statsHandler stats.Handler
+func (o options) unaryClientInterceptors() []grpc.UnaryClientInterceptor {
+	if o.unaryClientInt == nil {
+		return o.unaryClientInts
+	}
+	return append([]grpc.UnaryClientInterceptor{o.unaryClientInt}, o.unaryClientInts...)
+}
+
+func (o options) streamClientInterceptors() []grpc.StreamClientInterceptor {
+	if o.streamClientInt == nil {
+		return o.streamClientInts
+	}
+	return append([]grpc.StreamClientInterceptor{o.streamClientInt}, o.streamClientInts...)
 }
 
 // WithLogger sets the logger for the client or server.
@@ -35,9 +227,578 @@ func WithLogger(log Logger) Option {
 	}
 }
 
+// WithStreamWindow sets the flow control window of streams for the client or server.
+// The window is the number of received messages a stream buffers before the sending
+// side blocks in SendMsg until the messages are consumed. A size of 0 disables
+// flow control, which is the default.
+func WithStreamWindow(size int) Option {
+	return func(opt *options) {
+		opt.window = size
+	}
+}
+
+// WithStreamConnectTimeout sets the time a client waits for the server to accept a new stream.
+// It defaults to 5 seconds and can be overwritten per stream with the StreamConnectTimeout call option.
+func WithStreamConnectTimeout(timeout time.Duration) Option {
+	return func(opt *options) {
+		opt.connectTimeout = timeout
+	}
+}
+
+// WithConsumerStuckTimeout sets the time a stream waits for a received message to be consumed
+// before the stream is closed. It defaults to 30 seconds and can be overwritten per stream with
+// the ConsumerStuckTimeout call option.
+func WithConsumerStuckTimeout(timeout time.Duration) Option {
+	return func(opt *options) {
+		opt.stuckTimeout = timeout
+	}
+}
+
+// WithRecvBuffer sets the receive buffer policy of the streams of the client or server for the given
+// methods. Methods are given as full method (/service/method) or as service name to apply the policy to
+// all methods of the service. Without methods the policy becomes the default for all methods. Streams
+// buffer a single message and block while it is not consumed by default. The client can overwrite the
+// policy per stream with the RecvBuffer call option.
+func WithRecvBuffer(policy RecvBufferPolicy, methods ...string) Option {
+	return func(opt *options) {
+		if len(methods) == 0 {
+			opt.recvBuffers[""] = policy
+			return
+		}
+		for _, method := range methods {
+			opt.recvBuffers[method] = policy
+		}
+	}
+}
+
+// WithSendPacing sets the send pacing policy of the streams of the server for the given methods. Methods
+// are given as full method (/service/method) or as service name to apply the policy to all methods of the
+// service. Without methods the policy becomes the default for all methods. With a write deadline, handlers
+// sending to a stalled client or a full broker fail with codes.DeadlineExceeded instead of blocking forever.
+func WithSendPacing(policy SendPacingPolicy, methods ...string) Option {
+	return func(opt *options) {
+		if len(methods) == 0 {
+			opt.sendPacings[""] = policy
+			return
+		}
+		for _, method := range methods {
+			opt.sendPacings[method] = policy
+		}
+	}
+}
+
+// WithKeepalive enables keepalive pings on the streams of the client. Both the client and the server
+// send a ping if the interval passed and abort the stream with codes.Unavailable if nothing was received
+// from the other side within the interval plus the timeout. The parameters are sent to the server with
+// the first message of a stream, so only the client needs to be configured. Keepalive is disabled by
+// default and can be overwritten per stream with the Keepalive call option.
+func WithKeepalive(interval, timeout time.Duration) Option {
+	return func(opt *options) {
+		opt.keepaliveTime = interval
+		opt.keepaliveWait = timeout
+	}
+}
+
+// WithStreamResumption enables the resumption of the streams of the client after frames got lost,
+// e.g. during a short outage of the broker. Both sides keep the last bufferSize frames they sent. A side
+// receiving a frame out of order asks the other side to send the frames following the last frame it
+// received in order again, instead of aborting the stream. Lost frames at the end of a stream are
+// detected by the keepalive pings (see WithKeepalive). If the frames are no longer buffered, the stream
+// is aborted with codes.Unavailable. The buffer size is sent to the server with the first message of a
+// stream, so only the client needs to be configured. Resumption is disabled by default and can be
+// overwritten per stream with the StreamResumption call option.
+func WithStreamResumption(bufferSize int) Option {
+	return func(opt *options) {
+		opt.resumeBuffer = bufferSize
+	}
+}
+
+// WithStreamOrdering enables the verification of the order of the frames of the streams of the client.
+// Both sides verify that the numbered frames they receive arrive in order and without gaps. Depending on
+// the mode of the policy, frames received out of order are reordered within a bounded window or abort the
+// stream with codes.DataLoss. The policy is sent to the server with the first message of a stream, so only
+// the client needs to be configured. Streams with resumption enabled (see WithStreamResumption) recover
+// lost frames instead and ignore the policy. The verification is disabled by default and can be
+// overwritten per stream with the StreamOrdering call option.
+func WithStreamOrdering(policy OrderingPolicy) Option {
+	return func(opt *options) {
+		opt.ordering = policy
+	}
+}
+
+// WithStreamBatching coalesces the messages sent on the streams of the client or server into batches of up
+// to maxMessages messages. A batch is published as a single frame once it is full or maxDelay passed since
+// its first message was sent, which saves round trips to the broker for high-frequency small messages at the
+// cost of latency. The end of the stream and messages waiting for flow control credit publish the batch
+// right away. The receiving side unpacks batches transparently, but only peers speaking version 2 of the
+// envelope understand them (see WireVersion): streams with older peers send every message on its own.
+// Batching is disabled by default.
+func WithStreamBatching(maxMessages int, maxDelay time.Duration) Option {
+	return func(opt *options) {
+		opt.batchSize = maxMessages
+		opt.batchDelay = maxDelay
+	}
+}
+
+// WithStreamMultiplexing makes the client share the given number of response subjects among all its streams
+// instead of subscribing a response subject per stream, which strains the broker if a process opens many
+// streams. The subjects are subscribed once they are first used and the frames received on them are passed
+// to their stream by the stream ID the server sets on every frame. Streams sharing a subject share its
+// subscription as well, so a stream whose consumer is stuck holds up the others (see WithRecvBuffer).
+// Only servers speaking version 3 of the envelope set the stream ID (see WireVersion): streams to older
+// servers fail with codes.Unimplemented. Multiplexing is disabled by default.
+func WithStreamMultiplexing(subjects int) Option {
+	return func(opt *options) {
+		opt.muxSubjects = subjects
+	}
+}
+
+// WithChunkSize sets the maximum size in bytes of a message sent on a stream. Larger messages
+// are split into chunks and reassembled by the receiving side. Use it to stream messages
+// exceeding the maximum payload size of the broker (e.g. 1MB for NATS by default).
+// Both the client and the server need to be configured. A size of 0 disables chunking, which
+// is the default. Unary calls are not chunked, see WithResponsePaging for large unary responses.
+func WithChunkSize(size int) Option {
+	return func(opt *options) {
+		opt.chunkSize = size
+	}
+}
+
+// WithResponsePaging makes the server split unary responses exceeding pageSize bytes into pages, so unary
+// methods keep working when their responses exceed the maximum payload size of the broker. The reply to
+// the call carries the first page. The client transparently requests the following pages from the server
+// that handled the call and reassembles the response. The server keeps the pages for 30 seconds, up to the
+// limit set with WithResponsePagingLimit. Only the server needs to be configured, but only clients
+// speaking version 4 of the envelope understand pages (see WireVersion): older clients receive the
+// responses as a whole. Paging is disabled by default.
+func WithResponsePaging(pageSize int) Option {
+	return func(opt *options) {
+		opt.pageSize = pageSize
+	}
+}
+
+// WithResponsePagingLimit limits the bytes of the pages the server keeps for the clients to request them
+// (see WithResponsePaging). Calls whose response would exceed the limit fail with codes.ResourceExhausted.
+// The limit defaults to 64 MiB.
+func WithResponsePagingLimit(maxBytes int) Option {
+	return func(opt *options) {
+		opt.pagedBytes = maxBytes
+	}
+}
+
+// WithCodec sets the codec the client marshals messages with. It defaults to the proto codec and
+// can be overwritten per call with the grpc.CallContentSubtype or grpc.ForceCodec call options.
+// The name of the codec is sent along with each message, so the server must have registered a
+// codec under the same name (see RegisterCodec). The server always answers with the codec of the request.
+func WithCodec(codec Codec) Option {
+	return func(opt *options) {
+		opt.codec = codec
+	}
+}
+
+// WithPerRPCCredentials adds credentials to the client which attach their metadata, e.g. an OAuth
+// token, to every call. Further credentials can be added per call with the grpc.PerRPCCredentials
+// call option. Securing the transport is up to the connection to the broker, so credentials requiring
+// transport security are attached as well.
+func WithPerRPCCredentials(creds credentials.PerRPCCredentials) Option {
+	return func(opt *options) {
+		opt.perRPCCreds = append(opt.perRPCCreds, creds)
+	}
+}
+
+// WithClientIdentity sets the identity the client announces with its calls. Servers expose it to their
+// handlers with the peer of a call (see PeerFromContext), so callers can be told apart in metrics, logs
+// and quotas. The identity should be stable across restarts of the client: use the name of the service
+// and an instance ID like the name of its pod rather than a random ID.
+func WithClientIdentity(identity ClientIdentity) Option {
+	return func(opt *options) {
+		opt.identity = identity
+	}
+}
+
+// WithAuthenticator sets the authenticator of the server validating the credentials of every call and
+// stream before the interceptors and handlers are invoked. Calls failing authentication are answered
+// with codes.Unauthenticated, unless the authenticator returns another status.
+func WithAuthenticator(auth Authenticator) Option {
+	return func(opt *options) {
+		opt.authenticator = auth
+	}
+}
+
+// WithAuthorizer sets the authorizer of the server deciding whether a call may invoke the method. It runs
+// after the authenticator (see WithAuthenticator), before the interceptors and handlers. Calls that are
+// not authorized are answered with codes.PermissionDenied, unless the authorizer returns another status.
+func WithAuthorizer(authz Authorizer) Option {
+	return func(opt *options) {
+		opt.authorizer = authz
+	}
+}
+
+// WithEncryption encrypts and authenticates the payloads of all messages end-to-end with AES-GCM and
+// the keys of the provider, for deployments where the broker is not trusted. Only the subjects remain
+// visible to the broker. Payloads are bound to the subject they are sent on, so the broker cannot redirect
+// them to other methods, tenants or streams, though it can still replay them. Clients, servers and
+// registries must share the keys and the subject mapping; messages failing the decryption are dropped.
+func WithEncryption(keys KeyProvider) Option {
+	return func(opt *options) {
+		opt.encryptionKeys = keys
+	}
+}
+
+// WithRetryPolicy sets the retry policy of the client for the given methods. Methods are given
+// as full method (/service/method) or as service name to apply the policy to all methods of the service.
+// Without methods the policy becomes the default for all methods. Retries are disabled by default.
+// The policy can be overwritten per call with the Retry call option.
+func WithRetryPolicy(policy RetryPolicy, methods ...string) Option {
+	return func(opt *options) {
+		if len(methods) == 0 {
+			opt.retryPolicies[""] = policy
+			return
+		}
+		for _, method := range methods {
+			opt.retryPolicies[method] = policy
+		}
+	}
+}
+
+// WithHedgingPolicy sets the hedging policy of the client for the given methods and thereby marks them
+// as idempotent. Methods are given as full method (/service/method) or as service name to apply the
+// policy to all methods of the service. Without methods the policy becomes the default for all methods.
+// Hedging is disabled by default. It takes precedence over a retry policy and can be overwritten per
+// call with the Hedge call option. Streams are never hedged.
+func WithHedgingPolicy(policy HedgingPolicy, methods ...string) Option {
+	return func(opt *options) {
+		if len(methods) == 0 {
+			opt.hedgingPolicies[""] = policy
+			return
+		}
+		for _, method := range methods {
+			opt.hedgingPolicies[method] = policy
+		}
+	}
+}
+
+// WithCircuitBreaker sets the circuit breaker policy of the client for the given methods. Methods are given
+// as full method (/service/method) or as service name to apply the policy to all methods of the service.
+// Without methods the policy becomes the default for all methods. Circuit breakers are disabled by default.
+func WithCircuitBreaker(policy CircuitBreakerPolicy, methods ...string) Option {
+	return func(opt *options) {
+		if len(methods) == 0 {
+			opt.breakerPolicies[""] = policy
+			return
+		}
+		for _, method := range methods {
+			opt.breakerPolicies[method] = policy
+		}
+	}
+}
+
+// WithDrainTimeout sets the time the server waits for in-flight calls and open streams
+// to finish on GracefulStop. It defaults to 30 seconds.
+func WithDrainTimeout(timeout time.Duration) Option {
+	return func(opt *options) {
+		opt.drainTimeout = timeout
+	}
+}
+
+// WithAnnouncements makes the server announce its instance ID, services and load on the discovery
+// subject every interval, so clients can keep track of the live instances with a Registry.
+// The server announces that it leaves when it is stopped. Announcing servers additionally serve
+// their methods on subjects of their instance, so clients can address them (see WithBalancingPolicy
+// and ToInstance). Announcements are disabled by default.
+func WithAnnouncements(interval time.Duration) Option {
+	return func(opt *options) {
+		opt.announceInterval = interval
+	}
+}
+
+// WithRegistry sets the registry the client discovers the live server instances with.
+// It is needed by the balancing policies set with WithBalancingPolicy.
+func WithRegistry(registry *Registry) Option {
+	return func(opt *options) {
+		opt.registry = registry
+	}
+}
+
+// WithBalancingPolicy sets the policy picking the server instance the calls of the given methods are sent to.
+// Methods are given as full method (/service/method) or as service name to apply the policy to all
+// methods of the service. Without methods the policy becomes the default for all methods. The instances
+// are discovered with the registry of the client (see WithRegistry). Calls are sent to the queue group
+// of the service as long as no instance of the service is discovered.
+func WithBalancingPolicy(policy BalancingPolicy, methods ...string) Option {
+	return func(opt *options) {
+		if len(methods) == 0 {
+			opt.balancingPolicies[""] = policy
+			return
+		}
+		for _, method := range methods {
+			opt.balancingPolicies[method] = policy
+		}
+	}
+}
+
+// WithSubjectMapper sets the mapper of the subjects used on the broker. It replaces the subject
+// segments set with WithSubjectPrefix, WithEnvironment and WithTenant.
+func WithSubjectMapper(mapper SubjectMapper) Option {
+	return func(opt *options) {
+		opt.subjectMapper = mapper
+	}
+}
+
+// WithSubjectPrefix prefixes all subjects used on the broker with the given prefix,
+// e.g. "acme.nrpc.<service>.<method>". The prefix may consist of multiple segments.
+func WithSubjectPrefix(prefix string) Option {
+	return func(opt *options) {
+		opt.subjectScheme.prefix = prefix
+	}
+}
+
+// WithEnvironment scopes all subjects used on the broker to the environment, e.g. "staging.nrpc.<service>.<method>".
+// The environment segment follows the prefix set with WithSubjectPrefix.
+func WithEnvironment(env string) Option {
+	return func(opt *options) {
+		opt.subjectScheme.environment = env
+	}
+}
+
+// WithTenant scopes all subjects used on the broker to the tenant, e.g. "acme.nrpc.<service>.<method>".
+// The tenant segment follows the prefix and the environment segments.
+func WithTenant(tenant string) Option {
+	return func(opt *options) {
+		opt.subjectScheme.tenant = tenant
+	}
+}
+
+// WithMultiTenancy scopes the subjects of all calls and streams under the tenant of the call.
+// Clients take the tenant from the context (see NewTenantContext) or the TenantMetadataKey of the
+// outgoing metadata and fail calls without a tenant with codes.InvalidArgument. Servers serve all
+// tenants and pass the tenant to the handlers with the context (see TenantFromContext). The tenant
+// segment follows the segments of WithSubjectPrefix and WithEnvironment, so brokers can isolate the
+// tenants by permissions on the subjects (see the nats package for NATS). Servers subscribe the subjects
+// of all tenants with a wildcard segment: servers on transports without wildcard subjects, e.g. Kafka
+// and MQTT, fail to run with pubsub.ErrWildcards. Clients work on all transports.
+func WithMultiTenancy() Option {
+	return func(opt *options) {
+		opt.multiTenant = true
+	}
+}
+
+// WithOutbox persists the messages published by the client in the store until they were delivered: until
+// the publisher succeeded for the messages of streams and until the response was received for unary
+// calls. The messages whose publication failed, e.g. as no server responded in time, and the messages
+// left in the store by a crashed process are published again by Client.Redeliver, which the application
+// calls at startup and, to redeliver failed messages at runtime, periodically. Calls are thereby
+// delivered at least once; servers should tolerate duplicates, e.g. by idempotent handlers. The store
+// holds the messages before they are encrypted (see WithEncryption).
+func WithOutbox(store OutboxStore) Option {
+	return func(opt *options) {
+		opt.outbox = store
+	}
+}
+
+// WithIdempotencyKeys makes the client send its unary calls to the given methods with a generated
+// idempotency key, so servers with idempotency enabled (see WithIdempotency) execute them once even
+// if they are retried or hedged. Methods are given as full method (/service/method) or as service name
+// to apply to all methods of the service. Without methods, keys are sent for all methods. The key can be
+// set per call with the IdempotencyKey call option.
+func WithIdempotencyKeys(methods ...string) Option {
+	return func(opt *options) {
+		if len(methods) == 0 {
+			opt.idempotentMethods[""] = true
+			return
+		}
+		for _, method := range methods {
+			opt.idempotentMethods[method] = true
+		}
+	}
+}
+
+// WithIdempotency makes the server execute unary calls with an idempotency key once: the successful
+// response is stored in the store for the ttl and sent in reply to later calls with the same key instead
+// of executing them again. Failed calls are executed again. A nil store keeps the responses in the memory
+// of the server, the ttl defaults to 10 minutes. Calls without a key are not affected. The keys are
+// scoped to the method, the tenant (see WithMultiTenancy) and the authenticated user (see Peer), and calls
+// are authenticated and authorized before a stored response is replayed, so callers cannot obtain the
+// responses of others by reusing their keys.
+func WithIdempotency(store IdempotencyStore, ttl time.Duration) Option {
+	return func(opt *options) {
+		if store == nil {
+			store = newMemoryIdempotencyStore()
+		}
+		opt.idempotencyStore = store
+		opt.idempotencyTTL = ttl
+	}
+}
+
+// WithPropagation propagates the incoming metadata of the keys to the calls and streams of the client.
+// Handlers making calls with the context of the call they serve forward the metadata without copying it,
+// e.g. the authorization header or the baggage of the caller. Keys ending in "*" match all keys with the
+// prefix. Values set in the outgoing metadata take precedence. The deadline, the tenant (see
+// NewTenantContext) and the trace context of the tracing package are carried by the context itself.
+func WithPropagation(keys ...string) Option {
+	return func(opt *options) {
+		opt.propagatedKeys = append(opt.propagatedKeys, keys...)
+	}
+}
+
+// WithQueueGroups sets the queue groups the server serves the given methods in. Methods are given as
+// full method (/service/method) or as service name to apply the groups to all methods of the service.
+// Without methods the groups become the default for all methods. Methods are served in the queue group
+// named after their service by default. Every queue group receives its own copy of a call, so servers
+// joining several groups handle a call once per group they are picked in. The empty group subscribes
+// without queue group (see WithBroadcast).
+func WithQueueGroups(groups []string, methods ...string) Option {
+	return func(opt *options) {
+		if len(methods) == 0 {
+			opt.queueGroups[""] = groups
+			return
+		}
+		for _, method := range methods {
+			opt.queueGroups[method] = groups
+		}
+	}
+}
+
+// WithBroadcast serves the given methods without queue group, so every instance of the server handles
+// every call, e.g. to invalidate caches or to fan out a notification. It is meant for unary methods, which
+// return the first response received. Methods are given as full method (/service/method) or as service name.
+func WithBroadcast(methods ...string) Option {
+	return WithQueueGroups([]string{""}, methods...)
+}
+
+// WithStreamQueueGroup sets the queue group the client and the server subscribe the subjects of their
+// streams with, e.g. to match the permissions of the broker. It defaults to "receive".
+func WithStreamQueueGroup(group string) Option {
+	return func(opt *options) {
+		opt.streamQueue = group
+	}
+}
+
+// WithHandlerTimeout bounds the time the handlers of the server take for the calls and streams of the given
+// methods, independent of the deadline sent by the client. Methods are given as full method (/service/method)
+// or as service name to apply the timeout to all methods of the service. Without methods the timeout becomes
+// the default for all methods. The context of the handler is canceled once the timeout or the deadline of the
+// client passes, whichever comes first, and the call fails with codes.DeadlineExceeded even if the handler
+// returns a result afterwards. Handlers are not bound by a timeout by default. The timeouts can be replaced
+// at runtime with Server.SetHandlerTimeouts.
+func WithHandlerTimeout(timeout time.Duration, methods ...string) Option {
+	return func(opt *options) {
+		if len(methods) == 0 {
+			opt.handlerTimeouts[""] = timeout
+			return
+		}
+		for _, method := range methods {
+			opt.handlerTimeouts[method] = timeout
+		}
+	}
+}
+
+// WithConcurrencyLimit limits the number of calls and streams the server handles concurrently for the given
+// methods. Methods are given as full method (/service/method) or as service name to apply the limit to all
+// methods of the service. Without methods the limit becomes the default for all methods. Each method is limited
+// separately. Calls are not limited by default.
+func WithConcurrencyLimit(limit ConcurrencyLimit, methods ...string) Option {
+	return func(opt *options) {
+		if len(methods) == 0 {
+			opt.concurrencyLimits[""] = limit
+			return
+		}
+		for _, method := range methods {
+			opt.concurrencyLimits[method] = limit
+		}
+	}
+}
+
+// WithGlobalConcurrencyLimit limits the number of calls and streams the server handles concurrently across all
+// methods. A call has to get a slot of its method (see WithConcurrencyLimit) before it waits for a global slot.
+// Calls are not limited by default.
+func WithGlobalConcurrencyLimit(limit ConcurrencyLimit) Option {
+	return func(opt *options) {
+		opt.globalLimit = limit
+	}
+}
+
+// MaxRecvMsgSize sets the maximum size in bytes of a message the client or server can receive.
+// Larger messages are rejected with codes.ResourceExhausted before they are unmarshaled. It defaults
+// to 4MB. The client can overwrite it per call with the grpc.MaxCallRecvMsgSize call option.
+func MaxRecvMsgSize(size int) Option {
+	return func(opt *options) {
+		opt.maxRecvMsgSize = size
+	}
+}
+
+// MaxSendMsgSize sets the maximum size in bytes of a message the client or server can send.
+// Larger messages are rejected with codes.ResourceExhausted before they are published. It defaults
+// to math.MaxInt32. The client can overwrite it per call with the grpc.MaxCallSendMsgSize call option.
+func MaxSendMsgSize(size int) Option {
+	return func(opt *options) {
+		opt.maxSendMsgSize = size
+	}
+}
+
+// WithWaitForReady sets whether calls of the client wait for the connection of the publisher to the
+// broker to be ready. Calls waiting for ready block until the connection is ready or their context is
+// done, while the other calls fail with codes.Unavailable if the connection is down. Without the option,
+// calls are passed to the publisher regardless of the state of its connection. Like the grpc.WaitForReady
+// call option overwriting it per call, it requires the publisher to implement pubsub.StateReporter.
+func WithWaitForReady(waitForReady bool) Option {
+	return func(opt *options) {
+		opt.readiness = readinessOf(waitForReady)
+	}
+}
+
+// WithServiceConfig configures the calls of the client per method with a service config, e.g. one read
+// with LoadServiceConfig: timeouts, waiting for ready, maximum message sizes and retry or hedging policies.
+// The service config takes precedence over the options of the client, call options take precedence over
+// the service config. Timeouts bound the calls in addition to the deadlines of their contexts. The service
+// config can be replaced at runtime with Client.SetServiceConfig.
+func WithServiceConfig(cfg *ServiceConfig) Option {
+	return func(opt *options) {
+		opt.serviceConfig = cfg
+	}
+}
+
+// WithStreamObserver sets an observer of the client or server that is notified about the receive
+// queue depth of streams and streams closed because of a stuck consumer. Observers implementing
+// DuplicateObserver are also notified about the duplicate frames streams dropped.
+func WithStreamObserver(observer StreamObserver) Option {
+	return func(opt *options) {
+		opt.observer = observer
+	}
+}
+
+// WithStreamEvents sets the callbacks of the client or server notified about slow consumers, stuck
+// consumers and streams aborted by nrpc. Unlike the log messages of these events, the callbacks let the
+// application react to them, e.g. by alerting or shedding load.
+func WithStreamEvents(events StreamEvents) Option {
+	return func(opt *options) {
+		opt.events = events
+	}
+}
+
+// WithWireTap sets a tap of the client or server receiving every frame it sends and receives
+// along with its subject, type, size and metadata.
+func WithWireTap(tap WireTap) Option {
+	return func(opt *options) {
+		opt.wireTap = tap
+	}
+}
+
+// WithWireVersion sets the version of the envelope the client or server speaks (see WireVersion). It
+// defaults to WireVersion. A new version of the envelope is rolled out by first deploying all peers
+// pinned to the previous version and then removing the option. Versions above WireVersion are lowered to it.
+func WithWireVersion(version uint32) Option {
+	return func(opt *options) {
+		if version > WireVersion {
+			version = WireVersion
+		}
+		opt.wireVersion = version
+	}
+}
+
 // UnaryInterceptor returns a ServerOption that sets the UnaryServerInterceptor for the
-// server. Only one unary interceptor can be installed. The construction of multiple
-// interceptors (e.g., chaining) can be implemented at the caller.
+// server. Only one unary interceptor can be installed. Use ChainUnaryInterceptor to
+// install multiple interceptors.
 func UnaryInterceptor(i grpc.UnaryServerInterceptor) Option {
 	return func(opt *options) {
 		if opt.unaryInt != nil {
@@ -47,8 +808,19 @@ func UnaryInterceptor(i grpc.UnaryServerInterceptor) Option {
 	}
 }
 
+// ChainUnaryInterceptor returns a ServerOption that specifies the chained interceptors
+// for unary RPCs. The first interceptor will be the outer most, while the last interceptor
+// will be the inner most wrapper around the real call. An interceptor set with
+// UnaryInterceptor is always executed first.
+func ChainUnaryInterceptor(interceptors ...grpc.UnaryServerInterceptor) Option {
+	return func(opt *options) {
+		opt.unaryInts = append(opt.unaryInts, interceptors...)
+	}
+}
+
 // StreamInterceptor returns a ServerOption that sets the StreamServerInterceptor for the
-// server. Only one stream interceptor can be installed.
+// server. Only one stream interceptor can be installed. Use ChainStreamInterceptor to
+// install multiple interceptors.
 func StreamInterceptor(i grpc.StreamServerInterceptor) Option {
 	return func(opt *options) {
 		if opt.streamInt != nil {
@@ -58,6 +830,77 @@ func StreamInterceptor(i grpc.StreamServerInterceptor) Option {
 	}
 }
 
+// ChainStreamInterceptor returns a ServerOption that specifies the chained interceptors
+// for streaming RPCs. The first interceptor will be the outer most, while the last interceptor
+// will be the inner most wrapper around the real call. An interceptor set with
+// StreamInterceptor is always executed first.
+func ChainStreamInterceptor(interceptors ...grpc.StreamServerInterceptor) Option {
+	return func(opt *options) {
+		opt.streamInts = append(opt.streamInts, interceptors...)
+	}
+}
+
+// UnknownServiceHandler returns an Option that sets the handler of the calls and streams of methods not
+// registered on the server, like grpc.UnknownServiceHandler, e.g. to build proxies and protocol translators.
+// The handler is subscribed to the subjects of all methods (nrpc.>) in the queue group "nrpc.unknown" and
+// invoked through the stream interceptors only. Unary calls are passed to it as streams receiving the request
+// as the only message and answered with the first message sent. The method is found with
+// grpc.MethodFromServerStream. To pass messages on without knowing their types, use a codec leaving them
+// marshaled (see RegisterCodec) or unmarshal them into a message keeping them as unknown fields.
+//
+// Methods served on subjects set with RegisterSubject are not received. Calls to the instances of unknown
+// methods (see WithAnnouncements) are passed on with the instance ID as method. The calls share the
+// concurrency limit of the server set with WithConcurrencyLimit without methods. The subscription relies
+// on wildcard subjects: servers on transports without them, e.g. Kafka and MQTT, fail to run with
+// pubsub.ErrWildcards.
+func UnknownServiceHandler(handler grpc.StreamHandler) Option {
+	return func(opt *options) {
+		opt.unknownHandler = handler
+	}
+}
+
+// WithUnaryInterceptor returns an Option that specifies the interceptor for unary RPCs of the client.
+// Interceptors added with WithChainUnaryInterceptor are executed after it.
+//
+// The *grpc.ClientConn passed to the interceptor is always nil.
+func WithUnaryInterceptor(i grpc.UnaryClientInterceptor) Option {
+	return func(opt *options) {
+		opt.unaryClientInt = i
+	}
+}
+
+// WithChainUnaryInterceptor returns an Option that specifies the chained interceptors for unary RPCs
+// of the client. The first interceptor will be the outer most, while the last interceptor will be
+// the inner most wrapper around the real call.
+//
+// The *grpc.ClientConn passed to the interceptors is always nil.
+func WithChainUnaryInterceptor(interceptors ...grpc.UnaryClientInterceptor) Option {
+	return func(opt *options) {
+		opt.unaryClientInts = append(opt.unaryClientInts, interceptors...)
+	}
+}
+
+// WithStreamInterceptor returns an Option that specifies the interceptor for streaming RPCs of the client.
+// Interceptors added with WithChainStreamInterceptor are executed after it.
+//
+// The *grpc.ClientConn passed to the interceptor is always nil.
+func WithStreamInterceptor(i grpc.StreamClientInterceptor) Option {
+	return func(opt *options) {
+		opt.streamClientInt = i
+	}
+}
+
+// WithChainStreamInterceptor returns an Option that specifies the chained interceptors for streaming
+// RPCs of the client. The first interceptor will be the outer most, while the last interceptor will be
+// the inner most wrapper around the real call.
+//
+// The *grpc.ClientConn passed to the interceptors is always nil.
+func WithChainStreamInterceptor(interceptors ...grpc.StreamClientInterceptor) Option {
+	return func(opt *options) {
+		opt.streamClientInts = append(opt.streamClientInts, interceptors...)
+	}
+}
+
 // StatsHandler returns a ServerOption that sets the StatsHandler for the server.
 // It can be used to add tracing, metrics, etc.
 func StatsHandler(handler stats.Handler) Option {
@@ -65,3 +908,12 @@ func StatsHandler(handler stats.Handler) Option {
 		opt.statsHandler = handler
 	}
 }
+
+// WithStatsHandler sets the StatsHandler of the client. Like with grpc, it receives the events of
+// all unary calls and streams of the client, so handlers of the grpc ecosystem like the ones of
+// otelgrpc work unchanged. The connection events of grpc are never reported.
+func WithStatsHandler(handler stats.Handler) Option {
+	return func(opt *options) {
+		opt.clientStatsHandler = handler
+	}
+}
@@ -0,0 +1,120 @@
+package nrpc
+
+import (
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// defaultReorderWindow is the number of frames OrderingReorder holds back if the policy does not set a window.
+const defaultReorderWindow = 16
+
+// OrderingMode defines how a stream handles frames received out of order or after a gap.
+type OrderingMode int
+
+const (
+	// OrderingOff does not verify the order of the received frames. This is the default.
+	OrderingOff OrderingMode = iota
+	// OrderingAbort aborts the stream with codes.DataLoss if a frame is received out of order.
+	OrderingAbort
+	// OrderingReorder holds back the frames received ahead of a missing frame and delivers them in order
+	// once the missing frame arrived. The stream is aborted with codes.DataLoss if the missing frame does
+	// not arrive before the window of held back frames is full.
+	OrderingReorder
+)
+
+// OrderingPolicy configures the verification of the order of the frames of a stream (see WithStreamOrdering).
+type OrderingPolicy struct {
+	// Mode defines how frames received out of order are handled.
+	Mode OrderingMode
+	// Window is the number of frames OrderingReorder holds back. It defaults to 16.
+	Window int
+}
+
+// window returns the number of frames held back by the policy.
+func (p OrderingPolicy) window() int {
+	if p.Mode != OrderingReorder {
+		return 0
+	}
+	if p.Window <= 0 {
+		return defaultReorderWindow
+	}
+	return p.Window
+}
+
+// reorderer verifies that the numbered frames of a stream are processed in order and without gaps.
+// Streams with resumption enabled recover lost frames instead (see resumer), so the frames pass it
+// unchecked.
+type reorderer struct {
+	policy OrderingPolicy
+
+	m      sync.Mutex
+	next   uint64
+	held   map[uint64]func()
+	failed bool
+}
+
+func newReorderer(policy OrderingPolicy) *reorderer {
+	return &reorderer{policy: policy, next: 1}
+}
+
+// newSequencing returns the resumer and the reorderer of a stream. Resumption restores the order of
+// the frames on its own, so the reorderer of streams with resumption enabled passes all frames.
+func newSequencing(resumeBuffer int, ordering OrderingPolicy) (*resumer, *reorderer) {
+	resume := newResumer(resumeBuffer)
+	if resume.enabled() {
+		ordering = OrderingPolicy{}
+	}
+	return resume, newReorderer(ordering)
+}
+
+// receive processes the frame with the sequence number in order. Frames received ahead of a missing
+// frame are held back by OrderingReorder and processed once the missing frame arrived. Frames received
+// twice are dropped. It fails with codes.DataLoss if the order of the frames cannot be restored. All
+// frames received after it failed are dropped.
+func (r *reorderer) receive(seq uint64, process func()) error {
+	if r.policy.Mode == OrderingOff || seq == 0 {
+		process()
+		return nil
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	switch {
+	case r.failed || seq < r.next:
+		return nil
+	case seq > r.next:
+		if r.policy.Mode == OrderingAbort {
+			return r.fail(status.Errorf(codes.DataLoss, "nrpc: received frame %d out of order, expected frame %d", seq, r.next))
+		}
+		if r.held == nil {
+			r.held = map[uint64]func(){}
+		}
+		r.held[seq] = process
+		if len(r.held) > r.policy.window() {
+			return r.fail(status.Errorf(codes.DataLoss, "nrpc: frame %d is missing after holding back %d frames", r.next, len(r.held)-1))
+		}
+		return nil
+	}
+
+	process()
+	r.next++
+	for {
+		process, ok := r.held[r.next]
+		if !ok {
+			return nil
+		}
+		delete(r.held, r.next)
+		process()
+		r.next++
+	}
+}
+
+// fail drops the held back frames and returns the error.
+func (r *reorderer) fail(err error) error {
+	r.failed = true
+	r.held = nil
+	return err
+}
@@ -0,0 +1,195 @@
+package nrpc
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/tehsphinx/nrpc/pubsub"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// OutboxEntry is a message persisted in the outbox (see WithOutbox).
+type OutboxEntry struct {
+	// ID identifies the entry. It is the ID of the published message, so transports with at-least-once
+	// delivery deduplicate redelivered messages.
+	ID      string
+	Subject string
+	Data    []byte
+	// Call reports that the message is the request of a unary call.
+	Call    bool
+	Created time.Time
+}
+
+// OutboxStore persists the entries of the outbox. The outbox package implements stores for bbolt and
+// SQLite, other stores implement the interface. It must be safe for concurrent use.
+type OutboxStore interface {
+	// Put persists the entry. It replaces the entry with the same ID, e.g. the entry of the previous
+	// attempt of a retried call, keeping its position in the order of the entries.
+	Put(entry OutboxEntry) error
+	// Delete removes the entry with the ID.
+	Delete(id string) error
+	// Pending returns the persisted entries in the order they were put.
+	Pending() ([]OutboxEntry, error)
+}
+
+// outbox persists the messages of a publisher until they were delivered, i.e. until the publisher
+// succeeded for published messages and until the response was received for requests. The messages
+// whose publication failed and the messages of a crashed process remain in the store. All attempts of a
+// unary call are published with the same ID, so they share their entry.
+type outbox struct {
+	pub   pubsub.Publisher
+	store OutboxStore
+	log   Logger
+
+	m sync.Mutex
+	// inflight counts the holders of the IDs of the messages still being delivered, which are not redelivered.
+	inflight map[string]int
+	// redelivering serializes the redeliveries.
+	redelivering sync.Mutex
+}
+
+func newOutbox(pub pubsub.Publisher, store OutboxStore, log Logger) *outbox {
+	return &outbox{pub: pub, store: store, log: log, inflight: map[string]int{}}
+}
+
+// acquire marks the message with the ID as being delivered until the returned function is called.
+// Clients hold the ID of a unary call for all of its attempts.
+func (o *outbox) acquire(id string) func() {
+	o.m.Lock()
+	o.inflight[id]++
+	o.m.Unlock()
+
+	return func() {
+		o.m.Lock()
+		defer o.m.Unlock()
+		if o.inflight[id]--; o.inflight[id] == 0 {
+			delete(o.inflight, id)
+		}
+	}
+}
+
+func (o *outbox) isInflight(id string) bool {
+	o.m.Lock()
+	defer o.m.Unlock()
+	return o.inflight[id] != 0
+}
+
+// Publish implements the pubsub.Publisher interface.
+func (o *outbox) Publish(msg pubsub.Message) error {
+	defer o.acquire(withID(&msg))()
+	entry, err := o.put(msg, false)
+	if err != nil {
+		return err
+	}
+	if r := o.pub.Publish(msg); r != nil {
+		return r
+	}
+	o.delete(entry)
+	return nil
+}
+
+// Request implements the pubsub.Publisher interface.
+func (o *outbox) Request(ctx context.Context, msg pubsub.Message) (pubsub.Message, error) {
+	defer o.acquire(withID(&msg))()
+	entry, err := o.put(msg, true)
+	if err != nil {
+		return pubsub.Message{}, err
+	}
+	// responses are received for errors of the server too: without one, e.g. on a timeout, the request may
+	// not have been delivered.
+	resp, err := o.pub.Request(ctx, msg)
+	if err != nil {
+		return pubsub.Message{}, err
+	}
+	o.delete(entry)
+	return resp, nil
+}
+
+// withID gives the message an ID unless it has one and returns the ID.
+func withID(msg *pubsub.Message) string {
+	if msg.ID == "" {
+		msg.ID = randString(callIDLen)
+	}
+	return msg.ID
+}
+
+// put persists the message before it is published, replacing the entry of a previous attempt.
+func (o *outbox) put(msg pubsub.Message, call bool) (OutboxEntry, error) {
+	entry := OutboxEntry{
+		ID:      msg.ID,
+		Subject: msg.Subject,
+		Data:    msg.Data,
+		Call:    call,
+		Created: time.Now(),
+	}
+	if r := o.store.Put(entry); r != nil {
+		return OutboxEntry{}, status.Errorf(codes.Unavailable, "nrpc: failed to persist the message in the outbox: %v", r)
+	}
+	return entry, nil
+}
+
+func (o *outbox) delete(entry OutboxEntry) {
+	if r := o.store.Delete(entry.ID); r != nil {
+		o.log.Error("failed to delete the message from the outbox", "subject", entry.Subject, "id", entry.ID, "error", r)
+	}
+}
+
+// redeliver publishes the pending entries not being delivered and deletes them once published. Unary
+// calls are published one-way, as nobody waits for their response anymore.
+func (o *outbox) redeliver() (int, error) {
+	o.redelivering.Lock()
+	defer o.redelivering.Unlock()
+
+	entries, err := o.store.Pending()
+	if err != nil {
+		return 0, err
+	}
+
+	var n int
+	for _, entry := range entries {
+		if o.isInflight(entry.ID) {
+			continue
+		}
+		data, r := redeliveredData(entry)
+		if r != nil {
+			return n, r
+		}
+		if r := o.pub.Publish(pubsub.Message{Subject: entry.Subject, Data: data, ID: entry.ID}); r != nil {
+			return n, r
+		}
+		o.delete(entry)
+		n++
+	}
+	return n, nil
+}
+
+// redeliveredData returns the data of the entry to redeliver. The requests of unary calls are marked
+// as one-way, so the server does not reply, and lose the timeout of their caller, which passed: like
+// jobs, they are bound by the handler timeouts of the server.
+func redeliveredData(entry OutboxEntry) ([]byte, error) {
+	if !entry.Call {
+		return entry.Data, nil
+	}
+	req, err := unmarshalReq(entry.Data)
+	if err != nil {
+		return nil, err
+	}
+	req.OneWay = true
+	req.Timeout = 0
+	return proto.Marshal(req)
+}
+
+// Redeliver publishes the messages left in the outbox (see WithOutbox), e.g. because their publication
+// failed or a previous run of the process crashed. Unary calls are redelivered one-way, as nobody waits
+// for their response anymore. It returns the number of redelivered messages. It can be called at any
+// time, e.g. at startup and periodically: the messages of the calls and streams still in progress are
+// left to them.
+func (s *Client) Redeliver() (int, error) {
+	if s.outbox == nil {
+		return 0, nil
+	}
+	return s.outbox.redeliver()
+}
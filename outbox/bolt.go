@@ -0,0 +1,103 @@
+package outbox
+
+import (
+	"encoding/binary"
+	"encoding/json"
+
+	"github.com/tehsphinx/nrpc"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// entriesBucket holds the entries by their sequence number, so they are iterated in the order they were put.
+	entriesBucket = []byte("entries")
+	// idsBucket maps the IDs of the entries to their sequence number.
+	idsBucket = []byte("ids")
+)
+
+// Bolt is an outbox store persisting the entries in a bbolt database.
+type Bolt struct {
+	db *bolt.DB
+}
+
+// NewBolt opens the bbolt database at the path as outbox store. The database is created if it does not exist.
+func NewBolt(path string) (*Bolt, error) {
+	db, err := bolt.Open(path, 0o600, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, r := tx.CreateBucketIfNotExists(entriesBucket); r != nil {
+			return r
+		}
+		_, r := tx.CreateBucketIfNotExists(idsBucket)
+		return r
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &Bolt{db: db}, nil
+}
+
+// Put implements the nrpc.OutboxStore interface.
+func (s *Bolt) Put(entry nrpc.OutboxEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		entries := tx.Bucket(entriesBucket)
+		// the entry replacing another one keeps its key
+		key := append([]byte(nil), tx.Bucket(idsBucket).Get([]byte(entry.ID))...)
+		if key == nil {
+			seq, r := entries.NextSequence()
+			if r != nil {
+				return r
+			}
+			key = make([]byte, 8)
+			binary.BigEndian.PutUint64(key, seq)
+		}
+
+		if r := entries.Put(key, data); r != nil {
+			return r
+		}
+		return tx.Bucket(idsBucket).Put([]byte(entry.ID), key)
+	})
+}
+
+// Delete implements the nrpc.OutboxStore interface.
+func (s *Bolt) Delete(id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		ids := tx.Bucket(idsBucket)
+		key := ids.Get([]byte(id))
+		if key == nil {
+			return nil
+		}
+		if r := tx.Bucket(entriesBucket).Delete(key); r != nil {
+			return r
+		}
+		return ids.Delete([]byte(id))
+	})
+}
+
+// Pending implements the nrpc.OutboxStore interface.
+func (s *Bolt) Pending() ([]nrpc.OutboxEntry, error) {
+	var pending []nrpc.OutboxEntry
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(entriesBucket).ForEach(func(_, data []byte) error {
+			var entry nrpc.OutboxEntry
+			if r := json.Unmarshal(data, &entry); r != nil {
+				return r
+			}
+			pending = append(pending, entry)
+			return nil
+		})
+	})
+	return pending, err
+}
+
+// Close closes the database.
+func (s *Bolt) Close() error {
+	return s.db.Close()
+}
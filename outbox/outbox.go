@@ -0,0 +1,62 @@
+// Package outbox implements the stores of the outbox of nrpc clients. The outbox persists the messages
+// published by a client until they were delivered, so the messages of a crashed process or of failed
+// publications are delivered again when it restarts:
+//
+//	store, err := outbox.NewBolt("outbox.db")
+//	client := nrpc.NewClient(pub, sub, nrpc.WithOutbox(store))
+//	if _, err := client.Redeliver(); err != nil {
+//		...
+//	}
+//
+// Bolt persists the messages in a bbolt database, SQLite in a table of a SQLite database opened with
+// the driver of choice, e.g. in the database of the application. Memory keeps the messages in memory,
+// e.g. for tests. Other stores implement the nrpc.OutboxStore interface.
+package outbox
+
+import (
+	"sync"
+
+	"github.com/tehsphinx/nrpc"
+)
+
+// Memory is an outbox store keeping the entries in memory. It does not survive a crash of the process.
+type Memory struct {
+	m       sync.Mutex
+	entries []nrpc.OutboxEntry
+}
+
+// NewMemory creates an outbox store keeping the entries in memory.
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+// Put implements the nrpc.OutboxStore interface.
+func (s *Memory) Put(entry nrpc.OutboxEntry) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.entries = append(s.entries, entry)
+	return nil
+}
+
+// Delete implements the nrpc.OutboxStore interface.
+func (s *Memory) Delete(id string) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	for i, entry := range s.entries {
+		if entry.ID == id {
+			s.entries = append(s.entries[:i], s.entries[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+// Pending implements the nrpc.OutboxStore interface.
+func (s *Memory) Pending() ([]nrpc.OutboxEntry, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return append([]nrpc.OutboxEntry(nil), s.entries...), nil
+}
@@ -0,0 +1,73 @@
+package outbox
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/tehsphinx/nrpc"
+)
+
+// sqliteSchema creates the table of the entries. seq orders them in the order they were put.
+const sqliteSchema = `CREATE TABLE IF NOT EXISTS nrpc_outbox (
+	seq INTEGER PRIMARY KEY AUTOINCREMENT,
+	id TEXT NOT NULL UNIQUE,
+	subject TEXT NOT NULL,
+	data BLOB,
+	call INTEGER NOT NULL,
+	created INTEGER NOT NULL
+)`
+
+// SQLite is an outbox store persisting the entries in the table nrpc_outbox of a SQLite database.
+type SQLite struct {
+	db *sql.DB
+}
+
+// NewSQLite uses the SQLite database as outbox store. The database is opened with the driver of choice,
+// e.g. github.com/mattn/go-sqlite3 or modernc.org/sqlite, and may be shared with the data of the
+// application. The table of the entries is created if it does not exist. Closing the database is up to
+// the caller.
+func NewSQLite(db *sql.DB) (*SQLite, error) {
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		return nil, err
+	}
+	return &SQLite{db: db}, nil
+}
+
+// Put implements the nrpc.OutboxStore interface.
+func (s *SQLite) Put(entry nrpc.OutboxEntry) error {
+	// the entry replacing another one keeps its sequence number
+	_, err := s.db.Exec(`INSERT INTO nrpc_outbox (id, subject, data, call, created) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET subject = excluded.subject, data = excluded.data, call = excluded.call,
+		created = excluded.created`,
+		entry.ID, entry.Subject, entry.Data, entry.Call, entry.Created.UnixNano())
+	return err
+}
+
+// Delete implements the nrpc.OutboxStore interface.
+func (s *SQLite) Delete(id string) error {
+	_, err := s.db.Exec(`DELETE FROM nrpc_outbox WHERE id = ?`, id)
+	return err
+}
+
+// Pending implements the nrpc.OutboxStore interface.
+func (s *SQLite) Pending() ([]nrpc.OutboxEntry, error) {
+	rows, err := s.db.Query(`SELECT id, subject, data, call, created FROM nrpc_outbox ORDER BY seq`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pending []nrpc.OutboxEntry
+	for rows.Next() {
+		var (
+			entry   nrpc.OutboxEntry
+			created int64
+		)
+		if r := rows.Scan(&entry.ID, &entry.Subject, &entry.Data, &entry.Call, &created); r != nil {
+			return nil, r
+		}
+		entry.Created = time.Unix(0, created)
+		pending = append(pending, entry)
+	}
+	return pending, rows.Err()
+}
@@ -0,0 +1,222 @@
+package nrpc
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/tehsphinx/nrpc/pubsub"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// pagingVersion is the version of the envelope introducing unary responses split into pages.
+	pagingVersion uint32 = 4
+
+	// pageTTL is the time a server keeps the pages of a response for the client to request them.
+	pageTTL = 30 * time.Second
+	// defaultPagedBytes is the default of the bytes of pages a server keeps at most (see WithResponsePagingLimit).
+	defaultPagedBytes = 64 << 20
+)
+
+// pageSubj returns the subject the server with the instance ID serves the pages of its responses on.
+func pageSubj(serverID string) string {
+	return "nrpc.page." + serverID
+}
+
+// pager splits unary responses exceeding the page size into pages and keeps the pages following
+// the first one for the client to request them (see WithResponsePaging).
+type pager struct {
+	subj string
+	// limit is the number of bytes of pages kept at most.
+	limit int
+
+	m         sync.Mutex
+	chunker   *chunker
+	responses map[string]*pagedResponse
+	// held is the number of bytes of the kept pages.
+	held int
+}
+
+// pagedResponse holds the pages of a response following the first one.
+type pagedResponse struct {
+	pages [][]byte
+	size  int
+	// tenant is the tenant of the call (see WithMultiTenancy). Only requests of the tenant get the pages.
+	tenant string
+}
+
+func newPager(size, limit int, subj string) *pager {
+	return &pager{
+		subj:      subj,
+		limit:     limit,
+		chunker:   &chunker{size: size},
+		responses: map[string]*pagedResponse{},
+	}
+}
+
+// split returns the payload of the reply to a unary call of the tenant received on the subject. Payloads
+// exceeding the page size are split into pages: the reply carries the first page, the subject the client
+// requests the following pages on and the random key of the response the client requests them with.
+// Calls whose pages would exceed the limit of the kept pages fail with codes.ResourceExhausted.
+func (p *pager) split(subj string, payload []byte, tenant string) ([]byte, error) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if !p.chunker.needsSplit(payload) {
+		return payload, nil
+	}
+	key, err := secretString(callIDLen)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "nrpc: failed to generate the key of the paged response: %v", err)
+	}
+	pages, err := p.chunker.split(payload, func(chunk *Chunk) ([]byte, error) {
+		resp := &Response{Chunk: chunk}
+		if chunk.Index == 0 {
+			resp.PageSubject = p.subj
+			resp.PageKey = key
+		}
+		return marshalPage(subj, resp)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &pagedResponse{pages: pages[1:], tenant: tenant}
+	for _, page := range resp.pages {
+		resp.size += len(page)
+	}
+	if p.held+resp.size > p.limit {
+		return nil, status.Errorf(codes.ResourceExhausted, "nrpc: the server keeps too many pages of responses to page a response of %d bytes", len(payload))
+	}
+	p.held += resp.size
+	p.responses[key] = resp
+	time.AfterFunc(pageTTL, func() { p.remove(key) })
+	return pages[0], nil
+}
+
+// page returns the page of the response with the key to a request of the tenant. The pages are kept until
+// they expire, so requests for a page can be repeated, e.g. if the reply got lost. Requests of other tenants
+// fail like requests of unknown responses, so they learn nothing.
+func (p *pager) page(key string, index uint32, tenant string) ([]byte, error) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	resp, ok := p.responses[key]
+	if !ok || resp.tenant != tenant || index == 0 || int(index) > len(resp.pages) {
+		return nil, status.Errorf(codes.NotFound, "nrpc: page %d of the response not found", index)
+	}
+	return resp.pages[index-1], nil
+}
+
+func (p *pager) remove(key string) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if resp, ok := p.responses[key]; ok {
+		p.held -= resp.size
+		delete(p.responses, key)
+	}
+}
+
+// marshalPage marshals a page of a response into the envelope of unary replies.
+func marshalPage(subj string, resp *Response) ([]byte, error) {
+	data, err := proto.Marshal(resp)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(&Message{
+		Subject: subj,
+		Data:    data,
+		Type:    MessageType_Data,
+	})
+}
+
+// registerPages subscribes the server to the requests of clients for the pages of its responses.
+func (s *Server) registerPages() {
+	subj := s.servedSubjects().MapSubject(pageSubj(s.id))
+	s.pager = newPager(s.pageSize, s.pagedBytes, pageSubj(s.id))
+	s.subs.RegisterSubscription(subscription{
+		endpoint: subj,
+		handler:  s.tenantHandler(subj, s.cfg.tap.handler("", FrameData, s.handlePage)),
+		control:  true,
+	})
+}
+
+// handlePage replies to the request of a client for a page of a response. Page requests are not
+// authenticated: the secret key of the response, only known to its caller, grants access to its pages,
+// which are only returned to the tenant of the caller.
+func (s *Server) handlePage(ctx context.Context, msg pubsub.Replier) {
+	req, err := unmarshalReq(msg.Data())
+	if err != nil {
+		s.respondErr(msg, err)
+		return
+	}
+	if req.Chunk == nil {
+		s.respondErr(msg, status.Error(codes.InvalidArgument, "nrpc: request for a page without a chunk"))
+		return
+	}
+	tenant, _ := TenantFromContext(ctx)
+	page, err := s.pager.page(req.PageKey, req.Chunk.Index, tenant)
+	if err != nil {
+		s.respondErr(msg, err)
+		return
+	}
+	s.reply(msg, page)
+}
+
+// replyPaged answers a unary call with the context with the payload, split into pages if it exceeds the
+// page size and the client understands pages.
+func (s *Server) replyPaged(ctx context.Context, msg pubsub.Replier, payload []byte, version uint32) {
+	if s.pager != nil && version >= pagingVersion {
+		var err error
+		tenant, _ := TenantFromContext(ctx)
+		if payload, err = s.pager.split(msg.Subject(), payload, tenant); err != nil {
+			s.respondErr(msg, err)
+			return
+		}
+	}
+	s.reply(msg, payload)
+}
+
+// fetchPages requests the pages of a response following the first page and returns the reassembled response.
+func (s *Client) fetchPages(ctx context.Context, method string, callOpts callOptions, first *Response) (*Response, error) {
+	if first.Chunk == nil {
+		return nil, status.Error(codes.Internal, "nrpc: paged response without a page")
+	}
+	subj := callOpts.subjects.MapSubject(first.PageSubject)
+	pages := newReassembler()
+	data, complete, err := pages.add(first.Chunk)
+	for index := uint32(1); err == nil && !complete; index++ {
+		var payload []byte
+		payload, err = proto.Marshal(&Request{
+			Chunk:   &Chunk{Id: first.Chunk.Id, Index: index},
+			PageKey: first.PageKey,
+		})
+		if err != nil {
+			break
+		}
+
+		s.cfg.tap.request(ctx, Frame{Direction: FrameSent, Method: method, Subject: subj, Data: payload})
+		var res pubsub.Message
+		if res, err = s.pub.Request(ctx, pubsub.Message{Subject: subj, Data: payload}); err != nil {
+			break
+		}
+		s.cfg.tap.message(ctx, Frame{Direction: FrameReceived, Method: method, Subject: subj, Data: res.Data})
+
+		var page *Response
+		if page, err = unmarshalUnaryResp(res.Data); err != nil {
+			break
+		}
+		if page.Chunk == nil {
+			return nil, status.Errorf(codes.Internal, "nrpc: page %d of response missing", index)
+		}
+		data, complete, err = pages.add(page.Chunk)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalUnaryResp(data)
+}
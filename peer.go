@@ -0,0 +1,101 @@
+package nrpc
+
+import (
+	"context"
+
+	"github.com/tehsphinx/nrpc/pubsub"
+	"google.golang.org/grpc/peer"
+)
+
+// Peer describes the client of a call or stream handled by a server. The server passes it to the
+// interceptors and handlers with the context (see PeerFromContext).
+type Peer struct {
+	// ReplySubject is the subject the responses to the client are published to: the reply subject of
+	// a unary call or the response subject of a stream. It is empty for unary calls if the transport
+	// does not expose the reply subject of received messages (see pubsub.ReplyAddresser).
+	ReplySubject string
+	// ClientID identifies the connection of the client to the broker, e.g. the client ID assigned by the
+	// NATS server. It is empty if the publisher of the client does not know it (see pubsub.ClientIdentifier).
+	ClientID string
+	// User is the identity of the authenticated client. Authenticators set it with NewContextWithPeer,
+	// the ones of the auth package do so with the claims they add to the context.
+	User string
+	// Transport names the transport the call was received on, e.g. "nats". It is empty if the
+	// subscriber of the server does not name it (see pubsub.Namer).
+	Transport string
+	// Identity is the identity announced by the client. It is empty if the client does not announce one.
+	Identity ClientIdentity
+}
+
+// ClientIdentity is the identity a client announces with its calls (see WithClientIdentity).
+type ClientIdentity struct {
+	// Service is the name of the service the client belongs to, e.g. "billing".
+	Service string
+	// Version is the version of the service, e.g. "v1.4.2".
+	Version string
+	// Instance identifies the instance of the service, e.g. the name of its pod.
+	Instance string
+}
+
+// String returns the identity as service/version/instance, leaving out empty parts.
+func (id ClientIdentity) String() string {
+	s := id.Service
+	for _, part := range []string{id.Version, id.Instance} {
+		if part != "" {
+			s += "/" + part
+		}
+	}
+	return s
+}
+
+// announce sets the identity on a request.
+func (id ClientIdentity) announce(req *Request) {
+	req.ClientService = id.Service
+	req.ClientVersion = id.Version
+	req.ClientInstance = id.Instance
+}
+
+// announcedIdentity returns the identity announced with a request.
+func announcedIdentity(req *Request) ClientIdentity {
+	return ClientIdentity{
+		Service:  req.ClientService,
+		Version:  req.ClientVersion,
+		Instance: req.ClientInstance,
+	}
+}
+
+type peerKey struct{}
+
+// NewContextWithPeer returns a context carrying the peer.
+func NewContextWithPeer(ctx context.Context, p *Peer) context.Context {
+	return context.WithValue(ctx, peerKey{}, p)
+}
+
+// PeerFromContext returns the peer of the call or stream handled with the context.
+func PeerFromContext(ctx context.Context) (*Peer, bool) {
+	p, ok := ctx.Value(peerKey{}).(*Peer)
+	return p, ok
+}
+
+// newPeerContext adds the peer to the context of a call handled by the server. The reply subject is
+// also set as the address of the grpc peer, so code using peer.FromContext works unchanged.
+func newPeerContext(ctx context.Context, p *Peer) context.Context {
+	ctx = peer.NewContext(ctx, &peer.Peer{Addr: subjectAddr(p.ReplySubject)})
+	return NewContextWithPeer(ctx, p)
+}
+
+// transportName returns the name of the transport of the subscriber.
+func transportName(sub pubsub.Subscriber) string {
+	if namer, ok := sub.(pubsub.Namer); ok {
+		return namer.Name()
+	}
+	return ""
+}
+
+// replySubject returns the reply subject of a received message.
+func replySubject(msg pubsub.Replier) string {
+	if addresser, ok := msg.(pubsub.ReplyAddresser); ok {
+		return addresser.ReplySubject()
+	}
+	return ""
+}
@@ -0,0 +1,71 @@
+package nrpc
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// propagation holds the metadata keys propagated from the incoming to the outgoing metadata
+// (see WithPropagation). Keys ending in "*" are prefixes.
+type propagation struct {
+	keys     []string
+	prefixes []string
+}
+
+func newPropagation(keys []string) propagation {
+	var p propagation
+	for _, key := range keys {
+		key = strings.ToLower(key)
+		if strings.HasSuffix(key, "*") {
+			p.prefixes = append(p.prefixes, strings.TrimSuffix(key, "*"))
+			continue
+		}
+		p.keys = append(p.keys, key)
+	}
+	return p
+}
+
+// propagates reports whether the metadata key is propagated.
+func (p propagation) propagates(key string) bool {
+	for _, k := range p.keys {
+		if k == key {
+			return true
+		}
+	}
+	for _, prefix := range p.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// apply returns the context with the propagated keys of its incoming metadata added to the outgoing
+// metadata. Keys already set in the outgoing metadata are not overwritten.
+func (p propagation) apply(ctx context.Context) context.Context {
+	if len(p.keys) == 0 && len(p.prefixes) == 0 {
+		return ctx
+	}
+	incoming, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx
+	}
+
+	outgoing, _ := metadata.FromOutgoingContext(ctx)
+	var added metadata.MD
+	for key, values := range incoming {
+		if !p.propagates(key) || len(outgoing.Get(key)) != 0 {
+			continue
+		}
+		if added == nil {
+			added = outgoing.Copy()
+		}
+		added[key] = append([]string(nil), values...)
+	}
+	if added == nil {
+		return ctx
+	}
+	return metadata.NewOutgoingContext(ctx, added)
+}
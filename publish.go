@@ -0,0 +1,117 @@
+package nrpc
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/tehsphinx/nrpc/pubsub"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// eventService is the pseudo service of the events: an event published on a subject is a one-way call
+// of the method /nrpc.Event/<subject>. Jobs (see Server.HandleJob) use the pseudo service nrpc.Job.
+const (
+	eventService = "nrpc.Event"
+	jobService   = "nrpc.Job"
+)
+
+// EventMethod returns the full method (/service/method) events published on the subject are handled as.
+// The interceptors, stats handlers and wire taps of clients and servers see it as the method of the
+// event; per-method options such as handler timeouts and concurrency limits of servers apply to it.
+func EventMethod(subject string) string {
+	return "/" + eventService + "/" + subject
+}
+
+// eventSubj returns the subject the events or jobs of the method are published on, if it is the method
+// of an event or job.
+func eventSubj(method string) (string, bool) {
+	if subject := strings.TrimPrefix(method, "/"+eventService+"/"); len(subject) != len(method) {
+		return "nrpc.event." + subject, true
+	}
+	if subject := strings.TrimPrefix(method, "/"+jobService+"/"); len(subject) != len(method) {
+		return "nrpc.job." + subject, true
+	}
+	return "", false
+}
+
+// PublishEvent publishes the event on the subject to all servers handling it (see Server.HandleEvent).
+// Events are sent like one-way calls of EventMethod(subject): they share codec, compression, metadata,
+// propagation and the interceptors of the client, but the servers never reply. The event is published
+// once the client is ready; it is lost if no server handles it.
+func (s *Client) PublishEvent(ctx context.Context, subject string, event interface{}, opts ...grpc.CallOption) error {
+	return s.Invoke(ctx, EventMethod(subject), event, nil, append(opts, OneWay())...)
+}
+
+// EventHandler handles an event received by a server (see Server.HandleEvent).
+type EventHandler func(ctx context.Context, event interface{}) error
+
+// HandleEvent registers the handler for the events published on the subject (see Client.PublishEvent).
+// newEvent returns the message the event is decoded into. Like subscriptions of the broker, every server
+// handling the subject receives the events unless the queue group is set: the events are then handled by
+// one server of the group only. Events run through the same stack as unary calls of EventMethod(subject):
+// the incoming metadata, the stats handler and the unary interceptors of the server. Errors of the
+// handler are reported to them but never reach the publisher. Like RegisterService, it panics if it is
+// called twice for the same subject and queue group or after the server started.
+func (s *Server) HandleEvent(subject, queue string, newEvent func() interface{}, handler EventHandler) {
+	s.registerEvent("HandleEvent", subject, EventMethod(subject), queue, newEvent, handler, nil)
+}
+
+// registerEvent subscribes the handler of the events or jobs on the subject, handled as the full method,
+// in the queue group. name is the function registering them. wrap wraps the subscribed handler if it is
+// not nil.
+func (s *Server) registerEvent(name, subject, fullMethod, queue string, newEvent func() interface{},
+	handler EventHandler, wrap func(pubsub.Handler) pubsub.Handler) {
+	subj, _ := eventSubj(fullMethod)
+
+	s.m.Lock()
+	if s.serving {
+		s.m.Unlock()
+		panic(fmt.Sprintf("nrpc: Server.%s of %q after the server started", name, subject))
+	}
+	key := subj + "\x00" + queue
+	if _, ok := s.events[key]; ok {
+		s.m.Unlock()
+		panic(fmt.Sprintf("nrpc: Server.%s found duplicate registration for %q", name, subject))
+	}
+	s.events[key] = struct{}{}
+	s.m.Unlock()
+
+	desc := grpc.MethodDesc{
+		MethodName: subject,
+		Handler: func(_ interface{}, ctx context.Context, dec func(interface{}) error,
+			interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			job := JobFromContext(ctx)
+			event := newEvent()
+			if err := dec(event); err != nil {
+				job.handled(err, true)
+				return nil, err
+			}
+			handle := func(ctx context.Context, event interface{}) (interface{}, error) {
+				return &emptypb.Empty{}, handler(ctx, event)
+			}
+			if interceptor == nil {
+				resp, err := handle(ctx, event)
+				job.handled(err, false)
+				return resp, err
+			}
+			resp, err := interceptor(ctx, event, &grpc.UnaryServerInfo{FullMethod: fullMethod}, handle)
+			job.handled(err, false)
+			return resp, err
+		},
+	}
+	h := s.recoverHandler(fullMethod, s.handleMethod(fullMethod, desc, nil, newLimiter(s.limits.get(fullMethod))))
+	h = s.cfg.tap.handler(fullMethod, FrameData, h)
+
+	endpoint := s.servedSubjects().MapSubject(subj)
+	h = s.tenantHandler(endpoint, h)
+	if wrap != nil {
+		h = wrap(h)
+	}
+	s.subs.RegisterSubscription(subscription{
+		endpoint: endpoint,
+		queue:    queue,
+		handler:  h,
+	})
+}
@@ -0,0 +1,109 @@
+// Package jetstream implements the pub/sub interfaces backed by NATS JetStream.
+//
+// The messages of streams are persisted in JetStream and delivered at least once, so streams
+// survive broker restarts and slow consumers. Redelivered messages are detected by their message ID.
+// Jobs (see nrpc.Server.HandleJob) are persisted as well: they are redelivered until their handler
+// acknowledges them, so they survive crashing workers. All other messages (unary calls and the handshake opening a stream) are sent via core NATS
+// as they are bound to a waiting requester anyway.
+package jetstream
+
+import (
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// ReqSubjects are the subjects the request messages of streams are sent on.
+	ReqSubjects = "nrpc.req.>"
+	// RespSubjects are the subjects the response messages of streams are sent on.
+	RespSubjects = "nrpc.resp.>"
+	// JobSubjects are the subjects jobs are published on.
+	JobSubjects = "nrpc.job.>"
+
+	duplicateWindow = 2 * time.Minute
+	maxAge          = time.Hour
+)
+
+// AddStream creates or updates the JetStream stream persisting the messages of nrpc streams.
+// Messages are removed from the stream once they are acknowledged. To configure the stream
+// differently create it manually covering the subjects ReqSubjects and RespSubjects.
+// If client and server map the subjects with prefixes (see nrpc.WithSubjectPrefix), the
+// stream covers the subjects with the given prefixes instead.
+func AddStream(js nats.JetStreamContext, name string, prefixes ...string) error {
+	subjects := []string{ReqSubjects, RespSubjects}
+	if len(prefixes) != 0 {
+		subjects = subjects[:0]
+		for _, prefix := range prefixes {
+			subjects = append(subjects, prefix+"."+ReqSubjects, prefix+"."+RespSubjects)
+		}
+	}
+
+	cfg := &nats.StreamConfig{
+		Name:       name,
+		Subjects:   subjects,
+		Retention:  nats.WorkQueuePolicy,
+		Storage:    nats.FileStorage,
+		Duplicates: duplicateWindow,
+		MaxAge:     maxAge,
+	}
+
+	if _, err := js.StreamInfo(name); err != nil {
+		_, err = js.AddStream(cfg)
+		return err
+	}
+	_, err := js.UpdateStream(cfg)
+	return err
+}
+
+// AddJobStream creates or updates the JetStream stream persisting jobs (see nrpc.Server.HandleJob).
+// Jobs are removed from the stream once they are acknowledged. To configure the stream differently
+// create it manually covering the subjects JobSubjects, prefixed like for AddStream.
+func AddJobStream(js nats.JetStreamContext, name string, prefixes ...string) error {
+	subjects := []string{JobSubjects}
+	if len(prefixes) != 0 {
+		subjects = subjects[:0]
+		for _, prefix := range prefixes {
+			subjects = append(subjects, prefix+"."+JobSubjects)
+		}
+	}
+
+	cfg := &nats.StreamConfig{
+		Name:      name,
+		Subjects:  subjects,
+		Retention: nats.WorkQueuePolicy,
+		Storage:   nats.FileStorage,
+	}
+
+	if _, err := js.StreamInfo(name); err != nil {
+		_, err = js.AddStream(cfg)
+		return err
+	}
+	_, err := js.UpdateStream(cfg)
+	return err
+}
+
+// isStreamSubject reports whether messages on the subject are persisted in JetStream.
+// The subject may be prefixed (see nrpc.WithSubjectPrefix).
+func isStreamSubject(subject string) bool {
+	return hasSegments(subject, strings.TrimSuffix(ReqSubjects, ">")) ||
+		hasSegments(subject, strings.TrimSuffix(RespSubjects, ">")) ||
+		isJobSubject(subject)
+}
+
+// isJobSubject reports whether jobs are published on the subject.
+func isJobSubject(subject string) bool {
+	return hasSegments(subject, strings.TrimSuffix(JobSubjects, ">"))
+}
+
+// hasSegments reports whether the subject starts with the segments, possibly after a prefix.
+func hasSegments(subject, segments string) bool {
+	return strings.HasPrefix(subject, segments) || strings.Contains(subject, "."+segments)
+}
+
+// durableName derives the name of the durable consumer from the subject.
+// Each stream subject is unique, so is the derived name.
+func durableName(subject string) string {
+	return strings.NewReplacer(".", "_", "*", "_", ">", "_").Replace(subject)
+}
@@ -0,0 +1,104 @@
+package jetstream
+
+import (
+	"errors"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/tehsphinx/nrpc/pubsub"
+)
+
+// ErrReplyNotSupported is returned when replying to a message delivered by a JetStream consumer.
+var ErrReplyNotSupported = errors.New("jetstream: replying to stream messages is not supported")
+
+type message struct {
+	msg *nats.Msg
+}
+
+var _ pubsub.Replier = (*message)(nil)
+
+// Subject implements the Msg interface.
+func (s message) Subject() string {
+	return s.msg.Subject
+}
+
+// Data implements the Msg interface.
+func (s message) Data() []byte {
+	return s.msg.Data
+}
+
+// Reply implemets the Msg interface.
+func (s message) Reply(msg pubsub.Reply) error {
+	return s.msg.Respond(msg.Data)
+}
+
+// streamMessage is a message delivered by a JetStream consumer.
+type streamMessage struct {
+	msg *nats.Msg
+}
+
+var (
+	_ pubsub.Replier    = (*streamMessage)(nil)
+	_ pubsub.Identifier = (*streamMessage)(nil)
+)
+
+// Subject implements the Msg interface.
+func (s streamMessage) Subject() string {
+	return s.msg.Subject
+}
+
+// Data implements the Msg interface.
+func (s streamMessage) Data() []byte {
+	return s.msg.Data
+}
+
+// ID implements the pubsub.Identifier interface.
+func (s streamMessage) ID() string {
+	return s.msg.Header.Get(nats.MsgIdHdr)
+}
+
+// Reply implemets the Msg interface. The reply subject of a stream message
+// is used for acknowledgements, so replying is not supported.
+func (s streamMessage) Reply(_ pubsub.Reply) error {
+	return ErrReplyNotSupported
+}
+
+// jobMessage is a job delivered by a JetStream consumer. It is acknowledged by the server handling it.
+type jobMessage struct {
+	streamMessage
+}
+
+var (
+	_ pubsub.Acknowledger    = (*jobMessage)(nil)
+	_ pubsub.DeliveryCounter = (*jobMessage)(nil)
+)
+
+// Ack implements the pubsub.Acknowledger interface.
+func (s jobMessage) Ack() error {
+	return s.msg.Ack()
+}
+
+// Nack implements the pubsub.Acknowledger interface.
+func (s jobMessage) Nack(delay time.Duration) error {
+	return s.msg.NakWithDelay(delay)
+}
+
+// Term implements the pubsub.Acknowledger interface.
+func (s jobMessage) Term() error {
+	return s.msg.Term()
+}
+
+// InProgress implements the pubsub.Acknowledger interface.
+func (s jobMessage) InProgress() error {
+	return s.msg.InProgress()
+}
+
+// Deliveries implements the pubsub.DeliveryCounter interface. It returns 1 if the metadata of the
+// message cannot be read.
+func (s jobMessage) Deliveries() int {
+	meta, err := s.msg.Metadata()
+	if err != nil {
+		return 1
+	}
+	return int(meta.NumDelivered)
+}
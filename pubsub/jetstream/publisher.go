@@ -0,0 +1,59 @@
+package jetstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+	"github.com/tehsphinx/nrpc/pubsub"
+)
+
+// Publisher returns a JetStream wrapper implementing the pubsub.Publisher interface.
+// Messages of streams are published to JetStream using the message ID for deduplication.
+func Publisher(conn *nats.Conn, js nats.JetStreamContext) pubsub.Publisher {
+	return &publisher{nats: conn, js: js}
+}
+
+type publisher struct {
+	nats *nats.Conn
+	js   nats.JetStreamContext
+}
+
+// Publish implements the pubsub.Publisher interface.
+func (s *publisher) Publish(msg pubsub.Message) error {
+	natsMsg := &nats.Msg{
+		Subject: msg.Subject,
+		Reply:   msg.Reply,
+		Data:    msg.Data,
+	}
+	if !isStreamSubject(msg.Subject) {
+		return s.nats.PublishMsg(natsMsg)
+	}
+
+	var opts []nats.PubOpt
+	if msg.ID != "" {
+		opts = append(opts, nats.MsgId(msg.ID))
+	}
+	_, err := s.js.PublishMsg(natsMsg, opts...)
+	return err
+}
+
+// Request implements the pubsub.Publisher interface.
+func (s *publisher) Request(ctx context.Context, msg pubsub.Message) (pubsub.Message, error) {
+	resp, err := s.nats.RequestMsgWithContext(ctx, &nats.Msg{
+		Subject: msg.Subject,
+		Data:    msg.Data,
+	})
+	if errors.Is(err, nats.ErrNoResponders) {
+		return pubsub.Message{}, fmt.Errorf("%w: %s", pubsub.ErrNoResponders, msg.Subject)
+	}
+	if err != nil {
+		return pubsub.Message{}, err
+	}
+
+	return pubsub.Message{
+		Subject: resp.Subject,
+		Data:    resp.Data,
+	}, nil
+}
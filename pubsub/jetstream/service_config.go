@@ -0,0 +1,73 @@
+package jetstream
+
+import (
+	"context"
+
+	"github.com/nats-io/nats.go"
+	"github.com/tehsphinx/nrpc"
+)
+
+// FetchServiceConfig reads the service config document stored under the key of the key-value bucket
+// (see nrpc.ServiceConfig).
+func FetchServiceConfig(kv nats.KeyValue, key string) (*nrpc.ServiceConfig, error) {
+	entry, err := kv.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return nrpc.ParseServiceConfig(entry.Value())
+}
+
+// WatchServiceConfig sets the service config stored under the key of the key-value bucket on the client
+// and keeps it up to date until the context is done. It returns once the current document was set and
+// fails if it is invalid. Later invalid documents leave the service config of the client unchanged and
+// are reported to onError if it is not nil. Deleting the key removes the service config from the client.
+func WatchServiceConfig(ctx context.Context, kv nats.KeyValue, key string, client *nrpc.Client, onError func(error)) error {
+	watcher, err := kv.Watch(key)
+	if err != nil {
+		return err
+	}
+
+	// the current document is followed by a nil entry, which is the only entry if the key does not exist
+	for entry := range watcher.Updates() {
+		if entry == nil {
+			break
+		}
+		if r := applyServiceConfig(client, entry); r != nil {
+			_ = watcher.Stop()
+			return r
+		}
+	}
+
+	go func() {
+		defer func() { _ = watcher.Stop() }()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case entry, ok := <-watcher.Updates():
+				if !ok {
+					return
+				}
+				if r := applyServiceConfig(client, entry); r != nil && onError != nil {
+					onError(r)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// applyServiceConfig sets the service config of the entry on the client.
+func applyServiceConfig(client *nrpc.Client, entry nats.KeyValueEntry) error {
+	if op := entry.Operation(); op == nats.KeyValueDelete || op == nats.KeyValuePurge {
+		client.SetServiceConfig(nil)
+		return nil
+	}
+	cfg, err := nrpc.ParseServiceConfig(entry.Value())
+	if err != nil {
+		return err
+	}
+	client.SetServiceConfig(cfg)
+	return nil
+}
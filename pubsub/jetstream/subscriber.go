@@ -0,0 +1,111 @@
+package jetstream
+
+import (
+	"context"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/tehsphinx/nrpc/pubsub"
+)
+
+// Subscriber returns a JetStream wrapper implementing the pubsub.Subscriber interface.
+// Subscriptions to subjects of streams create a durable JetStream consumer with explicit acks.
+// A message is acknowledged once the handler returned. Jobs are acknowledged by their handlers instead
+// (see pubsub.Acknowledger). Other subscriptions use core NATS.
+func Subscriber(conn *nats.Conn, js nats.JetStreamContext, opts ...SubscriberOption) pubsub.Subscriber {
+	s := &subscriber{nats: conn, js: js}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// SubscriberOption configures the subscriber.
+type SubscriberOption func(*subscriber)
+
+// JobAckWait sets the time a job is leased to its handler: jobs neither acknowledged nor extended within
+// it are redelivered, e.g. because their worker crashed. It defaults to the ack wait of JetStream (30s).
+func JobAckWait(d time.Duration) SubscriberOption {
+	return func(s *subscriber) {
+		s.jobAckWait = d
+	}
+}
+
+type subscriber struct {
+	nats       *nats.Conn
+	js         nats.JetStreamContext
+	jobAckWait time.Duration
+}
+
+// Subscribe implements the pubsub.Subscriber interface.
+func (s *subscriber) Subscribe(subject, queue string, handler pubsub.Handler) (pubsub.Subscription, error) {
+	if isJobSubject(subject) {
+		return s.subscribeStream(subject, func(msg *nats.Msg) {
+			handler(context.Background(), jobMessage{streamMessage{msg: msg}})
+		}, s.jobOpts()...)
+	}
+	if !isStreamSubject(subject) {
+		return s.nats.QueueSubscribe(subject, queue, func(msg *nats.Msg) {
+			handler(context.Background(), message{msg: msg})
+		})
+	}
+
+	return s.subscribeStream(subject, func(msg *nats.Msg) {
+		handler(context.Background(), streamMessage{msg: msg})
+		_ = msg.Ack()
+	})
+}
+
+// SubscribeAsync implements the pubsub.Subscriber interface.
+func (s *subscriber) SubscribeAsync(subject, queue string, handler pubsub.Handler) (pubsub.Subscription, error) {
+	if isJobSubject(subject) {
+		return s.subscribeStream(subject, func(msg *nats.Msg) {
+			go handler(context.Background(), jobMessage{streamMessage{msg: msg}})
+		}, s.jobOpts()...)
+	}
+	if !isStreamSubject(subject) {
+		return s.nats.QueueSubscribe(subject, queue, func(msg *nats.Msg) {
+			go func(msg *nats.Msg) {
+				handler(context.Background(), message{msg: msg})
+			}(msg)
+		})
+	}
+
+	return s.subscribeStream(subject, func(msg *nats.Msg) {
+		go func(msg *nats.Msg) {
+			handler(context.Background(), streamMessage{msg: msg})
+			_ = msg.Ack()
+		}(msg)
+	})
+}
+
+// subscribeStream creates a durable consumer for the subject. The consumer is deleted on unsubscribe.
+// Each stream subject only has one receiver, so the queue of the caller is replaced by the durable name.
+// The workers handling the jobs of a subject share its consumer the same way.
+func (s *subscriber) subscribeStream(subject string, handler nats.MsgHandler, opts ...nats.SubOpt) (pubsub.Subscription, error) {
+	durable := durableName(subject)
+	return s.js.QueueSubscribe(subject, durable, handler, append([]nats.SubOpt{
+		nats.Durable(durable),
+		nats.DeliverAll(),
+		nats.AckExplicit(),
+		nats.ManualAck(),
+	}, opts...)...)
+}
+
+// jobOpts returns the options of the consumers of jobs.
+func (s *subscriber) jobOpts() []nats.SubOpt {
+	if s.jobAckWait <= 0 {
+		return nil
+	}
+	return []nats.SubOpt{nats.AckWait(s.jobAckWait)}
+}
+
+// Flush implements the pubsub.Subscriber interface.
+func (s *subscriber) Flush() error {
+	return s.nats.Flush()
+}
+
+// Name implements the pubsub.Namer interface.
+func (s *subscriber) Name() string {
+	return "jetstream"
+}
@@ -0,0 +1,260 @@
+// Package kafka implements the pub/sub interfaces for Kafka.
+//
+// Subjects are mapped to topics of the same name. Subscribers with a queue join a consumer group
+// named after the queue and the topic, so each message is handled by one of them. The messages of
+// nrpc streams are sent on a single partition topic per method (the random suffix of the stream
+// subject is dropped) which every subscriber reads and filters by subject. The same applies to
+// cancellations. Each open stream thus reads the messages of all streams of its method: n concurrent
+// streams of a method cost O(n²) reads, so the transport suits methods with few concurrent streams. Replies to requests are sent to an inbox topic per connection and matched to the
+// request by a correlation ID header.
+//
+// Kafka cannot tell whether a subject has subscribers, so requests to unavailable services fail with
+// the deadline of their context instead of pubsub.ErrNoResponders. Consumer groups take a moment to
+// join, so servers should be running before clients send requests. Topics cannot be subscribed by
+// pattern: subscriptions of subjects with wildcards fail with pubsub.ErrWildcards.
+package kafka
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	kafkago "github.com/segmentio/kafka-go"
+)
+
+const (
+	headerSubject       = "nrpc-subject"
+	headerReply         = "nrpc-reply"
+	headerCorrelationID = "nrpc-correlation-id"
+	headerID            = "nrpc-id"
+
+	inboxPrefix = "nrpc.inbox."
+	// replicationFactor of -1 creates topics with the default replication factor of the brokers.
+	replicationFactor = -1
+
+	batchTimeout = time.Millisecond
+	maxWait      = 500 * time.Millisecond
+
+	uidLen = 16
+)
+
+// fanoutPrefixes are the prefixes of subjects whose messages are read by every subscriber.
+var fanoutPrefixes = []string{"nrpc.req.", "nrpc.resp.", "nrpc.cancel.", inboxPrefix}
+
+// Conn is a connection to a Kafka cluster. Use it to create a Publisher and Subscriber.
+type Conn struct {
+	brokers []string
+	writer  *kafkago.Writer
+	inbox   string
+	reader  *kafkago.Reader
+	cancel  context.CancelFunc
+
+	m       sync.Mutex
+	pending map[string]chan kafkago.Message
+}
+
+// Dial connects to the Kafka cluster of the given brokers. It creates the inbox topic
+// the replies to requests of this connection are sent to. Close removes it again.
+func Dial(brokers ...string) (*Conn, error) {
+	if len(brokers) == 0 {
+		return nil, errors.New("kafka: no brokers given")
+	}
+
+	c := &Conn{
+		brokers: brokers,
+		writer: &kafkago.Writer{
+			Addr:                   kafkago.TCP(brokers...),
+			Balancer:               kafkago.BalancerFunc(balance),
+			BatchTimeout:           batchTimeout,
+			RequiredAcks:           kafkago.RequireOne,
+			AllowAutoTopicCreation: true,
+		},
+		inbox:   inboxPrefix + newUID(),
+		pending: make(map[string]chan kafkago.Message),
+	}
+
+	reader, err := c.partitionReader(c.inbox)
+	if err != nil {
+		_ = c.writer.Close()
+		return nil, err
+	}
+	c.reader = reader
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	go c.readInbox(ctx)
+
+	return c, nil
+}
+
+// Close closes the connection and deletes its inbox topic.
+func (c *Conn) Close() error {
+	c.cancel()
+	_ = c.reader.Close()
+	err := c.writer.Close()
+
+	if r := c.controller(func(conn *kafkago.Conn) error {
+		return conn.DeleteTopics(c.inbox)
+	}); r != nil && err == nil {
+		err = r
+	}
+	return err
+}
+
+func (c *Conn) readInbox(ctx context.Context) {
+	for {
+		msg, err := c.reader.FetchMessage(ctx)
+		if err != nil {
+			return
+		}
+		c.deliver(msg)
+	}
+}
+
+// deliver passes the reply to the request waiting for it by its correlation ID.
+func (c *Conn) deliver(msg kafkago.Message) {
+	c.m.Lock()
+	ch, ok := c.pending[header(msg, headerCorrelationID)]
+	c.m.Unlock()
+	if !ok {
+		// the requester gave up already
+		return
+	}
+	select {
+	case ch <- msg:
+	default:
+	}
+}
+
+func (c *Conn) addPending(correlationID string) chan kafkago.Message {
+	ch := make(chan kafkago.Message, 1)
+
+	c.m.Lock()
+	c.pending[correlationID] = ch
+	c.m.Unlock()
+	return ch
+}
+
+func (c *Conn) removePending(correlationID string) {
+	c.m.Lock()
+	delete(c.pending, correlationID)
+	c.m.Unlock()
+}
+
+// partitionReader creates the topic if needed and returns a reader of its first partition
+// starting at the current end of the partition.
+func (c *Conn) partitionReader(topic string) (*kafkago.Reader, error) {
+	if err := c.controller(func(conn *kafkago.Conn) error {
+		err := conn.CreateTopics(kafkago.TopicConfig{
+			Topic:             topic,
+			NumPartitions:     1,
+			ReplicationFactor: replicationFactor,
+		})
+		if errors.Is(err, kafkago.TopicAlreadyExists) {
+			return nil
+		}
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	// the offset is resolved right away, so no message published after subscribing is missed
+	leader, err := kafkago.DialLeader(context.Background(), "tcp", c.brokers[0], topic, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer leader.Close()
+
+	offset, err := leader.ReadLastOffset()
+	if err != nil {
+		return nil, err
+	}
+
+	reader := kafkago.NewReader(kafkago.ReaderConfig{
+		Brokers: c.brokers,
+		Topic:   topic,
+		MaxWait: maxWait,
+	})
+	if r := reader.SetOffset(offset); r != nil {
+		_ = reader.Close()
+		return nil, r
+	}
+	return reader, nil
+}
+
+// controller runs fn with a connection to the controller of the cluster.
+func (c *Conn) controller(fn func(conn *kafkago.Conn) error) error {
+	conn, err := kafkago.Dial("tcp", c.brokers[0])
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	broker, err := conn.Controller()
+	if err != nil {
+		return err
+	}
+	controller, err := kafkago.Dial("tcp", net.JoinHostPort(broker.Host, strconv.Itoa(broker.Port)))
+	if err != nil {
+		return err
+	}
+	defer controller.Close()
+
+	return fn(controller)
+}
+
+// topicOf returns the topic the messages of a subject are sent on.
+func topicOf(subject string) string {
+	if hasSegments(subject, "nrpc.req.") || hasSegments(subject, "nrpc.resp.") {
+		// drop the random suffix of stream subjects
+		if i := strings.LastIndex(subject, "."); i >= 0 {
+			return subject[:i]
+		}
+	}
+	return subject
+}
+
+func isFanout(subject string) bool {
+	for _, prefix := range fanoutPrefixes {
+		if hasSegments(subject, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasSegments reports whether the subject starts with the segments, possibly after a prefix
+// (see nrpc.WithSubjectPrefix).
+func hasSegments(subject, segments string) bool {
+	return strings.HasPrefix(subject, segments) || strings.Contains(subject, "."+segments)
+}
+
+// balance sends messages of fanout topics to the first partition, which is read by the subscribers.
+// All other messages are spread over the partitions, so the members of a consumer group share the load.
+func balance(msg kafkago.Message, partitions ...int) int {
+	if isFanout(msg.Topic) {
+		return partitions[0]
+	}
+	return (&kafkago.Hash{}).Balance(msg, partitions...)
+}
+
+func header(msg kafkago.Message, key string) string {
+	for _, h := range msg.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func newUID() string {
+	b := make([]byte, uidLen)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
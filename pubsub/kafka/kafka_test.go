@@ -0,0 +1,191 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/matryer/is"
+	kafkago "github.com/segmentio/kafka-go"
+	"github.com/tehsphinx/nrpc/pubsub"
+)
+
+func TestTopicOf(t *testing.T) {
+	tests := []struct {
+		subject string
+		want    string
+	}{
+		{subject: "nrpc.req.pkg.Service.Method.abc123", want: "nrpc.req.pkg.Service.Method"},
+		{subject: "nrpc.resp.pkg.Service.Method.abc123", want: "nrpc.resp.pkg.Service.Method"},
+		{subject: "prefix.nrpc.req.pkg.Service.Method.abc123", want: "prefix.nrpc.req.pkg.Service.Method"},
+		{subject: "nrpc.cancel.pkg.Service", want: "nrpc.cancel.pkg.Service"},
+		{subject: "nrpc.inbox.abc123", want: "nrpc.inbox.abc123"},
+		{subject: "nrpc.pkg.Service.Method", want: "nrpc.pkg.Service.Method"},
+		{subject: "mynrpc.req.pkg.Service.Method.abc123", want: "mynrpc.req.pkg.Service.Method.abc123"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.subject, func(t *testing.T) {
+			is.New(t).Equal(topicOf(tt.subject), tt.want)
+		})
+	}
+}
+
+func TestIsFanout(t *testing.T) {
+	tests := []struct {
+		subject string
+		want    bool
+	}{
+		{subject: "nrpc.req.pkg.Service.Method.abc123", want: true},
+		{subject: "nrpc.resp.pkg.Service.Method", want: true},
+		{subject: "nrpc.cancel.pkg.Service", want: true},
+		{subject: "nrpc.inbox.abc123", want: true},
+		{subject: "prefix.env.nrpc.cancel.pkg.Service", want: true},
+		{subject: "nrpc.pkg.Service.Method", want: false},
+		{subject: "nrpc.event.orders", want: false},
+		{subject: "mynrpc.req.pkg.Service.Method", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.subject, func(t *testing.T) {
+			is.New(t).Equal(isFanout(tt.subject), tt.want)
+		})
+	}
+}
+
+func TestHasSegments(t *testing.T) {
+	tests := []struct {
+		name     string
+		subject  string
+		segments string
+		want     bool
+	}{
+		{name: "prefix", subject: "nrpc.req.Method", segments: "nrpc.req.", want: true},
+		{name: "after subject prefix", subject: "app.nrpc.req.Method", segments: "nrpc.req.", want: true},
+		{name: "within segment", subject: "appnrpc.req.Method", segments: "nrpc.req.", want: false},
+		{name: "partial segment", subject: "nrpc.request.Method", segments: "nrpc.req.", want: false},
+		{name: "other", subject: "nrpc.resp.Method", segments: "nrpc.req.", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is.New(t).Equal(hasSegments(tt.subject, tt.segments), tt.want)
+		})
+	}
+}
+
+func TestBalance(t *testing.T) {
+	partitions := []int{3, 4, 5, 6}
+	tests := []struct {
+		name   string
+		topic  string
+		fanout bool
+	}{
+		{name: "stream", topic: "nrpc.req.pkg.Service.Method", fanout: true},
+		{name: "cancel", topic: "nrpc.cancel.pkg.Service", fanout: true},
+		{name: "inbox", topic: "nrpc.inbox.abc123", fanout: true},
+		{name: "unary", topic: "nrpc.pkg.Service.Method"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			asrt := is.New(t)
+
+			used := map[int]bool{}
+			for i := 0; i < 64; i++ {
+				used[balance(kafkago.Message{Topic: tt.topic, Key: []byte(newUID())}, partitions...)] = true
+			}
+			if tt.fanout {
+				asrt.Equal(used, map[int]bool{3: true})
+				return
+			}
+			asrt.True(len(used) > 1) // spread over the partitions
+		})
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	reply := func(correlationID string) kafkago.Message {
+		return kafkago.Message{
+			Value:   []byte(correlationID),
+			Headers: []kafkago.Header{{Key: headerCorrelationID, Value: []byte(correlationID)}},
+		}
+	}
+	tests := []struct {
+		name    string
+		pending []string
+		replies []kafkago.Message
+		want    map[string]string
+	}{
+		{
+			name:    "matched",
+			pending: []string{"a", "b"},
+			replies: []kafkago.Message{reply("b"), reply("a")},
+			want:    map[string]string{"a": "a", "b": "b"},
+		},
+		{
+			name:    "unknown",
+			pending: []string{"a"},
+			replies: []kafkago.Message{reply("x"), {Value: []byte("no header")}},
+			want:    map[string]string{},
+		},
+		{
+			name:    "first reply",
+			pending: []string{"a"},
+			replies: []kafkago.Message{reply("a"), {Value: []byte("second"), Headers: reply("a").Headers}},
+			want:    map[string]string{"a": "a"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			asrt := is.New(t)
+
+			c := &Conn{pending: map[string]chan kafkago.Message{}}
+			chans := map[string]chan kafkago.Message{}
+			for _, id := range tt.pending {
+				chans[id] = c.addPending(id)
+			}
+			for _, msg := range tt.replies {
+				c.deliver(msg)
+			}
+
+			got := map[string]string{}
+			for id, ch := range chans {
+				select {
+				case msg := <-ch:
+					got[id] = string(msg.Value)
+				default:
+				}
+			}
+			asrt.Equal(got, tt.want)
+		})
+	}
+}
+
+func TestDeliverRemoved(t *testing.T) {
+	asrt := is.New(t)
+
+	c := &Conn{pending: map[string]chan kafkago.Message{}}
+	ch := c.addPending("a")
+	c.removePending("a")
+	c.deliver(kafkago.Message{Headers: []kafkago.Header{{Key: headerCorrelationID, Value: []byte("a")}}})
+
+	select {
+	case <-ch:
+		t.Fatal("reply delivered to a request that gave up")
+	default:
+	}
+	asrt.Equal(len(c.pending), 0)
+}
+
+func TestSubscribeWildcards(t *testing.T) {
+	asrt := is.New(t)
+
+	// topics cannot be subscribed by pattern
+	sub := Subscriber(&Conn{})
+	_, err := sub.Subscribe("nrpc.>", "", func(context.Context, pubsub.Replier) {})
+	asrt.True(errors.Is(err, pubsub.ErrWildcards))
+	_, err = sub.SubscribeAsync("nrpc.*.pkg.Service.Method", "queue", func(context.Context, pubsub.Replier) {})
+	asrt.True(errors.Is(err, pubsub.ErrWildcards))
+}
@@ -0,0 +1,51 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+
+	kafkago "github.com/segmentio/kafka-go"
+	"github.com/tehsphinx/nrpc/pubsub"
+)
+
+var errNoReply = errors.New("kafka: message has no reply topic")
+
+type message struct {
+	conn    *Conn
+	subject string
+	msg     kafkago.Message
+}
+
+var _ pubsub.Replier = (*message)(nil)
+var _ pubsub.Identifier = (*message)(nil)
+
+// Subject implements the pubsub.Replier interface.
+func (s message) Subject() string {
+	return s.subject
+}
+
+// Data implements the pubsub.Replier interface.
+func (s message) Data() []byte {
+	return s.msg.Value
+}
+
+// ID implements the pubsub.Identifier interface.
+func (s message) ID() string {
+	return header(s.msg, headerID)
+}
+
+// Reply implements the pubsub.Replier interface. The reply is sent to the inbox topic of the requester.
+func (s message) Reply(msg pubsub.Reply) error {
+	reply := header(s.msg, headerReply)
+	if reply == "" {
+		return errNoReply
+	}
+
+	return s.conn.writer.WriteMessages(context.Background(), kafkago.Message{
+		Topic: reply,
+		Value: msg.Data,
+		Headers: []kafkago.Header{
+			{Key: headerCorrelationID, Value: []byte(header(s.msg, headerCorrelationID))},
+		},
+	})
+}
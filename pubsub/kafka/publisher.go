@@ -0,0 +1,58 @@
+package kafka
+
+import (
+	"context"
+
+	kafkago "github.com/segmentio/kafka-go"
+	"github.com/tehsphinx/nrpc/pubsub"
+)
+
+// Publisher returns a Kafka wrapper implementing the pubsub.Publisher interface.
+func Publisher(conn *Conn) pubsub.Publisher {
+	return &publisher{conn: conn}
+}
+
+type publisher struct {
+	conn *Conn
+}
+
+// Publish implements the pubsub.Publisher interface.
+func (s *publisher) Publish(msg pubsub.Message) error {
+	return s.publish(context.Background(), msg, "")
+}
+
+func (s *publisher) publish(ctx context.Context, msg pubsub.Message, correlationID string) error {
+	return s.conn.writer.WriteMessages(ctx, kafkago.Message{
+		Topic: topicOf(msg.Subject),
+		Key:   []byte(newUID()),
+		Value: msg.Data,
+		Headers: []kafkago.Header{
+			{Key: headerSubject, Value: []byte(msg.Subject)},
+			{Key: headerReply, Value: []byte(msg.Reply)},
+			{Key: headerCorrelationID, Value: []byte(correlationID)},
+			{Key: headerID, Value: []byte(msg.ID)},
+		},
+	})
+}
+
+// Request implements the pubsub.Publisher interface. The reply is matched by a correlation ID.
+func (s *publisher) Request(ctx context.Context, msg pubsub.Message) (pubsub.Message, error) {
+	correlationID := newUID()
+	ch := s.conn.addPending(correlationID)
+	defer s.conn.removePending(correlationID)
+
+	msg.Reply = s.conn.inbox
+	if err := s.publish(ctx, msg, correlationID); err != nil {
+		return pubsub.Message{}, err
+	}
+
+	select {
+	case <-ctx.Done():
+		return pubsub.Message{}, ctx.Err()
+	case resp := <-ch:
+		return pubsub.Message{
+			Subject: s.conn.inbox,
+			Data:    resp.Value,
+		}, nil
+	}
+}
@@ -0,0 +1,134 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	kafkago "github.com/segmentio/kafka-go"
+	"github.com/tehsphinx/nrpc/pubsub"
+)
+
+// Subscriber returns a Kafka wrapper implementing the pubsub.Subscriber interface.
+func Subscriber(conn *Conn) pubsub.Subscriber {
+	return &subscriber{conn: conn}
+}
+
+type subscriber struct {
+	conn *Conn
+}
+
+// Subscribe implements the pubsub.Subscriber interface.
+func (s *subscriber) Subscribe(subject, queue string, handler pubsub.Handler) (pubsub.Subscription, error) {
+	return s.subscribe(subject, queue, handler, false)
+}
+
+// SubscribeAsync implements the pubsub.Subscriber interface.
+func (s *subscriber) SubscribeAsync(subject, queue string, handler pubsub.Handler) (pubsub.Subscription, error) {
+	return s.subscribe(subject, queue, handler, true)
+}
+
+func (s *subscriber) subscribe(subject, queue string, handler pubsub.Handler, async bool) (pubsub.Subscription, error) {
+	if pubsub.HasWildcards(subject) {
+		return nil, fmt.Errorf("%w: %s", pubsub.ErrWildcards, subject)
+	}
+	reader, err := s.reader(subject, queue)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sub := &subscription{reader: reader, cancel: cancel}
+	go func() {
+		defer sub.invalidate()
+
+		for {
+			msg, err := reader.FetchMessage(ctx)
+			if err != nil {
+				return
+			}
+			s.handle(ctx, reader, msg, subject, handler, async)
+		}
+	}()
+	return sub, nil
+}
+
+// reader returns the reader of the subject. Fanout subjects are read by every subscriber. Other subjects
+// are read by a consumer group of the queue or a consumer group of its own if there is no queue.
+func (s *subscriber) reader(subject, queue string) (*kafkago.Reader, error) {
+	topic := topicOf(subject)
+	if isFanout(subject) {
+		return s.conn.partitionReader(topic)
+	}
+
+	groupID := queue + "." + topic
+	if queue == "" {
+		groupID = "nrpc." + newUID()
+	}
+	return kafkago.NewReader(kafkago.ReaderConfig{
+		Brokers:     s.conn.brokers,
+		Topic:       topic,
+		GroupID:     groupID,
+		StartOffset: kafkago.LastOffset,
+		MaxWait:     maxWait,
+	}), nil
+}
+
+func (s *subscriber) handle(ctx context.Context, reader *kafkago.Reader, msg kafkago.Message, subject string,
+	handler pubsub.Handler, async bool) {
+	// messages of consumer groups are committed once handled, so they are redelivered to another
+	// member of the group if this one fails
+	commit := func() {
+		if reader.Config().GroupID != "" {
+			_ = reader.CommitMessages(ctx, msg)
+		}
+	}
+
+	if header(msg, headerSubject) != subject {
+		// fanout topics carry the messages of all streams of a method
+		commit()
+		return
+	}
+
+	m := message{conn: s.conn, subject: subject, msg: msg}
+	if async {
+		go handler(ctx, m)
+		commit()
+		return
+	}
+	handler(ctx, m)
+	commit()
+}
+
+// Flush implements the pubsub.Subscriber interface. Subscriptions are established once they
+// returned, so there is nothing to flush.
+func (s *subscriber) Flush() error {
+	return nil
+}
+
+// Name implements the pubsub.Namer interface.
+func (s *subscriber) Name() string {
+	return "kafka"
+}
+
+type subscription struct {
+	reader *kafkago.Reader
+	cancel context.CancelFunc
+	closed uint32
+}
+
+func (s *subscription) invalidate() {
+	atomic.StoreUint32(&s.closed, 1)
+}
+
+// Unsubscribe implements the pubsub.Subscription interface.
+func (s *subscription) Unsubscribe() error {
+	s.invalidate()
+	s.cancel()
+	return s.reader.Close()
+}
+
+// IsValid implements the pubsub.Subscription interface.
+func (s *subscription) IsValid() bool {
+	return atomic.LoadUint32(&s.closed) == 0
+}
@@ -0,0 +1,112 @@
+// Package memory implements the pub/sub interfaces in memory. Messages are delivered between the
+// publishers and subscribers of the same Broker within the process, so no broker needs to run,
+// e.g. in tests:
+//
+//	broker := memory.NewBroker()
+//	server := nrpc.NewServer(memory.Publisher(broker), memory.Subscriber(broker))
+//
+// Subjects support the wildcards of NATS: "*" matches a single token and ">" all remaining tokens.
+// Subscribers sharing a queue receive each message once between them. Messages are delivered to
+// every subscription in the order they were published.
+package memory
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/tehsphinx/nrpc/pubsub"
+)
+
+const inboxPrefix = "_INBOX."
+
+// Broker routes the messages between the publishers and subscribers created with it.
+type Broker struct {
+	m    sync.RWMutex
+	subs []*subscription
+
+	// seq is used to pick the subscriber of a queue and to create inbox subjects. It is accessed atomically.
+	seq uint64
+}
+
+// NewBroker creates an in-memory broker.
+func NewBroker() *Broker {
+	return &Broker{}
+}
+
+func (b *Broker) add(sub *subscription) {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	b.subs = append(b.subs, sub)
+}
+
+// Drop drops the subscriptions of subjects matching the pattern, as if the broker lost them, e.g. when restarting.
+// The subscriptions become invalid and notify their loss (see pubsub.LossNotifier). It returns the number of
+// dropped subscriptions. Drop allows testing how subscribers recover from a loss of their subscriptions.
+func (b *Broker) Drop(pattern string) int {
+	b.m.RLock()
+	var dropped []*subscription
+	for _, sub := range b.subs {
+		if pubsub.MatchSubject(pattern, sub.subject) {
+			dropped = append(dropped, sub)
+		}
+	}
+	b.m.RUnlock()
+
+	for _, sub := range dropped {
+		sub.lose()
+	}
+	return len(dropped)
+}
+
+func (b *Broker) remove(sub *subscription) {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	for i, s := range b.subs {
+		if s == sub {
+			b.subs = append(b.subs[:i:i], b.subs[i+1:]...)
+			return
+		}
+	}
+}
+
+// route delivers the message to the matching subscriptions. Of the subscriptions sharing a queue,
+// one is picked. It returns the number of subscriptions the message was delivered to.
+func (b *Broker) route(msg envelope) int {
+	b.m.RLock()
+	var receivers []*subscription
+	queues := map[string][]*subscription{}
+	for _, sub := range b.subs {
+		if !pubsub.MatchSubject(sub.subject, msg.subject) {
+			continue
+		}
+		if sub.queue == "" {
+			receivers = append(receivers, sub)
+			continue
+		}
+		queues[sub.queue] = append(queues[sub.queue], sub)
+	}
+	b.m.RUnlock()
+
+	for _, members := range queues {
+		receivers = append(receivers, members[atomic.AddUint64(&b.seq, 1)%uint64(len(members))])
+	}
+	for _, sub := range receivers {
+		sub.enqueue(msg)
+	}
+	return len(receivers)
+}
+
+func (b *Broker) newInbox() string {
+	return inboxPrefix + strconv.FormatUint(atomic.AddUint64(&b.seq, 1), 10)
+}
+
+// envelope is a message in transit.
+type envelope struct {
+	subject string
+	reply   string
+	id      string
+	data    []byte
+}
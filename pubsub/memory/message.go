@@ -0,0 +1,47 @@
+package memory
+
+import (
+	"errors"
+
+	"github.com/tehsphinx/nrpc/pubsub"
+)
+
+var errNoReply = errors.New("memory: message has no reply subject")
+
+type message struct {
+	broker *Broker
+	env    envelope
+}
+
+var _ pubsub.Replier = (*message)(nil)
+var _ pubsub.Identifier = (*message)(nil)
+var _ pubsub.ReplyAddresser = (*message)(nil)
+
+// Subject implements the pubsub.Replier interface.
+func (s message) Subject() string {
+	return s.env.subject
+}
+
+// Data implements the pubsub.Replier interface.
+func (s message) Data() []byte {
+	return s.env.data
+}
+
+// ID implements the pubsub.Identifier interface.
+func (s message) ID() string {
+	return s.env.id
+}
+
+// ReplySubject implements the pubsub.ReplyAddresser interface.
+func (s message) ReplySubject() string {
+	return s.env.reply
+}
+
+// Reply implements the pubsub.Replier interface.
+func (s message) Reply(msg pubsub.Reply) error {
+	if s.env.reply == "" {
+		return errNoReply
+	}
+	s.broker.route(envelope{subject: s.env.reply, data: msg.Data})
+	return nil
+}
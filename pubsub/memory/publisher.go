@@ -0,0 +1,50 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tehsphinx/nrpc/pubsub"
+)
+
+// Publisher returns a publisher of the broker implementing the pubsub.Publisher interface.
+func Publisher(broker *Broker) pubsub.Publisher {
+	return &publisher{broker: broker}
+}
+
+type publisher struct {
+	broker *Broker
+}
+
+// Publish implements the pubsub.Publisher interface.
+func (s *publisher) Publish(msg pubsub.Message) error {
+	s.broker.route(envelope{subject: msg.Subject, reply: msg.Reply, id: msg.ID, data: msg.Data})
+	return nil
+}
+
+// Request implements the pubsub.Publisher interface.
+func (s *publisher) Request(ctx context.Context, msg pubsub.Message) (pubsub.Message, error) {
+	inbox := s.broker.newInbox()
+	chReply := make(chan envelope, 1)
+	sub := newSubscription(s.broker, inbox, "", func(msg envelope) {
+		select {
+		case chReply <- msg:
+		default:
+		}
+	})
+	defer sub.close()
+
+	if s.broker.route(envelope{subject: msg.Subject, reply: inbox, id: msg.ID, data: msg.Data}) == 0 {
+		return pubsub.Message{}, fmt.Errorf("%w: %s", pubsub.ErrNoResponders, msg.Subject)
+	}
+
+	select {
+	case <-ctx.Done():
+		return pubsub.Message{}, ctx.Err()
+	case reply := <-chReply:
+		return pubsub.Message{
+			Subject: inbox,
+			Data:    reply.data,
+		}, nil
+	}
+}
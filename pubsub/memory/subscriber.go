@@ -0,0 +1,143 @@
+package memory
+
+import (
+	"context"
+	"sync"
+
+	"github.com/tehsphinx/nrpc/pubsub"
+)
+
+// Subscriber returns a subscriber of the broker implementing the pubsub.Subscriber interface.
+func Subscriber(broker *Broker) pubsub.Subscriber {
+	return &subscriber{broker: broker}
+}
+
+type subscriber struct {
+	broker *Broker
+}
+
+// Subscribe implements the pubsub.Subscriber interface.
+func (s *subscriber) Subscribe(subject, queue string, handler pubsub.Handler) (pubsub.Subscription, error) {
+	return newSubscription(s.broker, subject, queue, func(msg envelope) {
+		handler(context.Background(), message{broker: s.broker, env: msg})
+	}), nil
+}
+
+// SubscribeAsync implements the pubsub.Subscriber interface.
+func (s *subscriber) SubscribeAsync(subject, queue string, handler pubsub.Handler) (pubsub.Subscription, error) {
+	return newSubscription(s.broker, subject, queue, func(msg envelope) {
+		go handler(context.Background(), message{broker: s.broker, env: msg})
+	}), nil
+}
+
+// Flush implements the pubsub.Subscriber interface. Subscriptions of the in-memory broker are
+// in place once they were created, so there is nothing to flush.
+func (s *subscriber) Flush() error {
+	return nil
+}
+
+// Name implements the pubsub.Namer interface.
+func (s *subscriber) Name() string {
+	return "memory"
+}
+
+// subscription delivers the messages routed to it one after the other. Messages are queued,
+// so publishers never block on slow subscribers.
+type subscription struct {
+	broker  *Broker
+	subject string
+	queue   string
+	deliver func(msg envelope)
+
+	m       sync.Mutex
+	pending []envelope
+	closed  bool
+	chWake  chan struct{}
+	chLost  chan struct{}
+}
+
+func newSubscription(broker *Broker, subject, queue string, deliver func(msg envelope)) *subscription {
+	sub := &subscription{
+		broker:  broker,
+		subject: subject,
+		queue:   queue,
+		deliver: deliver,
+		chWake:  make(chan struct{}, 1),
+		chLost:  make(chan struct{}),
+	}
+	broker.add(sub)
+	go sub.run()
+	return sub
+}
+
+func (s *subscription) enqueue(msg envelope) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.closed {
+		return
+	}
+	s.pending = append(s.pending, msg)
+	select {
+	case s.chWake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *subscription) run() {
+	for range s.chWake {
+		for {
+			s.m.Lock()
+			if s.closed || len(s.pending) == 0 {
+				s.m.Unlock()
+				break
+			}
+			msg := s.pending[0]
+			s.pending = s.pending[1:]
+			s.m.Unlock()
+
+			s.deliver(msg)
+		}
+	}
+}
+
+// close closes the subscription. It reports whether it was still open.
+func (s *subscription) close() bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.closed {
+		return false
+	}
+	s.closed = true
+	s.pending = nil
+	s.broker.remove(s)
+	close(s.chWake)
+	return true
+}
+
+// lose closes the subscription as if it was lost by the broker.
+func (s *subscription) lose() {
+	if s.close() {
+		close(s.chLost)
+	}
+}
+
+// Unsubscribe implements the pubsub.Subscription interface.
+func (s *subscription) Unsubscribe() error {
+	s.close()
+	return nil
+}
+
+// IsValid implements the pubsub.Subscription interface.
+func (s *subscription) IsValid() bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return !s.closed
+}
+
+// Lost implements the pubsub.LossNotifier interface.
+func (s *subscription) Lost() <-chan struct{} {
+	return s.chLost
+}
@@ -5,6 +5,9 @@ type Message struct {
 	Subject string
 	Reply   string
 	Data    []byte
+	// ID optionally identifies the message. Transports with at-least-once delivery
+	// use it to deduplicate messages.
+	ID string
 }
 
 // Reply defines a pubsub reply.
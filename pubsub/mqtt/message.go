@@ -0,0 +1,55 @@
+package mqtt
+
+import (
+	"context"
+	"errors"
+
+	"github.com/eclipse/paho.golang/paho"
+	"github.com/tehsphinx/nrpc/pubsub"
+)
+
+var errNoReply = errors.New("mqtt: message has no response topic")
+
+type message struct {
+	conn *Conn
+	msg  *paho.Publish
+}
+
+var _ pubsub.Replier = (*message)(nil)
+var _ pubsub.Identifier = (*message)(nil)
+
+// Subject implements the pubsub.Replier interface.
+func (s message) Subject() string {
+	return s.msg.Topic
+}
+
+// Data implements the pubsub.Replier interface.
+func (s message) Data() []byte {
+	return s.msg.Payload
+}
+
+// ID implements the pubsub.Identifier interface.
+func (s message) ID() string {
+	if s.msg.Properties == nil {
+		return ""
+	}
+	return s.msg.Properties.User.Get(propertyID)
+}
+
+// Reply implements the pubsub.Replier interface. The reply is published to the response
+// topic of the request along with its correlation data.
+func (s message) Reply(msg pubsub.Reply) error {
+	if s.msg.Properties == nil || s.msg.Properties.ResponseTopic == "" {
+		return errNoReply
+	}
+
+	_, err := s.conn.client.Publish(context.Background(), &paho.Publish{
+		QoS:     qos,
+		Topic:   s.msg.Properties.ResponseTopic,
+		Payload: msg.Data,
+		Properties: &paho.PublishProperties{
+			CorrelationData: s.msg.Properties.CorrelationData,
+		},
+	})
+	return err
+}
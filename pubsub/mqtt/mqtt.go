@@ -0,0 +1,115 @@
+// Package mqtt implements the pub/sub interfaces for MQTT 5 brokers.
+//
+// Subjects are used as topic names. Subscribers with a queue use shared subscriptions
+// ($share/<queue>/<subject>), so each message is handled by one of them. Requests make use of
+// the response topic and correlation data properties of MQTT 5: the reply is published to the
+// inbox topic of the requesting connection and matched to the request by its correlation data.
+// All messages are published with QoS 1. Requests to subjects without subscribers fail with
+// pubsub.ErrNoResponders if the broker reports it in the acknowledgement. The wildcards of MQTT match
+// the levels of topics separated by slashes, not the tokens of subjects: subscriptions of subjects with
+// wildcards fail with pubsub.ErrWildcards.
+package mqtt
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"sync"
+
+	"github.com/eclipse/paho.golang/paho"
+)
+
+const (
+	qos         = 1
+	inboxPrefix = "nrpc/inbox/"
+	propertyID  = "nrpc-id"
+	// reasonNoMatchingSubscribers is the reason code of a PUBACK for a message nobody subscribed to.
+	reasonNoMatchingSubscribers = 0x10
+	// bufferSize is the number of messages buffered per subscription before the connection blocks.
+	bufferSize = 256
+
+	uidLen = 16
+)
+
+// Conn is an MQTT 5 session. Use it to create a Publisher and Subscriber.
+type Conn struct {
+	client *paho.Client
+	router *router
+	inbox  string
+
+	m       sync.Mutex
+	pending map[string]chan *paho.Publish
+}
+
+// Connect establishes an MQTT 5 session over the given network connection and subscribes
+// to the inbox topic the replies to requests of the session are sent to.
+func Connect(ctx context.Context, conn net.Conn, connect *paho.Connect) (*Conn, error) {
+	c := &Conn{
+		router:  newRouter(),
+		inbox:   inboxPrefix + newUID(),
+		pending: make(map[string]chan *paho.Publish),
+	}
+	c.client = paho.NewClient(paho.ClientConfig{
+		Conn:   conn,
+		Router: c.router,
+	})
+
+	if _, err := c.client.Connect(ctx, connect); err != nil {
+		return nil, err
+	}
+
+	c.router.add(c.inbox, c.resolve)
+	if _, err := c.client.Subscribe(ctx, &paho.Subscribe{
+		Subscriptions: map[string]paho.SubscribeOptions{c.inbox: {QoS: qos}},
+	}); err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Close disconnects the session.
+func (c *Conn) Close() error {
+	return c.client.Disconnect(&paho.Disconnect{})
+}
+
+// resolve passes a reply to the waiting request.
+func (c *Conn) resolve(pb *paho.Publish) {
+	if pb.Properties == nil {
+		return
+	}
+
+	c.m.Lock()
+	ch, ok := c.pending[string(pb.Properties.CorrelationData)]
+	c.m.Unlock()
+	if !ok {
+		// the requester gave up already
+		return
+	}
+	select {
+	case ch <- pb:
+	default:
+	}
+}
+
+func (c *Conn) addPending(correlationID string) chan *paho.Publish {
+	ch := make(chan *paho.Publish, 1)
+
+	c.m.Lock()
+	c.pending[correlationID] = ch
+	c.m.Unlock()
+	return ch
+}
+
+func (c *Conn) removePending(correlationID string) {
+	c.m.Lock()
+	delete(c.pending, correlationID)
+	c.m.Unlock()
+}
+
+func newUID() string {
+	b := make([]byte, uidLen)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,65 @@
+package mqtt
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/eclipse/paho.golang/paho"
+	"github.com/tehsphinx/nrpc/pubsub"
+)
+
+// Publisher returns an MQTT wrapper implementing the pubsub.Publisher interface.
+func Publisher(conn *Conn) pubsub.Publisher {
+	return &publisher{conn: conn}
+}
+
+type publisher struct {
+	conn *Conn
+}
+
+// Publish implements the pubsub.Publisher interface.
+func (s *publisher) Publish(msg pubsub.Message) error {
+	_, err := s.publish(context.Background(), msg, &paho.PublishProperties{ResponseTopic: msg.Reply})
+	return err
+}
+
+func (s *publisher) publish(ctx context.Context, msg pubsub.Message, props *paho.PublishProperties) (*paho.PublishResponse, error) {
+	if msg.ID != "" {
+		props.User.Add(propertyID, msg.ID)
+	}
+	return s.conn.client.Publish(ctx, &paho.Publish{
+		QoS:        qos,
+		Topic:      msg.Subject,
+		Payload:    msg.Data,
+		Properties: props,
+	})
+}
+
+// Request implements the pubsub.Publisher interface. The reply is published to the inbox
+// of the connection and matched by the correlation data.
+func (s *publisher) Request(ctx context.Context, msg pubsub.Message) (pubsub.Message, error) {
+	correlationID := newUID()
+	ch := s.conn.addPending(correlationID)
+	defer s.conn.removePending(correlationID)
+
+	resp, err := s.publish(ctx, msg, &paho.PublishProperties{
+		ResponseTopic:   s.conn.inbox,
+		CorrelationData: []byte(correlationID),
+	})
+	if err != nil {
+		return pubsub.Message{}, err
+	}
+	if resp != nil && resp.ReasonCode == reasonNoMatchingSubscribers {
+		return pubsub.Message{}, fmt.Errorf("%w: %s", pubsub.ErrNoResponders, msg.Subject)
+	}
+
+	select {
+	case <-ctx.Done():
+		return pubsub.Message{}, ctx.Err()
+	case reply := <-ch:
+		return pubsub.Message{
+			Subject: reply.Topic,
+			Data:    reply.Payload,
+		}, nil
+	}
+}
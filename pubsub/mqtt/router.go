@@ -0,0 +1,68 @@
+package mqtt
+
+import (
+	"sync"
+
+	"github.com/eclipse/paho.golang/packets"
+	"github.com/eclipse/paho.golang/paho"
+)
+
+var _ paho.Router = (*router)(nil)
+
+// router routes received messages to the subscriptions of their topic. Shared subscriptions
+// deliver messages with the plain topic, so routing by topic covers them as well.
+type router struct {
+	m        sync.RWMutex
+	nextID   uint64
+	handlers map[string]map[uint64]func(*paho.Publish)
+}
+
+func newRouter() *router {
+	return &router{
+		handlers: make(map[string]map[uint64]func(*paho.Publish)),
+	}
+}
+
+// add registers a handler for the topic and returns its id to remove it again.
+func (r *router) add(topic string, handler func(*paho.Publish)) uint64 {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.nextID++
+	if r.handlers[topic] == nil {
+		r.handlers[topic] = make(map[uint64]func(*paho.Publish))
+	}
+	r.handlers[topic][r.nextID] = handler
+	return r.nextID
+}
+
+func (r *router) remove(topic string, id uint64) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	delete(r.handlers[topic], id)
+	if len(r.handlers[topic]) == 0 {
+		delete(r.handlers, topic)
+	}
+}
+
+// Route implements the paho.Router interface.
+func (r *router) Route(pb *packets.Publish) {
+	msg := paho.PublishFromPacketPublish(pb)
+
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	for _, handler := range r.handlers[msg.Topic] {
+		handler(msg)
+	}
+}
+
+// RegisterHandler implements the paho.Router interface. Handlers are registered by the subscriber instead.
+func (r *router) RegisterHandler(string, paho.MessageHandler) {}
+
+// UnregisterHandler implements the paho.Router interface.
+func (r *router) UnregisterHandler(string) {}
+
+// SetDebugLogger implements the paho.Router interface.
+func (r *router) SetDebugLogger(paho.Logger) {}
@@ -0,0 +1,130 @@
+package mqtt
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/eclipse/paho.golang/paho"
+	"github.com/tehsphinx/nrpc/pubsub"
+)
+
+// Subscriber returns an MQTT wrapper implementing the pubsub.Subscriber interface.
+func Subscriber(conn *Conn) pubsub.Subscriber {
+	return &subscriber{conn: conn}
+}
+
+type subscriber struct {
+	conn *Conn
+}
+
+// Subscribe implements the pubsub.Subscriber interface. The messages are handled one after
+// another in the order they were received.
+func (s *subscriber) Subscribe(subject, queue string, handler pubsub.Handler) (pubsub.Subscription, error) {
+	if pubsub.HasWildcards(subject) {
+		return nil, fmt.Errorf("%w: %s", pubsub.ErrWildcards, subject)
+	}
+	sub := newSubscription(s.conn, subject, queue)
+
+	ch := make(chan *paho.Publish, bufferSize)
+	go func() {
+		for {
+			select {
+			case <-sub.done:
+				return
+			case pb := <-ch:
+				handler(context.Background(), message{conn: s.conn, msg: pb})
+			}
+		}
+	}()
+
+	// the messages are passed on, so the connection is not blocked by the handler
+	return sub, sub.subscribe(func(pb *paho.Publish) {
+		select {
+		case <-sub.done:
+		case ch <- pb:
+		}
+	})
+}
+
+// SubscribeAsync implements the pubsub.Subscriber interface.
+func (s *subscriber) SubscribeAsync(subject, queue string, handler pubsub.Handler) (pubsub.Subscription, error) {
+	if pubsub.HasWildcards(subject) {
+		return nil, fmt.Errorf("%w: %s", pubsub.ErrWildcards, subject)
+	}
+	sub := newSubscription(s.conn, subject, queue)
+
+	return sub, sub.subscribe(func(pb *paho.Publish) {
+		go handler(context.Background(), message{conn: s.conn, msg: pb})
+	})
+}
+
+// Flush implements the pubsub.Subscriber interface. Subscriptions are acknowledged by the
+// broker before they return, so there is nothing to flush.
+func (s *subscriber) Flush() error {
+	return nil
+}
+
+// Name implements the pubsub.Namer interface.
+func (s *subscriber) Name() string {
+	return "mqtt"
+}
+
+type subscription struct {
+	conn    *Conn
+	subject string
+	filter  string
+	id      uint64
+
+	once sync.Once
+	done chan struct{}
+}
+
+func newSubscription(conn *Conn, subject, queue string) *subscription {
+	filter := subject
+	if queue != "" {
+		filter = "$share/" + queue + "/" + subject
+	}
+	return &subscription{
+		conn:    conn,
+		subject: subject,
+		filter:  filter,
+		done:    make(chan struct{}),
+	}
+}
+
+func (s *subscription) subscribe(handler func(*paho.Publish)) error {
+	s.id = s.conn.router.add(s.subject, handler)
+
+	if _, err := s.conn.client.Subscribe(context.Background(), &paho.Subscribe{
+		Subscriptions: map[string]paho.SubscribeOptions{s.filter: {QoS: qos}},
+	}); err != nil {
+		s.close()
+		return err
+	}
+	return nil
+}
+
+func (s *subscription) close() {
+	s.once.Do(func() {
+		close(s.done)
+		s.conn.router.remove(s.subject, s.id)
+	})
+}
+
+// Unsubscribe implements the pubsub.Subscription interface.
+func (s *subscription) Unsubscribe() error {
+	s.close()
+	_, err := s.conn.client.Unsubscribe(context.Background(), &paho.Unsubscribe{Topics: []string{s.filter}})
+	return err
+}
+
+// IsValid implements the pubsub.Subscription interface.
+func (s *subscription) IsValid() bool {
+	select {
+	case <-s.done:
+		return false
+	default:
+		return true
+	}
+}
@@ -0,0 +1,151 @@
+package nats
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/tehsphinx/nrpc/pubsub"
+)
+
+const (
+	// statusHeader and noRespondersStatus mark the reply the NATS server sends to requests without responders.
+	statusHeader       = "Status"
+	noRespondersStatus = "503"
+)
+
+// RequestAsync implements the pubsub.AsyncRequester interface. The replies of all asynchronous requests
+// of the publisher are received by a single subscription on a wildcard inbox, so pending requests do not
+// hold a goroutine each. Requests are ended by a timer at the deadline of the context. A goroutine per
+// request only watches contexts that can be canceled but have no deadline.
+func (s *publisher) RequestAsync(ctx context.Context, msg pubsub.Message, fn func(reply pubsub.Message, err error)) error {
+	if r := ctx.Err(); r != nil {
+		return r
+	}
+	token, reply, err := s.async.add(s.nats, msg.Subject, fn)
+	if err != nil {
+		return err
+	}
+	if r := s.nats.PublishMsg(&nats.Msg{
+		Subject: msg.Subject,
+		Reply:   reply,
+		Data:    msg.Data,
+	}); r != nil {
+		s.async.take(token)
+		return r
+	}
+	s.async.watch(ctx, token)
+	return nil
+}
+
+// asyncReplies routes the replies received on the wildcard inbox to the pending requests.
+type asyncReplies struct {
+	m       sync.Mutex
+	prefix  string
+	sub     *nats.Subscription
+	next    uint64
+	pending map[string]*asyncRequest
+}
+
+type asyncRequest struct {
+	subject string
+	fn      func(reply pubsub.Message, err error)
+	timer   *time.Timer
+	done    chan struct{}
+}
+
+// add registers the function of a request sent to the subject. It returns the token of the request and
+// its reply subject. The inbox is subscribed with the first request and again if the subscription became
+// invalid. Requests pending on an invalid subscription end with their context.
+func (a *asyncReplies) add(conn *nats.Conn, subject string, fn func(reply pubsub.Message, err error)) (string, string, error) {
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	if a.sub == nil || !a.sub.IsValid() {
+		prefix := nats.NewInbox()
+		sub, err := conn.Subscribe(prefix+".*", a.receive)
+		if err != nil {
+			return "", "", err
+		}
+		a.prefix, a.sub = prefix, sub
+	}
+	if a.pending == nil {
+		a.pending = map[string]*asyncRequest{}
+	}
+
+	a.next++
+	token := strconv.FormatUint(a.next, 36)
+	a.pending[token] = &asyncRequest{subject: subject, fn: fn, done: make(chan struct{})}
+	return token, a.prefix + "." + token, nil
+}
+
+// take removes the request of the token. It returns nil if the request already ended.
+func (a *asyncReplies) take(token string) *asyncRequest {
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	req, ok := a.pending[token]
+	if !ok {
+		return nil
+	}
+	delete(a.pending, token)
+	if req.timer != nil {
+		req.timer.Stop()
+	}
+	close(req.done)
+	return req
+}
+
+// watch ends the request of the token with the error of the context once it is done.
+func (a *asyncReplies) watch(ctx context.Context, token string) {
+	end := func() {
+		req := a.take(token)
+		if req == nil {
+			return
+		}
+		err := ctx.Err()
+		if err == nil {
+			// the timer might fire before the context noticed its deadline
+			err = context.DeadlineExceeded
+		}
+		req.fn(pubsub.Message{}, err)
+	}
+
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	req, ok := a.pending[token]
+	if !ok {
+		return
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		req.timer = time.AfterFunc(time.Until(deadline), end)
+		return
+	}
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				end()
+			case <-req.done:
+			}
+		}()
+	}
+}
+
+// receive passes a reply to the request it answers.
+func (a *asyncReplies) receive(msg *nats.Msg) {
+	req := a.take(msg.Subject[strings.LastIndexByte(msg.Subject, '.')+1:])
+	if req == nil {
+		return
+	}
+	if len(msg.Data) == 0 && msg.Header.Get(statusHeader) == noRespondersStatus {
+		req.fn(pubsub.Message{}, fmt.Errorf("%w: %s", pubsub.ErrNoResponders, req.subject))
+		return
+	}
+	req.fn(pubsub.Message{Subject: msg.Subject, Data: msg.Data}, nil)
+}
@@ -0,0 +1,539 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/tehsphinx/nrpc/pubsub"
+	"google.golang.org/grpc/connectivity"
+)
+
+const defaultRedialWait = time.Second
+
+// ErrDisconnected is returned by the publisher of a managed connection with the FailFast policy
+// while the connection to the NATS server is down.
+var ErrDisconnected = errors.New("nats: disconnected")
+
+// State defines the state of a managed connection.
+type State int
+
+// States of a managed connection.
+const (
+	// StateConnected is reported once the connection is established initially.
+	StateConnected State = iota
+	// StateDisconnected is reported when the connection to the server is lost.
+	StateDisconnected
+	// StateReconnected is reported once the connection is re-established and all subscriptions are renewed.
+	StateReconnected
+	// StateClosed is reported when the managed connection is closed.
+	StateClosed
+)
+
+// String implements the fmt.Stringer interface.
+func (s State) String() string {
+	switch s {
+	case StateConnected:
+		return "CONNECTED"
+	case StateDisconnected:
+		return "DISCONNECTED"
+	case StateReconnected:
+		return "RECONNECTED"
+	case StateClosed:
+		return "CLOSED"
+	}
+	return fmt.Sprintf("STATE(%d)", int(s))
+}
+
+// Policy defines how calls are handled while the connection is down.
+type Policy int
+
+const (
+	// Buffer holds back messages and requests until the connection is re-established
+	// or the context of the call is done. This is the default.
+	Buffer Policy = iota
+	// FailFast fails messages and requests with ErrDisconnected while the connection is down.
+	FailFast
+)
+
+// ManagedOption configures a managed connection.
+type ManagedOption func(cfg *managedConfig)
+
+type managedConfig struct {
+	poolSize   int
+	policy     Policy
+	redialWait time.Duration
+	onState    func(conn int, state State)
+	natsOpts   []nats.Option
+}
+
+// WithPoolSize sets the number of connections to the NATS server. Messages and requests are
+// distributed round-robin over the connections, subscriptions are spread across them.
+// It defaults to a single connection.
+func WithPoolSize(size int) ManagedOption {
+	return func(cfg *managedConfig) {
+		cfg.poolSize = size
+	}
+}
+
+// WithPolicy sets how calls are handled while a connection is down. It defaults to Buffer.
+func WithPolicy(policy Policy) ManagedOption {
+	return func(cfg *managedConfig) {
+		cfg.policy = policy
+	}
+}
+
+// WithStateHandler sets a callback receiving the state changes of the connections.
+// The index identifies the connection within the pool.
+func WithStateHandler(handler func(conn int, state State)) ManagedOption {
+	return func(cfg *managedConfig) {
+		cfg.onState = handler
+	}
+}
+
+// WithRedialWait sets the time to wait between attempts to dial a connection the NATS
+// client gave up reconnecting. It defaults to one second.
+func WithRedialWait(wait time.Duration) ManagedOption {
+	return func(cfg *managedConfig) {
+		cfg.redialWait = wait
+	}
+}
+
+// WithNATSOptions passes options to the underlying NATS connections. The handlers for
+// disconnects, reconnects and closed connections are set by the managed connection.
+func WithNATSOptions(opts ...nats.Option) ManagedOption {
+	return func(cfg *managedConfig) {
+		cfg.natsOpts = append(cfg.natsOpts, opts...)
+	}
+}
+
+// Managed owns a pool of connections to a NATS server. It keeps track of the subscriptions
+// made through its subscriber and renews them on a new connection, should the NATS client
+// give up reconnecting. State changes of the connections are reported to the state handler.
+type Managed struct {
+	url   string
+	cfg   managedConfig
+	conns []*managedConn
+	next  uint32
+
+	m      sync.Mutex
+	subs   map[*managedSub]struct{}
+	closed chan struct{}
+	once   sync.Once
+
+	// chChanged is closed and replaced on every state change of a connection.
+	stateM    sync.Mutex
+	chChanged chan struct{}
+}
+
+// Dial connects to the NATS server(s) given as comma separated URLs and returns the managed connection.
+func Dial(url string, opts ...ManagedOption) (*Managed, error) {
+	cfg := managedConfig{
+		poolSize:   1,
+		redialWait: defaultRedialWait,
+		onState:    func(int, State) {},
+	}
+	for _, o := range opts {
+		o(&cfg)
+	}
+	if cfg.poolSize < 1 {
+		cfg.poolSize = 1
+	}
+
+	m := &Managed{
+		url:    url,
+		cfg:    cfg,
+		conns:  make([]*managedConn, cfg.poolSize),
+		subs:   make(map[*managedSub]struct{}),
+		closed: make(chan struct{}),
+
+		chChanged: make(chan struct{}),
+	}
+	for i := range m.conns {
+		mc := &managedConn{index: i, ready: make(chan struct{})}
+		if r := m.dial(mc); r != nil {
+			m.Close()
+			return nil, r
+		}
+		m.conns[i] = mc
+		m.report(i, StateConnected)
+	}
+	return m, nil
+}
+
+// Publisher returns the publisher of the managed connection implementing the pubsub.Publisher and
+// pubsub.StateReporter interfaces.
+func (m *Managed) Publisher() pubsub.Publisher {
+	return &managedPublisher{managed: m}
+}
+
+// Subscriber returns the subscriber of the managed connection implementing the pubsub.Subscriber interface.
+func (m *Managed) Subscriber() pubsub.Subscriber {
+	return &managedSubscriber{managed: m}
+}
+
+// Close closes all connections. Subscriptions are invalidated and calls waiting for a connection fail.
+func (m *Managed) Close() {
+	m.once.Do(func() {
+		close(m.closed)
+		for _, mc := range m.conns {
+			if mc == nil {
+				continue
+			}
+			mc.conn().Close()
+			m.report(mc.index, StateClosed)
+		}
+	})
+}
+
+// State returns the connectivity state of the managed connection. It is ready while any connection
+// of the pool is up and in a transient failure while all of them are down.
+func (m *Managed) State() connectivity.State {
+	if isClosed(m.closed) {
+		return connectivity.Shutdown
+	}
+	for _, mc := range m.conns {
+		if mc == nil {
+			continue
+		}
+		if _, ready := mc.state(); isClosed(ready) {
+			return connectivity.Ready
+		}
+	}
+	return connectivity.TransientFailure
+}
+
+// WaitForStateChange waits until the connectivity state differs from the source state or the
+// context is done. It reports whether the state changed.
+func (m *Managed) WaitForStateChange(ctx context.Context, source connectivity.State) bool {
+	for {
+		m.stateM.Lock()
+		chChanged := m.chChanged
+		m.stateM.Unlock()
+
+		if m.State() != source {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-chChanged:
+		}
+	}
+}
+
+// report reports the state change of the connection to the state handler and wakes up the calls
+// waiting for a state change.
+func (m *Managed) report(conn int, state State) {
+	m.cfg.onState(conn, state)
+
+	m.stateM.Lock()
+	defer m.stateM.Unlock()
+	close(m.chChanged)
+	m.chChanged = make(chan struct{})
+}
+
+func (m *Managed) dial(mc *managedConn) error {
+	opts := append(append([]nats.Option{}, m.cfg.natsOpts...),
+		nats.DisconnectErrHandler(func(*nats.Conn, error) {
+			if mc.disconnected() {
+				m.report(mc.index, StateDisconnected)
+			}
+		}),
+		nats.ReconnectHandler(func(*nats.Conn) {
+			mc.connected()
+			m.report(mc.index, StateReconnected)
+		}),
+		nats.ClosedHandler(func(*nats.Conn) {
+			select {
+			case <-m.closed:
+				return
+			default:
+			}
+			if mc.disconnected() {
+				m.report(mc.index, StateDisconnected)
+			}
+			go m.redial(mc)
+		}),
+	)
+
+	nc, err := nats.Connect(m.url, opts...)
+	if err != nil {
+		return err
+	}
+	mc.set(nc)
+	return nil
+}
+
+// redial replaces a connection the NATS client gave up on and renews its subscriptions.
+func (m *Managed) redial(mc *managedConn) {
+	for {
+		select {
+		case <-m.closed:
+			return
+		case <-time.After(m.cfg.redialWait):
+		}
+
+		if r := m.dial(mc); r != nil {
+			continue
+		}
+		m.resubscribe(mc)
+		m.report(mc.index, StateReconnected)
+		return
+	}
+}
+
+func (m *Managed) resubscribe(mc *managedConn) {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	for sub := range m.subs {
+		if sub.mc == mc {
+			_ = sub.subscribe()
+		}
+	}
+	// the subscriptions are renewed once the server processed them, as messages might be published
+	// on the other connections of the pool right after the reconnect was reported
+	_ = mc.conn().Flush()
+}
+
+// pick returns the next connection of the pool.
+func (m *Managed) pick() *managedConn {
+	i := atomic.AddUint32(&m.next, 1)
+	return m.conns[int(i)%len(m.conns)]
+}
+
+// await returns the connection once it is usable according to the policy.
+func (m *Managed) await(ctx context.Context, mc *managedConn) (*nats.Conn, error) {
+	nc, ready := mc.state()
+	if m.cfg.policy == Buffer {
+		select {
+		case <-ready:
+			nc, _ = mc.state()
+		case <-m.closed:
+			return nil, nats.ErrConnectionClosed
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		return nc, nil
+	}
+
+	select {
+	case <-ready:
+		return nc, nil
+	default:
+		return nil, ErrDisconnected
+	}
+}
+
+func (m *Managed) track(sub *managedSub) {
+	m.m.Lock()
+	m.subs[sub] = struct{}{}
+	m.m.Unlock()
+}
+
+func (m *Managed) untrack(sub *managedSub) {
+	m.m.Lock()
+	delete(m.subs, sub)
+	m.m.Unlock()
+}
+
+// managedConn holds a connection of the pool. The connection is replaced after a redial.
+type managedConn struct {
+	index int
+
+	m     sync.RWMutex
+	nc    *nats.Conn
+	ready chan struct{}
+	down  bool
+}
+
+func (c *managedConn) set(nc *nats.Conn) {
+	c.m.Lock()
+	c.nc = nc
+	c.m.Unlock()
+	c.connected()
+}
+
+func (c *managedConn) conn() *nats.Conn {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	return c.nc
+}
+
+// state returns the connection and a channel that is closed while the connection is up.
+func (c *managedConn) state() (*nats.Conn, chan struct{}) {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	return c.nc, c.ready
+}
+
+func (c *managedConn) connected() {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if !isClosed(c.ready) {
+		close(c.ready)
+	}
+	c.down = false
+}
+
+// disconnected marks the connection as down and reports whether it was up before.
+func (c *managedConn) disconnected() bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.down {
+		return false
+	}
+	c.down = true
+	c.ready = make(chan struct{})
+	return true
+}
+
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+type managedPublisher struct {
+	managed *Managed
+}
+
+// Publish implements the pubsub.Publisher interface.
+func (s *managedPublisher) Publish(msg pubsub.Message) error {
+	nc, err := s.managed.await(context.Background(), s.managed.pick())
+	if err != nil {
+		return err
+	}
+	return Publisher(nc).Publish(msg)
+}
+
+// Request implements the pubsub.Publisher interface.
+func (s *managedPublisher) Request(ctx context.Context, msg pubsub.Message) (pubsub.Message, error) {
+	nc, err := s.managed.await(ctx, s.managed.pick())
+	if err != nil {
+		return pubsub.Message{}, err
+	}
+	return Publisher(nc).Request(ctx, msg)
+}
+
+// State implements the pubsub.StateReporter interface.
+func (s *managedPublisher) State() connectivity.State {
+	return s.managed.State()
+}
+
+// WaitForStateChange implements the pubsub.StateReporter interface.
+func (s *managedPublisher) WaitForStateChange(ctx context.Context, source connectivity.State) bool {
+	return s.managed.WaitForStateChange(ctx, source)
+}
+
+type managedSubscriber struct {
+	managed *Managed
+}
+
+// Subscribe implements the pubsub.Subscriber interface.
+func (s *managedSubscriber) Subscribe(subject, queue string, handler pubsub.Handler) (pubsub.Subscription, error) {
+	return s.subscribe(subject, queue, func(msg *nats.Msg) {
+		handler(context.Background(), message{msg: msg})
+	})
+}
+
+// SubscribeAsync implements the pubsub.Subscriber interface.
+func (s *managedSubscriber) SubscribeAsync(subject, queue string, handler pubsub.Handler) (pubsub.Subscription, error) {
+	return s.subscribe(subject, queue, func(msg *nats.Msg) {
+		go handler(context.Background(), message{msg: msg})
+	})
+}
+
+func (s *managedSubscriber) subscribe(subject, queue string, handler nats.MsgHandler) (pubsub.Subscription, error) {
+	sub := &managedSub{
+		managed: s.managed,
+		mc:      s.managed.pick(),
+		subject: subject,
+		queue:   queue,
+		handler: handler,
+	}
+	if r := sub.subscribe(); r != nil {
+		return nil, r
+	}
+	s.managed.track(sub)
+	return sub, nil
+}
+
+// Flush implements the pubsub.Subscriber interface.
+func (s *managedSubscriber) Flush() error {
+	for _, mc := range s.managed.conns {
+		if r := mc.conn().Flush(); r != nil {
+			return r
+		}
+	}
+	return nil
+}
+
+// Name implements the pubsub.Namer interface.
+func (s *managedSubscriber) Name() string {
+	return "nats"
+}
+
+// managedSub is a subscription that is renewed when its connection is replaced.
+type managedSub struct {
+	managed *Managed
+	mc      *managedConn
+	subject string
+	queue   string
+	handler nats.MsgHandler
+
+	m    sync.Mutex
+	sub  *nats.Subscription
+	done bool
+}
+
+func (s *managedSub) subscribe() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.done {
+		return nil
+	}
+	sub, err := s.mc.conn().QueueSubscribe(s.subject, s.queue, s.handler)
+	if err != nil {
+		return err
+	}
+	s.sub = sub
+	return nil
+}
+
+// Unsubscribe implements the pubsub.Subscription interface.
+func (s *managedSub) Unsubscribe() error {
+	s.managed.untrack(s)
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.done = true
+	if s.sub == nil || !s.sub.IsValid() {
+		return nil
+	}
+	return s.sub.Unsubscribe()
+}
+
+// IsValid implements the pubsub.Subscription interface. The subscription stays valid
+// while its connection is being replaced.
+func (s *managedSub) IsValid() bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.done || isClosed(s.managed.closed) {
+		return false
+	}
+	return true
+}
@@ -10,6 +10,7 @@ type message struct {
 }
 
 var _ pubsub.Replier = (*message)(nil)
+var _ pubsub.ReplyAddresser = (*message)(nil)
 
 // Subject implements the Msg interface.
 func (s message) Subject() string {
@@ -25,3 +26,8 @@ func (s message) Data() []byte {
 func (s message) Reply(msg pubsub.Reply) error {
 	return s.msg.Respond(msg.Data)
 }
+
+// ReplySubject implements the pubsub.ReplyAddresser interface.
+func (s message) ReplySubject() string {
+	return s.msg.Reply
+}
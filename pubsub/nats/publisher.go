@@ -2,18 +2,29 @@ package nats
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
 
 	"github.com/nats-io/nats.go"
 	"github.com/tehsphinx/nrpc/pubsub"
+	"google.golang.org/grpc/connectivity"
 )
 
-// Publisher returns a NATS wrapper implementing the pubsub.Publisher interface.
+// statePollInterval is the interval the status of a plain NATS connection is checked at while waiting
+// for it to change. The NATS client offers no way to subscribe to status changes after connecting.
+const statePollInterval = 50 * time.Millisecond
+
+// Publisher returns a NATS wrapper implementing the pubsub.Publisher, pubsub.StateReporter,
+// pubsub.ClientIdentifier and pubsub.AsyncRequester interfaces.
 func Publisher(nats *nats.Conn) pubsub.Publisher {
 	return &publisher{nats: nats}
 }
 
 type publisher struct {
-	nats *nats.Conn
+	nats  *nats.Conn
+	async asyncReplies
 }
 
 // Publish implements the pubsub.Publisher interface.
@@ -31,6 +42,9 @@ func (s *publisher) Request(ctx context.Context, msg pubsub.Message) (pubsub.Mes
 		Subject: msg.Subject,
 		Data:    msg.Data,
 	})
+	if errors.Is(err, nats.ErrNoResponders) {
+		return pubsub.Message{}, fmt.Errorf("%w: %s", pubsub.ErrNoResponders, msg.Subject)
+	}
 	if err != nil {
 		return pubsub.Message{}, err
 	}
@@ -40,3 +54,47 @@ func (s *publisher) Request(ctx context.Context, msg pubsub.Message) (pubsub.Mes
 		Data:    resp.Data,
 	}, nil
 }
+
+// State implements the pubsub.StateReporter interface.
+func (s *publisher) State() connectivity.State {
+	return connState(s.nats.Status())
+}
+
+// WaitForStateChange implements the pubsub.StateReporter interface.
+func (s *publisher) WaitForStateChange(ctx context.Context, source connectivity.State) bool {
+	ticker := time.NewTicker(statePollInterval)
+	defer ticker.Stop()
+
+	for s.State() == source {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+	return true
+}
+
+// ClientID implements the pubsub.ClientIdentifier interface. It returns the client ID assigned to the
+// connection by the NATS server it is connected to.
+func (s *publisher) ClientID() string {
+	id, err := s.nats.GetClientID()
+	if err != nil {
+		return ""
+	}
+	return strconv.FormatUint(id, 10)
+}
+
+// connState maps the status of a NATS connection to its connectivity state. A connection that is
+// reconnecting lost the server, so it is in a transient failure.
+func connState(status nats.Status) connectivity.State {
+	switch status {
+	case nats.CONNECTED, nats.DRAINING_SUBS, nats.DRAINING_PUBS:
+		return connectivity.Ready
+	case nats.CONNECTING:
+		return connectivity.Connecting
+	case nats.CLOSED:
+		return connectivity.Shutdown
+	}
+	return connectivity.TransientFailure
+}
@@ -36,3 +36,8 @@ func (s *subscriber) SubscribeAsync(subject, queue string, handler pubsub.Handle
 func (s *subscriber) Flush() error {
 	return s.nats.Flush()
 }
+
+// Name implements the pubsub.Namer interface.
+func (s *subscriber) Name() string {
+	return "nats"
+}
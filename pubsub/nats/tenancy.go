@@ -0,0 +1,137 @@
+package nats
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nats-io/nats-server/v2/server"
+)
+
+const (
+	// inboxPrefix is the default inbox prefix of NATS connections.
+	inboxPrefix = "_INBOX"
+	// pushSubject is the subject the servers exchange the pushed messages on (see nrpc.Server.Push).
+	pushSubject = "nrpc.push"
+)
+
+// Tenancy describes the NATS users isolating the tenants of services with multi-tenancy
+// (see nrpc.WithMultiTenancy). The clients of a tenant can only reach the subjects of their tenant,
+// while the servers serve all tenants.
+type Tenancy struct {
+	// Prefix are the segments the subjects are prefixed with by nrpc.WithSubjectPrefix and
+	// nrpc.WithEnvironment, joined with a dot.
+	Prefix string
+	// Servers is the user the servers connect with.
+	Servers User
+	// Tenants maps the tenants to the user their clients connect with.
+	Tenants map[string]User
+}
+
+// User holds the credentials of a NATS user.
+type User struct {
+	Name     string
+	Password string
+}
+
+// TenantInboxPrefix returns the inbox prefix the connections of the clients of the tenant must use
+// (see nats.CustomInboxPrefix), as tenants can only receive replies on their own inboxes.
+func TenantInboxPrefix(tenant string) string {
+	return inboxPrefix + "_" + tenant
+}
+
+// ServerPermissions returns the permissions of the servers. They can publish on any subject to reply
+// to the clients of all tenants and subscribe to the subjects of all tenants. Only the servers can push
+// messages (see nrpc.Server.Push), as pushed messages are not authenticated.
+func (t Tenancy) ServerPermissions() *server.Permissions {
+	return &server.Permissions{
+		Publish: &server.SubjectPermission{
+			Allow: []string{">"},
+		},
+		Subscribe: &server.SubjectPermission{
+			Allow: []string{t.subject(">"), inboxPrefix + ".>"},
+		},
+	}
+}
+
+// TenantPermissions returns the permissions of the clients of the tenant. They can call the services
+// of the tenant and discover the server instances. They can neither push messages nor receive the
+// messages pushed to the streams of any tenant, even if the subjects of the tenant cover the push
+// subject, e.g. of a tenant named nrpc.
+func (t Tenancy) TenantPermissions(tenant string) *server.Permissions {
+	return &server.Permissions{
+		Publish: &server.SubjectPermission{
+			Allow: []string{t.subject(tenant + ".>"), t.subject("nrpc.discovery.probe")},
+			Deny:  []string{t.subject(pushSubject)},
+		},
+		Subscribe: &server.SubjectPermission{
+			Allow: []string{t.subject(tenant + ".>"), TenantInboxPrefix(tenant) + ".>", t.subject("nrpc.discovery")},
+			Deny:  []string{t.subject(pushSubject)},
+		},
+	}
+}
+
+// Users returns the users of the servers and tenants to configure an embedded NATS server with.
+func (t Tenancy) Users() []*server.User {
+	users := []*server.User{{
+		Username:    t.Servers.Name,
+		Password:    t.Servers.Password,
+		Permissions: t.ServerPermissions(),
+	}}
+	for _, tenant := range t.tenants() {
+		user := t.Tenants[tenant]
+		users = append(users, &server.User{
+			Username:    user.Name,
+			Password:    user.Password,
+			Permissions: t.TenantPermissions(tenant),
+		})
+	}
+	return users
+}
+
+// Config returns the authorization block of the NATS server configuration defining the users
+// of the servers and tenants.
+func (t Tenancy) Config() string {
+	var b strings.Builder
+	b.WriteString("authorization {\n  users = [\n")
+	for _, user := range t.Users() {
+		fmt.Fprintf(&b, "    {user: %s, password: %s, permissions: {publish: %s, subscribe: %s}}\n",
+			strconv.Quote(user.Username), strconv.Quote(user.Password),
+			subjectPermission(user.Permissions.Publish), subjectPermission(user.Permissions.Subscribe))
+	}
+	b.WriteString("  ]\n}\n")
+	return b.String()
+}
+
+func (t Tenancy) subject(subject string) string {
+	if t.Prefix == "" {
+		return subject
+	}
+	return t.Prefix + "." + subject
+}
+
+func (t Tenancy) tenants() []string {
+	tenants := make([]string, 0, len(t.Tenants))
+	for tenant := range t.Tenants {
+		tenants = append(tenants, tenant)
+	}
+	sort.Strings(tenants)
+	return tenants
+}
+
+// subjectPermission formats the subject permission as block of the NATS server configuration.
+func subjectPermission(p *server.SubjectPermission) string {
+	if len(p.Deny) == 0 {
+		return "{allow: " + quoteAll(p.Allow) + "}"
+	}
+	return "{allow: " + quoteAll(p.Allow) + ", deny: " + quoteAll(p.Deny) + "}"
+}
+
+func quoteAll(values []string) string {
+	quoted := make([]string, 0, len(values))
+	for _, v := range values {
+		quoted = append(quoted, strconv.Quote(v))
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
@@ -1,8 +1,51 @@
 package pubsub
 
-import "context"
+import (
+	"context"
+	"errors"
 
+	"google.golang.org/grpc/connectivity"
+)
+
+// ErrNoResponders is returned by Request if nobody is subscribed to the subject.
+// Implementations should wrap or return it so callers can detect unavailable services.
+var ErrNoResponders = errors.New("pubsub: no responders available for request")
+
+// Publisher publishes messages to the broker.
 type Publisher interface {
+	// Publish publishes the message without waiting for a reply.
 	Publish(msg Message) error
+	// Request publishes the message and waits for the first reply. Unary calls are sent with Request,
+	// so implementations should use the request-reply mechanism of the broker where it has one
+	// (e.g. the shared response inbox of NATS) instead of subscribing a reply subject per request.
 	Request(ctx context.Context, msg Message) (Message, error)
 }
+
+// StateReporter is implemented by publishers that know the state of their connection to the broker.
+// Clients on such publishers report it as their connectivity state and can hold back calls until
+// the connection is ready.
+type StateReporter interface {
+	// State returns the current state of the connection.
+	State() connectivity.State
+	// WaitForStateChange waits until the state differs from the source state or the context is done.
+	// It reports whether the state changed.
+	WaitForStateChange(ctx context.Context, source connectivity.State) bool
+}
+
+// ClientIdentifier is implemented by publishers that know the ID of their connection to the broker.
+// Clients on such publishers send it with their calls, so servers can identify the connection of
+// their peers.
+type ClientIdentifier interface {
+	// ClientID returns the ID of the connection. It is empty if the ID is not known, e.g. while disconnected.
+	ClientID() string
+}
+
+// AsyncRequester is implemented by publishers that wait for the replies of requests without blocking a
+// goroutine per request. Clients on such publishers send the calls of Client.InvokeAsync with it.
+type AsyncRequester interface {
+	// RequestAsync publishes the message and calls fn with the first reply or with the error the request
+	// failed with, e.g. the error of the context once it is done. fn is called exactly once, possibly
+	// before RequestAsync returns, unless RequestAsync returns an error. Implementations may notice the
+	// cancellation of a context with a deadline only at its deadline.
+	RequestAsync(ctx context.Context, msg Message, fn func(reply Message, err error)) error
+}
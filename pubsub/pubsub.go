@@ -1,3 +1,12 @@
 // Package pubsub defines the Publisher and Subscriber interfaces.
-// At this point there is a nats implementation in the `nats` subfolder.
+// At this point there is a nats implementation in the `nats` subfolder,
+// a NATS JetStream implementation in the `jetstream` subfolder, a Redis
+// implementation in the `redis` subfolder, a Kafka implementation in the
+// `kafka` subfolder, an MQTT 5 implementation in the `mqtt` subfolder and an
+// in-memory implementation for tests in the `memory` subfolder.
+//
+// Subjects are dot-separated tokens like the subjects of NATS. Subscriptions may use the wildcards
+// of NATS (see HasWildcards): the nats, jetstream and memory implementations support them natively,
+// the redis implementation translates them to PSUBSCRIBE. The kafka and mqtt implementations return
+// ErrWildcards instead of silently receiving nothing.
 package pubsub
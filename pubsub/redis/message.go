@@ -0,0 +1,54 @@
+package redis
+
+import (
+	"context"
+	"errors"
+
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/tehsphinx/nrpc/pubsub"
+)
+
+var errNoReply = errors.New("redis: message has no reply subject")
+
+type message struct {
+	client  goredis.UniversalClient
+	subject string
+	env     envelope
+}
+
+var _ pubsub.Replier = (*message)(nil)
+var _ pubsub.Identifier = (*message)(nil)
+
+// Subject implements the pubsub.Replier interface.
+func (s message) Subject() string {
+	return s.subject
+}
+
+// Data implements the pubsub.Replier interface.
+func (s message) Data() []byte {
+	return s.env.Data
+}
+
+// ID implements the pubsub.Identifier interface.
+func (s message) ID() string {
+	return s.env.ID
+}
+
+// Reply implements the pubsub.Replier interface. The reply is added to the stream of the requester.
+func (s message) Reply(msg pubsub.Reply) error {
+	if s.env.Reply == "" {
+		return errNoReply
+	}
+
+	ctx := context.Background()
+	_, err := s.client.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+		pipe.XAdd(ctx, &goredis.XAddArgs{
+			Stream: s.env.Reply,
+			Values: map[string]interface{}{replyField: msg.Data},
+		})
+		// the stream is removed by the requester; the expiry cleans up after requesters that gave up
+		pipe.Expire(ctx, s.env.Reply, inboxTTL)
+		return nil
+	})
+	return err
+}
@@ -0,0 +1,81 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/tehsphinx/nrpc/pubsub"
+)
+
+// Publisher returns a Redis wrapper implementing the pubsub.Publisher interface.
+func Publisher(client goredis.UniversalClient) pubsub.Publisher {
+	return &publisher{client: client}
+}
+
+type publisher struct {
+	client goredis.UniversalClient
+}
+
+// Publish implements the pubsub.Publisher interface.
+func (s *publisher) Publish(msg pubsub.Message) error {
+	_, err := s.publish(context.Background(), msg)
+	return err
+}
+
+// publish publishes the message and returns the number of subscribers that received it.
+func (s *publisher) publish(ctx context.Context, msg pubsub.Message) (int64, error) {
+	payload, err := json.Marshal(envelope{
+		UID:   newUID(),
+		ID:    msg.ID,
+		Reply: msg.Reply,
+		Data:  msg.Data,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return s.client.Publish(ctx, msg.Subject, payload).Result()
+}
+
+// Request implements the pubsub.Publisher interface.
+func (s *publisher) Request(ctx context.Context, msg pubsub.Message) (pubsub.Message, error) {
+	inbox := inboxPrefix + newUID()
+	defer s.client.Del(context.Background(), inbox)
+
+	msg.Reply = inbox
+	receivers, err := s.publish(ctx, msg)
+	if err != nil {
+		return pubsub.Message{}, err
+	}
+	if receivers == 0 {
+		return pubsub.Message{}, fmt.Errorf("%w: %s", pubsub.ErrNoResponders, msg.Subject)
+	}
+
+	for {
+		streams, err := s.client.XRead(ctx, &goredis.XReadArgs{
+			Streams: []string{inbox, "0"},
+			Count:   1,
+			Block:   pollInterval,
+		}).Result()
+		if errors.Is(err, goredis.Nil) {
+			if r := ctx.Err(); r != nil {
+				return pubsub.Message{}, r
+			}
+			continue
+		}
+		if err != nil {
+			if r := ctx.Err(); r != nil {
+				return pubsub.Message{}, r
+			}
+			return pubsub.Message{}, err
+		}
+
+		data, _ := streams[0].Messages[0].Values[replyField].(string)
+		return pubsub.Message{
+			Subject: inbox,
+			Data:    []byte(data),
+		}, nil
+	}
+}
@@ -0,0 +1,41 @@
+// Package redis implements the pub/sub interfaces for Redis.
+//
+// Messages are sent via Redis PUB/SUB. Subscribers sharing a queue claim each message with
+// SET NX, so only one of them handles it. Replies to requests are added to a Redis stream
+// created per request, so they are not lost if they arrive before the requester reads them.
+// Subjects with the wildcards of NATS (see pubsub.HasWildcards) are subscribed with PSUBSCRIBE.
+package redis
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+const (
+	inboxPrefix = "nrpc:inbox:"
+	claimPrefix = "nrpc:claim:"
+	replyField  = "data"
+
+	claimTTL = time.Minute
+	inboxTTL = time.Minute
+	// pollInterval bounds the time a request blocks on the reply stream before checking its context.
+	pollInterval = 500 * time.Millisecond
+
+	uidLen = 16
+)
+
+// envelope wraps the messages published via Redis PUB/SUB.
+type envelope struct {
+	// UID uniquely identifies the published message. Subscribers of a queue claim it.
+	UID   string `json:"u"`
+	ID    string `json:"i,omitempty"`
+	Reply string `json:"r,omitempty"`
+	Data  []byte `json:"d,omitempty"`
+}
+
+func newUID() string {
+	b := make([]byte, uidLen)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,124 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync/atomic"
+
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/tehsphinx/nrpc/pubsub"
+)
+
+// Subscriber returns a Redis wrapper implementing the pubsub.Subscriber interface.
+func Subscriber(client goredis.UniversalClient) pubsub.Subscriber {
+	return &subscriber{client: client}
+}
+
+type subscriber struct {
+	client goredis.UniversalClient
+}
+
+// Subscribe implements the pubsub.Subscriber interface.
+func (s *subscriber) Subscribe(subject, queue string, handler pubsub.Handler) (pubsub.Subscription, error) {
+	return s.subscribe(subject, queue, handler, false)
+}
+
+// SubscribeAsync implements the pubsub.Subscriber interface.
+func (s *subscriber) SubscribeAsync(subject, queue string, handler pubsub.Handler) (pubsub.Subscription, error) {
+	return s.subscribe(subject, queue, handler, true)
+}
+
+func (s *subscriber) subscribe(subject, queue string, handler pubsub.Handler, async bool) (pubsub.Subscription, error) {
+	ctx := context.Background()
+
+	wildcards := pubsub.HasWildcards(subject)
+	var ps *goredis.PubSub
+	if wildcards {
+		ps = s.client.PSubscribe(ctx, globPattern(subject))
+	} else {
+		ps = s.client.Subscribe(ctx, subject)
+	}
+	// wait for the confirmation, so messages published after returning are received
+	if _, err := ps.Receive(ctx); err != nil {
+		_ = ps.Close()
+		return nil, err
+	}
+
+	go func() {
+		for msg := range ps.Channel() {
+			if wildcards && !pubsub.MatchSubject(subject, msg.Channel) {
+				// the glob pattern matches across tokens
+				continue
+			}
+			s.handle(ctx, msg, queue, handler, async)
+		}
+	}()
+	return &subscription{ps: ps}, nil
+}
+
+// globPattern translates the wildcards of the subject to the glob pattern of PSUBSCRIBE. The pattern
+// matches a superset of the subjects: * of globs matches across tokens.
+func globPattern(subject string) string {
+	tokens := strings.Split(subject, ".")
+	for i, token := range tokens {
+		if token == "*" || token == ">" {
+			tokens[i] = "*"
+			continue
+		}
+		tokens[i] = globEscaper.Replace(token)
+	}
+	return strings.Join(tokens, ".")
+}
+
+// globEscaper escapes the special characters of glob patterns.
+var globEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
+func (s *subscriber) handle(ctx context.Context, msg *goredis.Message, queue string, handler pubsub.Handler, async bool) {
+	var env envelope
+	if err := json.Unmarshal([]byte(msg.Payload), &env); err != nil {
+		return
+	}
+	if queue != "" {
+		claimed, err := s.client.SetNX(ctx, claimPrefix+queue+":"+env.UID, 1, claimTTL).Result()
+		if err != nil || !claimed {
+			// another subscriber of the queue handles the message
+			return
+		}
+	}
+
+	m := message{client: s.client, subject: msg.Channel, env: env}
+	if async {
+		go handler(ctx, m)
+		return
+	}
+	handler(ctx, m)
+}
+
+// Flush implements the pubsub.Subscriber interface.
+func (s *subscriber) Flush() error {
+	return s.client.Ping(context.Background()).Err()
+}
+
+// Name implements the pubsub.Namer interface.
+func (s *subscriber) Name() string {
+	return "redis"
+}
+
+type subscription struct {
+	ps     *goredis.PubSub
+	closed uint32
+}
+
+// Unsubscribe implements the pubsub.Subscription interface.
+func (s *subscription) Unsubscribe() error {
+	if !atomic.CompareAndSwapUint32(&s.closed, 0, 1) {
+		return nil
+	}
+	return s.ps.Close()
+}
+
+// IsValid implements the pubsub.Subscription interface.
+func (s *subscription) IsValid() bool {
+	return atomic.LoadUint32(&s.closed) == 0
+}
@@ -0,0 +1,58 @@
+package pubsub
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrWildcards is returned by Subscribe of transports not supporting subjects with wildcards.
+var ErrWildcards = errors.New("pubsub: the transport does not support wildcard subjects")
+
+// HasWildcards reports whether the subject contains the wildcard tokens * (one token) or > (one or more
+// trailing tokens) of NATS subjects.
+func HasWildcards(subject string) bool {
+	for subject != "" {
+		var token string
+		token, subject = nextToken(subject)
+		if token == "*" || token == ">" {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchSubject reports whether the subject matches the pattern, which may contain the wildcard tokens of
+// NATS subjects (see HasWildcards). It walks the tokens without splitting the subjects, so transports can
+// call it for every subscription on every message.
+func MatchSubject(pattern, subject string) bool {
+	if pattern == subject {
+		return true
+	}
+	if !strings.ContainsAny(pattern, "*>") {
+		return false
+	}
+	for pattern != "" {
+		var token, subjectToken string
+		token, pattern = nextToken(pattern)
+		if token == ">" {
+			return subject != ""
+		}
+		if subject == "" {
+			return false
+		}
+		subjectToken, subject = nextToken(subject)
+		if token != "*" && token != subjectToken {
+			return false
+		}
+	}
+	return subject == ""
+}
+
+// nextToken returns the first token of the subject and the rest of the subject following it.
+func nextToken(subject string) (string, string) {
+	i := strings.IndexByte(subject, '.')
+	if i < 0 {
+		return subject, ""
+	}
+	return subject[:i], subject[i+1:]
+}
@@ -0,0 +1,36 @@
+package pubsub_test
+
+import (
+	"testing"
+
+	"github.com/matryer/is"
+	"github.com/tehsphinx/nrpc/pubsub"
+)
+
+func TestSubjectWildcards(t *testing.T) {
+	tests := []struct {
+		pattern   string
+		subject   string
+		wildcards bool
+		match     bool
+	}{
+		{pattern: "nrpc.pkg.Service.Method", subject: "nrpc.pkg.Service.Method", match: true},
+		{pattern: "nrpc.pkg.Service.Method", subject: "nrpc.pkg.Service.Other"},
+		{pattern: "nrpc.*.Service.Method", subject: "nrpc.pkg.Service.Method", wildcards: true, match: true},
+		{pattern: "nrpc.*.Method", subject: "nrpc.pkg.Service.Method", wildcards: true},
+		{pattern: "nrpc.>", subject: "nrpc.pkg.Service.Method", wildcards: true, match: true},
+		{pattern: "nrpc.>", subject: "nrpc", wildcards: true},
+		{pattern: "*", subject: "tenant", wildcards: true, match: true},
+		{pattern: "nrpc.a*b", subject: "nrpc.a*b", match: true},
+		{pattern: "nrpc.a*b", subject: "nrpc.axb"},
+		{pattern: "nrpc.x>", subject: "nrpc.xy"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.subject, func(t *testing.T) {
+			asrt := is.New(t)
+			asrt.Equal(pubsub.HasWildcards(tt.pattern), tt.wildcards)
+			asrt.Equal(pubsub.MatchSubject(tt.pattern, tt.subject), tt.match)
+		})
+	}
+}
@@ -2,6 +2,7 @@ package pubsub
 
 import (
 	"context"
+	"time"
 )
 
 type Subscriber interface {
@@ -20,7 +21,51 @@ type Replier interface {
 	Reply(msg Reply) error
 }
 
+// Identifier is implemented by received messages carrying the ID set by the publisher.
+// Transports with at-least-once delivery implement it, so redelivered messages can be detected.
+type Identifier interface {
+	ID() string
+}
+
+// Acknowledger is implemented by received messages that are redelivered until they are acknowledged,
+// e.g. the jobs delivered by the jetstream subscriber (see nrpc.Server.HandleJob).
+type Acknowledger interface {
+	// Ack acknowledges the message: it is not delivered again.
+	Ack() error
+	// Nack requeues the message: it is delivered again once the delay passed.
+	Nack(delay time.Duration) error
+	// Term acknowledges the message without processing it, e.g. because it cannot be decoded.
+	Term() error
+	// InProgress extends the lease of the message, so it is not redelivered while it is processed.
+	InProgress() error
+}
+
+// DeliveryCounter is implemented by received messages that count their deliveries, e.g. the jobs
+// delivered by the jetstream subscriber. Servers back off further with each delivery of a failing job.
+type DeliveryCounter interface {
+	// Deliveries returns the number of times the message was delivered, including this delivery.
+	Deliveries() int
+}
+
+// ReplyAddresser is implemented by received messages exposing the subject their replies are published to.
+type ReplyAddresser interface {
+	ReplySubject() string
+}
+
+// Namer is implemented by subscribers naming their transport, e.g. "nats". Servers expose the name
+// to their handlers with the peer of a call.
+type Namer interface {
+	Name() string
+}
+
 type Subscription interface {
 	Unsubscribe() error
 	IsValid() bool
 }
+
+// LossNotifier is implemented by subscriptions notifying about their loss, e.g. because the broker restarted.
+// The channel returned by Lost is closed once the subscription was lost. It is not closed by Unsubscribe.
+// The loss of subscriptions not implementing it is detected by checking IsValid periodically.
+type LossNotifier interface {
+	Lost() <-chan struct{}
+}
@@ -0,0 +1,203 @@
+package nrpc
+
+import (
+	"context"
+	"sync"
+
+	"github.com/tehsphinx/nrpc/pubsub"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// PushKeyMetadataKey is the metadata key of the push key a client opens a stream with, so the server
+// can push messages into it (see AcceptPush).
+const PushKeyMetadataKey = "nrpc-push-key"
+
+const (
+	// pushSubj is the subject all server instances receive the pushed messages on. Only servers may publish
+	// on it (see Server.Push).
+	pushSubj = "nrpc.push"
+	// pushBuffer is the number of pushed messages buffered per stream. Messages pushed to a stream whose
+	// buffer is full are dropped.
+	pushBuffer = 64
+)
+
+// NewPushKeyContext returns a context opening streams with the push key, e.g. the ID of the user
+// receiving notifications on the stream.
+func NewPushKeyContext(ctx context.Context, key string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, PushKeyMetadataKey, key)
+}
+
+type pushKey struct{}
+
+// pushTarget identifies the streams a message is pushed to: the streams of the tenant accepting the key.
+// The tenant is empty on servers without multi-tenancy.
+type pushTarget struct {
+	tenant string
+	key    string
+}
+
+// pushRegistry holds the channels of the streams accepting pushed messages by their tenant and push key.
+type pushRegistry struct {
+	m     sync.Mutex
+	byKey map[pushTarget]map[chan proto.Message]struct{}
+}
+
+func newPushRegistry() *pushRegistry {
+	return &pushRegistry{byKey: map[pushTarget]map[chan proto.Message]struct{}{}}
+}
+
+// add registers the channel under the target until the context is done.
+func (s *pushRegistry) add(ctx context.Context, key pushTarget, ch chan proto.Message) {
+	s.m.Lock()
+	if s.byKey[key] == nil {
+		s.byKey[key] = map[chan proto.Message]struct{}{}
+	}
+	s.byKey[key][ch] = struct{}{}
+	s.m.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		s.m.Lock()
+		defer s.m.Unlock()
+		delete(s.byKey[key], ch)
+		if len(s.byKey[key]) == 0 {
+			delete(s.byKey, key)
+		}
+	}()
+}
+
+// deliver passes the message to the streams registered under the target. It returns the number of streams
+// the message was dropped for as their buffer is full.
+func (s *pushRegistry) deliver(key pushTarget, msg proto.Message) (dropped int) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	for ch := range s.byKey[key] {
+		select {
+		case ch <- msg:
+		default:
+			dropped++
+		}
+	}
+	return dropped
+}
+
+// AcceptPush registers the server stream under the push key the client opened it with (see
+// NewPushKeyContext) and returns the messages pushed to the key (see Server.Push) until the stream ends.
+// On servers with multi-tenancy, the stream only accepts the messages pushed to the tenant that opened it.
+// The handler sends them on the stream itself, as streams must not be sent on concurrently. Servers
+// validate the key before accepting it, e.g. that it names the authenticated user. It fails if the client
+// did not set a push key or if the stream is not a stream of an nrpc server.
+func AcceptPush(stream grpc.ServerStream) (<-chan proto.Message, error) {
+	ctx := stream.Context()
+	registry, ok := ctx.Value(pushKey{}).(*pushRegistry)
+	if !ok {
+		return nil, status.Error(codes.FailedPrecondition, "nrpc: the stream does not support pushed messages")
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	keys := md.Get(PushKeyMetadataKey)
+	if len(keys) == 0 || keys[0] == "" {
+		return nil, status.Error(codes.InvalidArgument, "nrpc: the stream was opened without push key")
+	}
+
+	tenant, _ := TenantFromContext(ctx)
+	ch := make(chan proto.Message, pushBuffer)
+	registry.add(ctx, pushTarget{tenant: tenant, key: keys[0]}, ch)
+	return ch, nil
+}
+
+// ServePush accepts the messages pushed to the stream (see AcceptPush) and sends them on the stream until
+// it ends. It returns nil once the context of the stream is done, e.g. because the client canceled it.
+// Handlers of streams only carrying pushed messages return it.
+func ServePush(stream grpc.ServerStream) error {
+	pushed, err := AcceptPush(stream)
+	if err != nil {
+		return err
+	}
+	ctx := stream.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg := <-pushed:
+			if r := stream.SendMsg(msg); r != nil {
+				return r
+			}
+		}
+	}
+}
+
+// Push sends the message to the streams accepting pushed messages with the key (see AcceptPush) on all
+// server instances sharing the broker, including this one. Delivery is best effort: the message is lost
+// for the streams not accepting it at the moment or not keeping up with the pushed messages. The message
+// type must be registered with the protobuf registry of the receiving instances, as generated code does.
+// Servers with multi-tenancy push the message to the streams of the tenant of the context (see
+// TenantFromContext), e.g. the one of the call handled with it, and fail without a valid tenant.
+// Pushed messages are published on the subject nrpc.push, mapped by WithSubjectPrefix and WithEnvironment,
+// and they are not authenticated: anyone publishing on the subject reaches the streams of all tenants.
+// The broker must restrict the subject to the servers, e.g. with the permissions of nats.Tenancy.
+func (s *Server) Push(ctx context.Context, key string, msg proto.Message) error {
+	var tenant string
+	if s.multiTenant {
+		tenant, _ = TenantFromContext(ctx)
+		if !validTenant(tenant) {
+			return status.Errorf(codes.InvalidArgument, "nrpc: invalid or missing tenant %q", tenant)
+		}
+	}
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return status.Errorf(codes.Internal, "grpc: error while marshaling: %v", err)
+	}
+	payload, err := proto.Marshal(&Push{
+		Key:    key,
+		Type:   string(msg.ProtoReflect().Descriptor().FullName()),
+		Data:   data,
+		Tenant: tenant,
+	})
+	if err != nil {
+		return status.Errorf(codes.Internal, "grpc: error while marshaling: %v", err)
+	}
+	return toRPCErr(s.pub.Publish(pubsub.Message{
+		Subject: s.subjects.MapSubject(pushSubj),
+		Data:    payload,
+	}))
+}
+
+// registerPush makes the server deliver the pushed messages to its streams accepting them.
+func (s *Server) registerPush() {
+	s.subs.RegisterSubscription(subscription{
+		endpoint: s.subjects.MapSubject(pushSubj),
+		handler:  s.handlePush,
+		control:  true,
+	})
+}
+
+// handlePush delivers the pushed message to the streams of the server accepting its tenant and key.
+func (s *Server) handlePush(_ context.Context, msg pubsub.Replier) {
+	var push Push
+	if r := proto.Unmarshal(msg.Data(), &push); r != nil {
+		s.log.Warn("failed to unmarshal pushed message", "error", r)
+		return
+	}
+	typ, err := protoregistry.GlobalTypes.FindMessageByName(protoreflect.FullName(push.Type))
+	if err != nil {
+		s.log.Warn("unknown type of pushed message", "type", push.Type, "error", err)
+		return
+	}
+	m := typ.New().Interface()
+	if r := proto.Unmarshal(push.Data, m); r != nil {
+		s.log.Warn("failed to unmarshal pushed message", "type", push.Type, "error", r)
+		return
+	}
+	if dropped := s.pushes.deliver(pushTarget{tenant: push.Tenant, key: push.Key}, m); dropped != 0 {
+		s.log.Warn("dropped pushed message for streams not keeping up", "tenant", push.Tenant, "key", push.Key,
+			"streams", dropped)
+	}
+}
@@ -0,0 +1,15 @@
+package nrpc
+
+// queueGroupPolicies holds the queue groups configured for methods, services and the default.
+type queueGroupPolicies map[string][]string
+
+// get returns the queue groups the full method (/service/method) of the service is served in.
+// Methods are served in the queue group of their service by default.
+func (p queueGroupPolicies) get(method, service string) []string {
+	for _, key := range policyKeys(method) {
+		if groups, ok := p[key]; ok && len(groups) != 0 {
+			return groups
+		}
+	}
+	return []string{service}
+}
@@ -0,0 +1,328 @@
+// Package ratelimit implements token bucket rate limiting of nrpc clients and servers as interceptors.
+// Limits are set per method and can apply to every caller separately, identified by a metadata value:
+//
+//	limiter := ratelimit.New(ratelimit.WithLimit(ratelimit.Limit{Rate: 100, Burst: 10}), ratelimit.PerCaller("x-api-key"))
+//	server := nrpc.NewServer(pub, sub, limiter.ServerOptions()...)
+//
+// Calls exceeding the limit are rejected with codes.ResourceExhausted. The time after which the
+// call can be retried is sent in the header of the rejected call (see RetryAfter).
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tehsphinx/nrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// RetryAfterKey is the metadata key of the seconds after which a rejected call can be retried.
+const RetryAfterKey = "retry-after"
+
+// sweepInterval is the interval idle buckets of callers are removed in.
+const sweepInterval = time.Minute
+
+// Limit is the rate limit of a token bucket.
+type Limit struct {
+	// Rate is the number of calls per second.
+	Rate float64
+	// Burst is the number of calls allowed at once. It is at least 1.
+	Burst int
+}
+
+// Option configures the limiter.
+type Option func(cfg *config)
+
+type config struct {
+	limits   map[string]Limit
+	caller   string
+	services bool
+}
+
+// WithLimit sets the limit of the given methods. Methods are given as full method (/service/method)
+// or as service name to apply the limit to all methods of the service. Without methods the limit
+// becomes the default for all methods. Every method has its own bucket.
+func WithLimit(limit Limit, methods ...string) Option {
+	return func(cfg *config) {
+		if len(methods) == 0 {
+			cfg.limits[""] = limit
+			return
+		}
+		for _, method := range methods {
+			cfg.limits[method] = limit
+		}
+	}
+}
+
+// PerCaller limits every caller separately. Callers are identified by the value of the metadata key,
+// e.g. an API key. Calls without the key share a bucket.
+func PerCaller(key string) Option {
+	return func(cfg *config) {
+		cfg.caller = strings.ToLower(key)
+	}
+}
+
+// PerCallerService limits the services calling a server separately. They are identified by the identity
+// the clients announce (see nrpc.WithClientIdentity), so all instances of a service share a bucket. Calls
+// of clients not announcing an identity share a bucket. Client interceptors ignore the option.
+func PerCallerService() Option {
+	return func(cfg *config) {
+		cfg.services = true
+	}
+}
+
+// Limiter limits the rate of calls with token buckets.
+type Limiter struct {
+	cfg config
+
+	m         sync.Mutex
+	buckets   map[bucketKey]*bucket
+	lastSweep time.Time
+}
+
+type bucketKey struct {
+	method string
+	caller string
+}
+
+// New creates a limiter.
+func New(opts ...Option) *Limiter {
+	cfg := config{limits: map[string]Limit{}}
+	for _, o := range opts {
+		o(&cfg)
+	}
+	return &Limiter{
+		cfg:       cfg,
+		buckets:   map[bucketKey]*bucket{},
+		lastSweep: time.Now(),
+	}
+}
+
+// ClientOptions returns the options adding the client interceptors of the limiter to a client.
+func (l *Limiter) ClientOptions() []nrpc.Option {
+	return []nrpc.Option{
+		nrpc.WithChainUnaryInterceptor(l.UnaryClientInterceptor()),
+		nrpc.WithChainStreamInterceptor(l.StreamClientInterceptor()),
+	}
+}
+
+// ServerOptions returns the options adding the server interceptors of the limiter to a server.
+func (l *Limiter) ServerOptions() []nrpc.Option {
+	return []nrpc.Option{
+		nrpc.ChainUnaryInterceptor(l.UnaryServerInterceptor()),
+		nrpc.ChainStreamInterceptor(l.StreamServerInterceptor()),
+	}
+}
+
+// UnaryClientInterceptor returns a client interceptor rejecting unary calls exceeding the limit
+// before they are sent. The caller is identified by the outgoing metadata.
+func (l *Limiter) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		if wait, ok := l.allow(method, l.caller(ctx, md), time.Now()); !ok {
+			return rejectCall(method, wait, opts)
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor returns a client interceptor rejecting streams exceeding the limit
+// before they are opened. The caller is identified by the outgoing metadata.
+func (l *Limiter) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string,
+		streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		if wait, ok := l.allow(method, l.caller(ctx, md), time.Now()); !ok {
+			return nil, rejectCall(method, wait, opts)
+		}
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+}
+
+// UnaryServerInterceptor returns a server interceptor rejecting unary calls exceeding the limit.
+// The caller is identified by the incoming metadata.
+func (l *Limiter) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if wait, ok := l.allow(info.FullMethod, l.caller(ctx, md), time.Now()); !ok {
+			_ = grpc.SetHeader(ctx, retryAfterMD(wait))
+			return nil, rejected(info.FullMethod, wait)
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor returns a server interceptor rejecting streams exceeding the limit.
+// The caller is identified by the incoming metadata.
+func (l *Limiter) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		md, _ := metadata.FromIncomingContext(ss.Context())
+		if wait, ok := l.allow(info.FullMethod, l.caller(ss.Context(), md), time.Now()); !ok {
+			_ = ss.SetHeader(retryAfterMD(wait))
+			return rejected(info.FullMethod, wait)
+		}
+		return handler(srv, ss)
+	}
+}
+
+// caller returns the caller a call is limited for: the service announced by the caller if limited per
+// service, the value of the metadata key if limited per caller, or nothing if the callers share a bucket.
+func (l *Limiter) caller(ctx context.Context, md metadata.MD) string {
+	var caller string
+	if l.cfg.services {
+		if p, ok := nrpc.PeerFromContext(ctx); ok {
+			caller = p.Identity.Service
+		}
+	}
+	if l.cfg.caller != "" {
+		if values := md.Get(l.cfg.caller); len(values) != 0 {
+			caller += "/" + values[0]
+		}
+	}
+	return caller
+}
+
+// allow takes a token from the bucket of the call. If the bucket is empty, it returns the time
+// until a token is available.
+func (l *Limiter) allow(method, caller string, now time.Time) (time.Duration, bool) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	limit, ok := l.limit(method)
+	if !ok {
+		return 0, true
+	}
+	key := bucketKey{method: method, caller: caller}
+
+	if now.Sub(l.lastSweep) > sweepInterval {
+		l.sweep(now)
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = newBucket(limit, now)
+		l.buckets[key] = b
+	}
+	return b.take(now)
+}
+
+// sweep removes the buckets that are full again, as they behave like new buckets. The lock must be held.
+func (l *Limiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if b.full(now) {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// SetLimits replaces the limits of the limiter at runtime, e.g. with the limits of an updated configuration.
+// The limits are keyed like the methods of WithLimit: by full method, by service name or by "" for the
+// default. The buckets start over with the new limits.
+func (l *Limiter) SetLimits(limits map[string]Limit) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	l.cfg.limits = make(map[string]Limit, len(limits))
+	for key, limit := range limits {
+		l.cfg.limits[key] = limit
+	}
+	l.buckets = map[bucketKey]*bucket{}
+}
+
+// limit returns the limit of the full method (/service/method). The lock must be held.
+func (l *Limiter) limit(method string) (Limit, bool) {
+	service := strings.TrimPrefix(method, "/")
+	if i := strings.Index(service, "/"); i >= 0 {
+		service = service[:i]
+	}
+	for _, key := range []string{method, service, ""} {
+		if limit, ok := l.cfg.limits[key]; ok {
+			return limit, true
+		}
+	}
+	return Limit{}, false
+}
+
+// RetryAfter returns the time after which a call rejected by a limiter can be retried. The metadata is the
+// header of the rejected call (see grpc.Header).
+func RetryAfter(md metadata.MD) (time.Duration, bool) {
+	values := md.Get(RetryAfterKey)
+	if len(values) == 0 {
+		return 0, false
+	}
+	seconds, err := strconv.ParseFloat(values[0], 64)
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(seconds * float64(time.Second)), true
+}
+
+func retryAfterMD(wait time.Duration) metadata.MD {
+	return metadata.Pairs(RetryAfterKey, strconv.FormatFloat(wait.Seconds(), 'f', 3, 64))
+}
+
+func rejected(method string, wait time.Duration) error {
+	return status.Errorf(codes.ResourceExhausted, "ratelimit: rate limit of %s exceeded, retry after %v", method, wait)
+}
+
+// rejectCall returns the error of a call rejected by the client. The retry-after metadata is passed
+// to the grpc.Header call options of the call.
+func rejectCall(method string, wait time.Duration, opts []grpc.CallOption) error {
+	for _, o := range opts {
+		if header, ok := o.(grpc.HeaderCallOption); ok {
+			*header.HeaderAddr = retryAfterMD(wait)
+		}
+	}
+	return rejected(method, wait)
+}
+
+// bucket is a token bucket.
+type bucket struct {
+	limit  Limit
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(limit Limit, now time.Time) *bucket {
+	if limit.Burst < 1 {
+		limit.Burst = 1
+	}
+	return &bucket{
+		limit:  limit,
+		tokens: float64(limit.Burst),
+		last:   now,
+	}
+}
+
+// take takes a token. If there is none, it returns the time until a token is available.
+func (b *bucket) take(now time.Time) (time.Duration, bool) {
+	b.refill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, true
+	}
+	if b.limit.Rate <= 0 {
+		return time.Duration(math.MaxInt64), false
+	}
+	return time.Duration((1 - b.tokens) / b.limit.Rate * float64(time.Second)), false
+}
+
+func (b *bucket) full(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= float64(b.limit.Burst)
+}
+
+func (b *bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(float64(b.limit.Burst), b.tokens+elapsed.Seconds()*b.limit.Rate)
+		b.last = now
+	}
+}
@@ -0,0 +1,39 @@
+package nrpc
+
+import (
+	"context"
+	"runtime/debug"
+
+	"github.com/tehsphinx/nrpc/pubsub"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// recoverPanic recovers a panic of a handler or interceptor. The panic is logged with its stack
+// trace and turned into a codes.Internal error, so the caller gets an answer instead of waiting for
+// its deadline. It must be deferred directly.
+func (s *Server) recoverPanic(fullMethod string, err *error) {
+	p := recover()
+	if p == nil {
+		return
+	}
+
+	s.log.Error("panic recovered in handler", "method", fullMethod, "panic", p, "stack", string(debug.Stack()))
+	*err = status.Errorf(codes.Internal, "panic in handler of %s: %v", fullMethod, p)
+}
+
+// recoverHandler guards the subscription handler of an endpoint against panics outside of the
+// service handler, e.g. in a stats handler or codec. The panic is logged and answered with codes.Internal.
+func (s *Server) recoverHandler(fullMethod string, handler pubsub.Handler) pubsub.Handler {
+	return func(ctx context.Context, msg pubsub.Replier) {
+		var err error
+		defer func() {
+			if err != nil {
+				s.respondErr(msg, err)
+			}
+		}()
+		defer s.recoverPanic(fullMethod, &err)
+
+		handler(ctx, msg)
+	}
+}
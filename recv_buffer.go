@@ -0,0 +1,73 @@
+package nrpc
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// OverflowPolicy defines what a stream does with a received message when its receive buffer is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock waits for the consumer to make room. The stream is closed if no message is
+	// consumed within the consumer stuck timeout. This is the default.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropOldest drops the oldest buffered message to make room for the received one.
+	// The end of the stream and errors are never dropped: the stream is aborted instead.
+	OverflowDropOldest
+	// OverflowAbort aborts the stream with codes.ResourceExhausted.
+	OverflowAbort
+)
+
+// RecvBufferPolicy configures the buffer of received messages a stream holds until they are consumed.
+type RecvBufferPolicy struct {
+	// Size is the number of messages buffered. Streams with flow control buffer at least a full window
+	// and the end of the stream, so the buffer of such streams does not overflow. It defaults to 1.
+	Size int
+	// Overflow defines what happens when a message is received while the buffer is full.
+	Overflow OverflowPolicy
+	// OnDrop is called with the context of the stream for every message dropped by OverflowDropOldest.
+	OnDrop func(ctx context.Context, method string)
+}
+
+// bufferSize returns the size of the receive buffer of a stream with the receive window.
+func (p RecvBufferPolicy) bufferSize(win *recvWindow) int {
+	if size := win.bufferSize(); p.Size < size {
+		return size
+	}
+	return p.Size
+}
+
+func (p RecvBufferPolicy) dropped(ctx context.Context, method string) {
+	if p.OnDrop != nil {
+		p.OnDrop(ctx, method)
+	}
+}
+
+// recvBufferPolicies holds the receive buffer policies configured for methods, services and the default.
+type recvBufferPolicies map[string]RecvBufferPolicy
+
+// get returns the receive buffer policy of the full method (/service/method).
+func (p recvBufferPolicies) get(method string) RecvBufferPolicy {
+	for _, key := range policyKeys(method) {
+		if policy, ok := p[key]; ok {
+			return policy
+		}
+	}
+	return RecvBufferPolicy{}
+}
+
+// RecvBuffer returns a CallOption that sets the receive buffer policy of the stream. It overwrites
+// the policies configured with the WithRecvBuffer option of the client.
+func RecvBuffer(policy RecvBufferPolicy) grpc.CallOption {
+	return callOption{apply: func(opt *callOptions) {
+		opt.stream.recvBuffer = policy
+	}}
+}
+
+func errRecvOverflow(size int) error {
+	return status.Errorf(codes.ResourceExhausted, "nrpc: receive buffer of %d messages overflowed", size)
+}
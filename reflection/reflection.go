@@ -0,0 +1,73 @@
+// Package reflection implements the gRPC server reflection API (v1alpha and v1) on top of an nrpc server.
+// It allows tools to list the services of a server and fetch their descriptors.
+package reflection
+
+import (
+	"google.golang.org/grpc"
+	grpcreflection "google.golang.org/grpc/reflection"
+	rpbalpha "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/protobuf/proto"
+
+	rpb "github.com/tehsphinx/nrpc/reflection/grpc_reflection_v1"
+)
+
+// Server is the interface a server has to implement to register the reflection services.
+// The nrpc server implements it.
+type Server interface {
+	grpc.ServiceRegistrar
+	grpcreflection.ServiceInfoProvider
+}
+
+// Register registers the server reflection services in version v1alpha and v1 on the given server.
+// It must be called after all other services were registered and before the server is run.
+func Register(s Server) {
+	svr := grpcreflection.NewServer(grpcreflection.ServerOptions{Services: s})
+	rpbalpha.RegisterServerReflectionServer(s, svr)
+	rpb.RegisterServerReflectionServer(s, &v1Server{alpha: svr})
+}
+
+// v1Server serves the v1 reflection API by translating to the v1alpha implementation.
+// The messages of both versions share the same wire format.
+type v1Server struct {
+	rpb.UnimplementedServerReflectionServer
+
+	alpha rpbalpha.ServerReflectionServer
+}
+
+func (s *v1Server) ServerReflectionInfo(stream rpb.ServerReflection_ServerReflectionInfoServer) error {
+	return s.alpha.ServerReflectionInfo(&v1Stream{ServerReflection_ServerReflectionInfoServer: stream})
+}
+
+// v1Stream wraps a v1 stream to look like a v1alpha stream.
+type v1Stream struct {
+	rpb.ServerReflection_ServerReflectionInfoServer
+}
+
+func (s *v1Stream) Send(resp *rpbalpha.ServerReflectionResponse) error {
+	var out rpb.ServerReflectionResponse
+	if err := convert(resp, &out); err != nil {
+		return err
+	}
+	return s.ServerReflection_ServerReflectionInfoServer.Send(&out)
+}
+
+func (s *v1Stream) Recv() (*rpbalpha.ServerReflectionRequest, error) {
+	req, err := s.ServerReflection_ServerReflectionInfoServer.Recv()
+	if err != nil {
+		return nil, err
+	}
+
+	var out rpbalpha.ServerReflectionRequest
+	if r := convert(req, &out); r != nil {
+		return nil, r
+	}
+	return &out, nil
+}
+
+func convert(from, to proto.Message) error {
+	data, err := proto.Marshal(from)
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(data, to)
+}
@@ -0,0 +1,285 @@
+// Package relay forwards the calls of nrpc services from one broker to another, e.g. between two NATS
+// clusters of different data centers or from a DMZ to the internal cluster, without bridging the brokers:
+//
+//	target := nrpc.NewClient(nats.Publisher(connB), nats.Subscriber(connB))
+//	r := relay.New(dynamic.NewClient(target, dynamic.NewReflectionSource(target)))
+//
+//	server := nrpc.NewServer(nats.Publisher(connA), nats.Subscriber(connA))
+//	if err := r.Register(ctx, server, "pkg.Greeter"); err != nil {
+//		return err
+//	}
+//	err := server.Run(ctx)
+//
+// The relay serves the methods of the registered services on the subjects of the source broker and
+// calls them on the target broker. Unary calls and streams of all kinds are forwarded with their
+// metadata, deadline and cancellation. The header and trailer metadata and the status of the target
+// are returned to the caller. The messages are decoded with the descriptors of the dynamic client, so
+// the relay works without the generated code of the services.
+//
+// Instead of registering services, Handler forwards the calls of any method, e.g. of a grpc server
+// (see package bridge).
+package relay
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/tehsphinx/nrpc/dynamic"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Relay forwards calls to the target of a dynamic client.
+type Relay struct {
+	client *dynamic.Client
+
+	m       sync.RWMutex
+	methods map[string]protoreflect.MethodDescriptor
+}
+
+// New creates a relay forwarding the calls through the client. The descriptors of the services are
+// looked up in the source of the client.
+func New(client *dynamic.Client) *Relay {
+	return &Relay{
+		client:  client,
+		methods: map[string]protoreflect.MethodDescriptor{},
+	}
+}
+
+// Register registers the services given by their full names (package.Service) with the server, usually
+// an *nrpc.Server subscribed to the source broker. It must be called before the server is started.
+func (r *Relay) Register(ctx context.Context, server grpc.ServiceRegistrar, services ...string) error {
+	for _, service := range services {
+		sd, err := r.client.FindService(ctx, service)
+		if err != nil {
+			return err
+		}
+		r.RegisterService(server, sd)
+	}
+	return nil
+}
+
+// RegisterService registers the service of the descriptor with the server.
+func (r *Relay) RegisterService(server grpc.ServiceRegistrar, sd protoreflect.ServiceDescriptor) {
+	desc := grpc.ServiceDesc{
+		ServiceName: string(sd.FullName()),
+		HandlerType: (*interface{})(nil),
+		Metadata:    sd.ParentFile().Path(),
+	}
+
+	methods := sd.Methods()
+	for i := 0; i < methods.Len(); i++ {
+		md := methods.Get(i)
+		if !md.IsStreamingClient() && !md.IsStreamingServer() {
+			desc.Methods = append(desc.Methods, grpc.MethodDesc{
+				MethodName: string(md.Name()),
+				Handler:    r.unaryHandler(md),
+			})
+			continue
+		}
+		desc.Streams = append(desc.Streams, grpc.StreamDesc{
+			StreamName:    string(md.Name()),
+			ServerStreams: md.IsStreamingServer(),
+			ClientStreams: md.IsStreamingClient(),
+			Handler:       r.streamHandler(md),
+		})
+	}
+	// the relay does not implement the handler type, so the implementation is not checked
+	server.RegisterService(&desc, nil)
+}
+
+// Handler returns a stream handler forwarding the calls of any method found in the source of the client,
+// e.g. as grpc.UnknownServiceHandler of a grpc server. The descriptors of the methods are cached.
+func (r *Relay) Handler() grpc.StreamHandler {
+	return func(srv interface{}, serverStream grpc.ServerStream) error {
+		method, ok := grpc.MethodFromServerStream(serverStream)
+		if !ok {
+			return status.Error(codes.Internal, "relay: the method of the stream is unknown")
+		}
+		md, err := r.findMethod(serverStream.Context(), method)
+		if err != nil {
+			return status.Errorf(codes.Unimplemented, "relay: unknown method %s: %v", method, err)
+		}
+
+		if !md.IsStreamingClient() && !md.IsStreamingServer() {
+			return r.forwardUnary(md, serverStream)
+		}
+		return r.streamHandler(md)(srv, serverStream)
+	}
+}
+
+func (r *Relay) findMethod(ctx context.Context, method string) (protoreflect.MethodDescriptor, error) {
+	r.m.RLock()
+	md, ok := r.methods[method]
+	r.m.RUnlock()
+	if ok {
+		return md, nil
+	}
+
+	md, err := r.client.FindMethod(ctx, method)
+	if err != nil {
+		return nil, err
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.methods[method] = md
+	return md, nil
+}
+
+// forwardUnary forwards the unary call of a stream handler.
+func (r *Relay) forwardUnary(md protoreflect.MethodDescriptor, serverStream grpc.ServerStream) error {
+	req := dynamicpb.NewMessage(md.Input())
+	if err := serverStream.RecvMsg(req); err != nil {
+		return err
+	}
+
+	resp, header, trailer, err := r.invoke(serverStream.Context(), md, req)
+	if len(header) != 0 {
+		if headerErr := serverStream.SendHeader(header); headerErr != nil {
+			return headerErr
+		}
+	}
+	serverStream.SetTrailer(trailer)
+	if err != nil {
+		return err
+	}
+	return serverStream.SendMsg(resp)
+}
+
+// invoke calls the unary method of the target with the metadata of the caller.
+func (r *Relay) invoke(ctx context.Context, md protoreflect.MethodDescriptor, req *dynamicpb.Message) (*dynamicpb.Message,
+	metadata.MD, metadata.MD, error) {
+	var header, trailer metadata.MD
+	resp, err := r.client.Invoke(outgoing(ctx), md, req, grpc.Header(&header), grpc.Trailer(&trailer))
+	return resp, header, trailer, err
+}
+
+func (r *Relay) unaryHandler(md protoreflect.MethodDescriptor) func(interface{}, context.Context, func(interface{}) error,
+	grpc.UnaryServerInterceptor) (interface{}, error) {
+	fullMethod := dynamic.FullMethod(md)
+
+	// nolint: revive // the context is not the first argument of grpc method handlers
+	return func(_ interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+		req := dynamicpb.NewMessage(md.Input())
+		if err := dec(req); err != nil {
+			return nil, err
+		}
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			msg, _ := req.(*dynamicpb.Message)
+
+			resp, header, trailer, err := r.invoke(ctx, md, msg)
+			if len(header) != 0 {
+				_ = grpc.SetHeader(ctx, header)
+			}
+			if len(trailer) != 0 {
+				_ = grpc.SetTrailer(ctx, trailer)
+			}
+			if err != nil {
+				return nil, err
+			}
+			return resp, nil
+		}
+		if interceptor == nil {
+			return handler(ctx, req)
+		}
+		return interceptor(ctx, req, &grpc.UnaryServerInfo{FullMethod: fullMethod}, handler)
+	}
+}
+
+func (r *Relay) streamHandler(md protoreflect.MethodDescriptor) grpc.StreamHandler {
+	return func(_ interface{}, serverStream grpc.ServerStream) error {
+		ctx, cancel := context.WithCancel(outgoing(serverStream.Context()))
+		defer cancel()
+
+		clientStream, err := r.client.NewStream(ctx, md)
+		if err != nil {
+			return err
+		}
+
+		// the requests are forwarded concurrently, so the responses are forwarded while the caller sends
+		go func() {
+			if sendErr := forwardRequests(md, serverStream, clientStream); sendErr != nil {
+				// the call of the target fails with the cancellation, which is returned to the caller
+				cancel()
+			}
+		}()
+
+		return forwardResponses(md, serverStream, clientStream)
+	}
+}
+
+// forwardRequests forwards the requests of the caller to the target until the caller closes the stream.
+func forwardRequests(md protoreflect.MethodDescriptor, serverStream grpc.ServerStream, clientStream *dynamic.Stream) error {
+	for {
+		req := dynamicpb.NewMessage(md.Input())
+		if err := serverStream.RecvMsg(req); err != nil {
+			if errors.Is(err, io.EOF) {
+				return clientStream.CloseSend()
+			}
+			return err
+		}
+		if err := clientStream.Send(req); err != nil {
+			if errors.Is(err, io.EOF) {
+				// the target ended the stream, its status is returned by Recv
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// forwardResponses forwards the header, the responses and the trailer of the target to the caller.
+// The stream of a method not streaming responses ends with its response.
+func forwardResponses(md protoreflect.MethodDescriptor, serverStream grpc.ServerStream, clientStream *dynamic.Stream) error {
+	header, err := clientStream.Header()
+	if err != nil {
+		serverStream.SetTrailer(clientStream.Trailer())
+		return err
+	}
+	if len(header) != 0 {
+		if r := serverStream.SendHeader(header); r != nil {
+			return r
+		}
+	}
+
+	for {
+		resp, err := clientStream.Recv()
+		if err != nil {
+			serverStream.SetTrailer(clientStream.Trailer())
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if r := serverStream.SendMsg(resp); r != nil {
+			return r
+		}
+		if !md.IsStreamingServer() {
+			serverStream.SetTrailer(clientStream.Trailer())
+			return nil
+		}
+	}
+}
+
+// outgoing returns the context with the incoming metadata of the caller as outgoing metadata. The
+// pseudo-headers and transport headers added by grpc servers are not forwarded.
+func outgoing(ctx context.Context) context.Context {
+	in, _ := metadata.FromIncomingContext(ctx)
+	md := make(metadata.MD, len(in))
+	for key, values := range in {
+		if strings.HasPrefix(key, ":") || strings.HasPrefix(key, "grpc-") || key == "content-type" || key == "te" {
+			continue
+		}
+		md[key] = append([]string(nil), values...)
+	}
+	return metadata.NewOutgoingContext(ctx, md)
+}
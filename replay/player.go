@@ -0,0 +1,286 @@
+package replay
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/tehsphinx/nrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// Player serves recorded responses to the calls of a client instead of sending them. Unary calls are
+// answered with the exchange recorded for the same request. Streams are answered with the exchanges
+// recorded for the method in the order they were recorded, starting over once all were played.
+// Calls without recorded exchange fail with codes.NotFound.
+type Player struct {
+	exchanges []Exchange
+
+	m sync.Mutex
+	// next is the index of the exchange played to the next stream of a method.
+	next map[string]int
+}
+
+// NewPlayer creates a player of the exchanges.
+func NewPlayer(exchanges []Exchange) *Player {
+	return &Player{exchanges: exchanges, next: map[string]int{}}
+}
+
+// ClientOptions returns the options adding the client interceptors of the player to a client.
+func (p *Player) ClientOptions() []nrpc.Option {
+	return []nrpc.Option{
+		nrpc.WithChainUnaryInterceptor(p.UnaryClientInterceptor()),
+		nrpc.WithChainStreamInterceptor(p.StreamClientInterceptor()),
+	}
+}
+
+// UnaryClientInterceptor returns a client interceptor answering unary calls with the recorded responses.
+func (p *Player) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(_ context.Context, method string, req, reply interface{}, _ *grpc.ClientConn,
+		_ grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ex, err := p.unary(method, req)
+		if err != nil {
+			return err
+		}
+		applyMetadata(opts, ex.Header, ex.Trailer)
+		if received := ex.Received(); len(received) != 0 {
+			if r := received[0].unmarshal(reply); r != nil {
+				return status.Error(codes.Internal, r.Error())
+			}
+		}
+		return ex.Err()
+	}
+}
+
+// StreamClientInterceptor returns a client interceptor answering streams with the recorded responses.
+// The messages sent on the stream are discarded.
+func (p *Player) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, _ *grpc.StreamDesc, _ *grpc.ClientConn, method string,
+		_ grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		ex, err := p.stream(method)
+		if err != nil {
+			return nil, err
+		}
+		return &playedStream{ctx: ctx, ex: ex, received: ex.Received(), opts: opts}, nil
+	}
+}
+
+// unary returns the exchange recorded for the request to the method.
+func (p *Player) unary(method string, req interface{}) (Exchange, error) {
+	msg, ok := req.(proto.Message)
+	if !ok {
+		return Exchange{}, status.Errorf(codes.Internal, "replay: %T is not a protobuf message", req)
+	}
+	data, err := marshal.Marshal(msg)
+	if err != nil {
+		return Exchange{}, status.Error(codes.Internal, err.Error())
+	}
+	for _, ex := range p.exchanges {
+		if ex.Method != method || ex.ClientStreams || ex.ServerStreams {
+			continue
+		}
+		if sent := ex.Sent(); len(sent) != 0 && bytes.Equal(sent[0].Data, data) {
+			return ex, nil
+		}
+	}
+	return Exchange{}, status.Errorf(codes.NotFound, "replay: no exchange of %s recorded for the request", method)
+}
+
+// stream returns the next exchange recorded for a stream of the method.
+func (p *Player) stream(method string) (Exchange, error) {
+	var streams []Exchange
+	for _, ex := range p.exchanges {
+		if ex.Method == method && (ex.ClientStreams || ex.ServerStreams) {
+			streams = append(streams, ex)
+		}
+	}
+	if len(streams) == 0 {
+		return Exchange{}, status.Errorf(codes.NotFound, "replay: no stream of %s recorded", method)
+	}
+
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	i := p.next[method] % len(streams)
+	p.next[method] = i + 1
+	return streams[i], nil
+}
+
+// applyMetadata fills the grpc.Header and grpc.Trailer call options with the recorded metadata.
+func applyMetadata(opts []grpc.CallOption, header, trailer metadata.MD) {
+	for _, o := range opts {
+		switch o := o.(type) {
+		case grpc.HeaderCallOption:
+			*o.HeaderAddr = header
+		case grpc.TrailerCallOption:
+			*o.TrailerAddr = trailer
+		}
+	}
+}
+
+// playedStream plays the responses of a recorded stream.
+type playedStream struct {
+	ctx      context.Context
+	ex       Exchange
+	received []Message
+	opts     []grpc.CallOption
+	done     bool
+}
+
+func (s *playedStream) Header() (metadata.MD, error) {
+	return s.ex.Header, nil
+}
+
+func (s *playedStream) Trailer() metadata.MD {
+	return s.ex.Trailer
+}
+
+func (s *playedStream) CloseSend() error {
+	return nil
+}
+
+func (s *playedStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *playedStream) SendMsg(interface{}) error {
+	return nil
+}
+
+func (s *playedStream) RecvMsg(m interface{}) error {
+	if len(s.received) != 0 {
+		msg := s.received[0]
+		s.received = s.received[1:]
+		if r := msg.unmarshal(m); r != nil {
+			return status.Error(codes.Internal, r.Error())
+		}
+		if !s.ex.ServerStreams {
+			// calls without server stream end with their response
+			s.end()
+		}
+		return nil
+	}
+
+	s.end()
+	if s.ex.Code != codes.OK {
+		return s.ex.Err()
+	}
+	return io.EOF
+}
+
+// end fills the call options with the recorded metadata once the stream ended.
+func (s *playedStream) end() {
+	if s.done {
+		return
+	}
+	s.done = true
+	applyMetadata(s.opts, s.ex.Header, s.ex.Trailer)
+}
+
+// Replay issues the recorded calls against the server of the connection again, one after the other.
+// It returns the exchanges as they were recorded this time, so they can be compared with the recorded
+// ones (see Exchange.Equal). Streams send all their messages before they receive the responses.
+func Replay(ctx context.Context, conn grpc.ClientConnInterface, exchanges []Exchange) ([]Exchange, error) {
+	replayed := make([]Exchange, 0, len(exchanges))
+	for _, ex := range exchanges {
+		var result Exchange
+		var err error
+		if ex.ClientStreams || ex.ServerStreams {
+			result, err = replayStream(ctx, conn, ex)
+		} else {
+			result, err = replayUnary(ctx, conn, ex)
+		}
+		if err != nil {
+			return replayed, err
+		}
+		replayed = append(replayed, result)
+	}
+	return replayed, nil
+}
+
+func replayUnary(ctx context.Context, conn grpc.ClientConnInterface, ex Exchange) (Exchange, error) {
+	sent := ex.Sent()
+	if len(sent) == 0 {
+		return Exchange{}, errors.New("replay: exchange of " + ex.Method + " without request")
+	}
+	req, err := sent[0].message()
+	if err != nil {
+		return Exchange{}, err
+	}
+	reply, err := newMessage(ex.ReplyType)
+	if err != nil {
+		return Exchange{}, err
+	}
+
+	result := Exchange{Method: ex.Method, ReplyType: ex.ReplyType}
+	callErr := conn.Invoke(ctx, ex.Method, req, reply, grpc.Header(&result.Header), grpc.Trailer(&result.Trailer))
+	if r := result.add(true, req); r != nil {
+		return Exchange{}, r
+	}
+	if callErr == nil {
+		if r := result.add(false, reply); r != nil {
+			return Exchange{}, r
+		}
+	}
+	result.setStatus(callErr)
+	return result, nil
+}
+
+func replayStream(ctx context.Context, conn grpc.ClientConnInterface, ex Exchange) (Exchange, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	result := Exchange{Method: ex.Method, ClientStreams: ex.ClientStreams, ServerStreams: ex.ServerStreams, ReplyType: ex.ReplyType}
+	desc := &grpc.StreamDesc{ClientStreams: ex.ClientStreams, ServerStreams: ex.ServerStreams}
+	stream, err := conn.NewStream(ctx, desc, ex.Method)
+	if err != nil {
+		result.setStatus(err)
+		return result, nil
+	}
+
+	for _, msg := range ex.Sent() {
+		req, r := msg.message()
+		if r != nil {
+			return Exchange{}, r
+		}
+		if r := stream.SendMsg(req); r != nil {
+			// the status is received with the end of the stream
+			break
+		}
+		if r := result.add(true, req); r != nil {
+			return Exchange{}, r
+		}
+	}
+	_ = stream.CloseSend()
+
+	for {
+		reply, r := newMessage(ex.ReplyType)
+		if r != nil {
+			return Exchange{}, r
+		}
+		err = stream.RecvMsg(reply)
+		if err != nil {
+			break
+		}
+		if r := result.add(false, reply); r != nil {
+			return Exchange{}, r
+		}
+		if !ex.ServerStreams {
+			err = nil
+			break
+		}
+	}
+	if errors.Is(err, io.EOF) {
+		err = nil
+	}
+	result.Header, _ = stream.Header()
+	result.Trailer = stream.Trailer()
+	result.setStatus(err)
+	return result, nil
+}
@@ -0,0 +1,158 @@
+package replay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/tehsphinx/nrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+// Recorder writes the calls of a client as exchanges to a writer, one JSON object per line.
+type Recorder struct {
+	m   sync.Mutex
+	enc *json.Encoder
+	err error
+}
+
+// NewRecorder creates a recorder writing to w.
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{enc: json.NewEncoder(w)}
+}
+
+// Err returns the first error that occurred while recording. Calls are not affected by errors
+// of the recorder.
+func (r *Recorder) Err() error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	return r.err
+}
+
+// ClientOptions returns the options adding the client interceptors of the recorder to a client.
+func (r *Recorder) ClientOptions() []nrpc.Option {
+	return []nrpc.Option{
+		nrpc.WithChainUnaryInterceptor(r.UnaryClientInterceptor()),
+		nrpc.WithChainStreamInterceptor(r.StreamClientInterceptor()),
+	}
+}
+
+// UnaryClientInterceptor returns a client interceptor recording unary calls once they completed.
+func (r *Recorder) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		var header, trailer metadata.MD
+		err := invoker(ctx, method, req, reply, cc, append(opts, grpc.Header(&header), grpc.Trailer(&trailer))...)
+
+		ex := Exchange{Method: method, ReplyType: typeName(reply), Header: header, Trailer: trailer}
+		r.fail(ex.add(true, req))
+		if err == nil {
+			r.fail(ex.add(false, reply))
+		}
+		ex.setStatus(err)
+		r.record(ex)
+		return err
+	}
+}
+
+// StreamClientInterceptor returns a client interceptor recording streams once they ended.
+// Streams are recorded when the end of the stream or an error was received.
+func (r *Recorder) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string,
+		streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		ex := Exchange{Method: method, ClientStreams: desc.ClientStreams, ServerStreams: desc.ServerStreams}
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			ex.setStatus(err)
+			r.record(ex)
+			return nil, err
+		}
+		return &recordedStream{ClientStream: stream, rec: r, ex: ex}, nil
+	}
+}
+
+func (r *Recorder) record(ex Exchange) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if err := r.enc.Encode(ex); err != nil && r.err == nil {
+		r.err = err
+	}
+}
+
+func (r *Recorder) fail(err error) {
+	if err == nil {
+		return
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+// recordedStream records the messages of a stream.
+type recordedStream struct {
+	grpc.ClientStream
+	rec *Recorder
+
+	// m guards the exchange, as messages may be sent and received concurrently.
+	m    sync.Mutex
+	ex   Exchange
+	done bool
+}
+
+func (s *recordedStream) SendMsg(m interface{}) error {
+	if err := s.ClientStream.SendMsg(m); err != nil {
+		return err
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.rec.fail(s.ex.add(true, m))
+	return nil
+}
+
+func (s *recordedStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.ex.ReplyType == "" {
+		s.ex.ReplyType = typeName(m)
+	}
+	if err == nil {
+		s.rec.fail(s.ex.add(false, m))
+		if s.ex.ServerStreams {
+			return nil
+		}
+	}
+	if !s.done {
+		// the stream ended: calls without server stream end with their response
+		s.done = true
+		s.ex.Header, _ = s.ClientStream.Header()
+		s.ex.Trailer = s.ClientStream.Trailer()
+		if errors.Is(err, io.EOF) {
+			s.ex.setStatus(nil)
+		} else {
+			s.ex.setStatus(err)
+		}
+		s.rec.record(s.ex)
+	}
+	return err
+}
+
+// typeName returns the full name of the message type.
+func typeName(m interface{}) string {
+	if msg, ok := m.(proto.Message); ok {
+		return string(msg.ProtoReflect().Descriptor().FullName())
+	}
+	return ""
+}
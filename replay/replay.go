@@ -0,0 +1,171 @@
+// Package replay records the calls of nrpc clients and plays them back for debugging and
+// deterministic regression tests. The recorder captures every call with its messages, metadata
+// and status as a line of JSON:
+//
+//	f, err := os.Create("calls.jsonl")
+//	recorder := replay.NewRecorder(f)
+//	client := nrpc.NewClient(pub, sub, recorder.ClientOptions()...)
+//
+// The recorded calls can be issued against a server again with Replay, or a player can serve
+// the recorded responses to a client without any server:
+//
+//	exchanges, err := replay.Load(f)
+//	client := nrpc.NewClient(pub, sub, replay.NewPlayer(exchanges).ClientOptions()...)
+//
+// The messages must be protobuf messages. Their types are looked up in the global registry of
+// the protobuf package when they are played back.
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// marshal encodes the messages deterministically, so recorded requests can be matched byte by byte.
+var marshal = proto.MarshalOptions{Deterministic: true}
+
+// Exchange is a recorded call.
+type Exchange struct {
+	// Method is the full method (/service/method) of the call.
+	Method        string `json:"method"`
+	ClientStreams bool   `json:"clientStreams,omitempty"`
+	ServerStreams bool   `json:"serverStreams,omitempty"`
+	// ReplyType is the full name of the message type of the responses.
+	ReplyType string `json:"replyType"`
+	// Messages are the messages sent and received in the order they were sent and received.
+	Messages []Message   `json:"messages"`
+	Header   metadata.MD `json:"header,omitempty"`
+	Trailer  metadata.MD `json:"trailer,omitempty"`
+	// Code and Error are the status of the call.
+	Code  codes.Code `json:"code"`
+	Error string     `json:"error,omitempty"`
+}
+
+// Message is a message of a recorded call.
+type Message struct {
+	// Sent is set for the messages sent by the client and unset for the messages it received.
+	Sent bool `json:"sent"`
+	// Type is the full name of the message type.
+	Type string `json:"type"`
+	// Data is the message encoded in the protobuf wire format.
+	Data []byte `json:"data"`
+}
+
+// Err returns the status of the call as error.
+func (e Exchange) Err() error {
+	return status.Error(e.Code, e.Error)
+}
+
+// Sent returns the messages sent by the client.
+func (e Exchange) Sent() []Message {
+	return e.filter(true)
+}
+
+// Received returns the messages received by the client.
+func (e Exchange) Received() []Message {
+	return e.filter(false)
+}
+
+func (e Exchange) filter(sent bool) []Message {
+	var msgs []Message
+	for _, msg := range e.Messages {
+		if msg.Sent == sent {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs
+}
+
+// Equal reports whether the exchanges called the same method with the same messages and ended with
+// the same status. The metadata is not compared, as it often carries varying values like IDs.
+func (e Exchange) Equal(other Exchange) bool {
+	if e.Method != other.Method || e.Code != other.Code || e.Error != other.Error || len(e.Messages) != len(other.Messages) {
+		return false
+	}
+	for i, msg := range e.Messages {
+		o := other.Messages[i]
+		if msg.Sent != o.Sent || msg.Type != o.Type || !bytes.Equal(msg.Data, o.Data) {
+			return false
+		}
+	}
+	return true
+}
+
+// setStatus sets the status of the call from the error it ended with.
+func (e *Exchange) setStatus(err error) {
+	st := status.Convert(err)
+	e.Code, e.Error = st.Code(), st.Message()
+}
+
+// add appends the message to the exchange.
+func (e *Exchange) add(sent bool, m interface{}) error {
+	msg, ok := m.(proto.Message)
+	if !ok {
+		return fmt.Errorf("replay: %T of %s is not a protobuf message", m, e.Method)
+	}
+	data, err := marshal.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	e.Messages = append(e.Messages, Message{
+		Sent: sent,
+		Type: string(msg.ProtoReflect().Descriptor().FullName()),
+		Data: data,
+	})
+	return nil
+}
+
+// unmarshal decodes the message into target.
+func (m Message) unmarshal(target interface{}) error {
+	msg, ok := target.(proto.Message)
+	if !ok {
+		return fmt.Errorf("replay: %T is not a protobuf message", target)
+	}
+	return proto.Unmarshal(m.Data, msg)
+}
+
+// message decodes the message into a new message of its type.
+func (m Message) message() (proto.Message, error) {
+	msg, err := newMessage(m.Type)
+	if err != nil {
+		return nil, err
+	}
+	return msg, proto.Unmarshal(m.Data, msg)
+}
+
+// newMessage returns a new message of the type with the full name.
+func newMessage(name string) (proto.Message, error) {
+	typ, err := protoregistry.GlobalTypes.FindMessageByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil, fmt.Errorf("replay: unknown message type %q: %w", name, err)
+	}
+	return typ.New().Interface(), nil
+}
+
+// Load reads the exchanges written by a recorder.
+func Load(r io.Reader) ([]Exchange, error) {
+	var exchanges []Exchange
+	dec := json.NewDecoder(bufio.NewReader(r))
+	for {
+		var ex Exchange
+		err := dec.Decode(&ex)
+		if errors.Is(err, io.EOF) {
+			return exchanges, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("replay: failed to read exchange %d: %w", len(exchanges)+1, err)
+		}
+		exchanges = append(exchanges, ex)
+	}
+}
@@ -0,0 +1,283 @@
+// Package requestlog logs the calls and streams handled by nrpc servers as interceptors. Every call is
+// logged once it is handled, with its method, the identity of the caller, the identity announced by the
+// caller (see nrpc.WithClientIdentity), the duration, the status and the size of the messages:
+//
+//	reqLog := requestlog.New(logger, requestlog.WithPayloads(), requestlog.WithRedactedPaths("card.number"))
+//	server := nrpc.NewServer(pub, sub, reqLog.ServerOptions()...)
+//
+// Successful calls are logged at info level, calls failing because of the caller, e.g. with
+// codes.InvalidArgument or codes.NotFound, at warn level and all other failures at error level.
+//
+// The requests and responses of unary calls are logged as JSON if enabled with WithPayloads. Fields marked
+// with the (nrpc.redact) option of nrpcpb/options.proto and the fields of the paths given with
+// WithRedactedPaths are masked: strings read "[REDACTED]", fields of other types are cleared. The messages
+// of streams are counted, but not logged.
+package requestlog
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/tehsphinx/nrpc"
+	"github.com/tehsphinx/nrpc/auth"
+	"github.com/tehsphinx/nrpc/nrpcpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// redactedValue replaces the value of redacted string fields.
+const redactedValue = "[REDACTED]"
+
+// Option configures the interceptors.
+type Option func(cfg *config)
+
+type config struct {
+	payloads bool
+	paths    []path
+	identity func(ctx context.Context) string
+}
+
+// path is a redacted field given by the names of the fields leading to it.
+type path []protoreflect.Name
+
+// WithPayloads logs the requests and responses of unary calls.
+func WithPayloads() Option {
+	return func(cfg *config) {
+		cfg.payloads = true
+	}
+}
+
+// WithRedactedPaths redacts the fields of the paths in the logged payloads in addition to the fields
+// marked with the (nrpc.redact) option. A path lists the names of the fields leading from the message
+// to the field separated by dots, e.g. "card.number". Paths through repeated fields and maps apply
+// to all of their messages.
+func WithRedactedPaths(paths ...string) Option {
+	return func(cfg *config) {
+		for _, p := range paths {
+			var names path
+			for _, name := range strings.Split(p, ".") {
+				names = append(names, protoreflect.Name(name))
+			}
+			cfg.paths = append(cfg.paths, names)
+		}
+	}
+}
+
+// WithIdentity sets the function returning the identity of the caller logged with the calls. By
+// default, the NKey of callers authenticated with auth.NKeyAuthenticator or the "sub" claim of the
+// claims added by the authenticator are logged (see auth.ClaimsFromContext).
+func WithIdentity(identity func(ctx context.Context) string) Option {
+	return func(cfg *config) {
+		cfg.identity = identity
+	}
+}
+
+// Interceptor logs the calls handled by a server.
+type Interceptor struct {
+	log nrpc.Logger
+	cfg config
+}
+
+// New creates the logging interceptors writing to the logger.
+func New(log nrpc.Logger, opts ...Option) *Interceptor {
+	cfg := config{identity: identity}
+	for _, o := range opts {
+		o(&cfg)
+	}
+	return &Interceptor{log: log, cfg: cfg}
+}
+
+// ServerOptions returns the options adding the interceptors to a server.
+func (i *Interceptor) ServerOptions() []nrpc.Option {
+	return []nrpc.Option{
+		nrpc.ChainUnaryInterceptor(i.UnaryServerInterceptor()),
+		nrpc.ChainStreamInterceptor(i.StreamServerInterceptor()),
+	}
+}
+
+// UnaryServerInterceptor returns a server interceptor logging unary calls.
+func (i *Interceptor) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+
+		fields := i.fields(ctx, info.FullMethod, start, err)
+		fields = append(fields, "requestSize", size(req))
+		if err == nil {
+			fields = append(fields, "responseSize", size(resp))
+		}
+		if i.cfg.payloads {
+			fields = append(fields, "request", i.payload(req))
+			if err == nil {
+				fields = append(fields, "response", i.payload(resp))
+			}
+		}
+		i.logFunc(err)("handled call", fields...)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor returns a server interceptor logging streams once they ended.
+func (i *Interceptor) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		stream := &countingStream{ServerStream: ss}
+		err := handler(srv, stream)
+
+		fields := i.fields(ss.Context(), info.FullMethod, start, err)
+		fields = append(fields,
+			"received", atomic.LoadInt64(&stream.received),
+			"receivedSize", atomic.LoadInt64(&stream.receivedSize),
+			"sent", atomic.LoadInt64(&stream.sent),
+			"sentSize", atomic.LoadInt64(&stream.sentSize),
+		)
+		i.logFunc(err)("handled stream", fields...)
+		return err
+	}
+}
+
+// fields returns the fields logged for calls and streams alike.
+func (i *Interceptor) fields(ctx context.Context, method string, start time.Time, err error) []interface{} {
+	fields := []interface{}{
+		"method", method,
+		"identity", i.cfg.identity(ctx),
+		"duration", time.Since(start),
+		"code", status.Code(err).String(),
+	}
+	if p, ok := nrpc.PeerFromContext(ctx); ok && p.Identity != (nrpc.ClientIdentity{}) {
+		fields = append(fields, "caller", p.Identity.String())
+	}
+	if err != nil {
+		fields = append(fields, "error", status.Convert(err).Message())
+	}
+	return fields
+}
+
+// logFunc returns the function logging a call failing with the error at the level of its status.
+func (i *Interceptor) logFunc(err error) func(msg string, fields ...interface{}) {
+	switch status.Code(err) {
+	case codes.OK:
+		return i.log.Info
+	case codes.Canceled, codes.InvalidArgument, codes.NotFound, codes.AlreadyExists, codes.PermissionDenied,
+		codes.Unauthenticated, codes.ResourceExhausted, codes.FailedPrecondition, codes.Aborted, codes.OutOfRange:
+		return i.log.Warn
+	}
+	return i.log.Error
+}
+
+// payload returns the message as JSON with the redacted fields masked.
+func (i *Interceptor) payload(msg interface{}) string {
+	m, ok := msg.(proto.Message)
+	if !ok {
+		return ""
+	}
+	m = proto.Clone(m)
+	redact(m.ProtoReflect(), i.cfg.paths)
+
+	data, err := protojson.Marshal(m)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// redact masks the fields of the message marked with the redact option or matching one of the paths.
+func redact(msg protoreflect.Message, paths []path) {
+	msg.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		masked, _ := proto.GetExtension(fd.Options(), nrpcpb.E_Redact).(bool)
+		var sub []path
+		for _, p := range paths {
+			if p[0] != fd.Name() {
+				continue
+			}
+			if len(p) == 1 {
+				masked = true
+				continue
+			}
+			sub = append(sub, p[1:])
+		}
+
+		switch {
+		case masked:
+			mask(msg, fd)
+		case fd.Message() == nil:
+		case fd.IsList():
+			list := v.List()
+			for j := 0; j < list.Len(); j++ {
+				redact(list.Get(j).Message(), sub)
+			}
+		case fd.IsMap():
+			if fd.MapValue().Message() == nil {
+				break
+			}
+			v.Map().Range(func(_ protoreflect.MapKey, v protoreflect.Value) bool {
+				redact(v.Message(), sub)
+				return true
+			})
+		default:
+			redact(v.Message(), sub)
+		}
+		return true
+	})
+}
+
+// mask replaces the value of a string field and clears fields of other types.
+func mask(msg protoreflect.Message, fd protoreflect.FieldDescriptor) {
+	if fd.Kind() == protoreflect.StringKind && fd.Cardinality() != protoreflect.Repeated {
+		msg.Set(fd, protoreflect.ValueOfString(redactedValue))
+		return
+	}
+	msg.Clear(fd)
+}
+
+// identity returns the NKey or the subject claim of the authenticated caller.
+func identity(ctx context.Context) string {
+	if key, ok := auth.NKeyFromContext(ctx); ok {
+		return key
+	}
+	if claims, ok := auth.ClaimsFromContext(ctx); ok && len(claims["sub"]) != 0 {
+		return claims["sub"][0]
+	}
+	return ""
+}
+
+func size(msg interface{}) int {
+	if m, ok := msg.(proto.Message); ok {
+		return proto.Size(m)
+	}
+	return 0
+}
+
+// countingStream counts the messages of a stream and their sizes. The counters are accessed atomically,
+// as messages may be sent and received concurrently.
+type countingStream struct {
+	grpc.ServerStream
+	received     int64
+	receivedSize int64
+	sent         int64
+	sentSize     int64
+}
+
+func (s *countingStream) SendMsg(msg interface{}) error {
+	err := s.ServerStream.SendMsg(msg)
+	if err == nil {
+		atomic.AddInt64(&s.sent, 1)
+		atomic.AddInt64(&s.sentSize, int64(size(msg)))
+	}
+	return err
+}
+
+func (s *countingStream) RecvMsg(msg interface{}) error {
+	err := s.ServerStream.RecvMsg(msg)
+	if err == nil {
+		atomic.AddInt64(&s.received, 1)
+		atomic.AddInt64(&s.receivedSize, int64(size(msg)))
+	}
+	return err
+}
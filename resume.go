@@ -0,0 +1,212 @@
+package nrpc
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/tehsphinx/nrpc/pubsub"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	// resumeInterval is the interval in which a stream checks its subscription and repeats
+	// the request to resume the stream while frames are missing.
+	resumeInterval = time.Second
+	// resumeLinger is the time the server keeps serving requests to resume a stream after it ended,
+	// so the client can still request frames lost at the end of the stream.
+	resumeLinger = 5 * time.Second
+
+	// reqSeqField and respSeqField are the field numbers of seq in Request and Response (see message.proto).
+	reqSeqField  protowire.Number = 18
+	respSeqField protowire.Number = 12
+)
+
+// resumer numbers the frames of a stream and keeps the last sent frames to send them again
+// if the other side lost them, e.g. during a short outage of the broker. The receiving side
+// only accepts frames in order and asks the other side to resume the stream after the last
+// frame it received in order if it detects a gap. Resumption is disabled with a size of 0.
+type resumer struct {
+	size int
+
+	// m serializes sending numbered frames, so they are published in order.
+	m       sync.Mutex
+	sentSeq uint64
+	sent    [][]byte
+
+	recvM   sync.Mutex
+	recvSeq uint64
+	highSeq uint64
+}
+
+func newResumer(size int) *resumer {
+	return &resumer{size: size}
+}
+
+func (r *resumer) enabled() bool {
+	return r.size > 0
+}
+
+// send publishes a frame. The frame is numbered, so the other side can drop duplicates and verify the
+// order of the frames, and kept for replay if resumption is enabled. The sequence number is appended to
+// the marshaled frame, which sets the seq field of the frame.
+func (r *resumer) send(payload []byte, seqField protowire.Number, publish func(payload []byte) error) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	seq := r.sentSeq + 1
+	payload = protowire.AppendVarint(protowire.AppendTag(payload, seqField, protowire.VarintType), seq)
+	if err := publish(payload); err != nil {
+		return err
+	}
+
+	r.sentSeq = seq
+	if !r.enabled() {
+		return nil
+	}
+	r.sent = append(r.sent, payload)
+	if len(r.sent) > r.size {
+		r.sent = r.sent[1:]
+	}
+	return nil
+}
+
+// lastSent returns the sequence number of the last sent frame.
+func (r *resumer) lastSent() uint64 {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return r.sentSeq
+}
+
+// replay sends the frames following the acknowledged sequence number again. It fails with
+// codes.Unavailable if the frames are no longer buffered.
+func (r *resumer) replay(ack uint64, publish func(payload []byte) error) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if ack >= r.sentSeq {
+		return nil
+	}
+	first := r.sentSeq - uint64(len(r.sent)) + 1
+	if ack+1 < first {
+		return status.Errorf(codes.Unavailable, "stream cannot be resumed: frames after %d are no longer buffered", ack)
+	}
+	for _, payload := range r.sent[ack+1-first:] {
+		if err := publish(payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// receive checks the sequence number of a received frame. It reports whether the frame is the
+// next in order and should be processed and whether frames are missing. Frames received twice or
+// ahead of a missing frame are dropped. Frames without a sequence number are always processed.
+func (r *resumer) receive(seq uint64) (next, missing bool) {
+	if !r.enabled() || seq == 0 {
+		return true, false
+	}
+
+	r.recvM.Lock()
+	defer r.recvM.Unlock()
+
+	if seq > r.highSeq {
+		r.highSeq = seq
+	}
+	switch {
+	case seq <= r.recvSeq:
+		return false, false
+	case seq > r.recvSeq+1:
+		return false, true
+	}
+	r.recvSeq = seq
+	return true, false
+}
+
+// advertised records the sequence number of the last frame sent by the other side
+// and reports whether frames are missing.
+func (r *resumer) advertised(lastSeq uint64) bool {
+	if !r.enabled() {
+		return false
+	}
+
+	r.recvM.Lock()
+	defer r.recvM.Unlock()
+
+	if lastSeq > r.highSeq {
+		r.highSeq = lastSeq
+	}
+	return r.recvSeq < r.highSeq
+}
+
+// missing reports whether frames sent by the other side are missing.
+func (r *resumer) missing() bool {
+	return r.advertised(0)
+}
+
+// acked returns the sequence number of the last frame received in order.
+func (r *resumer) acked() uint64 {
+	r.recvM.Lock()
+	defer r.recvM.Unlock()
+	return r.recvSeq
+}
+
+// watch closes the subscription once the context is done. It detects the loss of the subscription,
+// e.g. when the broker restarted, by checking it periodically or by the notification of the pubsub
+// layer (see pubsub.LossNotifier). If resumption is enabled, it subscribes again and asks the other
+// side to resume the stream; it also repeats the request to resume the stream while frames are missing.
+// Otherwise the frames sent in the meantime are lost and the stream is aborted with codes.Unavailable.
+// The subscription is kept until teardown returns after the context is done, e.g. to be able to serve
+// requests to resume the stream.
+func (r *resumer) watch(ctx context.Context, sub pubsub.Subscription, teardown func(),
+	subscribe func() (pubsub.Subscription, error), requestResume func(), abort func(err error)) {
+	defer func() {
+		_ = sub.Unsubscribe()
+	}()
+
+	tick := time.NewTicker(resumeInterval)
+	defer tick.Stop()
+
+	chLost := lostChan(sub)
+	for {
+		select {
+		case <-ctx.Done():
+			teardown()
+			return
+		case <-chLost:
+			// the loss is handled once, failed subscriptions are retried with the interval
+			chLost = nil
+		case <-tick.C:
+		}
+
+		if !sub.IsValid() {
+			if !r.enabled() {
+				abort(status.Error(codes.Unavailable, "nrpc: subscription of the stream was lost"))
+				continue
+			}
+			s, err := subscribe()
+			if err != nil {
+				continue
+			}
+			sub = s
+			chLost = lostChan(sub)
+			// frames sent in the meantime are lost: ask for them
+			requestResume()
+			continue
+		}
+		if r.missing() {
+			requestResume()
+		}
+	}
+}
+
+// lostChan returns the channel notifying the loss of the subscription. It returns nil if the
+// subscription does not notify its loss (see pubsub.LossNotifier).
+func lostChan(sub pubsub.Subscription) <-chan struct{} {
+	if notifier, ok := sub.(pubsub.LossNotifier); ok {
+		return notifier.Lost()
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package nrpc
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// RetryPolicy configures the automatic retries of calls. It mirrors the retry policy
+// of the gRPC service config. Unary calls are retried as a whole. Streams are only retried
+// while being established, so a stream is never retried once the server produced data.
+// Servers can push back on retries by attaching errdetails.RetryInfo to the status of the
+// error: the retry delay given there replaces the backoff before the next attempt.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts including the original call.
+	// A value of 1 or less disables retries.
+	MaxAttempts int
+	// InitialBackoff is the maximum backoff before the first retry. The actual
+	// backoff is randomized between 0 and the current maximum backoff.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the maximum backoff between retries.
+	MaxBackoff time.Duration
+	// BackoffMultiplier is the factor the maximum backoff grows by after each attempt.
+	BackoffMultiplier float64
+	// RetryableStatusCodes are the status codes a call is retried on.
+	// It defaults to codes.Unavailable if left empty.
+	RetryableStatusCodes []codes.Code
+}
+
+func (p RetryPolicy) retryable(err error) bool {
+	code := status.Code(err)
+	if len(p.RetryableStatusCodes) == 0 {
+		return code == codes.Unavailable
+	}
+	for _, c := range p.RetryableStatusCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the randomized backoff before the given retry. The first retry is 1.
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	multiplier := p.BackoffMultiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	maxBackoff := float64(p.InitialBackoff) * math.Pow(multiplier, float64(retry-1))
+	if p.MaxBackoff > 0 && maxBackoff > float64(p.MaxBackoff) {
+		maxBackoff = float64(p.MaxBackoff)
+	}
+	if maxBackoff <= 0 {
+		return 0
+	}
+	// nolint: gosec
+	return time.Duration(rand.Int63n(int64(maxBackoff) + 1))
+}
+
+// retryDelay returns the delay before the next attempt the server asked for with errdetails.RetryInfo.
+func retryDelay(err error) (time.Duration, bool) {
+	st, ok := status.FromError(err)
+	if !ok {
+		return 0, false
+	}
+	for _, detail := range st.Details() {
+		if info, isInfo := detail.(*errdetails.RetryInfo); isInfo && info.RetryDelay != nil {
+			return info.RetryDelay.AsDuration(), true
+		}
+	}
+	return 0, false
+}
+
+// retry calls fn until it succeeds, fails with an error not retryable by the policy,
+// the attempts are used up or the context is done. The errors returned by fn are expected
+// to be status errors.
+func retry(ctx context.Context, policy RetryPolicy, fn func() error) error {
+	err := fn()
+	for attempt := 1; err != nil && attempt < policy.MaxAttempts && policy.retryable(err); attempt++ {
+		delay, ok := retryDelay(err)
+		if !ok {
+			delay = policy.backoff(attempt)
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+
+		err = fn()
+	}
+	return err
+}
+
+// retryPolicies holds the retry policies configured for methods, services and the default.
+type retryPolicies map[string]RetryPolicy
+
+// get returns the retry policy of the full method (/service/method).
+func (p retryPolicies) get(method string) RetryPolicy {
+	for _, key := range policyKeys(method) {
+		if policy, ok := p[key]; ok {
+			return policy
+		}
+	}
+	return RetryPolicy{}
+}
+
+// policyKeys returns the keys a policy of the full method is looked up with in order of precedence:
+// a policy configured for the method takes precedence over one configured for the service and the default.
+func policyKeys(method string) []string {
+	return []string{method, serviceName(method), ""}
+}
+
+// Retry returns a CallOption that sets the retry policy of the call. It overwrites
+// the policies configured with the WithRetryPolicy option of the client.
+// Pass an empty RetryPolicy to disable retries for the call.
+func Retry(policy RetryPolicy) grpc.CallOption {
+	return callOption{apply: func(opt *callOptions) {
+		opt.retry = policy
+	}}
+}
@@ -0,0 +1,57 @@
+package nrpc
+
+import (
+	"context"
+
+	"google.golang.org/grpc/status"
+)
+
+// ContextSender is implemented by the client and server streams of nrpc. SendMsgContext sends a message like
+// SendMsg, but gives up waiting for the stream to be able to send it when the context is done.
+type ContextSender interface {
+	SendMsgContext(ctx context.Context, m interface{}) error
+}
+
+// SendMsgContext sends a message on the stream, bounding the send with the context. If the context is done
+// before the message could be handed to the transport, e.g. while waiting for credit of the receiving side
+// (see WithFlowControl) or for the pacing interval (see WithSendPacing), the message is not sent and the
+// status of the context error is returned. Unlike the cancellation of the context of the stream, this does
+// not abort the stream: the following messages can be sent as usual.
+//
+// Streams not implementing ContextSender, e.g. the ones wrapped by interceptors, send the message with
+// SendMsg once the context was checked.
+func SendMsgContext(ctx context.Context, stream interface{ SendMsg(m interface{}) error }, m interface{}) error {
+	if sender, ok := stream.(ContextSender); ok {
+		return sender.SendMsgContext(ctx, m)
+	}
+	if r := ctx.Err(); r != nil {
+		return status.FromContextError(r).Err()
+	}
+	return stream.SendMsg(m)
+}
+
+// withSendContext returns a context of the stream that is also done when the context of the send is done.
+func withSendContext(streamCtx, sendCtx context.Context) (context.Context, context.CancelFunc) {
+	if sendCtx == streamCtx || sendCtx.Done() == nil {
+		return streamCtx, func() {}
+	}
+
+	ctx, cancel := context.WithCancel(streamCtx)
+	go func() {
+		select {
+		case <-sendCtx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// sendContextErr returns the status of the error of the context of a send if it ended the send while
+// the stream is still alive. It returns nil otherwise.
+func sendContextErr(streamCtx, sendCtx context.Context) error {
+	if streamCtx.Err() != nil || sendCtx.Err() == nil {
+		return nil
+	}
+	return status.FromContextError(sendCtx.Err()).Err()
+}
@@ -0,0 +1,100 @@
+package nrpc
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// SendPacingPolicy configures how server streams send messages (see WithSendPacing).
+type SendPacingPolicy struct {
+	// Interval is the minimum time between two messages sent by the handler. SendMsg waits for the
+	// interval to pass since the previous message was sent. Pacing is disabled if it is 0.
+	Interval time.Duration
+	// WriteDeadline bounds the time SendMsg blocks waiting for credit of the client (see WithFlowControl)
+	// and for the broker to accept the message. When it passes, SendMsg fails and the stream is aborted
+	// with codes.DeadlineExceeded. The waiting time is unbounded if it is 0.
+	WriteDeadline time.Duration
+}
+
+// writeContext returns the context bounding a single write of the stream.
+func (p SendPacingPolicy) writeContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if p.WriteDeadline <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, p.WriteDeadline)
+}
+
+// sendPacingPolicies holds the send pacing policies configured for methods, services and the default.
+type sendPacingPolicies map[string]SendPacingPolicy
+
+// get returns the send pacing policy of the full method (/service/method).
+func (p sendPacingPolicies) get(method string) SendPacingPolicy {
+	for _, key := range policyKeys(method) {
+		if policy, ok := p[key]; ok {
+			return policy
+		}
+	}
+	return SendPacingPolicy{}
+}
+
+// pace waits for the pacing interval to pass since the previous message was sent. It gives up without
+// aborting the stream if the context of the send is done first.
+func (s *serverStream) pace(sendCtx context.Context) error {
+	if s.cfg.sendPacing.Interval <= 0 || s.lastSent.IsZero() {
+		return nil
+	}
+	wait := s.cfg.sendPacing.Interval - time.Since(s.lastSent)
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-s.ctx.Done():
+		return s.aborted.err(s.ctx)
+	case <-sendCtx.Done():
+		if r := sendContextErr(s.ctx, sendCtx); r != nil {
+			return r
+		}
+		return s.aborted.err(s.ctx)
+	case <-timer.C:
+		return nil
+	}
+}
+
+// write sends the message within the write context. The stream is aborted if the write deadline
+// passes before the message was handed to the broker.
+func (s *serverStream) write(ctx context.Context, m interface{}) error {
+	defer func() { s.lastSent = time.Now() }()
+
+	if s.cfg.sendPacing.WriteDeadline <= 0 {
+		return s.sendMsg(m, false, false)
+	}
+
+	chDone := make(chan error, 1)
+	go func() {
+		chDone <- s.sendMsg(m, false, false)
+	}()
+
+	select {
+	case err := <-chDone:
+		return err
+	case <-ctx.Done():
+		s.writeFailed(ctx)
+		return s.aborted.err(s.ctx)
+	}
+}
+
+// writeFailed aborts the stream after a write failed because its context is done.
+func (s *serverStream) writeFailed(ctx context.Context) {
+	if s.ctx.Err() == nil && ctx.Err() == context.DeadlineExceeded {
+		s.abort(status.Errorf(codes.DeadlineExceeded, "nrpc: write deadline of %s passed", s.cfg.sendPacing.WriteDeadline))
+		return
+	}
+	s.cancel()
+}
@@ -3,15 +3,17 @@ package nrpc
 import (
 	"context"
 	"fmt"
+	"reflect"
+	"sync"
 	"time"
 
 	"github.com/tehsphinx/nrpc/pubsub"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/reflection"
 	"google.golang.org/grpc/stats"
-	"google.golang.org/grpc/status"
-	"google.golang.org/protobuf/proto"
 )
 
 const checkSubsInterval = 5 * time.Minute
@@ -21,14 +23,55 @@ type Server struct {
 	pub pubsub.Publisher
 	sub pubsub.Subscriber
 	log Logger
+	cfg streamConfig
+	// transport names the transport of the subscriber (see pubsub.Namer).
+	transport string
 
 	subs     *subscriptions
+	calls    *inflightCalls
+	streams  *activeStreams
 	shutdown context.CancelFunc
 
+	drainTimeout time.Duration
+	limits       concurrencyLimits
+	limiter      *limiter
+
 	unaryInt     grpc.UnaryServerInterceptor
 	streamInt    grpc.StreamServerInterceptor
 	statsHandler stats.Handler
 	serviceInfo  map[string]grpc.ServiceInfo
+	health       *health.Server
+
+	subjects         SubjectMapper
+	multiTenant      bool
+	queueGroups      queueGroupPolicies
+	id               string
+	announceInterval time.Duration
+	idempotency      *idempotency
+	// auth and authz authenticate and authorize unary calls before their idempotency key is looked up.
+	// Streams are authenticated and authorized by an interceptor.
+	auth  Authenticator
+	authz Authorizer
+
+	// methods are the subjects of the methods registered on the server.
+	methods map[string]struct{}
+	// events are the subjects and queue groups of the event handlers registered on the server.
+	events         map[string]struct{}
+	unknownHandler grpc.StreamHandler
+	unknownLimiter *limiter
+	// unknownPrefix is the number of segments preceding the method in the subjects received by the unknown handler.
+	unknownPrefix int
+
+	// pager splits large unary responses into pages if enabled (see WithResponsePaging).
+	pageSize   int
+	pagedBytes int
+	pager      *pager
+	// pushes holds the streams accepting pushed messages (see AcceptPush).
+	pushes *pushRegistry
+
+	m        sync.Mutex
+	serving  bool
+	draining bool
 }
 
 var _ grpc.ServiceRegistrar = (*Server)(nil)
@@ -36,6 +79,9 @@ var _ reflection.ServiceInfoProvider = (*Server)(nil)
 
 // Run starts the server by subscribing to the registered endpoints.
 func (s *Server) Run(ctx context.Context) error {
+	s.startServing()
+	s.registerProbe()
+	s.registerPush()
 	if r := s.subs.subscribe(s.sub); r != nil {
 		return r
 	}
@@ -46,11 +92,12 @@ func (s *Server) Run(ctx context.Context) error {
 	shutdownCtx, shutdown := context.WithCancel(ctx)
 	s.shutdown = shutdown
 
+	go s.announceLoop(shutdownCtx)
 	go func() {
 		defer shutdown()
 
 		if err := s.subs.watchSubscriptions(shutdownCtx); err != nil {
-			s.log.Errorf("subscriptions watcher returned with error: %v", err)
+			s.log.Error("subscriptions watcher returned with error", "error", err)
 		}
 	}()
 	return nil
@@ -59,6 +106,9 @@ func (s *Server) Run(ctx context.Context) error {
 // Listen starts the server by subscribing to the registered endpoints
 // and blocks until closed or an error occurs.
 func (s *Server) Listen(ctx context.Context) error {
+	s.startServing()
+	s.registerProbe()
+	s.registerPush()
 	if r := s.subs.subscribe(s.sub); r != nil {
 		return r
 	}
@@ -70,39 +120,125 @@ func (s *Server) Listen(ctx context.Context) error {
 	s.shutdown = shutdown
 	defer shutdown()
 
+	go s.announceLoop(shutdownCtx)
+
 	return s.subs.watchSubscriptions(shutdownCtx)
 }
 
 // Stop signals the Service to shut down. Stopping is done when the Listen function returns.
 func (s *Server) Stop() {
+	s.health.Shutdown()
 	s.shutdown()
 }
 
-// RegisterService implements the grpc.ServiceRegistrar interface.
+// Health returns the grpc.health.v1 health service registered on the server. Every registered
+// service as well as the server as a whole (empty service name) is reported as serving. Use it to
+// change the serving status, e.g. depending on the availability of a database.
+func (s *Server) Health() *health.Server {
+	return s.health
+}
+
+// GracefulStop stops the server from accepting new calls and streams and blocks until all
+// in-flight calls and open streams finished or the drain timeout (see WithDrainTimeout) passed.
+// Calls and streams still in progress after the drain timeout are canceled before the
+// server is stopped.
+func (s *Server) GracefulStop() {
+	s.health.Shutdown()
+	s.m.Lock()
+	s.draining = true
+	s.m.Unlock()
+	s.announce(true)
+	s.subs.closeEndpoints()
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.drainTimeout)
+	defer cancel()
+
+	if r := s.calls.wait(ctx); r != nil {
+		s.log.Warn("drain timeout passed: canceling the remaining calls", "timeout", s.drainTimeout)
+		s.calls.cancelAll()
+	}
+	s.Stop()
+}
+
+func (s *Server) startServing() {
+	s.m.Lock()
+	s.serving = true
+	s.m.Unlock()
+}
+
+// RegisterService implements the grpc.ServiceRegistrar interface. It is called by the
+// Register<Service>Server functions of code generated by protoc-gen-go-grpc. The calls are
+// dispatched to the handlers of the service description. Like the grpc server, it panics
+// if impl does not implement the handler type of the service, if the service is registered
+// twice or if it is called after the server started.
 func (s *Server) RegisterService(desc *grpc.ServiceDesc, impl interface{}) {
-	prefix := "nrpc." + desc.ServiceName
+	s.checkService(desc, impl)
 
 	for _, mDesc := range desc.Methods {
-		subject := prefix + "." + mDesc.MethodName
-
-		s.subs.RegisterSubscription(subscription{
-			endpoint: subject,
-			queue:    desc.ServiceName,
-			handler:  s.handleMethod(mDesc, impl),
-		})
+		fullMethod := "/" + desc.ServiceName + "/" + mDesc.MethodName
+		handler := s.recoverHandler(fullMethod, s.handleMethod(fullMethod, mDesc, impl, newLimiter(s.limits.get(fullMethod))))
+		handler = s.cfg.tap.handler(fullMethod, FrameData, handler)
+		s.registerMethod(desc.ServiceName, fullMethod, handler)
+		s.registerBroadcast(fullMethod, handler)
 	}
 
 	for _, sDesc := range desc.Streams {
-		subject := prefix + "." + sDesc.StreamName
+		fullMethod := "/" + desc.ServiceName + "/" + sDesc.StreamName
+		handler := s.recoverHandler(fullMethod, s.handleStream(fullMethod, sDesc, impl, newLimiter(s.limits.get(fullMethod))))
+		s.registerMethod(desc.ServiceName, fullMethod, s.cfg.tap.handler(fullMethod, FrameHandshake, handler))
+	}
+
+	// all instances of the service listen for cancellations
+	s.subs.RegisterSubscription(subscription{
+		endpoint: s.servedSubjects().MapSubject(cancelSubj(desc.ServiceName)),
+		handler:  s.cfg.tap.handler("", FrameData, s.handleCancel),
+		control:  true,
+	})
+
+	s.registerServiceInfo(desc)
+	s.health.SetServingStatus(desc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+}
 
+// registerMethod subscribes the handler to the subject of the method in the queue groups of the method
+// (see WithQueueGroups), the queue group of the service by default. Servers announcing themselves
+// additionally serve the method on the subject of their instance.
+func (s *Server) registerMethod(service, fullMethod string, handler pubsub.Handler) {
+	s.methods[methodSubj(fullMethod)] = struct{}{}
+	subj := s.servedSubjects().MapSubject(methodSubj(fullMethod))
+	for _, queue := range s.queueGroups.get(fullMethod, service) {
 		s.subs.RegisterSubscription(subscription{
-			endpoint: subject,
-			queue:    desc.ServiceName,
-			handler:  s.handleStream(sDesc, impl),
+			endpoint: subj,
+			queue:    queue,
+			handler:  s.tenantHandler(subj, handler),
 		})
 	}
+	if s.announceInterval > 0 {
+		instanceSubj := s.servedSubjects().MapSubject(callSubj(fullMethod, s.id))
+		s.subs.RegisterSubscription(subscription{
+			endpoint: instanceSubj,
+			handler:  s.tenantHandler(instanceSubj, handler),
+		})
+	}
+}
 
-	s.registerServiceInfo(desc)
+func (s *Server) checkService(desc *grpc.ServiceDesc, impl interface{}) {
+	if impl != nil {
+		ht := reflect.TypeOf(desc.HandlerType).Elem()
+		st := reflect.TypeOf(impl)
+		if !st.Implements(ht) {
+			panic(fmt.Sprintf("nrpc: Server.RegisterService found the handler of type %v that does not satisfy %v", st, ht))
+		}
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.serving {
+		panic(fmt.Sprintf("nrpc: Server.RegisterService of %q after the server started", desc.ServiceName))
+	}
+	if _, ok := s.serviceInfo[desc.ServiceName]; ok {
+		panic(fmt.Sprintf("nrpc: Server.RegisterService found duplicate service registration for %q", desc.ServiceName))
+	}
 }
 
 func (s *Server) registerServiceInfo(desc *grpc.ServiceDesc) {
@@ -134,71 +270,148 @@ func (s *Server) GetServiceInfo() map[string]grpc.ServiceInfo {
 	return s.serviceInfo
 }
 
-func (s *Server) handleMethod(desc grpc.MethodDesc, impl interface{}) pubsub.Handler {
+// acquire waits for a free slot of the method and of the server. It returns a function to release the slots again.
+func (s *Server) acquire(ctx context.Context, methodLimiter *limiter) (func(), error) {
+	releaseMethod, err := methodLimiter.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	release, err := s.limiter.acquire(ctx)
+	if err != nil {
+		releaseMethod()
+		return nil, err
+	}
+	return func() {
+		release()
+		releaseMethod()
+	}, nil
+}
+
+func (s *Server) handleMethod(fullMethod string, desc grpc.MethodDesc, impl interface{}, lim *limiter) pubsub.Handler {
 	return func(ctx context.Context, msg pubsub.Replier) {
 		start := time.Now()
 
-		transport := newServerTransport(desc.MethodName)
+		transport := newServerTransport(fullMethod)
 		ctx = grpc.NewContextWithServerTransportStream(ctx, transport)
-		ctx = s.statsHandler.TagRPC(ctx, &stats.RPCTagInfo{
-			FullMethodName: desc.MethodName,
-		})
-
-		s.statsHandler.HandleRPC(ctx, &stats.Begin{BeginTime: start})
 
 		req, err := unmarshalReq(msg.Data())
 		if err != nil {
 			s.respondErr(msg, err)
-			s.statsEndRPC(ctx, start, err)
 			return
 		}
+		ctx = newPeerContext(ctx, &Peer{
+			ReplySubject: replySubject(msg),
+			ClientID:     req.ClientId,
+			Transport:    s.transport,
+			Identity:     announcedIdentity(req),
+		})
+		if req.OneWay {
+			msg = discardReplies{Replier: msg}
+		}
 		reqHeader := toMD(req.Header)
 
-		s.statsHandler.HandleRPC(ctx, &stats.InHeader{Header: reqHeader, FullMethod: desc.MethodName, WireLength: len(msg.Data())})
+		// like grpc, the call is tagged with the incoming metadata, so handlers can extract e.g. trace contexts
+		ctx = metadata.NewIncomingContext(ctx, reqHeader)
+		ctx = s.statsHandler.TagRPC(ctx, &stats.RPCTagInfo{
+			FullMethodName: fullMethod,
+		})
+		s.statsHandler.HandleRPC(ctx, &stats.Begin{BeginTime: start})
+		s.statsHandler.HandleRPC(ctx, &stats.InHeader{Header: reqHeader, FullMethod: fullMethod, WireLength: len(msg.Data())})
 		// s.statsHandler.HandleRPC(ctx, &stats.InTrailer{}) // no trailers
 
-		ctx = metadata.NewIncomingContext(ctx, reqHeader)
-		ctx, cancel := contextTimeout(ctx, req.Timeout)
+		ctx, cancel := contextWithTimeout(ctx, req.Timeout, s.cfg.handlerTimeouts.get(fullMethod))
 		defer cancel()
+		defer s.calls.add(req.Id, cancel)()
+
+		// stored responses must only be replayed to the caller that may invoke the method
+		authCtx, err := authorize(ctx, s.auth, s.authz, fullMethod)
+		if err != nil {
+			s.respondErr(msg, err)
+			s.statsEndRPC(ctx, start, err)
+			return
+		}
+		ctx = authCtx
+
+		var idemCall *idempotentCall
+		if s.idempotency != nil && req.IdempotencyKey != "" {
+			stored, call, r := s.idempotency.begin(ctx, fullMethod, req.IdempotencyKey)
+			if r != nil {
+				s.respondErr(msg, r)
+				s.statsEndRPC(ctx, start, r)
+				return
+			}
+			if stored != nil {
+				s.replyPaged(ctx, msg, stored, req.Version)
+				s.statsEndRPC(ctx, start, nil)
+				return
+			}
+			defer call.end()
+			idemCall = call
+		}
+
+		release, err := s.acquire(ctx, lim)
+		if err != nil {
+			s.respondErr(msg, err)
+			s.statsEndRPC(ctx, start, err)
+			return
+		}
+		defer release()
+
+		codec, err := getCodec(req.Codec)
+		if err != nil {
+			s.respondErr(msg, err)
+			s.statsEndRPC(ctx, start, err)
+			return
+		}
 
 		dec := func(target interface{}) error {
-			//nolint:forcetypeassert
-			r := proto.Unmarshal(req.Data, target.(proto.Message))
+			data, r := decode(codec, req.Compressor, req.Data, target, s.cfg.maxRecvMsgSize)
+			if r != nil {
+				return r
+			}
 
-			s.statsHandler.HandleRPC(ctx, &stats.InPayload{Payload: target, Data: req.Data, Length: len(req.Data),
+			s.statsHandler.HandleRPC(ctx, &stats.InPayload{Payload: target, Data: data, Length: len(data),
 				WireLength: len(msg.Data()), RecvTime: start})
-			return r
+			return nil
 		}
 
 		resp, err := func() (_ interface{}, err error) {
-			defer func() {
-				if r := recover(); r != nil {
-					err = fmt.Errorf("server.handleMethod: panic recovered: %v", r)
-				}
-			}()
+			defer s.recoverPanic(fullMethod, &err)
 
-			return desc.Handler(impl, ctx, dec, s.unaryInt)
+			resp, err := desc.Handler(impl, ctx, dec, s.unaryInt)
+			return resp, deadlineErr(ctx, err)
 		}()
+		header, trailer := transport.metadata()
 		if err != nil {
-			s.respondErr(msg, err)
+			s.respondErrMD(msg, err, header, trailer)
 			s.statsEndRPC(ctx, start, err)
 			return
 		}
 
-		innerPayload, payload, err := marshalUnaryRespMsg(msg.Subject(), resp.(proto.Message), transport.header, transport.trailer, true, false)
+		version := negotiateVersion(s.cfg.wireVersion, req.Version)
+		innerPayload, payload, err := marshalUnaryRespMsg(msg.Subject(), codec, resp, &Response{
+			Header:     fromMD(header),
+			Trailer:    fromMD(trailer),
+			Eos:        true,
+			Compressor: req.Compressor,
+			Version:    version,
+		}, s.cfg.maxSendMsgSize)
 		if err != nil {
 			s.respondErr(msg, err)
 			s.statsEndRPC(ctx, start, err)
 			return
 		}
 
+		if idemCall != nil {
+			idemCall.succeed(payload)
+		}
 		sent := time.Now()
-		s.reply(msg, payload)
+		s.replyPaged(ctx, msg, payload, version)
 
-		s.statsHandler.HandleRPC(ctx, &stats.OutHeader{Header: transport.header, FullMethod: desc.MethodName})
+		s.statsHandler.HandleRPC(ctx, &stats.OutHeader{Header: header, FullMethod: fullMethod})
 		s.statsHandler.HandleRPC(ctx, &stats.OutPayload{Payload: resp, Data: innerPayload, Length: len(innerPayload),
 			WireLength: len(payload), SentTime: sent})
-		s.statsHandler.HandleRPC(ctx, &stats.OutTrailer{Trailer: transport.trailer})
+		s.statsHandler.HandleRPC(ctx, &stats.OutTrailer{Trailer: trailer})
 
 		s.statsEndRPC(ctx, start, nil)
 	}
@@ -209,18 +422,18 @@ func (s *Server) statsEndRPC(ctx context.Context, start time.Time, err error) {
 }
 
 func (s *Server) respondErr(msg pubsub.Replier, resErr error) {
-	errStatus, ok := status.FromError(resErr)
-	if !ok {
-		errStatus = status.FromContextError(resErr)
-		// updates the resErr to the status error. Needed if below TODO is implemented
-		// resErr = errStatus.Err()
-	}
+	s.respondErrMD(msg, resErr, nil, nil)
+}
+
+// respondErrMD answers a unary call with the error along with the header and trailer set by the handler.
+func (s *Server) respondErrMD(msg pubsub.Replier, resErr error, header, trailer metadata.MD) {
+	errStatus := toStatus(resErr)
 
 	// TODO: inject external error handler for logging, tracing, etc.
 
-	payload, err := marshalProto(msg.Subject(), errStatus.Proto(), MessageType_Error)
+	payload, err := marshalErrMsg(msg.Subject(), errStatus.Proto(), header, trailer)
 	if err != nil {
-		s.log.Errorf("Failed to marshal error response: %v", err)
+		s.log.Error("failed to marshal error response", "subject", msg.Subject(), "error", err)
 		return
 	}
 
@@ -228,36 +441,58 @@ func (s *Server) respondErr(msg pubsub.Replier, resErr error) {
 }
 
 func (s *Server) reply(msg pubsub.Replier, payload []byte) {
-	s.log.Infof("Reply: subject => %v", msg.Subject())
+	if debugEnabled(s.log) {
+		s.log.Debug("reply", "subject", msg.Subject())
+	}
 	if r := msg.Reply(pubsub.Reply{
 		Data: payload,
 	}); r != nil {
-		s.log.Errorf("Failed to reply: %v", r)
+		s.log.Error("failed to reply", "subject", msg.Subject(), "error", r)
 		return
 	}
 }
 
-func (s *Server) handleStream(desc grpc.StreamDesc, impl interface{}) pubsub.Handler {
+func (s *Server) handleStream(fullMethod string, desc grpc.StreamDesc, impl interface{}, lim *limiter) pubsub.Handler {
 	return func(ctx context.Context, msg pubsub.Replier) {
-		s.statsHandler.TagRPC(ctx, &stats.RPCTagInfo{FullMethodName: desc.StreamName})
+		// the client stops waiting for the stream to be accepted after the connect timeout
+		waitCtx, cancel := context.WithTimeout(ctx, s.cfg.connectTimeout)
+		release, err := s.acquire(waitCtx, lim)
+		cancel()
+		if err != nil {
+			s.respondErr(msg, err)
+			return
+		}
 
-		stream := newServerStream(s.pub, s.sub, s.statsHandler, s.log, desc)
-		if r := stream.Subscribe(ctx, msg.Data()); r != nil {
-			s.respondErr(msg, fmt.Errorf("failed to subscribe: %w", r))
+		stream := newServerStream(s.pub, s.sub, s.statsHandler, s.log, s.cfg.forMethod(fullMethod), fullMethod, desc)
+		subjects := s.streamSubjects(ctx)
+		if r := stream.Subscribe(context.WithValue(ctx, pushKey{}, s.pushes), msg.Data(), Peer{Transport: s.transport}, subjects); r != nil {
+			release()
+			s.respondErr(msg, r)
+			return
+		}
+		handshake, err := marshalHandshake(msg.Subject(), s.cfg.window, stream.version)
+		if err != nil {
+			stream.cancel()
+			release()
+			s.respondErr(msg, err)
 			return
 		}
+		// streams are identified by their request subject for cancellation
+		removeCall := s.calls.add(stream.reqSubj, stream.cancel)
+		removeStream := s.streams.add(stream)
 		go func() {
+			defer removeCall()
+			defer removeStream()
+			defer release()
+
 			if r := func() (err error) {
-				defer func() {
-					if r := recover(); r != nil {
-						err = fmt.Errorf("server.handleStream: panic recovered: %v", r)
-					}
-				}()
+				// a panic mid-stream closes the stream with codes.Internal
+				defer s.recoverPanic(fullMethod, &err)
 
 				if s.streamInt != nil {
 					// pass the call through the stream interceptor
 					info := &grpc.StreamServerInfo{
-						FullMethod:     desc.StreamName,
+						FullMethod:     fullMethod,
 						IsClientStream: desc.ClientStreams,
 						IsServerStream: desc.ServerStreams,
 					}
@@ -265,8 +500,8 @@ func (s *Server) handleStream(desc grpc.StreamDesc, impl interface{}) pubsub.Han
 				}
 
 				return desc.Handler(impl, stream)
-			}(); r != nil {
-				stream.CloseWithError(r)
+			}(); r != nil || stream.ctx.Err() == context.DeadlineExceeded {
+				stream.CloseWithError(deadlineErr(stream.ctx, r))
 				return
 			}
 
@@ -274,17 +509,10 @@ func (s *Server) handleStream(desc grpc.StreamDesc, impl interface{}) pubsub.Han
 		}()
 
 		if r := msg.Reply(pubsub.Reply{
-			Data: nil,
+			Data: handshake,
 		}); r != nil {
 			s.respondErr(msg, fmt.Errorf("failed to reply: %w", r))
 			return
 		}
 	}
 }
-
-func contextTimeout(ctx context.Context, timeout int64) (context.Context, context.CancelFunc) {
-	if timeout == 0 {
-		return ctx, func() {}
-	}
-	return context.WithTimeout(ctx, time.Duration(timeout))
-}
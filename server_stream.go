@@ -2,44 +2,99 @@ package nrpc
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/tehsphinx/nrpc/pubsub"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	encproto "google.golang.org/grpc/encoding/proto"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/stats"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
 )
 
-func newServerStream(pub pubsub.Publisher, sub pubsub.Subscriber, statsHandler stats.Handler, log Logger, desc grpc.StreamDesc) *serverStream {
-	return &serverStream{
+func newServerStream(pub pubsub.Publisher, sub pubsub.Subscriber, statsHandler stats.Handler, log Logger, cfg streamConfig,
+	fullMethod string, desc grpc.StreamDesc) *serverStream {
+	recvWin := &recvWindow{size: cfg.window}
+	s := &serverStream{
 		pub:          pub,
 		sub:          sub,
 		statsHandler: statsHandler,
 		log:          log,
+		cfg:          cfg,
+		fullMethod:   fullMethod,
 		desc:         desc,
-		chRecv:       make(chan *recvMsg, 1),
+		chRecv:       make(chan *recvMsg, cfg.recvBuffer.bufferSize(recvWin)),
+		sendWin:      newSendWindow(),
+		recvWin:      recvWin,
+		chunker:      &chunker{size: cfg.chunkSize},
+		chunks:       newReassembler(),
+		dedup:        newDedup(),
+		keepalive:    newKeepalive(0, 0),
+		resume:       newResumer(0),
+		order:        newReorderer(OrderingPolicy{}),
+		activity:     newStreamActivity(),
 		start:        time.Now(),
 	}
+	s.batch = newBatcher(cfg.batchSize, cfg.batchDelay, s.publishBatch, s.abort)
+	return s
 }
 
 type serverStream struct {
+	// sentSeq numbers the published messages. It is accessed atomically and kept
+	// first in the struct to guarantee 64-bit alignment.
+	sentSeq uint64
+
 	pub          pubsub.Publisher
 	sub          pubsub.Subscriber
 	statsHandler stats.Handler
 	log          Logger
+	cfg          streamConfig
+	fullMethod   string
 	desc         grpc.StreamDesc
 
-	ctx         context.Context
-	cancel      context.CancelFunc
-	respSubj    string
-	chRecv      chan *recvMsg
+	ctx      context.Context
+	cancel   context.CancelFunc
+	reqSubj  string
+	respSubj string
+	// streamID identifies the stream on a response subject the client shares among several streams.
+	streamID string
+	// frameKey prefixes the IDs of the published frames. It is unique per stream.
+	frameKey   string
+	compressor string
+	codec      Codec
+	chRecv     chan *recvMsg
+	sendWin    *sendWindow
+	recvWin    *recvWindow
+	chunker    *chunker
+	chunks     *reassembler
+	dedup      *dedup
+	keepalive  *keepalive
+	resume     *resumer
+	order      *reorderer
+	batch      *batcher
+	aborted    abortErr
+	activity   *streamActivity
+	start      time.Time
+	// version is the version of the envelope negotiated with the client.
+	version uint32
+	// lastSent is the time the handler sent the previous message. It paces the messages.
+	lastSent time.Time
+
+	// md guards the metadata, as grpc.SetTrailer may be called from any goroutine.
+	md          sync.Mutex
 	sendHeader  metadata.MD
 	sendTrailer metadata.MD
-	start       time.Time
+	headerSent  bool
 }
 
 // SetHeader sets the header metadata. It may be called multiple times.
@@ -49,13 +104,13 @@ type serverStream struct {
 //   - The first response is sent out;
 //   - An RPC status is sent out (error or success).
 func (s *serverStream) SetHeader(md metadata.MD) error {
-	if s.sendHeader == nil {
-		s.sendHeader = md
-		return nil
-	}
-	for k, vals := range md {
-		s.sendHeader[k] = append(s.sendHeader[k], vals...)
+	s.md.Lock()
+	defer s.md.Unlock()
+
+	if s.headerSent {
+		return errHeaderSent
 	}
+	s.sendHeader = metadata.Join(s.sendHeader, md)
 	return nil
 }
 
@@ -72,13 +127,27 @@ func (s *serverStream) SendHeader(md metadata.MD) error {
 // SetTrailer sets the trailer metadata which will be sent with the RPC status.
 // When called more than once, all the provided metadata will be merged.
 func (s *serverStream) SetTrailer(md metadata.MD) {
-	if s.sendTrailer == nil {
-		s.sendTrailer = md
-		return
+	s.md.Lock()
+	defer s.md.Unlock()
+
+	s.sendTrailer = metadata.Join(s.sendTrailer, md)
+}
+
+// metadata returns the header if it was not sent yet and the trailer if the stream ends.
+// The header is marked as sent.
+func (s *serverStream) metadata(eos bool) (metadata.MD, metadata.MD) {
+	s.md.Lock()
+	defer s.md.Unlock()
+
+	var header, trailer metadata.MD
+	if !s.headerSent {
+		header = s.sendHeader
+		s.headerSent = true
 	}
-	for k, vals := range md {
-		s.sendTrailer[k] = append(s.sendTrailer[k], vals...)
+	if eos {
+		trailer = s.sendTrailer
 	}
+	return header, trailer
 }
 
 // Context returns the context for this stream.
@@ -104,9 +173,44 @@ func (s *serverStream) Context() context.Context {
 // calling RecvMsg on the same stream at the same time, but it is not safe
 // to call SendMsg on the same stream in different goroutines.
 func (s *serverStream) SendMsg(m interface{}) error {
-	// nolint: forcetypeassert
-	args := m.(proto.Message)
-	return s.sendMsg(args, false, false)
+	return s.SendMsgContext(s.ctx, m)
+}
+
+// SendMsgContext sends a message like SendMsg. If the context is done before the message could be sent,
+// e.g. while waiting for the pacing interval or for credit of the client, the message is dropped without
+// aborting the stream.
+func (s *serverStream) SendMsgContext(sendCtx context.Context, m interface{}) error {
+	if r := sendContextErr(s.ctx, sendCtx); r != nil {
+		return r
+	}
+	if r := s.pace(sendCtx); r != nil {
+		return r
+	}
+
+	writeCtx, cancel := s.cfg.sendPacing.writeContext(s.ctx)
+	defer cancel()
+
+	if !s.sendWin.ready() {
+		// the client grants credit for the messages it received, so the batch must not wait for the delay
+		if r := s.batch.flush(); r != nil {
+			s.cancel()
+			return r
+		}
+	}
+	ctx := writeCtx
+	if sendCtx != s.ctx {
+		var cancelSend context.CancelFunc
+		ctx, cancelSend = withSendContext(writeCtx, sendCtx)
+		defer cancelSend()
+	}
+	if r := s.sendWin.acquire(ctx); r != nil {
+		if r := sendContextErr(writeCtx, sendCtx); r != nil {
+			return r
+		}
+		s.writeFailed(writeCtx)
+		return s.aborted.err(s.ctx)
+	}
+	return s.write(writeCtx, m)
 }
 
 // Close closes the stream with OK status.
@@ -116,7 +220,7 @@ func (s *serverStream) Close() {
 	}()
 
 	if r := s.sendMsg(nil, true, false); r != nil {
-		s.log.Errorf("failed to close stream: %w", r)
+		s.log.Error("failed to close stream", "subject", s.respSubj, "error", r)
 		return
 	}
 }
@@ -127,35 +231,181 @@ func (s *serverStream) CloseWithError(err error) {
 		s.statsHandler.HandleRPC(s.ctx, &stats.End{BeginTime: s.start, EndTime: time.Now(), Error: err})
 	}()
 
-	state := status.Convert(err)
-	if r := s.sendMsg(state.Proto(), true, false); r != nil {
-		s.log.Errorf("failed to close stream with error: %w", r)
+	if r := s.sendStatus(toStatus(err)); r != nil {
+		s.log.Error("failed to close stream with error", "subject", s.respSubj, "error", r)
 		return
 	}
 }
 
-func (s *serverStream) sendMsg(args proto.Message, eos, headerOnly bool) (err error) {
+// sendMsg sends args encoded with the codec of the stream. Only the metadata is sent if args is nil.
+func (s *serverStream) sendMsg(args interface{}, eos, headerOnly bool) error {
+	resp := &Response{
+		HeaderOnly: headerOnly,
+		Eos:        eos,
+	}
+	if args == nil {
+		return s.send(resp, nil, nil, 0)
+	}
+
+	resp.Codec = s.codec.Name()
+	resp.Compressor = s.compressor
+	return s.send(resp, args, s.codec, s.cfg.maxSendMsgSize)
+}
+
+// sendStatus closes the stream with the error status. The status is encoded as proto message
+// regardless of the codec of the stream.
+func (s *serverStream) sendStatus(state *status.Status) error {
+	return s.send(&Response{Eos: true}, state.Proto(), encoding.GetCodec(encproto.Name), math.MaxInt32)
+}
+
+// send sends the response with args encoded with the codec as data. Only the response is sent if args is nil.
+func (s *serverStream) send(resp *Response, args interface{}, codec Codec, maxSize int) (err error) {
 	defer func() {
-		if err != nil || eos {
+		if err != nil || resp.Eos {
 			s.cancel()
 		}
 	}()
-	innerPayload, payload, err := marshalRespMsg(args, s.sendHeader, s.sendTrailer, eos, headerOnly)
+	// the header is sent with the first frame, the trailer with the end of the stream
+	header, trailer := s.metadata(resp.Eos)
+	if resp.HeaderOnly && header == nil {
+		// the header went out with a frame sent concurrently
+		return nil
+	}
+	resp.Header = fromMD(header)
+	resp.Trailer = fromMD(trailer)
+
+	var innerPayload, payload []byte
+	if args == nil {
+		payload, err = proto.Marshal(resp)
+	} else {
+		innerPayload, resp.Data, payload, err = marshalEnvelope(codec, resp.Compressor, args, maxSize, resp)
+	}
 	if err != nil {
 		return err
 	}
 
-	s.statsHandler.HandleRPC(s.ctx, &stats.OutHeader{Header: s.sendHeader, FullMethod: s.desc.StreamName})
+	if header != nil {
+		s.statsHandler.HandleRPC(s.ctx, &stats.OutHeader{Header: header, FullMethod: s.fullMethod})
+	}
 	s.statsHandler.HandleRPC(s.ctx, &stats.OutPayload{Payload: args, Data: innerPayload, Length: len(innerPayload), WireLength: len(payload)})
-	s.statsHandler.HandleRPC(s.ctx, &stats.OutTrailer{Trailer: s.sendTrailer})
+	if resp.Eos {
+		s.statsHandler.HandleRPC(s.ctx, &stats.OutTrailer{Trailer: trailer})
+	}
+
+	return s.publish(respFrame(resp), payload)
+}
+
+// publish publishes the payload on the response subject. Frames other than pings and requests
+// to resume the stream are numbered if stream resumption is enabled. Data frames are batched
+// if enabled and negotiated with the client.
+func (s *serverStream) publish(frame FrameType, payload []byte) error {
+	if frame == FramePing || frame == FrameResume {
+		return s.publishFrame(frame, payload)
+	}
+	if frame == FrameData && s.batch.enabled(s.version) {
+		return s.batch.add(payload)
+	}
+	// the frame must not overtake the data frames sent before
+	if r := s.batch.flush(); r != nil {
+		return r
+	}
+	return s.publishNumbered(frame, payload)
+}
+
+// publishNumbered publishes the payload numbered if stream resumption is enabled.
+func (s *serverStream) publishNumbered(frame FrameType, payload []byte) error {
+	return s.resume.send(payload, respSeqField, func(payload []byte) error {
+		return s.publishFrame(frame, payload)
+	})
+}
 
-	msg := pubsub.Message{
-		Subject: s.respSubj,
-		Data:    payload,
+// publishBatch publishes the data frames of a batch in a single frame.
+func (s *serverStream) publishBatch(frames [][]byte) error {
+	if len(frames) == 1 {
+		return s.publishNumbered(FrameData, frames[0])
 	}
-	s.sendHeader = nil
+	payload, err := marshalRespBatch(frames)
+	if err != nil {
+		return err
+	}
+	return s.publishNumbered(FrameBatch, payload)
+}
+
+// publishFrame publishes the payload on the response subject. It is split into chunks if needed.
+func (s *serverStream) publishFrame(frame FrameType, payload []byte) error {
+	chunks := [][]byte{payload}
+	if s.chunker.needsSplit(payload) {
+		var err error
+		if chunks, err = s.chunker.split(payload, wrapRespChunk); err != nil {
+			return err
+		}
+	}
+
+	if debugEnabled(s.log) {
+		s.log.Debug("sending frame", "subject", s.respSubj, "frame", frame, "chunks", len(chunks))
+	}
+	s.cfg.tap.response(s.ctx, Frame{Direction: FrameSent, Method: s.fullMethod, Subject: s.respSubj, Type: frame, Data: payload})
+
+	for _, chunk := range chunks {
+		if s.streamID != "" {
+			chunk = appendStreamID(chunk, s.streamID)
+		}
+		if r := s.pub.Publish(pubsub.Message{
+			Subject: s.respSubj,
+			Data:    chunk,
+			ID:      frameID(s.frameKey, atomic.AddUint64(&s.sentSeq, 1)),
+		}); r != nil {
+			return r
+		}
+		s.activity.sentFrame(len(chunk))
+	}
+	return nil
+}
+
+// linger keeps serving requests to resume the stream after it ended.
+func (s *serverStream) linger() {
+	if s.resume.enabled() {
+		time.Sleep(resumeLinger)
+	}
+}
+
+// abort cancels the stream with the given error.
+func (s *serverStream) abort(err error) {
+	first := s.aborted.set(err)
+	s.cancel()
+	if first {
+		s.cfg.events.aborted(s, err)
+	}
+}
+
+// ping proves the liveness of the server to the client.
+func (s *serverStream) ping() error {
+	payload, err := marshalRespPing(s.resume.lastSent())
+	if err != nil {
+		return err
+	}
+	return s.publish(FramePing, payload)
+}
+
+// requestResume asks the client to send the frames following the last frame received in order again.
+func (s *serverStream) requestResume() {
+	payload, err := marshalRespResume(s.resume.acked())
+	if err != nil {
+		return
+	}
+	if r := s.publish(FrameResume, payload); r != nil {
+		s.log.Warn("failed to request resumption of stream", "subject", s.respSubj, "error", r)
+	}
+}
 
-	return s.pub.Publish(msg)
+// replay sends the frames following the acknowledged sequence number again.
+func (s *serverStream) replay(ack uint64) {
+	err := s.resume.replay(ack, func(payload []byte) error {
+		return s.publishFrame(FrameReplay, payload)
+	})
+	if err != nil {
+		s.abort(err)
+	}
 }
 
 // RecvMsg blocks until it receives a message into m or the stream is
@@ -168,7 +418,8 @@ func (s *serverStream) sendMsg(args proto.Message, eos, headerOnly bool) (err er
 // safe to call RecvMsg on the same stream in different goroutines.
 func (s *serverStream) RecvMsg(target interface{}) (err error) {
 	defer func() {
-		if err != nil {
+		// like with grpc, the context of the handler stays alive when the client closes its side of the stream
+		if err != nil && !errors.Is(err, io.EOF) {
 			s.cancel()
 		}
 	}()
@@ -183,14 +434,28 @@ func (s *serverStream) RecvMsg(target interface{}) (err error) {
 		return io.EOF
 	}
 
-	return nil
+	return s.grantCredit()
+}
+
+// grantCredit grants the client further credit once enough messages of the window were consumed.
+func (s *serverStream) grantCredit() error {
+	credit := s.recvWin.consume()
+	if credit == 0 {
+		return nil
+	}
+
+	payload, err := marshalRespCredit(credit)
+	if err != nil {
+		return err
+	}
+	return s.publish(FrameCredit, payload)
 }
 
 func (s *serverStream) recvMsg(target interface{}) (*Request, error) {
 	var recv *recvMsg
 	select {
 	case <-s.ctx.Done():
-		return nil, s.ctx.Err()
+		return nil, s.aborted.err(s.ctx)
 	case recv = <-s.chRecv:
 	}
 
@@ -199,68 +464,270 @@ func (s *serverStream) recvMsg(target interface{}) (*Request, error) {
 		return nil, err
 	}
 
-	// nolint: forcetypeassert
-	if r := proto.Unmarshal(req.Data, target.(proto.Message)); r != nil {
-		return nil, r
+	codec, err := getCodec(req.Codec)
+	if err != nil {
+		return nil, err
 	}
-
-	if len(req.Header) != 0 {
-		s.statsHandler.HandleRPC(s.ctx, &stats.InHeader{Header: toMD(req.Header), FullMethod: s.desc.StreamName})
+	data, err := decode(codec, req.Compressor, req.Data, target, s.cfg.maxRecvMsgSize)
+	if err != nil {
+		return nil, err
 	}
-	s.statsHandler.HandleRPC(s.ctx, &stats.InPayload{Payload: target, Data: req.Data, Length: len(req.Data), WireLength: len(recv.data)})
+
+	s.statsHandler.HandleRPC(s.ctx, &stats.InPayload{Payload: target, Data: data, Length: len(data), WireLength: len(recv.data)})
 
 	return req, nil
 }
 
-// Subscribe subscribes to the client stream.
-func (s *serverStream) Subscribe(ctx context.Context, reqData []byte) error {
-	queue := "receive"
-
-	s.statsHandler.HandleRPC(ctx, &stats.Begin{BeginTime: s.start, IsClientStream: s.desc.ClientStreams, IsServerStream: s.desc.ServerStreams})
-
+// Subscribe subscribes to the client stream. The peer is completed with the client of the stream
+// and passed to the handler with the context. The request and response subjects chosen by the client
+// must be the subjects of a stream of the method mapped with the subject mapper.
+func (s *serverStream) Subscribe(ctx context.Context, reqData []byte, peer Peer, subjects SubjectMapper) error {
+	s.activity.receivedFrame(len(reqData))
 	req, err := unmarshalReq(reqData)
 	if err != nil {
 		return fmt.Errorf("failed to unmarshal request message: %w", err)
 	}
+	if r := checkStreamSubjects(subjects, s.fullMethod, req); r != nil {
+		return r
+	}
+	s.reqSubj = req.ReqSubject
 	s.respSubj = req.RespSubject
+	s.compressor = req.Compressor
+	if s.codec, err = getCodec(req.Codec); err != nil {
+		return err
+	}
 	reqHeader := toMD(req.Header)
+	if req.Window != 0 {
+		s.sendWin.enable(int(req.Window))
+	}
+	s.keepalive = newKeepalive(time.Duration(req.KeepaliveInterval), time.Duration(req.KeepaliveTimeout))
+	s.resume, s.order = newSequencing(int(req.ResumeBuffer), OrderingPolicy{
+		Mode:   OrderingMode(req.Ordering),
+		Window: int(req.ReorderWindow),
+	})
+	s.version = negotiateVersion(s.cfg.wireVersion, req.Version)
+	s.frameKey = s.respSubj
+	if s.version >= muxVersion && req.StreamId != "" {
+		s.streamID = req.StreamId
+		s.frameKey = s.respSubj + "." + s.streamID
+	}
 
+	peer.ReplySubject = s.respSubj
+	peer.ClientID = req.ClientId
+	peer.Identity = announcedIdentity(req)
+	ctx = newPeerContext(ctx, &peer)
 	ctx = metadata.NewIncomingContext(ctx, reqHeader)
-	s.ctx, s.cancel = context.WithCancel(ctx)
+	ctx = grpc.NewContextWithServerTransportStream(ctx, streamTransport{stream: s})
+	ctx = s.statsHandler.TagRPC(ctx, &stats.RPCTagInfo{FullMethodName: s.fullMethod})
+	ctx = context.WithValue(ctx, streamKey{}, introspectable(s))
+	s.ctx, s.cancel = contextWithTimeout(ctx, req.Timeout, s.cfg.handlerTimeout)
 
-	s.statsHandler.HandleRPC(ctx, &stats.InHeader{Header: reqHeader, WireLength: len(reqData), FullMethod: s.desc.StreamName})
+	s.statsHandler.HandleRPC(ctx, &stats.Begin{BeginTime: s.start, IsClientStream: s.desc.ClientStreams, IsServerStream: s.desc.ServerStreams})
+	s.statsHandler.HandleRPC(ctx, &stats.InHeader{Header: reqHeader, WireLength: len(reqData), FullMethod: s.fullMethod})
 
-	s.log.Infof("Subscribed Stream (server): Subject => %s, Queue => %s", req.ReqSubject, queue)
-	sub, err := s.sub.Subscribe(req.ReqSubject, queue, func(ctx context.Context, msg pubsub.Replier) {
-		// dbg.Cyan("client -> server (received)", msg.Subject(), msg.Data())
-		select {
-		case <-s.ctx.Done():
-		case s.chRecv <- &recvMsg{ctx: ctx, data: msg.Data()}:
-		default:
-			select {
-			case <-s.ctx.Done():
-			case <-ctx.Done():
-				s.cancel()
-			case s.chRecv <- &recvMsg{ctx: ctx, data: msg.Data()}:
-			case <-time.After(stuckTimeout):
-				s.log.Errorf("Stream: Subject => %s, Queue => %s: closing stream: "+
-					"server stream consumer stuck for 30sec", s.respSubj, queue)
-				s.cancel()
-			}
-		}
-	})
+	s.log.Debug("subscribed server stream", "subject", req.ReqSubject, "queue", s.cfg.streamQueue)
+	sub, err := s.subscribe()
 	if err != nil {
 		return fmt.Errorf("failed to subscribe: %w", err)
 	}
 
-	go func() {
-		<-s.ctx.Done()
-		_ = sub.Unsubscribe()
-	}()
+	go s.resume.watch(s.ctx, sub, s.linger, s.subscribe, s.requestResume, s.abort)
+	go s.keepalive.run(s.ctx, "client", s.ping, s.abort)
 
+	if req.DataFollows {
+		// the data of the first message is sent in chunks on the request subject
+		return nil
+	}
 	s.chRecv <- &recvMsg{
 		ctx: ctx,
 		req: req,
 	}
 	return nil
 }
+
+// checkStreamSubjects rejects the request and response subjects of the stream unless they are the
+// subjects of a stream of the method: the servers subscribe and publish on them on behalf of the
+// client, which must not reach the subjects of other methods or tenants, e.g. with wildcards.
+func checkStreamSubjects(subjects SubjectMapper, method string, req *Request) error {
+	method = strings.ReplaceAll(method, "/", ".")
+	if !isMappedSubject(subjects, "nrpc.req"+method+".", req.ReqSubject) {
+		return status.Errorf(codes.InvalidArgument, "nrpc: invalid request subject %q", req.ReqSubject)
+	}
+	if !isMappedSubject(subjects, "nrpc.resp"+method+".", req.RespSubject) &&
+		!isMappedSubject(subjects, "nrpc.mux.", req.RespSubject) {
+		return status.Errorf(codes.InvalidArgument, "nrpc: invalid response subject %q", req.RespSubject)
+	}
+	return nil
+}
+
+// isMappedSubject reports whether the subject is the prefix followed by a suffix without wildcards, mapped
+// with the subject mapper.
+func isMappedSubject(subjects SubjectMapper, prefix, subject string) bool {
+	subject, ok := unmapSubject(subjects, subject)
+	if !ok || len(subject) <= len(prefix) || !strings.HasPrefix(subject, prefix) {
+		return false
+	}
+	return !pubsub.HasWildcards(subject[len(prefix):])
+}
+
+func (s *serverStream) subscribe() (pubsub.Subscription, error) {
+	return s.sub.Subscribe(s.reqSubj, s.cfg.streamQueue, s.receive)
+}
+
+// receive handles a message received on the request subject.
+func (s *serverStream) receive(ctx context.Context, msg pubsub.Replier) {
+	// dbg.Cyan("client -> server (received)", msg.Subject(), msg.Data())
+	if s.dedup.duplicate(msg) {
+		observeDuplicate(s.cfg.observer, s.fullMethod)
+		return
+	}
+	s.activity.receivedFrame(len(msg.Data()))
+	s.keepalive.received()
+	recv := s.readReq(ctx, msg.Data())
+	if recv == nil {
+		// incomplete chunked message
+		return
+	}
+	req, err := recv.request()
+	if err != nil {
+		s.deliver(ctx, recv)
+		return
+	}
+	if !s.accept(req) {
+		return
+	}
+	if r := s.order.receive(req.Seq, func() { s.process(ctx, recv, req) }); r != nil {
+		s.log.Error("aborting stream: server stream received frames out of order", "subject", s.reqSubj, "error", r)
+		s.abort(r)
+	}
+}
+
+// process delivers a received request or the requests of a received batch.
+func (s *serverStream) process(ctx context.Context, recv *recvMsg, req *Request) {
+	if len(req.Batch) != 0 {
+		for _, frame := range req.Batch {
+			s.deliver(ctx, &recvMsg{ctx: ctx, data: frame})
+		}
+		return
+	}
+	s.deliver(ctx, recv)
+}
+
+// deliver handles a received request, which is either a credit grant or buffered to be received.
+func (s *serverStream) deliver(ctx context.Context, recv *recvMsg) {
+	if req, err := recv.request(); err == nil && req.Credit != 0 {
+		s.sendWin.add(int(req.Credit))
+		return
+	}
+
+	select {
+	case <-s.ctx.Done():
+		return
+	case s.chRecv <- recv:
+	default:
+		if !s.overflow(ctx, recv) {
+			return
+		}
+	}
+	s.cfg.observer.ObserveQueueDepth(s.fullMethod, len(s.chRecv))
+}
+
+// overflow buffers a message received while the receive buffer is full according to the overflow policy.
+// It reports whether the message was buffered.
+func (s *serverStream) overflow(ctx context.Context, recv *recvMsg) bool {
+	switch s.cfg.recvBuffer.Overflow {
+	case OverflowAbort:
+		s.log.Error("aborting stream: server stream receive buffer overflowed", "subject", s.reqSubj, "size", cap(s.chRecv))
+		s.abort(errRecvOverflow(cap(s.chRecv)))
+		return false
+	case OverflowDropOldest:
+		for {
+			select {
+			case s.chRecv <- recv:
+				return true
+			default:
+			}
+			select {
+			case old := <-s.chRecv:
+				if old.final() {
+					s.abort(errRecvOverflow(cap(s.chRecv)))
+					return false
+				}
+				s.cfg.recvBuffer.dropped(s.ctx, s.fullMethod)
+			default:
+			}
+		}
+	}
+
+	s.cfg.events.slowConsumer(s)
+	select {
+	case <-s.ctx.Done():
+		return false
+	case <-ctx.Done():
+		s.cancel()
+		return false
+	case s.chRecv <- recv:
+		return true
+	case <-time.After(s.cfg.stuckTimeout):
+		s.log.Error("closing stream: server stream consumer stuck",
+			"subject", s.respSubj, "queue", s.cfg.streamQueue, "timeout", s.cfg.stuckTimeout)
+		s.cfg.observer.ConsumerStuck(s.fullMethod)
+		s.cfg.events.stuck(s)
+		s.cancel()
+		return false
+	}
+}
+
+// accept handles pings and requests to resume the stream and checks the order of the other frames.
+// It reports whether the request should be processed further.
+func (s *serverStream) accept(req *Request) bool {
+	switch {
+	case req.Ping:
+		if s.resume.advertised(req.LastSeq) {
+			s.requestResume()
+		}
+		return false
+	case req.Resume:
+		s.replay(req.Ack)
+		return false
+	}
+
+	if duplicateFrame(s.dedup, s.resume, req.Seq) {
+		observeDuplicate(s.cfg.observer, s.fullMethod)
+		return false
+	}
+
+	next, missing := s.resume.receive(req.Seq)
+	if missing {
+		s.requestResume()
+	}
+	return next
+}
+
+// readReq reads a received request. Chunks are collected until the request is complete.
+// It returns nil if more chunks are expected.
+func (s *serverStream) readReq(ctx context.Context, data []byte) *recvMsg {
+	recv := &recvMsg{ctx: ctx, data: data}
+	req, err := recv.request()
+	if err != nil {
+		return recv
+	}
+	if debugEnabled(s.log) {
+		s.log.Debug("received frame", "subject", s.reqSubj, "frame", reqFrame(req))
+	}
+	if req.Chunk == nil {
+		s.cfg.tap.request(ctx, Frame{Direction: FrameReceived, Method: s.fullMethod, Subject: s.reqSubj, Data: data})
+		return recv
+	}
+
+	data, complete, err := s.chunks.add(req.Chunk)
+	if err != nil {
+		recv.err = err
+		return recv
+	}
+	if !complete {
+		return nil
+	}
+	s.cfg.tap.request(ctx, Frame{Direction: FrameReceived, Method: s.fullMethod, Subject: s.reqSubj, Data: data})
+	return &recvMsg{ctx: ctx, data: data}
+}
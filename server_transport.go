@@ -1,39 +1,107 @@
 package nrpc
 
 import (
+	"sync"
+
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
+// errHeaderSent is returned like grpc does when the header is set or sent after it was sent already.
+var errHeaderSent = status.Error(codes.Internal, "transport: SendHeader called multiple times")
+
 func newServerTransport(method string) *serverTransport {
 	return &serverTransport{
 		method: method,
 	}
 }
 
+// serverTransport collects the header and trailer set by the handler of a unary call with
+// grpc.SetHeader, grpc.SendHeader and grpc.SetTrailer. Both are sent with the response, as
+// a unary call is answered with a single message.
 type serverTransport struct {
-	method  string
-	header  metadata.MD
-	trailer metadata.MD
+	method string
+
+	m          sync.Mutex
+	header     metadata.MD
+	trailer    metadata.MD
+	headerSent bool
 }
 
 // Method implements grpc.ServerTransportStream interface.
-func (s serverTransport) Method() string {
+func (s *serverTransport) Method() string {
 	return s.method
 }
 
-// SetHeader implements grpc.ServerTransportStream interface.
+// SetHeader implements grpc.ServerTransportStream interface. The metadata is merged with the
+// header set before. It fails once the header was sent.
 func (s *serverTransport) SetHeader(md metadata.MD) error {
-	s.header = md
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.headerSent {
+		return errHeaderSent
+	}
+	s.header = metadata.Join(s.header, md)
 	return nil
 }
 
-// SendHeader implements grpc.ServerTransportStream interface.
-func (s serverTransport) SendHeader(md metadata.MD) error {
+// SendHeader implements grpc.ServerTransportStream interface. The metadata is merged with the
+// header set before. Further calls of SetHeader and SendHeader fail.
+func (s *serverTransport) SendHeader(md metadata.MD) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.headerSent {
+		return errHeaderSent
+	}
+	s.header = metadata.Join(s.header, md)
+	s.headerSent = true
 	return nil
 }
 
-// SetTrailer implements grpc.ServerTransportStream interface.
+// SetTrailer implements grpc.ServerTransportStream interface. The metadata is merged with the trailer set before.
 func (s *serverTransport) SetTrailer(md metadata.MD) error {
-	s.trailer = md
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.trailer = metadata.Join(s.trailer, md)
+	return nil
+}
+
+// metadata returns the header and trailer to send with the response and marks the header as sent.
+func (s *serverTransport) metadata() (metadata.MD, metadata.MD) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.headerSent = true
+	return s.header, s.trailer
+}
+
+// streamTransport exposes a server stream to grpc.SetHeader, grpc.SendHeader and grpc.SetTrailer
+// called with the context of the stream.
+type streamTransport struct {
+	stream *serverStream
+}
+
+// Method implements grpc.ServerTransportStream interface.
+func (t streamTransport) Method() string {
+	return t.stream.fullMethod
+}
+
+// SetHeader implements grpc.ServerTransportStream interface.
+func (t streamTransport) SetHeader(md metadata.MD) error {
+	return t.stream.SetHeader(md)
+}
+
+// SendHeader implements grpc.ServerTransportStream interface.
+func (t streamTransport) SendHeader(md metadata.MD) error {
+	return t.stream.SendHeader(md)
+}
+
+// SetTrailer implements grpc.ServerTransportStream interface.
+func (t streamTransport) SetTrailer(md metadata.MD) error {
+	t.stream.SetTrailer(md)
 	return nil
 }
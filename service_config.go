@@ -0,0 +1,266 @@
+package nrpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+)
+
+// ServiceConfig configures the calls of a client per method (see WithServiceConfig). It is read from a
+// document in the JSON format of the gRPC service config:
+//
+//	{
+//	  "methodConfig": [{
+//	    "name": [{"service": "foo.Foo", "method": "Bar"}, {"service": "foo.Baz"}],
+//	    "timeout": "1.5s",
+//	    "waitForReady": true,
+//	    "maxRequestMessageBytes": 1048576,
+//	    "maxResponseMessageBytes": 4194304,
+//	    "retryPolicy": {
+//	      "maxAttempts": 3,
+//	      "initialBackoff": "0.1s",
+//	      "maxBackoff": "1s",
+//	      "backoffMultiplier": 2,
+//	      "retryableStatusCodes": ["UNAVAILABLE"]
+//	    }
+//	  }]
+//	}
+//
+// A name with a method selects the method, a name without a method all methods of the service and an
+// empty name all methods. Like the policies of the options, the config of a method takes precedence
+// over the one of its service and the default. A method config may set a hedgingPolicy instead of the
+// retryPolicy. Other fields of the gRPC service config are ignored.
+type ServiceConfig struct {
+	methods map[string]methodConfig
+}
+
+// methodConfig is the configuration of the calls of a method, a service or the default. Unset fields
+// leave the configuration of the client unchanged.
+type methodConfig struct {
+	timeout         time.Duration
+	waitForReady    *bool
+	maxRequestSize  int
+	maxResponseSize int
+	retry           *RetryPolicy
+	hedging         *HedgingPolicy
+}
+
+// apply applies the config to the options of a call.
+func (c methodConfig) apply(opt *callOptions) {
+	opt.timeout = c.timeout
+	if c.waitForReady != nil {
+		opt.readiness = readinessOf(*c.waitForReady)
+	}
+	if c.maxRequestSize > 0 {
+		opt.stream.maxSendMsgSize = c.maxRequestSize
+	}
+	if c.maxResponseSize > 0 {
+		opt.stream.maxRecvMsgSize = c.maxResponseSize
+	}
+	// like in gRPC, a method is either retried or hedged
+	if c.retry != nil {
+		opt.retry, opt.hedging = *c.retry, HedgingPolicy{}
+	}
+	if c.hedging != nil {
+		opt.retry, opt.hedging = RetryPolicy{}, *c.hedging
+	}
+}
+
+// get returns the config of the full method (/service/method).
+func (c *ServiceConfig) get(method string) methodConfig {
+	if c == nil {
+		return methodConfig{}
+	}
+	for _, key := range policyKeys(method) {
+		if cfg, ok := c.methods[key]; ok {
+			return cfg
+		}
+	}
+	return methodConfig{}
+}
+
+// LoadServiceConfig reads the service config document from the file (see ServiceConfig).
+func LoadServiceConfig(path string) (*ServiceConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseServiceConfig(data)
+}
+
+// ParseServiceConfig parses a service config document (see ServiceConfig). Durations are given like in
+// the gRPC service config, e.g. "1.5s", status codes by their names, e.g. "UNAVAILABLE".
+func ParseServiceConfig(data []byte) (*ServiceConfig, error) {
+	var doc jsonServiceConfig
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("nrpc: invalid service config: %w", err)
+	}
+
+	cfg := &ServiceConfig{methods: map[string]methodConfig{}}
+	for i, jsonCfg := range doc.MethodConfig {
+		methodCfg, err := jsonCfg.methodConfig()
+		if err != nil {
+			return nil, fmt.Errorf("nrpc: invalid method config %d of the service config: %w", i, err)
+		}
+		for _, name := range jsonCfg.Name {
+			key, err := name.key()
+			if err != nil {
+				return nil, fmt.Errorf("nrpc: invalid method config %d of the service config: %w", i, err)
+			}
+			if _, ok := cfg.methods[key]; ok {
+				return nil, fmt.Errorf("nrpc: service config configures %q more than once", key)
+			}
+			cfg.methods[key] = methodCfg
+		}
+	}
+	return cfg, nil
+}
+
+type jsonServiceConfig struct {
+	MethodConfig []jsonMethodConfig `json:"methodConfig"`
+}
+
+type jsonMethodConfig struct {
+	Name                    []jsonName         `json:"name"`
+	Timeout                 string             `json:"timeout"`
+	WaitForReady            *bool              `json:"waitForReady"`
+	MaxRequestMessageBytes  int                `json:"maxRequestMessageBytes"`
+	MaxResponseMessageBytes int                `json:"maxResponseMessageBytes"`
+	RetryPolicy             *jsonRetryPolicy   `json:"retryPolicy"`
+	HedgingPolicy           *jsonHedgingPolicy `json:"hedgingPolicy"`
+}
+
+func (c jsonMethodConfig) methodConfig() (methodConfig, error) {
+	if c.RetryPolicy != nil && c.HedgingPolicy != nil {
+		return methodConfig{}, fmt.Errorf("retryPolicy and hedgingPolicy are mutually exclusive")
+	}
+	timeout, err := parseDuration("timeout", c.Timeout)
+	if err != nil {
+		return methodConfig{}, err
+	}
+	cfg := methodConfig{
+		timeout:         timeout,
+		waitForReady:    c.WaitForReady,
+		maxRequestSize:  c.MaxRequestMessageBytes,
+		maxResponseSize: c.MaxResponseMessageBytes,
+	}
+	if c.RetryPolicy != nil {
+		policy, err := c.RetryPolicy.policy()
+		if err != nil {
+			return methodConfig{}, err
+		}
+		cfg.retry = &policy
+	}
+	if c.HedgingPolicy != nil {
+		policy, err := c.HedgingPolicy.policy()
+		if err != nil {
+			return methodConfig{}, err
+		}
+		cfg.hedging = &policy
+	}
+	return cfg, nil
+}
+
+type jsonName struct {
+	Service string `json:"service"`
+	Method  string `json:"method"`
+}
+
+// key returns the key the config of the name is looked up with (see policyKeys).
+func (n jsonName) key() (string, error) {
+	switch {
+	case n.Method == "":
+		return n.Service, nil
+	case n.Service == "":
+		return "", fmt.Errorf("name with method %q without service", n.Method)
+	}
+	return "/" + n.Service + "/" + n.Method, nil
+}
+
+type jsonRetryPolicy struct {
+	MaxAttempts          int          `json:"maxAttempts"`
+	InitialBackoff       string       `json:"initialBackoff"`
+	MaxBackoff           string       `json:"maxBackoff"`
+	BackoffMultiplier    float64      `json:"backoffMultiplier"`
+	RetryableStatusCodes []codes.Code `json:"retryableStatusCodes"`
+}
+
+func (p jsonRetryPolicy) policy() (RetryPolicy, error) {
+	initialBackoff, err := parseDuration("initialBackoff", p.InitialBackoff)
+	if err != nil {
+		return RetryPolicy{}, err
+	}
+	maxBackoff, err := parseDuration("maxBackoff", p.MaxBackoff)
+	if err != nil {
+		return RetryPolicy{}, err
+	}
+	return RetryPolicy{
+		MaxAttempts:          p.MaxAttempts,
+		InitialBackoff:       initialBackoff,
+		MaxBackoff:           maxBackoff,
+		BackoffMultiplier:    p.BackoffMultiplier,
+		RetryableStatusCodes: p.RetryableStatusCodes,
+	}, nil
+}
+
+type jsonHedgingPolicy struct {
+	MaxAttempts         int          `json:"maxAttempts"`
+	HedgingDelay        string       `json:"hedgingDelay"`
+	NonFatalStatusCodes []codes.Code `json:"nonFatalStatusCodes"`
+}
+
+func (p jsonHedgingPolicy) policy() (HedgingPolicy, error) {
+	delay, err := parseDuration("hedgingDelay", p.HedgingDelay)
+	if err != nil {
+		return HedgingPolicy{}, err
+	}
+	return HedgingPolicy{
+		MaxAttempts:         p.MaxAttempts,
+		HedgingDelay:        delay,
+		NonFatalStatusCodes: p.NonFatalStatusCodes,
+	}, nil
+}
+
+// parseDuration parses a duration of the service config. Besides the seconds of the gRPC service
+// config, e.g. "1.5s", it accepts all durations understood by time.ParseDuration.
+func parseDuration(field, s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid %s %q", field, s)
+	}
+	return d, nil
+}
+
+// serviceConfigHolder holds the service config of a client, which can be replaced at runtime.
+type serviceConfigHolder struct {
+	m   sync.RWMutex
+	cfg *ServiceConfig
+}
+
+func (h *serviceConfigHolder) set(cfg *ServiceConfig) {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	h.cfg = cfg
+}
+
+// get returns the config of the full method (/service/method).
+func (h *serviceConfigHolder) get(method string) methodConfig {
+	h.m.RLock()
+	defer h.m.RUnlock()
+
+	return h.cfg.get(method)
+}
+
+// SetServiceConfig replaces the service config of the client (see WithServiceConfig), e.g. with an
+// updated document. Calls already in progress keep their configuration. A nil config removes it.
+func (s *Client) SetServiceConfig(cfg *ServiceConfig) {
+	s.serviceConfig.set(cfg)
+}
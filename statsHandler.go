@@ -2,7 +2,11 @@ package nrpc
 
 import (
 	"context"
+	"sync"
+	"time"
 
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/stats"
 )
 
@@ -21,3 +25,64 @@ func (n noopStatsHandler) TagConn(ctx context.Context, _ *stats.ConnTagInfo) con
 }
 
 func (n noopStatsHandler) HandleConn(context.Context, stats.ConnStats) {}
+
+// clientStats reports the events of a call of the client to its stats handler.
+type clientStats struct {
+	handler stats.Handler
+	// ctx is the context of the call tagged by the handler.
+	ctx    context.Context
+	method string
+	begin  time.Time
+
+	endOnce sync.Once
+}
+
+// beginClientStats tags the call of the method with the stats handler and reports its begin.
+// The returned context carries the tags, e.g. the span of tracing handlers, and is used for the call.
+func beginClientStats(ctx context.Context, handler stats.Handler, method string, desc *grpc.StreamDesc) (context.Context, *clientStats) {
+	ctx = handler.TagRPC(ctx, &stats.RPCTagInfo{FullMethodName: method})
+	c := &clientStats{handler: handler, ctx: ctx, method: method, begin: time.Now()}
+
+	begin := &stats.Begin{Client: true, BeginTime: c.begin}
+	if desc != nil {
+		begin.IsClientStream = desc.ClientStreams
+		begin.IsServerStream = desc.ServerStreams
+	}
+	handler.HandleRPC(ctx, begin)
+	return ctx, c
+}
+
+// outHeader reports the header sent with the first message of the call.
+func (c *clientStats) outHeader(ctx context.Context, subj string) {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	c.handler.HandleRPC(c.ctx, &stats.OutHeader{Client: true, Header: md, FullMethod: c.method, RemoteAddr: subjectAddr(subj)})
+}
+
+// outPayload reports a sent message. The data is the marshaled message, payload its wire format.
+func (c *clientStats) outPayload(msg interface{}, data, payload []byte) {
+	c.handler.HandleRPC(c.ctx, &stats.OutPayload{Client: true, Payload: msg, Data: data, Length: len(data),
+		WireLength: len(payload), SentTime: time.Now()})
+}
+
+// inHeader reports the header received from the server.
+func (c *clientStats) inHeader(header metadata.MD, wireLength int) {
+	c.handler.HandleRPC(c.ctx, &stats.InHeader{Client: true, Header: header, FullMethod: c.method, WireLength: wireLength})
+}
+
+// inPayload reports a received message. The data is the marshaled message, payload its wire format.
+func (c *clientStats) inPayload(msg interface{}, data, payload []byte) {
+	c.handler.HandleRPC(c.ctx, &stats.InPayload{Client: true, Payload: msg, Data: data, Length: len(data),
+		WireLength: len(payload), RecvTime: time.Now()})
+}
+
+// inTrailer reports the trailer received from the server.
+func (c *clientStats) inTrailer(trailer metadata.MD) {
+	c.handler.HandleRPC(c.ctx, &stats.InTrailer{Client: true, Trailer: trailer})
+}
+
+// end reports the end of the call with its error. Only the first end of a call is reported.
+func (c *clientStats) end(err error) {
+	c.endOnce.Do(func() {
+		c.handler.HandleRPC(c.ctx, &stats.End{Client: true, BeginTime: c.begin, EndTime: time.Now(), Error: err})
+	})
+}
@@ -0,0 +1,106 @@
+package nrpc
+
+import (
+	"strings"
+	"sync"
+)
+
+var subjects = struct {
+	m      sync.RWMutex
+	byName map[string]string
+}{byName: map[string]string{}}
+
+// RegisterSubject sets the subject the full method (/service/method) is served on instead of the
+// default nrpc.<service>.<method>. Client and server must agree on the subject. The code generated
+// by protoc-gen-nrpc registers the subjects set with the options of nrpcpb/options.proto.
+// It must only be called at init time.
+func RegisterSubject(fullMethod, subject string) {
+	subjects.m.Lock()
+	defer subjects.m.Unlock()
+
+	subjects.byName[fullMethod] = subject
+}
+
+// methodSubj returns the subject the full method is served on.
+func methodSubj(method string) string {
+	subjects.m.RLock()
+	subj, ok := subjects.byName[method]
+	subjects.m.RUnlock()
+	if ok {
+		return subj
+	}
+	if subj, ok := eventSubj(method); ok {
+		return subj
+	}
+	return "nrpc" + strings.ReplaceAll(method, "/", ".")
+}
+
+// callSubj returns the subject a call is sent to: the subject of the instance (see WithAnnouncements)
+// or the subject of the method served by the queue group of the service if the instance is empty.
+func callSubj(method, instance string) string {
+	if instance == "" {
+		return methodSubj(method)
+	}
+	return methodSubj(method) + "." + instance
+}
+
+// SubjectMapper maps the subjects of nrpc to the subjects used on the broker, so multiple environments
+// or tenants can share a broker without collisions. It is applied to all subjects: the subjects of the
+// methods (including the ones set with RegisterSubject), the request and response subjects of streams
+// as well as the subjects of cancellations and discovery. Client and server must use the same mapping.
+type SubjectMapper interface {
+	// MapSubject returns the subject used on the broker for the subject of nrpc.
+	MapSubject(subject string) string
+}
+
+// SubjectMapperFunc adapts a function to the SubjectMapper interface.
+type SubjectMapperFunc func(subject string) string
+
+// MapSubject implements the SubjectMapper interface.
+func (f SubjectMapperFunc) MapSubject(subject string) string {
+	return f(subject)
+}
+
+// subjectScheme is the default subject mapper. It prepends the prefix, environment and tenant
+// segments set with WithSubjectPrefix, WithEnvironment and WithTenant in that order.
+// Without segments the subjects are left unchanged.
+type subjectScheme struct {
+	prefix      string
+	environment string
+	tenant      string
+}
+
+// MapSubject implements the SubjectMapper interface.
+func (s subjectScheme) MapSubject(subject string) string {
+	var b strings.Builder
+	for _, segment := range []string{s.prefix, s.environment, s.tenant} {
+		if segment == "" {
+			continue
+		}
+		b.WriteString(segment)
+		b.WriteByte('.')
+	}
+	if b.Len() == 0 {
+		return subject
+	}
+	b.WriteString(subject)
+	return b.String()
+}
+
+// unmapSubject returns the subject of nrpc the subject used on the broker was mapped from by stripping the
+// segments the mapper adds around the subjects. The segments are located by mapping a marker, so any mapper
+// leaving the subjects themselves untouched is supported. It reports false if the subject is not a subject
+// mapped by the mapper.
+func unmapSubject(mapper SubjectMapper, subject string) (string, bool) {
+	const marker = "\x00"
+	mapped := mapper.MapSubject(marker)
+	i := strings.Index(mapped, marker)
+	if i < 0 {
+		return "", false
+	}
+	head, tail := mapped[:i], mapped[i+len(marker):]
+	if len(subject) < len(head)+len(tail) || !strings.HasPrefix(subject, head) || !strings.HasSuffix(subject, tail) {
+		return "", false
+	}
+	return subject[len(head) : len(subject)-len(tail)], true
+}
@@ -3,6 +3,7 @@ package nrpc
 import (
 	"context"
 	"errors"
+	"sync"
 	"time"
 
 	"github.com/tehsphinx/nrpc/pubsub"
@@ -12,6 +13,13 @@ type subscription struct {
 	endpoint string
 	queue    string
 	handler  pubsub.Handler
+	// control subscriptions (e.g. cancellations) are kept while the server drains.
+	control bool
+}
+
+// key identifies the subscription. The same subject may be subscribed in several queue groups.
+func (d subscription) key() string {
+	return d.endpoint + " " + d.queue
 }
 
 func newSubscriptions(log Logger) *subscriptions {
@@ -24,6 +32,7 @@ func newSubscriptions(log Logger) *subscriptions {
 type subscriptions struct {
 	log Logger
 
+	m    sync.Mutex
 	defs []subscription
 	subs map[string]pubsub.Subscription
 }
@@ -34,19 +43,22 @@ func (s *subscriptions) RegisterSubscription(sub subscription) {
 }
 
 func (s *subscriptions) subscribe(subscriber pubsub.Subscriber) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
 	for _, def := range s.defs {
 		sub, err := subscriber.SubscribeAsync(def.endpoint, def.queue, def.handler)
 		if err != nil {
 			return err
 		}
 
-		if subscr, ok := s.subs[def.endpoint]; ok {
+		if subscr, ok := s.subs[def.key()]; ok {
 			_ = subscr.Unsubscribe()
-			s.log.Infof("un-subscribed: subject => %v: subscription with same name", def.endpoint)
+			s.log.Warn("unsubscribed subscription with same subject and queue", "subject", def.endpoint, "queue", def.queue)
 		}
-		s.subs[def.endpoint] = sub
+		s.subs[def.key()] = sub
 
-		s.log.Infof("Subscribed: subject => %v, queue => %v", def.endpoint, def.queue)
+		s.log.Info("subscribed", "subject", def.endpoint, "queue", def.queue)
 	}
 	return nil
 }
@@ -58,10 +70,7 @@ func (s *subscriptions) watchSubscriptions(ctx context.Context) error {
 	for {
 		select {
 		case <-tick.C:
-			for _, sub := range s.subs {
-				if sub.IsValid() {
-					continue
-				}
+			if !s.valid() {
 				return errors.New("subscription was closed unexpectedly")
 			}
 		case <-ctx.Done():
@@ -70,10 +79,40 @@ func (s *subscriptions) watchSubscriptions(ctx context.Context) error {
 	}
 }
 
-func (s *subscriptions) closeSubscriptions() {
+func (s *subscriptions) valid() bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+
 	for _, sub := range s.subs {
+		if !sub.IsValid() {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *subscriptions) closeSubscriptions() {
+	s.close(func(subscription) bool { return true })
+}
+
+// closeEndpoints closes all subscriptions but the control subscriptions, so no new calls are accepted.
+func (s *subscriptions) closeEndpoints() {
+	s.close(func(def subscription) bool { return !def.control })
+}
+
+func (s *subscriptions) close(filter func(def subscription) bool) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	for _, def := range s.defs {
+		sub, ok := s.subs[def.key()]
+		if !ok || !filter(def) {
+			continue
+		}
+		delete(s.subs, def.key())
+
 		if r := sub.Unsubscribe(); r != nil {
-			s.log.Infof("error closing subscription: %v", r)
+			s.log.Error("failed to close subscription", "subject", def.endpoint, "error", r)
 		}
 	}
 }
@@ -0,0 +1,118 @@
+package nrpc
+
+import (
+	"context"
+	"strings"
+
+	"github.com/tehsphinx/nrpc/pubsub"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// TenantMetadataKey is the metadata key the tenant of a call is taken from if its context carries
+// no tenant (see NewTenantContext).
+const TenantMetadataKey = "nrpc-tenant"
+
+// anyTenant is the subject segment multi-tenant servers subscribe with to serve all tenants.
+const anyTenant = "*"
+
+type tenantKey struct{}
+
+// NewTenantContext returns a context carrying the tenant. The calls of clients with multi-tenancy
+// (see WithMultiTenancy) made with the context are scoped to the tenant.
+func NewTenantContext(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFromContext returns the tenant carried by the context. Servers with multi-tenancy pass the
+// tenant of the call to the handler with the context. As handlers pass their context on to the calls
+// they make, these calls are scoped to the same tenant.
+func TenantFromContext(ctx context.Context) (string, bool) {
+	tenant, ok := ctx.Value(tenantKey{}).(string)
+	return tenant, ok
+}
+
+// tenantOf returns the tenant of an outgoing call: the tenant of the context or the one set
+// in the outgoing metadata.
+func tenantOf(ctx context.Context) string {
+	if tenant, ok := TenantFromContext(ctx); ok {
+		return tenant
+	}
+	md, _ := metadata.FromOutgoingContext(ctx)
+	if values := md.Get(TenantMetadataKey); len(values) != 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// validTenant reports whether the tenant can be used as a subject segment.
+func validTenant(tenant string) bool {
+	return tenant != "" && !strings.ContainsAny(tenant, ".*> \t\r\n")
+}
+
+// tenantSubjects scopes the subjects under the tenant before mapping them with the subject mapper,
+// so the tenant segment follows the prefix and environment segments of the default mapper.
+type tenantSubjects struct {
+	tenant string
+	mapper SubjectMapper
+}
+
+// MapSubject implements the SubjectMapper interface.
+func (m tenantSubjects) MapSubject(subject string) string {
+	return m.mapper.MapSubject(m.tenant + "." + subject)
+}
+
+// callSubjects returns the subject mapper of a call. Calls of clients with multi-tenancy are scoped
+// to the tenant of the context and fail without a valid tenant.
+func (s *Client) callSubjects(ctx context.Context) (SubjectMapper, error) {
+	if !s.multiTenant {
+		return s.subjects, nil
+	}
+	tenant := tenantOf(ctx)
+	if !validTenant(tenant) {
+		return nil, status.Errorf(codes.InvalidArgument, "nrpc: invalid or missing tenant %q", tenant)
+	}
+	return tenantSubjects{tenant: tenant, mapper: s.subjects}, nil
+}
+
+// servedSubjects returns the subject mapper of the subjects the services are served on.
+// Servers with multi-tenancy serve all tenants.
+func (s *Server) servedSubjects() SubjectMapper {
+	if !s.multiTenant {
+		return s.subjects
+	}
+	return tenantSubjects{tenant: anyTenant, mapper: s.subjects}
+}
+
+// streamSubjects returns the subject mapper of the subjects of a stream opened with the context.
+// Servers with multi-tenancy map them to the tenant the stream was opened by.
+func (s *Server) streamSubjects(ctx context.Context) SubjectMapper {
+	if !s.multiTenant {
+		return s.subjects
+	}
+	tenant, _ := TenantFromContext(ctx)
+	return tenantSubjects{tenant: tenant, mapper: s.subjects}
+}
+
+// tenantHandler passes the tenant segment of the subject the message was received on to the
+// handler with the context. The subject is the one of the subscription.
+func (s *Server) tenantHandler(subject string, handler pubsub.Handler) pubsub.Handler {
+	if !s.multiTenant {
+		return handler
+	}
+
+	pos := -1
+	for i, segment := range strings.Split(subject, ".") {
+		if segment == anyTenant {
+			pos = i
+			break
+		}
+	}
+	return func(ctx context.Context, msg pubsub.Replier) {
+		if segments := strings.Split(msg.Subject(), "."); pos >= 0 && pos < len(segments) {
+			ctx = NewTenantContext(ctx, segments[pos])
+		}
+		handler(ctx, msg)
+	}
+}
@@ -0,0 +1,198 @@
+package testproto
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/eclipse/paho.golang/packets"
+)
+
+// NewTestMQTTBroker starts an in-memory MQTT 5 broker and returns its address as well as a shutdown
+// function to be deferred. The broker implements what the mqtt transport relies on: QoS 1 publications
+// acknowledged with the reason code 0x10 if nobody subscribed to the topic, the response topic and
+// correlation data properties and shared subscriptions ($share/<group>/<filter>). Messages are delivered
+// to the subscribers with QoS 0.
+func NewTestMQTTBroker() (addr string, shutdown func(), err error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to start mqtt broker: %w", err)
+	}
+
+	b := &mqttBroker{sessions: map[*mqttSession]struct{}{}}
+	go b.serve(ln)
+	return ln.Addr().String(), func() {
+		_ = ln.Close()
+		b.close()
+	}, nil
+}
+
+type mqttBroker struct {
+	m        sync.Mutex
+	sessions map[*mqttSession]struct{}
+	// next is the round-robin counter of the shared subscriptions by group and filter.
+	next map[string]int
+}
+
+type mqttSession struct {
+	conn net.Conn
+	// filters are the topic filters the session subscribed to, guarded by the mutex of the broker.
+	filters map[string]struct{}
+
+	w sync.Mutex
+}
+
+func (s *mqttSession) send(p packets.Packet) {
+	s.w.Lock()
+	defer s.w.Unlock()
+	_, _ = p.WriteTo(s.conn)
+}
+
+func (b *mqttBroker) serve(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go b.handle(&mqttSession{conn: conn, filters: map[string]struct{}{}})
+	}
+}
+
+func (b *mqttBroker) close() {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	for s := range b.sessions {
+		_ = s.conn.Close()
+	}
+}
+
+func (b *mqttBroker) handle(s *mqttSession) {
+	b.m.Lock()
+	b.sessions[s] = struct{}{}
+	b.m.Unlock()
+	defer func() {
+		b.m.Lock()
+		delete(b.sessions, s)
+		b.m.Unlock()
+		_ = s.conn.Close()
+	}()
+
+	for {
+		cp, err := packets.ReadPacket(s.conn)
+		if err != nil {
+			return
+		}
+		switch p := cp.Content.(type) {
+		case *packets.Connect:
+			s.send(packets.NewControlPacket(packets.CONNACK).Content)
+		case *packets.Subscribe:
+			ack := packets.NewControlPacket(packets.SUBACK).Content.(*packets.Suback)
+			ack.PacketID = p.PacketID
+			b.m.Lock()
+			for filter, opts := range p.Subscriptions {
+				s.filters[filter] = struct{}{}
+				ack.Reasons = append(ack.Reasons, opts.QoS)
+			}
+			b.m.Unlock()
+			s.send(ack)
+		case *packets.Unsubscribe:
+			ack := packets.NewControlPacket(packets.UNSUBACK).Content.(*packets.Unsuback)
+			ack.PacketID = p.PacketID
+			b.m.Lock()
+			for _, filter := range p.Topics {
+				delete(s.filters, filter)
+				ack.Reasons = append(ack.Reasons, packets.UnsubackSuccess)
+			}
+			b.m.Unlock()
+			s.send(ack)
+		case *packets.Publish:
+			delivered := b.route(p)
+			if p.QoS == 0 {
+				continue
+			}
+			ack := packets.NewControlPacket(packets.PUBACK).Content.(*packets.Puback)
+			ack.PacketID = p.PacketID
+			if !delivered {
+				ack.ReasonCode = packets.PubackNoMatchingSubscribers
+			}
+			s.send(ack)
+		case *packets.Pingreq:
+			s.send(packets.NewControlPacket(packets.PINGRESP).Content)
+		case *packets.Disconnect:
+			return
+		}
+	}
+}
+
+// route delivers the message to the sessions subscribed to its topic and reports whether any was. Each
+// group of shared subscriptions receives the message once.
+func (b *mqttBroker) route(p *packets.Publish) bool {
+	var receivers []*mqttSession
+
+	b.m.Lock()
+	shared := map[string][]*mqttSession{}
+	for s := range b.sessions {
+		subscribed := false
+		for filter := range s.filters {
+			if group, f, ok := sharedFilter(filter); ok {
+				if topicMatches(f, p.Topic) {
+					shared[group+"/"+f] = append(shared[group+"/"+f], s)
+				}
+				continue
+			}
+			subscribed = subscribed || topicMatches(filter, p.Topic)
+		}
+		if subscribed {
+			receivers = append(receivers, s)
+		}
+	}
+	if b.next == nil {
+		b.next = map[string]int{}
+	}
+	for key, members := range shared {
+		receivers = append(receivers, members[b.next[key]%len(members)])
+		b.next[key]++
+	}
+	b.m.Unlock()
+
+	for _, s := range receivers {
+		s.send(&packets.Publish{
+			Topic:      p.Topic,
+			Payload:    p.Payload,
+			Properties: p.Properties,
+		})
+	}
+	return len(receivers) != 0
+}
+
+// sharedFilter splits a shared subscription ($share/<group>/<filter>) into its group and filter.
+func sharedFilter(filter string) (group, f string, ok bool) {
+	rest := strings.TrimPrefix(filter, "$share/")
+	if len(rest) == len(filter) {
+		return "", "", false
+	}
+	i := strings.Index(rest, "/")
+	if i < 0 {
+		return "", "", false
+	}
+	return rest[:i], rest[i+1:], true
+}
+
+// topicMatches reports whether the topic matches the filter with the wildcards + and #.
+func topicMatches(filter, topic string) bool {
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+	for i, level := range filterLevels {
+		switch {
+		case level == "#":
+			return true
+		case i >= len(topicLevels):
+			return false
+		case level != "+" && level != topicLevels[i]:
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
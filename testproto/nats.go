@@ -1,12 +1,16 @@
 // Package testproto contains the generated testproto grpc code.
 // Additionally it contains a function to start the server and
 // returns a NATS connection to it. NATS is used in the tests.
+// For the Redis transport an in-memory Redis server is started instead,
+// for the MQTT transport an in-memory MQTT 5 broker.
 package testproto
 
 import (
 	"fmt"
 	"math"
 	"net"
+	"os"
+	"time"
 
 	"github.com/nats-io/nats-server/v2/server"
 	"github.com/nats-io/nats.go"
@@ -14,25 +18,45 @@ import (
 
 // NewTestConn creates a nats test connection and returns a shutdown function to be deferred.
 func NewTestConn() (conn *nats.Conn, shutdown func(), err error) {
-	// nolint: gomnd
-	port, err := getFreePort(3)
+	return newTestConn(server.Options{})
+}
+
+// NewTestJetStreamConn creates a nats test connection to a server with JetStream enabled
+// and returns a shutdown function to be deferred.
+func NewTestJetStreamConn() (conn *nats.Conn, js nats.JetStreamContext, shutdown func(), err error) {
+	storeDir, err := os.MkdirTemp("", "nrpc-jetstream")
 	if err != nil {
-		return nil, nil, fmt.Errorf("no free port found")
+		return nil, nil, nil, fmt.Errorf("failed to create store dir: %w", err)
 	}
 
-	opts := server.Options{
-		Host:       "localhost",
-		Port:       port,
-		MaxPayload: math.MaxInt32,
-		MaxPending: math.MaxInt64,
+	conn, shutdownConn, err := newTestConn(server.Options{
+		JetStream: true,
+		StoreDir:  storeDir,
+	})
+	if err != nil {
+		_ = os.RemoveAll(storeDir)
+		return nil, nil, nil, err
 	}
-	gnatsd, err := server.NewServer(&opts)
+	shutdown = func() {
+		shutdownConn()
+		_ = os.RemoveAll(storeDir)
+	}
+
+	js, err = conn.JetStream()
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to create nats server: %w", err)
+		shutdown()
+		return nil, nil, nil, fmt.Errorf("failed to create jetstream context: %w", err)
 	}
-	gnatsd.Start()
+	return conn, js, shutdown, nil
+}
 
-	conn, err = nats.Connect(gnatsd.Addr().String())
+func newTestConn(opts server.Options) (conn *nats.Conn, shutdown func(), err error) {
+	srv, err := newNATSServer(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	conn, err = nats.Connect(srv.URL())
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to connect to nats server: %w", err)
 	}
@@ -42,10 +66,78 @@ func NewTestConn() (conn *nats.Conn, shutdown func(), err error) {
 
 	return conn, func() {
 		conn.Close()
-		gnatsd.Shutdown()
+		srv.Shutdown()
 	}, nil
 }
 
+// NATSServer is a nats test server that can be restarted on the same address
+// to test the behavior of connections on server outages.
+type NATSServer struct {
+	opts   server.Options
+	gnatsd *server.Server
+}
+
+// NewTestNATSServer starts a nats test server.
+func NewTestNATSServer() (*NATSServer, error) {
+	return newNATSServer(server.Options{})
+}
+
+// NewTestAuthNATSServer starts a nats test server the given users can connect to.
+func NewTestAuthNATSServer(users []*server.User) (*NATSServer, error) {
+	return newNATSServer(server.Options{Users: users})
+}
+
+func newNATSServer(opts server.Options) (*NATSServer, error) {
+	// nolint: gomnd
+	port, err := getFreePort(3)
+	if err != nil {
+		return nil, fmt.Errorf("no free port found")
+	}
+
+	opts.Host = "localhost"
+	opts.Port = port
+	opts.MaxPayload = math.MaxInt32
+	opts.MaxPending = math.MaxInt64
+
+	srv := &NATSServer{opts: opts}
+	if r := srv.start(); r != nil {
+		return nil, r
+	}
+	return srv, nil
+}
+
+func (s *NATSServer) start() error {
+	opts := s.opts
+	gnatsd, err := server.NewServer(&opts)
+	if err != nil {
+		return fmt.Errorf("failed to create nats server: %w", err)
+	}
+	gnatsd.Start()
+
+	// nolint: gomnd
+	if !gnatsd.ReadyForConnections(5 * time.Second) {
+		gnatsd.Shutdown()
+		return fmt.Errorf("nats server not ready for connections")
+	}
+	s.gnatsd = gnatsd
+	return nil
+}
+
+// URL returns the address to connect to the server.
+func (s *NATSServer) URL() string {
+	return fmt.Sprintf("nats://%s:%d", s.opts.Host, s.opts.Port)
+}
+
+// Restart starts the server again after it was shut down.
+func (s *NATSServer) Restart() error {
+	return s.start()
+}
+
+// Shutdown shuts the server down.
+func (s *NATSServer) Shutdown() {
+	s.gnatsd.Shutdown()
+}
+
 func getFreePort(n int) (port int, err error) {
 	for i := 0; i < n; i++ {
 		if port, err = getPort(); err == nil {
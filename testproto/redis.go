@@ -0,0 +1,23 @@
+package testproto
+
+import (
+	"fmt"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// NewTestRedisClient starts an in-memory Redis server and returns a client connected to it
+// as well as a shutdown function to be deferred.
+func NewTestRedisClient() (client *redis.Client, shutdown func(), err error) {
+	srv, err := miniredis.Run()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to start redis server: %w", err)
+	}
+
+	client = redis.NewClient(&redis.Options{Addr: srv.Addr()})
+	return client, func() {
+		_ = client.Close()
+		srv.Close()
+	}, nil
+}
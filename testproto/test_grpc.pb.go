@@ -309,3 +309,200 @@ var Test_ServiceDesc = grpc.ServiceDesc{
 	},
 	Metadata: "testproto/test.proto",
 }
+
+// EchoClient is the client API for Echo service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type EchoClient interface {
+	// Echo answers with the received message.
+	Echo(ctx context.Context, in *UnaryReq, opts ...grpc.CallOption) (*UnaryResp, error)
+	// Stream answers every received message.
+	Stream(ctx context.Context, opts ...grpc.CallOption) (Echo_StreamClient, error)
+	// Notify receives a message without answering.
+	Notify(ctx context.Context, in *UnaryReq, opts ...grpc.CallOption) (*UnaryResp, error)
+}
+
+type echoClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewEchoClient(cc grpc.ClientConnInterface) EchoClient {
+	return &echoClient{cc}
+}
+
+func (c *echoClient) Echo(ctx context.Context, in *UnaryReq, opts ...grpc.CallOption) (*UnaryResp, error) {
+	out := new(UnaryResp)
+	err := c.cc.Invoke(ctx, "/testproto.Echo/Echo", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *echoClient) Stream(ctx context.Context, opts ...grpc.CallOption) (Echo_StreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Echo_ServiceDesc.Streams[0], "/testproto.Echo/Stream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &echoStreamClient{stream}
+	return x, nil
+}
+
+type Echo_StreamClient interface {
+	Send(*BiDiStreamReq) error
+	Recv() (*BiDiStreamResp, error)
+	grpc.ClientStream
+}
+
+type echoStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *echoStreamClient) Send(m *BiDiStreamReq) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *echoStreamClient) Recv() (*BiDiStreamResp, error) {
+	m := new(BiDiStreamResp)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *echoClient) Notify(ctx context.Context, in *UnaryReq, opts ...grpc.CallOption) (*UnaryResp, error) {
+	out := new(UnaryResp)
+	err := c.cc.Invoke(ctx, "/testproto.Echo/Notify", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// EchoServer is the server API for Echo service.
+// All implementations must embed UnimplementedEchoServer
+// for forward compatibility
+type EchoServer interface {
+	// Echo answers with the received message.
+	Echo(context.Context, *UnaryReq) (*UnaryResp, error)
+	// Stream answers every received message.
+	Stream(Echo_StreamServer) error
+	// Notify receives a message without answering.
+	Notify(context.Context, *UnaryReq) (*UnaryResp, error)
+	mustEmbedUnimplementedEchoServer()
+}
+
+// UnimplementedEchoServer must be embedded to have forward compatible implementations.
+type UnimplementedEchoServer struct {
+}
+
+func (UnimplementedEchoServer) Echo(context.Context, *UnaryReq) (*UnaryResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Echo not implemented")
+}
+func (UnimplementedEchoServer) Stream(Echo_StreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method Stream not implemented")
+}
+func (UnimplementedEchoServer) Notify(context.Context, *UnaryReq) (*UnaryResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Notify not implemented")
+}
+func (UnimplementedEchoServer) mustEmbedUnimplementedEchoServer() {}
+
+// UnsafeEchoServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to EchoServer will
+// result in compilation errors.
+type UnsafeEchoServer interface {
+	mustEmbedUnimplementedEchoServer()
+}
+
+func RegisterEchoServer(s grpc.ServiceRegistrar, srv EchoServer) {
+	s.RegisterService(&Echo_ServiceDesc, srv)
+}
+
+func _Echo_Echo_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UnaryReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EchoServer).Echo(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/testproto.Echo/Echo",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EchoServer).Echo(ctx, req.(*UnaryReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Echo_Stream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(EchoServer).Stream(&echoStreamServer{stream})
+}
+
+type Echo_StreamServer interface {
+	Send(*BiDiStreamResp) error
+	Recv() (*BiDiStreamReq, error)
+	grpc.ServerStream
+}
+
+type echoStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *echoStreamServer) Send(m *BiDiStreamResp) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *echoStreamServer) Recv() (*BiDiStreamReq, error) {
+	m := new(BiDiStreamReq)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Echo_Notify_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UnaryReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EchoServer).Notify(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/testproto.Echo/Notify",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EchoServer).Notify(ctx, req.(*UnaryReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Echo_ServiceDesc is the grpc.ServiceDesc for Echo service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Echo_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "testproto.Echo",
+	HandlerType: (*EchoServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Echo",
+			Handler:    _Echo_Echo_Handler,
+		},
+		{
+			MethodName: "Notify",
+			Handler:    _Echo_Notify_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Stream",
+			Handler:       _Echo_Stream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "testproto/test.proto",
+}
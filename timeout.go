@@ -0,0 +1,88 @@
+package nrpc
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// handlerTimeouts holds the handler timeouts configured for methods, services and the default.
+type handlerTimeouts map[string]time.Duration
+
+// get returns the handler timeout of the full method (/service/method). It is 0 if the handlers
+// of the method are not bound by a timeout.
+func (p handlerTimeouts) get(method string) time.Duration {
+	for _, key := range policyKeys(method) {
+		if timeout, ok := p[key]; ok {
+			return timeout
+		}
+	}
+	return 0
+}
+
+// handlerTimeoutTable holds the handler timeouts of a server, which can be replaced at runtime
+// (see Server.SetHandlerTimeouts).
+type handlerTimeoutTable struct {
+	m        sync.RWMutex
+	timeouts handlerTimeouts
+}
+
+// get returns the handler timeout of the full method (/service/method).
+func (t *handlerTimeoutTable) get(method string) time.Duration {
+	t.m.RLock()
+	defer t.m.RUnlock()
+
+	return t.timeouts.get(method)
+}
+
+func (t *handlerTimeoutTable) set(timeouts handlerTimeouts) {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	t.timeouts = timeouts
+}
+
+// SetHandlerTimeouts replaces the handler timeouts of the server at runtime, e.g. with the timeouts of an
+// updated configuration. The timeouts are keyed like the methods of WithHandlerTimeout: by full method
+// (/service/method), by service name or by "" for the default. Calls and streams in progress keep their
+// timeout.
+func (s *Server) SetHandlerTimeouts(timeouts map[string]time.Duration) {
+	table := make(handlerTimeouts, len(timeouts))
+	for key, timeout := range timeouts {
+		table[key] = timeout
+	}
+	s.cfg.handlerTimeouts.set(table)
+}
+
+// contextWithTimeout returns a cancelable context which is additionally bound to the timeout if one
+// was transmitted by the client and to the timeout of the handler if one is configured on the server.
+func contextWithTimeout(ctx context.Context, timeout int64, handlerTimeout time.Duration) (context.Context, context.CancelFunc) {
+	if handlerTimeout > 0 && (timeout == 0 || handlerTimeout < time.Duration(timeout)) {
+		timeout = int64(handlerTimeout)
+	}
+	if timeout == 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Duration(timeout))
+}
+
+// deadlineErr returns codes.DeadlineExceeded if the deadline of the handler passed, so handlers ignoring
+// their context report it instead of their late result. Otherwise, the error of the handler is returned.
+func deadlineErr(ctx context.Context, err error) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return status.Error(codes.DeadlineExceeded, "nrpc: the handler exceeded its deadline")
+	}
+	return err
+}
+
+// withCallTimeout bounds the context of a call to the timeout configured by the service config of the
+// client. The context is returned unchanged if no timeout is configured.
+func withCallTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
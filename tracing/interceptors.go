@@ -0,0 +1,173 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+)
+
+const (
+	messageSent     = "SENT"
+	messageReceived = "RECEIVED"
+)
+
+// UnaryClientInterceptor returns a client interceptor creating a span per unary call.
+func UnaryClientInterceptor(opts ...Option) grpc.UnaryClientInterceptor {
+	cfg := getConfig(opts)
+	tracer := cfg.tracer()
+
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		name, attrs := spanInfo(method)
+		ctx, span := tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+
+		err := invoker(cfg.inject(ctx), method, req, reply, cc, opts...)
+		endSpan(span, err)
+		return err
+	}
+}
+
+// StreamClientInterceptor returns a client interceptor creating a span per stream and per message
+// sent or received on it. The span of the stream ends once RecvMsg returns an error.
+func StreamClientInterceptor(opts ...Option) grpc.StreamClientInterceptor {
+	cfg := getConfig(opts)
+	tracer := cfg.tracer()
+
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string,
+		streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		name, attrs := spanInfo(method)
+		ctx, span := tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+
+		stream, err := streamer(cfg.inject(ctx), desc, cc, method, opts...)
+		if err != nil {
+			endSpan(span, err)
+			return nil, err
+		}
+		return &clientStream{
+			ClientStream: stream,
+			tracedStream: &tracedStream{tracer: tracer, ctx: ctx, name: name, span: span},
+		}, nil
+	}
+}
+
+// UnaryServerInterceptor returns a server interceptor creating a span per unary call. The span
+// is a child of the span of the client, if the client sent its trace context.
+func UnaryServerInterceptor(opts ...Option) grpc.UnaryServerInterceptor {
+	cfg := getConfig(opts)
+	tracer := cfg.tracer()
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		name, attrs := spanInfo(info.FullMethod)
+		ctx, span := tracer.Start(cfg.extract(ctx), name, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attrs...))
+
+		resp, err := handler(ctx, req)
+		endSpan(span, err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor returns a server interceptor creating a span per stream and per message
+// sent or received on it. The span is a child of the span of the client, if the client sent its trace context.
+func StreamServerInterceptor(opts ...Option) grpc.StreamServerInterceptor {
+	cfg := getConfig(opts)
+	tracer := cfg.tracer()
+
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		name, attrs := spanInfo(info.FullMethod)
+		ctx, span := tracer.Start(cfg.extract(ss.Context()), name,
+			trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attrs...))
+
+		t := &tracedStream{tracer: tracer, ctx: ctx, name: name, span: span}
+		err := handler(srv, &serverStream{ServerStream: ss, tracedStream: t})
+		t.finish(err)
+		return err
+	}
+}
+
+// tracedStream holds the span of a stream and creates the spans of its messages.
+type tracedStream struct {
+	// sentSeq and recvSeq number the messages. They are accessed atomically and kept
+	// first in the struct to guarantee 64-bit alignment.
+	sentSeq int64
+	recvSeq int64
+
+	tracer trace.Tracer
+	ctx    context.Context
+	name   string
+	span   trace.Span
+	once   sync.Once
+}
+
+// message runs fn within the span of a sent or received message.
+func (t *tracedStream) message(msgType string, seq *int64, fn func() error) error {
+	_, span := t.tracer.Start(t.ctx, t.name+" "+msgType, trace.WithAttributes(
+		semconv.MessageTypeKey.String(msgType),
+		semconv.MessageIDKey.Int64(atomic.AddInt64(seq, 1)),
+	))
+
+	err := fn()
+	if err != nil && !errors.Is(err, io.EOF) {
+		span.RecordError(err)
+	}
+	span.End()
+	return err
+}
+
+// finish ends the span of the stream. Only the first call has an effect.
+func (t *tracedStream) finish(err error) {
+	t.once.Do(func() {
+		if errors.Is(err, io.EOF) {
+			err = nil
+		}
+		endSpan(t.span, err)
+	})
+}
+
+type clientStream struct {
+	grpc.ClientStream
+	*tracedStream
+}
+
+func (s *clientStream) SendMsg(msg interface{}) error {
+	return s.message(messageSent, &s.sentSeq, func() error {
+		return s.ClientStream.SendMsg(msg)
+	})
+}
+
+func (s *clientStream) RecvMsg(msg interface{}) error {
+	err := s.message(messageReceived, &s.recvSeq, func() error {
+		return s.ClientStream.RecvMsg(msg)
+	})
+	if err != nil {
+		s.finish(err)
+	}
+	return err
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	*tracedStream
+}
+
+// Context returns the context of the stream carrying the span of the stream.
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *serverStream) SendMsg(msg interface{}) error {
+	return s.message(messageSent, &s.sentSeq, func() error {
+		return s.ServerStream.SendMsg(msg)
+	})
+}
+
+func (s *serverStream) RecvMsg(msg interface{}) error {
+	return s.message(messageReceived, &s.recvSeq, func() error {
+		return s.ServerStream.RecvMsg(msg)
+	})
+}
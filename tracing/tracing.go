@@ -0,0 +1,146 @@
+// Package tracing implements OpenTelemetry tracing of nrpc clients and servers. The trace context
+// is injected into the metadata of a request by the client interceptors and extracted by the
+// server interceptors, so spans are linked across the pub/sub hop:
+//
+//	client := nrpc.NewClient(pub, sub, tracing.ClientOptions()...)
+//	server := nrpc.NewServer(pub, sub, tracing.ServerOptions()...)
+//
+// A span is created per RPC and per message sent or received on a stream.
+package tracing
+
+import (
+	"context"
+	"strings"
+
+	"github.com/tehsphinx/nrpc"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const instrumentationName = "github.com/tehsphinx/nrpc/tracing"
+
+// Option configures the tracing interceptors.
+type Option func(cfg *config)
+
+type config struct {
+	provider   trace.TracerProvider
+	propagator propagation.TextMapPropagator
+}
+
+func getConfig(opts []Option) config {
+	cfg := config{
+		provider:   otel.GetTracerProvider(),
+		propagator: otel.GetTextMapPropagator(),
+	}
+	for _, o := range opts {
+		o(&cfg)
+	}
+	return cfg
+}
+
+func (c config) tracer() trace.Tracer {
+	return c.provider.Tracer(instrumentationName)
+}
+
+// WithTracerProvider sets the tracer provider spans are created with. It defaults to the global provider.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(cfg *config) {
+		cfg.provider = provider
+	}
+}
+
+// WithPropagator sets the propagator the trace context is sent with. It defaults to the global propagator.
+func WithPropagator(propagator propagation.TextMapPropagator) Option {
+	return func(cfg *config) {
+		cfg.propagator = propagator
+	}
+}
+
+// ClientOptions returns the options adding the tracing interceptors to a client.
+func ClientOptions(opts ...Option) []nrpc.Option {
+	return []nrpc.Option{
+		nrpc.WithChainUnaryInterceptor(UnaryClientInterceptor(opts...)),
+		nrpc.WithChainStreamInterceptor(StreamClientInterceptor(opts...)),
+	}
+}
+
+// ServerOptions returns the options adding the tracing interceptors to a server.
+func ServerOptions(opts ...Option) []nrpc.Option {
+	return []nrpc.Option{
+		nrpc.ChainUnaryInterceptor(UnaryServerInterceptor(opts...)),
+		nrpc.ChainStreamInterceptor(StreamServerInterceptor(opts...)),
+	}
+}
+
+// inject adds the trace context of ctx to the outgoing metadata.
+func (c config) inject(ctx context.Context) context.Context {
+	md, ok := metadata.FromOutgoingContext(ctx)
+	if ok {
+		md = md.Copy()
+	} else {
+		md = metadata.MD{}
+	}
+	c.propagator.Inject(ctx, metadataCarrier(md))
+	return metadata.NewOutgoingContext(ctx, md)
+}
+
+// extract reads the trace context from the incoming metadata.
+func (c config) extract(ctx context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	return c.propagator.Extract(ctx, metadataCarrier(md))
+}
+
+// metadataCarrier adapts metadata to the propagation.TextMapCarrier interface.
+type metadataCarrier metadata.MD
+
+var _ propagation.TextMapCarrier = metadataCarrier{}
+
+func (c metadataCarrier) Get(key string) string {
+	values := metadata.MD(c).Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// spanInfo returns the span name and the attributes of the given full method (/service/method).
+func spanInfo(fullMethod string) (string, []attribute.KeyValue) {
+	name := strings.TrimPrefix(fullMethod, "/")
+	attrs := []attribute.KeyValue{semconv.RPCSystemKey.String("nrpc")}
+
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		attrs = append(attrs,
+			semconv.RPCServiceKey.String(name[:i]),
+			semconv.RPCMethodKey.String(name[i+1:]),
+		)
+	}
+	return name, attrs
+}
+
+// endSpan records the status of the RPC and ends the span.
+func endSpan(span trace.Span, err error) {
+	st, _ := status.FromError(err)
+	span.SetAttributes(semconv.RPCGRPCStatusCodeKey.Int64(int64(st.Code())))
+	if err != nil {
+		span.SetStatus(otelcodes.Error, st.Message())
+	}
+	span.End()
+}
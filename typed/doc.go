@@ -0,0 +1,23 @@
+// Package typed provides generic wrappers of the streams of nrpc clients and servers, so application code
+// sends and receives messages of their types instead of interface{}:
+//
+//	stream, err := typed.OpenServerStream[pb.ListReq, pb.Item](ctx, client, "/pkg.Service/List", &pb.ListReq{})
+//	if err != nil {
+//		return err
+//	}
+//	for item, err := range stream.Messages(ctx) {
+//		if err != nil {
+//			return err
+//		}
+//		process(item)
+//	}
+//
+// Publish and Subscribe send and handle the events of nrpc (see nrpc.Client.PublishEvent) as messages of
+// their types as well, PublishJob and HandleJob its jobs.
+//
+// The type parameters are the message types, not pointers to them. The package requires Go 1.23, as it
+// supports range-over-func iterators. With older versions of Go it is empty.
+//
+// Code generated by protoc-gen-nrpc with the option iterators=true adds the Messages iterator to the
+// receiving streams of the generated clients and servers.
+package typed
@@ -0,0 +1,215 @@
+//go:build go1.23
+
+package typed
+
+import (
+	"context"
+	"errors"
+	"io"
+	"iter"
+
+	"github.com/tehsphinx/nrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// Receiver is a stream receiving messages, e.g. a grpc.ClientStream or a grpc.ServerStream.
+type Receiver interface {
+	RecvMsg(m any) error
+}
+
+// Recv receives the next message of the stream.
+func Recv[T any](stream Receiver) (*T, error) {
+	m := new(T)
+	if err := stream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Messages returns an iterator over the messages received on the stream:
+//
+//	for msg, err := range typed.Messages[pb.Item](ctx, stream) {
+//
+// The iteration ends at the end of the stream. Other errors end it as well, after yielding them with a nil
+// message. The iteration also ends with the error of the context once it is done. The context is checked
+// before every message: to abort a pending receive, cancel the context of the stream.
+func Messages[T any](ctx context.Context, stream Receiver) iter.Seq2[*T, error] {
+	return func(yield func(*T, error) bool) {
+		for {
+			if r := ctx.Err(); r != nil {
+				yield(nil, status.FromContextError(r).Err())
+				return
+			}
+			m, err := Recv[T](stream)
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			if !yield(m, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+// ServerStream is the client side of a server streaming call receiving messages of type Resp.
+type ServerStream[Resp any] struct {
+	grpc.ClientStream
+}
+
+// OpenServerStream opens a server streaming call of the full method (/service/method) on the client,
+// e.g. an nrpc.Client, and sends the request.
+func OpenServerStream[Req, Resp any](ctx context.Context, cc grpc.ClientConnInterface, method string, req *Req,
+	opts ...grpc.CallOption) (*ServerStream[Resp], error) {
+	stream, err := cc.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, method, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if r := stream.SendMsg(req); r != nil {
+		return nil, r
+	}
+	if r := stream.CloseSend(); r != nil {
+		return nil, r
+	}
+	return &ServerStream[Resp]{ClientStream: stream}, nil
+}
+
+// Recv receives the next message. It returns io.EOF at the end of the stream.
+func (s *ServerStream[Resp]) Recv() (*Resp, error) {
+	return Recv[Resp](s.ClientStream)
+}
+
+// Messages returns an iterator over the received messages (see Messages).
+func (s *ServerStream[Resp]) Messages(ctx context.Context) iter.Seq2[*Resp, error] {
+	return Messages[Resp](ctx, s.ClientStream)
+}
+
+// ClientStream is the client side of a client streaming call sending messages of type Req and receiving
+// a single response of type Resp.
+type ClientStream[Req, Resp any] struct {
+	grpc.ClientStream
+}
+
+// OpenClientStream opens a client streaming call of the full method (/service/method) on the client.
+func OpenClientStream[Req, Resp any](ctx context.Context, cc grpc.ClientConnInterface, method string,
+	opts ...grpc.CallOption) (*ClientStream[Req, Resp], error) {
+	stream, err := cc.NewStream(ctx, &grpc.StreamDesc{ClientStreams: true}, method, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &ClientStream[Req, Resp]{ClientStream: stream}, nil
+}
+
+// Send sends a message.
+func (s *ClientStream[Req, Resp]) Send(m *Req) error {
+	return s.ClientStream.SendMsg(m)
+}
+
+// SendContext sends a message, giving up without aborting the stream if the context is done first
+// (see nrpc.SendMsgContext).
+func (s *ClientStream[Req, Resp]) SendContext(ctx context.Context, m *Req) error {
+	return nrpc.SendMsgContext(ctx, s.ClientStream, m)
+}
+
+// CloseAndRecv ends sending and receives the response.
+func (s *ClientStream[Req, Resp]) CloseAndRecv() (*Resp, error) {
+	if r := s.ClientStream.CloseSend(); r != nil {
+		return nil, r
+	}
+	return Recv[Resp](s.ClientStream)
+}
+
+// BidiStream is the client side of a bidirectional streaming call sending messages of type Req and
+// receiving messages of type Resp.
+type BidiStream[Req, Resp any] struct {
+	grpc.ClientStream
+}
+
+// OpenBidiStream opens a bidirectional streaming call of the full method (/service/method) on the client.
+func OpenBidiStream[Req, Resp any](ctx context.Context, cc grpc.ClientConnInterface, method string,
+	opts ...grpc.CallOption) (*BidiStream[Req, Resp], error) {
+	stream, err := cc.NewStream(ctx, &grpc.StreamDesc{ClientStreams: true, ServerStreams: true}, method, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &BidiStream[Req, Resp]{ClientStream: stream}, nil
+}
+
+// Send sends a message.
+func (s *BidiStream[Req, Resp]) Send(m *Req) error {
+	return s.ClientStream.SendMsg(m)
+}
+
+// SendContext sends a message, giving up without aborting the stream if the context is done first
+// (see nrpc.SendMsgContext).
+func (s *BidiStream[Req, Resp]) SendContext(ctx context.Context, m *Req) error {
+	return nrpc.SendMsgContext(ctx, s.ClientStream, m)
+}
+
+// Recv receives the next message. It returns io.EOF at the end of the stream.
+func (s *BidiStream[Req, Resp]) Recv() (*Resp, error) {
+	return Recv[Resp](s.ClientStream)
+}
+
+// Messages returns an iterator over the received messages (see Messages).
+func (s *BidiStream[Req, Resp]) Messages(ctx context.Context) iter.Seq2[*Resp, error] {
+	return Messages[Resp](ctx, s.ClientStream)
+}
+
+// ServerSide is the server side of a stream receiving messages of type Req and sending messages of type
+// Resp, e.g. to handle streams passed to an unknown service handler (see nrpc.UnknownServiceHandler).
+type ServerSide[Req, Resp any] struct {
+	grpc.ServerStream
+}
+
+// NewServerSide wraps the server side of a stream.
+func NewServerSide[Req, Resp any](stream grpc.ServerStream) *ServerSide[Req, Resp] {
+	return &ServerSide[Req, Resp]{ServerStream: stream}
+}
+
+// Send sends a message.
+func (s *ServerSide[Req, Resp]) Send(m *Resp) error {
+	return s.ServerStream.SendMsg(m)
+}
+
+// SendContext sends a message, giving up without aborting the stream if the context is done first
+// (see nrpc.SendMsgContext).
+func (s *ServerSide[Req, Resp]) SendContext(ctx context.Context, m *Resp) error {
+	return nrpc.SendMsgContext(ctx, s.ServerStream, m)
+}
+
+// Recv receives the next message. It returns io.EOF once the client closed sending.
+func (s *ServerSide[Req, Resp]) Recv() (*Req, error) {
+	return Recv[Req](s.ServerStream)
+}
+
+// Messages returns an iterator over the received messages (see Messages).
+func (s *ServerSide[Req, Resp]) Messages(ctx context.Context) iter.Seq2[*Req, error] {
+	return Messages[Req](ctx, s.ServerStream)
+}
+
+// Publish publishes the event on the subject to the servers handling it (see nrpc.Client.PublishEvent).
+func Publish[T any](ctx context.Context, client *nrpc.Client, subject string, event *T, opts ...grpc.CallOption) error {
+	return client.PublishEvent(ctx, subject, event, opts...)
+}
+
+// Subscribe registers the handler for the events of type T published on the subject
+// (see nrpc.Server.HandleEvent). It must be called before the server starts.
+func Subscribe[T any](server *nrpc.Server, subject, queue string, handler func(ctx context.Context, event *T) error) {
+	server.HandleEvent(subject, queue, func() any { return new(T) }, func(ctx context.Context, event any) error {
+		return handler(ctx, event.(*T))
+	})
+}
+
+// PublishJob publishes the job on the subject to the servers handling it (see nrpc.Client.PublishJob).
+func PublishJob[T any](ctx context.Context, client *nrpc.Client, subject string, job *T, opts ...grpc.CallOption) error {
+	return client.PublishJob(ctx, subject, job, opts...)
+}
+
+// HandleJob registers the handler for the jobs of type T published on the subject
+// (see nrpc.Server.HandleJob). It must be called before the server starts.
+func HandleJob[T any](server *nrpc.Server, subject string, handler func(ctx context.Context, job *T) error) {
+	server.HandleJob(subject, func() any { return new(T) }, func(ctx context.Context, job any) error {
+		return handler(ctx, job.(*T))
+	})
+}
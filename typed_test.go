@@ -0,0 +1,162 @@
+//go:build go1.23
+
+package nrpc_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"github.com/tehsphinx/nrpc"
+	"github.com/tehsphinx/nrpc/pubsub/nats"
+	"github.com/tehsphinx/nrpc/testproto"
+	"github.com/tehsphinx/nrpc/testproto/testserver"
+	"github.com/tehsphinx/nrpc/typed"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestTypedStreams(t *testing.T) {
+	asrt := is.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	server, impl, err := testserver.New(pub, sub)
+	asrt.NoErr(err)
+	defer server.Stop()
+	impl.SetMsgCount(3)
+	client := nrpc.NewClient(pub, sub)
+
+	serverStream, err := typed.OpenServerStream[testproto.ServerStreamReq, testproto.ServerStreamResp](ctx, client,
+		"/testproto.Test/ServerStream", &testproto.ServerStreamReq{Msg: "Hello via NRPC"})
+	asrt.NoErr(err)
+	var i int
+	for resp, err := range serverStream.Messages(ctx) {
+		asrt.NoErr(err)
+		i++
+		asrt.Equal(resp.Msg, fmt.Sprintf("Hello back! %d", i))
+	}
+	asrt.Equal(i, 3)
+
+	clientStream, err := typed.OpenClientStream[testproto.ClientStreamReq, testproto.ClientStreamResp](ctx, client,
+		"/testproto.Test/ClientStream")
+	asrt.NoErr(err)
+	for i := 1; i <= 3; i++ {
+		asrt.NoErr(clientStream.Send(&testproto.ClientStreamReq{Msg: fmt.Sprintf("Hello via NRPC %d", i)}))
+	}
+	resp, err := clientStream.CloseAndRecv()
+	asrt.NoErr(err)
+	asrt.Equal(resp.Msg, "Hello back!")
+
+	bidiStream, err := typed.OpenBidiStream[testproto.BiDiStreamReq, testproto.BiDiStreamResp](ctx, client,
+		"/testproto.Test/BiDiStream")
+	asrt.NoErr(err)
+	for i := 1; i <= 3; i++ {
+		asrt.NoErr(bidiStream.Send(&testproto.BiDiStreamReq{Msg: fmt.Sprintf("Hello via NRPC %d", i)}))
+	}
+	asrt.NoErr(bidiStream.CloseSend())
+	i = 0
+	for resp, err := range bidiStream.Messages(ctx) {
+		asrt.NoErr(err)
+		i++
+		asrt.Equal(resp.Msg, fmt.Sprintf("Hello back! %d", i))
+	}
+	asrt.Equal(i, 3)
+
+	// errors end the iteration
+	serverStream, err = typed.OpenServerStream[testproto.ServerStreamReq, testproto.ServerStreamResp](ctx, client,
+		"/testproto.Test/ServerStream", &testproto.ServerStreamReq{Msg: "invalid"})
+	asrt.NoErr(err)
+	var errs int
+	for resp, err := range serverStream.Messages(ctx) {
+		asrt.True(resp == nil)
+		asrt.True(err != nil)
+		errs++
+	}
+	asrt.Equal(errs, 1)
+}
+
+func TestTypedEvents(t *testing.T) {
+	asrt := is.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, shutdown, err := testproto.NewTestConn()
+	asrt.NoErr(err)
+	defer shutdown()
+
+	pub := nats.Publisher(conn)
+	sub := nats.Subscriber(conn)
+
+	type event struct {
+		server string
+		msg    string
+		header string
+		method string
+	}
+	received := make(chan event, 10)
+	newServer := func(name, queue string) *nrpc.Server {
+		var method string
+		server := nrpc.NewServer(pub, sub, nrpc.UnaryInterceptor(func(ctx context.Context, req interface{},
+			info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			method = info.FullMethod
+			return handler(ctx, req)
+		}))
+		typed.Subscribe(server, "orders.created", queue, func(ctx context.Context, e *testproto.UnaryReq) error {
+			md, _ := metadata.FromIncomingContext(ctx)
+			received <- event{server: name, msg: e.Msg, header: md.Get("x-order")[0], method: method}
+			return nil
+		})
+		asrt.NoErr(server.Run(ctx))
+		return server
+	}
+	for _, srv := range []*nrpc.Server{
+		newServer("a", ""),
+		newServer("b", ""),
+		newServer("queued", "workers"),
+		newServer("queued", "workers"),
+	} {
+		defer srv.Stop()
+	}
+
+	client := nrpc.NewClient(pub, sub)
+	ctx = metadata.AppendToOutgoingContext(ctx, "x-order", "42")
+	asrt.NoErr(typed.Publish(ctx, client, "orders.created", &testproto.UnaryReq{Msg: "created"}))
+
+	// every server receives the event, the servers of a queue group only once
+	got := map[string]int{}
+	for i := 0; i < 3; i++ {
+		select {
+		case e := <-received:
+			asrt.Equal(e.msg, "created")
+			asrt.Equal(e.header, "42")
+			asrt.Equal(e.method, nrpc.EventMethod("orders.created"))
+			got[e.server]++
+		case <-ctx.Done():
+			t.Fatal("event not received")
+		}
+	}
+	select {
+	case e := <-received:
+		t.Fatalf("unexpected event on server %q", e.server)
+	case <-time.After(100 * time.Millisecond):
+	}
+	asrt.Equal(got, map[string]int{"a": 1, "b": 1, "queued": 1})
+
+	// events on other subjects are not received
+	asrt.NoErr(client.PublishEvent(ctx, "orders.deleted", &testproto.UnaryReq{Msg: "deleted"}))
+	select {
+	case e := <-received:
+		t.Fatalf("unexpected event %q", e.msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,165 @@
+package nrpc
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	"github.com/tehsphinx/nrpc/pubsub"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// unknownQueue is the queue group the unknown service handler is subscribed in (see UnknownServiceHandler).
+const unknownQueue = "nrpc.unknown"
+
+// reservedSegments are the segments following "nrpc" in the subjects nrpc uses for other purposes than
+// serving methods. The unknown service handler ignores messages received on them.
+var reservedSegments = map[string]struct{}{
+	"req":       {},
+	"resp":      {},
+	"mux":       {},
+	"cancel":    {},
+	"discovery": {},
+	"inbox":     {},
+	"page":      {},
+	"event":     {},
+	"job":       {},
+	"push":      {},
+}
+
+// registerUnknown subscribes the unknown service handler to the subjects of all methods.
+func (s *Server) registerUnknown() {
+	subj := s.servedSubjects().MapSubject("nrpc.>")
+	// the mapped subject keeps the segments of the method behind the prefix
+	s.unknownPrefix = strings.Count(subj, ".")
+	s.subs.RegisterSubscription(subscription{
+		endpoint: subj,
+		queue:    unknownQueue,
+		handler:  s.tenantHandler(subj, s.handleUnknown),
+	})
+}
+
+// handleUnknown passes calls and streams of methods not registered on the server to the unknown service handler.
+func (s *Server) handleUnknown(ctx context.Context, msg pubsub.Replier) {
+	fullMethod, ok := s.unknownMethod(msg.Subject())
+	if !ok {
+		return
+	}
+	req, err := unmarshalReq(msg.Data())
+	if err != nil {
+		s.respondErr(msg, err)
+		return
+	}
+
+	if req.RespSubject == "" {
+		handler := s.handleMethod(fullMethod, s.unknownMethodDesc(fullMethod), nil, s.unknownLimiter)
+		s.cfg.tap.handler(fullMethod, FrameData, s.recoverHandler(fullMethod, handler))(ctx, msg)
+		return
+	}
+	desc := grpc.StreamDesc{
+		StreamName:    fullMethod[strings.LastIndexByte(fullMethod, '/')+1:],
+		Handler:       s.unknownHandler,
+		ServerStreams: true,
+		ClientStreams: true,
+	}
+	handler := s.handleStream(fullMethod, desc, nil, s.unknownLimiter)
+	s.cfg.tap.handler(fullMethod, FrameHandshake, s.recoverHandler(fullMethod, handler))(ctx, msg)
+}
+
+// unknownMethod returns the full method (/service/method) of a message received on the subject by the unknown
+// service handler. It reports false for the subjects of the methods registered on the server, including the
+// subjects of their instances and broadcasts, and for the subjects nrpc uses otherwise.
+func (s *Server) unknownMethod(subject string) (string, bool) {
+	segments := strings.Split(subject, ".")
+	if len(segments) < s.unknownPrefix+2 {
+		return "", false
+	}
+	segments = segments[s.unknownPrefix:]
+	if _, ok := reservedSegments[segments[0]]; ok {
+		return "", false
+	}
+
+	subj := "nrpc." + strings.Join(segments, ".")
+	if s.serves(subj) || s.serves(subj[:strings.LastIndexByte(subj, '.')]) {
+		return "", false
+	}
+	last := len(segments) - 1
+	return "/" + strings.Join(segments[:last], ".") + "/" + segments[last], true
+}
+
+// serves reports whether a method registered on the server is served on the subject.
+func (s *Server) serves(subj string) bool {
+	_, ok := s.methods[subj]
+	return ok
+}
+
+// unknownMethodDesc returns the description of a unary method passing the call to the unknown service handler.
+// Like grpc, the handler is invoked through the stream interceptors only.
+func (s *Server) unknownMethodDesc(fullMethod string) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: fullMethod[strings.LastIndexByte(fullMethod, '/')+1:],
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+			stream := &unaryStream{ctx: ctx, dec: dec}
+			var err error
+			if s.streamInt != nil {
+				info := &grpc.StreamServerInfo{FullMethod: fullMethod, IsClientStream: true, IsServerStream: true}
+				err = s.streamInt(srv, stream, info, s.unknownHandler)
+			} else {
+				err = s.unknownHandler(srv, stream)
+			}
+			if err != nil {
+				return nil, err
+			}
+			if stream.resp == nil {
+				return nil, status.Errorf(codes.Internal, "nrpc: unknown service handler sent no response to the unary call of %s", fullMethod)
+			}
+			return stream.resp, nil
+		},
+	}
+}
+
+// unaryStream passes a unary call to the unknown service handler as a stream. It receives the request
+// as the only message and takes the first message sent as the response.
+type unaryStream struct {
+	ctx      context.Context
+	dec      func(interface{}) error
+	received bool
+	resp     interface{}
+}
+
+var _ grpc.ServerStream = (*unaryStream)(nil)
+
+func (s *unaryStream) SetHeader(md metadata.MD) error {
+	return grpc.SetHeader(s.ctx, md)
+}
+
+func (s *unaryStream) SendHeader(md metadata.MD) error {
+	return grpc.SendHeader(s.ctx, md)
+}
+
+func (s *unaryStream) SetTrailer(md metadata.MD) {
+	_ = grpc.SetTrailer(s.ctx, md)
+}
+
+func (s *unaryStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *unaryStream) SendMsg(m interface{}) error {
+	if s.resp != nil {
+		return status.Error(codes.Internal, "nrpc: unary call answered more than once")
+	}
+	s.resp = m
+	return nil
+}
+
+func (s *unaryStream) RecvMsg(m interface{}) error {
+	if s.received {
+		return io.EOF
+	}
+	s.received = true
+	return s.dec(m)
+}
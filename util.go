@@ -1,6 +1,9 @@
 package nrpc
 
-import "math/rand"
+import (
+	crand "crypto/rand"
+	"math/rand"
+)
 
 const letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
 
@@ -12,3 +15,22 @@ func randString(n int) string {
 	}
 	return string(b)
 }
+
+// secretString returns a random string of n letters that cannot be guessed, e.g. to grant access to
+// the resource it identifies.
+func secretString(n int) (string, error) {
+	b := make([]byte, 0, n)
+	buf := make([]byte, n)
+	for len(b) < n {
+		if _, err := crand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, c := range buf {
+			// bytes beyond the largest multiple of the number of letters are skipped to avoid bias
+			if int(c) < 256-256%len(letterBytes) && len(b) < n {
+				b = append(b, letterBytes[int(c)%len(letterBytes)])
+			}
+		}
+	}
+	return string(b), nil
+}
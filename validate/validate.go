@@ -0,0 +1,189 @@
+// Package validate validates the messages received by nrpc servers as interceptors. Requests of unary
+// calls and every message received on client streams are validated before they reach the handler:
+//
+//	validator := validate.New()
+//	server := nrpc.NewServer(pub, sub, validator.ServerOptions()...)
+//
+// By default, messages generated by protoc-gen-validate are validated with their ValidateAll or Validate
+// methods. Other validators like protovalidate are plugged in with WithValidator:
+//
+//	v, _ := protovalidate.New()
+//	validator := validate.New(validate.WithValidator(validate.ValidatorFunc(func(msg interface{}) error {
+//		return v.Validate(msg.(proto.Message))
+//	})))
+//
+// Invalid messages are rejected with codes.InvalidArgument. The field violations of the errors of
+// protoc-gen-validate are sent as errdetails.BadRequest. Validators returning status errors control
+// the status themselves.
+package validate
+
+import (
+	"context"
+	"errors"
+
+	"github.com/tehsphinx/nrpc"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Validator validates a received message.
+type Validator interface {
+	// Validate returns an error if the message is invalid.
+	Validate(msg interface{}) error
+}
+
+// ValidatorFunc implements the Validator interface with a function.
+type ValidatorFunc func(msg interface{}) error
+
+// Validate implements the Validator interface.
+func (f ValidatorFunc) Validate(msg interface{}) error {
+	return f(msg)
+}
+
+// Option configures the interceptors.
+type Option func(cfg *config)
+
+type config struct {
+	validator Validator
+}
+
+// WithValidator sets the validator of the messages. It replaces the validation methods generated
+// by protoc-gen-validate.
+func WithValidator(validator Validator) Option {
+	return func(cfg *config) {
+		cfg.validator = validator
+	}
+}
+
+// Interceptor validates the messages received by a server.
+type Interceptor struct {
+	cfg config
+}
+
+// New creates the validating interceptors.
+func New(opts ...Option) *Interceptor {
+	cfg := config{validator: ValidatorFunc(validateGenerated)}
+	for _, o := range opts {
+		o(&cfg)
+	}
+	return &Interceptor{cfg: cfg}
+}
+
+// ServerOptions returns the options adding the interceptors to a server.
+func (i *Interceptor) ServerOptions() []nrpc.Option {
+	return []nrpc.Option{
+		nrpc.ChainUnaryInterceptor(i.UnaryServerInterceptor()),
+		nrpc.ChainStreamInterceptor(i.StreamServerInterceptor()),
+	}
+}
+
+// UnaryServerInterceptor returns a server interceptor validating the requests of unary calls.
+func (i *Interceptor) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if r := i.validate(req); r != nil {
+			return nil, r
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor returns a server interceptor validating every message received on streams.
+// The stream ends with the error of the first invalid message.
+func (i *Interceptor) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &serverStream{ServerStream: ss, validate: i.validate})
+	}
+}
+
+// validate validates the message and converts the error into a status error.
+func (i *Interceptor) validate(msg interface{}) error {
+	err := i.cfg.validator.Validate(msg)
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	st := status.New(codes.InvalidArgument, "invalid message: "+err.Error())
+	violations := fieldViolations(err, "")
+	if len(violations) == 0 {
+		return st.Err()
+	}
+	if detailed, r := st.WithDetails(&errdetails.BadRequest{FieldViolations: violations}); r == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	validate func(msg interface{}) error
+}
+
+func (s *serverStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	return s.validate(m)
+}
+
+// validateGenerated validates messages with the methods generated by protoc-gen-validate.
+// Messages without them are valid.
+func validateGenerated(msg interface{}) error {
+	switch m := msg.(type) {
+	case interface{ ValidateAll() error }:
+		return m.ValidateAll()
+	case interface{ Validate() error }:
+		return m.Validate()
+	}
+	return nil
+}
+
+// fieldError is implemented by the validation errors generated by protoc-gen-validate.
+type fieldError interface {
+	Field() string
+	Reason() string
+}
+
+// causer is implemented by the validation errors generated by protoc-gen-validate. The cause of the error
+// of an embedded message field is the validation error of the embedded message.
+type causer interface {
+	Cause() error
+}
+
+// multiError is implemented by the errors of ValidateAll generated by protoc-gen-validate.
+type multiError interface {
+	AllErrors() []error
+}
+
+// fieldViolations returns the field violations of the validation error. Fields of embedded messages
+// are prefixed with the path of the embedding field.
+func fieldViolations(err error, prefix string) []*errdetails.BadRequest_FieldViolation {
+	var multi multiError
+	if errors.As(err, &multi) {
+		var violations []*errdetails.BadRequest_FieldViolation
+		for _, e := range multi.AllErrors() {
+			violations = append(violations, fieldViolations(e, prefix)...)
+		}
+		return violations
+	}
+
+	var fe fieldError
+	if !errors.As(err, &fe) {
+		return nil
+	}
+	field := fe.Field()
+	if prefix != "" {
+		field = prefix + "." + field
+	}
+	// errors of embedded messages are the cause of the error of the embedding field
+	if c, ok := fe.(causer); ok && c.Cause() != nil {
+		if nested := fieldViolations(c.Cause(), field); len(nested) != 0 {
+			return nested
+		}
+	}
+	return []*errdetails.BadRequest_FieldViolation{{Field: field, Description: fe.Reason()}}
+}
@@ -0,0 +1,21 @@
+package nrpc
+
+// WireVersion is the latest version of the envelope of the messages (see message.proto) this package
+// speaks. The version of a call is negotiated between the client and the server: both use the lower of
+// their versions, so peers of different releases keep understanding each other. Changes to the envelope,
+// e.g. new compression, chunking or flow control fields, increase the version and are only used on
+// calls negotiated to a version supporting them.
+//
+// Version 0 is the envelope of peers not sending a version. Version 1 adds the negotiation itself,
+// version 2 batched stream frames (see WithStreamBatching), version 3 streams sharing their
+// response subject (see WithStreamMultiplexing) and version 4 unary responses split into pages
+// (see WithResponsePaging).
+const WireVersion uint32 = 4
+
+// negotiateVersion returns the version of the envelope both sides of a call understand.
+func negotiateVersion(local, remote uint32) uint32 {
+	if remote < local {
+		return remote
+	}
+	return local
+}
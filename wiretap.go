@@ -0,0 +1,163 @@
+package nrpc
+
+import (
+	"context"
+
+	"github.com/tehsphinx/nrpc/pubsub"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+// FrameDirection tells whether a frame was sent or received.
+type FrameDirection int
+
+// The directions of frames.
+const (
+	// FrameSent marks frames published by the client or server.
+	FrameSent FrameDirection = iota
+	// FrameReceived marks frames received by the client or server.
+	FrameReceived
+)
+
+func (d FrameDirection) String() string {
+	if d == FrameReceived {
+		return "received"
+	}
+	return "sent"
+}
+
+// Frame describes a frame sent or received by a client or server.
+type Frame struct {
+	Direction FrameDirection
+	// Method is the full method (/service/method) of the call. It is empty for cancellations
+	// received by servers, as they are sent per service.
+	Method  string
+	Subject string
+	Type    FrameType
+	// Size is the size of the frame in bytes.
+	Size int
+	// Header and Trailer are the metadata carried by the frame.
+	Header  metadata.MD
+	Trailer metadata.MD
+	// Data is the frame as published. It must not be modified.
+	Data []byte
+}
+
+// WireTap receives every frame sent and received by a client or server (see WithWireTap), e.g. to
+// write audit logs or capture calls for debugging. Frames split into chunks are tapped as a whole.
+// Payload encryption is applied after the tap, so the tap sees the frames in plain text.
+type WireTap interface {
+	// TapFrame is called synchronously for every frame, so it should return quickly.
+	TapFrame(ctx context.Context, frame Frame)
+}
+
+// WireTapFunc adapts a function to the WireTap interface.
+type WireTapFunc func(ctx context.Context, frame Frame)
+
+// TapFrame implements the WireTap interface.
+func (f WireTapFunc) TapFrame(ctx context.Context, frame Frame) {
+	f(ctx, frame)
+}
+
+// wireTap passes frames to the tap if one is set. The frames are only unmarshaled to fill in
+// their type and metadata if they are tapped.
+type wireTap struct {
+	tap WireTap
+}
+
+// request taps a frame carrying a Request.
+func (t wireTap) request(ctx context.Context, frame Frame) {
+	if t.tap == nil {
+		return
+	}
+	if req, err := unmarshalReq(frame.Data); err == nil {
+		if frame.Type == "" {
+			frame.Type = reqFrame(req)
+		}
+		frame.Header = toMD(req.Header)
+	}
+	t.emit(ctx, frame)
+}
+
+// response taps a frame carrying a Response of a stream.
+func (t wireTap) response(ctx context.Context, frame Frame) {
+	if t.tap == nil {
+		return
+	}
+	if resp, err := unmarshalResp(frame.Data); err == nil {
+		if frame.Type == "" {
+			frame.Type = respFrame(resp)
+		}
+		frame.Header, frame.Trailer = toMD(resp.Header), toMD(resp.Trailer)
+	}
+	t.emit(ctx, frame)
+}
+
+// message taps a frame carrying a Message, i.e. the reply to a unary call or to the first message of a stream.
+func (t wireTap) message(ctx context.Context, frame Frame) {
+	if t.tap == nil {
+		return
+	}
+	if frame.Type == "" {
+		frame.Type = FrameData
+	}
+	var msg Message
+	if r := proto.Unmarshal(frame.Data, &msg); r == nil {
+		header, trailer := msg.Header, msg.Trailer
+		if msg.GetType() == MessageType_Error {
+			frame.Type = FrameError
+		} else if resp, err := unmarshalResp(msg.GetData()); err == nil {
+			header, trailer = resp.Header, resp.Trailer
+		}
+		frame.Header, frame.Trailer = toMD(header), toMD(trailer)
+	}
+	t.emit(ctx, frame)
+}
+
+func (t wireTap) emit(ctx context.Context, frame Frame) {
+	frame.Size = len(frame.Data)
+	t.tap.TapFrame(ctx, frame)
+}
+
+// handler taps the requests received by the handler and its replies of the given frame type.
+func (t wireTap) handler(method string, reply FrameType, handler pubsub.Handler) pubsub.Handler {
+	if t.tap == nil {
+		return handler
+	}
+	return func(ctx context.Context, msg pubsub.Replier) {
+		t.request(ctx, Frame{Direction: FrameReceived, Method: method, Subject: msg.Subject(), Data: msg.Data()})
+		handler(ctx, &tappedMsg{Replier: msg, ctx: ctx, method: method, reply: reply, tap: t})
+	}
+}
+
+// tappedMsg taps the replies to a received message.
+type tappedMsg struct {
+	pubsub.Replier
+	ctx    context.Context
+	method string
+	reply  FrameType
+	tap    wireTap
+}
+
+func (m *tappedMsg) Reply(reply pubsub.Reply) error {
+	m.tap.message(m.ctx, Frame{Direction: FrameSent, Method: m.method, Subject: m.Subject(), Type: m.reply, Data: reply.Data})
+	return m.Replier.Reply(reply)
+}
+
+// Unwrap returns the received message, e.g. to acknowledge it (see pubsub.Acknowledger).
+func (m *tappedMsg) Unwrap() pubsub.Replier {
+	return m.Replier
+}
+
+// ID implements the pubsub.Identifier interface if the received message does.
+func (m *tappedMsg) ID() string {
+	if identifier, ok := m.Replier.(pubsub.Identifier); ok {
+		return identifier.ID()
+	}
+	return ""
+}
+
+// ReplySubject implements the pubsub.ReplyAddresser interface if the received message does.
+func (m *tappedMsg) ReplySubject() string {
+	return replySubject(m.Replier)
+}